@@ -35,6 +35,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/command"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
@@ -69,6 +70,9 @@ type ProtocolActions struct {
 	ExecuteSync []string `json:"execute_sync" mapstructure:"execute_sync"`
 	// Absolute path to an external program or an HTTP URL
 	Hook string `json:"hook" mapstructure:"hook"`
+	// Secret used to sign the HTTP hook requests using HMAC-SHA256.
+	// The signature is sent in the X-SFTPGo-Signature header. Leave empty to disable
+	HookSigningSecret *kms.Secret `json:"hook_signing_secret" mapstructure:"-"`
 }
 
 var actionHandler ActionHandler = &defaultActionHandler{}
@@ -113,6 +117,9 @@ func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath str
 func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtualPath, target, virtualTarget, sshCmd string,
 	fileSize int64, err error,
 ) error {
+	if err == nil {
+		recordFsChange(conn.User.Username, operation, virtualPath, virtualTarget)
+	}
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
 	hasHook := util.Contains(Config.Actions.ExecuteOn, operation)
 	hasRules := eventManager.hasFsRules()
@@ -164,6 +171,12 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 	return errRes
 }
 
+// ExecuteUploadNotification executes the defined hook, if any, for a file
+// uploaded without using a transfer
+func ExecuteUploadNotification(conn *BaseConnection, filePath, virtualPath string, fileSize int64, err error) error {
+	return ExecuteActionNotification(conn, operationUpload, filePath, virtualPath, "", "", "", fileSize, err)
+}
+
 // ActionHandler handles a notification for a Protocol Action.
 type ActionHandler interface {
 	Handle(notification *notifier.FsEvent) error
@@ -252,7 +265,23 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 	var b bytes.Buffer
 	_ = json.NewEncoder(&b).Encode(event)
 
-	resp, err := httpclient.RetryablePost(Config.Actions.Hook, "application/json", &b)
+	var signer func(*http.Request) http.Header
+	if secret := Config.Actions.HookSigningSecret; secret != nil && !secret.IsEmpty() {
+		if err := secret.TryDecrypt(); err != nil {
+			logger.Error(event.Protocol, "", "unable to decrypt the hook signing secret: %v", err)
+			return err
+		}
+		payload := secret.GetPayload()
+		body := b.Bytes()
+		// a new nonce and timestamp are generated for each attempt
+		signer = func(req *http.Request) http.Header {
+			h := make(http.Header)
+			h.Set(httpSignatureHeader, getHTTPSignature(payload, req.Method, getHTTPSignatureURL(req.URL), body))
+			return h
+		}
+	}
+
+	resp, err := httpclient.RetryablePostWithSigner(Config.Actions.Hook, "application/json", bytes.NewReader(b.Bytes()), signer)
 	if err == nil {
 		respCode = resp.StatusCode
 		resp.Body.Close()
@@ -17,6 +17,9 @@ package common
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -26,10 +29,13 @@ import (
 	"github.com/lithammer/shortuuid/v3"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
+	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/sftpgo/sdk/plugin/notifier"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
@@ -146,6 +152,74 @@ func TestActionHTTP(t *testing.T) {
 	Config.Actions = actionsCopy
 }
 
+func TestActionHTTPSigned(t *testing.T) {
+	actionsCopy := Config.Actions
+
+	var signatureErr error
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signatures = append(signatures, r.Header.Get(httpSignatureHeader))
+		signatureErr = verifyHTTPSignature("hook secret", r.Header.Get(httpSignatureHeader), r.Method,
+			getTestRequestURL(r), body)
+		if len(signatures) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	secret := kms.NewPlainSecret("hook secret")
+	err := secret.Encrypt()
+	assert.NoError(t, err)
+	Config.Actions = ProtocolActions{
+		ExecuteOn:         []string{operationDownload},
+		Hook:              server.URL,
+		HookSigningSecret: secret,
+	}
+	user := &dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "username",
+		},
+	}
+	httpConfig := httpclient.Config{
+		Timeout:  5,
+		RetryMax: 1,
+	}
+	err = httpConfig.Initialize(configDir)
+	assert.NoError(t, err)
+
+	a := newActionNotification(user, operationDownload, "path", "vpath", "target", "", "", ProtocolSFTP, "",
+		xid.New().String(), 123, 0, nil)
+	err = actionHandler.Handle(a)
+	assert.NoError(t, err)
+	assert.NoError(t, signatureErr)
+	// the request is retried with a new signature
+	if assert.Len(t, signatures, 2) {
+		assert.NotEqual(t, signatures[0], signatures[1])
+	}
+
+	httpConfig.RetryMax = 0
+	err = httpConfig.Initialize(configDir)
+	assert.NoError(t, err)
+
+	Config.Actions.HookSigningSecret = kms.NewEmptySecret()
+	err = actionHandler.Handle(a)
+	assert.NoError(t, err)
+	assert.Error(t, signatureErr)
+
+	Config.Actions.HookSigningSecret = kms.NewSecret(sdkkms.SecretStatusSecretBox, "invalid", "", "")
+	err = actionHandler.Handle(a)
+	assert.Error(t, err)
+
+	Config.Actions = actionsCopy
+}
+
 func TestActionCMD(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -0,0 +1,225 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	syncTokenPrefix = "urn:sftpgo:sync:"
+)
+
+var (
+	// ErrInvalidSyncToken is returned if a sync token is unknown or if the
+	// changes after the token are no longer available
+	ErrInvalidSyncToken = errors.New("invalid sync token")
+	// ChangeJournalMaxEntries defines the maximum number of changes retained
+	// for each user. Clients using older sync tokens must do a full sync
+	ChangeJournalMaxEntries = 10000
+	// ChangeJournalIdleTimeout defines how long the changes are recorded for a
+	// user after the last sync token request
+	ChangeJournalIdleTimeout     = 24 * time.Hour
+	changeJournal                = newChangesJournal()
+	changeJournalCleanupInterval = 30 * time.Minute
+)
+
+// FsChange defines a change to a virtual path
+type FsChange struct {
+	// VirtualPath is the changed path
+	VirtualPath string
+	// Deleted is true if the path no longer exists
+	Deleted bool
+	// Recursive is true if the path contents changed too, for example
+	// a directory renamed to VirtualPath
+	Recursive bool
+}
+
+type journalEntry struct {
+	seq    int64
+	change FsChange
+}
+
+type userJournal struct {
+	entries []journalEntry
+	// first sequence number still available, a token with a lower sequence
+	// number cannot be used to get the changes
+	firstSeq int64
+	lastSeq  int64
+	lastUse  time.Time
+}
+
+// changesJournal records the filesystem changes for the users that requested
+// at least one sync token. The journal is in memory, the journal ID is included
+// in the sync tokens so tokens issued before a restart or by other instances
+// are rejected
+type changesJournal struct {
+	sync.Mutex
+	id    string
+	users map[string]*userJournal
+}
+
+func newChangesJournal() *changesJournal {
+	return &changesJournal{
+		id:    util.GenerateUniqueID(),
+		users: make(map[string]*userJournal),
+	}
+}
+
+func (j *changesJournal) getToken(seq int64) string {
+	return fmt.Sprintf("%s%s:%d", syncTokenPrefix, j.id, seq)
+}
+
+func (j *changesJournal) parseToken(token string) (int64, error) {
+	val := strings.TrimPrefix(token, syncTokenPrefix)
+	if val == token {
+		return 0, ErrInvalidSyncToken
+	}
+	id, seq, ok := strings.Cut(val, ":")
+	if !ok || id != j.id {
+		return 0, ErrInvalidSyncToken
+	}
+	res, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || res < 0 {
+		return 0, ErrInvalidSyncToken
+	}
+	return res, nil
+}
+
+func (j *changesJournal) getSyncToken(username string) string {
+	j.Lock()
+	defer j.Unlock()
+
+	journal, ok := j.users[username]
+	if !ok {
+		journal = &userJournal{
+			firstSeq: 1,
+		}
+		j.users[username] = journal
+	}
+	journal.lastUse = time.Now()
+	return j.getToken(journal.lastSeq)
+}
+
+func (j *changesJournal) getChanges(username, token string) ([]FsChange, string, error) {
+	seq, err := j.parseToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	journal, ok := j.users[username]
+	if !ok || seq > journal.lastSeq || seq+1 < journal.firstSeq {
+		return nil, "", ErrInvalidSyncToken
+	}
+	journal.lastUse = time.Now()
+	var changes []FsChange
+	for _, entry := range journal.entries {
+		if entry.seq > seq {
+			changes = append(changes, entry.change)
+		}
+	}
+	return changes, j.getToken(journal.lastSeq), nil
+}
+
+func (j *changesJournal) add(username string, changes ...FsChange) {
+	j.Lock()
+	defer j.Unlock()
+
+	journal, ok := j.users[username]
+	if !ok {
+		return
+	}
+	for _, change := range changes {
+		journal.lastSeq++
+		journal.entries = append(journal.entries, journalEntry{
+			seq:    journal.lastSeq,
+			change: change,
+		})
+	}
+	if len(journal.entries) > ChangeJournalMaxEntries {
+		journal.entries = journal.entries[len(journal.entries)-ChangeJournalMaxEntries:]
+		journal.firstSeq = journal.entries[0].seq
+	}
+}
+
+func (j *changesJournal) remove(username string) {
+	j.Lock()
+	defer j.Unlock()
+
+	delete(j.users, username)
+}
+
+func (j *changesJournal) cleanup() {
+	j.Lock()
+	defer j.Unlock()
+
+	for username, journal := range j.users {
+		if time.Since(journal.lastUse) > ChangeJournalIdleTimeout {
+			logger.Debug(logSender, "", "removing idle changes journal for user %q", username)
+			delete(j.users, username)
+		}
+	}
+}
+
+// GetSyncToken returns a token representing the current state of the user's
+// files. The changes after this token can be obtained using GetChangesSince.
+// The changes are recorded only for users that requested a sync token
+func GetSyncToken(username string) string {
+	return changeJournal.getSyncToken(username)
+}
+
+// GetChangesSince returns the changes recorded after the specified sync token
+// and the sync token to use for the next request. ErrInvalidSyncToken is
+// returned if the token is unknown or too old
+func GetChangesSince(username, token string) ([]FsChange, string, error) {
+	return changeJournal.getChanges(username, token)
+}
+
+// RemoveChangesJournal removes the recorded changes for the specified user
+func RemoveChangesJournal(username string) {
+	changeJournal.remove(username)
+}
+
+func cleanupChangesJournal() {
+	changeJournal.cleanup()
+}
+
+// recordFsChange adds the changes caused by the specified operation to the user's journal
+func recordFsChange(username, operation, virtualPath, virtualTargetPath string) {
+	switch operation {
+	case operationUpload, operationMkdir:
+		changeJournal.add(username, FsChange{VirtualPath: path.Clean(virtualPath)})
+	case OperationSSHCmd:
+		// we don't know what the command changed
+		changeJournal.add(username, FsChange{VirtualPath: path.Clean(virtualPath), Recursive: true})
+	case operationDelete, operationRmdir:
+		changeJournal.add(username, FsChange{VirtualPath: path.Clean(virtualPath), Deleted: true})
+	case operationRename:
+		changeJournal.add(username, FsChange{VirtualPath: path.Clean(virtualPath), Deleted: true},
+			FsChange{VirtualPath: path.Clean(virtualTargetPath), Recursive: true})
+	}
+}
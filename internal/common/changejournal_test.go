@@ -0,0 +1,108 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+func TestChangesJournal(t *testing.T) {
+	oldJournal := changeJournal
+	oldMaxEntries := ChangeJournalMaxEntries
+	changeJournal = newChangesJournal()
+	defer func() {
+		changeJournal = oldJournal
+		ChangeJournalMaxEntries = oldMaxEntries
+	}()
+
+	username := "journal_user"
+	// changes are not recorded before the first sync token request
+	recordFsChange(username, operationUpload, "/file", "")
+	token := GetSyncToken(username)
+	changes, newToken, err := GetChangesSince(username, token)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 0)
+	assert.Equal(t, token, newToken)
+
+	recordFsChange(username, operationUpload, "/dir/../file", "")
+	recordFsChange(username, operationMkdir, "/dir", "")
+	recordFsChange(username, operationDelete, "/file1", "")
+	recordFsChange(username, operationRename, "/dir", "/dir1")
+	recordFsChange(username, OperationSSHCmd, "/", "")
+	recordFsChange(username, operationPreDelete, "/file2", "")
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+		},
+	})
+	// failed operations are not recorded
+	ExecuteActionNotification(conn, operationUpload, "", "/file6", "", "", "", 0, errors.New("upload error")) //nolint:errcheck
+	ExecuteActionNotification(conn, operationUpload, "", "/file7", "", "", "", 0, nil)                        //nolint:errcheck
+	changes, newToken, err = GetChangesSince(username, token)
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, newToken)
+	assert.Equal(t, []FsChange{
+		{VirtualPath: "/file"},
+		{VirtualPath: "/dir"},
+		{VirtualPath: "/file1", Deleted: true},
+		{VirtualPath: "/dir", Deleted: true},
+		{VirtualPath: "/dir1", Recursive: true},
+		{VirtualPath: "/", Recursive: true},
+		{VirtualPath: "/file7"},
+	}, changes)
+	changes, _, err = GetChangesSince(username, newToken)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 0)
+	// other users and invalid tokens
+	_, _, err = GetChangesSince("missing", token)
+	assert.ErrorIs(t, err, ErrInvalidSyncToken)
+	for _, val := range []string{"", "invalid", syncTokenPrefix + "id:1", syncTokenPrefix + changeJournal.id,
+		syncTokenPrefix + changeJournal.id + ":a", syncTokenPrefix + changeJournal.id + ":-1",
+		syncTokenPrefix + changeJournal.id + ":100"} {
+		_, _, err = GetChangesSince(username, val)
+		assert.ErrorIs(t, err, ErrInvalidSyncToken, val)
+	}
+	// old changes are discarded
+	ChangeJournalMaxEntries = 2
+	recordFsChange(username, operationUpload, "/file3", "")
+	_, _, err = GetChangesSince(username, token)
+	assert.ErrorIs(t, err, ErrInvalidSyncToken)
+	changes, _, err = GetChangesSince(username, newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []FsChange{{VirtualPath: "/file3"}}, changes)
+	token = GetSyncToken(username)
+	recordFsChange(username, operationUpload, "/file4", "")
+	recordFsChange(username, operationUpload, "/file5", "")
+	changes, _, err = GetChangesSince(username, token)
+	assert.NoError(t, err)
+	assert.Equal(t, []FsChange{{VirtualPath: "/file4"}, {VirtualPath: "/file5"}}, changes)
+
+	cleanupChangesJournal()
+	assert.Len(t, changeJournal.users, 1)
+	changeJournal.users[username].lastUse = time.Now().Add(-ChangeJournalIdleTimeout - time.Minute)
+	cleanupChangesJournal()
+	assert.Len(t, changeJournal.users, 0)
+	GetSyncToken(username)
+	assert.Len(t, changeJournal.users, 1)
+	RemoveChangesJournal(username)
+	assert.Len(t, changeJournal.users, 0)
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// ChunkedUploadExpiration defines the time after which a WebDAV chunked upload
+	// with no new chunks is considered abandoned and removed
+	ChunkedUploadExpiration       = 24 * time.Hour
+	chunkedUploadsCleanupInterval = 1 * time.Hour
+	chunkedUploads                = chunkedUploadsTracker{
+		users: make(map[string]time.Time),
+	}
+)
+
+// chunkedUploadsTracker keeps track of the users with pending chunked uploads
+// on this instance, the value is the last time an upload was started or updated
+type chunkedUploadsTracker struct {
+	sync.RWMutex
+	users map[string]time.Time
+}
+
+func (t *chunkedUploadsTracker) add(username string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.users[username] = time.Now()
+}
+
+// remove removes the specified user if there was no upload activity after the given time
+func (t *chunkedUploadsTracker) remove(username string, before time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	if lastActivity, ok := t.users[username]; ok && !lastActivity.After(before) {
+		delete(t.users, username)
+	}
+}
+
+func (t *chunkedUploadsTracker) getUsers() []string {
+	t.RLock()
+	defer t.RUnlock()
+
+	users := make([]string, 0, len(t.users))
+	for username := range t.users {
+		users = append(users, username)
+	}
+	return users
+}
+
+// AddChunkedUploadsUser registers a user with pending WebDAV chunked uploads,
+// the expired uploads for the registered users are periodically removed
+func AddChunkedUploadsUser(username string) {
+	chunkedUploads.add(username)
+}
+
+// RemoveChunkedUploadDir removes a chunked upload directory and the chunks inside it
+func RemoveChunkedUploadDir(fs vfs.Fs, dir, connectionID string) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to list chunked upload dir %q: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if err := fs.Remove(fs.Join(dir, entry.Name()), entry.IsDir()); err != nil {
+			logger.Warn(logSender, connectionID, "unable to remove chunk %q: %v", entry.Name(), err)
+		}
+	}
+	if err := fs.Remove(dir, true); err != nil {
+		logger.Warn(logSender, connectionID, "unable to remove chunked upload dir %q: %v", dir, err)
+	}
+}
+
+func cleanupChunkedUploads() {
+	for _, username := range chunkedUploads.getUsers() {
+		start := time.Now()
+		if removeExpiredChunkedUploads(username) {
+			chunkedUploads.remove(username, start)
+		}
+	}
+}
+
+// removeExpiredChunkedUploads removes the chunked uploads not updated within the
+// expiration time for the specified user. It returns true if no upload is pending
+func removeExpiredChunkedUploads(username string) bool {
+	user, err := dataprovider.GetUserWithGroupSettings(username, "")
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return true
+		}
+		logger.Warn(logSender, "", "unable to get user %q to remove expired chunked uploads: %v", username, err)
+		return false
+	}
+	connectionID := fmt.Sprintf("chunked_uploads_%s", xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to check root fs for user %q: %v", username, err)
+		return false
+	}
+	fs, err := user.GetFilesystemForPath("/", connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get fs for user %q: %v", username, err)
+		return false
+	}
+	rootDir, err := fs.ResolvePath("/" + vfs.ChunkedUploadsDirName)
+	if err != nil {
+		return false
+	}
+	entries, err := fs.ReadDir(rootDir)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return true
+		}
+		logger.Warn(logSender, connectionID, "unable to list chunked uploads for user %q: %v", username, err)
+		return false
+	}
+	pending := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		uploadDir := fs.Join(rootDir, entry.Name())
+		if time.Since(getChunkedUploadModTime(fs, uploadDir, entry.ModTime())) < ChunkedUploadExpiration {
+			pending++
+			continue
+		}
+		logger.Debug(logSender, connectionID, "removing expired chunked upload %q for user %q", entry.Name(), username)
+		RemoveChunkedUploadDir(fs, uploadDir, connectionID)
+	}
+	return pending == 0
+}
+
+// getChunkedUploadModTime returns the most recent modification time for the
+// specified upload directory and the chunks inside it. Some storage backends
+// have no modification time for directories
+func getChunkedUploadModTime(fs vfs.Fs, uploadDir string, modTime time.Time) time.Time {
+	entries, err := fs.ReadDir(uploadDir)
+	if err != nil {
+		return modTime
+	}
+	for _, entry := range entries {
+		if entry.ModTime().After(modTime) {
+			modTime = entry.ModTime()
+		}
+	}
+	return modTime
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestChunkedUploadsCleanup(t *testing.T) {
+	username := "chunked_uploads_user"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: "test_pwd",
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+
+	uploadsDir := filepath.Join(user.HomeDir, vfs.ChunkedUploadsDirName)
+	expired := time.Now().Add(-2 * ChunkedUploadExpiration)
+	for _, name := range []string{"upload1", "upload2"} {
+		err = os.MkdirAll(filepath.Join(uploadsDir, name), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(uploadsDir, name, "1"), []byte("chunk"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	err = os.Chtimes(filepath.Join(uploadsDir, "upload1", "1"), expired, expired)
+	assert.NoError(t, err)
+	err = os.Chtimes(filepath.Join(uploadsDir, "upload1"), expired, expired)
+	assert.NoError(t, err)
+	// the upload directory is old but a chunk was recently written
+	err = os.Chtimes(filepath.Join(uploadsDir, "upload2"), expired, expired)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.HomeDir, "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	// the chunks are not included in the quota
+	numFiles, size, err := user.ScanQuota()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(4), size)
+
+	AddChunkedUploadsUser(username)
+	AddChunkedUploadsUser("missing_chunked_uploads_user")
+	assert.Contains(t, chunkedUploads.getUsers(), "missing_chunked_uploads_user")
+	cleanupChunkedUploads()
+	assert.NoDirExists(t, filepath.Join(uploadsDir, "upload1"))
+	assert.DirExists(t, filepath.Join(uploadsDir, "upload2"))
+	assert.Contains(t, chunkedUploads.getUsers(), username)
+	assert.NotContains(t, chunkedUploads.getUsers(), "missing_chunked_uploads_user")
+
+	err = os.Chtimes(filepath.Join(uploadsDir, "upload2", "1"), expired, expired)
+	assert.NoError(t, err)
+	cleanupChunkedUploads()
+	assert.NoDirExists(t, filepath.Join(uploadsDir, "upload2"))
+	assert.NotContains(t, chunkedUploads.getUsers(), username)
+	// users with new uploads activity are not removed
+	AddChunkedUploadsUser(username)
+	chunkedUploads.remove(username, time.Now().Add(-time.Minute))
+	assert.Contains(t, chunkedUploads.getUsers(), username)
+	chunkedUploads.remove(username, time.Now())
+	assert.NotContains(t, chunkedUploads.getUsers(), username)
+
+	conn := NewBaseConnection("", ProtocolFTP, "", "", user)
+	_, _, err = conn.GetFsAndResolvedPath("/" + vfs.ChunkedUploadsDirName)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	err = conn.CreateSymlink("/file", "/"+vfs.ChunkedUploadsDirName+"/link")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	entries, err := conn.ListDir("/")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "file", entries[0].Name())
+	}
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.HomeDir)
+	assert.NoError(t, err)
+}
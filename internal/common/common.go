@@ -52,6 +52,8 @@ const (
 	rmdirLogSender         = "Rmdir"
 	mkdirLogSender         = "Mkdir"
 	symlinkLogSender       = "Symlink"
+	hardlinkLogSender      = "Hardlink"
+	copyLogSender          = "Copy"
 	removeLogSender        = "Remove"
 	chownLogSender         = "Chown"
 	chmodLogSender         = "Chmod"
@@ -70,6 +72,7 @@ const (
 	operationRename    = "rename"
 	operationMkdir     = "mkdir"
 	operationRmdir     = "rmdir"
+	operationTransfer  = "transfer"
 	// SSH command action name
 	OperationSSHCmd              = "ssh_cmd"
 	chtimesFormat                = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
@@ -153,6 +156,9 @@ var (
 	supportedProtocols   = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
 		ProtocolHTTP, ProtocolHTTPShare, ProtocolOIDC}
 	disconnHookProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP}
+	// internal shares are exposed as virtual folders for these protocols
+	internalSharesProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
+		ProtocolHTTP, ProtocolOIDC}
 	// the map key is the protocol, for each protocol we can have multiple rate limiters
 	rateLimiters   map[string][]*rateLimiter
 	isShuttingDown atomic.Bool
@@ -220,6 +226,7 @@ func Initialize(c Configuration, isShared int) error {
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	transfersChecker = getTransfersChecker(isShared)
+	webDAVLocks = newWebDAVLockStore(isShared)
 	return nil
 }
 
@@ -394,6 +401,18 @@ func startPeriodicChecks(duration time.Duration) {
 		util.PanicOnError(err)
 		logger.Info(logSender, "", "scheduled idle connections check, schedule %q", spec)
 	}
+	spec = fmt.Sprintf("@every %s", webDAVLocksCleanupInterval)
+	_, err = eventScheduler.AddFunc(spec, cleanupWebDAVLocks)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled WebDAV locks cleanup, schedule %q", spec)
+	spec = fmt.Sprintf("@every %s", changeJournalCleanupInterval)
+	_, err = eventScheduler.AddFunc(spec, cleanupChangesJournal)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled changes journal cleanup, schedule %q", spec)
+	spec = fmt.Sprintf("@every %s", chunkedUploadsCleanupInterval)
+	_, err = eventScheduler.AddFunc(spec, cleanupChunkedUploads)
+	util.PanicOnError(err)
+	logger.Info(logSender, "", "scheduled chunked uploads cleanup, schedule %q", spec)
 }
 
 // ActiveTransfer defines the interface for the current active transfers
@@ -576,6 +595,9 @@ type Configuration struct {
 	// Allow users on this instance to use other users/virtual folders on this instance as storage backend.
 	// Enable this setting if you know what you are doing.
 	AllowSelfConnections int `json:"allow_self_connections" mapstructure:"allow_self_connections"`
+	// Set to 1 to prevent SFTP, SCP, FTP and HTTP clients from modifying files and directories
+	// locked by WebDAV clients
+	EnforceWebDAVLocks int `json:"enforce_webdav_locks" mapstructure:"enforce_webdav_locks"`
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
 	// Rate limiter configurations
@@ -17,6 +17,7 @@ package common
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
@@ -64,6 +65,11 @@ func NewBaseConnection(id, protocol, localAddr, remoteAddr string, user dataprov
 		connID = fmt.Sprintf("%s_%s", protocol, id)
 	}
 	user.UploadBandwidth, user.DownloadBandwidth = user.GetBandwidthForIP(util.GetIPFromRemoteAddress(remoteAddr), connID)
+	if user.Username != "" && util.Contains(internalSharesProtocols, protocol) {
+		if err := user.LoadAndApplyInternalShares(connID); err != nil {
+			logger.Warn(protocol, connID, "unable to apply internal shares for user %q: %v", user.Username, err)
+		}
+	}
 	c := &BaseConnection{
 		ID:         connID,
 		User:       user,
@@ -346,6 +352,9 @@ func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) e
 	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(virtualPath)) {
 		return c.GetPermissionDeniedError()
 	}
+	if err := c.CheckWebDAVLocks(virtualPath, false); err != nil {
+		return err
+	}
 	if checkFilePatterns {
 		if ok, _ := c.User.IsFileAllowed(virtualPath); !ok {
 			return c.GetPermissionDeniedError()
@@ -371,6 +380,26 @@ func (c *BaseConnection) CreateDir(virtualPath string, checkFilePatterns bool) e
 	return nil
 }
 
+// CheckWebDAVLocks returns an error if the specified virtual path is locked by a
+// WebDAV client and the WebDAV locks enforcement is enabled. If checkChildren is
+// true the locks for the path contents are checked too.
+// WebDAV connections are not checked, the WebDAV handler already confirms the locks
+func (c *BaseConnection) CheckWebDAVLocks(virtualPath string, checkChildren bool) error {
+	if Config.EnforceWebDAVLocks != 1 || c.protocol == ProtocolWebDAV {
+		return nil
+	}
+	var fsName, fsPath string
+	if fs, p, err := c.GetFsAndResolvedPath(virtualPath); err == nil {
+		fsName = fs.Name()
+		fsPath = p
+	}
+	if isWebDAVLocked(c.User.Username, virtualPath, fsName, fsPath, checkChildren) {
+		c.Log(logger.LevelInfo, "path %q is locked by a WebDAV client, operation denied", virtualPath)
+		return fmt.Errorf("%w: %v", c.GetPermissionDeniedError(), ErrWebDAVLocked)
+	}
+	return nil
+}
+
 // IsRemoveFileAllowed returns an error if removing this file is not allowed
 func (c *BaseConnection) IsRemoveFileAllowed(virtualPath string) error {
 	if !c.User.HasAnyPerm([]string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}, path.Dir(virtualPath)) {
@@ -380,7 +409,7 @@ func (c *BaseConnection) IsRemoveFileAllowed(virtualPath string) error {
 		c.Log(logger.LevelDebug, "removing file %#v is not allowed", virtualPath)
 		return c.GetErrorForDeniedFile(policy)
 	}
-	return nil
+	return c.CheckWebDAVLocks(virtualPath, false)
 }
 
 // RemoveFile removes a file at the specified fsPath
@@ -402,6 +431,7 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr)
+	c.removeWebDAVDeadProps(fs, fsPath, virtualPath)
 	if info.Mode()&os.ModeSymlink == 0 {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
@@ -444,7 +474,7 @@ func (c *BaseConnection) IsRemoveDirAllowed(fs vfs.Fs, fsPath, virtualPath strin
 		c.Log(logger.LevelDebug, "removing directory %#v is not allowed", virtualPath)
 		return c.GetErrorForDeniedFile(policy)
 	}
-	return nil
+	return c.CheckWebDAVLocks(virtualPath, true)
 }
 
 // RemoveDir removes a directory at the specified fsPath
@@ -478,6 +508,7 @@ func (c *BaseConnection) RemoveDir(virtualPath string) error {
 
 	logger.CommandLog(rmdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr)
+	c.removeWebDAVDeadProps(fs, fsPath, virtualPath)
 	ExecuteActionNotification(c, operationRmdir, fsPath, virtualPath, "", "", "", 0, nil) //nolint:errcheck
 	return nil
 }
@@ -613,6 +644,12 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 	if virtualSourcePath == virtualTargetPath {
 		return fmt.Errorf("the rename source and target cannot be the same: %w", c.GetOpUnsupportedError())
 	}
+	if err := c.CheckWebDAVLocks(virtualSourcePath, true); err != nil {
+		return err
+	}
+	if err := c.CheckWebDAVLocks(virtualTargetPath, true); err != nil {
+		return err
+	}
 	fsSrc, fsSourcePath, err := c.GetFsAndResolvedPath(virtualSourcePath)
 	if err != nil {
 		return err
@@ -667,6 +704,7 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 	}
 	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize) //nolint:errcheck
+	c.moveWebDAVDeadProps(fsDst, fsTargetPath, virtualSourcePath, virtualTargetPath)
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 		"", "", "", -1, c.localAddr, c.remoteAddr)
 	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, //nolint:errcheck
@@ -688,11 +726,17 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 		c.Log(logger.LevelWarn, "cross folder symlink is not supported, src: %v dst: %v", virtualSourcePath, virtualTargetPath)
 		return c.GetOpUnsupportedError()
 	}
+	if err := c.CheckWebDAVLocks(virtualTargetPath, false); err != nil {
+		return err
+	}
 	// we cannot have a cross folder request here so only one fs is enough
 	fs, fsSourcePath, err := c.GetFsAndResolvedPath(virtualSourcePath)
 	if err != nil {
 		return err
 	}
+	if err := c.checkChunkedUploadsPath(virtualTargetPath); err != nil {
+		return err
+	}
 	fsTargetPath, err := fs.ResolvePath(virtualTargetPath)
 	if err != nil {
 		return c.GetFsError(fs, err)
@@ -726,9 +770,221 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 	}
 	logger.CommandLog(symlinkLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "",
 		"", "", -1, c.localAddr, c.remoteAddr)
+	changeJournal.add(c.User.Username, FsChange{VirtualPath: path.Clean(virtualTargetPath)})
+	return nil
+}
+
+// CreateHardlink creates virtualTargetPath as a hard link to virtualSourcePath.
+// Hard links are accounted in quota as new files and notified as uploads, so
+// they are recorded in the changes journal as new files too
+func (c *BaseConnection) CreateHardlink(virtualSourcePath, virtualTargetPath string) error {
+	if c.isCrossFoldersRequest(virtualSourcePath, virtualTargetPath) {
+		c.Log(logger.LevelWarn, "cross folder hard link is not supported, src: %q dst: %q",
+			virtualSourcePath, virtualTargetPath)
+		return c.GetOpUnsupportedError()
+	}
+	if err := c.CheckWebDAVLocks(virtualTargetPath, false); err != nil {
+		return err
+	}
+	// we cannot have a cross folder request here so only one fs is enough
+	fs, fsSourcePath, err := c.GetFsAndResolvedPath(virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	linker, ok := fs.(vfs.FsHardlinker)
+	if !ok {
+		c.Log(logger.LevelDebug, "hard links are not supported for fs %q", fs.Name())
+		return c.GetOpUnsupportedError()
+	}
+	if err := c.checkChunkedUploadsPath(virtualTargetPath); err != nil {
+		return err
+	}
+	fsTargetPath, err := fs.ResolvePath(virtualTargetPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	// a hard link gives access to the source file contents from the target path
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualSourcePath)) ||
+		!c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualTargetPath)) {
+		return c.GetPermissionDeniedError()
+	}
+	ok, policy := c.User.IsFileAllowed(virtualSourcePath)
+	if !ok {
+		c.Log(logger.LevelError, "hard link source path %q is not allowed", virtualSourcePath)
+		return c.GetErrorForDeniedFile(policy)
+	}
+	if ok, _ = c.User.IsFileAllowed(virtualTargetPath); !ok {
+		c.Log(logger.LevelError, "hard link target path %q is not allowed", virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
+	info, err := fs.Lstat(fsSourcePath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, "hard link source %q is not a regular file", virtualSourcePath)
+		return c.GetOpUnsupportedError()
+	}
+	quotaResult, _ := c.HasSpace(true, false, virtualTargetPath)
+	if !quotaResult.HasSpace || (quotaResult.QuotaSize > 0 && quotaResult.GetRemainingSize() < info.Size()) {
+		c.Log(logger.LevelDebug, "hard link not allowed, quota exceeded for target path %q", virtualTargetPath)
+		return c.GetQuotaExceededError()
+	}
+	if err := linker.Link(fsSourcePath, fsTargetPath); err != nil {
+		c.Log(logger.LevelError, "failed to create hard link %q -> %q: %+v", fsSourcePath, fsTargetPath, err)
+		return c.GetFsError(fs, err)
+	}
+	c.updateQuotaAfterHardlink(virtualTargetPath, info.Size())
+	logger.CommandLog(hardlinkLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "",
+		"", "", -1, c.localAddr, c.remoteAddr)
+	ExecuteActionNotification(c, operationUpload, fsTargetPath, virtualTargetPath, "", "", "", //nolint:errcheck
+		info.Size(), nil)
+	return nil
+}
+
+// Copy copies virtualSourcePath to virtualTargetPath, directories are copied recursively.
+// Files are read and written using the filesystems configured for the source and target
+// paths, so copying works for any storage backend and across virtual folders
+func (c *BaseConnection) Copy(virtualSourcePath, virtualTargetPath string) error {
+	if virtualSourcePath == virtualTargetPath {
+		return fmt.Errorf("the copy source and target cannot be the same: %w", c.GetOpUnsupportedError())
+	}
+	if err := c.CheckWebDAVLocks(virtualTargetPath, true); err != nil {
+		return err
+	}
+	srcInfo, err := c.DoStat(virtualSourcePath, 1, true)
+	if err != nil {
+		return err
+	}
+	if srcInfo.IsDir() && strings.HasPrefix(virtualTargetPath, virtualSourcePath+"/") {
+		c.Log(logger.LevelDebug, "cannot copy directory %q inside itself: %q", virtualSourcePath, virtualTargetPath)
+		return fmt.Errorf("cannot copy a directory inside itself: %w", c.GetOpUnsupportedError())
+	}
+	return c.copyPath(virtualSourcePath, virtualTargetPath, srcInfo)
+}
+
+func (c *BaseConnection) copyPath(virtualSourcePath, virtualTargetPath string, srcInfo os.FileInfo) error {
+	if err := CheckClosing(); err != nil {
+		return err
+	}
+	if srcInfo.IsDir() {
+		return c.copyDir(virtualSourcePath, virtualTargetPath)
+	}
+	if !srcInfo.Mode().IsRegular() {
+		c.Log(logger.LevelDebug, "skipping copy for non regular file %q", virtualSourcePath)
+		return nil
+	}
+	return c.copyFile(virtualSourcePath, virtualTargetPath, srcInfo.Size())
+}
+
+func (c *BaseConnection) copyDir(virtualSourcePath, virtualTargetPath string) error {
+	contents, err := c.ListDir(virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := c.DoStat(virtualTargetPath, 0, false)
+	if err == nil && !dstInfo.IsDir() {
+		c.Log(logger.LevelDebug, "cannot copy directory %q over the existing file %q", virtualSourcePath,
+			virtualTargetPath)
+		return c.GetOpUnsupportedError()
+	}
+	if err != nil {
+		if !c.IsNotExistError(err) {
+			return err
+		}
+		if err := c.CreateDir(virtualTargetPath, true); err != nil {
+			return err
+		}
+	}
+	for _, info := range contents {
+		if err := c.copyPath(path.Join(virtualSourcePath, info.Name()), path.Join(virtualTargetPath, info.Name()),
+			info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BaseConnection) copyFile(virtualSourcePath, virtualTargetPath string, size int64) error {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualSourcePath)) {
+		return c.GetPermissionDeniedError()
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualSourcePath); !ok {
+		c.Log(logger.LevelDebug, "copy source path %q is not allowed", virtualSourcePath)
+		return c.GetErrorForDeniedFile(policy)
+	}
+	if ok, _ := c.User.IsFileAllowed(virtualTargetPath); !ok {
+		c.Log(logger.LevelDebug, "copy target path %q is not allowed", virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
+	dstInfo, err := c.DoStat(virtualTargetPath, 1, false)
+	if err == nil {
+		if !dstInfo.Mode().IsRegular() {
+			c.Log(logger.LevelDebug, "cannot copy %q overwriting the non regular file %q", virtualSourcePath,
+				virtualTargetPath)
+			return c.GetOpUnsupportedError()
+		}
+		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualTargetPath)) {
+			return c.GetPermissionDeniedError()
+		}
+	} else {
+		if !c.IsNotExistError(err) {
+			return err
+		}
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualTargetPath)) {
+			return c.GetPermissionDeniedError()
+		}
+	}
+	quotaResult, _ := c.HasSpace(dstInfo == nil, false, virtualTargetPath)
+	if !quotaResult.HasSpace || (quotaResult.QuotaSize > 0 && quotaResult.GetRemainingSize() < size) {
+		c.Log(logger.LevelDebug, "copy not allowed, quota exceeded for target path %q", virtualTargetPath)
+		return c.GetQuotaExceededError()
+	}
+	reader, cancelReader, err := getFileReader(c, virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	defer cancelReader()
+	defer reader.Close()
+
+	writer, numFiles, truncatedSize, cancelWriter, err := getFileWriter(c, virtualTargetPath)
+	if err != nil {
+		return err
+	}
+	defer cancelWriter()
+
+	_, err = io.Copy(writer, reader)
+	errClose := closeWriterAndUpdateQuota(writer, c, virtualTargetPath, numFiles, truncatedSize, err)
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		c.Log(logger.LevelError, "failed to copy %q -> %q: %+v", virtualSourcePath, virtualTargetPath, err)
+		return c.GetGenericError(err)
+	}
+	_, fsSourcePath, _ := c.GetFsAndResolvedPath(virtualSourcePath)
+	_, fsTargetPath, _ := c.GetFsAndResolvedPath(virtualTargetPath)
+	logger.CommandLog(copyLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol,
+		-1, -1, "", "", "", size, c.localAddr, c.remoteAddr)
 	return nil
 }
 
+func (c *BaseConnection) updateQuotaAfterHardlink(virtualTargetPath string, size int64) {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return
+	}
+	// quota scans count each hard link as a separate file, we do the same here
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualTargetPath))
+	if err == nil {
+		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 1, size, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+		}
+		return
+	}
+	dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+}
+
 func (c *BaseConnection) getPathForSetStatPerms(fs vfs.Fs, fsPath, virtualPath string) string {
 	pathForPerms := virtualPath
 	if fi, err := fs.Lstat(fsPath); err == nil {
@@ -862,6 +1118,9 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
 		return c.GetErrorForDeniedFile(policy)
 	}
+	if err := c.CheckWebDAVLocks(virtualPath, false); err != nil {
+		return err
+	}
 	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return err
@@ -898,6 +1157,7 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 		logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "",
 			"", attributes.Size, c.localAddr, c.remoteAddr)
 	}
+	changeJournal.add(c.User.Username, FsChange{VirtualPath: path.Clean(virtualPath)})
 
 	return nil
 }
@@ -1211,7 +1471,11 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 		}
 		result.QuotaSize = vfolder.QuotaSize
 		result.QuotaFiles = vfolder.QuotaFiles
-		result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedVirtualFolderQuota(vfolder.Name)
+		if vfolder.SharedBy != "" {
+			result.UsedFiles, result.UsedSize, _, _, err = dataprovider.GetUsedQuota(vfolder.SharedBy)
+		} else {
+			result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedVirtualFolderQuota(vfolder.Name)
+		}
 	} else {
 		if c.User.HasNoQuotaRestrictions(checkFiles) && !getUsage {
 			return result, transferQuota
@@ -1533,8 +1797,21 @@ func (c *BaseConnection) GetFsError(fs vfs.Fs, err error) error {
 	return nil
 }
 
+// checkChunkedUploadsPath denies the direct access to the directory where
+// the WebDAV chunked uploads are stored until they are assembled
+func (c *BaseConnection) checkChunkedUploadsPath(virtualPath string) error {
+	if vfs.IsChunkedUploadsPath(virtualPath) {
+		c.Log(logger.LevelWarn, "access to the chunked uploads path %q is not allowed", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
+	return nil
+}
+
 // GetFsAndResolvedPath returns the fs and the fs path matching virtualPath
 func (c *BaseConnection) GetFsAndResolvedPath(virtualPath string) (vfs.Fs, string, error) {
+	if err := c.checkChunkedUploadsPath(virtualPath); err != nil {
+		return nil, "", err
+	}
 	fs, err := c.User.GetFilesystemForPath(virtualPath, c.ID)
 	if err != nil {
 		if c.protocol == ProtocolWebDAV && strings.Contains(err.Error(), vfs.ErrSFTPLoop.Error()) {
@@ -224,6 +224,97 @@ func TestRenameVirtualFolders(t *testing.T) {
 	assert.False(t, res)
 }
 
+func TestCreateHardlinkErrors(t *testing.T) {
+	vdir := "/avdir"
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "hardlink_user",
+			HomeDir:  filepath.Join(os.TempDir(), "hardlink_home"),
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       "name",
+			MappedPath: filepath.Join(os.TempDir(), "mappedPath"),
+		},
+		VirtualPath: vdir,
+	})
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", u)
+	err := conn.CreateHardlink("/file", path.Join(vdir, "file"))
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = conn.CreateHardlink("/missing", "/file")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	err = os.MkdirAll(filepath.Join(u.GetHomeDir(), "dir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = conn.CreateHardlink("/dir", "/dir_link")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	// successful hard links are recorded in the changes journal
+	token := GetSyncToken(u.Username)
+	err = os.WriteFile(filepath.Join(u.GetHomeDir(), "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = conn.CreateHardlink("/file", "/dir/file_link")
+	assert.NoError(t, err)
+	changes, _, err := GetChangesSince(u.Username, token)
+	assert.NoError(t, err)
+	assert.Equal(t, []FsChange{{VirtualPath: "/dir/file_link"}}, changes)
+	RemoveChangesJournal(u.Username)
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestCopy(t *testing.T) {
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			HomeDir: filepath.Join(os.TempDir(), "copy_home"),
+			Permissions: map[string][]string{
+				"/":    {dataprovider.PermAny},
+				"/ro":  {dataprovider.PermListItems, dataprovider.PermDownload},
+				"/dst": {dataprovider.PermListItems, dataprovider.PermCreateDirs},
+			},
+		},
+	}
+	err := os.MkdirAll(filepath.Join(u.GetHomeDir(), "src", "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(u.GetHomeDir(), "dst"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(u.GetHomeDir(), "src", "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(u.GetHomeDir(), "src", "sub", "file"), []byte("subdata"), os.ModePerm)
+	assert.NoError(t, err)
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", u)
+	err = conn.Copy("/src", "/src")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = conn.Copy("/src", "/src/sub/copy")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = conn.Copy("/missing", "/copy")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	err = conn.Copy("/src", "/copy")
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(u.GetHomeDir(), "copy", "sub", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("subdata"), data)
+	err = conn.Copy("/src/file", "/copy/sub/file")
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(u.GetHomeDir(), "copy", "sub", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	err = conn.Copy("/src", "/copy/file")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = conn.Copy("/src/file", "/copy/sub")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxOpUnsupported)
+	err = conn.Copy("/src/file", "/ro/file")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	// directories can be created but files cannot be uploaded
+	err = conn.Copy("/src", "/dst/src")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+	assert.DirExists(t, filepath.Join(u.GetHomeDir(), "dst", "src"))
+	err = os.RemoveAll(u.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestRenamePerms(t *testing.T) {
 	src := "source"
 	target := "target"
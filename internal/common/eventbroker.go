@@ -0,0 +1,332 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/xid"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/version"
+)
+
+// brokerMessage is the message published if no custom body is configured
+type brokerMessage struct {
+	Event             string          `json:"event"`
+	Name              string          `json:"name"`
+	Status            int             `json:"status,omitempty"`
+	VirtualPath       string          `json:"virtual_path,omitempty"`
+	FsPath            string          `json:"fs_path,omitempty"`
+	VirtualTargetPath string          `json:"virtual_target_path,omitempty"`
+	FsTargetPath      string          `json:"fs_target_path,omitempty"`
+	ObjectName        string          `json:"object_name,omitempty"`
+	ObjectType        string          `json:"object_type,omitempty"`
+	FileSize          int64           `json:"file_size,omitempty"`
+	Protocol          string          `json:"protocol,omitempty"`
+	IP                string          `json:"ip,omitempty"`
+	Timestamp         int64           `json:"timestamp"`
+	Error             string          `json:"error,omitempty"`
+	ObjectData        json.RawMessage `json:"object_data,omitempty"`
+}
+
+func getBrokerMessage(c dataprovider.EventActionBrokerConfig, params *EventParams) ([]byte, error) {
+	addObjectData := false
+	if params.Object != nil {
+		addObjectData = c.HasObjectData()
+	}
+	replacer := params.getReplacer(addObjectData)
+	if c.Body != "" {
+		return []byte(replaceWithReplacer(c.Body, replacer)), nil
+	}
+	msg := brokerMessage{
+		Event:             params.Event,
+		Name:              params.Name,
+		Status:            params.Status,
+		VirtualPath:       params.VirtualPath,
+		FsPath:            params.FsPath,
+		VirtualTargetPath: params.VirtualTargetPath,
+		FsTargetPath:      params.FsTargetPath,
+		ObjectName:        params.ObjectName,
+		ObjectType:        params.ObjectType,
+		FileSize:          params.FileSize,
+		Protocol:          params.Protocol,
+		IP:                params.IP,
+		Timestamp:         params.Timestamp,
+		Error:             strings.Join(params.errors, ", "),
+	}
+	if objectData := replacer.data.ObjectData; objectData != "" && json.Valid([]byte(objectData)) {
+		msg.ObjectData = json.RawMessage(objectData)
+	}
+	return json.Marshal(msg)
+}
+
+func getBrokerTLSConfig(c dataprovider.EventActionBrokerConfig, serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipTLSVerify,
+	}
+}
+
+func publishAMQPMessage(ctx context.Context, c dataprovider.EventActionBrokerConfig, topic string, body []byte) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid AMQP endpoint: %w", err)
+	}
+	// the network connection is closed if the context expires, this way
+	// an unresponsive broker cannot block the pending calls
+	var netConn net.Conn
+	dial := amqp.DefaultDial(time.Duration(c.Timeout) * time.Second)
+	config := amqp.Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := dial(network, addr)
+			netConn = conn
+			return conn, err
+		},
+		Properties: amqp.Table{
+			"product": "SFTPGo",
+			"version": version.Get().Version,
+		},
+	}
+	if c.Username != "" {
+		config.SASL = []amqp.Authentication{
+			&amqp.PlainAuth{
+				Username: c.Username,
+				Password: c.Password.GetPayload(),
+			},
+		}
+	}
+	if u.Scheme == "amqps" {
+		config.TLSClientConfig = getBrokerTLSConfig(c, u.Hostname())
+	}
+	conn, err := amqp.DialConfig(c.Endpoint, config)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the AMQP broker: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			netConn.Close()
+		case <-done:
+		}
+	}()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("unable to open an AMQP channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("unable to enable AMQP publisher confirms: %w", err)
+	}
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, c.Exchange, topic, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		AppId:        "SFTPGo",
+		Body:         body,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to publish the AMQP message: %w", err)
+	}
+	if !confirmation.Wait() {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("timeout waiting for the AMQP message acknowledgement: %w", err)
+		}
+		return errors.New("the AMQP message was not acknowledged by the broker")
+	}
+	return nil
+}
+
+func publishMQTTMessage(c dataprovider.EventActionBrokerConfig, topic string, body []byte) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid MQTT endpoint: %w", err)
+	}
+	timeout := time.Duration(c.Timeout) * time.Second
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Endpoint).
+		SetClientID(fmt.Sprintf("sftpgo_%s", xid.New().String())).
+		SetConnectTimeout(timeout).
+		SetWriteTimeout(timeout).
+		SetAutoReconnect(false).
+		SetConnectRetry(false)
+	if c.Username != "" {
+		opts.SetUsername(c.Username)
+		opts.SetPassword(c.Password.GetPayload())
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		opts.SetTLSConfig(getBrokerTLSConfig(c, u.Hostname()))
+	}
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return errors.New("timeout connecting to the MQTT broker")
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("unable to connect to the MQTT broker: %w", err)
+	}
+	defer client.Disconnect(250)
+
+	// QoS 1, the broker acknowledges the message
+	token = client.Publish(topic, 1, false, body)
+	if !token.WaitTimeout(timeout) {
+		return errors.New("timeout waiting for the MQTT message acknowledgement")
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("unable to publish the MQTT message: %w", err)
+	}
+	return nil
+}
+
+func publishNATSMessage(c dataprovider.EventActionBrokerConfig, topic string, body []byte) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid NATS endpoint: %w", err)
+	}
+	timeout := time.Duration(c.Timeout) * time.Second
+	opts := []nats.Option{
+		nats.Name("SFTPGo"),
+		nats.Timeout(timeout),
+		nats.NoReconnect(),
+	}
+	if c.Username != "" {
+		opts = append(opts, nats.UserInfo(c.Username, c.Password.GetPayload()))
+	}
+	if u.Scheme == "tls" {
+		opts = append(opts, nats.Secure(getBrokerTLSConfig(c, u.Hostname())))
+	}
+	nc, err := nats.Connect(c.Endpoint, opts...)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the NATS server: %w", err)
+	}
+	defer nc.Close()
+
+	if err := nc.Publish(topic, body); err != nil {
+		return fmt.Errorf("unable to publish the NATS message: %w", err)
+	}
+	// core NATS has no acknowledgements, a flush ensures that the
+	// server has processed the message
+	if err := nc.FlushTimeout(timeout); err != nil {
+		return fmt.Errorf("unable to flush the NATS message: %w", err)
+	}
+	return nil
+}
+
+func publishKafkaMessage(ctx context.Context, c dataprovider.EventActionBrokerConfig, topic string, body []byte) error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid Kafka endpoint: %w", err)
+	}
+	timeout := time.Duration(c.Timeout) * time.Second
+	transport := &kafka.Transport{
+		DialTimeout: timeout,
+		ClientID:    "SFTPGo",
+	}
+	defer transport.CloseIdleConnections()
+
+	brokers, err := c.GetKafkaBrokers()
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "kafkas" {
+		// the server name is set for each broker
+		transport.TLS = getBrokerTLSConfig(c, "")
+	}
+	if c.Username != "" {
+		transport.SASL = plain.Mechanism{
+			Username: c.Username,
+			Password: c.Password.GetPayload(),
+		}
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    1,
+		MaxAttempts:  3,
+		WriteTimeout: timeout,
+		ReadTimeout:  timeout,
+		Transport:    transport,
+	}
+	defer w.Close()
+
+	err = w.WriteMessages(ctx, kafka.Message{
+		Value: body,
+		Time:  time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to publish the Kafka message: %w", err)
+	}
+	return nil
+}
+
+func executeBrokerRuleAction(c dataprovider.EventActionBrokerConfig, params *EventParams) error {
+	if err := c.TryDecryptPassword(); err != nil {
+		return err
+	}
+	body, err := getBrokerMessage(c, params)
+	if err != nil {
+		return fmt.Errorf("unable to build the message to publish: %w", err)
+	}
+	replacer := params.getReplacer(false)
+	topic := replaceWithReplacer(c.Topic, replacer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	startTime := time.Now()
+	switch c.Protocol {
+	case dataprovider.BrokerProtocolAMQP:
+		err = publishAMQPMessage(ctx, c, topic, body)
+	case dataprovider.BrokerProtocolMQTT:
+		err = publishMQTTMessage(c, topic, body)
+	case dataprovider.BrokerProtocolNATS:
+		err = publishNATSMessage(c, topic, body)
+	case dataprovider.BrokerProtocolKafka:
+		err = publishKafkaMessage(ctx, c, topic, body)
+	default:
+		err = fmt.Errorf("unsupported message broker protocol: %d", c.Protocol)
+	}
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to publish message to %s broker %q, topic %q, elapsed: %s, err: %v",
+			c.GetProtocolAsString(), c.Endpoint, topic, time.Since(startTime), err)
+		return err
+	}
+	eventManagerLog(logger.LevelDebug, "message published to %s broker %q, topic %q, elapsed: %s",
+		c.GetProtocolAsString(), c.Endpoint, topic, time.Since(startTime))
+	return nil
+}
@@ -0,0 +1,465 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+type testBrokerMessage struct {
+	topic   string
+	payload []byte
+}
+
+func getTestBrokerParams() *EventParams {
+	return &EventParams{
+		Name:        "user",
+		Event:       operationUpload,
+		Status:      1,
+		VirtualPath: "/dir/file.txt",
+		FileSize:    10,
+		Protocol:    ProtocolSFTP,
+		IP:          "::1",
+		Timestamp:   time.Now().UnixNano(),
+	}
+}
+
+func checkTestBrokerMessage(t *testing.T, messages <-chan testBrokerMessage, topic string) {
+	select {
+	case msg := <-messages:
+		assert.Equal(t, topic, msg.topic)
+		var data map[string]any
+		err := json.Unmarshal(msg.payload, &data)
+		require.NoError(t, err)
+		assert.Equal(t, operationUpload, data["event"])
+		assert.Equal(t, "user", data["name"])
+		assert.Equal(t, "/dir/file.txt", data["virtual_path"])
+		assert.Equal(t, float64(10), data["file_size"])
+		assert.Equal(t, ProtocolSFTP, data["protocol"])
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestBrokerActionAMQP(t *testing.T) {
+	addr, messages := startTestAMQPServer(t, true)
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolAMQP,
+		Endpoint: fmt.Sprintf("amqp://%s/", addr),
+		Username: "user",
+		Password: kms.NewPlainSecret("pwd"),
+		Exchange: "sftpgo",
+		Topic:    "sftpgo.{{Event}}.{{Name}}",
+		Timeout:  5,
+	}
+	err := executeBrokerRuleAction(c, getTestBrokerParams())
+	assert.NoError(t, err)
+	checkTestBrokerMessage(t, messages, "sftpgo/sftpgo.upload.user")
+}
+
+func TestBrokerActionAMQPTimeout(t *testing.T) {
+	addr, messages := startTestAMQPServer(t, false)
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolAMQP,
+		Endpoint: fmt.Sprintf("amqp://%s/", addr),
+		Exchange: "sftpgo",
+		Topic:    "sftpgo.{{Event}}.{{Name}}",
+		Timeout:  1,
+	}
+	startTime := time.Now()
+	err := executeBrokerRuleAction(c, getTestBrokerParams())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "timeout waiting for the AMQP message acknowledgement")
+	}
+	assert.Less(t, time.Since(startTime), 3*time.Second)
+	checkTestBrokerMessage(t, messages, "sftpgo/sftpgo.upload.user")
+}
+
+func TestBrokerActionMQTT(t *testing.T) {
+	addr, messages := startTestMQTTServer(t)
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolMQTT,
+		Endpoint: fmt.Sprintf("tcp://%s", addr),
+		Username: "user",
+		Password: kms.NewPlainSecret("pwd"),
+		Topic:    "sftpgo/{{Event}}/{{Name}}",
+		Timeout:  5,
+	}
+	err := executeBrokerRuleAction(c, getTestBrokerParams())
+	assert.NoError(t, err)
+	checkTestBrokerMessage(t, messages, "sftpgo/upload/user")
+}
+
+func TestBrokerActionKafka(t *testing.T) {
+	addr, messages := startTestKafkaServer(t, "sftpgo.upload.user")
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolKafka,
+		Endpoint: fmt.Sprintf("kafka://%s", addr),
+		Topic:    "sftpgo.{{Event}}.{{Name}}",
+		Timeout:  5,
+	}
+	err := executeBrokerRuleAction(c, getTestBrokerParams())
+	assert.NoError(t, err)
+	checkTestBrokerMessage(t, messages, "sftpgo.upload.user")
+}
+
+func TestKafkaBrokers(t *testing.T) {
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolKafka,
+		Endpoint: "kafka://host1:9092, host2:9093,,",
+	}
+	brokers, err := c.GetKafkaBrokers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host1:9092", "host2:9093"}, brokers)
+	c.Endpoint = "kafkas://[::1]:9092/"
+	brokers, err = c.GetKafkaBrokers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[::1]:9092"}, brokers)
+	for _, endpoint := range []string{"kafka://", "kafka://,", "kafka://host1", "kafka://host1:9092,host2", "host1:9092"} {
+		c.Endpoint = endpoint
+		_, err = c.GetKafkaBrokers()
+		assert.Error(t, err, endpoint)
+	}
+}
+
+func startTestBrokerListener(t *testing.T, handler func(conn net.Conn, messages chan<- testBrokerMessage)) (string,
+	<-chan testBrokerMessage,
+) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	messages := make(chan testBrokerMessage, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				handler(conn, messages)
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+// startTestAMQPServer starts a minimal AMQP 0-9-1 server that accepts a connection, a channel
+// and confirms the published messages. The message topic is "exchange/routing key"
+// startTestAMQPServer starts a minimal AMQP broker, if ack is false the published
+// messages are never acknowledged and the close requests are ignored
+func startTestAMQPServer(t *testing.T, ack bool) (string, <-chan testBrokerMessage) {
+	return startTestBrokerListener(t, func(conn net.Conn, messages chan<- testBrokerMessage) {
+		reader := bufio.NewReader(conn)
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header, []byte("AMQP\x00\x00\x09\x01")) {
+			return
+		}
+		var startArgs bytes.Buffer
+		startArgs.Write([]byte{0, 9})            // version
+		writeAMQPLongString(&startArgs, "")      // empty server properties table
+		writeAMQPLongString(&startArgs, "PLAIN") // mechanisms
+		writeAMQPLongString(&startArgs, "en_US") // locales
+		if err := writeAMQPMethod(conn, 0, 10, 10, startArgs.Bytes()); err != nil {
+			return
+		}
+		var deliveryTag uint64
+		var pending *testBrokerMessage
+		var bodySize uint64
+
+		for {
+			frameType, channel, payload, err := readAMQPFrame(reader)
+			if err != nil {
+				return
+			}
+			switch frameType {
+			case 1: // method
+				if len(payload) < 4 {
+					return
+				}
+				classID := binary.BigEndian.Uint16(payload)
+				methodID := binary.BigEndian.Uint16(payload[2:])
+				args := payload[4:]
+				switch {
+				case classID == 10 && methodID == 11: // connection start-ok
+					var tuneArgs bytes.Buffer
+					binary.Write(&tuneArgs, binary.BigEndian, uint16(0))      //nolint:errcheck
+					binary.Write(&tuneArgs, binary.BigEndian, uint32(131072)) //nolint:errcheck
+					binary.Write(&tuneArgs, binary.BigEndian, uint16(0))      //nolint:errcheck
+					err = writeAMQPMethod(conn, 0, 10, 30, tuneArgs.Bytes())
+				case classID == 10 && methodID == 31: // connection tune-ok
+				case classID == 10 && methodID == 40: // connection open
+					err = writeAMQPMethod(conn, 0, 10, 41, []byte{0})
+				case classID == 10 && methodID == 50: // connection close
+					if !ack {
+						continue
+					}
+					writeAMQPMethod(conn, 0, 10, 51, nil) //nolint:errcheck
+					return
+				case classID == 20 && methodID == 10: // channel open
+					err = writeAMQPMethod(conn, channel, 20, 11, []byte{0, 0, 0, 0})
+				case classID == 20 && methodID == 40: // channel close
+					if !ack {
+						continue
+					}
+					err = writeAMQPMethod(conn, channel, 20, 41, nil)
+				case classID == 85 && methodID == 10: // confirm select
+					err = writeAMQPMethod(conn, channel, 85, 11, nil)
+				case classID == 60 && methodID == 40: // basic publish
+					exchange, rest, ok := readAMQPShortString(args[2:])
+					if !ok {
+						return
+					}
+					routingKey, _, ok := readAMQPShortString(rest)
+					if !ok {
+						return
+					}
+					pending = &testBrokerMessage{
+						topic: exchange + "/" + routingKey,
+					}
+				default:
+					return
+				}
+				if err != nil {
+					return
+				}
+			case 2: // content header
+				if pending == nil || len(payload) < 12 {
+					return
+				}
+				bodySize = binary.BigEndian.Uint64(payload[4:])
+			case 3: // content body
+				if pending == nil {
+					return
+				}
+				pending.payload = append(pending.payload, payload...)
+				if uint64(len(pending.payload)) < bodySize {
+					continue
+				}
+				messages <- *pending
+				pending = nil
+				if !ack {
+					continue
+				}
+				deliveryTag++
+				var ackArgs bytes.Buffer
+				binary.Write(&ackArgs, binary.BigEndian, deliveryTag) //nolint:errcheck
+				ackArgs.WriteByte(0)
+				if err := writeAMQPMethod(conn, channel, 60, 80, ackArgs.Bytes()); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+func writeAMQPLongString(buf *bytes.Buffer, val string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(val))) //nolint:errcheck
+	buf.WriteString(val)
+}
+
+func readAMQPShortString(data []byte) (string, []byte, bool) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, false
+	}
+	size := int(data[0])
+	return string(data[1 : 1+size]), data[1+size:], true
+}
+
+func writeAMQPMethod(w io.Writer, channel, classID, methodID uint16, args []byte) error {
+	payload := make([]byte, 4, 4+len(args))
+	binary.BigEndian.PutUint16(payload, classID)
+	binary.BigEndian.PutUint16(payload[2:], methodID)
+	payload = append(payload, args...)
+
+	frame := make([]byte, 7, 8+len(payload))
+	frame[0] = 1
+	binary.BigEndian.PutUint16(frame[1:], channel)
+	binary.BigEndian.PutUint32(frame[3:], uint32(len(payload)))
+	frame = append(frame, payload...)
+	frame = append(frame, 0xCE)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readAMQPFrame(r io.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != 0xCE {
+		return 0, 0, nil, errors.New("invalid frame end")
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:len(payload)-1], nil
+}
+
+// startTestMQTTServer starts a minimal MQTT 3.1.1 server that accepts connections
+// and acknowledges the QoS 1 publications
+func startTestMQTTServer(t *testing.T) (string, <-chan testBrokerMessage) {
+	return startTestBrokerListener(t, func(conn net.Conn, messages chan<- testBrokerMessage) {
+		reader := bufio.NewReader(conn)
+		for {
+			packetType, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			size, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return
+			}
+			packet := make([]byte, size)
+			if _, err := io.ReadFull(reader, packet); err != nil {
+				return
+			}
+			switch packetType >> 4 {
+			case 1: // connect
+				_, err = conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+			case 3: // publish
+				if len(packet) < 2 {
+					return
+				}
+				topicLen := int(binary.BigEndian.Uint16(packet))
+				if len(packet) < 2+topicLen+2 {
+					return
+				}
+				topic := string(packet[2 : 2+topicLen])
+				packetID := packet[2+topicLen : 2+topicLen+2]
+				messages <- testBrokerMessage{
+					topic:   topic,
+					payload: packet[2+topicLen+2:],
+				}
+				_, err = conn.Write([]byte{0x40, 0x02, packetID[0], packetID[1]})
+			case 12: // ping request
+				_, err = conn.Write([]byte{0xD0, 0x00})
+			case 14: // disconnect
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// startTestKafkaServer starts a minimal single node Kafka broker with the specified
+// topics that supports the API versions, metadata and produce requests
+func startTestKafkaServer(t *testing.T, topics ...string) (string, <-chan testBrokerMessage) {
+	var host string
+	var port int
+	addr, messages := startTestBrokerListener(t, func(conn net.Conn, messages chan<- testBrokerMessage) {
+		for {
+			apiVersion, correlationID, _, msg, err := protocol.ReadRequest(conn)
+			if err != nil {
+				return
+			}
+			var resp protocol.Message
+			switch req := msg.(type) {
+			case *apiversions.Request:
+				resp = &apiversions.Response{
+					ApiKeys: []apiversions.ApiKeyResponse{
+						{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+						{ApiKey: int16(protocol.Metadata), MinVersion: 0, MaxVersion: 8},
+						{ApiKey: int16(protocol.Produce), MinVersion: 0, MaxVersion: 8},
+					},
+				}
+			case *metadata.Request:
+				metadataResp := &metadata.Response{
+					Brokers: []metadata.ResponseBroker{
+						{NodeID: 0, Host: host, Port: int32(port)},
+					},
+				}
+				topicNames := req.TopicNames
+				if topicNames == nil {
+					topicNames = topics
+				}
+				for _, topic := range topicNames {
+					metadataResp.Topics = append(metadataResp.Topics, metadata.ResponseTopic{
+						Name: topic,
+						Partitions: []metadata.ResponsePartition{
+							{PartitionIndex: 0, LeaderID: 0, ReplicaNodes: []int32{0}, IsrNodes: []int32{0}},
+						},
+					})
+				}
+				resp = metadataResp
+			case *produce.Request:
+				produceResp := &produce.Response{}
+				for _, topic := range req.Topics {
+					respTopic := produce.ResponseTopic{Topic: topic.Topic}
+					for _, partition := range topic.Partitions {
+						for {
+							record, err := partition.RecordSet.Records.ReadRecord()
+							if err != nil {
+								break
+							}
+							payload, err := protocol.ReadAll(record.Value)
+							if err != nil {
+								return
+							}
+							messages <- testBrokerMessage{
+								topic:   topic.Topic,
+								payload: payload,
+							}
+						}
+						respTopic.Partitions = append(respTopic.Partitions, produce.ResponsePartition{
+							Partition: partition.Partition,
+						})
+					}
+					produceResp.Topics = append(produceResp.Topics, respTopic)
+				}
+				resp = produceResp
+			default:
+				return
+			}
+			if err := protocol.WriteResponse(conn, apiVersion, correlationID, resp); err != nil {
+				return
+			}
+		}
+	})
+	h, p, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	host = h
+	port, err = strconv.Atoi(p)
+	require.NoError(t, err)
+	return addr, messages
+}
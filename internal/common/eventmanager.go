@@ -15,9 +15,13 @@
 package common
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -36,6 +40,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"github.com/klauspost/compress/zip"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/xid"
@@ -53,12 +58,17 @@ import (
 const (
 	ipBlockedEventName = "IP Blocked"
 	maxAttachmentsSize = int64(10 * 1024 * 1024)
+	// httpSignatureHeader is the header with the HMAC-SHA256 request signature
+	httpSignatureHeader = "X-SFTPGo-Signature"
 )
 
 var (
 	// eventManager handle the supported event rules actions
 	eventManager          eventRulesContainer
 	multipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+	errUnsafeArchiveEntry = errors.New("unsafe archive entry")
+	// delay between failed transfer attempts
+	transferRetryDelay = 5 * time.Second
 )
 
 func init() {
@@ -635,6 +645,10 @@ func (p *EventParams) getStringReplacements(addObjectData bool) []string {
 	return replacements
 }
 
+func (p *EventParams) getReplacer(addObjectData bool) *eventReplacer {
+	return newEventReplacer(p, p.getStringReplacements(addObjectData))
+}
+
 func getCSVRetentionReport(results []folderRetentionCheckResult) ([]byte, error) {
 	var b bytes.Buffer
 	csvWriter := csv.NewWriter(&b)
@@ -859,7 +873,7 @@ func getFileContent(conn *BaseConnection, virtualPath string, expectedSize int)
 	return data, err
 }
 
-func getMailAttachments(user dataprovider.User, attachments []string, replacer *strings.Replacer) ([]mail.File, error) {
+func getMailAttachments(user dataprovider.User, attachments []string, replacer *eventReplacer) ([]mail.File, error) {
 	var files []mail.File
 	user, err := getUserForEventAction(user)
 	if err != nil {
@@ -897,10 +911,7 @@ func getMailAttachments(user dataprovider.User, attachments []string, replacer *
 	return files, nil
 }
 
-func replaceWithReplacer(input string, replacer *strings.Replacer) string {
-	if !strings.Contains(input, "{{") {
-		return input
-	}
+func replaceWithReplacer(input string, replacer *eventReplacer) string {
 	return replacer.Replace(input)
 }
 
@@ -945,7 +956,7 @@ func checkEventGroupConditionPatters(groups []sdk.GroupMapping, patterns []datap
 	return false
 }
 
-func getHTTPRuleActionEndpoint(c dataprovider.EventActionHTTPConfig, replacer *strings.Replacer) (string, error) {
+func getHTTPRuleActionEndpoint(c dataprovider.EventActionHTTPConfig, replacer *eventReplacer) (string, error) {
 	if len(c.QueryParameters) > 0 {
 		u, err := url.Parse(c.Endpoint)
 		if err != nil {
@@ -964,7 +975,7 @@ func getHTTPRuleActionEndpoint(c dataprovider.EventActionHTTPConfig, replacer *s
 }
 
 func writeHTTPPart(m *multipart.Writer, part dataprovider.HTTPPart, h textproto.MIMEHeader,
-	conn *BaseConnection, replacer *strings.Replacer, params *EventParams,
+	conn *BaseConnection, replacer *eventReplacer, params *EventParams,
 ) error {
 	partWriter, err := m.CreatePart(h)
 	if err != nil {
@@ -999,7 +1010,7 @@ func writeHTTPPart(m *multipart.Writer, part dataprovider.HTTPPart, h textproto.
 	return nil
 }
 
-func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strings.Replacer,
+func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *eventReplacer,
 	cancel context.CancelFunc, user dataprovider.User, params *EventParams,
 ) (io.ReadCloser, string, error) {
 	var body io.ReadCloser
@@ -1071,8 +1082,34 @@ func getHTTPRuleActionBody(c dataprovider.EventActionHTTPConfig, replacer *strin
 	return body, "", nil
 }
 
+// getHTTPSignatureURL returns the URL to sign for the specified request URL,
+// an empty path is signed as "/", the same path seen by the receiver
+func getHTTPSignatureURL(u *url.URL) string {
+	if u.Path != "" || u.Opaque != "" {
+		return u.String()
+	}
+	signedURL := *u
+	signedURL.Path = "/"
+	return signedURL.String()
+}
+
+// getHTTPSignature returns the value for the signature header.
+// The signed payload is "<timestamp>.<nonce>.<method>.<url>.<body>", receivers
+// should reject requests with an old timestamp or an already used nonce
+func getHTTPSignature(secret, method, url string, body []byte) string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := util.GenerateUniqueID()
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, val := range []string{timestamp, nonce, method, url} {
+		mac.Write([]byte(val))
+		mac.Write([]byte("."))
+	}
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,nonce=%s,v1=%s", timestamp, nonce, hex.EncodeToString(mac.Sum(nil)))
+}
+
 func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventParams) error {
-	if err := c.TryDecryptPassword(); err != nil {
+	if err := c.TryDecryptSecrets(); err != nil {
 		return err
 	}
 	addObjectData := false
@@ -1080,8 +1117,7 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 		addObjectData = c.HasObjectData()
 	}
 
-	replacements := params.getStringReplacements(addObjectData)
-	replacer := strings.NewReplacer(replacements...)
+	replacer := params.getReplacer(addObjectData)
 	endpoint, err := getHTTPRuleActionEndpoint(c, replacer)
 	if err != nil {
 		return err
@@ -1104,10 +1140,23 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 	if body != nil {
 		defer body.Close()
 	}
+	var data []byte
+	if c.SigningSecret.GetPayload() != "" && body != nil {
+		// the body must be fully read to compute the signature, multipart
+		// requests with files are not allowed if signing is enabled
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("unable to read the HTTP body to sign: %w", err)
+		}
+		body = io.NopCloser(bytes.NewReader(data))
+	}
 	req, err := http.NewRequestWithContext(ctx, c.Method, endpoint, body)
 	if err != nil {
 		return err
 	}
+	if c.SigningSecret.GetPayload() != "" {
+		req.Header.Set(httpSignatureHeader, getHTTPSignature(c.SigningSecret.GetPayload(), req.Method, getHTTPSignatureURL(req.URL), data))
+	}
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
@@ -1117,7 +1166,10 @@ func executeHTTPRuleAction(c dataprovider.EventActionHTTPConfig, params *EventPa
 	for _, keyVal := range c.Headers {
 		req.Header.Set(keyVal.Key, replaceWithReplacer(keyVal.Value, replacer))
 	}
-	client := c.GetHTTPClient()
+	client, err := c.GetHTTPClient()
+	if err != nil {
+		return err
+	}
 	defer client.CloseIdleConnections()
 
 	startTime := time.Now()
@@ -1148,8 +1200,7 @@ func executeCommandRuleAction(c dataprovider.EventActionCommandConfig, params *E
 			}
 		}
 	}
-	replacements := params.getStringReplacements(addObjectData)
-	replacer := strings.NewReplacer(replacements...)
+	replacer := params.getReplacer(addObjectData)
 
 	args := make([]string, 0, len(c.Args))
 	for _, arg := range c.Args {
@@ -1181,8 +1232,7 @@ func executeEmailRuleAction(c dataprovider.EventActionEmailConfig, params *Event
 			addObjectData = true
 		}
 	}
-	replacements := params.getStringReplacements(addObjectData)
-	replacer := strings.NewReplacer(replacements...)
+	replacer := params.getReplacer(addObjectData)
 	body := replaceWithReplacer(c.Body, replacer)
 	subject := replaceWithReplacer(c.Subject, replacer)
 	startTime := time.Now()
@@ -1238,7 +1288,7 @@ func getUserForEventAction(user dataprovider.User) (dataprovider.User, error) {
 	return user, nil
 }
 
-func replacePathsPlaceholders(paths []string, replacer *strings.Replacer) []string {
+func replacePathsPlaceholders(paths []string, replacer *eventReplacer) []string {
 	for idx := range paths {
 		paths[idx] = util.CleanPath(replaceWithReplacer(paths[idx], replacer))
 	}
@@ -1253,7 +1303,7 @@ func executeDeleteFileFsAction(conn *BaseConnection, item string, info os.FileIn
 	return conn.RemoveFile(fs, fsPath, item, info)
 }
 
-func executeDeleteFsActionForUser(deletes []string, replacer *strings.Replacer, user dataprovider.User) error {
+func executeDeleteFsActionForUser(deletes []string, replacer *eventReplacer, user dataprovider.User) error {
 	user, err := getUserForEventAction(user)
 	if err != nil {
 		return err
@@ -1287,7 +1337,7 @@ func executeDeleteFsActionForUser(deletes []string, replacer *strings.Replacer,
 	return nil
 }
 
-func executeDeleteFsRuleAction(deletes []string, replacer *strings.Replacer,
+func executeDeleteFsRuleAction(deletes []string, replacer *eventReplacer,
 	conditions dataprovider.ConditionOptions, params *EventParams,
 ) error {
 	users, err := params.getUsers()
@@ -1327,7 +1377,7 @@ func executeDeleteFsRuleAction(deletes []string, replacer *strings.Replacer,
 	return nil
 }
 
-func executeMkDirsFsActionForUser(dirs []string, replacer *strings.Replacer, user dataprovider.User) error {
+func executeMkDirsFsActionForUser(dirs []string, replacer *eventReplacer, user dataprovider.User) error {
 	user, err := getUserForEventAction(user)
 	if err != nil {
 		return err
@@ -1351,7 +1401,7 @@ func executeMkDirsFsActionForUser(dirs []string, replacer *strings.Replacer, use
 	return nil
 }
 
-func executeMkdirFsRuleAction(dirs []string, replacer *strings.Replacer,
+func executeMkdirFsRuleAction(dirs []string, replacer *eventReplacer,
 	conditions dataprovider.ConditionOptions, params *EventParams,
 ) error {
 	users, err := params.getUsers()
@@ -1390,7 +1440,7 @@ func executeMkdirFsRuleAction(dirs []string, replacer *strings.Replacer,
 	return nil
 }
 
-func executeRenameFsActionForUser(renames []dataprovider.KeyValue, replacer *strings.Replacer,
+func executeRenameFsActionForUser(renames []dataprovider.KeyValue, replacer *eventReplacer,
 	user dataprovider.User,
 ) error {
 	user, err := getUserForEventAction(user)
@@ -1415,7 +1465,7 @@ func executeRenameFsActionForUser(renames []dataprovider.KeyValue, replacer *str
 	return nil
 }
 
-func executeExistFsActionForUser(exist []string, replacer *strings.Replacer,
+func executeExistFsActionForUser(exist []string, replacer *eventReplacer,
 	user dataprovider.User,
 ) error {
 	user, err := getUserForEventAction(user)
@@ -1438,7 +1488,7 @@ func executeExistFsActionForUser(exist []string, replacer *strings.Replacer,
 	return nil
 }
 
-func executeRenameFsRuleAction(renames []dataprovider.KeyValue, replacer *strings.Replacer,
+func executeRenameFsRuleAction(renames []dataprovider.KeyValue, replacer *eventReplacer,
 	conditions dataprovider.ConditionOptions, params *EventParams,
 ) error {
 	users, err := params.getUsers()
@@ -1491,7 +1541,7 @@ func getArchiveBaseDir(paths []string) string {
 	return baseDir
 }
 
-func executeCompressFsActionForUser(c dataprovider.EventActionFsCompress, replacer *strings.Replacer,
+func executeCompressFsActionForUser(c dataprovider.EventActionFsCompress, replacer *eventReplacer,
 	user dataprovider.User,
 ) error {
 	user, err := getUserForEventAction(user)
@@ -1544,7 +1594,721 @@ func executeCompressFsActionForUser(c dataprovider.EventActionFsCompress, replac
 	return closeWriterAndUpdateQuota(writer, conn, name, numFiles, truncatedSize, err)
 }
 
-func executeExistFsRuleAction(exist []string, replacer *strings.Replacer, conditions dataprovider.ConditionOptions,
+func getExtractEntryPath(target, entryName string) (string, error) {
+	name := strings.ReplaceAll(entryName, "\\", "/")
+	if name == "" || path.IsAbs(name) {
+		return "", fmt.Errorf("%w: %q", errUnsafeArchiveEntry, entryName)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%w: %q", errUnsafeArchiveEntry, entryName)
+		}
+	}
+	entryPath := path.Join(target, name)
+	if entryPath == target {
+		// root entries, for example "./", are added by tar -C dir -czf archive.tar.gz .
+		return "", nil
+	}
+	if !strings.HasPrefix(entryPath, strings.TrimSuffix(target, "/")+"/") {
+		return "", fmt.Errorf("%w: %q", errUnsafeArchiveEntry, entryName)
+	}
+	return entryPath, nil
+}
+
+func copyWithLimit(dst io.Writer, src io.Reader, limit int64, errLimit error) (int64, error) {
+	if limit <= 0 {
+		return io.Copy(dst, src)
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, limit))
+	if err != nil {
+		return n, err
+	}
+	var buf [1]byte
+	if m, _ := io.ReadFull(src, buf[:]); m > 0 {
+		return n, errLimit
+	}
+	return n, nil
+}
+
+func (e *archiveExtractor) addEntry() error {
+	e.numEntries++
+	if e.MaxEntries > 0 && e.numEntries > e.MaxEntries {
+		return fmt.Errorf("too many entries in archive %q, max allowed: %d", e.Name, e.MaxEntries)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractDir(entryPath string) error {
+	if err := e.addEntry(); err != nil {
+		return err
+	}
+	if err := e.conn.CheckParentDirs(entryPath); err != nil {
+		return fmt.Errorf("unable to create dir %q: %w", entryPath, err)
+	}
+	return nil
+}
+
+// getWriteLimit returns the maximum size allowed for the specified entry, 0 means no limit.
+// The returned boolean is true if the limit is imposed by the user quota
+func (e *archiveExtractor) getWriteLimit(entryPath string, truncatedSize int64) (int64, bool, error) {
+	var remainingSize int64
+	if e.MaxSize > 0 {
+		remainingSize = e.MaxSize - e.extractedSize
+		if remainingSize <= 0 {
+			return 0, false, e.getMaxSizeError()
+		}
+	}
+	quotaResult, _ := e.conn.HasSpace(true, false, entryPath)
+	if !quotaResult.HasSpace {
+		return 0, true, e.conn.GetQuotaExceededError()
+	}
+	maxWriteSize, err := e.conn.GetMaxWriteSize(quotaResult, false, truncatedSize, false)
+	if err != nil {
+		return 0, true, err
+	}
+	if maxWriteSize > 0 && (remainingSize == 0 || maxWriteSize < remainingSize) {
+		return maxWriteSize, true, nil
+	}
+	return remainingSize, false, nil
+}
+
+func (e *archiveExtractor) getMaxSizeError() error {
+	return fmt.Errorf("the uncompressed size for archive %q exceeds the limit: %s", e.Name,
+		util.ByteCountIEC(e.MaxSize))
+}
+
+func (e *archiveExtractor) extractFile(entryPath string, reader io.Reader) error {
+	if err := e.addEntry(); err != nil {
+		return err
+	}
+	if entryPath == e.Name {
+		// never overwrite the archive we are reading from
+		eventManagerLog(logger.LevelInfo, "skipping archive entry %q, it overwrites the archive itself", entryPath)
+		return nil
+	}
+	if err := e.conn.CheckParentDirs(path.Dir(entryPath)); err != nil {
+		return fmt.Errorf("unable to check parent dirs for %q: %w", entryPath, err)
+	}
+	quotaResult, _ := e.conn.HasSpace(true, false, entryPath)
+	if !quotaResult.HasSpace {
+		return e.conn.GetQuotaExceededError()
+	}
+	writer, numFiles, truncatedSize, cancelFn, err := getFileWriter(e.conn, entryPath)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to create file %q: %v", entryPath, err)
+		return fmt.Errorf("unable to create file %q: %w", entryPath, err)
+	}
+	defer cancelFn()
+
+	limit, isQuotaLimit, err := e.getWriteLimit(entryPath, truncatedSize)
+	if err != nil {
+		closeWriterAndUpdateQuota(writer, e.conn, entryPath, numFiles, truncatedSize, err) //nolint:errcheck
+		return err
+	}
+	errLimit := e.getMaxSizeError()
+	if isQuotaLimit {
+		errLimit = e.conn.GetQuotaExceededError()
+	}
+	written, err := copyWithLimit(writer, reader, limit, errLimit)
+	e.extractedSize += written
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to extract %q from archive %q: %v", entryPath, e.Name, err)
+		closeWriterAndUpdateQuota(writer, e.conn, entryPath, numFiles, truncatedSize, err) //nolint:errcheck
+		return err
+	}
+	return closeWriterAndUpdateQuota(writer, e.conn, entryPath, numFiles, truncatedSize, nil)
+}
+
+// getZipReaderAt returns a reader with random access for the archive.
+// Archives stored on backends without random access are copied to a temporary file
+func (e *archiveExtractor) getZipReaderAt() (io.ReaderAt, int64, func(), error) {
+	reader, cancelFn, err := getFileReader(e.conn, e.Name)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("unable to open archive %q: %w", e.Name, err)
+	}
+	if f, ok := reader.(vfs.File); ok {
+		info, err := f.Stat()
+		if err == nil {
+			return f, info.Size(), func() {
+				f.Close()
+				cancelFn()
+			}, nil
+		}
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(vfs.GetTempPath(), "extract")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, reader)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("unable to read archive %q: %w", e.Name, err)
+	}
+	return tmp, size, cleanup, nil
+}
+
+func (e *archiveExtractor) extractZip() error {
+	readerAt, size, cleanup, err := e.getZipReaderAt()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	zipReader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return fmt.Errorf("unable to read zip archive %q: %w", e.Name, err)
+	}
+	if e.MaxEntries > 0 && len(zipReader.File) > e.MaxEntries {
+		return fmt.Errorf("too many entries in archive %q, max allowed: %d", e.Name, e.MaxEntries)
+	}
+	if e.MaxSize > 0 {
+		var totalSize uint64
+		for _, f := range zipReader.File {
+			totalSize += f.UncompressedSize64
+		}
+		if totalSize > uint64(e.MaxSize) {
+			return e.getMaxSizeError()
+		}
+	}
+	for _, f := range zipReader.File {
+		entryPath, err := getExtractEntryPath(e.Target, f.Name)
+		if err != nil {
+			return err
+		}
+		if entryPath == "" {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			if err := e.extractDir(entryPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			eventManagerLog(logger.LevelInfo, "skipping non regular zip entry %q", f.Name)
+			continue
+		}
+		if err := e.extractZipFile(f, entryPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractZipFile(f *zip.File, entryPath string) error {
+	reader, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open zip entry %q: %w", f.Name, err)
+	}
+	defer reader.Close()
+
+	return e.extractFile(entryPath, reader)
+}
+
+func (e *archiveExtractor) extractTar(compressed bool) error {
+	reader, cancelFn, err := getFileReader(e.conn, e.Name)
+	if err != nil {
+		return fmt.Errorf("unable to open archive %q: %w", e.Name, err)
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	var r io.Reader = reader
+	if compressed {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to read gzip archive %q: %w", e.Name, err)
+		}
+		defer gzReader.Close()
+
+		r = gzReader
+	}
+	tarReader := tar.NewReader(r)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar archive %q: %w", e.Name, err)
+		}
+		entryPath, err := getExtractEntryPath(e.Target, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if entryPath == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := e.extractDir(entryPath); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := e.extractFile(entryPath, tarReader); err != nil {
+				return err
+			}
+		default:
+			eventManagerLog(logger.LevelInfo, "skipping non regular tar entry %q, type %d", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func executeExtractFsActionForUser(c dataprovider.EventActionFsExtract, replacer *eventReplacer,
+	user dataprovider.User,
+) error {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("extract error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	name := util.CleanPath(replaceWithReplacer(c.Name, replacer))
+	target := util.CleanPath(replaceWithReplacer(c.Target, replacer))
+	info, err := conn.DoStat(name, 0, false)
+	if err != nil {
+		return fmt.Errorf("unable to stat archive %q: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot extract non regular file %q", name)
+	}
+	if err = conn.CheckParentDirs(target); err != nil {
+		return fmt.Errorf("unable to create target dir %q: %w", target, err)
+	}
+	extractor := &archiveExtractor{
+		Name:       name,
+		Target:     target,
+		MaxSize:    c.MaxSize,
+		MaxEntries: c.MaxEntries,
+		conn:       conn,
+	}
+	eventManagerLog(logger.LevelDebug, "extracting archive %q to %q", name, target)
+
+	lowerName := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lowerName, ".zip"):
+		err = extractor.extractZip()
+	case strings.HasSuffix(lowerName, ".tar"):
+		err = extractor.extractTar(false)
+	case strings.HasSuffix(lowerName, ".tar.gz"), strings.HasSuffix(lowerName, ".tgz"):
+		err = extractor.extractTar(true)
+	default:
+		err = fmt.Errorf("unsupported archive format for %q", name)
+	}
+	if err != nil {
+		eventManagerLog(logger.LevelError, "unable to extract archive %q: %v", name, err)
+		return err
+	}
+	eventManagerLog(logger.LevelDebug, "archive %q extracted, entries: %d, size: %d",
+		name, extractor.numEntries, extractor.extractedSize)
+	if c.DeleteSource {
+		if err = executeDeleteFileFsAction(conn, name, info); err != nil {
+			return fmt.Errorf("unable to remove archive %q after extraction: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func getTransferTargetFs(connectionID string, config vfs.Filesystem) (vfs.Fs, error) {
+	switch config.Provider {
+	case sdk.S3FilesystemProvider:
+		return vfs.NewS3Fs(connectionID, "", "", config.S3Config)
+	case sdk.GCSFilesystemProvider:
+		return vfs.NewGCSFs(connectionID, "", "", config.GCSConfig)
+	case sdk.AzureBlobFilesystemProvider:
+		return vfs.NewAzBlobFs(connectionID, "", "", config.AzBlobConfig)
+	case sdk.SFTPFilesystemProvider:
+		return vfs.NewSFTPFs(connectionID, "", "", nil, config.SFTPConfig)
+	default:
+		return nil, fmt.Errorf("unsupported transfer target filesystem %q", config.Provider.Name())
+	}
+}
+
+func getTransferTargetEndpoint(config vfs.Filesystem) string {
+	switch config.Provider {
+	case sdk.S3FilesystemProvider:
+		return config.S3Config.Bucket
+	case sdk.GCSFilesystemProvider:
+		return config.GCSConfig.Bucket
+	case sdk.AzureBlobFilesystemProvider:
+		return config.AzBlobConfig.Container
+	case sdk.SFTPFilesystemProvider:
+		return config.SFTPConfig.Endpoint
+	default:
+		return ""
+	}
+}
+
+func getTransferTargetName(c dataprovider.EventActionFsTransfer) string {
+	if c.TargetUser != "" {
+		return fmt.Sprintf("user %q", c.TargetUser)
+	}
+	name := c.TargetFs.Provider.Name()
+	if endpoint := getTransferTargetEndpoint(c.TargetFs); endpoint != "" {
+		name = fmt.Sprintf("%s %q", name, endpoint)
+	}
+	return name
+}
+
+func getTransferTarget(c dataprovider.EventActionFsTransfer) (*transferTarget, error) {
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	if c.TargetUser != "" {
+		user, err := dataprovider.UserExists(c.TargetUser, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to get transfer target user %q: %w", c.TargetUser, err)
+		}
+		user, err = getUserForEventAction(user)
+		if err != nil {
+			return nil, err
+		}
+		err = user.CheckFsRoot(connectionID)
+		if err != nil {
+			user.CloseFs() //nolint:errcheck
+			return nil, fmt.Errorf("unable to check root fs for transfer target user %q: %w", user.Username, err)
+		}
+		return &transferTarget{
+			conn: NewBaseConnection(connectionID, protocolEventAction, "", "", user),
+		}, nil
+	}
+	// the secrets are decrypted when the filesystem is created, don't modify the shared configuration
+	fsConfig := c.TargetFs.GetACopy()
+	fs, err := getTransferTargetFs(connectionID, fsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create transfer target filesystem: %w", err)
+	}
+	return &transferTarget{
+		fs: fs,
+	}, nil
+}
+
+func (t *transferTarget) close() {
+	if t.conn != nil {
+		t.conn.User.CloseFs() //nolint:errcheck
+		return
+	}
+	t.fs.Close()
+}
+
+func (t *transferTarget) getFsPath(virtualPath string) string {
+	if t == nil {
+		return ""
+	}
+	if t.conn != nil {
+		_, fsPath, err := t.conn.GetFsAndResolvedPath(virtualPath)
+		if err != nil {
+			return ""
+		}
+		return fsPath
+	}
+	fsPath, err := t.fs.ResolvePath(virtualPath)
+	if err != nil {
+		return ""
+	}
+	return fsPath
+}
+
+func (t *transferTarget) checkParentDirs(virtualPath string) error {
+	if t.conn != nil {
+		return t.conn.CheckParentDirs(virtualPath)
+	}
+	if t.fs.HasVirtualFolders() {
+		return nil
+	}
+	dirs := util.GetDirsForVirtualPath(virtualPath)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		fsPath, err := t.fs.ResolvePath(dirs[idx])
+		if err != nil {
+			return err
+		}
+		_, err = t.fs.Stat(fsPath)
+		if err == nil {
+			continue
+		}
+		if !t.fs.IsNotExist(err) {
+			return err
+		}
+		if err = t.fs.Mkdir(fsPath); err != nil {
+			return fmt.Errorf("unable to create missing dir %q: %w", dirs[idx], err)
+		}
+	}
+	return nil
+}
+
+func (t *transferTarget) writeToUser(virtualPath string, reader io.Reader, size int64) error {
+	quotaResult, _ := t.conn.HasSpace(true, false, virtualPath)
+	if !quotaResult.HasSpace {
+		return t.conn.GetQuotaExceededError()
+	}
+	writer, numFiles, truncatedSize, cancelFn, err := getFileWriter(t.conn, virtualPath)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", virtualPath, err)
+	}
+	defer cancelFn()
+
+	maxWriteSize, err := t.conn.GetMaxWriteSize(quotaResult, false, truncatedSize, false)
+	if err == nil && maxWriteSize > 0 && size > maxWriteSize {
+		err = t.conn.GetQuotaExceededError()
+	}
+	if err != nil {
+		closeWriterAndUpdateQuota(writer, t.conn, virtualPath, numFiles, truncatedSize, err) //nolint:errcheck
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	errClose := closeWriterAndUpdateQuota(writer, t.conn, virtualPath, numFiles, truncatedSize, err)
+	if err != nil {
+		return err
+	}
+	return errClose
+}
+
+func (t *transferTarget) writeToFs(virtualPath string, reader io.Reader) error {
+	fsPath, err := t.fs.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	f, w, cancelFn, err := t.fs.Create(fsPath, 0)
+	if err != nil {
+		return fmt.Errorf("unable to create file %q: %w", virtualPath, err)
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	var writer io.WriteCloser = w
+	if f != nil {
+		writer = f
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		// cancel before closing so partial uploads are aborted, if supported
+		cancelFn()
+		writer.Close()
+		return err
+	}
+	err = writer.Close()
+	cancelFn()
+	return err
+}
+
+func (t *transferTarget) getFileReader(virtualPath string) (io.ReadCloser, func(), error) {
+	if t.conn != nil {
+		return getFileReader(t.conn, virtualPath)
+	}
+	fsPath, err := t.fs.ResolvePath(virtualPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, r, cancelFn, err := t.fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	if f != nil {
+		return f, cancelFn, nil
+	}
+	return r, cancelFn, nil
+}
+
+func (t *transferTarget) getChecksum(virtualPath string) (string, error) {
+	reader, cancelFn, err := t.getFileReader(virtualPath)
+	if err != nil {
+		return "", err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (t *transferTarget) removeFile(virtualPath string) {
+	var err error
+	if t.conn != nil {
+		var fs vfs.Fs
+		var fsPath string
+		var info os.FileInfo
+		fs, fsPath, err = t.conn.GetFsAndResolvedPath(virtualPath)
+		if err == nil {
+			info, err = fs.Lstat(fsPath)
+			if err == nil {
+				err = t.conn.RemoveFile(fs, fsPath, virtualPath, info)
+			}
+		}
+	} else {
+		var fsPath string
+		fsPath, err = t.fs.ResolvePath(virtualPath)
+		if err == nil {
+			err = t.fs.Remove(fsPath, false)
+		}
+	}
+	if err != nil {
+		eventManagerLog(logger.LevelDebug, "unable to remove transferred file %q: %v", virtualPath, err)
+	}
+}
+
+// transferFile copies the source file to the target and returns the SHA256 checksum
+// of the transferred data, the checksum is empty if the verification is disabled
+func (t *transferTarget) transferFile(conn *BaseConnection, source, target string, size int64,
+	verifyChecksum bool,
+) (string, error) {
+	if err := t.checkParentDirs(path.Dir(target)); err != nil {
+		return "", fmt.Errorf("unable to check parent dirs for %q: %w", target, err)
+	}
+	reader, cancelFn, err := getFileReader(conn, source)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %q: %w", source, err)
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	h := sha256.New()
+	var src io.Reader = reader
+	if verifyChecksum {
+		src = io.TeeReader(reader, h)
+	}
+	if t.conn != nil {
+		err = t.writeToUser(target, src, size)
+	} else {
+		err = t.writeToFs(target, src)
+	}
+	if err != nil {
+		t.removeFile(target)
+		return "", err
+	}
+	if !verifyChecksum {
+		return "", nil
+	}
+	sourceChecksum := hex.EncodeToString(h.Sum(nil))
+	targetChecksum, err := t.getChecksum(target)
+	if err != nil {
+		return "", fmt.Errorf("unable to compute the checksum for %q: %w", target, err)
+	}
+	if sourceChecksum != targetChecksum {
+		t.removeFile(target)
+		return "", fmt.Errorf("checksum mismatch for %q, source: %s, target: %s", target, sourceChecksum, targetChecksum)
+	}
+	return sourceChecksum, nil
+}
+
+func notifyTransferResult(conn *BaseConnection, source, target, fsTarget string, size int64, err error) {
+	if !plugin.Handler.HasNotifiers() {
+		return
+	}
+	_, fsSource, errFs := conn.GetFsAndResolvedPath(source)
+	if errFs != nil {
+		fsSource = ""
+	}
+	notification := newActionNotification(&conn.User, operationTransfer, fsSource, source, fsTarget, target, "",
+		conn.protocol, conn.GetRemoteIP(), conn.ID, size, 0, err)
+	plugin.Handler.NotifyFsEvent(notification)
+}
+
+func executeTransferSourceAction(c dataprovider.EventActionFsTransfer, replacer *eventReplacer,
+	conn *BaseConnection, source string, info os.FileInfo,
+) error {
+	if c.DeleteSource {
+		if err := executeDeleteFileFsAction(conn, source, info); err != nil {
+			return fmt.Errorf("unable to remove %q after transfer: %w", source, err)
+		}
+		return nil
+	}
+	if c.MoveSourceTo != "" {
+		dir := util.CleanPath(replaceWithReplacer(c.MoveSourceTo, replacer))
+		if err := conn.CheckParentDirs(dir); err != nil {
+			return fmt.Errorf("unable to create dir %q: %w", dir, err)
+		}
+		target := path.Join(dir, path.Base(source))
+		if err := conn.Rename(source, target); err != nil {
+			return fmt.Errorf("unable to move %q->%q after transfer: %w", source, target, err)
+		}
+	}
+	return nil
+}
+
+func executeTransferFsActionForUser(c dataprovider.EventActionFsTransfer, replacer *eventReplacer,
+	user dataprovider.User,
+) error {
+	user, err := getUserForEventAction(user)
+	if err != nil {
+		return err
+	}
+	connectionID := fmt.Sprintf("%s_%s", protocolEventAction, xid.New().String())
+	err = user.CheckFsRoot(connectionID)
+	defer user.CloseFs() //nolint:errcheck
+	if err != nil {
+		return fmt.Errorf("transfer error, unable to check root fs for user %q: %w", user.Username, err)
+	}
+	conn := NewBaseConnection(connectionID, protocolEventAction, "", "", user)
+	targetName := getTransferTargetName(c)
+	// the target is created on first use so connection errors can be retried
+	var t *transferTarget
+	defer func() {
+		if t != nil {
+			t.close()
+		}
+	}()
+
+	for _, item := range c.Paths {
+		source := util.CleanPath(replaceWithReplacer(item.Key, replacer))
+		target := util.CleanPath(replaceWithReplacer(item.Value, replacer))
+		info, err := conn.DoStat(source, 0, false)
+		if err != nil {
+			return fmt.Errorf("unable to stat %q: %w", source, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("cannot transfer non regular file %q", source)
+		}
+		var checksum string
+		for attempt := 0; attempt <= c.Retries; attempt++ {
+			if attempt > 0 {
+				eventManagerLog(logger.LevelDebug, "retrying transfer %q->%q, target %s, attempt %d/%d",
+					source, target, targetName, attempt, c.Retries)
+				time.Sleep(transferRetryDelay)
+			}
+			if t == nil {
+				t, err = getTransferTarget(c)
+			}
+			if err == nil {
+				checksum, err = t.transferFile(conn, source, target, info.Size(), c.VerifyChecksum)
+				if err == nil {
+					break
+				}
+			}
+			eventManagerLog(logger.LevelWarn, "transfer %q->%q failed, user %q, target %s: %v",
+				source, target, user.Username, targetName, err)
+		}
+		notifyTransferResult(conn, source, target, t.getFsPath(target), info.Size(), err)
+		if err != nil {
+			eventManagerLog(logger.LevelError, "transfer %q->%q failed after %d attempts, user %q, target %s",
+				source, target, c.Retries+1, user.Username, targetName)
+			return fmt.Errorf("unable to transfer %q->%q, user %q: %w", source, target, user.Username, err)
+		}
+		eventManagerLog(logger.LevelInfo, "transfer %q->%q ok, user %q, target %s, size: %d, sha256: %q",
+			source, target, user.Username, targetName, info.Size(), checksum)
+		if err = executeTransferSourceAction(c, replacer, conn, source, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func executeExistFsRuleAction(exist []string, replacer *eventReplacer, conditions dataprovider.ConditionOptions,
 	params *EventParams,
 ) error {
 	users, err := params.getUsers()
@@ -1584,7 +2348,7 @@ func executeExistFsRuleAction(exist []string, replacer *strings.Replacer, condit
 	return nil
 }
 
-func executeCompressFsRuleAction(c dataprovider.EventActionFsCompress, replacer *strings.Replacer,
+func executeCompressFsRuleAction(c dataprovider.EventActionFsCompress, replacer *eventReplacer,
 	conditions dataprovider.ConditionOptions, params *EventParams,
 ) error {
 	users, err := params.getUsers()
@@ -1624,12 +2388,91 @@ func executeCompressFsRuleAction(c dataprovider.EventActionFsCompress, replacer
 	return nil
 }
 
+func executeExtractFsRuleAction(c dataprovider.EventActionFsExtract, replacer *eventReplacer,
+	conditions dataprovider.ConditionOptions, params *EventParams,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	executed := 0
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkEventConditionPatterns(user.Username, conditions.Names) {
+				eventManagerLog(logger.LevelDebug, "skipping fs extract for user %s, name conditions don't match",
+					user.Username)
+				continue
+			}
+			if !checkEventGroupConditionPatters(user.Groups, conditions.GroupNames) {
+				eventManagerLog(logger.LevelDebug, "skipping fs extract for user %s, group name conditions don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeExtractFsActionForUser(c, replacer, user); err != nil {
+			failures = append(failures, user.Username)
+			params.AddError(err)
+			continue
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("fs extract failed for users: %+v", failures)
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no archive extracted")
+		return errors.New("no archive extracted")
+	}
+	return nil
+}
+
+func executeTransferFsRuleAction(c dataprovider.EventActionFsTransfer, replacer *eventReplacer,
+	conditions dataprovider.ConditionOptions, params *EventParams,
+) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	var failures []string
+	executed := 0
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkEventConditionPatterns(user.Username, conditions.Names) {
+				eventManagerLog(logger.LevelDebug, "skipping fs transfer for user %s, name conditions don't match",
+					user.Username)
+				continue
+			}
+			if !checkEventGroupConditionPatters(user.Groups, conditions.GroupNames) {
+				eventManagerLog(logger.LevelDebug, "skipping fs transfer for user %s, group name conditions don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeTransferFsActionForUser(c, replacer, user); err != nil {
+			failures = append(failures, user.Username)
+			params.AddError(err)
+			continue
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("fs transfer failed for users: %+v", failures)
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no file transferred")
+		return errors.New("no file transferred")
+	}
+	return nil
+}
+
 func executeFsRuleAction(c dataprovider.EventActionFilesystemConfig, conditions dataprovider.ConditionOptions,
 	params *EventParams,
 ) error {
 	addObjectData := false
-	replacements := params.getStringReplacements(addObjectData)
-	replacer := strings.NewReplacer(replacements...)
+	replacer := params.getReplacer(addObjectData)
 	switch c.Type {
 	case dataprovider.FilesystemActionRename:
 		return executeRenameFsRuleAction(c.Renames, replacer, conditions, params)
@@ -1641,6 +2484,10 @@ func executeFsRuleAction(c dataprovider.EventActionFilesystemConfig, conditions
 		return executeExistFsRuleAction(c.Exist, replacer, conditions, params)
 	case dataprovider.FilesystemActionCompress:
 		return executeCompressFsRuleAction(c.Compress, replacer, conditions, params)
+	case dataprovider.FilesystemActionExtract:
+		return executeExtractFsRuleAction(c.Extract, replacer, conditions, params)
+	case dataprovider.FilesystemActionTransfer:
+		return executeTransferFsRuleAction(c.Transfer, replacer, conditions, params)
 	default:
 		return fmt.Errorf("unsupported filesystem action %d", c.Type)
 	}
@@ -1955,6 +2802,8 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = executeMetadataCheckRuleAction(conditions, params)
 	case dataprovider.ActionTypeFilesystem:
 		err = executeFsRuleAction(action.Options.FsConfig, conditions, params)
+	case dataprovider.ActionTypeMessageBroker:
+		err = executeBrokerRuleAction(action.Options.BrokerConfig, params)
 	default:
 		err = fmt.Errorf("unsupported action type: %d", action.Type)
 	}
@@ -2135,6 +2984,23 @@ type zipWriterWrapper struct {
 	Writer  *zip.Writer
 }
 
+type archiveExtractor struct {
+	Name          string
+	Target        string
+	MaxSize       int64
+	MaxEntries    int
+	conn          *BaseConnection
+	numEntries    int
+	extractedSize int64
+}
+
+// transferTarget is the destination of the transfer filesystem action.
+// conn is set for SFTPGo users, fs for remote filesystems
+type transferTarget struct {
+	conn *BaseConnection
+	fs   vfs.Fs
+}
+
 func eventManagerLog(level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, "eventmanager", "", format, v...)
 }
@@ -15,16 +15,27 @@
 package common
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -337,6 +348,10 @@ func TestEventManagerErrors(t *testing.T) {
 	assert.Error(t, err)
 	err = executeCompressFsRuleAction(dataprovider.EventActionFsCompress{}, nil, dataprovider.ConditionOptions{}, &EventParams{})
 	assert.Error(t, err)
+	err = executeExtractFsRuleAction(dataprovider.EventActionFsExtract{}, nil, dataprovider.ConditionOptions{}, &EventParams{})
+	assert.Error(t, err)
+	err = executeTransferFsRuleAction(dataprovider.EventActionFsTransfer{}, nil, dataprovider.ConditionOptions{}, &EventParams{})
+	assert.Error(t, err)
 
 	groupName := "agroup"
 	err = executeQuotaResetForUser(dataprovider.User{
@@ -411,6 +426,24 @@ func TestEventManagerErrors(t *testing.T) {
 		},
 	})
 	assert.Error(t, err)
+	err = executeExtractFsActionForUser(dataprovider.EventActionFsExtract{}, nil, dataprovider.User{
+		Groups: []sdk.GroupMapping{
+			{
+				Name: groupName,
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+	})
+	assert.Error(t, err)
+	err = executeTransferFsActionForUser(dataprovider.EventActionFsTransfer{}, nil, dataprovider.User{
+		Groups: []sdk.GroupMapping{
+			{
+				Name: groupName,
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+	})
+	assert.Error(t, err)
 	_, err = getMailAttachments(dataprovider.User{
 		Groups: []sdk.GroupMapping{
 			{
@@ -1109,7 +1142,7 @@ func TestGetFileContent(t *testing.T) {
 	fileContent := []byte("test file content")
 	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), fileContent, 0666)
 	assert.NoError(t, err)
-	replacer := strings.NewReplacer("old", "new")
+	replacer := newEventReplacer(&EventParams{}, []string{"old", "new"})
 	files, err := getMailAttachments(user, []string{"/file.txt"}, replacer)
 	assert.NoError(t, err)
 	if assert.Len(t, files, 1) {
@@ -1153,13 +1186,130 @@ func TestGetFileContent(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGetExtractEntryPath(t *testing.T) {
+	entryPath, err := getExtractEntryPath("/target", "dir/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/target/dir/file.txt", entryPath)
+	entryPath, err = getExtractEntryPath("/", "dir\\file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir/file.txt", entryPath)
+	entryPath, err = getExtractEntryPath("/target", "./dir/./file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/target/dir/file.txt", entryPath)
+	for _, name := range []string{".", "./", "./."} {
+		entryPath, err = getExtractEntryPath("/target", name)
+		assert.NoError(t, err)
+		assert.Empty(t, entryPath)
+	}
+	for _, name := range []string{"", "/etc/passwd", "../file.txt", "dir/../../file.txt", "..\\file.txt"} {
+		_, err = getExtractEntryPath("/target", name)
+		assert.ErrorIs(t, err, errUnsafeArchiveEntry, "entry name %q", name)
+	}
+}
+
+func TestCopyWithLimit(t *testing.T) {
+	errLimit := errors.New("limit exceeded")
+	var b bytes.Buffer
+	n, err := copyWithLimit(&b, bytes.NewBufferString("content"), 0, errLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+	b.Reset()
+	n, err = copyWithLimit(&b, bytes.NewBufferString("content"), 7, errLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+	b.Reset()
+	n, err = copyWithLimit(&b, bytes.NewBufferString("content"), 6, errLimit)
+	assert.ErrorIs(t, err, errLimit)
+	assert.Equal(t, int64(6), n)
+}
+
+func TestTransferFsActionErrors(t *testing.T) {
+	_, err := getTransferTargetFs("", vfs.Filesystem{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported transfer target filesystem")
+	}
+	_, err = getTransferTarget(dataprovider.EventActionFsTransfer{
+		TargetUser: "missing user",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to get transfer target user")
+	}
+	_, err = getTransferTarget(dataprovider.EventActionFsTransfer{})
+	assert.Error(t, err)
+
+	username := "test_user_transfer"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+			HomeDir: filepath.Join(os.TempDir(), username),
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "adir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.txt"), []byte("content"), 0666)
+	assert.NoError(t, err)
+
+	c := dataprovider.EventActionFsTransfer{
+		Paths: []dataprovider.KeyValue{
+			{
+				Key:   "/missing.txt",
+				Value: "/target.txt",
+			},
+		},
+		TargetUser: username,
+	}
+	replacer := newEventReplacer(&EventParams{}, []string{"old", "new"})
+	err = executeTransferFsActionForUser(c, replacer, user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to stat")
+	}
+	c.Paths[0].Key = "/adir"
+	err = executeTransferFsActionForUser(c, replacer, user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot transfer non regular file")
+	}
+	// invalid credentials for the remote filesystem, all the attempts must fail
+	retryDelay := transferRetryDelay
+	transferRetryDelay = 10 * time.Millisecond
+	c.Paths[0].Key = "/file.txt"
+	c.TargetUser = ""
+	c.Retries = 2
+	c.TargetFs = vfs.Filesystem{
+		Provider: sdk.SFTPFilesystemProvider,
+		SFTPConfig: vfs.SFTPFsConfig{
+			BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+				Endpoint: "127.0.0.1:4022",
+				Username: username,
+			},
+			Password: kms.NewPlainSecret("pwd"),
+		},
+	}
+	err = executeTransferFsActionForUser(c, replacer, user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to transfer")
+	}
+	transferRetryDelay = retryDelay
+	// the source file must be preserved
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestFilesystemActionErrors(t *testing.T) {
 	err := executeFsRuleAction(dataprovider.EventActionFilesystemConfig{}, dataprovider.ConditionOptions{}, &EventParams{})
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "unsupported filesystem action")
 	}
 	username := "test_user_for_actions"
-	testReplacer := strings.NewReplacer("old", "new")
+	testReplacer := newEventReplacer(&EventParams{}, []string{"old", "new"})
 	user := dataprovider.User{
 		BaseUser: sdk.BaseUser{
 			Username: username,
@@ -1204,6 +1354,10 @@ func TestFilesystemActionErrors(t *testing.T) {
 	assert.Error(t, err)
 	err = executeCompressFsActionForUser(dataprovider.EventActionFsCompress{}, testReplacer, user)
 	assert.Error(t, err)
+	err = executeExtractFsActionForUser(dataprovider.EventActionFsExtract{}, testReplacer, user)
+	assert.Error(t, err)
+	err = executeTransferFsActionForUser(dataprovider.EventActionFsTransfer{}, testReplacer, user)
+	assert.Error(t, err)
 	_, _, _, _, err = getFileWriter(conn, "/path.txt") //nolint:dogsled
 	assert.Error(t, err)
 	err = executeEmailRuleAction(dataprovider.EventActionEmailConfig{
@@ -1598,8 +1752,148 @@ func TestWriteHTTPPartsError(t *testing.T) {
 	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
+// getTestRequestURL returns the URL, as sent by the client, for the specified server side request
+func getTestRequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func verifyHTTPSignature(secret, header, method, url string, body []byte) error {
+	var timestamp, nonce, signature string
+	for _, val := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(val, "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "nonce":
+			nonce = v
+		case "v1":
+			signature = v
+		}
+	}
+	if timestamp == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("invalid signature header %q", header)
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return err
+	}
+	if time.Since(time.Unix(ts, 0)) > time.Minute {
+		return errors.New("signature expired")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "." + method + "." + url + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func TestHTTPSignature(t *testing.T) {
+	body := []byte(`{"event":"upload"}`)
+	endpoint := "https://example.com/hook?a=b"
+	sig1 := getHTTPSignature("secret", http.MethodPost, endpoint, body)
+	assert.NoError(t, verifyHTTPSignature("secret", sig1, http.MethodPost, endpoint, body))
+	assert.Error(t, verifyHTTPSignature("other secret", sig1, http.MethodPost, endpoint, body))
+	assert.Error(t, verifyHTTPSignature("secret", sig1, http.MethodPost, endpoint, []byte("{}")))
+	// the signature cannot be replayed using another method or URL
+	assert.Error(t, verifyHTTPSignature("secret", sig1, http.MethodPut, endpoint, body))
+	assert.Error(t, verifyHTTPSignature("secret", sig1, http.MethodPost, "https://example.com/other", body))
+	sig2 := getHTTPSignature("secret", http.MethodPost, endpoint, body)
+	assert.NotEqual(t, sig1, sig2, "the nonce must be unique")
+	sig3 := getHTTPSignature("secret", http.MethodGet, endpoint, nil)
+	assert.NoError(t, verifyHTTPSignature("secret", sig3, http.MethodGet, endpoint, nil))
+
+	u, err := url.Parse("https://example.com:8443?a=b")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com:8443/?a=b", getHTTPSignatureURL(u))
+	u, err = url.Parse(endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, endpoint, getHTTPSignatureURL(u))
+}
+
+func TestHTTPActionSigningAndClientCert(t *testing.T) {
+	signingSecret := "signing secret"
+	var signatureErr error
+	var peerCerts int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signatureErr = verifyHTTPSignature(signingSecret, r.Header.Get(httpSignatureHeader), r.Method,
+			getTestRequestURL(r), body)
+		peerCerts = len(r.TLS.PeerCertificates)
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	c := dataprovider.EventActionHTTPConfig{
+		Endpoint:      server.URL,
+		Method:        http.MethodPost,
+		Body:          `{"user":"{{Name}}"}`,
+		Timeout:       5,
+		SkipTLSVerify: true,
+		SigningSecret: kms.NewPlainSecret(signingSecret),
+		ClientKey:     kms.NewEmptySecret(),
+	}
+	params := &EventParams{
+		Name:  "user",
+		Event: operationUpload,
+	}
+	// no client certificate, the TLS handshake fails
+	err := executeHTTPRuleAction(c, params)
+	assert.Error(t, err)
+
+	c.ClientCert = client1Crt
+	c.ClientKey = kms.NewPlainSecret(client1Key)
+	err = executeHTTPRuleAction(c, params)
+	assert.NoError(t, err)
+	assert.NoError(t, signatureErr)
+	assert.Equal(t, 1, peerCerts)
+	// multipart body without files
+	c.Body = ""
+	c.Parts = []dataprovider.HTTPPart{
+		{
+			Name: "p1",
+			Body: "{{Event}}",
+		},
+	}
+	err = executeHTTPRuleAction(c, params)
+	assert.NoError(t, err)
+	assert.NoError(t, signatureErr)
+	// invalid client key
+	c.ClientKey = kms.NewPlainSecret(client2Key)
+	err = executeHTTPRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to load the client certificate")
+	}
+	c.SigningSecret = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executeHTTPRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt HTTP signing secret")
+	}
+	c.SigningSecret = kms.NewEmptySecret()
+	c.ClientKey = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executeHTTPRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt HTTP client key")
+	}
+}
+
 func TestReplacePathsPlaceholders(t *testing.T) {
-	replacer := strings.NewReplacer("{{VirtualPath}}", "/path1")
+	replacer := newEventReplacer(&EventParams{}, []string{"{{VirtualPath}}", "/path1"})
 	paths := []string{"{{VirtualPath}}", "/path1"}
 	paths = replacePathsPlaceholders(paths, replacer)
 	assert.Equal(t, []string{"/path1"}, paths)
@@ -1608,6 +1902,250 @@ func TestReplacePathsPlaceholders(t *testing.T) {
 	assert.Equal(t, []string{"/path1", "/path2"}, paths)
 }
 
+func TestEventReplacer(t *testing.T) {
+	username := "test_template_user"
+	ts := time.Date(2022, time.November, 25, 10, 11, 12, 0, time.UTC)
+	params := &EventParams{
+		Name: username,
+		Groups: []sdk.GroupMapping{
+			{
+				Name: "group1",
+				Type: sdk.GroupTypeSecondary,
+			},
+			{
+				Name: "group2",
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+		Event:       operationUpload,
+		Status:      1,
+		VirtualPath: "/dir/sub/file.txt",
+		FileSize:    123,
+		Protocol:    ProtocolSFTP,
+		IP:          "127.0.0.1",
+		Timestamp:   ts.UnixNano(),
+		sender:      username,
+	}
+	replacer := params.getReplacer(false)
+	// legacy syntax
+	assert.Equal(t, "plain text", replacer.Replace("plain text"))
+	assert.Equal(t, "/dir/sub/file.txt-upload-/dir/sub", replacer.Replace("{{VirtualPath}}-{{Event}}-{{VirtualDirPath}}"))
+	assert.Equal(t, fmt.Sprintf("%d", ts.UnixNano()), replacer.Replace("{{Timestamp}}"))
+	// the legacy placeholders not available for this event are not replaced
+	assert.Equal(t, "{{TargetName}} 123", replacer.Replace("{{TargetName}} {{FileSize}}"))
+	assert.Equal(t, "{{ unknown }} OK", replacer.Replace("{{ unknown }} {{StatusString}}"))
+	// template syntax
+	assert.Equal(t, "2022/11/25", replacer.Replace(`{{.Timestamp.UTC | date "2006/01/02"}}`))
+	assert.Equal(t, "2022-11-25", replacer.Replace(`{{date "2006-01-02" .Timestamp.UTC}}`))
+	assert.Equal(t, "/sub/file.txt", replacer.Replace(`/{{.VirtualPath | dir | base}}/{{base .VirtualPath}}`))
+	assert.Equal(t, ".txt SFTP 123", replacer.Replace(`{{ext .VirtualPath}} {{.Protocol}} {{.FileSize}}`))
+	assert.Equal(t, "/dir/sub/file.txt group2", replacer.Replace(`{{VirtualPath}} {{.PrimaryGroup}}`))
+	assert.Equal(t, "group1:secondary,group2:primary,",
+		replacer.Replace(`{{range .Groups}}{{.Name}}:{{.Type}},{{end}}`))
+	assert.Equal(t, `a \"b\" c`, replacer.Replace(`{{jsonEscape "a \"b\" c"}}`))
+	assert.Equal(t, `{"path":"/dir/sub/file.txt"}`, replacer.Replace(`{"path":{{toJSON .VirtualPath}}}`))
+	// the user does not exist
+	assert.Equal(t, "{{.User.Email}}", replacer.Replace("{{.User.Email}}"))
+	_, err := formatEventTemplateDate("2006", "a")
+	assert.Error(t, err)
+	res, err := formatEventTemplateDate("2006", ts.UnixNano())
+	assert.NoError(t, err)
+	assert.Equal(t, ts.Local().Format("2006"), res)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:       username,
+			Email:          "user@example.com",
+			Description:    "desc",
+			AdditionalInfo: "info",
+			Status:         1,
+			Password:       "pwd",
+			HomeDir:        filepath.Join(os.TempDir(), username),
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "")
+	require.NoError(t, err)
+	replacer = params.getReplacer(false)
+	assert.Equal(t, "user@example.com desc info", replacer.Replace("{{.User.Email}} {{.User.Description}} {{.User.AdditionalInfo}}"))
+	assert.Equal(t, username, replacer.Replace("{{.User.Username}}"))
+
+	params.sender = dataprovider.ActionExecutorSystem
+	replacer = params.getReplacer(false)
+	assert.Equal(t, "{{.User.Email}}", replacer.Replace("{{.User.Email}}"))
+
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestBrokerActionErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	err = listener.Close()
+	require.NoError(t, err)
+
+	params := &EventParams{
+		Name:  "user",
+		Event: operationUpload,
+	}
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolAMQP,
+		Endpoint: fmt.Sprintf("amqp://%s/", addr),
+		Username: "user",
+		Password: kms.NewPlainSecret("pwd"),
+		Topic:    "sftpgo.{{Event}}",
+		Timeout:  1,
+	}
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect to the AMQP broker")
+	}
+	c.Protocol = dataprovider.BrokerProtocolMQTT
+	c.Endpoint = fmt.Sprintf("tcp://%s", addr)
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "MQTT broker")
+	}
+	c.Protocol = dataprovider.BrokerProtocolNATS
+	c.Endpoint = fmt.Sprintf("nats://%s", addr)
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect to the NATS server")
+	}
+	c.Protocol = dataprovider.BrokerProtocolKafka
+	c.Endpoint = fmt.Sprintf("kafka://%s", addr)
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to publish the Kafka message")
+	}
+	c.Protocol = 100
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported message broker protocol")
+	}
+	c.Endpoint = "kafka://%AG"
+	for _, protocol := range []int{dataprovider.BrokerProtocolAMQP, dataprovider.BrokerProtocolMQTT,
+		dataprovider.BrokerProtocolNATS, dataprovider.BrokerProtocolKafka} {
+		c.Protocol = protocol
+		err = executeBrokerRuleAction(c, params)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "endpoint")
+		}
+	}
+	c.Password = kms.NewSecret(sdkkms.SecretStatusSecretBox, "payload", "key", "data")
+	err = executeBrokerRuleAction(c, params)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decrypt message broker password")
+	}
+}
+
+func TestBrokerActionNATS(t *testing.T) {
+	addr, messages := startTestNATSServer(t)
+	ts := time.Now()
+	params := &EventParams{
+		Name:        "user",
+		Event:       operationUpload,
+		Status:      1,
+		VirtualPath: "/dir/file.txt",
+		FileSize:    10,
+		Protocol:    ProtocolSFTP,
+		IP:          "::1",
+		Timestamp:   ts.UnixNano(),
+	}
+	c := dataprovider.EventActionBrokerConfig{
+		Protocol: dataprovider.BrokerProtocolNATS,
+		Endpoint: fmt.Sprintf("nats://%s", addr),
+		Username: "user",
+		Password: kms.NewPlainSecret("pwd"),
+		Topic:    "sftpgo.{{Event}}.{{Name}}",
+		Timeout:  5,
+	}
+	err := executeBrokerRuleAction(c, params)
+	assert.NoError(t, err)
+	msg := <-messages
+	assert.Equal(t, "sftpgo.upload.user", msg.subject)
+	var data map[string]any
+	err = json.Unmarshal(msg.payload, &data)
+	require.NoError(t, err)
+	assert.Equal(t, operationUpload, data["event"])
+	assert.Equal(t, "user", data["name"])
+	assert.Equal(t, "/dir/file.txt", data["virtual_path"])
+	assert.Equal(t, float64(10), data["file_size"])
+	assert.Equal(t, ProtocolSFTP, data["protocol"])
+	assert.NotContains(t, data, "object_data")
+	assert.NotContains(t, data, "error")
+
+	c.Body = `{"path":"{{jsonEscape .VirtualPath}}","date":"{{.Timestamp.UTC | date "2006-01-02"}}"}`
+	err = executeBrokerRuleAction(c, params)
+	assert.NoError(t, err)
+	msg = <-messages
+	assert.Equal(t, fmt.Sprintf(`{"path":"/dir/file.txt","date":"%s"}`, ts.UTC().Format("2006-01-02")),
+		string(msg.payload))
+}
+
+type testNATSMessage struct {
+	subject string
+	payload []byte
+}
+
+// startTestNATSServer starts a minimal NATS server that accepts connections
+// and publications, it returns the listening address and the received messages
+func startTestNATSServer(t *testing.T) (string, <-chan testNATSMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	messages := make(chan testNATSMessage, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.9.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						continue
+					}
+					switch strings.ToUpper(fields[0]) {
+					case "PING":
+						fmt.Fprintf(conn, "PONG\r\n")
+					case "PUB":
+						size, err := strconv.Atoi(fields[len(fields)-1])
+						if err != nil {
+							return
+						}
+						payload := make([]byte, size+2)
+						if _, err := io.ReadFull(reader, payload); err != nil {
+							return
+						}
+						messages <- testNATSMessage{
+							subject: fields[1],
+							payload: payload[:size],
+						}
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
 func getErrorString(err error) string {
 	if err == nil {
 		return ""
@@ -0,0 +1,247 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// eventReplacer replaces the placeholders defined in event actions.
+// Inputs are rendered as Go text templates, the legacy placeholders,
+// for example {{VirtualPath}}, are available as template functions so
+// they can be used alone or mixed with the new syntax. If an input is
+// not a valid template we fallback to plain string replacements
+type eventReplacer struct {
+	replacer *strings.Replacer
+	funcs    template.FuncMap
+	data     *eventTemplateData
+}
+
+func newEventReplacer(params *EventParams, replacements []string) *eventReplacer {
+	funcs := getEventTemplateFuncs()
+	legacyValues := make(map[string]string)
+	for idx := 0; idx < len(replacements)-1; idx += 2 {
+		name := strings.TrimPrefix(replacements[idx], "{{")
+		name = strings.TrimSuffix(name, "}}")
+		if name == replacements[idx] {
+			continue
+		}
+		value := replacements[idx+1]
+		legacyValues[name] = value
+		funcs[name] = func() string {
+			return value
+		}
+	}
+	return &eventReplacer{
+		replacer: strings.NewReplacer(replacements...),
+		funcs:    funcs,
+		data:     newEventTemplateData(params, legacyValues),
+	}
+}
+
+// Replace returns a copy of input with all the placeholders replaced
+func (r *eventReplacer) Replace(input string) string {
+	if !strings.Contains(input, "{{") {
+		return input
+	}
+	tmpl, err := template.New("").Funcs(r.funcs).Parse(input)
+	if err != nil {
+		return r.replacer.Replace(input)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, r.data); err != nil {
+		eventManagerLog(logger.LevelWarn, "unable to execute template %q: %v, fallback to plain replacements",
+			input, err)
+		return r.replacer.Replace(input)
+	}
+	return b.String()
+}
+
+type eventTemplateGroup struct {
+	Name string
+	Type string
+}
+
+type eventTemplateUser struct {
+	Username       string
+	Email          string
+	Description    string
+	AdditionalInfo string
+	Role           string
+	Groups         []eventTemplateGroup
+}
+
+// eventTemplateData defines the data available inside event templates
+type eventTemplateData struct {
+	Name                 string
+	Event                string
+	Status               int
+	StatusString         string
+	ErrorString          string
+	VirtualPath          string
+	VirtualDirPath       string
+	FsPath               string
+	VirtualTargetPath    string
+	VirtualTargetDirPath string
+	TargetName           string
+	FsTargetPath         string
+	ObjectName           string
+	ObjectType           string
+	ObjectData           string
+	FileSize             int64
+	Protocol             string
+	IP                   string
+	Timestamp            time.Time
+	Groups               []eventTemplateGroup
+	PrimaryGroup         string
+	sender               string
+	user                 *eventTemplateUser
+}
+
+func newEventTemplateData(params *EventParams, legacyValues map[string]string) *eventTemplateData {
+	data := &eventTemplateData{
+		Name:                 params.Name,
+		Event:                params.Event,
+		Status:               params.Status,
+		StatusString:         legacyValues["StatusString"],
+		ErrorString:          legacyValues["ErrorString"],
+		VirtualPath:          params.VirtualPath,
+		VirtualDirPath:       legacyValues["VirtualDirPath"],
+		FsPath:               params.FsPath,
+		VirtualTargetPath:    params.VirtualTargetPath,
+		VirtualTargetDirPath: legacyValues["VirtualTargetDirPath"],
+		TargetName:           legacyValues["TargetName"],
+		FsTargetPath:         params.FsTargetPath,
+		ObjectName:           params.ObjectName,
+		ObjectType:           params.ObjectType,
+		ObjectData:           legacyValues["ObjectData"],
+		FileSize:             params.FileSize,
+		Protocol:             params.Protocol,
+		IP:                   params.IP,
+		Timestamp:            time.Unix(0, params.Timestamp),
+		Groups:               getEventTemplateGroups(params.Groups),
+		sender:               params.sender,
+	}
+	for _, group := range params.Groups {
+		if group.Type == sdk.GroupTypePrimary {
+			data.PrimaryGroup = group.Name
+			break
+		}
+	}
+	return data
+}
+
+// User returns the user associated with the event, it is loaded on first use
+func (d *eventTemplateData) User() (*eventTemplateUser, error) {
+	if d.user != nil {
+		return d.user, nil
+	}
+	if d.sender == "" || d.sender == dataprovider.ActionExecutorSystem {
+		return nil, errors.New("no user associated with this event")
+	}
+	user, err := dataprovider.UserExists(d.sender, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get user %q: %w", d.sender, err)
+	}
+	d.user = &eventTemplateUser{
+		Username:       user.Username,
+		Email:          user.Email,
+		Description:    user.Description,
+		AdditionalInfo: user.AdditionalInfo,
+		Role:           user.Role,
+		Groups:         getEventTemplateGroups(user.Groups),
+	}
+	return d.user, nil
+}
+
+func getEventTemplateGroups(groups []sdk.GroupMapping) []eventTemplateGroup {
+	result := make([]eventTemplateGroup, 0, len(groups))
+	for _, group := range groups {
+		var groupType string
+		switch group.Type {
+		case sdk.GroupTypePrimary:
+			groupType = "primary"
+		case sdk.GroupTypeSecondary:
+			groupType = "secondary"
+		default:
+			groupType = "membership"
+		}
+		result = append(result, eventTemplateGroup{
+			Name: group.Name,
+			Type: groupType,
+		})
+	}
+	return result
+}
+
+func getEventTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"date":       formatEventTemplateDate,
+		"base":       path.Base,
+		"ext":        path.Ext,
+		"dir":        path.Dir,
+		"clean":      util.CleanPath,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"jsonEscape": jsonEscapeEventTemplateValue,
+		"toJSON":     toJSONEventTemplateValue,
+	}
+}
+
+// formatEventTemplateDate formats the given value using the specified layout.
+// Integer values are interpreted as Unix timestamps in nanoseconds as for
+// the {{Timestamp}} placeholder
+func formatEventTemplateDate(layout string, value any) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case int64:
+		return time.Unix(0, v).Format(layout), nil
+	case int:
+		return time.Unix(0, int64(v)).Format(layout), nil
+	default:
+		return "", fmt.Errorf("unable to format %T as date", value)
+	}
+}
+
+// jsonEscapeEventTemplateValue escapes the given string so that it can be
+// safely embedded inside a JSON string
+func jsonEscapeEventTemplateValue(value string) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data[1 : len(data)-1]), nil
+}
+
+func toJSONEventTemplateValue(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -15,6 +15,7 @@
 package common_test
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"crypto/rand"
@@ -36,6 +37,8 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mhale/smtpd"
 	"github.com/minio/sio"
@@ -377,6 +380,44 @@ func TestRemoveAll(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebDAVLocksUpload(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	common.Config.EnforceWebDAVLocks = 1
+	defer func() {
+		common.Config.EnforceWebDAVLocks = 0
+	}()
+
+	lock := common.NewWebDAVLock(user.Username, "/"+testFileName, true, "", time.Minute)
+	lock.SetFsPath(vfs.NewOsFs("", user.GetHomeDir(), "").Name(), filepath.Join(user.GetHomeDir(), testFileName))
+	err = common.AddWebDAVLock(lock)
+	assert.NoError(t, err)
+
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.Error(t, err)
+		err = writeSFTPFile(testFileName+"1", 100, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName+"1", testFileName)
+		assert.Error(t, err)
+
+		err = common.RemoveWebDAVLock(lock.Token)
+		assert.NoError(t, err)
+		err = writeSFTPFile(testFileName, 100, client)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestRelativeSymlinks(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -4870,6 +4911,417 @@ func TestEventActionCompressErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestEventActionExtract(t *testing.T) {
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeFilesystem,
+		Options: dataprovider.BaseEventActionOptions{
+			FsConfig: dataprovider.EventActionFilesystemConfig{
+				Type: dataprovider.FilesystemActionExtract,
+				Extract: dataprovider.EventActionFsExtract{
+					Name:         "/{{VirtualPath}}",
+					Target:       "/extracted/{{ObjectName}}",
+					DeleteSource: true,
+				},
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test extract",
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"upload"},
+			Options: dataprovider.ConditionOptions{
+				FsPaths: []dataprovider.ConditionPattern{
+					{
+						Pattern: "/archive.*",
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+				Options: dataprovider.EventActionOptions{
+					ExecuteSync: true,
+				},
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	entries := map[string][]byte{
+		"dir/file1.txt": []byte("file1 content"),
+		"file2.txt":     []byte("file2 longer content"),
+	}
+	// archives created using "tar -C dir -czf archive.tar.gz ." have a root entry
+	archiveEntries := map[string][]byte{
+		"./": nil,
+	}
+	for name, content := range entries {
+		archiveEntries["./"+name] = content
+	}
+	zipData, err := getTestZipArchive(archiveEntries)
+	assert.NoError(t, err)
+	tarData, err := getTestTarGzArchive(archiveEntries)
+	assert.NoError(t, err)
+
+	u := getTestUser()
+	u.QuotaFiles = 1000
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestSFTPUser()
+	u.FsConfig.SFTPConfig.BufferSize = 1
+	u.QuotaFiles = 1000
+	sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	for _, user := range []dataprovider.User{localUser, sftpUser} {
+		// the SFTP user stores its files inside the local user home dir
+		err = os.RemoveAll(localUser.GetHomeDir())
+		assert.NoError(t, err)
+		rule1.Conditions.Options.Names = []dataprovider.ConditionPattern{
+			{
+				Pattern: user.Username,
+			},
+		}
+		_, _, err = httpdtest.UpdateEventRule(rule1, http.StatusOK)
+		assert.NoError(t, err)
+
+		conn, client, err := getSftpClient(user)
+		if assert.NoError(t, err) {
+			defer conn.Close()
+			defer client.Close()
+
+			for name, data := range map[string][]byte{"archive.zip": zipData, "archive.tar.gz": tarData} {
+				err = writeSFTPFileContent(name, data, client)
+				assert.NoError(t, err, "user %q archive %q", user.Username, name)
+				_, err = client.Stat(name)
+				assert.ErrorIs(t, err, os.ErrNotExist, "user %q archive %q", user.Username, name)
+				for entryName, content := range entries {
+					entryPath := path.Join("/extracted", name, entryName)
+					f, err := client.Open(entryPath)
+					if assert.NoError(t, err, "user %q entry %q", user.Username, entryPath) {
+						data, err := io.ReadAll(f)
+						assert.NoError(t, err)
+						assert.Equal(t, content, data)
+						err = f.Close()
+						assert.NoError(t, err)
+					}
+				}
+			}
+			user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, 2*len(entries), user.UsedQuotaFiles)
+			assert.Equal(t, int64(2*(len(entries["dir/file1.txt"])+len(entries["file2.txt"]))), user.UsedQuotaSize)
+		}
+	}
+	rule1.Conditions.Options.Names = []dataprovider.ConditionPattern{
+		{
+			Pattern: localUser.Username,
+		},
+	}
+	_, _, err = httpdtest.UpdateEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	// entries outside the target directory are not allowed
+	zipData, err = getTestZipArchive(map[string][]byte{"../evil.txt": []byte("evil")})
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(localUser)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFileContent("archive.zip", zipData, client)
+		assert.Error(t, err)
+		_, err = client.Stat("/extracted/evil.txt")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat("/evil.txt")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		// the uploaded file is removed if a sync action fails
+		_, err = client.Stat("archive.zip")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+	// limits
+	action1.Options.FsConfig.Extract.MaxEntries = 1
+	_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(localUser)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFileContent("archive.tar.gz", tarData, client)
+		assert.Error(t, err)
+		_, err = client.Stat("archive.tar.gz")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+	action1.Options.FsConfig.Extract.MaxEntries = 0
+	action1.Options.FsConfig.Extract.MaxSize = 20
+	_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(localUser)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFileContent("archive.tar.gz", tarData, client)
+		assert.Error(t, err)
+	}
+	// unsupported format
+	action1.Options.FsConfig.Extract.MaxSize = 0
+	_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(localUser)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFileContent("archive.rar", testFileContent, client)
+		assert.Error(t, err)
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestEventActionExtractQuota(t *testing.T) {
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeFilesystem,
+		Options: dataprovider.BaseEventActionOptions{
+			FsConfig: dataprovider.EventActionFilesystemConfig{
+				Type: dataprovider.FilesystemActionExtract,
+				Extract: dataprovider.EventActionFsExtract{
+					Name:   "/{{VirtualPath}}",
+					Target: "/extracted",
+				},
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test extract quota",
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"upload"},
+			Options: dataprovider.ConditionOptions{
+				FsPaths: []dataprovider.ConditionPattern{
+					{
+						Pattern: "/*.zip",
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+				Options: dataprovider.EventActionOptions{
+					ExecuteSync: true,
+				},
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	zipData, err := getTestZipArchive(map[string][]byte{
+		"file.txt": bytes.Repeat([]byte("a"), 4096),
+	})
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.QuotaSize = int64(len(zipData)) + 1024
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFileContent("archive.zip", zipData, client)
+		assert.Error(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, user.UsedQuotaSize, user.QuotaSize)
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestEventActionTransfer(t *testing.T) {
+	partnerUser := getTestUser()
+	partnerUser.Username = "partner_" + defaultUsername
+	partnerUser.HomeDir = filepath.Join(homeBasePath, partnerUser.Username)
+	partnerUser.QuotaFiles = 100
+	partner, _, err := httpdtest.AddUser(partnerUser, http.StatusCreated)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	a1 := dataprovider.BaseEventAction{
+		Name: "action1",
+		Type: dataprovider.ActionTypeFilesystem,
+		Options: dataprovider.BaseEventActionOptions{
+			FsConfig: dataprovider.EventActionFilesystemConfig{
+				Type: dataprovider.FilesystemActionTransfer,
+				Transfer: dataprovider.EventActionFsTransfer{
+					Paths: []dataprovider.KeyValue{
+						{
+							Key:   "/{{VirtualPath}}",
+							Value: "/inbox/{{ObjectName}}",
+						},
+					},
+					TargetUser:     partner.Username,
+					VerifyChecksum: true,
+					MoveSourceTo:   "/sent",
+				},
+			},
+		},
+	}
+	action1, _, err := httpdtest.AddEventAction(a1, http.StatusCreated)
+	assert.NoError(t, err)
+	r1 := dataprovider.EventRule{
+		Name:    "test transfer",
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"upload"},
+			Options: dataprovider.ConditionOptions{
+				Names: []dataprovider.ConditionPattern{
+					{
+						Pattern: user.Username,
+					},
+				},
+				FsPaths: []dataprovider.ConditionPattern{
+					{
+						Pattern: "/outbox/*",
+					},
+				},
+			},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action1.Name,
+				},
+				Order: 1,
+				Options: dataprovider.EventActionOptions{
+					ExecuteSync: true,
+				},
+			},
+		},
+	}
+	rule1, _, err := httpdtest.AddEventRule(r1, http.StatusCreated)
+	assert.NoError(t, err)
+
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		content := []byte("file content to transfer")
+		err = client.Mkdir("outbox")
+		assert.NoError(t, err)
+		err = writeSFTPFileContent("/outbox/file1.dat", content, client)
+		assert.NoError(t, err)
+		data, err := os.ReadFile(filepath.Join(partner.GetHomeDir(), "inbox", "file1.dat"))
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		_, err = client.Stat("/outbox/file1.dat")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat("/sent/file1.dat")
+		assert.NoError(t, err)
+		partner, _, err = httpdtest.GetUserByUsername(partner.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, partner.UsedQuotaFiles)
+		assert.Equal(t, int64(len(content)), partner.UsedQuotaSize)
+		// transfer to a remote SFTP filesystem
+		action1.Options.FsConfig.Transfer = dataprovider.EventActionFsTransfer{
+			Paths: []dataprovider.KeyValue{
+				{
+					Key:   "/{{VirtualPath}}",
+					Value: "/remote/{{ObjectName}}",
+				},
+			},
+			TargetFs: vfs.Filesystem{
+				Provider: sdk.SFTPFilesystemProvider,
+				SFTPConfig: vfs.SFTPFsConfig{
+					BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+						Endpoint: sftpServerAddr,
+						Username: partner.Username,
+					},
+					Password: kms.NewPlainSecret(defaultPassword),
+				},
+			},
+			Retries:        1,
+			VerifyChecksum: true,
+			DeleteSource:   true,
+		}
+		_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+		assert.NoError(t, err)
+		err = writeSFTPFileContent("/outbox/file2.dat", content, client)
+		assert.NoError(t, err)
+		data, err = os.ReadFile(filepath.Join(partner.GetHomeDir(), "remote", "file2.dat"))
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+		_, err = client.Stat("/outbox/file2.dat")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat("/sent/file2.dat")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		// the target user does not exist, the upload fails and the source is removed
+		action1.Options.FsConfig.Transfer = dataprovider.EventActionFsTransfer{
+			Paths: []dataprovider.KeyValue{
+				{
+					Key:   "/{{VirtualPath}}",
+					Value: "/inbox/{{ObjectName}}",
+				},
+			},
+			TargetUser: "missing_user",
+		}
+		_, _, err = httpdtest.UpdateEventAction(action1, http.StatusOK)
+		assert.NoError(t, err)
+		err = writeSFTPFileContent("/outbox/file3.dat", content, client)
+		assert.Error(t, err)
+		_, err = os.Stat(filepath.Join(partner.GetHomeDir(), "inbox", "file3.dat"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	_, err = httpdtest.RemoveEventRule(rule1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveEventAction(action1, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(partner, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(partner.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestEventActionEmailAttachments(t *testing.T) {
 	smtpCfg := smtp.Config{
 		Host:          "127.0.0.1",
@@ -6831,6 +7283,64 @@ func isDbDefenderSupported() bool {
 	}
 }
 
+func writeSFTPFileContent(name string, content []byte, client *sftp.Client) error {
+	f, err := client.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, bytes.NewBuffer(content))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func getTestZipArchive(entries map[string][]byte) ([]byte, error) {
+	var b bytes.Buffer
+	wr := zip.NewWriter(&b)
+	for name, content := range entries {
+		f, err := wr.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	err := wr.Close()
+	return b.Bytes(), err
+}
+
+func getTestTarGzArchive(entries map[string][]byte) ([]byte, error) {
+	var b bytes.Buffer
+	gzWriter := gzip.NewWriter(&b)
+	wr := tar.NewWriter(gzWriter)
+	for name, content := range entries {
+		typeFlag := byte(tar.TypeReg)
+		if strings.HasSuffix(name, "/") {
+			typeFlag = tar.TypeDir
+		}
+		err := wr.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+			Typeflag: typeFlag,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = wr.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := wr.Close(); err != nil {
+		return nil, err
+	}
+	err := gzWriter.Close()
+	return b.Bytes(), err
+}
+
 func getEncryptedFileSize(size int64) (int64, error) {
 	encSize, err := sio.EncryptedSize(uint64(size))
 	return int64(encSize) + 33, err
@@ -357,7 +357,7 @@ func (t *BaseTransfer) Close() error {
 	numFiles := t.getUploadedFiles()
 	metric.TransferCompleted(t.BytesSent.Load(), t.BytesReceived.Load(),
 		t.transferType, t.ErrTransfer, vfs.IsSFTPFs(t.Fs))
-	if t.transferQuota.HasSizeLimits() {
+	if t.transferQuota.HasSizeLimits() && !t.isChunkedUpload() {
 		dataprovider.UpdateUserTransferQuota(&t.Connection.User, t.BytesReceived.Load(), //nolint:errcheck
 			t.BytesSent.Load(), false)
 	}
@@ -394,6 +394,9 @@ func (t *BaseTransfer) Close() error {
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
 		ExecuteActionNotification(t.Connection, operationDownload, t.fsPath, t.requestPath, "", "", "", //nolint:errcheck
 			t.BytesSent.Load(), t.ErrTransfer)
+	} else if t.isChunkedUpload() {
+		t.Connection.Log(logger.LevelDebug, "chunk upload completed, fs path %q, size %d, elapsed: %d ms",
+			t.fsPath, t.BytesReceived.Load(), elapsed)
 	} else {
 		statSize, deletedFiles, errStat := t.getUploadFileSize()
 		if errStat == nil {
@@ -425,7 +428,7 @@ func (t *BaseTransfer) Close() error {
 }
 
 func (t *BaseTransfer) updateTransferTimestamps(uploadFileSize int64) {
-	if t.ErrTransfer != nil {
+	if t.ErrTransfer != nil || t.isChunkedUpload() {
 		return
 	}
 	if t.transferType == TransferUpload {
@@ -468,6 +471,13 @@ func (t *BaseTransfer) executeUploadHook(numFiles int, fileSize int64) (int, int
 	return numFiles, fileSize
 }
 
+// isChunkedUpload returns true if the transfer stores a chunk for a WebDAV
+// chunked upload. Chunks are not included in the quota and don't trigger any
+// action, the assembled file is handled as a normal upload
+func (t *BaseTransfer) isChunkedUpload() bool {
+	return t.transferType == TransferUpload && vfs.IsChunkedUploadsPath(t.requestPath)
+}
+
 func (t *BaseTransfer) getUploadedFiles() int {
 	numFiles := 0
 	if t.isNewFile {
@@ -0,0 +1,402 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	// ErrWebDAVLocked is returned if a resource is locked by a WebDAV client
+	ErrWebDAVLocked = errors.New("the resource is locked")
+	// WebDAVLockMaxDuration defines the maximum duration for WebDAV locks, locks
+	// with an infinite or longer timeout are limited to this duration
+	WebDAVLockMaxDuration      = 24 * time.Hour
+	webDAVLocks                = newWebDAVLockStore(0)
+	webDAVLocksMu              sync.Mutex
+	webDAVLocksCleanupInterval = 10 * time.Minute
+)
+
+// WebDAVLock defines a lock created by a WebDAV client.
+// Locks are bound to a user and Root is a virtual path.
+// If the lock root was resolved, FsName and FsPath identify the locked
+// resource on the storage backend, so the lock also applies to other users
+// that access the same resource, for example using a shared virtual folder
+type WebDAVLock struct {
+	Token     string `json:"token"`
+	Username  string `json:"username"`
+	Root      string `json:"root"`
+	FsName    string `json:"fs_name,omitempty"`
+	FsPath    string `json:"fs_path,omitempty"`
+	ZeroDepth bool   `json:"zero_depth"`
+	OwnerXML  string `json:"owner_xml,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// NewWebDAVLock returns a new lock for the specified user and virtual path
+func NewWebDAVLock(username, root string, zeroDepth bool, ownerXML string, duration time.Duration) *WebDAVLock {
+	now := time.Now()
+	lock := &WebDAVLock{
+		Token:     fmt.Sprintf("opaquelocktoken:%s", util.GenerateUniqueID()),
+		Username:  username,
+		Root:      root,
+		ZeroDepth: zeroDepth,
+		OwnerXML:  ownerXML,
+		CreatedAt: util.GetTimeAsMsSinceEpoch(now),
+	}
+	lock.setExpiration(now, duration)
+	return lock
+}
+
+// SetFsPath sets the storage backend name and the resolved path for the lock root
+func (l *WebDAVLock) SetFsPath(fsName, fsPath string) {
+	l.FsName = fsName
+	l.FsPath = filepath.ToSlash(fsPath)
+}
+
+func (l *WebDAVLock) setExpiration(now time.Time, duration time.Duration) {
+	if duration < 0 || duration > WebDAVLockMaxDuration {
+		duration = WebDAVLockMaxDuration
+	}
+	l.ExpiresAt = util.GetTimeAsMsSinceEpoch(now.Add(duration))
+}
+
+// GetDuration returns the remaining lock duration
+func (l *WebDAVLock) GetDuration() time.Duration {
+	return time.Until(util.GetTimeFromMsecSinceEpoch(l.ExpiresAt))
+}
+
+func (l *WebDAVLock) isExpired() bool {
+	return l.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// Covers returns true if the lock applies to the specified virtual path
+func (l *WebDAVLock) Covers(name string) bool {
+	return isLockedPath(l.Root, name, l.ZeroDepth)
+}
+
+func (l *WebDAVLock) coversFsPath(fsName, fsPath string) bool {
+	if l.FsName == "" || l.FsName != fsName {
+		return false
+	}
+	return isLockedPath(l.FsPath, fsPath, l.ZeroDepth)
+}
+
+func (l *WebDAVLock) conflictsWith(other *WebDAVLock) bool {
+	if l.Token == other.Token {
+		return false
+	}
+	if l.coversFsPath(other.FsName, other.FsPath) || other.coversFsPath(l.FsName, l.FsPath) {
+		return true
+	}
+	if l.Username != other.Username {
+		return false
+	}
+	return l.Covers(other.Root) || other.Covers(l.Root)
+}
+
+func isLockedPath(root, name string, zeroDepth bool) bool {
+	if name == root {
+		return true
+	}
+	if zeroDepth {
+		return false
+	}
+	root = strings.TrimSuffix(root, "/")
+	return root == "" || strings.HasPrefix(name, root+"/")
+}
+
+// AddWebDAVLock stores the specified lock if it does not conflict with the existing ones
+func AddWebDAVLock(lock *WebDAVLock) error {
+	webDAVLocksMu.Lock()
+	defer webDAVLocksMu.Unlock()
+
+	locks, err := webDAVLocks.list()
+	if err != nil {
+		return err
+	}
+	if hasConflictingWebDAVLocks(locks, lock) {
+		return ErrWebDAVLocked
+	}
+	if err := webDAVLocks.add(lock); err != nil {
+		return err
+	}
+	if !webDAVLocks.isShared() {
+		return nil
+	}
+	// another instance could have added a conflicting lock after our check
+	locks, err = webDAVLocks.list()
+	if err == nil && !hasConflictingWebDAVLocks(locks, lock) {
+		return nil
+	}
+	webDAVLocks.delete(lock.Token) //nolint:errcheck
+	if err != nil {
+		return err
+	}
+	return ErrWebDAVLocked
+}
+
+// RefreshWebDAVLock updates the expiration for the lock with the specified token
+func RefreshWebDAVLock(token string, duration time.Duration) (*WebDAVLock, error) {
+	webDAVLocksMu.Lock()
+	defer webDAVLocksMu.Unlock()
+
+	lock, err := webDAVLocks.get(token)
+	if err != nil {
+		return nil, err
+	}
+	lock.setExpiration(time.Now(), duration)
+	return lock, webDAVLocks.add(lock)
+}
+
+// GetWebDAVLock returns the lock with the specified token
+func GetWebDAVLock(token string) (*WebDAVLock, error) {
+	return webDAVLocks.get(token)
+}
+
+// RemoveWebDAVLock removes the lock with the specified token
+func RemoveWebDAVLock(token string) error {
+	webDAVLocksMu.Lock()
+	defer webDAVLocksMu.Unlock()
+
+	if _, err := webDAVLocks.get(token); err != nil {
+		return err
+	}
+	return webDAVLocks.delete(token)
+}
+
+// RemoveWebDAVLocks removes all the locks for the specified user
+func RemoveWebDAVLocks(username string) {
+	locks, err := GetWebDAVLocks(username)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get WebDAV locks for user %q: %v", username, err)
+		return
+	}
+	for _, lock := range locks {
+		if err := RemoveWebDAVLock(lock.Token); err != nil {
+			logger.Warn(logSender, "", "unable to remove WebDAV lock %q for user %q: %v", lock.Token, username, err)
+		}
+	}
+}
+
+// GetWebDAVLocks returns the active WebDAV locks. If username is not empty
+// only the locks for the specified user are returned
+func GetWebDAVLocks(username string) ([]WebDAVLock, error) {
+	locks, err := webDAVLocks.list()
+	if err != nil {
+		return nil, err
+	}
+	if username == "" {
+		return locks, nil
+	}
+	result := make([]WebDAVLock, 0, len(locks))
+	for _, lock := range locks {
+		if lock.Username == username {
+			result = append(result, lock)
+		}
+	}
+	return result, nil
+}
+
+// isWebDAVLocked returns true if the specified virtual path is locked for the
+// given user or if the specified resolved path is locked by any user.
+// If checkChildren is true the locks for the contents of the path are
+// considered too
+func isWebDAVLocked(username, virtualPath, fsName, fsPath string, checkChildren bool) bool {
+	locks, err := webDAVLocks.list()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get WebDAV locks: %v", err)
+		return false
+	}
+	lock := &WebDAVLock{
+		Username:  username,
+		Root:      virtualPath,
+		ZeroDepth: !checkChildren,
+	}
+	if fsName != "" {
+		lock.SetFsPath(fsName, fsPath)
+	}
+	return hasConflictingWebDAVLocks(locks, lock)
+}
+
+func hasConflictingWebDAVLocks(locks []WebDAVLock, lock *WebDAVLock) bool {
+	for idx := range locks {
+		if locks[idx].conflictsWith(lock) {
+			return true
+		}
+	}
+	return false
+}
+
+func cleanupWebDAVLocks() {
+	webDAVLocks.cleanup()
+}
+
+func newWebDAVLockStore(isShared int) webDAVLockStore {
+	if isShared == 1 {
+		return &webDAVLockStoreDB{}
+	}
+	return &webDAVLockStoreMem{
+		locks: make(map[string]WebDAVLock),
+	}
+}
+
+type webDAVLockStore interface {
+	add(lock *WebDAVLock) error
+	get(token string) (*WebDAVLock, error)
+	delete(token string) error
+	list() ([]WebDAVLock, error)
+	cleanup()
+	isShared() bool
+}
+
+type webDAVLockStoreMem struct {
+	sync.RWMutex
+	locks map[string]WebDAVLock
+}
+
+func (s *webDAVLockStoreMem) add(lock *WebDAVLock) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.locks[lock.Token] = *lock
+	return nil
+}
+
+func (s *webDAVLockStoreMem) get(token string) (*WebDAVLock, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	lock, ok := s.locks[token]
+	if !ok || lock.isExpired() {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("lock %q not found", token))
+	}
+	return &lock, nil
+}
+
+func (s *webDAVLockStoreMem) delete(token string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.locks, token)
+	return nil
+}
+
+func (s *webDAVLockStoreMem) list() ([]WebDAVLock, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	locks := make([]WebDAVLock, 0, len(s.locks))
+	for _, lock := range s.locks {
+		if !lock.isExpired() {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (s *webDAVLockStoreMem) cleanup() {
+	s.Lock()
+	defer s.Unlock()
+
+	for token, lock := range s.locks {
+		if lock.isExpired() {
+			delete(s.locks, token)
+		}
+	}
+}
+
+func (s *webDAVLockStoreMem) isShared() bool {
+	return false
+}
+
+type webDAVLockStoreDB struct{}
+
+func (s *webDAVLockStoreDB) add(lock *WebDAVLock) error {
+	session := dataprovider.Session{
+		Key:       lock.Token,
+		Data:      lock,
+		Type:      dataprovider.SessionTypeWebDAVLock,
+		Timestamp: lock.ExpiresAt,
+	}
+	return dataprovider.AddSharedSession(session)
+}
+
+func (s *webDAVLockStoreDB) get(token string) (*WebDAVLock, error) {
+	session, err := dataprovider.GetSharedSession(token)
+	if err != nil {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("lock %q not found: %v", token, err))
+	}
+	if session.Type != dataprovider.SessionTypeWebDAVLock {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("lock %q not found", token))
+	}
+	lock, err := s.decodeData(session.Data)
+	if err != nil {
+		return nil, err
+	}
+	if lock.isExpired() {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("lock %q not found", token))
+	}
+	return lock, nil
+}
+
+func (s *webDAVLockStoreDB) delete(token string) error {
+	return dataprovider.DeleteSharedSession(token)
+}
+
+func (s *webDAVLockStoreDB) list() ([]WebDAVLock, error) {
+	sessions, err := dataprovider.GetSharedSessions(dataprovider.SessionTypeWebDAVLock, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]WebDAVLock, 0, len(sessions))
+	for _, session := range sessions {
+		lock, err := s.decodeData(session.Data)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to decode WebDAV lock %q: %v", session.Key, err)
+			continue
+		}
+		locks = append(locks, *lock)
+	}
+	return locks, nil
+}
+
+func (s *webDAVLockStoreDB) cleanup() {
+	dataprovider.CleanupSharedSessions(dataprovider.SessionTypeWebDAVLock, time.Now()) //nolint:errcheck
+}
+
+func (s *webDAVLockStoreDB) isShared() bool {
+	return true
+}
+
+func (s *webDAVLockStoreDB) decodeData(data any) (*WebDAVLock, error) {
+	if val, ok := data.([]byte); ok {
+		lock := &WebDAVLock{}
+		err := json.Unmarshal(val, lock)
+		return lock, err
+	}
+	return nil, fmt.Errorf("invalid WebDAV lock data type %T", data)
+}
@@ -0,0 +1,306 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestWebDAVLockCovers(t *testing.T) {
+	lock := NewWebDAVLock("user", "/dir", false, "", time.Minute)
+	assert.True(t, strings.HasPrefix(lock.Token, "opaquelocktoken:"))
+	assert.True(t, lock.Covers("/dir"))
+	assert.True(t, lock.Covers("/dir/sub/file"))
+	assert.False(t, lock.Covers("/dir1"))
+	assert.False(t, lock.Covers("/"))
+	lock.ZeroDepth = true
+	assert.True(t, lock.Covers("/dir"))
+	assert.False(t, lock.Covers("/dir/file"))
+	lock = NewWebDAVLock("user", "/", false, "", -1)
+	assert.True(t, lock.Covers("/file"))
+	assert.LessOrEqual(t, lock.GetDuration(), WebDAVLockMaxDuration)
+	assert.Greater(t, lock.GetDuration(), WebDAVLockMaxDuration-time.Minute)
+
+	other := NewWebDAVLock("user1", "/file", true, "", time.Minute)
+	assert.False(t, lock.conflictsWith(other))
+	other.Username = lock.Username
+	assert.True(t, lock.conflictsWith(other))
+	assert.True(t, other.conflictsWith(lock))
+	assert.False(t, lock.conflictsWith(lock))
+	// locks for different users conflict if they refer to the same resource
+	lock = NewWebDAVLock("user", "/dir", false, "", time.Minute)
+	lock.SetFsPath("osfs", filepath.Join(os.TempDir(), "shared"))
+	other = NewWebDAVLock("user1", "/vdir/file", true, "", time.Minute)
+	other.SetFsPath("osfs", filepath.Join(os.TempDir(), "shared", "file"))
+	assert.True(t, lock.conflictsWith(other))
+	assert.True(t, other.conflictsWith(lock))
+	other.SetFsPath("osfs", filepath.Join(os.TempDir(), "shared1"))
+	assert.False(t, lock.conflictsWith(other))
+	other.SetFsPath("S3Fs bucket \"b\"", filepath.Join(os.TempDir(), "shared"))
+	assert.False(t, lock.conflictsWith(other))
+	lock.ZeroDepth = true
+	other.SetFsPath("osfs", filepath.Join(os.TempDir(), "shared", "file"))
+	assert.False(t, lock.conflictsWith(other))
+	assert.False(t, other.conflictsWith(lock))
+	lock.SetFsPath("S3Fs", "")
+	lock.ZeroDepth = false
+	assert.True(t, lock.coversFsPath("S3Fs", "dir/file"))
+}
+
+func TestWebDAVLocksMemoryStore(t *testing.T) {
+	oldStore := webDAVLocks
+	webDAVLocks = newWebDAVLockStore(0)
+	defer func() {
+		webDAVLocks = oldStore
+	}()
+
+	lock1 := NewWebDAVLock("user", "/dir", false, "<owner/>", time.Minute)
+	err := AddWebDAVLock(lock1)
+	assert.NoError(t, err)
+	err = AddWebDAVLock(NewWebDAVLock("user", "/dir/file", true, "", time.Minute))
+	assert.ErrorIs(t, err, ErrWebDAVLocked)
+	err = AddWebDAVLock(NewWebDAVLock("user", "/", false, "", time.Minute))
+	assert.ErrorIs(t, err, ErrWebDAVLocked)
+	lock2 := NewWebDAVLock("user", "/", true, "", time.Minute)
+	err = AddWebDAVLock(lock2)
+	assert.NoError(t, err)
+	lock3 := NewWebDAVLock("user1", "/dir", false, "", time.Minute)
+	err = AddWebDAVLock(lock3)
+	assert.NoError(t, err)
+
+	locks, err := GetWebDAVLocks("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 3)
+	locks, err = GetWebDAVLocks("user1")
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, lock3.Token, locks[0].Token)
+	}
+
+	assert.True(t, isWebDAVLocked("user", "/dir/file", "", "", false))
+	assert.True(t, isWebDAVLocked("user", "/", "", "", false))
+	assert.False(t, isWebDAVLocked("user", "/file", "", "", false))
+	assert.False(t, isWebDAVLocked("user2", "/dir", "", "", true))
+
+	lock, err := GetWebDAVLock(lock1.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, lock1.OwnerXML, lock.OwnerXML)
+	lock, err = RefreshWebDAVLock(lock1.Token, time.Hour)
+	assert.NoError(t, err)
+	assert.Greater(t, lock.ExpiresAt, lock1.ExpiresAt)
+	_, err = RefreshWebDAVLock("missing", time.Hour)
+	assert.Error(t, err)
+
+	err = RemoveWebDAVLock(lock1.Token)
+	assert.NoError(t, err)
+	err = RemoveWebDAVLock(lock1.Token)
+	assert.Error(t, err)
+	_, err = GetWebDAVLock(lock1.Token)
+	var nfErr *util.RecordNotFoundError
+	assert.True(t, errors.As(err, &nfErr))
+	assert.False(t, isWebDAVLocked("user", "/dir/file", "", "", false))
+	assert.False(t, isWebDAVLocked("user", "/dir/file", "", "", true))
+	assert.True(t, isWebDAVLocked("user", "/", "", "", true))
+	// expired locks are ignored and then removed
+	lock4 := NewWebDAVLock("user", "/expired", false, "", time.Minute)
+	lock4.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Second))
+	err = AddWebDAVLock(lock4)
+	assert.NoError(t, err)
+	_, err = GetWebDAVLock(lock4.Token)
+	assert.Error(t, err)
+	assert.False(t, isWebDAVLocked("user", "/expired", "", "", false))
+	store, ok := webDAVLocks.(*webDAVLockStoreMem)
+	require.True(t, ok)
+	assert.Len(t, store.locks, 3)
+	cleanupWebDAVLocks()
+	assert.Len(t, store.locks, 2)
+	RemoveWebDAVLocks("user")
+	locks, err = GetWebDAVLocks("")
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, lock3.Token, locks[0].Token)
+	}
+}
+
+func TestWebDAVLocksEnforcement(t *testing.T) {
+	oldStore := webDAVLocks
+	webDAVLocks = newWebDAVLockStore(0)
+	defer func() {
+		webDAVLocks = oldStore
+		Config.EnforceWebDAVLocks = 0
+	}()
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: userTestUsername,
+			HomeDir:  filepath.Join(os.TempDir(), userTestUsername),
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	err := os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+
+	lock := NewWebDAVLock(user.Username, "/locked", false, "", time.Minute)
+	err = AddWebDAVLock(lock)
+	assert.NoError(t, err)
+
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", user)
+	webDAVConn := NewBaseConnection("", ProtocolWebDAV, "", "", user)
+	err = conn.CheckWebDAVLocks("/locked/file", false)
+	assert.NoError(t, err)
+	err = conn.CreateDir("/locked", true)
+	assert.NoError(t, err)
+
+	Config.EnforceWebDAVLocks = 1
+	err = conn.CheckWebDAVLocks("/locked/file", false)
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	assert.ErrorIs(t, err, conn.GetPermissionDeniedError())
+	err = conn.CheckWebDAVLocks("/", false)
+	assert.NoError(t, err)
+	err = conn.CheckWebDAVLocks("/", true)
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = webDAVConn.CheckWebDAVLocks("/locked/file", false)
+	assert.NoError(t, err)
+	err = conn.CreateDir("/locked/sub", true)
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = conn.Rename("/locked", "/renamed")
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = conn.RemoveDir("/locked")
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = ExecutePreAction(conn, OperationPreUpload, filepath.Join(user.GetHomeDir(), "locked", "file"),
+		"/locked/file", 0, 0)
+	assert.NoError(t, err)
+	// the locks apply to other users accessing the same resource
+	otherUser := user
+	otherUser.Username += "_other"
+	otherConn := NewBaseConnection("", ProtocolSFTP, "", "", otherUser)
+	err = otherConn.CheckWebDAVLocks("/shared/file", false)
+	assert.NoError(t, err)
+	lock1 := NewWebDAVLock(user.Username, "/shared/file", true, "", time.Minute)
+	lock1.SetFsPath(vfs.NewOsFs("", user.GetHomeDir(), "").Name(), filepath.Join(user.GetHomeDir(), "shared", "file"))
+	err = AddWebDAVLock(lock1)
+	assert.NoError(t, err)
+	err = otherConn.CheckWebDAVLocks("/shared/file", false)
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = otherConn.CheckWebDAVLocks("/shared", true)
+	assert.ErrorContains(t, err, ErrWebDAVLocked.Error())
+	err = otherConn.CheckWebDAVLocks("/shared", false)
+	assert.NoError(t, err)
+	err = RemoveWebDAVLock(lock1.Token)
+	assert.NoError(t, err)
+
+	err = RemoveWebDAVLock(lock.Token)
+	assert.NoError(t, err)
+	err = conn.RemoveDir("/locked")
+	assert.NoError(t, err)
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestWebDAVLocksDBStore(t *testing.T) {
+	if !isDbTransferCheckerSupported() {
+		t.Skip("this test is not supported with the current database provider")
+	}
+	providerConf := dataprovider.GetProviderConfig()
+	err := dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.IsShared = 1
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	oldStore := webDAVLocks
+	webDAVLocks = newWebDAVLockStore(1)
+	defer func() {
+		webDAVLocks = oldStore
+	}()
+	_, ok := webDAVLocks.(*webDAVLockStoreDB)
+	assert.True(t, ok)
+
+	lock1 := NewWebDAVLock("user", "/dir", false, "<owner/>", time.Minute)
+	err = AddWebDAVLock(lock1)
+	assert.NoError(t, err)
+	err = AddWebDAVLock(NewWebDAVLock("user", "/dir/file", true, "", time.Minute))
+	assert.ErrorIs(t, err, ErrWebDAVLocked)
+	lock2 := NewWebDAVLock("user1", "/dir", true, "", time.Minute)
+	err = AddWebDAVLock(lock2)
+	assert.NoError(t, err)
+	locks, err := GetWebDAVLocks("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 2)
+	lock, err := GetWebDAVLock(lock1.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, lock1.Root, lock.Root)
+	assert.Equal(t, lock1.OwnerXML, lock.OwnerXML)
+	assert.False(t, lock.ZeroDepth)
+	lock, err = RefreshWebDAVLock(lock1.Token, time.Hour)
+	assert.NoError(t, err)
+	assert.Greater(t, lock.ExpiresAt, lock1.ExpiresAt)
+	assert.True(t, isWebDAVLocked("user", "/dir/file", "", "", false))
+	// a session with a different type is not a lock
+	err = dataprovider.AddSharedSession(dataprovider.Session{
+		Key:       "notalock",
+		Data:      "{}",
+		Type:      dataprovider.SessionTypeOIDCAuth,
+		Timestamp: util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Hour)),
+	})
+	assert.NoError(t, err)
+	_, err = GetWebDAVLock("notalock")
+	assert.Error(t, err)
+	err = dataprovider.DeleteSharedSession("notalock")
+	assert.NoError(t, err)
+
+	err = RemoveWebDAVLock(lock1.Token)
+	assert.NoError(t, err)
+	err = RemoveWebDAVLock(lock1.Token)
+	assert.Error(t, err)
+	// expired locks are ignored and then removed
+	lock3 := NewWebDAVLock("user", "/expired", false, "", time.Minute)
+	lock3.ExpiresAt = util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Second))
+	err = AddWebDAVLock(lock3)
+	assert.NoError(t, err)
+	_, err = GetWebDAVLock(lock3.Token)
+	assert.Error(t, err)
+	cleanupWebDAVLocks()
+	_, err = dataprovider.GetSharedSession(lock3.Token)
+	assert.Error(t, err)
+	err = RemoveWebDAVLock(lock2.Token)
+	assert.NoError(t, err)
+	locks, err = GetWebDAVLocks("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	_, err = GetWebDAVLocks("")
+	assert.Error(t, err)
+	assert.False(t, isWebDAVLocked("user", "/", "", "", true))
+	providerConf.IsShared = 0
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
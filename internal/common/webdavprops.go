@@ -0,0 +1,137 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"path"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// GetWebDAVDeadProps returns the WebDAV dead properties stored for the specified
+// virtual path. Nil is returned if there are no stored properties
+func (c *BaseConnection) GetWebDAVDeadProps(virtualPath string) ([]byte, error) {
+	if c.User.GetWebDAVDeadPropsMaxSize() == 0 {
+		return nil, nil
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	if holder, ok := fs.(vfs.FsPropertiesHolder); ok {
+		data, err := holder.GetProperties(fsPath)
+		if !errors.Is(err, vfs.ErrVfsUnsupported) {
+			return data, c.GetFsError(fs, err)
+		}
+	}
+	return getWebDAVDeadProps(c.User.Username, virtualPath)
+}
+
+// SetWebDAVDeadProps stores the WebDAV dead properties for the specified virtual
+// path replacing the existing ones. Empty data removes the stored properties
+func (c *BaseConnection) SetWebDAVDeadProps(virtualPath string, data []byte) error {
+	maxSize := c.User.GetWebDAVDeadPropsMaxSize()
+	if maxSize == 0 {
+		return c.GetOpUnsupportedError()
+	}
+	if int64(len(data)) > maxSize {
+		c.Log(logger.LevelInfo, "unable to store WebDAV dead properties for %q, size %d exceeds the limit %d",
+			virtualPath, len(data), maxSize)
+		return vfs.ErrPropertiesTooLarge
+	}
+	if !c.User.HasAnyPerm([]string{dataprovider.PermUpload, dataprovider.PermOverwrite}, path.Dir(virtualPath)) {
+		return c.GetPermissionDeniedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	if holder, ok := fs.(vfs.FsPropertiesHolder); ok {
+		err := holder.SetProperties(fsPath, data)
+		if !errors.Is(err, vfs.ErrVfsUnsupported) {
+			if err != nil {
+				c.Log(logger.LevelWarn, "unable to store WebDAV dead properties for %q: %v", virtualPath, err)
+			}
+			return err
+		}
+	}
+	return setWebDAVDeadProps(c.User.Username, virtualPath, data)
+}
+
+// hasWebDAVDeadPropsInProvider returns true if the WebDAV dead properties for
+// the filesystem containing the specified existing path are stored within the
+// data provider and so they must be updated after renames and deletes
+func (c *BaseConnection) hasWebDAVDeadPropsInProvider(fs vfs.Fs, fsPath string) bool {
+	if c.User.GetWebDAVDeadPropsMaxSize() == 0 {
+		return false
+	}
+	if holder, ok := fs.(vfs.FsPropertiesHolder); ok {
+		_, err := holder.GetProperties(fsPath)
+		return errors.Is(err, vfs.ErrVfsUnsupported)
+	}
+	return true
+}
+
+func (c *BaseConnection) moveWebDAVDeadProps(fs vfs.Fs, fsTargetPath, virtualSourcePath, virtualTargetPath string) {
+	if !c.hasWebDAVDeadPropsInProvider(fs, fsTargetPath) {
+		return
+	}
+	if err := moveWebDAVDeadProps(c.User.Username, virtualSourcePath, virtualTargetPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to move WebDAV dead properties %q -> %q: %v", virtualSourcePath,
+			virtualTargetPath, err)
+	}
+}
+
+func (c *BaseConnection) removeWebDAVDeadProps(fs vfs.Fs, fsPath, virtualPath string) {
+	// the path is already removed, we check the parent directory
+	if !c.hasWebDAVDeadPropsInProvider(fs, fs.Join(fsPath, "..")) {
+		return
+	}
+	if err := removeWebDAVDeadProps(c.User.Username, virtualPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to remove WebDAV dead properties for %q: %v", virtualPath, err)
+	}
+}
+
+func getWebDAVDeadProps(username, virtualPath string) ([]byte, error) {
+	data, err := dataprovider.GetWebDAVProps(username, virtualPath)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func setWebDAVDeadProps(username, virtualPath string, data []byte) error {
+	return dataprovider.SetWebDAVProps(username, virtualPath, data)
+}
+
+// removeWebDAVDeadProps removes the properties for the specified virtual path
+// and, if it is a directory, for its children
+func removeWebDAVDeadProps(username, virtualPath string) error {
+	return dataprovider.DeleteWebDAVProps(username, virtualPath)
+}
+
+// moveWebDAVDeadProps moves the properties for the source path and its children
+// to the target path. The properties for an overwritten target, if any, are no
+// longer valid and so they are removed
+func moveWebDAVDeadProps(username, source, target string) error {
+	return dataprovider.RenameWebDAVProps(username, source, target)
+}
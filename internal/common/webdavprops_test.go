@@ -0,0 +1,191 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+func TestWebDAVDeadPropsProviderStore(t *testing.T) {
+	err := setWebDAVDeadProps("missing_davprops_user", "/file", []byte("data"))
+	assert.Error(t, err)
+
+	var users []dataprovider.User
+	for _, username := range []string{"davprops_user", "davprops_user1"} {
+		user := dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: username,
+				Password: "test_pwd",
+				HomeDir:  filepath.Join(os.TempDir(), username),
+				Status:   1,
+				Permissions: map[string][]string{
+					"/": {dataprovider.PermAny},
+				},
+			},
+		}
+		err = dataprovider.AddUser(&user, "", "")
+		require.NoError(t, err)
+		users = append(users, user)
+	}
+	user := users[0].Username
+	user1 := users[1].Username
+
+	err = setWebDAVDeadProps(user, "/file", []byte("file"))
+	assert.NoError(t, err)
+	err = setWebDAVDeadProps(user, "/dir", []byte("dir"))
+	assert.NoError(t, err)
+	err = setWebDAVDeadProps(user, "/dir/sub/file", []byte("nested"))
+	assert.NoError(t, err)
+	err = setWebDAVDeadProps(user, "/dir_1/file", []byte("other"))
+	assert.NoError(t, err)
+	err = setWebDAVDeadProps(user, "/DIR/file", []byte("case"))
+	assert.NoError(t, err)
+	err = setWebDAVDeadProps(user1, "/dir/file", []byte("user1"))
+	assert.NoError(t, err)
+
+	data, err := getWebDAVDeadProps(user, "/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file"), data)
+	data, err = getWebDAVDeadProps(user, "/missing")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	// a file rename overwriting a target with stored properties
+	err = moveWebDAVDeadProps(user, "/file", "/dir_1/file")
+	assert.NoError(t, err)
+	data, err = getWebDAVDeadProps(user, "/dir_1/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file"), data)
+	data, err = getWebDAVDeadProps(user, "/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	// the source has no properties, the ones for the target must be removed
+	err = moveWebDAVDeadProps(user, "/file", "/dir_1/file")
+	assert.NoError(t, err)
+	data, err = getWebDAVDeadProps(user, "/dir_1/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	// directory rename
+	err = moveWebDAVDeadProps(user, "/dir", "/newdir")
+	assert.NoError(t, err)
+	data, err = getWebDAVDeadProps(user, "/newdir")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("dir"), data)
+	data, err = getWebDAVDeadProps(user, "/newdir/sub/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("nested"), data)
+	data, err = getWebDAVDeadProps(user, "/dir/sub/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	data, err = getWebDAVDeadProps(user, "/DIR/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("case"), data)
+	data, err = getWebDAVDeadProps(user1, "/dir/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user1"), data)
+	// empty properties remove the ones for the path but not for its children
+	err = setWebDAVDeadProps(user, "/newdir", nil)
+	assert.NoError(t, err)
+	data, err = getWebDAVDeadProps(user, "/newdir")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	data, err = getWebDAVDeadProps(user, "/newdir/sub/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("nested"), data)
+
+	err = removeWebDAVDeadProps(user, "/newdir")
+	assert.NoError(t, err)
+	err = removeWebDAVDeadProps(user, "/missing")
+	assert.NoError(t, err)
+	data, err = getWebDAVDeadProps(user, "/newdir/sub/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	data, err = getWebDAVDeadProps(user, "/DIR/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("case"), data)
+	// the properties are removed with the user
+	for _, u := range users {
+		err = dataprovider.DeleteUser(u.Username, "", "", "")
+		assert.NoError(t, err)
+	}
+	err = dataprovider.AddUser(&users[1], "", "")
+	require.NoError(t, err)
+	data, err = getWebDAVDeadProps(user1, "/dir/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	err = dataprovider.DeleteUser(user1, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestWebDAVDeadPropsConnection(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "davprops")
+	require.NoError(t, os.MkdirAll(homeDir, os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "davpropsuser",
+			HomeDir:  homeDir,
+			Permissions: map[string][]string{
+				"/":   {dataprovider.PermAny},
+				"/ro": {dataprovider.PermListItems, dataprovider.PermDownload},
+			},
+		},
+	}
+	conn := NewBaseConnection("", ProtocolWebDAV, "", "", u)
+	err := os.WriteFile(filepath.Join(homeDir, "file"), []byte("data"), 0666)
+	require.NoError(t, err)
+	// dead properties are disabled by default
+	err = conn.SetWebDAVDeadProps("/file", []byte("props"))
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+
+	conn.User.Filters.WebDAVDeadPropsMaxSize = 1024
+	err = conn.SetWebDAVDeadProps("/ro/file", []byte("props"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	err = conn.SetWebDAVDeadProps("/file", make([]byte, 1025))
+	assert.ErrorIs(t, err, vfs.ErrPropertiesTooLarge)
+	fs, fsPath, err := conn.GetFsAndResolvedPath("/file")
+	require.NoError(t, err)
+	if _, err := fs.(vfs.FsPropertiesHolder).GetProperties(fsPath); errors.Is(err, vfs.ErrVfsUnsupported) {
+		t.Skip("extended attributes are not supported")
+	}
+	assert.False(t, conn.hasWebDAVDeadPropsInProvider(fs, fsPath))
+	err = conn.SetWebDAVDeadProps("/file", []byte("props"))
+	assert.NoError(t, err)
+	data, err := conn.GetWebDAVDeadProps("/file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("props"), data)
+	err = conn.SetWebDAVDeadProps("/file", nil)
+	assert.NoError(t, err)
+	data, err = conn.GetWebDAVDeadProps("/file")
+	assert.NoError(t, err)
+	assert.Len(t, data, 0)
+
+	conn.User.Filters.WebDAVDeadPropsMaxSize = -1
+	err = conn.SetWebDAVDeadProps("/file", []byte("props"))
+	assert.ErrorIs(t, err, ErrOpUnsupported)
+	data, err = conn.GetWebDAVDeadProps("/file")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
@@ -16,6 +16,7 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"strconv"
 	"strings"
 
+	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/spf13/viper"
 	"github.com/subosito/gotenv"
 
@@ -57,6 +59,7 @@ const (
 
 var (
 	globalConf             globalConfig
+	hookSigningSecret      []byte
 	defaultSFTPDBanner     = fmt.Sprintf("SFTPGo_%v", version.Get().Version)
 	defaultFTPDBanner      = fmt.Sprintf("SFTPGo %v ready", version.Get().Version)
 	defaultInstallCodeHint = "Installation code"
@@ -79,6 +82,10 @@ var (
 		TLSCipherSuites:            nil,
 		PassiveConnectionsSecurity: 0,
 		ActiveConnectionsSecurity:  0,
+		DisableASCIIMode:           false,
+		TLSSessionReuse:            0,
+		TLSSessionCacheSize:        0,
+		TLSSessionLifetime:         0,
 		Debug:                      false,
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
@@ -211,6 +218,7 @@ func Init() {
 			MaxPerHostConnections: 20,
 			WhiteListFile:         "",
 			AllowSelfConnections:  0,
+			EnforceWebDAVLocks:    0,
 			DefenderConfig: common.DefenderConfig{
 				Enabled:            false,
 				Driver:             common.DefenderDriverMemory,
@@ -251,6 +259,8 @@ func Init() {
 			MaxAuthTries:                      0,
 			Banner:                            defaultSFTPDBanner,
 			HostKeys:                          []string{},
+			AdvertisedHostKeys:                []string{},
+			HostKeysRotationFile:              "",
 			HostCertificates:                  []string{},
 			HostKeyAlgorithms:                 []string{},
 			Moduli:                            []string{},
@@ -264,7 +274,14 @@ func Init() {
 			KeyboardInteractiveAuthentication: false,
 			KeyboardInteractiveHook:           "",
 			PasswordAuthentication:            true,
+			RestrictedShell:                   false,
 			FolderPrefix:                      "",
+			UserCA: sftpd.UserCA{
+				Enabled:         false,
+				KeyPath:         "user_ca_key",
+				DefaultValidity: 60,
+				MaxValidity:     1440,
+			},
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -275,14 +292,17 @@ func Init() {
 				Start: 50000,
 				End:   50100,
 			},
-			DisableActiveMode:  false,
-			EnableSite:         false,
-			HASHSupport:        0,
-			CombineSupport:     0,
-			CertificateFile:    "",
-			CertificateKeyFile: "",
-			CACertificates:     []string{},
-			CARevocationLists:  []string{},
+			DisableActiveMode:       false,
+			EnableSite:              false,
+			HASHSupport:             0,
+			CombineSupport:          0,
+			SharedTLSSessionTickets: 0,
+			PassiveIPHook:           "",
+			PassiveIPHookCacheTime:  0,
+			CertificateFile:         "",
+			CertificateKeyFile:      "",
+			CACertificates:          []string{},
+			CARevocationLists:       []string{},
 		},
 		WebDAVD: webdavd.Configuration{
 			Bindings:           []webdavd.Binding{defaultWebDAVDBinding},
@@ -466,7 +486,11 @@ func Init() {
 
 // GetCommonConfig returns the common protocols configuration
 func GetCommonConfig() common.Configuration {
-	return globalConf.Common
+	c := globalConf.Common
+	if c.Actions.HookSigningSecret == nil {
+		c.Actions.HookSigningSecret = getHookSigningSecret()
+	}
+	return c
 }
 
 // SetCommonConfig sets the common protocols configuration
@@ -604,6 +628,9 @@ func getRedactedPassword(value string) string {
 func getRedactedGlobalConf() globalConfig {
 	conf := globalConf
 	conf.Common.Actions.Hook = util.GetRedactedURL(conf.Common.Actions.Hook)
+	if conf.Common.Actions.HookSigningSecret != nil && !conf.Common.Actions.HookSigningSecret.IsEmpty() {
+		conf.Common.Actions.HookSigningSecret = kms.NewSecret(sdkkms.SecretStatusRedacted, "", "", "")
+	}
 	conf.Common.StartupHook = util.GetRedactedURL(conf.Common.StartupHook)
 	conf.Common.PostConnectHook = util.GetRedactedURL(conf.Common.PostConnectHook)
 	conf.Common.PostDisconnectHook = util.GetRedactedURL(conf.Common.PostDisconnectHook)
@@ -726,6 +753,11 @@ func LoadConfig(configDir, configFile string) error {
 		logger.WarnToConsole("error parsing configuration file: %v", err)
 		return err
 	}
+	if err = loadHookSigningSecret(); err != nil {
+		logger.Warn(logSender, "", "error parsing configuration file: %v", err)
+		logger.WarnToConsole("error parsing configuration file: %v", err)
+		return err
+	}
 	// viper only supports slice of strings from env vars, so we use our custom method
 	loadBindingsFromEnv()
 	resetInvalidConfigs()
@@ -733,6 +765,65 @@ func LoadConfig(configDir, configFile string) error {
 	return nil
 }
 
+// loadHookSigningSecret parses the secret used to sign the HTTP hook requests.
+// The secret can be set as plain text or as a JSON encoded secret encrypted
+// using the built-in KMS, for example {"status":"Secretbox","payload":"...","key":"..."}.
+// The secret object is built in GetCommonConfig, after the KMS initialization
+func loadHookSigningSecret() error {
+	hookSigningSecret = nil
+	globalConf.Common.Actions.HookSigningSecret = nil
+	var secret kms.BaseSecret
+	switch val := viper.Get("common.actions.hook_signing_secret").(type) {
+	case nil:
+		return nil
+	case string:
+		val = strings.TrimSpace(val)
+		if val == "" {
+			return nil
+		}
+		if !strings.HasPrefix(val, "{") {
+			secret.Status = sdkkms.SecretStatusPlain
+			secret.Payload = val
+			break
+		}
+		if err := json.Unmarshal([]byte(val), &secret); err != nil {
+			return fmt.Errorf("invalid hook signing secret: %w", err)
+		}
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Errorf("invalid hook signing secret: %w", err)
+		}
+		if err := json.Unmarshal(data, &secret); err != nil {
+			return fmt.Errorf("invalid hook signing secret: %w", err)
+		}
+	}
+	if secret.Payload == "" {
+		return nil
+	}
+	switch secret.Status {
+	case sdkkms.SecretStatusPlain, sdkkms.SecretStatusAES256GCM, sdkkms.SecretStatusSecretBox:
+	default:
+		return fmt.Errorf("invalid hook signing secret, unsupported status %q", secret.Status)
+	}
+	data, err := json.Marshal(&secret)
+	if err != nil {
+		return err
+	}
+	hookSigningSecret = data
+	return nil
+}
+
+func getHookSigningSecret() *kms.Secret {
+	secret := kms.NewEmptySecret()
+	if len(hookSigningSecret) > 0 {
+		if err := secret.UnmarshalJSON(hookSigningSecret); err != nil {
+			logger.Error(logSender, "", "unable to load the hook signing secret: %v", err)
+		}
+	}
+	return secret
+}
+
 func isUploadModeValid() bool {
 	return globalConf.Common.UploadMode >= 0 && globalConf.Common.UploadMode <= 2
 }
@@ -1202,6 +1293,30 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	disableASCIIMode, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__DISABLE_ASCII_MODE", idx))
+	if ok {
+		binding.DisableASCIIMode = disableASCIIMode
+		isSet = true
+	}
+
+	tlsSessionReuse, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_SESSION_REUSE", idx))
+	if ok {
+		binding.TLSSessionReuse = int(tlsSessionReuse)
+		isSet = true
+	}
+
+	tlsSessionCacheSize, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_SESSION_CACHE_SIZE", idx))
+	if ok {
+		binding.TLSSessionCacheSize = int(tlsSessionCacheSize)
+		isSet = true
+	}
+
+	tlsSessionLifetime, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_SESSION_LIFETIME", idx))
+	if ok {
+		binding.TLSSessionLifetime = int(tlsSessionLifetime)
+		isSet = true
+	}
+
 	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__DEBUG", idx))
 	if ok {
 		binding.Debug = debug
@@ -1921,6 +2036,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.actions.execute_on", globalConf.Common.Actions.ExecuteOn)
 	viper.SetDefault("common.actions.execute_sync", globalConf.Common.Actions.ExecuteSync)
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
+	viper.SetDefault("common.actions.hook_signing_secret", "")
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
 	viper.SetDefault("common.temp_path", globalConf.Common.TempPath)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
@@ -1932,6 +2048,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.max_per_host_connections", globalConf.Common.MaxPerHostConnections)
 	viper.SetDefault("common.whitelist_file", globalConf.Common.WhiteListFile)
 	viper.SetDefault("common.allow_self_connections", globalConf.Common.AllowSelfConnections)
+	viper.SetDefault("common.enforce_webdav_locks", globalConf.Common.EnforceWebDAVLocks)
 	viper.SetDefault("common.defender.enabled", globalConf.Common.DefenderConfig.Enabled)
 	viper.SetDefault("common.defender.driver", globalConf.Common.DefenderConfig.Driver)
 	viper.SetDefault("common.defender.ban_time", globalConf.Common.DefenderConfig.BanTime)
@@ -1960,6 +2077,8 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
 	viper.SetDefault("sftpd.banner", globalConf.SFTPD.Banner)
 	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
+	viper.SetDefault("sftpd.advertised_host_keys", globalConf.SFTPD.AdvertisedHostKeys)
+	viper.SetDefault("sftpd.host_keys_rotation_file", globalConf.SFTPD.HostKeysRotationFile)
 	viper.SetDefault("sftpd.host_certificates", globalConf.SFTPD.HostCertificates)
 	viper.SetDefault("sftpd.host_key_algorithms", globalConf.SFTPD.HostKeyAlgorithms)
 	viper.SetDefault("sftpd.moduli", globalConf.SFTPD.Moduli)
@@ -1968,11 +2087,16 @@ func setViperDefaults() {
 	viper.SetDefault("sftpd.macs", globalConf.SFTPD.MACs)
 	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
 	viper.SetDefault("sftpd.revoked_user_certs_file", globalConf.SFTPD.RevokedUserCertsFile)
+	viper.SetDefault("sftpd.user_ca.enabled", globalConf.SFTPD.UserCA.Enabled)
+	viper.SetDefault("sftpd.user_ca.key_path", globalConf.SFTPD.UserCA.KeyPath)
+	viper.SetDefault("sftpd.user_ca.default_validity", globalConf.SFTPD.UserCA.DefaultValidity)
+	viper.SetDefault("sftpd.user_ca.max_validity", globalConf.SFTPD.UserCA.MaxValidity)
 	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
 	viper.SetDefault("sftpd.enabled_ssh_commands", sftpd.GetDefaultSSHCommands())
 	viper.SetDefault("sftpd.keyboard_interactive_authentication", globalConf.SFTPD.KeyboardInteractiveAuthentication)
 	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
 	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
+	viper.SetDefault("sftpd.restricted_shell", globalConf.SFTPD.RestrictedShell)
 	viper.SetDefault("sftpd.folder_prefix", globalConf.SFTPD.FolderPrefix)
 	viper.SetDefault("ftpd.banner", globalConf.FTPD.Banner)
 	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
@@ -1983,6 +2107,9 @@ func setViperDefaults() {
 	viper.SetDefault("ftpd.enable_site", globalConf.FTPD.EnableSite)
 	viper.SetDefault("ftpd.hash_support", globalConf.FTPD.HASHSupport)
 	viper.SetDefault("ftpd.combine_support", globalConf.FTPD.CombineSupport)
+	viper.SetDefault("ftpd.shared_tls_session_tickets", globalConf.FTPD.SharedTLSSessionTickets)
+	viper.SetDefault("ftpd.passive_ip_hook", globalConf.FTPD.PassiveIPHook)
+	viper.SetDefault("ftpd.passive_ip_hook_cache_time", globalConf.FTPD.PassiveIPHookCacheTime)
 	viper.SetDefault("ftpd.certificate_file", globalConf.FTPD.CertificateFile)
 	viper.SetDefault("ftpd.certificate_key_file", globalConf.FTPD.CertificateKeyFile)
 	viper.SetDefault("ftpd.ca_certificates", globalConf.FTPD.CACertificates)
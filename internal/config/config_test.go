@@ -33,6 +33,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/ftpd"
 	"github.com/drakkan/sftpgo/v2/internal/httpclient"
 	"github.com/drakkan/sftpgo/v2/internal/httpd"
+	ikms "github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
@@ -440,6 +441,55 @@ func TestSMTPFromEnv(t *testing.T) {
 	assert.Equal(t, 587, smtpConfig.Port)
 }
 
+func TestHookSigningSecret(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.True(t, config.GetCommonConfig().Actions.HookSigningSecret.IsEmpty())
+
+	os.Setenv("SFTPGO_COMMON__ACTIONS__HOOK_SIGNING_SECRET", "plain secret")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__ACTIONS__HOOK_SIGNING_SECRET")
+	})
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	secret := config.GetCommonConfig().Actions.HookSigningSecret
+	assert.Equal(t, kms.SecretStatusPlain, secret.GetStatus())
+	assert.Equal(t, "plain secret", secret.GetPayload())
+
+	os.Setenv("SFTPGO_COMMON__ACTIONS__HOOK_SIGNING_SECRET", `{"status":"VaultTransit","payload":"secret"}`)
+	err = config.LoadConfig(configDir, "")
+	assert.Error(t, err)
+	os.Unsetenv("SFTPGO_COMMON__ACTIONS__HOOK_SIGNING_SECRET")
+
+	reset()
+
+	confName := tempConfigName + ".json"
+	configFilePath := filepath.Join(configDir, confName)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	commonConf := config.GetCommonConfig()
+	commonConf.Actions.HookSigningSecret = ikms.NewPlainSecret("encrypted secret")
+	err = commonConf.Actions.HookSigningSecret.Encrypt()
+	assert.NoError(t, err)
+	c := make(map[string]common.Configuration)
+	c["common"] = commonConf
+	jsonConf, err := json.Marshal(c)
+	assert.NoError(t, err)
+	err = os.WriteFile(configFilePath, jsonConf, os.ModePerm)
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, confName)
+	assert.NoError(t, err)
+	secret = config.GetCommonConfig().Actions.HookSigningSecret
+	assert.True(t, secret.IsEncrypted())
+	err = secret.TryDecrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, "encrypted secret", secret.GetPayload())
+	err = os.Remove(configFilePath)
+	assert.NoError(t, err)
+}
+
 func TestMFAFromEnv(t *testing.T) {
 	reset()
 
@@ -963,6 +1013,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CLIENT_AUTH_TYPE", "2")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__DEBUG", "1")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY", "1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__DISABLE_ASCII_MODE", "1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_REUSE", "1")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_CACHE_SIZE", "100")
+	os.Setenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_LIFETIME", "3600")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE", "cert.crt")
 	os.Setenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE", "cert.key")
 
@@ -985,6 +1039,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CLIENT_AUTH_TYPE")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__DEBUG")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__ACTIVE_CONNECTIONS_SECURITY")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__DISABLE_ASCII_MODE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_REUSE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_CACHE_SIZE")
+		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__TLS_SESSION_LIFETIME")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_FILE")
 		os.Unsetenv("SFTPGO_FTPD__BINDINGS__9__CERTIFICATE_KEY_FILE")
 	})
@@ -1007,6 +1065,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.False(t, bindings[0].Debug)
 	require.Equal(t, 1, bindings[0].PassiveConnectionsSecurity)
 	require.Equal(t, 0, bindings[0].ActiveConnectionsSecurity)
+	require.False(t, bindings[0].DisableASCIIMode)
+	require.Equal(t, 0, bindings[0].TLSSessionReuse)
+	require.Equal(t, 0, bindings[0].TLSSessionCacheSize)
+	require.Equal(t, 0, bindings[0].TLSSessionLifetime)
 	require.Equal(t, 2203, bindings[1].Port)
 	require.Equal(t, "127.0.1.1", bindings[1].Address)
 	require.True(t, bindings[1].ApplyProxyConfig) // default value
@@ -1022,6 +1084,10 @@ func TestFTPDBindingsFromEnv(t *testing.T) {
 	require.Nil(t, bindings[1].TLSCipherSuites)
 	require.Equal(t, 0, bindings[1].PassiveConnectionsSecurity)
 	require.Equal(t, 1, bindings[1].ActiveConnectionsSecurity)
+	require.True(t, bindings[1].DisableASCIIMode)
+	require.Equal(t, 1, bindings[1].TLSSessionReuse)
+	require.Equal(t, 100, bindings[1].TLSSessionCacheSize)
+	require.Equal(t, 3600, bindings[1].TLSSessionLifetime)
 	require.True(t, bindings[1].Debug)
 	require.Equal(t, "cert.crt", bindings[1].CertificateFile)
 	require.Equal(t, "cert.key", bindings[1].CertificateKeyFile)
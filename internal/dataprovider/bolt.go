@@ -18,6 +18,7 @@
 package dataprovider
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
@@ -39,19 +40,20 @@ const (
 )
 
 var (
-	usersBucket     = []byte("users")
-	groupsBucket    = []byte("groups")
-	foldersBucket   = []byte("folders")
-	adminsBucket    = []byte("admins")
-	apiKeysBucket   = []byte("api_keys")
-	sharesBucket    = []byte("shares")
-	actionsBucket   = []byte("events_actions")
-	rulesBucket     = []byte("events_rules")
-	rolesBucket     = []byte("roles")
-	dbVersionBucket = []byte("db_version")
-	dbVersionKey    = []byte("version")
-	boltBuckets     = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, actionsBucket, rulesBucket, rolesBucket, dbVersionBucket}
+	usersBucket       = []byte("users")
+	groupsBucket      = []byte("groups")
+	foldersBucket     = []byte("folders")
+	adminsBucket      = []byte("admins")
+	apiKeysBucket     = []byte("api_keys")
+	sharesBucket      = []byte("shares")
+	actionsBucket     = []byte("events_actions")
+	rulesBucket       = []byte("events_rules")
+	rolesBucket       = []byte("roles")
+	webDAVPropsBucket = []byte("webdav_props")
+	dbVersionBucket   = []byte("db_version")
+	dbVersionKey      = []byte("version")
+	boltBuckets       = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
+		sharesBucket, actionsBucket, rulesBucket, rolesBucket, webDAVPropsBucket, dbVersionBucket}
 )
 
 // BoltProvider defines the auth provider for bolt key/value store
@@ -237,6 +239,41 @@ func (p *BoltProvider) updateLastLogin(username string) error {
 	})
 }
 
+func (p *BoltProvider) updatePublicKeyLastUse(username, fingerprint string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist, unable to update public key last use",
+				username))
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if user.Filters.PublicKeysLastUse == nil {
+			user.Filters.PublicKeysLastUse = make(map[string]int64)
+		}
+		user.Filters.PublicKeysLastUse[fingerprint] = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(username), buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for public key %q, user %q: %v", fingerprint,
+				username, err)
+		} else {
+			providerLog(logger.LevelDebug, "last use updated for public key %q, user %q", fingerprint, username)
+		}
+		return err
+	})
+}
+
 func (p *BoltProvider) updateAdminLastLogin(username string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getAdminsBucket(tx)
@@ -772,6 +809,9 @@ func (p *BoltProvider) deleteUser(user User, softDelete bool) error {
 		if err := p.deleteRelatedShares(tx, user.Username); err != nil {
 			return err
 		}
+		if err := p.deleteRelatedWebDAVProps(tx, user.Username); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(user.Username))
 	})
 }
@@ -1965,6 +2005,31 @@ func (p *BoltProvider) dumpShares() ([]Share, error) {
 	return shares, err
 }
 
+func (p *BoltProvider) getInternalSharesForUser(username string, groups []string) ([]Share, error) {
+	var shares []Share
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var share Share
+			err = json.Unmarshal(v, &share)
+			if err != nil {
+				return err
+			}
+			if share.isSharedWith(username, groups) {
+				shares = append(shares, share)
+			}
+		}
+		return err
+	})
+
+	return shares, err
+}
+
 func (p *BoltProvider) updateShareLastUse(shareID string, numTokens int) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getSharesBucket(tx)
@@ -2060,10 +2125,111 @@ func (p *BoltProvider) getSharedSession(key string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *BoltProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *BoltProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return ErrNotImplemented
 }
 
+func (p *BoltProvider) getWebDAVProps(username, virtualPath string) ([]byte, error) {
+	var data []byte
+
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getWebDAVPropsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var props []byte
+		if userBucket := bucket.Bucket([]byte(username)); userBucket != nil {
+			props = userBucket.Get([]byte(virtualPath))
+		}
+		if props == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV properties for user %q, path %q not found",
+				username, virtualPath))
+		}
+		data = make([]byte, len(props))
+		copy(data, props)
+		return nil
+	})
+
+	return data, err
+}
+
+func (p *BoltProvider) setWebDAVProps(username, virtualPath string, data []byte) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		usersBucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		if u := usersBucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		bucket, err := p.getWebDAVPropsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			if userBucket := bucket.Bucket([]byte(username)); userBucket != nil {
+				return userBucket.Delete([]byte(virtualPath))
+			}
+			return nil
+		}
+		userBucket, err := bucket.CreateBucketIfNotExists([]byte(username))
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte(virtualPath), data)
+	})
+}
+
+func (p *BoltProvider) renameWebDAVProps(username, source, target string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getWebDAVPropsBucket(tx)
+		if err != nil {
+			return err
+		}
+		userBucket := bucket.Bucket([]byte(username))
+		if userBucket == nil {
+			return nil
+		}
+		props := make(map[string][]byte)
+		for _, k := range p.getWebDAVPropsTreeKeys(userBucket, source) {
+			v := userBucket.Get(k)
+			data := make([]byte, len(v))
+			copy(data, v)
+			props[string(k)] = data
+		}
+		if err := p.deleteWebDAVPropsTree(userBucket, source); err != nil {
+			return err
+		}
+		if err := p.deleteWebDAVPropsTree(userBucket, target); err != nil {
+			return err
+		}
+		for k, v := range props {
+			if err := userBucket.Put([]byte(getWebDAVPropsRenamedPath(k, source, target)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) deleteWebDAVProps(username, virtualPath string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getWebDAVPropsBucket(tx)
+		if err != nil {
+			return err
+		}
+		userBucket := bucket.Bucket([]byte(username))
+		if userBucket == nil {
+			return nil
+		}
+		return p.deleteWebDAVPropsTree(userBucket, virtualPath)
+	})
+}
+
 func (p *BoltProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
 	if limit <= 0 {
 		return nil, nil
@@ -3518,6 +3684,46 @@ func (p *BoltProvider) deleteRelatedShares(tx *bolt.Tx, username string) error {
 	return nil
 }
 
+func (p *BoltProvider) deleteRelatedWebDAVProps(tx *bolt.Tx, username string) error {
+	bucket, err := p.getWebDAVPropsBucket(tx)
+	if err != nil {
+		return err
+	}
+	if bucket.Bucket([]byte(username)) == nil {
+		return nil
+	}
+	return bucket.DeleteBucket([]byte(username))
+}
+
+// getWebDAVPropsTreeKeys returns the keys for the specified virtual path and
+// its children. Keys are sorted so the children follow the common prefix
+func (p *BoltProvider) getWebDAVPropsTreeKeys(bucket *bolt.Bucket, virtualPath string) [][]byte {
+	var keys [][]byte
+	if v := bucket.Get([]byte(virtualPath)); v != nil {
+		keys = append(keys, []byte(virtualPath))
+	}
+	prefix := []byte(getWebDAVPropsChildrenPrefix(virtualPath))
+	cursor := bucket.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		if string(k) == virtualPath {
+			continue
+		}
+		key := make([]byte, len(k))
+		copy(key, k)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (p *BoltProvider) deleteWebDAVPropsTree(bucket *bolt.Bucket, virtualPath string) error {
+	for _, k := range p.getWebDAVPropsTreeKeys(bucket, virtualPath) {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *BoltProvider) deleteRelatedAPIKey(tx *bolt.Tx, username string, scope APIKeyScope) error {
 	bucket, err := p.getAPIKeysBucket(tx)
 	if err != nil {
@@ -3561,6 +3767,16 @@ func (p *BoltProvider) getSharesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getWebDAVPropsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(webDAVPropsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find WebDAV properties bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getAPIKeysBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 
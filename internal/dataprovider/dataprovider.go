@@ -149,6 +149,8 @@ var (
 	ErrNotImplemented = errors.New("feature not supported with the configured data provider")
 	// ValidProtocols defines all the valid protcols
 	ValidProtocols = []string{protocolSSH, protocolFTP, protocolWebDAV, protocolHTTP}
+	// ValidFTPSiteCommands defines the FTP SITE commands that can be allowed or denied for a user
+	ValidFTPSiteCommands = []string{"CHMOD", "CHOWN", "SYMLINK", "MKDIR", "UTIME", "QUOTA", "CPFR", "CPTO", "MD5", "HELP"}
 	// MFAProtocols defines the supported protocols for multi-factor authentication
 	MFAProtocols = []string{protocolHTTP, protocolSSH, protocolFTP}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
@@ -191,6 +193,7 @@ var (
 	sqlTableTasks                string
 	sqlTableNodes                string
 	sqlTableRoles                string
+	sqlTableWebDAVProps          string
 	sqlTableSchemaVersion        string
 	argon2Params                 *argon2id.Params
 	lastLoginMinDelay            = 10 * time.Minute
@@ -223,6 +226,7 @@ func initSQLTables() {
 	sqlTableTasks = "tasks"
 	sqlTableNodes = "nodes"
 	sqlTableRoles = "roles"
+	sqlTableWebDAVProps = "webdav_props"
 	sqlTableSchemaVersion = "schema_version"
 }
 
@@ -719,6 +723,7 @@ type Provider interface {
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
 	getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error)
 	updateLastLogin(username string) error
+	updatePublicKeyLastUse(username, fingerprint string) error
 	updateAdminLastLogin(username string) error
 	setUpdatedAt(username string)
 	getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error)
@@ -757,6 +762,7 @@ type Provider interface {
 	deleteShare(share Share) error
 	getShares(limit int, offset int, order, username string) ([]Share, error)
 	dumpShares() ([]Share, error)
+	getInternalSharesForUser(username string, groups []string) ([]Share, error)
 	updateShareLastUse(shareID string, numTokens int) error
 	getDefenderHosts(from int64, limit int) ([]DefenderEntry, error)
 	getDefenderHostByIP(ip string, from int64) (DefenderEntry, error)
@@ -774,6 +780,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string) error
 	getSharedSession(key string) (Session, error)
+	getSharedSessions(sessionType SessionType, after int64) ([]Session, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -781,6 +788,10 @@ type Provider interface {
 	addEventAction(action *BaseEventAction) error
 	updateEventAction(action *BaseEventAction) error
 	deleteEventAction(action BaseEventAction) error
+	getWebDAVProps(username, virtualPath string) ([]byte, error)
+	setWebDAVProps(username, virtualPath string, data []byte) error
+	renameWebDAVProps(username, source, target string) error
+	deleteWebDAVProps(username, virtualPath string) error
 	getEventRules(limit, offset int, order string) ([]EventRule, error)
 	dumpEventRules() ([]EventRule, error)
 	getRecentlyUpdatedRules(after int64) ([]EventRule, error)
@@ -984,16 +995,18 @@ func validateSQLTablesPrefix() error {
 		sqlTableTasks = config.SQLTablesPrefix + sqlTableTasks
 		sqlTableNodes = config.SQLTablesPrefix + sqlTableNodes
 		sqlTableRoles = config.SQLTablesPrefix + sqlTableRoles
+		sqlTableWebDAVProps = config.SQLTablesPrefix + sqlTableWebDAVProps
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %q, folders %q users folders mapping %q admins %q "+
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
-			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q",
+			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q "+
+			"webdav props %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes, sqlTableRoles)
+			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableWebDAVProps)
 	}
 	return nil
 }
@@ -1390,6 +1403,13 @@ func UpdateLastLogin(user *User) {
 	}
 }
 
+// UpdatePublicKeyLastUse updates the last use for the user public key with the given fingerprint
+func UpdatePublicKeyLastUse(user *User, fingerprint string) {
+	if !isLastActivityRecent(user.Filters.PublicKeysLastUse[fingerprint], lastLoginMinDelay) {
+		provider.updatePublicKeyLastUse(user.Username, fingerprint) //nolint:errcheck
+	}
+}
+
 // UpdateAdminLastLogin updates the last login field for the given SFTPGo admin
 func UpdateAdminLastLogin(admin *Admin) {
 	if !isLastActivityRecent(admin.LastLogin, lastLoginMinDelay) {
@@ -1427,6 +1447,17 @@ func UpdateVirtualFolderQuota(vfolder *vfs.BaseVirtualFolder, filesAdd int, size
 	if filesAdd == 0 && sizeAdd == 0 && !reset {
 		return nil
 	}
+	if vfolder.SharedBy != "" {
+		// folders added for internal shares are charged to the sharing user
+		if reset {
+			return nil
+		}
+		if config.DelayedQuotaUpdate == 0 {
+			return provider.updateQuota(vfolder.SharedBy, filesAdd, sizeAdd, false)
+		}
+		delayedQuotaUpdater.updateUserQuota(vfolder.SharedBy, filesAdd, sizeAdd)
+		return nil
+	}
 	if config.DelayedQuotaUpdate == 0 || reset {
 		if reset {
 			delayedQuotaUpdater.resetFolderQuota(vfolder.Name)
@@ -1507,6 +1538,7 @@ func GetUsedVirtualFolderQuota(name string) (int, int64, error) {
 func AddShare(share *Share, executor, ipAddress string) error {
 	err := provider.addShare(share)
 	if err == nil {
+		internalSharesCache.clear()
 		executeAction(operationAdd, executor, ipAddress, actionObjectShare, share.ShareID, share)
 	}
 	return err
@@ -1516,6 +1548,7 @@ func AddShare(share *Share, executor, ipAddress string) error {
 func UpdateShare(share *Share, executor, ipAddress string) error {
 	err := provider.updateShare(share)
 	if err == nil {
+		internalSharesCache.clear()
 		executeAction(operationUpdate, executor, ipAddress, actionObjectShare, share.ShareID, share)
 	}
 	return err
@@ -1529,6 +1562,7 @@ func DeleteShare(shareID string, executor, ipAddress string) error {
 	}
 	err = provider.deleteShare(share)
 	if err == nil {
+		internalSharesCache.clear()
 		executeAction(operationDelete, executor, ipAddress, actionObjectShare, shareID, &share)
 	}
 	return err
@@ -2051,6 +2085,41 @@ func GetSharedSession(key string) (Session, error) {
 	return provider.getSharedSession(key)
 }
 
+// GetSharedSessions retrieves the sessions with the specified type and a timestamp
+// greater than or equal to the specified time
+func GetSharedSessions(sessionType SessionType, after time.Time) ([]Session, error) {
+	return provider.getSharedSessions(sessionType, util.GetTimeAsMsSinceEpoch(after))
+}
+
+// GetWebDAVProps returns the WebDAV dead properties stored for the specified
+// user and virtual path. A not found error is returned if there are none
+func GetWebDAVProps(username, virtualPath string) ([]byte, error) {
+	return provider.getWebDAVProps(username, virtualPath)
+}
+
+// SetWebDAVProps stores the WebDAV dead properties for the specified user and
+// virtual path replacing the existing ones. Empty data removes the properties
+// stored for the path, the ones stored for its children are preserved
+func SetWebDAVProps(username, virtualPath string, data []byte) error {
+	return provider.setWebDAVProps(username, virtualPath, data)
+}
+
+// RenameWebDAVProps moves the WebDAV dead properties stored for the specified
+// source path, and its children, to the target path. The properties stored
+// for the target path, and its children, are removed
+func RenameWebDAVProps(username, source, target string) error {
+	if source == target {
+		return nil
+	}
+	return provider.renameWebDAVProps(username, source, target)
+}
+
+// DeleteWebDAVProps removes the WebDAV dead properties stored for the specified
+// virtual path and its children
+func DeleteWebDAVProps(username, virtualPath string) error {
+	return provider.deleteWebDAVProps(username, virtualPath)
+}
+
 // CleanupSharedSessions removes the shared session with the specified type and
 // before the specified time
 func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
@@ -2603,13 +2672,21 @@ func validatePublicKeys(user *User) error {
 		if k == "" {
 			continue
 		}
-		_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		_, _, options, _, err := ssh.ParseAuthorizedKey([]byte(k))
 		if err != nil {
 			return util.NewValidationError(fmt.Sprintf("could not parse key nr. %d: %s", i+1, err))
 		}
+		keyOptions, err := ParsePublicKeyOptions(options)
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid options for key nr. %d: %s", i+1, err))
+		}
+		if err := validatePublicKeyForcedCommand(keyOptions.Command); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid options for key nr. %d: %s", i+1, err))
+		}
 		validatedKeys = append(validatedKeys, k)
 	}
 	user.PublicKeys = util.RemoveDuplicates(validatedKeys, false)
+	user.prunePublicKeysLastUse()
 	return nil
 }
 
@@ -2693,6 +2770,98 @@ func validateIPFilters(filters *sdk.BaseUserFilters) error {
 	return nil
 }
 
+func validateFTPSiteCommands(siteCommands []string) ([]string, error) {
+	commands := make([]string, 0, len(siteCommands))
+	for _, command := range siteCommands {
+		command = strings.ToUpper(strings.TrimSpace(command))
+		if !util.Contains(ValidFTPSiteCommands, command) {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid FTP SITE command %q", command))
+		}
+		commands = append(commands, command)
+	}
+	return util.RemoveDuplicates(commands, false), nil
+}
+
+func validateFTPAllowedAndDeniedSiteCommands(allowed, denied []string) ([]string, []string, error) {
+	allowed, err := validateFTPSiteCommands(allowed)
+	if err != nil {
+		return nil, nil, err
+	}
+	denied, err = validateFTPSiteCommands(denied)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, command := range allowed {
+		if util.Contains(denied, command) {
+			return nil, nil, util.NewValidationError(fmt.Sprintf("FTP SITE command %q cannot be both allowed and denied",
+				command))
+		}
+	}
+	return allowed, denied, nil
+}
+
+func validateFTPPassiveIP(passiveIP string) (string, error) {
+	passiveIP = strings.TrimSpace(passiveIP)
+	if passiveIP == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(passiveIP)
+	if ip == nil || ip.To4() == nil {
+		return "", util.NewValidationError(fmt.Sprintf("invalid FTP passive IP %q, a valid IPv4 address is required",
+			passiveIP))
+	}
+	return ip.To4().String(), nil
+}
+
+// FTPPortRange defines a port range for FTP passive connections
+type FTPPortRange struct {
+	// Range start, 0 means not set
+	Start int `json:"start"`
+	// Range end, 0 means not set
+	End int `json:"end"`
+}
+
+// IsSet returns true if the port range is defined
+func (r FTPPortRange) IsSet() bool {
+	return r.Start > 0 || r.End > 0
+}
+
+func (r *FTPPortRange) validate() error {
+	if !r.IsSet() {
+		return nil
+	}
+	if r.Start < 1 || r.End > 65535 || r.Start >= r.End {
+		return util.NewValidationError(fmt.Sprintf("invalid FTP passive port range %d-%d", r.Start, r.End))
+	}
+	return nil
+}
+
+func validatePermittedOpens(filters *UserFilters) error {
+	filters.PermittedOpens = util.RemoveDuplicates(filters.PermittedOpens, false)
+	for _, dest := range filters.PermittedOpens {
+		host, port, err := net.SplitHostPort(dest)
+		if err != nil || host == "" {
+			return util.NewValidationError(fmt.Sprintf("invalid permitted open %q, the expected format is host:port", dest))
+		}
+		if port != "*" {
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 {
+				return util.NewValidationError(fmt.Sprintf("invalid port for permitted open %q", dest))
+			}
+		}
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return util.NewValidationError(fmt.Sprintf("could not parse permitted open IP/Mask %q: %v", dest, err))
+			}
+			continue
+		}
+		if _, err := path.Match(host, ""); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid host pattern for permitted open %q: %v", dest, err))
+		}
+	}
+	return nil
+}
+
 func validateBandwidthLimit(bl sdk.BandwidthLimit) error {
 	if len(bl.Sources) == 0 {
 		return util.NewValidationError("no bandwidth limit source specified")
@@ -2943,6 +3112,28 @@ func ValidateUser(user *User) error {
 	if err := validateBaseFilters(&user.Filters.BaseUserFilters); err != nil {
 		return err
 	}
+	if err := validatePermittedOpens(&user.Filters); err != nil {
+		return err
+	}
+	allowedSiteCommands, deniedSiteCommands, err := validateFTPAllowedAndDeniedSiteCommands(
+		user.Filters.FTPAllowedSiteCommands, user.Filters.FTPDeniedSiteCommands)
+	if err != nil {
+		return err
+	}
+	user.Filters.FTPAllowedSiteCommands = allowedSiteCommands
+	user.Filters.FTPDeniedSiteCommands = deniedSiteCommands
+	passiveIP, err := validateFTPPassiveIP(user.Filters.FTPPassiveIP)
+	if err != nil {
+		return err
+	}
+	user.Filters.FTPPassiveIP = passiveIP
+	if err := user.Filters.FTPPassivePortRange.validate(); err != nil {
+		return err
+	}
+	if user.Filters.WebDAVDeadPropsMaxSize < -1 {
+		return util.NewValidationError(fmt.Sprintf("invalid WebDAV dead properties max size: %d",
+			user.Filters.WebDAVDeadPropsMaxSize))
+	}
 	if !user.HasExternalAuth() {
 		user.Filters.ExternalAuthCacheTime = 0
 	}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"path"
 	"path/filepath"
@@ -27,10 +28,12 @@ import (
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 // Supported event actions
@@ -45,12 +48,13 @@ const (
 	ActionTypeDataRetentionCheck
 	ActionTypeFilesystem
 	ActionTypeMetadataCheck
+	ActionTypeMessageBroker
 )
 
 var (
 	supportedEventActions = []int{ActionTypeHTTP, ActionTypeCommand, ActionTypeEmail, ActionTypeFilesystem,
 		ActionTypeBackup, ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
-		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck}
+		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeMessageBroker}
 )
 
 func isActionTypeValid(action int) bool {
@@ -77,6 +81,8 @@ func getActionTypeAsString(action int) string {
 		return "Metadata check"
 	case ActionTypeFilesystem:
 		return "Filesystem"
+	case ActionTypeMessageBroker:
+		return "Message broker"
 	default:
 		return "Command"
 	}
@@ -124,6 +130,8 @@ const (
 	FilesystemActionMkdirs
 	FilesystemActionExist
 	FilesystemActionCompress
+	FilesystemActionExtract
+	FilesystemActionTransfer
 )
 
 const (
@@ -133,7 +141,7 @@ const (
 
 var (
 	supportedFsActions = []int{FilesystemActionRename, FilesystemActionDelete, FilesystemActionMkdirs,
-		FilesystemActionCompress, FilesystemActionExist}
+		FilesystemActionCompress, FilesystemActionExist, FilesystemActionExtract, FilesystemActionTransfer}
 )
 
 func isFilesystemActionValid(value int) bool {
@@ -150,11 +158,47 @@ func getFsActionTypeAsString(value int) string {
 		return "Paths exist"
 	case FilesystemActionCompress:
 		return "Compress"
+	case FilesystemActionExtract:
+		return "Extract"
+	case FilesystemActionTransfer:
+		return "Transfer"
 	default:
 		return "Create directories"
 	}
 }
 
+// Supported message broker protocols
+const (
+	BrokerProtocolAMQP = iota + 1
+	BrokerProtocolMQTT
+	BrokerProtocolNATS
+	BrokerProtocolKafka
+)
+
+var (
+	supportedBrokerProtocols = []int{BrokerProtocolAMQP, BrokerProtocolMQTT, BrokerProtocolNATS,
+		BrokerProtocolKafka}
+	brokerEndpointSchemes = map[int][]string{
+		BrokerProtocolAMQP:  {"amqp://", "amqps://"},
+		BrokerProtocolMQTT:  {"tcp://", "mqtt://", "ssl://", "tls://", "mqtts://"},
+		BrokerProtocolNATS:  {"nats://", "tls://"},
+		BrokerProtocolKafka: {"kafka://", "kafkas://"},
+	}
+)
+
+func getBrokerProtocolAsString(value int) string {
+	switch value {
+	case BrokerProtocolMQTT:
+		return "MQTT"
+	case BrokerProtocolNATS:
+		return "NATS"
+	case BrokerProtocolKafka:
+		return "Kafka"
+	default:
+		return "AMQP"
+	}
+}
+
 // TODO: replace the copied strings with shared constants
 var (
 	// SupportedFsEvents defines the supported filesystem events
@@ -177,6 +221,7 @@ var (
 	EventActionTypes  []EnumMapping
 	EventTriggerTypes []EnumMapping
 	FsActionTypes     []EnumMapping
+	BrokerProtocols   []EnumMapping
 )
 
 func init() {
@@ -198,6 +243,12 @@ func init() {
 			Name:  getFsActionTypeAsString(t),
 		})
 	}
+	for _, p := range supportedBrokerProtocols {
+		BrokerProtocols = append(BrokerProtocols, EnumMapping{
+			Value: p,
+			Name:  getBrokerProtocolAsString(p),
+		})
+	}
 }
 
 // EnumMapping defines a mapping between enum values and names
@@ -259,6 +310,11 @@ type EventActionHTTPConfig struct {
 	QueryParameters []KeyValue  `json:"query_parameters,omitempty"`
 	Body            string      `json:"body,omitempty"`
 	Parts           []HTTPPart  `json:"parts,omitempty"`
+	// secret used to sign the requests using HMAC-SHA256
+	SigningSecret *kms.Secret `json:"signing_secret,omitempty"`
+	// PEM encoded client certificate and private key for mutual TLS
+	ClientCert string      `json:"client_cert,omitempty"`
+	ClientKey  *kms.Secret `json:"client_key,omitempty"`
 }
 
 func (c *EventActionHTTPConfig) isTimeoutNotValid() bool {
@@ -294,6 +350,36 @@ func (c *EventActionHTTPConfig) validateMultiparts() error {
 	return nil
 }
 
+func (c *EventActionHTTPConfig) validateSecret(secret *kms.Secret, name, additionalData string) error {
+	if secret.IsRedacted() {
+		return util.NewValidationError("cannot save HTTP configuration with a redacted secret")
+	}
+	if secret.IsPlain() {
+		secret.SetAdditionalData(additionalData)
+		err := secret.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP %s: %v", name, err))
+		}
+	}
+	return nil
+}
+
+func (c *EventActionHTTPConfig) validateClientCertificate() error {
+	c.ClientCert = strings.TrimSpace(c.ClientCert)
+	if c.ClientCert == "" && c.ClientKey.IsEmpty() {
+		return nil
+	}
+	if c.ClientCert == "" || c.ClientKey.IsEmpty() {
+		return util.NewValidationError("both client certificate and key are required for mutual TLS")
+	}
+	if c.ClientKey.IsPlain() {
+		if _, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey.GetPayload())); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid client certificate/key pair: %v", err))
+		}
+	}
+	return nil
+}
+
 func (c *EventActionHTTPConfig) validate(additionalData string) error {
 	if c.Endpoint == "" {
 		return util.NewValidationError("HTTP endpoint is required")
@@ -312,15 +398,20 @@ func (c *EventActionHTTPConfig) validate(additionalData string) error {
 	if err := c.validateMultiparts(); err != nil {
 		return err
 	}
-	if c.Password.IsRedacted() {
-		return util.NewValidationError("cannot save HTTP configuration with a redacted secret")
+	if err := c.validateSecret(c.Password, "password", additionalData); err != nil {
+		return err
 	}
-	if c.Password.IsPlain() {
-		c.Password.SetAdditionalData(additionalData)
-		err := c.Password.Encrypt()
-		if err != nil {
-			return util.NewValidationError(fmt.Sprintf("could not encrypt HTTP password: %v", err))
-		}
+	if !c.SigningSecret.IsEmpty() && c.HasMultipartFiles() {
+		return util.NewValidationError("request signing is not supported for multipart requests with files")
+	}
+	if err := c.validateSecret(c.SigningSecret, "signing secret", additionalData); err != nil {
+		return err
+	}
+	if err := c.validateClientCertificate(); err != nil {
+		return err
+	}
+	if err := c.validateSecret(c.ClientKey, "client key", additionalData); err != nil {
+		return err
 	}
 	if !util.Contains(SupportedHTTPActionMethods, c.Method) {
 		return util.NewValidationError(fmt.Sprintf("unsupported HTTP method: %s", c.Method))
@@ -341,6 +432,33 @@ func (c *EventActionHTTPConfig) GetContext() (context.Context, context.CancelFun
 	return context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
 }
 
+// GetKafkaBrokers returns the bootstrap brokers defined in the Kafka endpoint,
+// for example "kafka://host1:9092,host2:9092"
+func (c *EventActionBrokerConfig) GetKafkaBrokers() ([]string, error) {
+	_, hosts, ok := strings.Cut(c.Endpoint, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid Kafka endpoint %q", c.Endpoint)
+	}
+	if idx := strings.IndexAny(hosts, "/?"); idx >= 0 {
+		hosts = hosts[:idx]
+	}
+	var brokers []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(host); err != nil || port == "" {
+			return nil, fmt.Errorf("invalid Kafka broker %q, the expected format is host:port", host)
+		}
+		brokers = append(brokers, host)
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka broker defined in endpoint %q", c.Endpoint)
+	}
+	return brokers, nil
+}
+
 // HasObjectData returns true if the {{ObjectData}} placeholder is defined
 func (c *EventActionHTTPConfig) HasObjectData() bool {
 	if strings.Contains(c.Body, "{{ObjectData}}") {
@@ -364,32 +482,49 @@ func (c *EventActionHTTPConfig) HasMultipartFiles() bool {
 	return false
 }
 
-// TryDecryptPassword decrypts the password if encryptet
-func (c *EventActionHTTPConfig) TryDecryptPassword() error {
+// TryDecryptSecrets decrypts the password, the signing secret and the client key if encrypted
+func (c *EventActionHTTPConfig) TryDecryptSecrets() error {
 	if c.Password != nil && !c.Password.IsEmpty() {
 		if err := c.Password.TryDecrypt(); err != nil {
 			return fmt.Errorf("unable to decrypt HTTP password: %w", err)
 		}
 	}
+	if c.SigningSecret != nil && !c.SigningSecret.IsEmpty() {
+		if err := c.SigningSecret.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt HTTP signing secret: %w", err)
+		}
+	}
+	if c.ClientKey != nil && !c.ClientKey.IsEmpty() {
+		if err := c.ClientKey.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt HTTP client key: %w", err)
+		}
+	}
 	return nil
 }
 
-// GetHTTPClient returns an HTTP client based on the config
-func (c *EventActionHTTPConfig) GetHTTPClient() *http.Client {
+// GetHTTPClient returns an HTTP client based on the config.
+// The secrets must be decrypted
+func (c *EventActionHTTPConfig) GetHTTPClient() (*http.Client, error) {
 	client := &http.Client{}
-	if c.SkipTLSVerify {
-		transport := http.DefaultTransport.(*http.Transport).Clone()
-		if transport.TLSClientConfig != nil {
-			transport.TLSClientConfig.InsecureSkipVerify = true
-		} else {
-			transport.TLSClientConfig = &tls.Config{
-				NextProtos:         []string{"http/1.1", "h2"},
-				InsecureSkipVerify: true,
-			}
+	if !c.SkipTLSVerify && c.ClientCert == "" {
+		return client, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{
+			NextProtos: []string{"http/1.1", "h2"},
+		}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = c.SkipTLSVerify
+	if c.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey.GetPayload()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %w", err)
 		}
-		client.Transport = transport
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
 	}
-	return client
+	client.Transport = transport
+	return client, nil
 }
 
 // EventActionCommandConfig defines the configuration for a command event target
@@ -487,6 +622,84 @@ func (c *EventActionEmailConfig) validate() error {
 	return nil
 }
 
+// EventActionBrokerConfig defines the configuration for a message broker event action
+type EventActionBrokerConfig struct {
+	Protocol int         `json:"protocol,omitempty"`
+	Endpoint string      `json:"endpoint,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// AMQP exchange, the default exchange is used if empty
+	Exchange string `json:"exchange,omitempty"`
+	// AMQP routing key, MQTT topic, NATS subject or Kafka topic
+	Topic string `json:"topic,omitempty"`
+	// Message body, a JSON representation of the event is published if empty
+	Body          string `json:"body,omitempty"`
+	Timeout       int    `json:"timeout,omitempty"`
+	SkipTLSVerify bool   `json:"skip_tls_verify,omitempty"`
+}
+
+// GetProtocolAsString returns the broker protocol as string
+func (c *EventActionBrokerConfig) GetProtocolAsString() string {
+	return getBrokerProtocolAsString(c.Protocol)
+}
+
+func (c *EventActionBrokerConfig) validate(additionalData string) error {
+	if !util.Contains(supportedBrokerProtocols, c.Protocol) {
+		return util.NewValidationError(fmt.Sprintf("invalid message broker protocol: %d", c.Protocol))
+	}
+	c.Endpoint = strings.TrimSpace(c.Endpoint)
+	if c.Endpoint == "" {
+		return util.NewValidationError("message broker endpoint is required")
+	}
+	schemes := brokerEndpointSchemes[c.Protocol]
+	if !util.IsStringPrefixInSlice(c.Endpoint, schemes) {
+		return util.NewValidationError(fmt.Sprintf("invalid %s endpoint schema, supported schemas: %s",
+			c.GetProtocolAsString(), strings.Join(schemes, ", ")))
+	}
+	c.Topic = strings.TrimSpace(c.Topic)
+	if c.Topic == "" {
+		return util.NewValidationError("message broker topic is required")
+	}
+	if c.Protocol != BrokerProtocolAMQP {
+		c.Exchange = ""
+	}
+	if c.Protocol == BrokerProtocolKafka {
+		if _, err := c.GetKafkaBrokers(); err != nil {
+			return util.NewValidationError(err.Error())
+		}
+	}
+	if c.Timeout < 1 || c.Timeout > 180 {
+		return util.NewValidationError(fmt.Sprintf("invalid message broker timeout %d", c.Timeout))
+	}
+	if c.Password.IsRedacted() {
+		return util.NewValidationError("cannot save message broker configuration with a redacted secret")
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		err := c.Password.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt message broker password: %v", err))
+		}
+	}
+	return nil
+}
+
+// HasObjectData returns true if the {{ObjectData}} placeholder is defined
+// or if the default message body, that includes the object data, is used
+func (c *EventActionBrokerConfig) HasObjectData() bool {
+	return c.Body == "" || strings.Contains(c.Body, "ObjectData")
+}
+
+// TryDecryptPassword decrypts the password if encrypted
+func (c *EventActionBrokerConfig) TryDecryptPassword() error {
+	if c.Password != nil && !c.Password.IsEmpty() {
+		if err := c.Password.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt message broker password: %w", err)
+		}
+	}
+	return nil
+}
+
 // FolderRetention defines a folder retention configuration
 type FolderRetention struct {
 	// Path is the exposed virtual directory path, if no other specific retention is defined,
@@ -572,6 +785,132 @@ func (c *EventActionFsCompress) validate() error {
 	return nil
 }
 
+// EventActionFsExtract defines the configuration for the extract filesystem action
+type EventActionFsExtract struct {
+	// Archive path
+	Name string `json:"name,omitempty"`
+	// Directory where the archive will be extracted
+	Target string `json:"target,omitempty"`
+	// Maximum allowed uncompressed size in bytes, 0 means no limit other than the quota
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Maximum allowed number of archive entries, 0 means no limit other than the quota
+	MaxEntries int `json:"max_entries,omitempty"`
+	// Delete the archive after a successful extraction
+	DeleteSource bool `json:"delete_source,omitempty"`
+}
+
+func (c *EventActionFsExtract) validate() error {
+	if c.Name == "" {
+		return util.NewValidationError("archive name is mandatory")
+	}
+	c.Name = util.CleanPath(strings.TrimSpace(c.Name))
+	if c.Name == "/" {
+		return util.NewValidationError("invalid archive name")
+	}
+	if strings.TrimSpace(c.Target) == "" {
+		return util.NewValidationError("extraction target directory is mandatory")
+	}
+	c.Target = util.CleanPath(strings.TrimSpace(c.Target))
+	if c.MaxSize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max uncompressed size: %d", c.MaxSize))
+	}
+	if c.MaxEntries < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid max archive entries: %d", c.MaxEntries))
+	}
+	return nil
+}
+
+// EventActionFsTransfer defines the configuration for the transfer filesystem action
+type EventActionFsTransfer struct {
+	// Files to transfer, the key is the source path and the value the target path
+	Paths []KeyValue `json:"paths,omitempty"`
+	// SFTPGo user to transfer the files to. If empty the files are transferred
+	// to the configured target filesystem
+	TargetUser string `json:"target_user,omitempty"`
+	// Target filesystem, used if no target user is set.
+	// S3, GCS, Azure Blob and SFTP filesystems are supported
+	TargetFs vfs.Filesystem `json:"target_fs"`
+	// Number of retries for failed transfers
+	Retries int `json:"retries,omitempty"`
+	// Compare the checksums of the source and target files after each transfer
+	VerifyChecksum bool `json:"verify_checksum,omitempty"`
+	// Delete the source file after a successful transfer
+	DeleteSource bool `json:"delete_source,omitempty"`
+	// Directory where the source file is moved after a successful transfer
+	MoveSourceTo string `json:"move_source_to,omitempty"`
+}
+
+func (c *EventActionFsTransfer) validatePaths() error {
+	if len(c.Paths) == 0 {
+		return util.NewValidationError("no path to transfer specified")
+	}
+	for idx, kv := range c.Paths {
+		key := strings.TrimSpace(kv.Key)
+		value := strings.TrimSpace(kv.Value)
+		if key == "" || value == "" {
+			return util.NewValidationError("invalid paths to transfer")
+		}
+		key = util.CleanPath(key)
+		value = util.CleanPath(value)
+		if key == "/" || value == "/" {
+			return util.NewValidationError("transferring the root directory is not allowed")
+		}
+		c.Paths[idx] = KeyValue{
+			Key:   key,
+			Value: value,
+		}
+	}
+	return nil
+}
+
+func (c *EventActionFsTransfer) validateTargetFs(additionalData string) error {
+	switch c.TargetFs.Provider {
+	case sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider,
+		sdk.SFTPFilesystemProvider:
+	default:
+		return util.NewValidationError(fmt.Sprintf("unsupported transfer target filesystem %q, set a target user or a remote filesystem",
+			c.TargetFs.Provider.Name()))
+	}
+	if c.TargetFs.HasRedactedSecret() {
+		return util.NewValidationError("cannot save a transfer target filesystem with a redacted secret")
+	}
+	return c.TargetFs.Validate(additionalData)
+}
+
+func (c *EventActionFsTransfer) validate(additionalData string) error {
+	if err := c.validatePaths(); err != nil {
+		return err
+	}
+	if c.Retries < 0 || c.Retries > 10 {
+		return util.NewValidationError(fmt.Sprintf("invalid transfer retries: %d", c.Retries))
+	}
+	c.MoveSourceTo = strings.TrimSpace(c.MoveSourceTo)
+	if c.MoveSourceTo != "" {
+		if c.DeleteSource {
+			return util.NewValidationError("the source file cannot be both deleted and moved")
+		}
+		c.MoveSourceTo = util.CleanPath(c.MoveSourceTo)
+	}
+	c.TargetUser = strings.TrimSpace(c.TargetUser)
+	if c.TargetUser != "" {
+		c.TargetFs = vfs.Filesystem{}
+		return nil
+	}
+	return c.validateTargetFs(additionalData)
+}
+
+func (c *EventActionFsTransfer) getACopy() EventActionFsTransfer {
+	return EventActionFsTransfer{
+		Paths:          cloneKeyValues(c.Paths),
+		TargetUser:     c.TargetUser,
+		TargetFs:       c.TargetFs.GetACopy(),
+		Retries:        c.Retries,
+		VerifyChecksum: c.VerifyChecksum,
+		DeleteSource:   c.DeleteSource,
+		MoveSourceTo:   c.MoveSourceTo,
+	}
+}
+
 // EventActionFilesystemConfig defines the configuration for filesystem actions
 type EventActionFilesystemConfig struct {
 	// Filesystem actions, see the above enum
@@ -586,6 +925,10 @@ type EventActionFilesystemConfig struct {
 	Exist []string `json:"exist,omitempty"`
 	// paths to compress and archive name
 	Compress EventActionFsCompress `json:"compress"`
+	// archive to extract and target directory
+	Extract EventActionFsExtract `json:"extract"`
+	// files to transfer to another user or to a remote filesystem
+	Transfer EventActionFsTransfer `json:"transfer"`
 }
 
 // GetDeletesAsString returns the list of items to delete as comma separated string.
@@ -683,7 +1026,7 @@ func (c *EventActionFilesystemConfig) validateExist() error {
 	return nil
 }
 
-func (c *EventActionFilesystemConfig) validate() error {
+func (c *EventActionFilesystemConfig) validate(additionalData string) error {
 	if !isFilesystemActionValid(c.Type) {
 		return util.NewValidationError(fmt.Sprintf("invalid filesystem action type: %d", c.Type))
 	}
@@ -693,6 +1036,8 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Deletes = nil
 		c.Exist = nil
 		c.Compress = EventActionFsCompress{}
+		c.Extract = EventActionFsExtract{}
+		c.Transfer = EventActionFsTransfer{}
 		if err := c.validateRenames(); err != nil {
 			return err
 		}
@@ -701,6 +1046,8 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.MkDirs = nil
 		c.Exist = nil
 		c.Compress = EventActionFsCompress{}
+		c.Extract = EventActionFsExtract{}
+		c.Transfer = EventActionFsTransfer{}
 		if err := c.validateDeletes(); err != nil {
 			return err
 		}
@@ -709,6 +1056,8 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Deletes = nil
 		c.Exist = nil
 		c.Compress = EventActionFsCompress{}
+		c.Extract = EventActionFsExtract{}
+		c.Transfer = EventActionFsTransfer{}
 		if err := c.validateMkdirs(); err != nil {
 			return err
 		}
@@ -717,6 +1066,8 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.Deletes = nil
 		c.MkDirs = nil
 		c.Compress = EventActionFsCompress{}
+		c.Extract = EventActionFsExtract{}
+		c.Transfer = EventActionFsTransfer{}
 		if err := c.validateExist(); err != nil {
 			return err
 		}
@@ -725,9 +1076,31 @@ func (c *EventActionFilesystemConfig) validate() error {
 		c.MkDirs = nil
 		c.Deletes = nil
 		c.Exist = nil
+		c.Extract = EventActionFsExtract{}
+		c.Transfer = EventActionFsTransfer{}
 		if err := c.Compress.validate(); err != nil {
 			return err
 		}
+	case FilesystemActionExtract:
+		c.Renames = nil
+		c.MkDirs = nil
+		c.Deletes = nil
+		c.Exist = nil
+		c.Compress = EventActionFsCompress{}
+		c.Transfer = EventActionFsTransfer{}
+		if err := c.Extract.validate(); err != nil {
+			return err
+		}
+	case FilesystemActionTransfer:
+		c.Renames = nil
+		c.MkDirs = nil
+		c.Deletes = nil
+		c.Exist = nil
+		c.Compress = EventActionFsCompress{}
+		c.Extract = EventActionFsExtract{}
+		if err := c.Transfer.validate(additionalData); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -752,6 +1125,14 @@ func (c *EventActionFilesystemConfig) getACopy() EventActionFilesystemConfig {
 			Paths: compressPaths,
 			Name:  c.Compress.Name,
 		},
+		Extract: EventActionFsExtract{
+			Name:         c.Extract.Name,
+			Target:       c.Extract.Target,
+			MaxSize:      c.Extract.MaxSize,
+			MaxEntries:   c.Extract.MaxEntries,
+			DeleteSource: c.Extract.DeleteSource,
+		},
+		Transfer: c.Transfer.getACopy(),
 	}
 }
 
@@ -762,6 +1143,7 @@ type BaseEventActionOptions struct {
 	EmailConfig     EventActionEmailConfig         `json:"email_config"`
 	RetentionConfig EventActionDataRetentionConfig `json:"retention_config"`
 	FsConfig        EventActionFilesystemConfig    `json:"fs_config"`
+	BrokerConfig    EventActionBrokerConfig        `json:"broker_config"`
 }
 
 func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
@@ -803,6 +1185,9 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			QueryParameters: cloneKeyValues(o.HTTPConfig.QueryParameters),
 			Body:            o.HTTPConfig.Body,
 			Parts:           httpParts,
+			SigningSecret:   o.HTTPConfig.SigningSecret.Clone(),
+			ClientCert:      o.HTTPConfig.ClientCert,
+			ClientKey:       o.HTTPConfig.ClientKey.Clone(),
 		},
 		CmdConfig: EventActionCommandConfig{
 			Cmd:     o.CmdConfig.Cmd,
@@ -820,6 +1205,17 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			Folders: folders,
 		},
 		FsConfig: o.FsConfig.getACopy(),
+		BrokerConfig: EventActionBrokerConfig{
+			Protocol:      o.BrokerConfig.Protocol,
+			Endpoint:      o.BrokerConfig.Endpoint,
+			Username:      o.BrokerConfig.Username,
+			Password:      o.BrokerConfig.Password.Clone(),
+			Exchange:      o.BrokerConfig.Exchange,
+			Topic:         o.BrokerConfig.Topic,
+			Body:          o.BrokerConfig.Body,
+			Timeout:       o.BrokerConfig.Timeout,
+			SkipTLSVerify: o.BrokerConfig.SkipTLSVerify,
+		},
 	}
 }
 
@@ -828,18 +1224,48 @@ func (o *BaseEventActionOptions) SetEmptySecretsIfNil() {
 	if o.HTTPConfig.Password == nil {
 		o.HTTPConfig.Password = kms.NewEmptySecret()
 	}
+	if o.HTTPConfig.SigningSecret == nil {
+		o.HTTPConfig.SigningSecret = kms.NewEmptySecret()
+	}
+	if o.HTTPConfig.ClientKey == nil {
+		o.HTTPConfig.ClientKey = kms.NewEmptySecret()
+	}
+	if o.BrokerConfig.Password == nil {
+		o.BrokerConfig.Password = kms.NewEmptySecret()
+	}
+	o.FsConfig.Transfer.TargetFs.SetEmptySecretsIfNil()
 }
 
 func (o *BaseEventActionOptions) setNilSecretsIfEmpty() {
 	if o.HTTPConfig.Password != nil && o.HTTPConfig.Password.IsEmpty() {
 		o.HTTPConfig.Password = nil
 	}
+	if o.HTTPConfig.SigningSecret != nil && o.HTTPConfig.SigningSecret.IsEmpty() {
+		o.HTTPConfig.SigningSecret = nil
+	}
+	if o.HTTPConfig.ClientKey != nil && o.HTTPConfig.ClientKey.IsEmpty() {
+		o.HTTPConfig.ClientKey = nil
+	}
+	if o.BrokerConfig.Password != nil && o.BrokerConfig.Password.IsEmpty() {
+		o.BrokerConfig.Password = nil
+	}
+	o.FsConfig.Transfer.TargetFs.SetNilSecretsIfEmpty()
 }
 
 func (o *BaseEventActionOptions) hideConfidentialData() {
 	if o.HTTPConfig.Password != nil {
 		o.HTTPConfig.Password.Hide()
 	}
+	if o.HTTPConfig.SigningSecret != nil {
+		o.HTTPConfig.SigningSecret.Hide()
+	}
+	if o.HTTPConfig.ClientKey != nil {
+		o.HTTPConfig.ClientKey.Hide()
+	}
+	if o.BrokerConfig.Password != nil {
+		o.BrokerConfig.Password.Hide()
+	}
+	o.FsConfig.Transfer.TargetFs.HideConfidentialData()
 }
 
 func (o *BaseEventActionOptions) validate(action int, name string) error {
@@ -850,37 +1276,50 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
 		return o.HTTPConfig.validate(name)
 	case ActionTypeCommand:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
 		return o.CmdConfig.validate()
 	case ActionTypeEmail:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
 		return o.EmailConfig.validate()
 	case ActionTypeDataRetentionCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
 		return o.RetentionConfig.validate()
 	case ActionTypeFilesystem:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
-		return o.FsConfig.validate()
+		o.BrokerConfig = EventActionBrokerConfig{}
+		return o.FsConfig.validate(name)
+	case ActionTypeMessageBroker:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		return o.BrokerConfig.validate(name)
 	default:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
 		o.EmailConfig = EventActionEmailConfig{}
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
+		o.BrokerConfig = EventActionBrokerConfig{}
 	}
 	return nil
 }
@@ -33,6 +33,24 @@ type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// If enabled, the group members can only use FIDO/U2F security keys, or certificates
+	// for these keys, for public key authentication
+	SecurityKeysOnly bool `json:"security_keys_only,omitempty"`
+	// If enabled, the security key signatures of the group members must assert the user presence
+	RequireUserPresence bool `json:"require_user_presence,omitempty"`
+	// If enabled, the security key signatures of the group members must assert the user verification
+	RequireUserVerification bool `json:"require_user_verification,omitempty"`
+	// IPv4 address to expose for FTP passive connections. It is applied to the
+	// members of this primary group that don't have a specific passive IP
+	FTPPassiveIP string `json:"ftp_passive_ip,omitempty"`
+	// Port range for FTP passive connections. It is applied to the members of
+	// this primary group that don't have a specific port range
+	FTPPassivePortRange FTPPortRange `json:"ftp_passive_port_range"`
+	// FTP SITE commands allowed for the group members. If not empty, any other
+	// SITE command is denied
+	FTPAllowedSiteCommands []string `json:"ftp_allowed_site_commands,omitempty"`
+	// FTP SITE commands denied for the group members
+	FTPDeniedSiteCommands []string `json:"ftp_denied_site_commands,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -177,6 +195,21 @@ func (g *Group) validateUserSettings() error {
 	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
 		return err
 	}
+	allowedSiteCommands, deniedSiteCommands, err := validateFTPAllowedAndDeniedSiteCommands(
+		g.UserSettings.FTPAllowedSiteCommands, g.UserSettings.FTPDeniedSiteCommands)
+	if err != nil {
+		return err
+	}
+	g.UserSettings.FTPAllowedSiteCommands = allowedSiteCommands
+	g.UserSettings.FTPDeniedSiteCommands = deniedSiteCommands
+	passiveIP, err := validateFTPPassiveIP(g.UserSettings.FTPPassiveIP)
+	if err != nil {
+		return err
+	}
+	g.UserSettings.FTPPassiveIP = passiveIP
+	if err := g.UserSettings.FTPPassivePortRange.validate(); err != nil {
+		return err
+	}
 	if !g.HasExternalAuth() {
 		g.UserSettings.Filters.ExternalAuthCacheTime = 0
 	}
@@ -200,6 +233,10 @@ func (g *Group) getACopy() Group {
 		copy(perms, v)
 		permissions[k] = perms
 	}
+	allowedSiteCommands := make([]string, len(g.UserSettings.FTPAllowedSiteCommands))
+	copy(allowedSiteCommands, g.UserSettings.FTPAllowedSiteCommands)
+	deniedSiteCommands := make([]string, len(g.UserSettings.FTPDeniedSiteCommands))
+	copy(deniedSiteCommands, g.UserSettings.FTPDeniedSiteCommands)
 
 	return Group{
 		BaseGroup: sdk.BaseGroup{
@@ -225,7 +262,14 @@ func (g *Group) getACopy() Group {
 				TotalDataTransfer:    g.UserSettings.TotalDataTransfer,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:                g.UserSettings.FsConfig.GetACopy(),
+			SecurityKeysOnly:        g.UserSettings.SecurityKeysOnly,
+			RequireUserPresence:     g.UserSettings.RequireUserPresence,
+			RequireUserVerification: g.UserSettings.RequireUserVerification,
+			FTPPassiveIP:            g.UserSettings.FTPPassiveIP,
+			FTPPassivePortRange:     g.UserSettings.FTPPassivePortRange,
+			FTPAllowedSiteCommands:  allowedSiteCommands,
+			FTPDeniedSiteCommands:   deniedSiteCommands,
 		},
 		VirtualFolders: virtualFolders,
 	}
@@ -74,6 +74,9 @@ type memoryProviderHandle struct {
 	roles map[string]Role
 	// slice with ordered roles
 	roleNames []string
+	// map for WebDAV properties, username is the key, the value is a map
+	// with the virtual path as key
+	webDAVProps map[string]map[string][]byte
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -110,6 +113,7 @@ func initializeMemoryProvider(basePath string) {
 			rulesNames:      []string{},
 			roles:           map[string]Role{},
 			roleNames:       []string{},
+			webDAVProps:     make(map[string]map[string][]byte),
 			configFile:      configFile,
 		},
 	}
@@ -228,6 +232,24 @@ func (p *MemoryProvider) updateLastLogin(username string) error {
 	return nil
 }
 
+func (p *MemoryProvider) updatePublicKeyLastUse(username, fingerprint string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	if user.Filters.PublicKeysLastUse == nil {
+		user.Filters.PublicKeysLastUse = make(map[string]int64)
+	}
+	user.Filters.PublicKeysLastUse[fingerprint] = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.users[user.Username] = user
+	return nil
+}
+
 func (p *MemoryProvider) updateAdminLastLogin(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -446,6 +468,7 @@ func (p *MemoryProvider) deleteUser(user User, softDelete bool) error {
 	sort.Strings(p.dbHandle.usernames)
 	p.deleteAPIKeysWithUser(user.Username)
 	p.deleteSharesWithUser(user.Username)
+	delete(p.dbHandle.webDAVProps, user.Username)
 	return nil
 }
 
@@ -1972,6 +1995,22 @@ func (p *MemoryProvider) dumpShares() ([]Share, error) {
 	return shares, nil
 }
 
+func (p *MemoryProvider) getInternalSharesForUser(username string, groups []string) ([]Share, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	var shares []Share
+	if p.dbHandle.isClosed {
+		return shares, errMemoryProviderClosed
+	}
+	for _, s := range p.dbHandle.shares {
+		if s.isSharedWith(username, groups) {
+			shares = append(shares, s.getACopy())
+		}
+	}
+	return shares, nil
+}
+
 func (p *MemoryProvider) updateShareLastUse(shareID string, numTokens int) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2052,10 +2091,94 @@ func (p *MemoryProvider) getSharedSession(key string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *MemoryProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *MemoryProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return ErrNotImplemented
 }
 
+func (p *MemoryProvider) getWebDAVProps(username, virtualPath string) ([]byte, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	data, ok := p.dbHandle.webDAVProps[username][virtualPath]
+	if !ok {
+		return nil, util.NewRecordNotFoundError(fmt.Sprintf("WebDAV properties for user %q, path %q not found",
+			username, virtualPath))
+	}
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result, nil
+}
+
+func (p *MemoryProvider) setWebDAVProps(username, virtualPath string, data []byte) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, err := p.userExistsInternal(username); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		delete(p.dbHandle.webDAVProps[username], virtualPath)
+		return nil
+	}
+	if _, ok := p.dbHandle.webDAVProps[username]; !ok {
+		p.dbHandle.webDAVProps[username] = make(map[string][]byte)
+	}
+	props := make([]byte, len(data))
+	copy(props, data)
+	p.dbHandle.webDAVProps[username][virtualPath] = props
+	return nil
+}
+
+func (p *MemoryProvider) renameWebDAVProps(username, source, target string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	userProps, ok := p.dbHandle.webDAVProps[username]
+	if !ok {
+		return nil
+	}
+	renamed := make(map[string][]byte)
+	for k, v := range userProps {
+		if isWebDAVPropsPathInTree(k, source) {
+			renamed[getWebDAVPropsRenamedPath(k, source, target)] = v
+			delete(userProps, k)
+		}
+	}
+	for k := range userProps {
+		if isWebDAVPropsPathInTree(k, target) {
+			delete(userProps, k)
+		}
+	}
+	for k, v := range renamed {
+		userProps[k] = v
+	}
+	return nil
+}
+
+func (p *MemoryProvider) deleteWebDAVProps(username, virtualPath string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for k := range p.dbHandle.webDAVProps[username] {
+		if isWebDAVPropsPathInTree(k, virtualPath) {
+			delete(p.dbHandle.webDAVProps[username], k)
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2720,6 +2843,7 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.rulesNames = []string{}
 	p.dbHandle.roles = map[string]Role{}
 	p.dbHandle.roleNames = []string{}
+	p.dbHandle.webDAVProps = make(map[string]map[string][]byte)
 }
 
 func (p *MemoryProvider) reloadConfig() error {
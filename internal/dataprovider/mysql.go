@@ -46,6 +46,7 @@ const (
 		"DROP TABLE IF EXISTS `{{admins}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{folders}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{shares}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{webdav_props}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{users}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{groups}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{defender_events}}` CASCADE;" +
@@ -183,6 +184,15 @@ const (
 		"ALTER TABLE `{{users}}` DROP COLUMN `role_id`;" +
 		"ALTER TABLE `{{admins}}` DROP COLUMN `role_id`;" +
 		"DROP TABLE `{{roles}}` CASCADE;"
+	mysqlV25SQL     = "ALTER TABLE `{{shares}}` ADD COLUMN `recipients` longtext NULL;"
+	mysqlV25DownSQL = "ALTER TABLE `{{shares}}` DROP COLUMN `recipients`;"
+	mysqlV26SQL     = "CREATE TABLE `{{webdav_props}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`path_hash` varchar(64) NOT NULL, `path` longtext NOT NULL, `data` longblob NOT NULL, " +
+		"`updated_at` bigint NOT NULL, `user_id` integer NOT NULL, " +
+		"CONSTRAINT `{{prefix}}unique_webdav_props_path` UNIQUE (`user_id`, `path_hash`));" +
+		"ALTER TABLE `{{webdav_props}}` ADD CONSTRAINT `{{prefix}}webdav_props_user_id_fk_users_id` " +
+		"FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;"
+	mysqlV26DownSQL = "DROP TABLE `{{webdav_props}}` CASCADE;"
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
@@ -320,6 +330,10 @@ func (p *MySQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *MySQLProvider) updatePublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
 func (p *MySQLProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -506,6 +520,10 @@ func (p *MySQLProvider) dumpShares() ([]Share, error) {
 	return sqlCommonDumpShares(p.dbHandle)
 }
 
+func (p *MySQLProvider) getInternalSharesForUser(username string, groups []string) ([]Share, error) {
+	return sqlCommonGetInternalSharesForUser(username, groups, p.dbHandle)
+}
+
 func (p *MySQLProvider) updateShareLastUse(shareID string, numTokens int) error {
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
@@ -574,10 +592,30 @@ func (p *MySQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *MySQLProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
+func (p *MySQLProvider) getWebDAVProps(username, virtualPath string) ([]byte, error) {
+	return sqlCommonGetWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
+func (p *MySQLProvider) setWebDAVProps(username, virtualPath string, data []byte) error {
+	return sqlCommonSetWebDAVProps(username, virtualPath, data, p.dbHandle)
+}
+
+func (p *MySQLProvider) renameWebDAVProps(username, source, target string) error {
+	return sqlCommonRenameWebDAVProps(username, source, target, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteWebDAVProps(username, virtualPath string) error {
+	return sqlCommonDeleteWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
 func (p *MySQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
 	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
 }
@@ -739,6 +777,10 @@ func (p *MySQLProvider) migrateDatabase() error { //nolint:dupl
 		return err
 	case version == 23:
 		return updateMySQLDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updateMySQLDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updateMySQLDatabaseFromV25(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -763,6 +805,10 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 24:
 		return downgradeMySQLDatabaseFromV24(p.dbHandle)
+	case 25:
+		return downgradeMySQLDatabaseFromV25(p.dbHandle)
+	case 26:
+		return downgradeMySQLDatabaseFromV26(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -774,13 +820,41 @@ func (p *MySQLProvider) resetDatabase() error {
 }
 
 func updateMySQLDatabaseFromV23(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom23To24(dbHandle)
+	if err := updateMySQLDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV24(dbHandle)
+}
+
+func updateMySQLDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV25(dbHandle)
+}
+
+func updateMySQLDatabaseFromV25(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom25To26(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV24(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFrom24To23(dbHandle)
 }
 
+func downgradeMySQLDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV24(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV25(dbHandle)
+}
+
 func updateMySQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -800,3 +874,33 @@ func downgradeMySQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 23, false)
 }
+
+func updateMySQLDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(mysqlV25SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 25, true)
+}
+
+func downgradeMySQLDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(mysqlV25DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 24, false)
+}
+
+func updateMySQLDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(mysqlV26SQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 26, true)
+}
+
+func downgradeMySQLDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(mysqlV26DownSQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 25, false)
+}
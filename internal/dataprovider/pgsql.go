@@ -44,6 +44,7 @@ DROP TABLE IF EXISTS "{{groups_folders_mapping}}" CASCADE;
 DROP TABLE IF EXISTS "{{admins}}" CASCADE;
 DROP TABLE IF EXISTS "{{folders}}" CASCADE;
 DROP TABLE IF EXISTS "{{shares}}" CASCADE;
+DROP TABLE IF EXISTS "{{webdav_props}}" CASCADE;
 DROP TABLE IF EXISTS "{{users}}" CASCADE;
 DROP TABLE IF EXISTS "{{groups}}" CASCADE;
 DROP TABLE IF EXISTS "{{defender_events}}" CASCADE;
@@ -194,6 +195,15 @@ CREATE INDEX "{{prefix}}users_role_id_idx" ON "{{users}}" ("role_id");
 ALTER TABLE "{{admins}}" DROP COLUMN "role_id" CASCADE;
 DROP TABLE "{{roles}}" CASCADE;
 `
+	pgsqlV25SQL     = `ALTER TABLE "{{shares}}" ADD COLUMN "recipients" text NULL;`
+	pgsqlV25DownSQL = `ALTER TABLE "{{shares}}" DROP COLUMN "recipients" CASCADE;`
+	pgsqlV26SQL     = `CREATE TABLE "{{webdav_props}}" ("id" serial NOT NULL PRIMARY KEY, "path_hash" varchar(64) NOT NULL,
+"path" text NOT NULL, "data" bytea NOT NULL, "updated_at" bigint NOT NULL, "user_id" integer NOT NULL);
+ALTER TABLE "{{webdav_props}}" ADD CONSTRAINT "{{prefix}}unique_webdav_props_path" UNIQUE ("user_id", "path_hash");
+ALTER TABLE "{{webdav_props}}" ADD CONSTRAINT "{{prefix}}webdav_props_user_id_fk_users_id" FOREIGN KEY ("user_id")
+REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+`
+	pgsqlV26DownSQL = `DROP TABLE "{{webdav_props}}" CASCADE;`
 )
 
 // PGSQLProvider defines the auth provider for PostgreSQL database
@@ -290,6 +300,10 @@ func (p *PGSQLProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updatePublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
 func (p *PGSQLProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -476,6 +490,10 @@ func (p *PGSQLProvider) dumpShares() ([]Share, error) {
 	return sqlCommonDumpShares(p.dbHandle)
 }
 
+func (p *PGSQLProvider) getInternalSharesForUser(username string, groups []string) ([]Share, error) {
+	return sqlCommonGetInternalSharesForUser(username, groups, p.dbHandle)
+}
+
 func (p *PGSQLProvider) updateShareLastUse(shareID string, numTokens int) error {
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
@@ -544,10 +562,30 @@ func (p *PGSQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getWebDAVProps(username, virtualPath string) ([]byte, error) {
+	return sqlCommonGetWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
+func (p *PGSQLProvider) setWebDAVProps(username, virtualPath string, data []byte) error {
+	return sqlCommonSetWebDAVProps(username, virtualPath, data, p.dbHandle)
+}
+
+func (p *PGSQLProvider) renameWebDAVProps(username, source, target string) error {
+	return sqlCommonRenameWebDAVProps(username, source, target, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteWebDAVProps(username, virtualPath string) error {
+	return sqlCommonDeleteWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
 	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
 }
@@ -709,6 +747,10 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 		return err
 	case version == 23:
 		return updatePgSQLDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updatePgSQLDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updatePgSQLDatabaseFromV25(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -733,6 +775,10 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 24:
 		return downgradePgSQLDatabaseFromV24(p.dbHandle)
+	case 25:
+		return downgradePgSQLDatabaseFromV25(p.dbHandle)
+	case 26:
+		return downgradePgSQLDatabaseFromV26(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -744,13 +790,41 @@ func (p *PGSQLProvider) resetDatabase() error {
 }
 
 func updatePgSQLDatabaseFromV23(dbHandle *sql.DB) error {
-	return updatePgSQLDatabaseFrom23To24(dbHandle)
+	if err := updatePgSQLDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV24(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV25(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV25(dbHandle *sql.DB) error {
+	return updatePgSQLDatabaseFrom25To26(dbHandle)
 }
 
 func downgradePgSQLDatabaseFromV24(dbHandle *sql.DB) error {
 	return downgradePgSQLDatabaseFrom24To23(dbHandle)
 }
 
+func downgradePgSQLDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV24(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV25(dbHandle)
+}
+
 func updatePgSQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -770,3 +844,33 @@ func downgradePgSQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 23, false)
 }
+
+func updatePgSQLDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(pgsqlV25SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, true)
+}
+
+func downgradePgSQLDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(pgsqlV25DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, false)
+}
+
+func updatePgSQLDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(pgsqlV26SQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, true)
+}
+
+func downgradePgSQLDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(pgsqlV26DownSQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, false)
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// supported expiry-time formats, they are the same accepted by OpenSSH
+var publicKeyExpiryTimeFormats = []string{"20060102", "200601021504", "20060102150405"}
+
+// PublicKeyOptions defines the OpenSSH authorized_keys options supported for the users' public keys.
+// Unsupported options are ignored
+type PublicKeyOptions struct {
+	// Patterns for the allowed source addresses, from the "from" option.
+	// Patterns can be IP addresses, wildcards or IP/Mask in CIDR format and can be
+	// negated prefixing them with "!"
+	From []string
+	// Expiration, from the "expiry-time" option, a zero value means no expiration
+	ExpiryTime time.Time
+	// Forced command, from the "command" option
+	Command string
+	// NoPortForwarding is set using the "no-port-forwarding" or the "restrict" options
+	NoPortForwarding bool
+	// NoPty is set using the "no-pty" or the "restrict" options
+	NoPty bool
+}
+
+// IsExpired returns true if the key is expired
+func (o *PublicKeyOptions) IsExpired() bool {
+	return !o.ExpiryTime.IsZero() && o.ExpiryTime.Before(time.Now())
+}
+
+// IsSourceAllowed returns true if the key can be used from the specified IP address
+func (o *PublicKeyOptions) IsSourceAllowed(ip string) bool {
+	if len(o.From) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	allowed := false
+	for _, pattern := range o.From {
+		negated := strings.HasPrefix(pattern, "!")
+		if matchPublicKeySourcePattern(strings.TrimPrefix(pattern, "!"), ip, parsedIP) {
+			if negated {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func (o *PublicKeyOptions) check(ip string) error {
+	if o.IsExpired() {
+		return fmt.Errorf("key expired on %s", o.ExpiryTime.UTC().Format(iso8601UTCFormat))
+	}
+	if !o.IsSourceAllowed(ip) {
+		return fmt.Errorf("key not allowed from ip %q, allowed sources: %s", ip, strings.Join(o.From, ","))
+	}
+	return nil
+}
+
+func matchPublicKeySourcePattern(pattern, ip string, parsedIP net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, ipNet, err := net.ParseCIDR(pattern)
+		return err == nil && parsedIP != nil && ipNet.Contains(parsedIP)
+	}
+	matched, err := path.Match(pattern, ip)
+	return err == nil && matched
+}
+
+func unquotePublicKeyOptionValue(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	return strings.ReplaceAll(value, `\"`, `"`)
+}
+
+func parsePublicKeyExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		loc = time.UTC
+		value = value[:len(value)-1]
+	}
+	for _, layout := range publicKeyExpiryTimeFormats {
+		if len(layout) == len(value) {
+			return time.ParseInLocation(layout, value, loc)
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry-time %q", value)
+}
+
+// forcedCommandValidator checks the commands set using the "command" option.
+// It is registered by the SFTP service, that executes the forced commands
+var forcedCommandValidator func(command string) error
+
+// SetForcedCommandValidator sets the function used to validate the forced commands
+func SetForcedCommandValidator(fn func(command string) error) {
+	forcedCommandValidator = fn
+}
+
+func validatePublicKeyForcedCommand(command string) error {
+	if command == "" || forcedCommandValidator == nil {
+		return nil
+	}
+	return forcedCommandValidator(command)
+}
+
+// ParsePublicKeyOptions parses the authorized_keys options, as returned by ssh.ParseAuthorizedKey
+func ParsePublicKeyOptions(options []string) (PublicKeyOptions, error) {
+	var result PublicKeyOptions
+
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if hasValue {
+			value = unquotePublicKeyOptionValue(value)
+		}
+		switch name {
+		case "from":
+			if !hasValue || value == "" {
+				return result, fmt.Errorf("the %q option requires a value", name)
+			}
+			for _, pattern := range strings.Split(value, ",") {
+				pattern = strings.TrimSpace(pattern)
+				if pattern == "" {
+					continue
+				}
+				p := strings.TrimPrefix(pattern, "!")
+				if strings.Contains(p, "/") {
+					if _, _, err := net.ParseCIDR(p); err != nil {
+						return result, fmt.Errorf("invalid source address %q: %w", pattern, err)
+					}
+				} else if _, err := path.Match(p, ""); err != nil {
+					return result, fmt.Errorf("invalid source address pattern %q: %w", pattern, err)
+				}
+				result.From = append(result.From, pattern)
+			}
+		case "expiry-time":
+			expiryTime, err := parsePublicKeyExpiryTime(value)
+			if err != nil {
+				return result, err
+			}
+			result.ExpiryTime = expiryTime
+		case "command":
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return result, fmt.Errorf("the %q option requires a value", name)
+			}
+			result.Command = value
+		case "restrict":
+			result.NoPortForwarding = true
+			result.NoPty = true
+		case "no-port-forwarding":
+			result.NoPortForwarding = true
+		case "port-forwarding":
+			result.NoPortForwarding = false
+		case "no-pty":
+			result.NoPty = true
+		case "pty":
+			result.NoPty = false
+		}
+	}
+	return result, nil
+}
+
+// IsSecurityKey returns true if the provided key, or the key for the provided certificate,
+// is a FIDO/U2F security key
+func IsSecurityKey(pubKey ssh.PublicKey) bool {
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		pubKey = cert.Key
+	}
+	switch pubKey.Type() {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return true
+	default:
+		return false
+	}
+}
+
+// PublicKeyInfo defines the details for a public key displayed in the web interfaces
+type PublicKeyInfo struct {
+	Key         string
+	Label       string
+	Fingerprint string
+	Options     []string
+	LastUse     int64
+}
+
+// GetLastUseAsString returns the last use as string
+func (k PublicKeyInfo) GetLastUseAsString() string {
+	if k.LastUse > 0 {
+		return util.GetTimeFromMsecSinceEpoch(k.LastUse).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+// GetOptionsAsString returns the key options as comma separated string
+func (k PublicKeyInfo) GetOptionsAsString() string {
+	return strings.Join(k.Options, ",")
+}
+
+// GetPublicKeysInfo returns the details for the user public keys
+func (u *User) GetPublicKeysInfo() []PublicKeyInfo {
+	result := make([]PublicKeyInfo, 0, len(u.PublicKeys))
+	for _, k := range u.PublicKeys {
+		info := PublicKeyInfo{
+			Key: k,
+		}
+		pubKey, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err == nil {
+			info.Label = comment
+			info.Fingerprint = ssh.FingerprintSHA256(pubKey)
+			info.Options = options
+			info.LastUse = u.Filters.PublicKeysLastUse[info.Fingerprint]
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// GetPublicKeyOptions returns the options for the stored public key matching the provided one
+func (u *User) GetPublicKeyOptions(pubKey []byte) (PublicKeyOptions, error) {
+	for _, k := range u.PublicKeys {
+		storedPubKey, _, options, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(storedPubKey.Marshal(), pubKey) {
+			return ParsePublicKeyOptions(options)
+		}
+	}
+	return PublicKeyOptions{}, util.NewRecordNotFoundError("public key not found")
+}
+
+// CheckPublicKeyOptions returns the options for the stored public key matching the provided one and
+// an error if the options does not allow to login from the specified IP address
+func (u *User) CheckPublicKeyOptions(pubKey []byte, ip string) (PublicKeyOptions, error) {
+	options, err := u.GetPublicKeyOptions(pubKey)
+	if err != nil {
+		return options, err
+	}
+	return options, options.check(ip)
+}
+
+func (u *User) prunePublicKeysLastUse() {
+	if len(u.Filters.PublicKeysLastUse) == 0 {
+		u.Filters.PublicKeysLastUse = nil
+		return
+	}
+	fingerprints := make(map[string]bool)
+	for _, k := range u.PublicKeys {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err == nil {
+			fingerprints[ssh.FingerprintSHA256(pubKey)] = true
+		}
+	}
+	for fp := range u.Filters.PublicKeysLastUse {
+		if !fingerprints[fp] {
+			delete(u.Filters.PublicKeysLastUse, fp)
+		}
+	}
+}
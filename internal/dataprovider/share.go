@@ -18,14 +18,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alexedwards/argon2id"
+	"github.com/sftpgo/sdk"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 // ShareScope defines the supported share scopes
@@ -42,6 +48,64 @@ const (
 	redactedPassword = "[**redacted**]"
 )
 
+// SharedWithMeDir is the virtual directory containing the directories shared
+// with a user using internal shares
+const SharedWithMeDir = "/Shared with me"
+
+var (
+	// the internal shares for a recipient are cached to avoid loading them for
+	// each request for the protocols, such as WebDAV and HTTP, that create a
+	// connection for each request. The cache is cleared when a share changes
+	internalSharesCache     = newInternalSharesForRecipientsCache()
+	internalSharesCacheTime = 2 * time.Minute
+)
+
+type cachedInternalShares struct {
+	key      string
+	mounts   []internalShareMount
+	loadedAt time.Time
+}
+
+type internalSharesForRecipientsCache struct {
+	sync.RWMutex
+	entries map[string]cachedInternalShares
+}
+
+func newInternalSharesForRecipientsCache() *internalSharesForRecipientsCache {
+	return &internalSharesForRecipientsCache{
+		entries: make(map[string]cachedInternalShares),
+	}
+}
+
+func (c *internalSharesForRecipientsCache) get(username, key string) ([]internalShareMount, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[username]
+	if !ok || entry.key != key || time.Since(entry.loadedAt) > internalSharesCacheTime {
+		return nil, false
+	}
+	return entry.mounts, true
+}
+
+func (c *internalSharesForRecipientsCache) add(username, key string, mounts []internalShareMount) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries[username] = cachedInternalShares{
+		key:      key,
+		mounts:   mounts,
+		loadedAt: time.Now(),
+	}
+}
+
+func (c *internalSharesForRecipientsCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[string]cachedInternalShares)
+}
+
 // Share defines files and or directories shared with external users
 type Share struct {
 	// Database unique identifier
@@ -69,6 +133,11 @@ type Share struct {
 	UsedTokens int `json:"used_tokens,omitempty"`
 	// Limit the share availability to these IPs/CIDR networks
 	AllowFrom []string `json:"allow_from,omitempty"`
+	// Users and groups to share with. If set, the share is internal: it is not
+	// available as a public link and the recipients see the shared directory
+	// as a virtual folder
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
 	// set for restores, we don't have to validate the expiration date
 	// otherwise we fail to restore existing shares and we have to insert
 	// all the previous values with no modifications
@@ -99,6 +168,9 @@ func (s *Share) IsExpired() bool {
 // GetInfoString returns share's info as string.
 func (s *Share) GetInfoString() string {
 	var result strings.Builder
+	if s.IsInternal() {
+		result.WriteString(fmt.Sprintf("%v. ", s.GetRecipientsAsString()))
+	}
 	if s.ExpiresAt > 0 {
 		t := util.GetTimeFromMsecSinceEpoch(s.ExpiresAt)
 		result.WriteString(fmt.Sprintf("Expiration: %v. ", t.Format("2006-01-02 15:04"))) // YYYY-MM-DD HH:MM
@@ -126,9 +198,66 @@ func (s *Share) GetAllowedFromAsString() string {
 	return strings.Join(s.AllowFrom, ",")
 }
 
+// GetUsersAsString returns the users to share with as comma separated string
+func (s *Share) GetUsersAsString() string {
+	return strings.Join(s.Users, ",")
+}
+
+// GetGroupsAsString returns the groups to share with as comma separated string
+func (s *Share) GetGroupsAsString() string {
+	return strings.Join(s.Groups, ",")
+}
+
+// IsInternal returns true if the share targets SFTPGo users or groups
+func (s *Share) IsInternal() bool {
+	return len(s.Users) > 0 || len(s.Groups) > 0
+}
+
+// IsSharedWith returns true if this internal share targets the specified user
+// directly or using one of its groups
+func (s *Share) IsSharedWith(user *User) bool {
+	groups := make([]string, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		groups = append(groups, g.Name)
+	}
+	return s.isSharedWith(user.Username, groups)
+}
+
+func (s *Share) isSharedWith(username string, groups []string) bool {
+	if username == s.Username {
+		return false
+	}
+	if util.Contains(s.Users, username) {
+		return true
+	}
+	for _, g := range groups {
+		if util.Contains(s.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRecipientsAsString returns the share recipients as string.
+// Used in web pages
+func (s *Share) GetRecipientsAsString() string {
+	var result []string
+	if len(s.Users) > 0 {
+		result = append(result, fmt.Sprintf("Users: %s", strings.Join(s.Users, ", ")))
+	}
+	if len(s.Groups) > 0 {
+		result = append(result, fmt.Sprintf("Groups: %s", strings.Join(s.Groups, ", ")))
+	}
+	return strings.Join(result, ". ")
+}
+
 func (s *Share) getACopy() Share {
 	allowFrom := make([]string, len(s.AllowFrom))
 	copy(allowFrom, s.AllowFrom)
+	users := make([]string, len(s.Users))
+	copy(users, s.Users)
+	groups := make([]string, len(s.Groups))
+	copy(groups, s.Groups)
 
 	return Share{
 		ID:          s.ID,
@@ -146,6 +275,8 @@ func (s *Share) getACopy() Share {
 		MaxTokens:   s.MaxTokens,
 		UsedTokens:  s.UsedTokens,
 		AllowFrom:   allowFrom,
+		Users:       users,
+		Groups:      groups,
 	}
 }
 
@@ -269,9 +400,267 @@ func (s *Share) validate() error {
 			return util.NewValidationError(fmt.Sprintf("could not parse allow from entry %#v : %v", IPMask, err))
 		}
 	}
+	return s.validateRecipients()
+}
+
+func (s *Share) validateRecipients() error {
+	s.Users = removeEmptyValues(util.RemoveDuplicates(s.Users, true))
+	s.Groups = removeEmptyValues(util.RemoveDuplicates(s.Groups, true))
+	if !s.IsInternal() {
+		return nil
+	}
+	if util.Contains(s.Users, s.Username) {
+		return util.NewValidationError("you cannot share with yourself")
+	}
+	if len(s.Paths) != 1 || s.Paths[0] == "/" {
+		return util.NewValidationError("internal shares require exactly one directory, different from the root one")
+	}
+	if s.Password != "" || s.MaxTokens > 0 || len(s.AllowFrom) > 0 {
+		return util.NewValidationError("password, max tokens and allow from are not supported for internal shares")
+	}
+	return nil
+}
+
+// getOwner returns the user sharing this object or nil if the user cannot
+// share anymore
+func (s *Share) getOwner() *User {
+	owner, err := provider.userExists(s.Username, "")
+	if err == nil {
+		err = owner.LoadAndApplyGroupSettings()
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get owner for share %q: %v", s.ShareID, err)
+		return nil
+	}
+	if owner.Status == 0 || owner.GetStatusAsString() == "Expired" || !owner.CanManageShares() {
+		return nil
+	}
+	return &owner
+}
+
+// internalShareMount defines how an internal share is exposed to the recipients.
+// Permissions and file patterns paths are relative to the mount point
+type internalShareMount struct {
+	shareID      string
+	owner        string
+	name         string
+	folder       vfs.VirtualFolder
+	permissions  map[string][]string
+	filePatterns []sdk.PatternsFilter
+	expiresAt    int64
+}
+
+// getMountName returns the name of the directory, inside the owner's directory
+// in SharedWithMeDir, where this internal share is mounted
+func (s *Share) getMountName() string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(s.Name)
+	if name == "" || name == "." || name == ".." {
+		return s.ShareID
+	}
+	return name
+}
+
+// getMount returns the virtual folder, the permissions and the file patterns
+// to use to expose this internal share. The owner's permissions and file
+// patterns for the shared path and its sub-paths are rebased under the mount point
+func (s *Share) getMount(owner *User) (internalShareMount, error) {
+	if len(s.Paths) != 1 {
+		return internalShareMount{}, fmt.Errorf("unexpected shared paths: %v", s.Paths)
+	}
+	sharedPath := s.Paths[0]
+	if owner.isDirHidden(sharedPath) {
+		return internalShareMount{}, fmt.Errorf("path %q is not allowed", sharedPath)
+	}
+	perms := s.getPermissions(owner.GetPermissionsForPath(sharedPath))
+	if len(perms) == 0 {
+		return internalShareMount{}, fmt.Errorf("no permissions for path %q", sharedPath)
+	}
+	mount := internalShareMount{
+		shareID:     s.ShareID,
+		owner:       owner.Username,
+		name:        s.getMountName(),
+		permissions: map[string][]string{"/": perms},
+		expiresAt:   s.ExpiresAt,
+	}
+	for dir, ownerPerms := range owner.Permissions {
+		if rel, ok := getSharedRelativePath(sharedPath, dir); ok && rel != "/" {
+			mount.permissions[rel] = s.getPermissions(ownerPerms)
+		}
+	}
+	if filter := owner.getPatternsFilterForPath(sharedPath); filter.Path != "" {
+		filter.Path = "/"
+		mount.filePatterns = append(mount.filePatterns, filter)
+	}
+	for _, filter := range owner.Filters.FilePatterns {
+		if rel, ok := getSharedRelativePath(sharedPath, filter.Path); ok && rel != "/" {
+			filter.Path = rel
+			mount.filePatterns = append(mount.filePatterns, filter)
+		}
+	}
+
+	folder := vfs.VirtualFolder{}
+	ownerFolder, err := owner.GetVirtualFolderForPath(sharedPath)
+	if err == nil {
+		folder.BaseVirtualFolder = ownerFolder.BaseVirtualFolder.GetACopy()
+		if ownerFolder.IsIncludedInUserQuota() {
+			folder.Name = fmt.Sprintf("share_%s", s.ShareID)
+			folder.SharedBy = owner.Username
+			folder.QuotaSize = owner.QuotaSize
+			folder.QuotaFiles = owner.QuotaFiles
+		} else {
+			folder.QuotaSize = ownerFolder.QuotaSize
+			folder.QuotaFiles = ownerFolder.QuotaFiles
+		}
+		sharedPath = util.CleanPath(strings.TrimPrefix(sharedPath, ownerFolder.VirtualPath))
+	} else {
+		folder.BaseVirtualFolder = vfs.BaseVirtualFolder{
+			Name:       fmt.Sprintf("share_%s", s.ShareID),
+			MappedPath: owner.GetHomeDir(),
+			FsConfig:   owner.FsConfig.GetACopy(),
+			SharedBy:   owner.Username,
+		}
+		folder.QuotaSize = owner.QuotaSize
+		folder.QuotaFiles = owner.QuotaFiles
+	}
+	folder.ID = 0
+	folder.Users = nil
+	folder.Groups = nil
+	if err := rebaseSharedFolder(&folder, sharedPath); err != nil {
+		return mount, err
+	}
+	mount.folder = folder
+	return mount, nil
+}
+
+// apply returns the virtual folder, the permissions and the file patterns for
+// this mount, using the specified mount point
+func (m *internalShareMount) apply(mountPath string) (vfs.VirtualFolder, map[string][]string, []sdk.PatternsFilter) {
+	folder := vfs.VirtualFolder{
+		BaseVirtualFolder: m.folder.BaseVirtualFolder.GetACopy(),
+		VirtualPath:       mountPath,
+		QuotaSize:         m.folder.QuotaSize,
+		QuotaFiles:        m.folder.QuotaFiles,
+	}
+	permissions := make(map[string][]string)
+	for rel, perms := range m.permissions {
+		permissions[path.Join(mountPath, rel)] = perms
+	}
+	filePatterns := make([]sdk.PatternsFilter, 0, len(m.filePatterns))
+	for _, filter := range m.filePatterns {
+		filter.Path = path.Join(mountPath, filter.Path)
+		filePatterns = append(filePatterns, filter)
+	}
+	return folder, permissions, filePatterns
+}
+
+// getSharedRelativePath returns the specified virtual path relative to the shared
+// path and true if it is the shared path or it is inside it
+func getSharedRelativePath(sharedPath, virtualPath string) (string, bool) {
+	if virtualPath == sharedPath {
+		return "/", true
+	}
+	if strings.HasPrefix(virtualPath, sharedPath+"/") {
+		return strings.TrimPrefix(virtualPath, sharedPath), true
+	}
+	return "", false
+}
+
+func (s *Share) getPermissions(ownerPerms []string) []string {
+	var perms []string
+	switch s.Scope {
+	case ShareScopeWrite:
+		perms = []string{PermUpload, PermCreateDirs}
+	case ShareScopeReadWrite:
+		perms = []string{PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename, PermDelete}
+	default:
+		perms = []string{PermListItems, PermDownload}
+	}
+	if util.Contains(ownerPerms, PermAny) {
+		return perms
+	}
+	var result []string
+	for _, p := range perms {
+		if util.Contains(ownerPerms, p) {
+			result = append(result, p)
+			continue
+		}
+		switch p {
+		case PermDelete:
+			for _, granular := range []string{PermDeleteFiles, PermDeleteDirs} {
+				if util.Contains(ownerPerms, granular) {
+					result = append(result, granular)
+				}
+			}
+		case PermRename:
+			for _, granular := range []string{PermRenameFiles, PermRenameDirs} {
+				if util.Contains(ownerPerms, granular) {
+					result = append(result, granular)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// rebaseSharedFolder changes the folder root to the specified path, relative
+// to the current root
+func rebaseSharedFolder(folder *vfs.VirtualFolder, relPath string) error {
+	if relPath == "/" {
+		return nil
+	}
+	switch folder.FsConfig.Provider {
+	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
+		mappedPath, err := getSharedLocalPath(folder.MappedPath, relPath)
+		if err != nil {
+			return err
+		}
+		folder.MappedPath = mappedPath
+	case sdk.S3FilesystemProvider:
+		folder.FsConfig.S3Config.KeyPrefix = getSharedKeyPrefix(folder.FsConfig.S3Config.KeyPrefix, relPath)
+	case sdk.GCSFilesystemProvider:
+		folder.FsConfig.GCSConfig.KeyPrefix = getSharedKeyPrefix(folder.FsConfig.GCSConfig.KeyPrefix, relPath)
+	case sdk.AzureBlobFilesystemProvider:
+		folder.FsConfig.AzBlobConfig.KeyPrefix = getSharedKeyPrefix(folder.FsConfig.AzBlobConfig.KeyPrefix, relPath)
+	case sdk.SFTPFilesystemProvider:
+		folder.FsConfig.SFTPConfig.Prefix = path.Join(util.CleanPath(folder.FsConfig.SFTPConfig.Prefix), relPath)
+	default:
+		return fmt.Errorf("internal shares are not supported for filesystem provider %v", folder.FsConfig.Provider)
+	}
 	return nil
 }
 
+// getSharedLocalPath returns the local path for the specified relative path with
+// any symlink resolved. The shared path must be inside the specified root
+func getSharedLocalPath(root, relPath string) (string, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the shared root %q: %w", root, err)
+	}
+	resolvedPath, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(relPath)))
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the shared path %q: %w", relPath, err)
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolvedPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", util.NewValidationError(fmt.Sprintf("the shared path %q is outside the owner's root", relPath))
+	}
+	return resolvedPath, nil
+}
+
+func getSharedKeyPrefix(keyPrefix, relPath string) string {
+	return strings.TrimPrefix(path.Join("/", keyPrefix, relPath), "/") + "/"
+}
+
+func removeEmptyValues(values []string) []string {
+	var result []string
+	for _, val := range values {
+		if val != "" {
+			result = append(result, val)
+		}
+	}
+	return result
+}
+
 // CheckCredentials verifies the share credentials if a password if set
 func (s *Share) CheckCredentials(username, password string) (bool, error) {
 	if s.Password == "" {
@@ -34,7 +34,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 24
+	sqlDatabaseVersion     = 26
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -79,6 +79,7 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{tasks}}", sqlTableTasks)
 	sql = strings.ReplaceAll(sql, "{{nodes}}", sqlTableNodes)
 	sql = strings.ReplaceAll(sql, "{{roles}}", sqlTableRoles)
+	sql = strings.ReplaceAll(sql, "{{webdav_props}}", sqlTableWebDAVProps)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sql
 }
@@ -122,6 +123,10 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 			allowFrom = string(res)
 		}
 	}
+	recipients, err := getShareRecipientsAsNullString(share)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -143,7 +148,7 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 	}
 	_, err = dbHandle.ExecContext(ctx, q, share.ShareID, share.Name, share.Description, share.Scope,
 		string(paths), createdAt, updatedAt, lastUseAt, share.ExpiresAt, share.Password,
-		share.MaxTokens, usedTokens, allowFrom, user.ID)
+		share.MaxTokens, usedTokens, allowFrom, recipients, user.ID)
 	return err
 }
 
@@ -166,6 +171,11 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		}
 	}
 
+	recipients, err := getShareRecipientsAsNullString(share)
+	if err != nil {
+		return err
+	}
+
 	user, err := provider.userExists(share.Username, "")
 	if err != nil {
 		return util.NewValidationError(fmt.Sprintf("unable to validate user %#v", share.Username))
@@ -190,11 +200,11 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		}
 		_, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, string(paths),
 			share.CreatedAt, share.UpdatedAt, share.LastUseAt, share.ExpiresAt, share.Password, share.MaxTokens,
-			share.UsedTokens, allowFrom, user.ID, share.ShareID)
+			share.UsedTokens, allowFrom, recipients, user.ID, share.ShareID)
 	} else {
 		_, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, string(paths),
 			util.GetTimeAsMsSinceEpoch(time.Now()), share.ExpiresAt, share.Password, share.MaxTokens,
-			allowFrom, user.ID, share.ShareID)
+			allowFrom, recipients, user.ID, share.ShareID)
 	}
 	return err
 }
@@ -258,6 +268,45 @@ func sqlCommonDumpShares(dbHandle sqlQuerier) ([]Share, error) {
 	return shares, rows.Err()
 }
 
+// sqlCommonGetInternalSharesForUser returns the internal shares targeting the specified
+// user or groups. The recipients are stored as JSON so the query only preselects
+// the shares containing the quoted names, the exact match is checked here
+func sqlCommonGetInternalSharesForUser(username string, groups []string, dbHandle sqlQuerier) ([]Share, error) {
+	var shares []Share
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	recipients := append([]string{username}, groups...)
+	var args []any
+	if len(recipients) <= len(sqlPlaceholders) {
+		for _, name := range recipients {
+			quoted, err := json.Marshal(name)
+			if err != nil {
+				return shares, err
+			}
+			args = append(args, "%"+string(quoted)+"%")
+		}
+	}
+	q := getInternalSharesQuery(len(args))
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	if err != nil {
+		return shares, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := getShareFromDbRow(rows)
+		if err != nil {
+			return shares, err
+		}
+		if s.isSharedWith(username, groups) {
+			shares = append(shares, s)
+		}
+	}
+
+	return shares, rows.Err()
+}
+
 func sqlCommonGetAPIKeyByID(keyID string, dbHandle sqlQuerier) (APIKey, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -1082,6 +1131,20 @@ func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 	return err
 }
 
+func sqlCommonUpdatePublicKeyLastUse(username, fingerprint string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdatePublicKeyLastUseQuery()
+	_, err := dbHandle.ExecContext(ctx, q, fingerprint, util.GetTimeAsMsSinceEpoch(time.Now()), username)
+	if err == nil {
+		providerLog(logger.LevelDebug, "last use updated for public key %q, user %q", fingerprint, username)
+	} else {
+		providerLog(logger.LevelWarn, "error updating last use for public key %q, user %q: %v", fingerprint, username, err)
+	}
+	return err
+}
+
 func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 	err := ValidateUser(user)
 	if err != nil {
@@ -1716,15 +1779,35 @@ func sqlCommonCleanupDefenderEvents(from int64, dbHandle *sql.DB) error {
 	return err
 }
 
+// shareRecipients defines the recipients for internal shares as stored in SQL databases
+type shareRecipients struct {
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func getShareRecipientsAsNullString(share *Share) (sql.NullString, error) {
+	if !share.IsInternal() {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(shareRecipients{
+		Users:  share.Users,
+		Groups: share.Groups,
+	})
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
 func getShareFromDbRow(row sqlScanner) (Share, error) {
 	var share Share
 	var description, password sql.NullString
-	var allowFrom, paths []byte
+	var allowFrom, paths, recipients []byte
 
 	err := row.Scan(&share.ShareID, &share.Name, &description, &share.Scope,
 		&paths, &share.Username, &share.CreatedAt, &share.UpdatedAt,
 		&share.LastUseAt, &share.ExpiresAt, &password, &share.MaxTokens,
-		&share.UsedTokens, &allowFrom)
+		&share.UsedTokens, &allowFrom, &recipients)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return share, util.NewRecordNotFoundError(err.Error())
@@ -1748,6 +1831,14 @@ func getShareFromDbRow(row sqlScanner) (Share, error) {
 	if err == nil {
 		share.AllowFrom = list
 	}
+	if len(recipients) > 0 {
+		var r shareRecipients
+		if err := json.Unmarshal(recipients, &r); err != nil {
+			return share, err
+		}
+		share.Users = r.Users
+		share.Groups = r.Groups
+	}
 	return share, nil
 }
 
@@ -2962,6 +3053,30 @@ func sqlCommonGetSession(key string, dbHandle sqlQuerier) (Session, error) {
 	return session, nil
 }
 
+func sqlCommonGetSessions(sessionType SessionType, after int64, dbHandle sqlQuerier) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSessionsQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, sessionType, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var data []byte // type hint, some driver will use string instead of []byte if the type is any
+		if err := rows.Scan(&session.Key, &data, &session.Type, &session.Timestamp); err != nil {
+			return nil, err
+		}
+		session.Data = data
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 func sqlCommonDeleteSession(key string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -2983,6 +3098,119 @@ func sqlCommonCleanupSessions(sessionType SessionType, before int64, dbHandle *s
 	return err
 }
 
+func sqlCommonGetWebDAVProps(username, virtualPath string, dbHandle sqlQuerier) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getWebDAVPropsQuery()
+	var data []byte
+	err := dbHandle.QueryRowContext(ctx, q, username, getWebDAVPropsPathHash(virtualPath)).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, util.NewRecordNotFoundError(fmt.Sprintf("no WebDAV properties for path %q", virtualPath))
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func sqlCommonSetWebDAVProps(username, virtualPath string, data []byte, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	pathHash := getWebDAVPropsPathHash(virtualPath)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getDeletePathWebDAVPropsQuery()
+		if _, err := tx.ExecContext(ctx, q, username, pathHash); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		q = getAddWebDAVPropsQuery()
+		res, err := tx.ExecContext(ctx, q, pathHash, virtualPath, data, util.GetTimeAsMsSinceEpoch(time.Now()),
+			username)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
+}
+
+func sqlCommonRenameWebDAVProps(username, source, target string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		sourcePaths, err := sqlCommonGetWebDAVPropsTree(ctx, username, source, tx)
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonDeleteWebDAVPropsTree(ctx, username, target, tx); err != nil {
+			return err
+		}
+		q := getUpdateWebDAVPropsPathQuery()
+		updatedAt := util.GetTimeAsMsSinceEpoch(time.Now())
+		for id, p := range sourcePaths {
+			newPath := getWebDAVPropsRenamedPath(p, source, target)
+			if _, err := tx.ExecContext(ctx, q, getWebDAVPropsPathHash(newPath), newPath, updatedAt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonDeleteWebDAVProps(username, virtualPath string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonDeleteWebDAVPropsTree(ctx, username, virtualPath, tx)
+	})
+}
+
+func sqlCommonDeleteWebDAVPropsTree(ctx context.Context, username, virtualPath string, dbHandle sqlQuerier) error {
+	paths, err := sqlCommonGetWebDAVPropsTree(ctx, username, virtualPath, dbHandle)
+	if err != nil {
+		return err
+	}
+	q := getDeleteWebDAVPropsQuery()
+	for id := range paths {
+		if _, err := dbHandle.ExecContext(ctx, q, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlCommonGetWebDAVPropsTree returns the paths, keyed by id, with WebDAV dead
+// properties for the specified virtual path and its children
+func sqlCommonGetWebDAVPropsTree(ctx context.Context, username, virtualPath string, dbHandle sqlQuerier,
+) (map[int64]string, error) {
+	q := getWebDAVPropsTreeQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, username, getWebDAVPropsPathHash(virtualPath),
+		getWebDAVPropsChildrenPattern(virtualPath))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			return nil, err
+		}
+		// LIKE is case insensitive for some databases
+		if isWebDAVPropsPathInTree(p, virtualPath) {
+			paths[id] = p
+		}
+	}
+	return paths, rows.Err()
+}
+
 func getActionsWithRuleNames(ctx context.Context, actions []BaseEventAction, dbHandle sqlQuerier,
 ) ([]BaseEventAction, error) {
 	if len(actions) == 0 {
@@ -46,6 +46,7 @@ DROP TABLE IF EXISTS "{{groups_folders_mapping}}";
 DROP TABLE IF EXISTS "{{admins}}";
 DROP TABLE IF EXISTS "{{folders}}";
 DROP TABLE IF EXISTS "{{shares}}";
+DROP TABLE IF EXISTS "{{webdav_props}}";
 DROP TABLE IF EXISTS "{{users}}";
 DROP TABLE IF EXISTS "{{groups}}";
 DROP TABLE IF EXISTS "{{defender_events}}";
@@ -175,6 +176,14 @@ ALTER TABLE "{{users}}" DROP COLUMN role_id;
 ALTER TABLE "{{admins}}" DROP COLUMN role_id;
 DROP TABLE "{{roles}}";
 `
+	sqliteV25SQL     = `ALTER TABLE "{{shares}}" ADD COLUMN "recipients" text NULL;`
+	sqliteV25DownSQL = `ALTER TABLE "{{shares}}" DROP COLUMN "recipients";`
+	sqliteV26SQL     = `CREATE TABLE "{{webdav_props}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"path_hash" varchar(64) NOT NULL, "path" text NOT NULL, "data" blob NOT NULL, "updated_at" bigint NOT NULL,
+"user_id" integer NOT NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+CONSTRAINT "{{prefix}}unique_webdav_props_path" UNIQUE ("user_id", "path_hash"));
+`
+	sqliteV26DownSQL = `DROP TABLE "{{webdav_props}}";`
 )
 
 // SQLiteProvider defines the auth provider for SQLite database
@@ -250,6 +259,10 @@ func (p *SQLiteProvider) updateLastLogin(username string) error {
 	return sqlCommonUpdateLastLogin(username, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updatePublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdatePublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
 func (p *SQLiteProvider) updateAdminLastLogin(username string) error {
 	return sqlCommonUpdateAdminLastLogin(username, p.dbHandle)
 }
@@ -436,6 +449,10 @@ func (p *SQLiteProvider) dumpShares() ([]Share, error) {
 	return sqlCommonDumpShares(p.dbHandle)
 }
 
+func (p *SQLiteProvider) getInternalSharesForUser(username string, groups []string) ([]Share, error) {
+	return sqlCommonGetInternalSharesForUser(username, groups, p.dbHandle)
+}
+
 func (p *SQLiteProvider) updateShareLastUse(shareID string, numTokens int) error {
 	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
 }
@@ -504,10 +521,30 @@ func (p *SQLiteProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getSharedSessions(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessions(sessionType, after, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getWebDAVProps(username, virtualPath string) ([]byte, error) {
+	return sqlCommonGetWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
+func (p *SQLiteProvider) setWebDAVProps(username, virtualPath string, data []byte) error {
+	return sqlCommonSetWebDAVProps(username, virtualPath, data, p.dbHandle)
+}
+
+func (p *SQLiteProvider) renameWebDAVProps(username, source, target string) error {
+	return sqlCommonRenameWebDAVProps(username, source, target, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteWebDAVProps(username, virtualPath string) error {
+	return sqlCommonDeleteWebDAVProps(username, virtualPath, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
 	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
 }
@@ -669,6 +706,10 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		return err
 	case version == 23:
 		return updateSQLiteDatabaseFromV23(p.dbHandle)
+	case version == 24:
+		return updateSQLiteDatabaseFromV24(p.dbHandle)
+	case version == 25:
+		return updateSQLiteDatabaseFromV25(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -693,6 +734,10 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 24:
 		return downgradeSQLiteDatabaseFromV24(p.dbHandle)
+	case 25:
+		return downgradeSQLiteDatabaseFromV25(p.dbHandle)
+	case 26:
+		return downgradeSQLiteDatabaseFromV26(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -704,13 +749,41 @@ func (p *SQLiteProvider) resetDatabase() error {
 }
 
 func updateSQLiteDatabaseFromV23(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom23To24(dbHandle)
+	if err := updateSQLiteDatabaseFrom23To24(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV24(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV24(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom24To25(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV25(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV25(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom25To26(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV24(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFrom24To23(dbHandle)
 }
 
+func downgradeSQLiteDatabaseFromV25(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom25To24(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV24(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV26(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom26To25(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV25(dbHandle)
+}
+
 func updateSQLiteDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -731,6 +804,36 @@ func downgradeSQLiteDatabaseFrom24To23(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 23, false)
 }
 
+func updateSQLiteDatabaseFrom24To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 24 -> 25")
+	providerLog(logger.LevelInfo, "updating database schema version: 24 -> 25")
+	sql := strings.ReplaceAll(sqliteV25SQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, true)
+}
+
+func downgradeSQLiteDatabaseFrom25To24(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 25 -> 24")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 25 -> 24")
+	sql := strings.ReplaceAll(sqliteV25DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 24, false)
+}
+
+func updateSQLiteDatabaseFrom25To26(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 25 -> 26")
+	providerLog(logger.LevelInfo, "updating database schema version: 25 -> 26")
+	sql := strings.ReplaceAll(sqliteV26SQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 26, true)
+}
+
+func downgradeSQLiteDatabaseFrom26To25(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 26 -> 25")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 26 -> 25")
+	sql := strings.ReplaceAll(sqliteV26DownSQL, "{{webdav_props}}", sqlTableWebDAVProps)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 25, false)
+}
+
 /*func setPragmaFK(dbHandle *sql.DB, value string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
@@ -32,7 +32,7 @@ const (
 	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at,a.updated_at,a.last_login,r.name"
 	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
-		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
+		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from,s.recipients"
 	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
 	selectEventActionFields = "id,name,description,type,options"
 	selectRoleFields        = "id,name,description,created_at,updated_at"
@@ -101,6 +101,15 @@ func getSessionQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getSessionsQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("SELECT `key`,`data`,`type`,`timestamp` FROM %s WHERE `type` = %s AND `timestamp` >= %s",
+			sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+	}
+	return fmt.Sprintf(`SELECT key,data,type,timestamp FROM %s WHERE type = %s AND timestamp >= %s`,
+		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getCleanupSessionsQuery() string {
 	return fmt.Sprintf(`DELETE from %s WHERE type = %s AND timestamp < %s`,
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -374,29 +383,47 @@ func getDumpSharesQuery() string {
 		selectShareFields, sqlTableShares, sqlTableUsers)
 }
 
+func getInternalSharesQuery(numArgs int) string {
+	var sb strings.Builder
+	for idx := 0; idx < numArgs; idx++ {
+		if sb.Len() == 0 {
+			sb.WriteString(" AND (")
+		} else {
+			sb.WriteString(" OR ")
+		}
+		sb.WriteString(fmt.Sprintf("s.recipients LIKE %s", sqlPlaceholders[idx]))
+	}
+	if sb.Len() > 0 {
+		sb.WriteString(")")
+	}
+	return fmt.Sprintf(`SELECT %s FROM %s s INNER JOIN %s u ON s.user_id = u.id WHERE s.recipients IS NOT NULL%s`,
+		selectShareFields, sqlTableShares, sqlTableUsers, sb.String())
+}
+
 func getAddShareQuery() string {
 	return fmt.Sprintf(`INSERT INTO %s (share_id,name,description,scope,paths,created_at,updated_at,last_use_at,
-		expires_at,password,max_tokens,used_tokens,allow_from,user_id) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`,
+		expires_at,password,max_tokens,used_tokens,allow_from,recipients,user_id) VALUES (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s)`,
 		sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
 		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11],
-		sqlPlaceholders[12], sqlPlaceholders[13])
+		sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14])
 }
 
 func getUpdateShareRestoreQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET name=%s,description=%s,scope=%s,paths=%s,created_at=%s,updated_at=%s,
-		last_use_at=%s,expires_at=%s,password=%s,max_tokens=%s,used_tokens=%s,allow_from=%s,user_id=%s WHERE share_id = %s`, sqlTableShares,
+		last_use_at=%s,expires_at=%s,password=%s,max_tokens=%s,used_tokens=%s,allow_from=%s,recipients=%s,user_id=%s
+		WHERE share_id = %s`, sqlTableShares,
 		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13])
+		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14])
 }
 
 func getUpdateShareQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET name=%s,description=%s,scope=%s,paths=%s,updated_at=%s,expires_at=%s,
-		password=%s,max_tokens=%s,allow_from=%s,user_id=%s WHERE share_id = %s`, sqlTableShares,
+		password=%s,max_tokens=%s,allow_from=%s,recipients=%s,user_id=%s WHERE share_id = %s`, sqlTableShares,
 		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
 		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10])
+		sqlPlaceholders[10], sqlPlaceholders[11])
 }
 
 func getDeleteShareQuery() string {
@@ -565,6 +592,23 @@ func getUpdateLastLoginQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET last_login = %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+// getUpdatePublicKeyLastUseQuery returns a query that only sets the last use for
+// a public key inside the user filters, the other filters are not rewritten
+func getUpdatePublicKeyLastUseQuery() string {
+	switch config.Driver {
+	case PGSQLDataProviderName, CockroachDataProviderName:
+		return fmt.Sprintf(`UPDATE %s SET filters = jsonb_set(COALESCE(NULLIF(filters,''),'{}')::jsonb,'{public_keys_last_use}',
+COALESCE(COALESCE(NULLIF(filters,''),'{}')::jsonb->'public_keys_last_use','{}'::jsonb) || jsonb_build_object(%s::text,%s::bigint))::text
+WHERE username = %s AND deleted_at = 0`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+	case MySQLDataProviderName:
+		return fmt.Sprintf("UPDATE %s SET `filters` = JSON_MERGE_PATCH(COALESCE(NULLIF(`filters`,''),'{}'),JSON_OBJECT('public_keys_last_use',JSON_OBJECT(%s,%s))) WHERE `username` = %s AND `deleted_at` = 0",
+			sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+	default:
+		return fmt.Sprintf(`UPDATE %s SET filters = json_patch(COALESCE(NULLIF(filters,''),'{}'),json_object('public_keys_last_use',json_object(%s,%s)))
+WHERE username = %s AND deleted_at = 0`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+	}
+}
+
 func getUpdateAdminLastLoginQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET last_login = %s WHERE username = %s`, sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1])
 }
@@ -1092,3 +1136,34 @@ func getDatabaseVersionQuery() string {
 func getUpdateDBVersionQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET version=%s`, sqlTableSchemaVersion, sqlPlaceholders[0])
 }
+
+func getWebDAVPropsQuery() string {
+	return fmt.Sprintf(`SELECT p.data FROM %s p INNER JOIN %s u ON p.user_id = u.id WHERE u.username = %s AND
+		p.path_hash = %s`, sqlTableWebDAVProps, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getWebDAVPropsTreeQuery() string {
+	return fmt.Sprintf(`SELECT p.id,p.path FROM %s p INNER JOIN %s u ON p.user_id = u.id WHERE u.username = %s AND
+		(p.path_hash = %s OR p.path LIKE %s ESCAPE '!')`, sqlTableWebDAVProps, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getAddWebDAVPropsQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (path_hash,path,data,updated_at,user_id) SELECT %s,%s,%s,%s,id FROM %s
+		WHERE username = %s`, sqlTableWebDAVProps, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
+}
+
+func getUpdateWebDAVPropsPathQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET path_hash=%s,path=%s,updated_at=%s WHERE id = %s`, sqlTableWebDAVProps,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getDeletePathWebDAVPropsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE user_id = (SELECT id FROM %s WHERE username = %s) AND path_hash = %s`,
+		sqlTableWebDAVProps, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDeleteWebDAVPropsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE id = %s`, sqlTableWebDAVProps, sqlPlaceholders[0])
+}
@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -88,6 +89,11 @@ const (
 	LoginMethodIDP                    = "IDP"
 )
 
+// DefaultWebDAVDeadPropsMaxSize defines the maximum size for the WebDAV dead
+// properties of each file or directory if no limit is set for the user.
+// 0 means that dead properties must be explicitly enabled for each user
+const DefaultWebDAVDeadPropsMaxSize = 0
+
 var (
 	errNoMatchingVirtualFolder = errors.New("no matching virtual folder found")
 	permsRenameAny             = []string{PermRename, PermRenameDirs, PermRenameFiles}
@@ -125,6 +131,38 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// Destinations allowed for SSH port forwarding (direct-tcpip channels) as "host:port".
+	// The host can be a shell pattern, for example "*.example.com", or an IP/Mask in CIDR
+	// format, the port can be "*". Port forwarding is not allowed if no destination is set
+	PermittedOpens []string `json:"permitted_opens,omitempty"`
+	// Last use, as unix timestamp in milliseconds, for the user public keys.
+	// The map key is the SHA256 fingerprint of the public key
+	PublicKeysLastUse map[string]int64 `json:"public_keys_last_use,omitempty"`
+	// If enabled, only FIDO/U2F security keys, sk-ssh-ed25519@openssh.com and
+	// sk-ecdsa-sha2-nistp256@openssh.com, or certificates for these keys can be used
+	// for public key authentication
+	SecurityKeysOnly bool `json:"security_keys_only,omitempty"`
+	// If enabled, signatures made using security keys must assert the user presence,
+	// for example a touch of the key
+	RequireUserPresence bool `json:"require_user_presence,omitempty"`
+	// If enabled, signatures made using security keys must assert the user verification,
+	// for example a PIN or a biometric check
+	RequireUserVerification bool `json:"require_user_verification,omitempty"`
+	// FTP SITE commands allowed for this user. If not empty, any other SITE command is denied
+	FTPAllowedSiteCommands []string `json:"ftp_allowed_site_commands,omitempty"`
+	// FTP SITE commands denied for this user, for example "CHMOD" or "SYMLINK".
+	// Denied commands take precedence over the allowed ones
+	FTPDeniedSiteCommands []string `json:"ftp_denied_site_commands,omitempty"`
+	// IPv4 address to expose for FTP passive connections. If set, it overrides
+	// the passive IP configured for the FTP binding
+	FTPPassiveIP string `json:"ftp_passive_ip,omitempty"`
+	// Port range for FTP passive connections. If set, it overrides the port
+	// range configured for the FTP service
+	FTPPassivePortRange FTPPortRange `json:"ftp_passive_port_range"`
+	// Maximum size, in bytes, of the WebDAV dead properties stored for each
+	// file or directory. 0 means the default limit, so dead properties are
+	// disabled by default. -1 disables dead properties
+	WebDAVDeadPropsMaxSize int64 `json:"webdav_dead_props_max_size,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -142,6 +180,11 @@ type User struct {
 	fsCache map[string]vfs.Fs `json:"-"`
 	// true if group settings are already applied for this user
 	groupSettingsApplied bool `json:"-"`
+	// true if the internal shares are already applied for this user
+	internalSharesApplied bool `json:"-"`
+	// expiration, as unix timestamp in milliseconds, for the directories mounted
+	// for internal shares with an expiration date
+	internalSharesExpiration map[string]int64 `json:"-"`
 	// in multi node setups we mark the user as deleted to be able to update the webdav cache
 	DeletedAt int64 `json:"-"`
 }
@@ -474,6 +517,9 @@ func (u *User) SetEmptySecrets() {
 // The path must be a SFTPGo exposed path
 func (u *User) GetPermissionsForPath(p string) []string {
 	permissions := []string{}
+	if u.isInternalShareExpired(p) {
+		return permissions
+	}
 	if perms, ok := u.Permissions["/"]; ok {
 		// if only root permissions are defined returns them unconditionally
 		if len(u.Permissions) == 1 {
@@ -635,6 +681,13 @@ func (u *User) ScanQuota() (int, int64, error) {
 	if err != nil {
 		return numFiles, size, err
 	}
+	// the chunks for the WebDAV chunked uploads are not included in the quota
+	if fsPath, err := fs.ResolvePath("/" + vfs.ChunkedUploadsDirName); err == nil {
+		if num, s, err := fs.GetDirSize(fsPath); err == nil {
+			numFiles -= num
+			size -= s
+		}
+	}
 	for idx := range u.VirtualFolders {
 		v := &u.VirtualFolders[idx]
 		if !v.IsIncludedInUserQuota() {
@@ -699,6 +752,9 @@ func (u *User) hasVirtualDirs() bool {
 
 // FilterListDir adds virtual folders and remove hidden items from the given files list
 func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os.FileInfo {
+	if virtualPath == "/" {
+		dirContents = removeChunkedUploadsDir(dirContents)
+	}
 	filter := u.getPatternsFilterForPath(virtualPath)
 	if !u.hasVirtualDirs() && filter.DenyPolicy != sdk.DenyPolicyHide {
 		return dirContents
@@ -1499,6 +1555,66 @@ func (u *User) GetAllowedIPAsString() string {
 	return strings.Join(u.Filters.AllowedIP, ",")
 }
 
+// GetPermittedOpensAsString returns the destinations allowed for port forwarding as comma separated string
+func (u *User) GetPermittedOpensAsString() string {
+	return strings.Join(u.Filters.PermittedOpens, ",")
+}
+
+// GetWebDAVDeadPropsMaxSize returns the maximum size allowed for the WebDAV dead
+// properties of each file or directory. 0 means that dead properties are disabled
+func (u *User) GetWebDAVDeadPropsMaxSize() int64 {
+	switch {
+	case u.Filters.WebDAVDeadPropsMaxSize < 0:
+		return 0
+	case u.Filters.WebDAVDeadPropsMaxSize == 0:
+		return DefaultWebDAVDeadPropsMaxSize
+	default:
+		return u.Filters.WebDAVDeadPropsMaxSize
+	}
+}
+
+// IsFTPSiteCommandAllowed returns true if the specified FTP SITE command is not
+// denied and it is allowed, an empty allow list means that all commands are allowed
+func (u *User) IsFTPSiteCommandAllowed(command string) bool {
+	command = strings.ToUpper(command)
+	if util.Contains(u.Filters.FTPDeniedSiteCommands, command) {
+		return false
+	}
+	if len(u.Filters.FTPAllowedSiteCommands) > 0 {
+		return util.Contains(u.Filters.FTPAllowedSiteCommands, command)
+	}
+	return true
+}
+
+// IsForwardingAllowed returns true if the user is allowed to open a forwarded
+// connection to the specified destination
+func (u *User) IsForwardingAllowed(host string, port uint32) bool {
+	ip := net.ParseIP(host)
+	for _, dest := range u.Filters.PermittedOpens {
+		hostPattern, portPattern, err := net.SplitHostPort(dest)
+		if err != nil {
+			continue
+		}
+		if portPattern != "*" && portPattern != strconv.FormatUint(uint64(port), 10) {
+			continue
+		}
+		if strings.Contains(hostPattern, "/") {
+			if ip == nil {
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(hostPattern)
+			if err == nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(strings.ToLower(hostPattern), strings.ToLower(host)); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // GetDeniedIPAsString returns the denied IP as comma separated string
 func (u *User) GetDeniedIPAsString() string {
 	return strings.Join(u.Filters.DeniedIP, ",")
@@ -1652,6 +1768,161 @@ func (u *User) LoadAndApplyGroupSettings() error {
 	return nil
 }
 
+// LoadAndApplyInternalShares adds the directories shared with this user, using
+// internal shares, as virtual folders inside SharedWithMeDir.
+// The user must not be saved after applying the internal shares
+func (u *User) LoadAndApplyInternalShares(connectionID string) error {
+	if u.internalSharesApplied {
+		return nil
+	}
+	u.internalSharesApplied = true
+	mounts, err := u.getInternalShareMounts()
+	if err != nil {
+		return err
+	}
+	var folders []vfs.VirtualFolder
+	var filePatterns []sdk.PatternsFilter
+	permissions := make(map[string][]string)
+	expirations := make(map[string]int64)
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	for idx := range mounts {
+		mount := &mounts[idx]
+		if mount.expiresAt > 0 && mount.expiresAt < now {
+			continue
+		}
+		mountPath := path.Join(SharedWithMeDir, mount.owner, mount.name)
+		if !u.canMountSharedFolder(mountPath, folders) {
+			mountPath = fmt.Sprintf("%s_%s", mountPath, mount.shareID)
+			if !u.canMountSharedFolder(mountPath, folders) {
+				providerLog(logger.LevelWarn, "unable to apply share %q from user %q to user %q: path %q already in use",
+					mount.shareID, mount.owner, u.Username, mountPath)
+				continue
+			}
+		}
+		folder, perms, patterns := mount.apply(mountPath)
+		folders = append(folders, folder)
+		for k, v := range perms {
+			permissions[k] = v
+		}
+		filePatterns = append(filePatterns, patterns...)
+		if mount.expiresAt > 0 {
+			expirations[mountPath] = mount.expiresAt
+		}
+	}
+	if len(folders) == 0 {
+		return nil
+	}
+	// the user is passed by value to the connections, we must not modify
+	// the slices and maps shared with the original user
+	virtualFolders := make([]vfs.VirtualFolder, 0, len(u.VirtualFolders)+len(folders))
+	virtualFolders = append(virtualFolders, u.VirtualFolders...)
+	u.VirtualFolders = append(virtualFolders, folders...)
+	userPermissions := make(map[string][]string)
+	for k, v := range u.Permissions {
+		userPermissions[k] = v
+	}
+	for k, v := range permissions {
+		userPermissions[k] = v
+	}
+	for idx := range folders {
+		for _, dir := range []string{path.Dir(folders[idx].VirtualPath), SharedWithMeDir} {
+			if _, ok := userPermissions[dir]; !ok {
+				userPermissions[dir] = []string{PermListItems}
+			}
+		}
+	}
+	u.Permissions = userPermissions
+	if len(filePatterns) > 0 {
+		userFilePatterns := make([]sdk.PatternsFilter, 0, len(u.Filters.FilePatterns)+len(filePatterns))
+		userFilePatterns = append(userFilePatterns, u.Filters.FilePatterns...)
+		u.Filters.FilePatterns = append(userFilePatterns, filePatterns...)
+	}
+	if len(expirations) > 0 {
+		u.internalSharesExpiration = expirations
+	}
+	for idx := range folders {
+		if err := u.checkDirWithParents(path.Dir(folders[idx].VirtualPath), connectionID); err != nil {
+			logger.Warn(logSender, connectionID, "could not create intermediary dir to %q, err: %v",
+				folders[idx].VirtualPath, err)
+		}
+	}
+	return nil
+}
+
+// getInternalShareMounts returns the internal shares targeting this user, the
+// results are cached for the user and its groups
+func (u *User) getInternalShareMounts() ([]internalShareMount, error) {
+	groups := make([]string, 0, len(u.Groups))
+	for _, g := range u.Groups {
+		groups = append(groups, g.Name)
+	}
+	sort.Strings(groups)
+	cacheKey := strings.Join(groups, "/")
+	if mounts, ok := internalSharesCache.get(u.Username, cacheKey); ok {
+		return mounts, nil
+	}
+	shares, err := provider.getInternalSharesForUser(u.Username, groups)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get internal shares: %w", err)
+	}
+	owners := make(map[string]*User)
+	var mounts []internalShareMount
+
+	for idx := range shares {
+		share := &shares[idx]
+		if share.IsExpired() {
+			continue
+		}
+		owner, ok := owners[share.Username]
+		if !ok {
+			owner = share.getOwner()
+			owners[share.Username] = owner
+		}
+		if owner == nil {
+			continue
+		}
+		mount, err := share.getMount(owner)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to apply share %q from user %q to user %q: %v",
+				share.ShareID, share.Username, u.Username, err)
+			continue
+		}
+		mounts = append(mounts, mount)
+	}
+	internalSharesCache.add(u.Username, cacheKey, mounts)
+	return mounts, nil
+}
+
+// isInternalShareExpired returns true if the specified path is inside a
+// directory shared using an expired internal share
+func (u *User) isInternalShareExpired(virtualPath string) bool {
+	if len(u.internalSharesExpiration) == 0 {
+		return false
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for mountPath, expiresAt := range u.internalSharesExpiration {
+		if expiresAt < now && (virtualPath == mountPath || strings.HasPrefix(virtualPath, mountPath+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) canMountSharedFolder(virtualPath string, sharedFolders []vfs.VirtualFolder) bool {
+	for idx := range u.VirtualFolders {
+		if isVirtualDirOverlapped(u.VirtualFolders[idx].VirtualPath, virtualPath, true) {
+			return false
+		}
+	}
+	for idx := range sharedFolders {
+		if isVirtualDirOverlapped(sharedFolders[idx].VirtualPath, virtualPath, true) {
+			return false
+		}
+	}
+	return true
+}
+
 func (u *User) getGroupPlacehodersReplacer() *strings.Replacer {
 	return strings.NewReplacer("%username%", u.Username)
 }
@@ -1707,6 +1978,12 @@ func (u *User) mergeWithPrimaryGroup(group Group, replacer *strings.Replacer) {
 		u.DownloadDataTransfer = group.UserSettings.DownloadDataTransfer
 		u.TotalDataTransfer = group.UserSettings.TotalDataTransfer
 	}
+	if u.Filters.FTPPassiveIP == "" {
+		u.Filters.FTPPassiveIP = group.UserSettings.FTPPassiveIP
+	}
+	if !u.Filters.FTPPassivePortRange.IsSet() {
+		u.Filters.FTPPassivePortRange = group.UserSettings.FTPPassivePortRange
+	}
 	u.mergePrimaryGroupFilters(group.UserSettings.Filters, replacer)
 	u.mergeAdditiveProperties(group, sdk.GroupTypePrimary, replacer)
 }
@@ -1762,6 +2039,17 @@ func (u *User) mergeAdditiveProperties(group Group, groupType int, replacer *str
 	u.Filters.DeniedProtocols = append(u.Filters.DeniedProtocols, group.UserSettings.Filters.DeniedProtocols...)
 	u.Filters.WebClient = append(u.Filters.WebClient, group.UserSettings.Filters.WebClient...)
 	u.Filters.TwoFactorAuthProtocols = append(u.Filters.TwoFactorAuthProtocols, group.UserSettings.Filters.TwoFactorAuthProtocols...)
+	u.Filters.FTPAllowedSiteCommands = append(u.Filters.FTPAllowedSiteCommands, group.UserSettings.FTPAllowedSiteCommands...)
+	u.Filters.FTPDeniedSiteCommands = append(u.Filters.FTPDeniedSiteCommands, group.UserSettings.FTPDeniedSiteCommands...)
+	if !u.Filters.SecurityKeysOnly {
+		u.Filters.SecurityKeysOnly = group.UserSettings.SecurityKeysOnly
+	}
+	if !u.Filters.RequireUserPresence {
+		u.Filters.RequireUserPresence = group.UserSettings.RequireUserPresence
+	}
+	if !u.Filters.RequireUserVerification {
+		u.Filters.RequireUserVerification = group.UserSettings.RequireUserVerification
+	}
 }
 
 func (u *User) mergeVirtualFolders(group Group, groupType int, replacer *strings.Replacer) {
@@ -1876,6 +2164,24 @@ func (u *User) getACopy() User {
 			Used:   code.Used,
 		})
 	}
+	filters.PermittedOpens = make([]string, len(u.Filters.PermittedOpens))
+	copy(filters.PermittedOpens, u.Filters.PermittedOpens)
+	filters.SecurityKeysOnly = u.Filters.SecurityKeysOnly
+	filters.RequireUserPresence = u.Filters.RequireUserPresence
+	filters.RequireUserVerification = u.Filters.RequireUserVerification
+	filters.FTPAllowedSiteCommands = make([]string, len(u.Filters.FTPAllowedSiteCommands))
+	copy(filters.FTPAllowedSiteCommands, u.Filters.FTPAllowedSiteCommands)
+	filters.FTPDeniedSiteCommands = make([]string, len(u.Filters.FTPDeniedSiteCommands))
+	copy(filters.FTPDeniedSiteCommands, u.Filters.FTPDeniedSiteCommands)
+	filters.FTPPassiveIP = u.Filters.FTPPassiveIP
+	filters.FTPPassivePortRange = u.Filters.FTPPassivePortRange
+	filters.WebDAVDeadPropsMaxSize = u.Filters.WebDAVDeadPropsMaxSize
+	if u.Filters.PublicKeysLastUse != nil {
+		filters.PublicKeysLastUse = make(map[string]int64)
+		for k, v := range u.Filters.PublicKeysLastUse {
+			filters.PublicKeysLastUse[k] = v
+		}
+	}
 
 	return User{
 		BaseUser: sdk.BaseUser{
@@ -1912,11 +2218,13 @@ func (u *User) getACopy() User {
 			UpdatedAt:                u.UpdatedAt,
 			Role:                     u.Role,
 		},
-		Filters:              filters,
-		VirtualFolders:       virtualFolders,
-		Groups:               groups,
-		FsConfig:             u.FsConfig.GetACopy(),
-		groupSettingsApplied: u.groupSettingsApplied,
+		Filters:                  filters,
+		VirtualFolders:           virtualFolders,
+		Groups:                   groups,
+		FsConfig:                 u.FsConfig.GetACopy(),
+		groupSettingsApplied:     u.groupSettingsApplied,
+		internalSharesApplied:    u.internalSharesApplied,
+		internalSharesExpiration: u.internalSharesExpiration,
 	}
 }
 
@@ -1924,3 +2232,13 @@ func (u *User) getACopy() User {
 func (u *User) GetEncryptionAdditionalData() string {
 	return u.Username
 }
+
+// removeChunkedUploadsDir hides the WebDAV chunked uploads directory
+func removeChunkedUploadsDir(dirContents []os.FileInfo) []os.FileInfo {
+	for idx, fi := range dirContents {
+		if fi.Name() == vfs.ChunkedUploadsDirName {
+			return append(dirContents[:idx], dirContents[idx+1:]...)
+		}
+	}
+	return dirContents
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// The WebDAV dead properties are stored within the data provider for the
+// storage backends unable to store them within their metadata. They are
+// keyed by user and virtual path and removed with the user
+
+// getWebDAVPropsPathHash returns the hash used to index the WebDAV dead
+// properties for the specified virtual path. Paths can be longer than the
+// maximum indexable size for some databases
+func getWebDAVPropsPathHash(virtualPath string) string {
+	h := sha256.Sum256([]byte(virtualPath))
+	return hex.EncodeToString(h[:])
+}
+
+// getWebDAVPropsChildrenPrefix returns the prefix shared by the children of the
+// specified virtual path
+func getWebDAVPropsChildrenPrefix(virtualPath string) string {
+	if strings.HasSuffix(virtualPath, "/") {
+		return virtualPath
+	}
+	return virtualPath + "/"
+}
+
+// getWebDAVPropsChildrenPattern returns a LIKE pattern, with "!" as escape
+// character, matching the children of the specified virtual path
+func getWebDAVPropsChildrenPattern(virtualPath string) string {
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return replacer.Replace(getWebDAVPropsChildrenPrefix(virtualPath)) + "%"
+}
+
+// isWebDAVPropsPathInTree returns true if the specified path is the specified
+// virtual path or one of its children
+func isWebDAVPropsPathInTree(p, virtualPath string) bool {
+	return p == virtualPath || strings.HasPrefix(p, getWebDAVPropsChildrenPrefix(virtualPath))
+}
+
+// getWebDAVPropsRenamedPath returns the path for p, inside the source tree,
+// after renaming source to target
+func getWebDAVPropsRenamedPath(p, source, target string) string {
+	if p == source {
+		return target
+	}
+	return getWebDAVPropsChildrenPrefix(target) + strings.TrimPrefix(p, getWebDAVPropsChildrenPrefix(source))
+}
@@ -16,6 +16,7 @@
 package ftpd
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -25,6 +26,7 @@ import (
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -99,6 +101,24 @@ type Binding struct {
 	// Please note that disabling the security checks you will make the FTP service vulnerable to bounce attacks
 	// on active data connections, so change the default value only if you are on a trusted/internal network
 	ActiveConnectionsSecurity int `json:"active_connections_security" mapstructure:"active_connections_security"`
+	// DisableASCIIMode disables the ASCII transfer mode, "TYPE A" commands are refused
+	// and the files are always transferred in binary mode
+	DisableASCIIMode bool `json:"disable_ascii_mode" mapstructure:"disable_ascii_mode"`
+	// TLSSessionReuse defines the TLS session reuse requirements for data connections.
+	// Supported values:
+	// - 0 TLS session reuse is not required. This is the default
+	// - 1 TLS data connections must resume the TLS session of their control connection,
+	//     otherwise they are rejected
+	TLSSessionReuse int `json:"tls_session_reuse" mapstructure:"tls_session_reuse"`
+	// TLSSessionCacheSize defines the maximum number of control connection TLS sessions
+	// kept to be resumed by data connections if TLS session reuse is required. If the
+	// limit is reached, the oldest session is evicted and its data connections are rejected.
+	// 0 means no limit, a session is kept for each connected client
+	TLSSessionCacheSize int `json:"tls_session_cache_size" mapstructure:"tls_session_cache_size"`
+	// TLSSessionLifetime defines the time, in seconds, after which the TLS session of a
+	// control connection can no longer be resumed by data connections if TLS session
+	// reuse is required. 0 means the session can be resumed while the client is connected
+	TLSSessionLifetime int `json:"tls_session_lifetime" mapstructure:"tls_session_lifetime"`
 	// Debug enables the FTP debug mode. In debug mode, every FTP command will be logged
 	Debug   bool `json:"debug" mapstructure:"debug"`
 	ciphers []uint16
@@ -115,6 +135,10 @@ func (b *Binding) isMutualTLSEnabled() bool {
 	return b.ClientAuthType == 1 || b.ClientAuthType == 2
 }
 
+func (b *Binding) isTLSSessionReuseRequired() bool {
+	return b.TLSSessionReuse == 1
+}
+
 // GetAddress returns the binding address
 func (b *Binding) GetAddress() string {
 	return fmt.Sprintf("%s:%d", b.Address, b.Port)
@@ -132,6 +156,15 @@ func (b *Binding) checkSecuritySettings() error {
 	if b.ActiveConnectionsSecurity < 0 || b.ActiveConnectionsSecurity > 1 {
 		return fmt.Errorf("invalid active_connections_security: %v", b.ActiveConnectionsSecurity)
 	}
+	if b.TLSSessionReuse < 0 || b.TLSSessionReuse > 1 {
+		return fmt.Errorf("invalid tls_session_reuse: %v", b.TLSSessionReuse)
+	}
+	if b.TLSSessionCacheSize < 0 {
+		return fmt.Errorf("invalid tls_session_cache_size: %v", b.TLSSessionCacheSize)
+	}
+	if b.TLSSessionLifetime < 0 {
+		return fmt.Errorf("invalid tls_session_lifetime: %v", b.TLSSessionLifetime)
+	}
 	return nil
 }
 
@@ -252,7 +285,9 @@ type Configuration struct {
 	// Set to true to disable active FTP
 	DisableActiveMode bool `json:"disable_active_mode" mapstructure:"disable_active_mode"`
 	// Set to true to enable the FTP SITE command.
-	// We support chmod and symlink if SITE support is enabled
+	// We support chmod, symlink, mkdir, utime, quota, cpfr/cpto, md5 and help
+	// if SITE support is enabled.
+	// Each SITE command can be allowed or denied per user and group
 	EnableSite bool `json:"enable_site" mapstructure:"enable_site"`
 	// Set to 1 to enable FTP commands that allow to calculate the hash value of files.
 	// These FTP commands will be enabled: HASH, XCRC, MD5/XMD5, XSHA/XSHA1, XSHA256, XSHA512.
@@ -265,8 +300,36 @@ type Configuration struct {
 	// no advantage as it will download the partial files and will upload the
 	// combined one. Cloud backends natively support multipart uploads.
 	CombineSupport int `json:"combine_support" mapstructure:"combine_support"`
+	// Set to 1 to store the TLS session ticket keys within the data provider, this way
+	// a TLS session established with an SFTPGo instance can be resumed using another one.
+	// The keys are periodically rotated. This setting requires a shared data provider,
+	// it is ignored otherwise and each instance uses its own, automatically rotated, keys
+	SharedTLSSessionTickets int `json:"shared_tls_session_tickets" mapstructure:"shared_tls_session_tickets"`
 	// Port Range for data connections. Random if not specified
 	PassivePortRange PortRange `json:"passive_port_range" mapstructure:"passive_port_range"`
+	// Absolute path to an external program or an HTTP URL to invoke after a user login
+	// to get the IPv4 address to expose for passive connections. The passive IP
+	// configured for the user or its primary group, if any, takes precedence.
+	// If the hook fails, the binding configuration is used
+	PassiveIPHook string `json:"passive_ip_hook" mapstructure:"passive_ip_hook"`
+	// Time, in seconds, to cache the passive IPs returned by the hook for a given
+	// username, client IP and local IP. 0 means no cache
+	PassiveIPHookCacheTime int `json:"passive_ip_hook_cache_time" mapstructure:"passive_ip_hook_cache_time"`
+}
+
+func (c *Configuration) startTLSTicketKeysManager(tlsConfigs []*tls.Config) {
+	if c.SharedTLSSessionTickets != 1 || len(tlsConfigs) == 0 {
+		return
+	}
+	providerConf := dataprovider.GetProviderConfig()
+	if providerConf.GetShared() != 1 {
+		logger.Warn(logSender, "", "shared TLS session tickets require a shared data provider, setting ignored")
+		return
+	}
+	logger.Info(logSender, "", "using TLS session ticket keys shared via the data provider")
+	mgr := newTLSTicketKeysManager(tlsConfigs)
+	mgr.start()
+	sharedTLSTicketKeys.Store(mgr)
 }
 
 // ShouldBind returns true if there is at least a valid binding
@@ -333,8 +396,10 @@ func (c *Configuration) Initialize(configDir string) error {
 		Bindings:         nil,
 		PassivePortRange: c.PassivePortRange,
 	}
+	passiveIPs.clear()
 
 	exitChannel := make(chan error, 1)
+	var tlsConfigs []*tls.Config
 
 	for idx, binding := range c.Bindings {
 		if !binding.IsValid() {
@@ -342,6 +407,9 @@ func (c *Configuration) Initialize(configDir string) error {
 		}
 
 		server := NewServer(c, configDir, binding, idx)
+		if server.tlsConfig != nil {
+			tlsConfigs = append(tlsConfigs, server.tlsConfig)
+		}
 
 		go func(s *Server) {
 			ftpLogger := logger.LeveledLogger{Sender: "ftpserverlib"}
@@ -355,6 +423,8 @@ func (c *Configuration) Initialize(configDir string) error {
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
 	}
 
+	c.startTLSTicketKeysManager(tlsConfigs)
+
 	serviceStatus.IsActive = true
 
 	return <-exitChannel
@@ -15,6 +15,7 @@
 package ftpd_test
 
 import (
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
@@ -32,6 +33,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -409,8 +411,9 @@ func TestMain(m *testing.M) {
 	ftpdConf = config.GetFTPDConfig()
 	ftpdConf.Bindings = []ftpd.Binding{
 		{
-			Port:    2124,
-			TLSMode: 2,
+			Port:             2124,
+			TLSMode:          2,
+			DisableASCIIMode: true,
 		},
 	}
 	ftpdConf.CertificateFile = certPath
@@ -3146,6 +3149,287 @@ func TestSITEDisabled(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMLSxFacts(t *testing.T) {
+	u := getTestUser()
+	u.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "sub", "file.txt"), []byte("data"), 0644)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		code, msg, err := client.SendCustomCommand("FEAT")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusSystem, code)
+		assert.Contains(t, msg, "MLST Type*;Size*;Modify*;Perm*;Unique*;Media-Type*;UNIX.mode*;UNIX.owner*;UNIX.group*;")
+
+		code, msg, err = client.SendCustomCommand("MLST /sub")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+		assert.Contains(t, msg, "Type=dir;")
+		assert.Contains(t, msg, "Perm=defl;")
+		assert.Contains(t, msg, "Unique=")
+		assert.Contains(t, msg, "UNIX.mode=")
+		assert.NotContains(t, msg, "Media-Type=")
+		if runtime.GOOS != osWindows {
+			assert.Contains(t, msg, fmt.Sprintf("UNIX.owner=%d;", os.Getuid()))
+			assert.Contains(t, msg, fmt.Sprintf("UNIX.group=%d;", os.Getgid()))
+		}
+		code, msg, err = client.SendCustomCommand("MLST /sub/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+		assert.Contains(t, msg, "Type=file;Size=4;")
+		assert.Contains(t, msg, "Perm=r;")
+		assert.Contains(t, msg, "Media-Type=text/plain;")
+		assert.Contains(t, msg, "UNIX.mode=0644;")
+
+		entries, err := client.List("/sub")
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "file.txt", entries[0].Name)
+			assert.Equal(t, uint64(4), entries[0].Size)
+		}
+		// only the requested facts must be returned
+		code, msg, err = client.SendCustomCommand("OPTS MLST type;perm;invalid;")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Equal(t, "MLST OPTS Type;Perm;", msg)
+		code, msg, err = client.SendCustomCommand("MLST /sub/file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestedFileActionOK, code)
+		assert.Contains(t, msg, "Type=file;Perm=r; file.txt")
+		code, msg, err = client.SendCustomCommand("FEAT")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusSystem, code)
+		assert.Contains(t, msg, "MLST Type*;Size;Modify;Perm*;Unique;")
+		code, msg, err = client.SendCustomCommand("OPTS MLST")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Equal(t, "MLST OPTS", msg)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSITEDeniedCommands(t *testing.T) {
+	u := getTestUser()
+	u.Filters.FTPDeniedSiteCommands = []string{"chmod", "MKDIR"}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CHMOD", "MKDIR"}, user.Filters.FTPDeniedSiteCommands)
+	dirName := "adir"
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+
+		code, _, err := client.SendCustomCommand("SITE MKDIR " + dirName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		assert.NoDirExists(t, filepath.Join(user.GetHomeDir(), dirName))
+		// MKD is not a SITE command, it is allowed
+		err = client.MakeDir(dirName)
+		assert.NoError(t, err)
+		code, _, err = client.SendCustomCommand("SITE CHMOD 700 " + dirName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand(fmt.Sprintf("SITE SYMLINK %s %s", dirName, dirName+".link"))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		// denied commands are not listed
+		code, msg, err := client.SendCustomCommand("SITE HELP")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusHelp, code)
+		assert.Contains(t, msg, "SYMLINK")
+		assert.NotContains(t, msg, "CHMOD")
+		assert.NotContains(t, msg, "MKDIR")
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSITEAllowedCommands(t *testing.T) {
+	g := getTestGroup()
+	g.UserSettings.FTPAllowedSiteCommands = []string{"symlink", "MKDIR", "HELP"}
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SYMLINK", "MKDIR", "HELP"}, group.UserSettings.FTPAllowedSiteCommands)
+	u := getTestUser()
+	u.Filters.FTPDeniedSiteCommands = []string{"MKDIR"}
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypePrimary,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	dirName := "adir"
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		// allowed for the group but denied for the user
+		code, _, err := client.SendCustomCommand("SITE MKDIR " + dirName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		assert.NoDirExists(t, filepath.Join(user.GetHomeDir(), dirName))
+		err = client.MakeDir(dirName)
+		assert.NoError(t, err)
+		// not included in the allow list
+		code, _, err = client.SendCustomCommand("SITE CHMOD 700 " + dirName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand("SITE QUOTA")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand(fmt.Sprintf("SITE SYMLINK %s %s", dirName, dirName+".link"))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		code, msg, err := client.SendCustomCommand("SITE HELP")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusHelp, code)
+		assert.Contains(t, msg, "SYMLINK")
+		assert.Contains(t, msg, "HELP")
+		assert.NotContains(t, msg, "CHMOD")
+		assert.NotContains(t, msg, "MKDIR")
+		assert.NotContains(t, msg, "QUOTA")
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestSITECommands(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(testFilePath)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.NoError(t, err)
+		// UTIME
+		code, _, err := client.SendCustomCommand("SITE UTIME 20201224153010 " + testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		info, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Date(2020, 12, 24, 15, 30, 10, 0, time.UTC), info.ModTime().UTC())
+		}
+		code, _, err = client.SendCustomCommand(fmt.Sprintf("SITE UTIME /%s 20210101000000 20211224153000 20210101000000 UTC",
+			testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		info, err = os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Date(2021, 12, 24, 15, 30, 0, 0, time.UTC), info.ModTime().UTC())
+		}
+		code, _, err = client.SendCustomCommand("SITE UTIME 2020 " + testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadArguments, code)
+		code, _, err = client.SendCustomCommand("SITE UTIME 20201224153010 missing")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		// QUOTA
+		code, msg, err := client.SendCustomCommand("SITE QUOTA")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Contains(t, msg, "Files: 1/100")
+		assert.Contains(t, msg, "Size: 64.0 KiB/unlimited")
+		// CPFR/CPTO
+		code, _, err = client.SendCustomCommand("SITE CPTO copy")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadSequence, code)
+		code, _, err = client.SendCustomCommand("SITE CPFR missing")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand("SITE CPFR " + testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusRequestFilePending, code)
+		code, _, err = client.SendCustomCommand("SITE CPTO /copy")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		copied, err := os.ReadFile(filepath.Join(user.GetHomeDir(), "copy"))
+		assert.NoError(t, err)
+		assert.Equal(t, content, copied)
+		// the copy source is reset after each copy
+		code, _, err = client.SendCustomCommand("SITE CPTO copy1")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadSequence, code)
+		// MD5
+		code, msg, err = client.SendCustomCommand("SITE MD5 " + testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Equal(t, fmt.Sprintf("%x /%s", md5.Sum(content), testFileName), msg)
+		err = client.MakeDir("sub")
+		assert.NoError(t, err)
+		code, _, err = client.SendCustomCommand("SITE MD5 sub")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, _, err = client.SendCustomCommand("SITE MD5")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadArguments, code)
+		// HELP
+		code, msg, err = client.SendCustomCommand("SITE HELP")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusHelp, code)
+		for _, cmd := range []string{"CHMOD", "SYMLINK", "MKDIR", "UTIME", "QUOTA", "CPFR", "CPTO", "MD5", "HELP"} {
+			assert.Contains(t, msg, cmd)
+		}
+		// the commands not registered are handled by the FTP library
+		code, _, err = client.SendCustomCommand("SITE UNKNOWN")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadCommand, code)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// MD5 requires the download permission
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getFTPClient(user, true, nil)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCustomCommand("SITE MD5 " + testFileName)
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestHASH(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -3218,6 +3502,99 @@ func TestHASH(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestASCIITransferMode(t *testing.T) {
+	u := getTestUser()
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	sftpUser, _, err := httpdtest.AddUser(getTestSFTPUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUserWithCryptFs()
+	u.Username += "_crypt"
+	cryptUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	content := "line1\r\nline2\r\n\r\nlast line without line ending"
+	storedContent := strings.ReplaceAll(content, "\r\n", "\n")
+	if runtime.GOOS == osWindows {
+		storedContent = content
+	}
+	for _, user := range []dataprovider.User{localUser, sftpUser, cryptUser} {
+		client, err := getFTPClient(user, true, nil)
+		if assert.NoError(t, err) {
+			code, _, err := client.SendCustomCommand("TYPE A")
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusCommandOK, code)
+			err = client.Stor(testFileName, strings.NewReader(content))
+			assert.NoError(t, err)
+			if user.Username == localUser.Username {
+				data, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+				assert.NoError(t, err)
+				assert.Equal(t, storedContent, string(data))
+			}
+			r, err := client.Retr(testFileName)
+			if assert.NoError(t, err) {
+				data, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Equal(t, content, string(data))
+				assert.NoError(t, r.Close())
+			}
+			// SIZE and REST are not supported in ASCII mode
+			_, err = client.FileSize(testFileName)
+			assert.Error(t, err)
+			_, err = client.RetrFrom(testFileName, 2)
+			assert.Error(t, err)
+			// in binary mode the file is transferred as stored
+			code, _, err = client.SendCustomCommand("TYPE I")
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusCommandOK, code)
+			size, err := client.FileSize(testFileName)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(storedContent)), size)
+			r, err = client.Retr(testFileName)
+			if assert.NoError(t, err) {
+				data, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Equal(t, storedContent, string(data))
+				assert.NoError(t, r.Close())
+			}
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(cryptUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(cryptUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestASCIITransferModeDisabled(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getFTPClientImplicitTLS(user)
+	if assert.NoError(t, err) {
+		code, _, err := client.SendCustomCommand("TYPE A")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusNotImplementedParameter, code)
+		code, _, err = client.SendCustomCommand("TYPE I")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestCombine(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
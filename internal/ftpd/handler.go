@@ -43,6 +43,8 @@ type Connection struct {
 	*common.BaseConnection
 	clientContext     ftpserver.ClientContext
 	doWildcardListDir bool
+	// source path set using SITE CPFR, it is used by the next SITE CPTO
+	copySource string
 }
 
 func (c *Connection) getFTPMode() string {
@@ -404,6 +406,9 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, flags int, resolvedPath
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, ftpserver.ErrStorageExceeded
 	}
+	if err := c.CheckWebDAVLocks(requestPath, false); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, fmt.Errorf("%w, denied by pre-upload action", ftpserver.ErrFileNameNotAllowed)
@@ -449,6 +454,9 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
 	}
+	if err := c.CheckWebDAVLocks(requestPath, false); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, flags); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, fmt.Errorf("%w, denied by pre-upload action", ftpserver.ErrFileNameNotAllowed)
@@ -17,10 +17,14 @@ package ftpd
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -31,11 +35,14 @@ import (
 	ftpserver "github.com/fclairamb/ftpserverlib"
 	"github.com/pires/go-proxyproto"
 	"github.com/sftpgo/sdk"
+	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
@@ -275,6 +282,7 @@ type mockFTPClientContext struct {
 	lastDataChannel ftpserver.DataChannel
 	remoteIP        string
 	localIP         string
+	id              uint32
 }
 
 func (cc mockFTPClientContext) Path() string {
@@ -292,6 +300,9 @@ func (cc mockFTPClientContext) Debug() bool {
 }
 
 func (cc mockFTPClientContext) ID() uint32 {
+	if cc.id > 0 {
+		return cc.id
+	}
 	return 1
 }
 
@@ -1032,6 +1043,401 @@ func TestPassiveIPResolver(t *testing.T) {
 	passiveIP, err = b.passiveIPResolver(mockCC)
 	assert.NoError(t, err)
 	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+	// the passive IP configured for the user overrides the binding ones
+	s := NewServer(&Configuration{}, configDir, b, 0)
+	passiveIP, err = s.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, b.ForcePassiveIP, passiveIP)
+	s.setUserPassiveIP(mockCC.ID(), "10.8.0.1")
+	passiveIP, err = s.passiveIPResolver(mockCC)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.8.0.1", passiveIP)
+	s.setUserPassiveIP(mockCC.ID(), "")
+	assert.Empty(t, s.getUserPassiveIP(mockCC.ID()))
+}
+
+func TestPassivePortRangeResolver(t *testing.T) {
+	mockCC := mockFTPClientContext{}
+	s := NewServer(&Configuration{
+		PassivePortRange: PortRange{
+			Start: 50000,
+			End:   50100,
+		},
+	}, configDir, Binding{}, 0)
+	settings, err := s.GetSettings()
+	require.NoError(t, err)
+	require.NotNil(t, settings.PassivePortRangeResolver)
+	// the service port range is used if the user does not have a specific one
+	assert.Nil(t, settings.PassivePortRangeResolver(mockCC))
+	user := dataprovider.User{}
+	s.setUserPassivePortRange(mockCC.ID(), getPassivePortRangeForUser(&user))
+	assert.Nil(t, settings.PassivePortRangeResolver(mockCC))
+	user.Filters.FTPPassivePortRange = dataprovider.FTPPortRange{
+		Start: 40000,
+		End:   40010,
+	}
+	s.setUserPassivePortRange(mockCC.ID(), getPassivePortRangeForUser(&user))
+	portRange := settings.PassivePortRangeResolver(mockCC)
+	if assert.NotNil(t, portRange) {
+		assert.Equal(t, 40000, portRange.Start)
+		assert.Equal(t, 40010, portRange.End)
+	}
+	s.onPassiveListenError(mockCC, ftpserver.ErrNoAvailableListeningPort)
+	s.ClientDisconnected(mockCC)
+	assert.Nil(t, settings.PassivePortRangeResolver(mockCC))
+}
+
+func TestPassiveIPHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	defer passiveIPs.clear()
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "passive_ip_user",
+		},
+	}
+	mockCC := mockFTPClientContext{
+		remoteIP: "172.16.1.2",
+		localIP:  "172.16.1.1",
+	}
+	hookPath := filepath.Join(os.TempDir(), "passive_ip_hook.sh")
+	err := os.WriteFile(hookPath, []byte("#!/bin/sh\n\necho \"$SFTPGO_CONNECTION_LOCAL_IP\"\n"), os.ModePerm)
+	require.NoError(t, err)
+	defer os.Remove(hookPath)
+
+	c := &Configuration{
+		PassiveIPHook:          hookPath,
+		PassiveIPHookCacheTime: 60,
+	}
+	s := NewServer(c, configDir, Binding{}, 0)
+	assert.Equal(t, "172.16.1.1", s.getPassiveIPForUser(&user, mockCC, ""))
+	// the cached value must be returned
+	err = os.WriteFile(hookPath, []byte("#!/bin/sh\n\necho \"10.1.1.1\"\n"), os.ModePerm)
+	require.NoError(t, err)
+	assert.Equal(t, "172.16.1.1", s.getPassiveIPForUser(&user, mockCC, ""))
+	mockCC.remoteIP = "172.16.1.3"
+	assert.Equal(t, "10.1.1.1", s.getPassiveIPForUser(&user, mockCC, ""))
+	// the passive IP configured for the user takes precedence
+	user.Filters.FTPPassiveIP = "10.2.2.2"
+	assert.Equal(t, "10.2.2.2", s.getPassiveIPForUser(&user, mockCC, ""))
+	user.Filters.FTPPassiveIP = ""
+	// invalid IP, the binding configuration must be used
+	c.PassiveIPHookCacheTime = 0
+	err = os.WriteFile(hookPath, []byte("#!/bin/sh\n\necho \"::1\"\n"), os.ModePerm)
+	require.NoError(t, err)
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+	err = os.WriteFile(hookPath, []byte("#!/bin/sh\n\nexit 1\n"), os.ModePerm)
+	require.NoError(t, err)
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+	c.PassiveIPHook = "relative_path"
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("username") != "passive_ip_user" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(r.URL.Query().Get("ip"))) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	c.PassiveIPHook = ts.URL
+	assert.Equal(t, "172.16.1.3", s.getPassiveIPForUser(&user, mockCC, ""))
+	user.Username = "unknown"
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+	c.PassiveIPHook = "http://foo\x7f.com/"
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+	c.PassiveIPHook = ""
+	assert.Empty(t, s.getPassiveIPForUser(&user, mockCC, ""))
+	// the cache is pruned when full
+	passiveIPs.clear()
+	for i := 0; i < maxCachedPassiveIPs; i++ {
+		passiveIPs.add(fmt.Sprintf("key%d", i), "10.1.1.1", -1*time.Second)
+	}
+	passiveIPs.add("key", "10.1.1.2", time.Minute)
+	ip, ok := passiveIPs.get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.1.2", ip)
+	assert.Len(t, passiveIPs.entries, 1)
+	_, ok = passiveIPs.get("key1")
+	assert.False(t, ok)
+}
+
+func TestPassiveListenError(t *testing.T) {
+	s := NewServer(&Configuration{}, configDir, Binding{}, 0)
+	settings, err := s.GetSettings()
+	require.NoError(t, err)
+	require.NotNil(t, settings.PassiveListenErrorHandler)
+	settings.PassiveListenErrorHandler(mockFTPClientContext{}, ftpserver.ErrNoAvailableListeningPort)
+	settings.PassiveListenErrorHandler(mockFTPClientContext{}, errors.New("listen error"))
+}
+
+func TestTLSSessionReuse(t *testing.T) {
+	for _, binding := range []Binding{
+		{TLSSessionReuse: 2},
+		{TLSSessionCacheSize: -1},
+		{TLSSessionLifetime: -1},
+	} {
+		assert.Error(t, binding.checkSecuritySettings())
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	binding := Binding{
+		Port:            2121,
+		TLSSessionReuse: 1,
+	}
+	err = binding.checkSecuritySettings()
+	assert.NoError(t, err)
+	c := &Configuration{
+		Bindings: []Binding{binding},
+	}
+	server := NewServer(c, configDir, binding, 0)
+	require.NotNil(t, server.tlsSessions)
+	client1 := mockFTPClientContext{id: 1}
+	client2 := mockFTPClientContext{id: 2}
+
+	handshake := func(serverTLSConfig, clientConfig *tls.Config) (tls.ConnectionState, error) {
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			tlsConn := tls.Server(conn, serverTLSConfig)
+			if tlsConn.Handshake() == nil {
+				// let the client read the session ticket, sent after the handshake using TLS 1.3
+				tlsConn.Write([]byte("1")) //nolint:errcheck
+			}
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		// the server rejects the connection after receiving the client's Finished
+		// message, read to get the alert if any
+		conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+		_, err = conn.Read(make([]byte, 1))
+		return conn.ConnectionState(), err
+	}
+	getConfig := func(cc ftpserver.ClientContext, forTransfer bool) *tls.Config {
+		cfg, err := server.GetClientTLSConfig(cc, forTransfer)
+		require.NoError(t, err)
+		return cfg
+	}
+
+	for _, tlsVersion := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		clientConfig1 := &tls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true, // use this for tests only
+			MinVersion:         tlsVersion,
+			MaxVersion:         tlsVersion,
+			ClientSessionCache: tls.NewLRUClientSessionCache(10),
+		}
+		clientConfig2 := clientConfig1.Clone()
+		clientConfig2.ClientSessionCache = tls.NewLRUClientSessionCache(10)
+		// data connection without a control connection
+		_, err = server.GetClientTLSConfig(client1, true)
+		assert.Error(t, err)
+		// control connections, no resumption required
+		state, err := handshake(getConfig(client1, false), clientConfig1)
+		assert.NoError(t, err)
+		assert.False(t, state.DidResume)
+		state, err = handshake(getConfig(client2, false), clientConfig2)
+		assert.NoError(t, err)
+		assert.False(t, state.DidResume)
+		// data connection resuming the control connection session
+		state, err = handshake(getConfig(client1, true), clientConfig1)
+		assert.NoError(t, err)
+		assert.True(t, state.DidResume)
+		state, err = handshake(getConfig(client2, true), clientConfig2)
+		assert.NoError(t, err)
+		assert.True(t, state.DidResume)
+		// a data connection cannot resume the session of another client
+		_, err = handshake(getConfig(client2, true), clientConfig1)
+		assert.Error(t, err)
+		// data connection from a client without a session cache
+		clientConfigNoCache := clientConfig1.Clone()
+		clientConfigNoCache.ClientSessionCache = nil
+		_, err = handshake(getConfig(client1, true), clientConfigNoCache)
+		assert.Error(t, err)
+
+		server.ClientDisconnected(client1)
+		server.ClientDisconnected(client2)
+		assert.Equal(t, 0, server.tlsSessions.size())
+		_, err = server.GetClientTLSConfig(client1, true)
+		assert.Error(t, err)
+	}
+
+	// a new control connection replaces the previous session
+	key1, err := server.tlsSessions.add(client1.ID())
+	assert.NoError(t, err)
+	key2, err := server.tlsSessions.add(client1.ID())
+	assert.NoError(t, err)
+	assert.NotEqual(t, key1, key2)
+	assert.Equal(t, 1, server.tlsSessions.size())
+	server.tlsSessions.remove(client1.ID())
+	// cache size
+	store := newTLSSessionStore(2, 0)
+	for id := uint32(1); id <= 3; id++ {
+		_, err = store.add(id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, store.size())
+	_, ok := store.get(1)
+	assert.False(t, ok, "the oldest session must be evicted")
+	_, ok = store.get(3)
+	assert.True(t, ok)
+	// lifetime
+	store = newTLSSessionStore(0, 100*time.Millisecond)
+	_, err = store.add(1)
+	assert.NoError(t, err)
+	_, ok = store.get(1)
+	assert.True(t, ok)
+	time.Sleep(150 * time.Millisecond)
+	_, ok = store.get(1)
+	assert.False(t, ok, "an expired session must not be resumed")
+	assert.Equal(t, 0, store.size())
+	// TLS session reuse not required
+	binding.TLSSessionReuse = 0
+	server = NewServer(c, configDir, binding, 0)
+	assert.Nil(t, server.tlsSessions)
+	cfg, err := server.GetClientTLSConfig(client1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, server.tlsConfig, cfg)
+}
+
+func TestTLSTicketKeysManager(t *testing.T) {
+	cfg1 := &tls.Config{}
+	cfg2 := &tls.Config{}
+	mgr := newTLSTicketKeysManager([]*tls.Config{cfg1, cfg2})
+	_, err := dataprovider.GetSharedSession(tlsTicketKeysSessionKey)
+	if errors.Is(err, dataprovider.ErrNotImplemented) {
+		// shared sessions are not supported for this provider
+		mgr.refresh()
+		assert.Len(t, mgr.keys, 0)
+		return
+	}
+	mgr.refresh()
+	require.Len(t, mgr.keys, 1)
+	firstKey := mgr.keys[0]
+	// another instance must load the same keys
+	mgr1 := newTLSTicketKeysManager([]*tls.Config{cfg1})
+	mgr1.refresh()
+	require.Len(t, mgr1.keys, 1)
+	assert.Equal(t, firstKey.Key, mgr1.keys[0].Key)
+	// expired key, a new one must be generated and the previous ones preserved
+	oldRotationInterval := tlsTicketKeysRotationInterval
+	tlsTicketKeysRotationInterval = 0
+	for i := 0; i < maxTLSTicketKeys+1; i++ {
+		mgr.refresh()
+	}
+	tlsTicketKeysRotationInterval = oldRotationInterval
+	require.Len(t, mgr.keys, maxTLSTicketKeys)
+	assert.NotEqual(t, firstKey.Key, mgr.keys[0].Key)
+	mgr1.refresh()
+	require.Len(t, mgr1.keys, maxTLSTicketKeys)
+	assert.Equal(t, mgr.keys[0].Key, mgr1.keys[0].Key)
+	// the keys must be stored encrypted
+	session, err := dataprovider.GetSharedSession(tlsTicketKeysSessionKey)
+	require.NoError(t, err)
+	var storedKeys []storedTLSTicketKey
+	err = json.Unmarshal(session.Data.([]byte), &storedKeys)
+	require.NoError(t, err)
+	require.Len(t, storedKeys, maxTLSTicketKeys)
+	for _, k := range storedKeys {
+		assert.True(t, k.Key.IsEncrypted())
+		assert.NotContains(t, string(session.Data.([]byte)), base64.StdEncoding.EncodeToString(mgr.keys[0].Key))
+	}
+	// the keys were already rotated by another instance, they must be preserved
+	task, err := dataprovider.GetTaskByName(tlsTicketKeysTaskName)
+	require.NoError(t, err)
+	keys, err := mgr.rotate()
+	require.NoError(t, err)
+	require.Len(t, keys, maxTLSTicketKeys)
+	assert.Equal(t, mgr.keys[0].Key, keys[0].Key)
+	// an instance that read the previous task version cannot rotate the keys
+	err = dataprovider.UpdateTask(tlsTicketKeysTaskName, task.Version)
+	assert.Error(t, err)
+	// invalid data, the current keys must be preserved
+	for _, data := range []any{
+		[]storedTLSTicketKey{{Key: kms.NewPlainSecret(base64.StdEncoding.EncodeToString(mgr.keys[0].Key))}},
+		[]storedTLSTicketKey{{Key: kms.NewSecret(sdkkms.SecretStatusSecretBox, "invalid", "", "")}},
+	} {
+		err = dataprovider.AddSharedSession(dataprovider.Session{
+			Key:       tlsTicketKeysSessionKey,
+			Data:      data,
+			Type:      dataprovider.SessionTypeTLSTicketKeys,
+			Timestamp: util.GetTimeAsMsSinceEpoch(time.Now()),
+		})
+		assert.NoError(t, err)
+		mgr1.refresh()
+		require.Len(t, mgr1.keys, maxTLSTicketKeys)
+		assert.Equal(t, mgr.keys[0].Key, mgr1.keys[0].Key)
+	}
+
+	err = dataprovider.DeleteSharedSession(tlsTicketKeysSessionKey)
+	assert.NoError(t, err)
+}
+
+func TestMLSxPermFact(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "mlsx_user",
+			HomeDir:  filepath.Clean(os.TempDir()),
+			Permissions: map[string][]string{
+				"/":      {dataprovider.PermAny},
+				"/ro":    {dataprovider.PermListItems, dataprovider.PermDownload},
+				"/files": {dataprovider.PermListItems, dataprovider.PermDeleteFiles, dataprovider.PermRenameFiles},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name:       "vfolder",
+					MappedPath: filepath.Join(os.TempDir(), "vfolder"),
+				},
+				VirtualPath: "/vdir",
+			},
+		},
+	}
+	user.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:           "/",
+			DeniedPatterns: []string{"*.zip"},
+		},
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolFTP, "", "", user),
+	}
+	dirInfo := vfs.NewFileInfo("dir", true, 0, time.Now(), false)
+	fileInfo := vfs.NewFileInfo("file.txt", false, 10, time.Now(), false)
+
+	assert.Equal(t, "celmp", connection.getMLSxPermFact("/", dirInfo))
+	assert.Equal(t, "cdeflmp", connection.getMLSxPermFact("/dir", dirInfo))
+	assert.Equal(t, "celmp", connection.getMLSxPermFact("/vdir", dirInfo))
+	assert.Equal(t, "defl", connection.getMLSxPermFact("/ro", dirInfo))
+	assert.Equal(t, "el", connection.getMLSxPermFact("/ro/sub", dirInfo))
+	assert.Equal(t, "adfrw", connection.getMLSxPermFact("/file.txt", fileInfo))
+	assert.Equal(t, "r", connection.getMLSxPermFact("/ro/file.txt", fileInfo))
+	assert.Equal(t, "df", connection.getMLSxPermFact("/files/file.txt", fileInfo))
+	assert.Empty(t, connection.getMLSxPermFact("/file.zip", fileInfo))
+
+	facts := connection.GetMLSxFacts("/file.txt", fileInfo)
+	assert.Equal(t, "text/plain", facts[mlsxFactMediaType])
+	assert.NotEmpty(t, facts[mlsxFactUnique])
+	assert.NotEqual(t, facts[mlsxFactUnique], connection.GetMLSxFacts("/dir", dirInfo)[mlsxFactUnique])
+	_, ok := facts[mlsxFactUnixOwner]
+	assert.False(t, ok)
+	_, ok = connection.GetMLSxFacts("/dir", dirInfo)[mlsxFactMediaType]
+	assert.False(t, ok)
 }
 
 func TestRelativePath(t *testing.T) {
@@ -0,0 +1,127 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"fmt"
+	"hash/fnv"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+)
+
+// MLSx facts, in addition to type, size and modify, as defined in RFC 3659
+const (
+	mlsxFactPerm      = "Perm"
+	mlsxFactUnique    = "Unique"
+	mlsxFactMediaType = "Media-Type"
+	mlsxFactUnixMode  = "UNIX.mode"
+	mlsxFactUnixOwner = "UNIX.owner"
+	mlsxFactUnixGroup = "UNIX.group"
+)
+
+var supportedMLSxFacts = []string{mlsxFactPerm, mlsxFactUnique, mlsxFactMediaType, mlsxFactUnixMode,
+	mlsxFactUnixOwner, mlsxFactUnixGroup}
+
+// fileIdentity defines the identity of a file as reported by the operating system
+type fileIdentity struct {
+	unique string
+	uid    int
+	gid    int
+}
+
+// GetMLSxFacts implements ClientDriverExtensionMLSxFacts
+func (c *Connection) GetMLSxFacts(name string, info os.FileInfo) map[string]string {
+	facts := map[string]string{
+		mlsxFactPerm:     c.getMLSxPermFact(name, info),
+		mlsxFactUnixMode: fmt.Sprintf("%04o", info.Mode().Perm()),
+	}
+	if identity, ok := getFileIdentity(info); ok {
+		facts[mlsxFactUnique] = identity.unique
+		facts[mlsxFactUnixOwner] = strconv.Itoa(identity.uid)
+		facts[mlsxFactUnixGroup] = strconv.Itoa(identity.gid)
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(name)) //nolint:errcheck
+		facts[mlsxFactUnique] = strconv.FormatUint(h.Sum64(), 16)
+	}
+	if !info.IsDir() {
+		if mediaType := mime.TypeByExtension(path.Ext(info.Name())); mediaType != "" {
+			// parameters such as the charset are separated by ";", the facts separator
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			facts[mlsxFactMediaType] = strings.TrimSpace(mediaType)
+		}
+	}
+	return facts
+}
+
+// getMLSxPermFact returns the RFC 3659 perm fact for the specified virtual path
+// based on the user's permissions and file patterns
+func (c *Connection) getMLSxPermFact(name string, info os.FileInfo) string {
+	parent := path.Dir(name)
+	isAllowed, _ := c.User.IsFileAllowed(name)
+	canModify := isAllowed && name != "/" && !c.User.IsVirtualFolder(name)
+	var perms strings.Builder
+
+	if info.IsDir() {
+		if c.User.HasPerm(dataprovider.PermUpload, name) {
+			perms.WriteString("c")
+		}
+		if canModify && c.User.HasAnyPerm([]string{dataprovider.PermDeleteDirs, dataprovider.PermDelete}, parent) {
+			perms.WriteString("d")
+		}
+		if c.User.HasPerm(dataprovider.PermListItems, name) {
+			perms.WriteString("e")
+		}
+		if canModify && c.User.HasAnyPerm([]string{dataprovider.PermRenameDirs, dataprovider.PermRename}, parent) {
+			perms.WriteString("f")
+		}
+		if c.User.HasPerm(dataprovider.PermListItems, name) {
+			perms.WriteString("l")
+		}
+		if c.User.HasPerm(dataprovider.PermCreateDirs, name) {
+			perms.WriteString("m")
+		}
+		if c.User.HasPermsDeleteAll(name) {
+			perms.WriteString("p")
+		}
+		return perms.String()
+	}
+
+	if !isAllowed {
+		return ""
+	}
+	canOverwrite := c.User.HasPerm(dataprovider.PermOverwrite, parent)
+	if canOverwrite {
+		perms.WriteString("a")
+	}
+	if c.User.HasAnyPerm([]string{dataprovider.PermDeleteFiles, dataprovider.PermDelete}, parent) {
+		perms.WriteString("d")
+	}
+	if c.User.HasAnyPerm([]string{dataprovider.PermRenameFiles, dataprovider.PermRename}, parent) {
+		perms.WriteString("f")
+	}
+	if c.User.HasPerm(dataprovider.PermDownload, parent) {
+		perms.WriteString("r")
+	}
+	if canOverwrite {
+		perms.WriteString("w")
+	}
+	return perms.String()
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !windows
+// +build !windows
+
+package ftpd
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func getFileIdentity(info os.FileInfo) (fileIdentity, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileIdentity{
+			unique: fmt.Sprintf("%xg%x", stat.Dev, stat.Ino),
+			uid:    int(stat.Uid),
+			gid:    int(stat.Gid),
+		}, true
+	}
+	return fileIdentity{}, false
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/command"
+	"github.com/drakkan/sftpgo/v2/internal/httpclient"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	maxPassiveIPHookResponseSize = 128
+	maxCachedPassiveIPs          = 5000
+)
+
+var passiveIPs = passiveIPCache{
+	entries: make(map[string]cachedPassiveIP),
+}
+
+type cachedPassiveIP struct {
+	ip        string
+	expiresAt time.Time
+}
+
+// passiveIPCache stores the passive IPs returned by the passive IP hook
+type passiveIPCache struct {
+	sync.RWMutex
+	entries map[string]cachedPassiveIP
+}
+
+func (c *passiveIPCache) get(key string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.ip, true
+}
+
+func (c *passiveIPCache) add(key, ip string, cacheTime time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCachedPassiveIPs {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedPassiveIPs {
+			c.entries = make(map[string]cachedPassiveIP)
+		}
+	}
+	c.entries[key] = cachedPassiveIP{
+		ip:        ip,
+		expiresAt: now.Add(cacheTime),
+	}
+}
+
+func (c *passiveIPCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[string]cachedPassiveIP)
+}
+
+// getPassiveIPFromHook returns the passive IP to expose for the specified user
+// and connection using the configured hook. The results are cached if a cache
+// time is configured
+func (c *Configuration) getPassiveIPFromHook(username, ipAddr, localAddr, connectionID string) (string, error) {
+	if c.PassiveIPHook == "" {
+		return "", nil
+	}
+	cacheKey := fmt.Sprintf("%v|%v|%v", username, ipAddr, localAddr)
+	if c.PassiveIPHookCacheTime > 0 {
+		if ip, ok := passiveIPs.get(cacheKey); ok {
+			return ip, nil
+		}
+	}
+	var out []byte
+	var err error
+	if strings.HasPrefix(c.PassiveIPHook, "http") {
+		out, err = c.getPassiveIPFromHTTPHook(username, ipAddr, localAddr)
+	} else {
+		out, err = c.getPassiveIPFromExternalHook(username, ipAddr, localAddr)
+	}
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to get the passive IP for user %#v from hook: %v", username, err)
+		return "", err
+	}
+	ip, err := parsePassiveIP(strings.TrimSpace(string(out)))
+	if err != nil {
+		logger.Warn(logSender, connectionID, "invalid passive IP returned from hook for user %#v: %v", username, err)
+		return "", err
+	}
+	if c.PassiveIPHookCacheTime > 0 {
+		passiveIPs.add(cacheKey, ip, time.Duration(c.PassiveIPHookCacheTime)*time.Second)
+	}
+	return ip, nil
+}
+
+func (c *Configuration) getPassiveIPFromHTTPHook(username, ipAddr, localAddr string) ([]byte, error) {
+	u, err := url.Parse(c.PassiveIPHook)
+	if err != nil {
+		return nil, fmt.Errorf("invalid passive IP hook %#v: %w", c.PassiveIPHook, err)
+	}
+	q := u.Query()
+	q.Add("username", username)
+	q.Add("ip", ipAddr)
+	q.Add("local_ip", localAddr)
+	u.RawQuery = q.Encode()
+
+	resp, err := httpclient.RetryableGet(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected passive IP hook response code: %v", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxPassiveIPHookResponseSize))
+}
+
+func (c *Configuration) getPassiveIPFromExternalHook(username, ipAddr, localAddr string) ([]byte, error) {
+	if !filepath.IsAbs(c.PassiveIPHook) {
+		return nil, fmt.Errorf("invalid passive IP hook %#v", c.PassiveIPHook)
+	}
+	timeout, env, args := command.GetConfig(c.PassiveIPHook, command.HookFTPPassiveIP)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.PassiveIPHook, args...)
+	cmd.Env = append(env,
+		fmt.Sprintf("SFTPGO_CONNECTION_USERNAME=%v", username),
+		fmt.Sprintf("SFTPGO_CONNECTION_IP=%v", ipAddr),
+		fmt.Sprintf("SFTPGO_CONNECTION_LOCAL_IP=%v", localAddr))
+	return cmd.Output()
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
@@ -36,26 +37,35 @@ import (
 
 // Server implements the ftpserverlib MainDriver interface
 type Server struct {
-	ID               int
-	config           *Configuration
-	initialMsg       string
-	statusBanner     string
-	binding          Binding
-	tlsConfig        *tls.Config
+	ID           int
+	config       *Configuration
+	initialMsg   string
+	statusBanner string
+	binding      Binding
+	tlsConfig    *tls.Config
+	// TLS sessions of the control connections, they are only set if the
+	// data connections must resume them
+	tlsSessions      *tlsSessionStore
 	mu               sync.RWMutex
 	verifiedTLSConns map[uint32]bool
+	// passive IPs for the logged in users with a specific configuration
+	userPassiveIPs map[uint32]string
+	// passive port ranges for the logged in users with a specific configuration
+	userPassivePortRanges map[uint32]*ftpserver.PortRange
 }
 
 // NewServer returns a new FTP server driver
 func NewServer(config *Configuration, configDir string, binding Binding, id int) *Server {
 	binding.setCiphers()
 	server := &Server{
-		config:           config,
-		initialMsg:       config.Banner,
-		statusBanner:     fmt.Sprintf("SFTPGo %v FTP Server", version.Get().Version),
-		binding:          binding,
-		ID:               id,
-		verifiedTLSConns: make(map[uint32]bool),
+		config:                config,
+		initialMsg:            config.Banner,
+		statusBanner:          fmt.Sprintf("SFTPGo %v FTP Server", version.Get().Version),
+		binding:               binding,
+		ID:                    id,
+		verifiedTLSConns:      make(map[uint32]bool),
+		userPassiveIPs:        make(map[uint32]string),
+		userPassivePortRanges: make(map[uint32]*ftpserver.PortRange),
 	}
 	if config.BannerFile != "" {
 		bannerFilePath := config.BannerFile
@@ -95,6 +105,53 @@ func (s *Server) cleanTLSConnVerification(id uint32) {
 	delete(s.verifiedTLSConns, id)
 }
 
+func (s *Server) setUserPassiveIP(id uint32, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ip == "" {
+		delete(s.userPassiveIPs, id)
+		return
+	}
+	s.userPassiveIPs[id] = ip
+}
+
+func (s *Server) getUserPassiveIP(id uint32) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userPassiveIPs[id]
+}
+
+func (s *Server) setUserPassivePortRange(id uint32, portRange *ftpserver.PortRange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if portRange == nil {
+		delete(s.userPassivePortRanges, id)
+		return
+	}
+	s.userPassivePortRanges[id] = portRange
+}
+
+// passivePortRangeResolver returns the passive port range configured for the logged in
+// user, if any. A nil port range means that the one configured for the service is used
+func (s *Server) passivePortRangeResolver(cc ftpserver.ClientContext) *ftpserver.PortRange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userPassivePortRanges[cc.ID()]
+}
+
+// passiveIPResolver returns the passive IP configured for the logged in user, if any,
+// otherwise the one configured for the binding
+func (s *Server) passiveIPResolver(cc ftpserver.ClientContext) (string, error) {
+	if ip := s.getUserPassiveIP(cc.ID()); ip != "" {
+		return ip, nil
+	}
+	return s.binding.passiveIPResolver(cc)
+}
+
 // GetSettings returns FTP server settings
 func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	if err := s.binding.checkPassiveIP(); err != nil {
@@ -136,25 +193,47 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 	}
 
 	return &ftpserver.Settings{
-		Listener:                 ftpListener,
-		ListenAddr:               s.binding.GetAddress(),
-		PublicIPResolver:         s.binding.passiveIPResolver,
-		PassiveTransferPortRange: portRange,
-		ActiveTransferPortNon20:  s.config.ActiveTransfersPortNon20,
-		IdleTimeout:              -1,
-		ConnectionTimeout:        20,
-		Banner:                   s.statusBanner,
-		TLSRequired:              ftpserver.TLSRequirement(s.binding.TLSMode),
-		DisableSite:              !s.config.EnableSite,
-		DisableActiveMode:        s.config.DisableActiveMode,
-		EnableHASH:               s.config.HASHSupport > 0,
-		EnableCOMB:               s.config.CombineSupport > 0,
-		DefaultTransferType:      ftpserver.TransferTypeBinary,
-		ActiveConnectionsCheck:   ftpserver.DataConnectionRequirement(s.binding.ActiveConnectionsSecurity),
-		PasvConnectionsCheck:     ftpserver.DataConnectionRequirement(s.binding.PassiveConnectionsSecurity),
+		Listener:                  ftpListener,
+		ListenAddr:                s.binding.GetAddress(),
+		PublicIPResolver:          s.passiveIPResolver,
+		PassiveTransferPortRange:  portRange,
+		ActiveTransferPortNon20:   s.config.ActiveTransfersPortNon20,
+		IdleTimeout:               -1,
+		ConnectionTimeout:         20,
+		Banner:                    s.statusBanner,
+		TLSRequired:               ftpserver.TLSRequirement(s.binding.TLSMode),
+		DisableSite:               !s.config.EnableSite,
+		DisableActiveMode:         s.config.DisableActiveMode,
+		EnableHASH:                s.config.HASHSupport > 0,
+		EnableCOMB:                s.config.CombineSupport > 0,
+		DefaultTransferType:       ftpserver.TransferTypeBinary,
+		DisableASCII:              s.binding.DisableASCIIMode,
+		ActiveConnectionsCheck:    ftpserver.DataConnectionRequirement(s.binding.ActiveConnectionsSecurity),
+		PasvConnectionsCheck:      ftpserver.DataConnectionRequirement(s.binding.PassiveConnectionsSecurity),
+		PassiveListenErrorHandler: s.onPassiveListenError,
+		PassivePortRangeResolver:  s.passivePortRangeResolver,
+		MLSxFacts:                 supportedMLSxFacts,
 	}, nil
 }
 
+func (s *Server) onPassiveListenError(cc ftpserver.ClientContext, err error) {
+	connectionID := fmt.Sprintf("%v_%v", s.ID, cc.ID())
+	if errors.Is(err, ftpserver.ErrNoAvailableListeningPort) {
+		portRange := s.passivePortRangeResolver(cc)
+		if portRange == nil {
+			portRange = &ftpserver.PortRange{
+				Start: s.config.PassivePortRange.Start,
+				End:   s.config.PassivePortRange.End,
+			}
+		}
+		logger.Warn(logSender, connectionID, "no passive port available within the configured range %d-%d",
+			portRange.Start, portRange.End)
+		metric.FTPPassivePortExhausted()
+		return
+	}
+	logger.Warn(logSender, connectionID, "unable to create a listener for a passive connection: %v", err)
+}
+
 // ClientConnected is called to send the very first welcome message
 func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	cc.SetDebug(s.binding.Debug)
@@ -189,6 +268,11 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 // ClientDisconnected is called when the user disconnects, even if he never authenticated
 func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 	s.cleanTLSConnVerification(cc.ID())
+	if s.tlsSessions != nil {
+		s.tlsSessions.remove(cc.ID())
+	}
+	s.setUserPassiveIP(cc.ID(), "")
+	s.setUserPassivePortRange(cc.ID(), nil)
 	connID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
 	common.Connections.Remove(connID)
 	common.Connections.RemoveClientConnection(util.GetIPFromRemoteAddress(cc.RemoteAddr().String()))
@@ -317,9 +401,59 @@ func (s *Server) buildTLSConfig() {
 				s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
+		if s.binding.isTLSSessionReuseRequired() {
+			s.tlsSessions = newTLSSessionStore(s.binding.TLSSessionCacheSize,
+				time.Duration(s.binding.TLSSessionLifetime)*time.Second)
+		}
 	}
 }
 
+// GetClientTLSConfig returns the TLS configuration for the control connection or
+// for the data connections of the specified client. If TLS session reuse is
+// required, the session tickets issued on the control connection are encrypted
+// using a key specific to the client, and the data connections only accept
+// tickets encrypted using this key. This way a data connection can only resume
+// the TLS session of its own control connection
+func (s *Server) GetClientTLSConfig(cc ftpserver.ClientContext, forTransfer bool) (*tls.Config, error) {
+	if s.tlsConfig == nil {
+		return nil, errors.New("no TLS certificate configured")
+	}
+	if s.tlsSessions == nil {
+		return s.tlsConfig, nil
+	}
+	if !forTransfer {
+		key, err := s.tlsSessions.add(cc.ID())
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate a TLS session ticket key: %w", err)
+		}
+		tlsConfig := s.tlsConfig.Clone()
+		// new tickets are encrypted using the client key, the shared keys, if any,
+		// allow to resume the sessions established with other instances
+		tlsConfig.SetSessionTicketKeys(append([][32]byte{key}, getSharedTLSTicketKeys()...))
+		return tlsConfig, nil
+	}
+	key, ok := s.tlsSessions.get(cc.ID())
+	if !ok {
+		logger.Debug(logSender, "", "no TLS session to resume for data connection, client %v, remote addr %v",
+			cc.ID(), cc.RemoteAddr())
+		return nil, errors.New("no TLS session to resume for data connections")
+	}
+	tlsConfig := s.tlsConfig.Clone()
+	tlsConfig.SetSessionTicketKeys([][32]byte{key})
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if !state.DidResume {
+			logger.Debug(logSender, "", "TLS session of the control connection not resumed for data connection, "+
+				"client %v, remote addr %v, binding %v", cc.ID(), cc.RemoteAddr(), s.binding.GetAddress())
+			return errors.New("TLS session resumption is required for data connections")
+		}
+		if s.binding.isMutualTLSEnabled() {
+			return s.verifyTLSConnection(state)
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
 // GetTLSConfig returns the TLS configuration for this server
 func (s *Server) GetTLSConfig() (*tls.Config, error) {
 	if s.tlsConfig != nil {
@@ -410,9 +544,38 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		logger.Warn(logSender, connectionID, "unable to swap connection: %v, close fs error: %v", err, errClose)
 		return nil, err
 	}
+	s.setUserPassiveIP(cc.ID(), s.getPassiveIPForUser(&user, cc, connectionID))
+	s.setUserPassivePortRange(cc.ID(), getPassivePortRangeForUser(&user))
 	return connection, nil
 }
 
+// getPassivePortRangeForUser returns the passive port range configured for the
+// user, or for its primary group, if any
+func getPassivePortRangeForUser(user *dataprovider.User) *ftpserver.PortRange {
+	if !user.Filters.FTPPassivePortRange.IsSet() {
+		return nil
+	}
+	return &ftpserver.PortRange{
+		Start: user.Filters.FTPPassivePortRange.Start,
+		End:   user.Filters.FTPPassivePortRange.End,
+	}
+}
+
+// getPassiveIPForUser returns the passive IP configured for the user, or for
+// its primary group, if any, otherwise the one returned by the passive IP hook.
+// An empty string means that the binding configuration must be used
+func (s *Server) getPassiveIPForUser(user *dataprovider.User, cc ftpserver.ClientContext, connectionID string) string {
+	if user.Filters.FTPPassiveIP != "" {
+		return user.Filters.FTPPassiveIP
+	}
+	ip, err := s.config.getPassiveIPFromHook(user.Username, util.GetIPFromRemoteAddress(cc.RemoteAddr().String()),
+		util.GetIPFromRemoteAddress(cc.LocalAddr().String()), connectionID)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
 func setStartDirectory(startDirectory string, cc ftpserver.ClientContext) {
 	if startDirectory == "" {
 		return
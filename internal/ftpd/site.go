@@ -0,0 +1,288 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	ftpserver "github.com/fclairamb/ftpserverlib"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// SITE commands handled by the FTP library
+const (
+	siteCommandChmod   = "CHMOD"
+	siteCommandSymlink = "SYMLINK"
+	siteCommandMkdir   = "MKDIR"
+)
+
+// SITE commands handled by SFTPGo
+const (
+	siteCommandUtime = "UTIME"
+	siteCommandQuota = "QUOTA"
+	siteCommandCpfr  = "CPFR"
+	siteCommandCpto  = "CPTO"
+	siteCommandMD5   = "MD5"
+	siteCommandHelp  = "HELP"
+)
+
+var (
+	errSiteMissingPath = errors.New("missing path")
+	siteUtimeLayouts   = []string{"20060102150405", "200601021504"}
+	// usage for the SITE commands handled by the FTP library
+	librarySiteCommands = map[string]string{
+		siteCommandChmod:   "CHMOD <mode> <path>",
+		siteCommandSymlink: "SYMLINK <target> <link>",
+		siteCommandMkdir:   "MKDIR <path>",
+	}
+)
+
+// siteCommandHandler executes a SITE command and returns the FTP reply code and message
+type siteCommandHandler func(c *Connection, param string) (int, string)
+
+type siteCommand struct {
+	usage   string
+	handler siteCommandHandler
+}
+
+// siteCommands is the registry of the SITE commands handled by SFTPGo,
+// the other SITE commands are handled by the FTP library
+var siteCommands = make(map[string]siteCommand)
+
+func init() {
+	registerSiteCommand(siteCommandUtime, "UTIME <YYYYMMDDhhmm[ss]> <path>", handleSiteUtime)
+	registerSiteCommand(siteCommandQuota, "QUOTA", handleSiteQuota)
+	registerSiteCommand(siteCommandCpfr, "CPFR <source path>", handleSiteCpfr)
+	registerSiteCommand(siteCommandCpto, "CPTO <target path>", handleSiteCpto)
+	registerSiteCommand(siteCommandMD5, "MD5 <path>", handleSiteMD5)
+	registerSiteCommand(siteCommandHelp, "HELP", handleSiteHelp)
+}
+
+func registerSiteCommand(name, usage string, handler siteCommandHandler) {
+	siteCommands[name] = siteCommand{
+		usage:   usage,
+		handler: handler,
+	}
+}
+
+// Site implements ClientDriverExtensionSite. The FTP library handles
+// the SITE commands not registered here
+func (c *Connection) Site(command, param string) (bool, int, string) {
+	c.UpdateLastActivity()
+
+	if !c.User.IsFTPSiteCommandAllowed(command) {
+		c.Log(logger.LevelInfo, "SITE %s denied for user %q", command, c.User.Username)
+		return true, ftpserver.StatusActionNotTaken, c.GetPermissionDeniedError().Error()
+	}
+	cmd, ok := siteCommands[command]
+	if !ok {
+		return false, 0, ""
+	}
+	code, message := cmd.handler(c, param)
+	return true, code, message
+}
+
+// getSitePath returns the absolute virtual path for the specified SITE command parameter
+func (c *Connection) getSitePath(param string) (string, error) {
+	if param == "" {
+		return "", errSiteMissingPath
+	}
+	if !path.IsAbs(param) && c.clientContext != nil {
+		param = path.Join(c.clientContext.Path(), param)
+	}
+	return util.CleanPath(param), nil
+}
+
+// handleSiteUtime sets the access and modification times. Both the
+// "UTIME <time> <path>" and the "UTIME <path> <atime> <mtime> <ctime> UTC"
+// formats are supported, times are in UTC
+func handleSiteUtime(c *Connection, param string) (int, string) {
+	var name string
+	var atime, mtime time.Time
+	var err error
+
+	fields := strings.Fields(param)
+	switch {
+	case len(fields) >= 5 && strings.EqualFold(fields[len(fields)-1], "UTC"):
+		if atime, err = parseSiteTime(fields[len(fields)-4]); err == nil {
+			mtime, err = parseSiteTime(fields[len(fields)-3])
+		}
+		// the path can contain spaces, remove the last four fields
+		name = strings.TrimSpace(param)
+		for i := 0; i < 4; i++ {
+			name = strings.TrimSpace(name[:strings.LastIndexAny(name, " \t")])
+		}
+	case len(fields) >= 2:
+		atime, err = parseSiteTime(fields[0])
+		mtime = atime
+		name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(param), fields[0]))
+	default:
+		err = errSiteMissingPath
+	}
+	if err != nil {
+		return ftpserver.StatusSyntaxErrorParameters, fmt.Sprintf("Invalid SITE UTIME parameters: %v", err)
+	}
+	p, err := c.getSitePath(name)
+	if err != nil {
+		return ftpserver.StatusSyntaxErrorParameters, err.Error()
+	}
+	attrs := common.StatAttributes{
+		Flags: common.StatAttrTimes,
+		Atime: atime,
+		Mtime: mtime,
+	}
+	if err := c.SetStat(p, &attrs); err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	return ftpserver.StatusOK, "SITE UTIME command successful"
+}
+
+func parseSiteTime(value string) (time.Time, error) {
+	for _, layout := range siteUtimeLayouts {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, time.UTC)
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// handleSiteQuota returns the disk quota and the data transfer limits for the user
+func handleSiteQuota(c *Connection, param string) (int, string) {
+	usedFiles, usedSize, usedULSize, usedDLSize, err := dataprovider.GetUsedQuota(c.User.Username)
+	if err != nil {
+		c.Log(logger.LevelError, "unable to get used quota: %v", err)
+		return ftpserver.StatusActionNotTaken, c.GetGenericError(err).Error()
+	}
+	ul, dl, total := c.User.GetDataTransferLimits(c.GetRemoteIP())
+	formatLimit := func(limit int64) string {
+		if limit <= 0 {
+			return "unlimited"
+		}
+		return util.ByteCountIEC(limit)
+	}
+	filesLimit := "unlimited"
+	if c.User.QuotaFiles > 0 {
+		filesLimit = fmt.Sprintf("%d", c.User.QuotaFiles)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("The current quota for this user is [current/limit]:\n")
+	sb.WriteString(fmt.Sprintf("Files: %d/%s\n", usedFiles, filesLimit))
+	sb.WriteString(fmt.Sprintf("Size: %s/%s\n", util.ByteCountIEC(usedSize), formatLimit(c.User.QuotaSize)))
+	sb.WriteString(fmt.Sprintf("Uploaded data: %s/%s\n", util.ByteCountIEC(usedULSize), formatLimit(ul)))
+	sb.WriteString(fmt.Sprintf("Downloaded data: %s/%s\n", util.ByteCountIEC(usedDLSize), formatLimit(dl)))
+	sb.WriteString(fmt.Sprintf("Total data transfer: %s/%s\n", util.ByteCountIEC(usedULSize+usedDLSize),
+		formatLimit(total)))
+	sb.WriteString("SITE QUOTA command successful")
+	return ftpserver.StatusOK, sb.String()
+}
+
+// handleSiteCpfr stores the source path for the next SITE CPTO command
+func handleSiteCpfr(c *Connection, param string) (int, string) {
+	c.copySource = ""
+	p, err := c.getSitePath(param)
+	if err != nil {
+		return ftpserver.StatusSyntaxErrorParameters, err.Error()
+	}
+	if _, err := c.DoStat(p, 0, true); err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	c.copySource = p
+	return ftpserver.StatusFileActionPending, "File or directory exists, ready for destination name"
+}
+
+// handleSiteCpto copies the source path set using SITE CPFR to the specified target
+func handleSiteCpto(c *Connection, param string) (int, string) {
+	source := c.copySource
+	c.copySource = ""
+	if source == "" {
+		return ftpserver.StatusBadCommandSequence, "Bad sequence of commands, use SITE CPFR first"
+	}
+	p, err := c.getSitePath(param)
+	if err != nil {
+		return ftpserver.StatusSyntaxErrorParameters, err.Error()
+	}
+	if err := c.Copy(source, p); err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	return ftpserver.StatusOK, "Copy successful"
+}
+
+// handleSiteMD5 returns the MD5 digest for the specified file. The file is
+// read as a download, so the same permissions and limits apply
+func handleSiteMD5(c *Connection, param string) (int, string) {
+	p, err := c.getSitePath(param)
+	if err != nil {
+		return ftpserver.StatusSyntaxErrorParameters, err.Error()
+	}
+	info, err := c.DoStat(p, 0, true)
+	if err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	if !info.Mode().IsRegular() {
+		return ftpserver.StatusActionNotTaken, fmt.Sprintf("%s is not a regular file", p)
+	}
+	transfer, err := c.GetHandle(p, os.O_RDONLY, 0)
+	if err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	h := md5.New()
+	_, err = io.Copy(h, transfer)
+	errClose := transfer.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return ftpserver.StatusActionNotTaken, err.Error()
+	}
+	return ftpserver.StatusOK, fmt.Sprintf("%s %s", hex.EncodeToString(h.Sum(nil)), p)
+}
+
+// handleSiteHelp returns the SITE commands allowed for the user
+func handleSiteHelp(c *Connection, param string) (int, string) {
+	var commands []string
+	for name, usage := range librarySiteCommands {
+		if c.User.IsFTPSiteCommandAllowed(name) {
+			commands = append(commands, usage)
+		}
+	}
+	for name, cmd := range siteCommands {
+		if c.User.IsFTPSiteCommandAllowed(name) {
+			commands = append(commands, cmd.usage)
+		}
+	}
+	sort.Strings(commands)
+
+	var sb strings.Builder
+	sb.WriteString("The following SITE commands are recognized:\n")
+	for _, cmd := range commands {
+		sb.WriteString(" " + cmd + "\n")
+	}
+	sb.WriteString("Direct comments to the administrator")
+	return ftpserver.StatusHelpMessage, sb.String()
+}
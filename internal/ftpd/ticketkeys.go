@@ -0,0 +1,260 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	tlsTicketKeysSessionKey = "ftpd_tls_session_ticket_keys"
+	// name of the task used to make sure that only one instance rotates the keys
+	tlsTicketKeysTaskName = "ftpd_tls_session_ticket_keys_rotation"
+	// the key used to encrypt new tickets and the previous ones still accepted to decrypt them
+	maxTLSTicketKeys = 3
+)
+
+var (
+	tlsTicketKeysRotationInterval = 12 * time.Hour
+	tlsTicketKeysRefreshInterval  = 5 * time.Minute
+	// sharedTLSTicketKeys is the manager for the keys shared via the data provider, if enabled
+	sharedTLSTicketKeys atomic.Pointer[tlsTicketKeysManager]
+)
+
+type tlsTicketKey struct {
+	Key       []byte
+	CreatedAt int64
+}
+
+// storedTLSTicketKey is the representation of a TLS session ticket key saved
+// within the data provider, the key is encrypted using the configured KMS
+type storedTLSTicketKey struct {
+	Key       *kms.Secret `json:"key"`
+	CreatedAt int64       `json:"created_at"`
+}
+
+// tlsTicketKeysManager loads the TLS session ticket keys from the data provider,
+// rotates them if required and applies them to the configured TLS configs
+type tlsTicketKeysManager struct {
+	mu      sync.Mutex
+	keys    []tlsTicketKey
+	configs []*tls.Config
+}
+
+func newTLSTicketKeysManager(configs []*tls.Config) *tlsTicketKeysManager {
+	return &tlsTicketKeysManager{
+		configs: configs,
+	}
+}
+
+func (m *tlsTicketKeysManager) start() {
+	m.refresh()
+
+	go func() {
+		ticker := time.NewTicker(tlsTicketKeysRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			m.refresh()
+		}
+	}()
+}
+
+func (m *tlsTicketKeysManager) refresh() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys, err := m.load()
+	if err != nil {
+		if len(m.keys) > 0 {
+			logger.Warn(logSender, "", "unable to load TLS session ticket keys, the current ones will be used: %v", err)
+			return
+		}
+		// no keys stored yet, or unable to load them, generate new ones
+		logger.Debug(logSender, "", "unable to load TLS session ticket keys, new ones will be generated: %v", err)
+		keys = nil
+	}
+	if needsTLSTicketKeysRotation(keys) {
+		rotatedKeys, err := m.rotate()
+		if err != nil {
+			logger.Debug(logSender, "", "TLS session ticket keys not rotated: %v", err)
+			// another instance could be rotating the keys, try to load the updated ones
+			if reloadedKeys, errLoad := m.load(); errLoad == nil {
+				keys = reloadedKeys
+			}
+		} else {
+			logger.Debug(logSender, "", "TLS session ticket keys rotated, number of keys: %d", len(rotatedKeys))
+			keys = rotatedKeys
+		}
+	}
+	m.apply(keys)
+}
+
+func needsTLSTicketKeysRotation(keys []tlsTicketKey) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	return util.GetTimeFromMsecSinceEpoch(keys[0].CreatedAt).Add(tlsTicketKeysRotationInterval).Before(time.Now())
+}
+
+func (m *tlsTicketKeysManager) load() ([]tlsTicketKey, error) {
+	session, err := dataprovider.GetSharedSession(tlsTicketKeysSessionKey)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := session.Data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS session ticket keys data type %T", session.Data)
+	}
+	var storedKeys []storedTLSTicketKey
+	if err := json.Unmarshal(data, &storedKeys); err != nil {
+		return nil, err
+	}
+	keys := make([]tlsTicketKey, 0, len(storedKeys))
+	for _, k := range storedKeys {
+		if k.Key == nil || !k.Key.IsEncrypted() {
+			return nil, errors.New("TLS session ticket keys must be encrypted")
+		}
+		if err := k.Key.Decrypt(); err != nil {
+			return nil, fmt.Errorf("unable to decrypt TLS session ticket key: %w", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(k.Key.GetPayload())
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 32 {
+			return nil, errors.New("invalid TLS session ticket key length")
+		}
+		keys = append(keys, tlsTicketKey{
+			Key:       key,
+			CreatedAt: k.CreatedAt,
+		})
+	}
+	return keys, nil
+}
+
+// rotate generates a new key and stores it within the data provider. The
+// rotation is guarded by a conditional update of a task, so only one of the
+// instances sharing the data provider will rotate the keys
+func (m *tlsTicketKeysManager) rotate() ([]tlsTicketKey, error) {
+	task, err := dataprovider.GetTaskByName(tlsTicketKeysTaskName)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			return nil, err
+		}
+		if err := dataprovider.AddTask(tlsTicketKeysTaskName); err != nil {
+			return nil, err
+		}
+		task = dataprovider.Task{
+			Name:    tlsTicketKeysTaskName,
+			Version: 0,
+		}
+	}
+	if err := dataprovider.UpdateTask(tlsTicketKeysTaskName, task.Version); err != nil {
+		return nil, fmt.Errorf("keys rotated by another instance: %w", err)
+	}
+	// we own this rotation, reload the keys since another instance could have
+	// rotated them after our last check
+	keys, err := m.load()
+	if err != nil {
+		keys = nil
+	}
+	if !needsTLSTicketKeysRotation(keys) {
+		return keys, nil
+	}
+	newKey := tlsTicketKey{
+		Key:       make([]byte, 32),
+		CreatedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	if _, err := rand.Read(newKey.Key); err != nil {
+		return nil, err
+	}
+	keys = append([]tlsTicketKey{newKey}, keys...)
+	if len(keys) > maxTLSTicketKeys {
+		keys = keys[:maxTLSTicketKeys]
+	}
+	storedKeys := make([]storedTLSTicketKey, 0, len(keys))
+	for _, k := range keys {
+		secret := kms.NewPlainSecret(base64.StdEncoding.EncodeToString(k.Key))
+		secret.SetAdditionalData(tlsTicketKeysSessionKey)
+		if err := secret.Encrypt(); err != nil {
+			return nil, fmt.Errorf("unable to encrypt TLS session ticket key: %w", err)
+		}
+		storedKeys = append(storedKeys, storedTLSTicketKey{
+			Key:       secret,
+			CreatedAt: k.CreatedAt,
+		})
+	}
+	session := dataprovider.Session{
+		Key:       tlsTicketKeysSessionKey,
+		Data:      storedKeys,
+		Type:      dataprovider.SessionTypeTLSTicketKeys,
+		Timestamp: newKey.CreatedAt,
+	}
+	if err := dataprovider.AddSharedSession(session); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m *tlsTicketKeysManager) apply(keys []tlsTicketKey) {
+	if len(keys) == 0 {
+		return
+	}
+	ticketKeys := getTicketKeys(keys)
+	for _, c := range m.configs {
+		c.SetSessionTicketKeys(ticketKeys)
+	}
+	m.keys = keys
+}
+
+// getTicketKeys returns the keys currently in use
+func (m *tlsTicketKeysManager) getTicketKeys() [][32]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return getTicketKeys(m.keys)
+}
+
+func getTicketKeys(keys []tlsTicketKey) [][32]byte {
+	ticketKeys := make([][32]byte, 0, len(keys))
+	for _, k := range keys {
+		var key [32]byte
+		copy(key[:], k.Key)
+		ticketKeys = append(ticketKeys, key)
+	}
+	return ticketKeys
+}
+
+// getSharedTLSTicketKeys returns the keys shared via the data provider, if any
+func getSharedTLSTicketKeys() [][32]byte {
+	if m := sharedTLSTicketKeys.Load(); m != nil {
+		return m.getTicketKeys()
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ftpd
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// tlsClientSession defines the session ticket key generated for a control connection
+type tlsClientSession struct {
+	key       [32]byte
+	createdAt time.Time
+}
+
+// tlsSessionStore keeps a TLS session ticket key for each client. The tickets
+// issued on a control connection are encrypted using the key of its client and
+// the data connections only accept tickets encrypted with the same key, this
+// way a data connection can only resume the TLS session of its control connection
+type tlsSessionStore struct {
+	mu       sync.Mutex
+	maxSize  int
+	lifetime time.Duration
+	sessions map[uint32]tlsClientSession
+}
+
+func newTLSSessionStore(maxSize int, lifetime time.Duration) *tlsSessionStore {
+	return &tlsSessionStore{
+		maxSize:  maxSize,
+		lifetime: lifetime,
+		sessions: make(map[uint32]tlsClientSession),
+	}
+}
+
+// add generates a new session ticket key for the specified client, the previous
+// one, if any, is replaced. If the store is full the oldest session is evicted
+func (s *tlsSessionStore) add(id uint32) ([32]byte, error) {
+	session := tlsClientSession{
+		createdAt: time.Now(),
+	}
+	if _, err := rand.Read(session.key[:]); err != nil {
+		return session.key, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	if s.maxSize > 0 && len(s.sessions) >= s.maxSize {
+		var oldestID uint32
+		var oldest time.Time
+		for k, v := range s.sessions {
+			if oldest.IsZero() || v.createdAt.Before(oldest) {
+				oldestID = k
+				oldest = v.createdAt
+			}
+		}
+		delete(s.sessions, oldestID)
+	}
+	s.sessions[id] = session
+	return session.key, nil
+}
+
+// get returns the session ticket key for the specified client, if it is not expired
+func (s *tlsSessionStore) get(id uint32) ([32]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return session.key, false
+	}
+	if s.lifetime > 0 && time.Since(session.createdAt) > s.lifetime {
+		delete(s.sessions, id)
+		return session.key, false
+	}
+	return session.key, true
+}
+
+func (s *tlsSessionStore) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+}
+
+func (s *tlsSessionStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
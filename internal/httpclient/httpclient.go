@@ -246,6 +246,13 @@ func RetryableGet(url string) (*http.Response, error) {
 
 // RetryablePost issues a POST to the specified URL using the retryable client
 func RetryablePost(url string, contentType string, body io.Reader) (*http.Response, error) {
+	return RetryablePostWithSigner(url, contentType, body, nil)
+}
+
+// RetryablePostWithSigner issues a POST to the specified URL using the retryable client.
+// If not nil, signer is called for each attempt and returns headers, such as signatures,
+// that must be unique for each request. The signer must not read the request body
+func RetryablePostWithSigner(url string, contentType string, body io.Reader, signer func(*http.Request) http.Header) (*http.Response, error) {
 	req, err := retryablehttp.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
@@ -253,11 +260,33 @@ func RetryablePost(url string, contentType string, body io.Reader) (*http.Respon
 	req.Header.Set("Content-Type", contentType)
 	addHeadersToRetryableReq(req, url)
 	client := GetRetraybleHTTPClient()
+	if signer != nil {
+		client.HTTPClient.Transport = &signerTransport{
+			base:   client.HTTPClient.Transport,
+			signer: signer,
+		}
+	}
 	defer client.HTTPClient.CloseIdleConnections()
 
 	return client.Do(req)
 }
 
+// signerTransport adds the headers returned by the signer to each request
+type signerTransport struct {
+	base   http.RoundTripper
+	signer func(*http.Request) http.Header
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *signerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request, we add the headers to a copy
+	r := req.Clone(req.Context())
+	for key, values := range t.signer(req) {
+		r.Header[key] = values
+	}
+	return t.base.RoundTrip(r)
+}
+
 func addHeaders(req *http.Request, url string) {
 	for idx := range httpConfig.Headers {
 		h := &httpConfig.Headers[idx]
@@ -22,6 +22,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 func getEventActions(w http.ResponseWriter, r *http.Request) {
@@ -99,6 +100,10 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 	actionID := action.ID
 	name = action.Name
 	currentHTTPPassword := action.Options.HTTPConfig.Password
+	currentHTTPSigningSecret := action.Options.HTTPConfig.SigningSecret
+	currentHTTPClientKey := action.Options.HTTPConfig.ClientKey
+	currentTransferFs := action.Options.FsConfig.Transfer.TargetFs
+	currentBrokerPassword := action.Options.BrokerConfig.Password
 	action.Options = dataprovider.BaseEventActionOptions{}
 
 	err = render.DecodeJSON(r.Body, &action)
@@ -114,6 +119,18 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 		if action.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			action.Options.HTTPConfig.Password = currentHTTPPassword
 		}
+		if action.Options.HTTPConfig.SigningSecret.IsNotPlainAndNotEmpty() {
+			action.Options.HTTPConfig.SigningSecret = currentHTTPSigningSecret
+		}
+		if action.Options.HTTPConfig.ClientKey.IsNotPlainAndNotEmpty() {
+			action.Options.HTTPConfig.ClientKey = currentHTTPClientKey
+		}
+	case dataprovider.ActionTypeFilesystem:
+		updateFsTransferSecrets(&action.Options.FsConfig.Transfer.TargetFs, currentTransferFs)
+	case dataprovider.ActionTypeMessageBroker:
+		if action.Options.BrokerConfig.Password.IsNotPlainAndNotEmpty() {
+			action.Options.BrokerConfig.Password = currentBrokerPassword
+		}
 	}
 
 	err = dataprovider.UpdateEventAction(&action, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
@@ -247,3 +264,11 @@ func deleteEventRule(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, err, "Event rule deleted", http.StatusOK)
 }
+
+func updateFsTransferSecrets(fsConfig *vfs.Filesystem, currentFsConfig vfs.Filesystem) {
+	currentFsConfig.SetEmptySecretsIfNil()
+	updateEncryptedSecrets(fsConfig, currentFsConfig.S3Config.AccessSecret, currentFsConfig.AzBlobConfig.AccountKey,
+		currentFsConfig.AzBlobConfig.SASURL, currentFsConfig.GCSConfig.Credentials, currentFsConfig.CryptConfig.Passphrase,
+		currentFsConfig.SFTPConfig.Password, currentFsConfig.SFTPConfig.PrivateKey, currentFsConfig.SFTPConfig.KeyPassphrase,
+		currentFsConfig.HTTPConfig.Password, currentFsConfig.HTTPConfig.APIKey)
+}
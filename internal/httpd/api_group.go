@@ -96,6 +96,13 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	group.UserSettings.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	group.UserSettings.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
 	group.UserSettings.FsConfig.HTTPConfig = vfs.HTTPFsConfig{}
+	group.UserSettings.SecurityKeysOnly = false
+	group.UserSettings.RequireUserPresence = false
+	group.UserSettings.RequireUserVerification = false
+	group.UserSettings.FTPPassiveIP = ""
+	group.UserSettings.FTPPassivePortRange = dataprovider.FTPPortRange{}
+	group.UserSettings.FTPAllowedSiteCommands = nil
+	group.UserSettings.FTPDeniedSiteCommands = nil
 	err = render.DecodeJSON(r.Body, &group)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
+)
+
+type hostKeyGenerateRequest struct {
+	Type string `json:"type"`
+}
+
+func getHostKeys(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	render.JSON(w, r, sftpd.GetHostKeys())
+}
+
+func generateHostKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req hostKeyGenerateRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	hostKey, err := sftpd.GenerateHostKey(req.Type)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "host key %q generated by admin %q, fingerprint %q", hostKey.ID, claims.Username,
+		hostKey.Fingerprint)
+	w.Header().Set("Location", hostKeysPath)
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, hostKey)
+}
+
+func promoteHostKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	if err := sftpd.PromoteHostKey(id); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "host key %q promoted by admin %q", id, claims.Username)
+	sendAPIResponse(w, r, nil, "Host key promoted", http.StatusOK)
+}
+
+func retireHostKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	if err := sftpd.RetireHostKey(id); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "host key %q retired by admin %q", id, claims.Username)
+	sendAPIResponse(w, r, nil, "Host key retired", http.StatusOK)
+}
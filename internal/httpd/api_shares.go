@@ -100,7 +100,7 @@ func addShare(w http.ResponseWriter, r *http.Request) {
 	if share.Name == "" {
 		share.Name = share.ShareID
 	}
-	if share.Password == "" {
+	if share.Password == "" && !share.IsInternal() {
 		if util.Contains(claims.Permissions, sdk.WebClientShareNoPasswordDisabled) {
 			sendAPIResponse(w, r, nil, "You are not authorized to share files/folders without a password",
 				http.StatusForbidden)
@@ -132,6 +132,11 @@ func updateShare(w http.ResponseWriter, r *http.Request) {
 	}
 
 	oldPassword := share.Password
+	// the JSON decoder reuses the existing slices
+	oldRecipients := dataprovider.Share{
+		Users:  append([]string(nil), share.Users...),
+		Groups: append([]string(nil), share.Groups...),
+	}
 	err = render.DecodeJSON(r.Body, &share)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -143,7 +148,7 @@ func updateShare(w http.ResponseWriter, r *http.Request) {
 	if share.Password == redactedSecret {
 		share.Password = oldPassword
 	}
-	if share.Password == "" {
+	if share.Password == "" && !share.IsInternal() {
 		if util.Contains(claims.Permissions, sdk.WebClientShareNoPasswordDisabled) {
 			sendAPIResponse(w, r, nil, "You are not authorized to share files/folders without a password",
 				http.StatusForbidden)
@@ -154,6 +159,7 @@ func updateShare(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	disconnectShareRecipients(&oldRecipients, &share)
 	sendAPIResponse(w, r, nil, "Share updated", http.StatusOK)
 }
 
@@ -166,14 +172,68 @@ func deleteShare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	share, err := dataprovider.ShareExists(shareID, claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	err = dataprovider.DeleteShare(shareID, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	disconnectShareRecipients(&share)
 	sendAPIResponse(w, r, err, "Share deleted", http.StatusOK)
 }
 
+// disconnectShareRecipients closes the active connections of the users receiving
+// the specified internal shares, the changes will be applied on the next login
+func disconnectShareRecipients(shares ...*dataprovider.Share) {
+	var users, groups []string
+	for _, share := range shares {
+		if share.IsInternal() {
+			users = append(users, share.Users...)
+			groups = append(groups, share.Groups...)
+		}
+	}
+	if len(users) == 0 && len(groups) == 0 {
+		return
+	}
+	recipients := make(map[string]bool)
+	for _, stat := range common.Connections.GetStats("") {
+		isRecipient, ok := recipients[stat.Username]
+		if !ok {
+			isRecipient = isShareRecipient(stat.Username, users, groups)
+			recipients[stat.Username] = isRecipient
+		}
+		if isRecipient {
+			common.Connections.Close(stat.ConnectionID, "")
+		}
+	}
+}
+
+func isShareRecipient(username string, users, groups []string) bool {
+	if username == "" {
+		return false
+	}
+	if util.Contains(users, username) {
+		return true
+	}
+	if len(groups) == 0 {
+		return false
+	}
+	user, err := dataprovider.UserExists(username, "")
+	if err != nil {
+		return false
+	}
+	for _, g := range user.Groups {
+		if util.Contains(groups, g.Name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *httpdServer) readBrowsableShareContents(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	validScopes := []dataprovider.ShareScope{dataprovider.ShareScopeRead, dataprovider.ShareScopeReadWrite}
@@ -413,6 +473,10 @@ func (s *httpdServer) checkPublicShare(w http.ResponseWriter, r *http.Request, v
 
 	shareID := getURLParam(r, "id")
 	share, err := dataprovider.ShareExists(shareID, "")
+	if err == nil && share.IsInternal() {
+		// internal shares are available to the recipients only
+		err = util.NewRecordNotFoundError(fmt.Sprintf("share %q is not public", shareID))
+	}
 	if err != nil {
 		statusCode := getRespStatus(err)
 		if statusCode == http.StatusNotFound {
@@ -139,6 +139,32 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "2FA disabled", http.StatusOK)
 }
 
+func signUserPublicKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req sshCertificateRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(getURLParam(r, "username"), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	cert, err := signUserSSHKey(&user, req, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, cert)
+}
+
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -166,6 +192,7 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	username = user.Username
 	totpConfig := user.Filters.TOTPConfig
 	recoveryCodes := user.Filters.RecoveryCodes
+	publicKeysLastUse := user.Filters.PublicKeysLastUse
 	currentPermissions := user.Permissions
 	currentS3AccessSecret := user.FsConfig.S3Config.AccessSecret
 	currentAzAccountKey := user.FsConfig.AzBlobConfig.AccountKey
@@ -187,6 +214,14 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	user.FsConfig.HTTPConfig = vfs.HTTPFsConfig{}
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{}
 	user.Filters.RecoveryCodes = nil
+	user.Filters.PublicKeysLastUse = nil
+	user.Filters.SecurityKeysOnly = false
+	user.Filters.RequireUserPresence = false
+	user.Filters.RequireUserVerification = false
+	user.Filters.FTPPassiveIP = ""
+	user.Filters.FTPPassivePortRange = dataprovider.FTPPortRange{}
+	user.Filters.FTPAllowedSiteCommands = nil
+	user.Filters.FTPDeniedSiteCommands = nil
 	user.VirtualFolders = nil
 	err = render.DecodeJSON(r.Body, &user)
 	if err != nil {
@@ -197,6 +232,7 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	user.Username = username
 	user.Filters.TOTPConfig = totpConfig
 	user.Filters.RecoveryCodes = recoveryCodes
+	user.Filters.PublicKeysLastUse = publicKeysLastUse
 	user.SetEmptySecretsIfNil()
 	// we use new Permissions if passed otherwise the old ones
 	if len(user.Permissions) == 0 {
@@ -234,6 +270,8 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 	sendAPIResponse(w, r, err, "User deleted", http.StatusOK)
 	disconnectUser(dataprovider.ConvertName(username))
+	common.RemoveWebDAVLocks(dataprovider.ConvertName(username))
+	common.RemoveChangesJournal(dataprovider.ConvertName(username))
 }
 
 func forgotUserPassword(w http.ResponseWriter, r *http.Request) {
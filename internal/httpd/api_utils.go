@@ -41,6 +41,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
@@ -70,6 +71,34 @@ type userProfile struct {
 	PublicKeys []string `json:"public_keys,omitempty"`
 }
 
+type sshCertificateRequest struct {
+	PublicKey string `json:"public_key"`
+	// validity in minutes, 0 means the default one
+	Validity int `json:"validity"`
+}
+
+type sshCertificate struct {
+	Certificate string `json:"certificate"`
+	// expiration as unix timestamp in milliseconds
+	ValidBefore int64 `json:"valid_before"`
+}
+
+// signUserSSHKey issues a short-lived SSH certificate for the specified user
+// using the built-in certificate authority
+func signUserSSHKey(user *dataprovider.User, req sshCertificateRequest, executor, ipAddress string) (sshCertificate, error) {
+	if user.Status != 1 {
+		return sshCertificate{}, util.NewValidationError(fmt.Sprintf("user %q is disabled", user.Username))
+	}
+	cert, validBefore, err := sftpd.SignUserPublicKey(user, req.PublicKey, req.Validity, executor, ipAddress)
+	if err != nil {
+		return sshCertificate{}, err
+	}
+	return sshCertificate{
+		Certificate: cert,
+		ValidBefore: util.GetTimeAsMsSinceEpoch(validBefore),
+	}, nil
+}
+
 func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message string, code int) {
 	var errorString string
 	if _, ok := err.(*util.RecordNotFoundError); ok {
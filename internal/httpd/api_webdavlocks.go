@@ -0,0 +1,84 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+func getWebDAVLocks(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	locks, err := common.GetWebDAVLocks(r.URL.Query().Get("username"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if claims.Role != "" {
+		allowedUsers := make(map[string]bool)
+		result := make([]common.WebDAVLock, 0, len(locks))
+		for _, lock := range locks {
+			allowed, ok := allowedUsers[lock.Username]
+			if !ok {
+				_, err := dataprovider.UserExists(lock.Username, claims.Role)
+				allowed = err == nil
+				allowedUsers[lock.Username] = allowed
+			}
+			if allowed {
+				result = append(result, lock)
+			}
+		}
+		locks = result
+	}
+	render.JSON(w, r, locks)
+}
+
+func breakWebDAVLock(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	token := getURLParam(r, "token")
+	lock, err := common.GetWebDAVLock(token)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if claims.Role != "" {
+		if _, err := dataprovider.UserExists(lock.Username, claims.Role); err != nil {
+			sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+	}
+	if err := common.RemoveWebDAVLock(token); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "WebDAV lock %q on path %q, user %q, broken by admin %q", token, lock.Root,
+		lock.Username, claims.Username)
+	sendAPIResponse(w, r, nil, "Lock removed", http.StatusOK)
+}
@@ -54,6 +54,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/html"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -91,6 +92,7 @@ const (
 	folderPath                     = "/api/v2/folders"
 	groupPath                      = "/api/v2/groups"
 	activeConnectionsPath          = "/api/v2/connections"
+	webDAVLocksPath                = "/api/v2/webdavlocks"
 	serverStatusPath               = "/api/v2/status"
 	quotasBasePath                 = "/api/v2/quotas"
 	quotaScanPath                  = "/api/v2/quotas/users/scans"
@@ -117,6 +119,8 @@ const (
 	userTOTPSavePath               = "/api/v2/user/totp/save"
 	user2FARecoveryCodesPath       = "/api/v2/user/2fa/recoverycodes"
 	userProfilePath                = "/api/v2/user/profile"
+	userSSHCertificatePath         = "/api/v2/user/ssh-certificate"
+	hostKeysPath                   = "/api/v2/hostkeys"
 	userSharesPath                 = "/api/v2/user/shares"
 	retentionBasePath              = "/api/v2/retention/users"
 	metadataBasePath               = "/api/v2/metadata/users"
@@ -170,6 +174,7 @@ const (
 	webClientDownloadZipPath       = "/web/client/downloadzip"
 	webChangeClientPwdPath         = "/web/client/changepwd"
 	webClientProfilePath           = "/web/client/profile"
+	webClientSSHCertPath           = "/web/client/sshcert"
 	webClientTwoFactorPath         = "/web/client/twofactor"
 	webClientTwoFactorRecoveryPath = "/web/client/twofactor-recovery"
 	webClientLogoutPath            = "/web/client/logout"
@@ -395,6 +400,12 @@ func TestMain(m *testing.M) {
 	}
 	hostKeyPath := filepath.Join(os.TempDir(), "id_rsa")
 	sftpdConf.HostKeys = []string{hostKeyPath}
+	userCAKeyPath := filepath.Join(os.TempDir(), "httpd_user_ca_key")
+	sftpdConf.UserCA.Enabled = true
+	sftpdConf.UserCA.KeyPath = userCAKeyPath
+	hostKeysRotationFile := filepath.Join(os.TempDir(), "httpd_host_keys_rotation.json")
+	os.Remove(hostKeysRotationFile)
+	sftpdConf.HostKeysRotationFile = hostKeysRotationFile
 
 	go func() {
 		if err := httpdConf.Initialize(configDir, 0); err != nil {
@@ -454,6 +465,8 @@ func TestMain(m *testing.M) {
 	os.Remove(keyPath)
 	os.Remove(hostKeyPath)
 	os.Remove(hostKeyPath + ".pub")
+	os.Remove(userCAKeyPath)
+	os.Remove(hostKeysRotationFile)
 	os.Remove(postConnectPath)
 	os.Remove(preActionPath)
 	os.Exit(exitCode)
@@ -1096,6 +1109,26 @@ func TestGroupValidation(t *testing.T) {
 	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp), "invalid web client options")
+	group.UserSettings.Filters.WebClient = nil
+	group.UserSettings.FTPPassiveIP = "::1"
+	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid FTP passive IP")
+	group.UserSettings.FTPPassiveIP = ""
+	group.UserSettings.FTPPassivePortRange = dataprovider.FTPPortRange{Start: 50000}
+	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid FTP passive port range")
+	group.UserSettings.FTPPassivePortRange = dataprovider.FTPPortRange{}
+	group.UserSettings.FTPDeniedSiteCommands = []string{"invalid"}
+	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid FTP SITE command")
+	group.UserSettings.FTPAllowedSiteCommands = []string{"MD5"}
+	group.UserSettings.FTPDeniedSiteCommands = []string{"md5"}
+	_, resp, err = httpdtest.AddGroup(group, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot be both allowed and denied")
 }
 
 func TestGroupSettingsOverride(t *testing.T) {
@@ -1208,6 +1241,11 @@ func TestGroupSettingsOverride(t *testing.T) {
 	group1.UserSettings.Filters.MaxUploadFileSize = 1024 * 1024
 	group1.UserSettings.Filters.StartDirectory = "/startdir/%username%"
 	group1.UserSettings.Filters.WebClient = []string{sdk.WebClientInfoChangeDisabled}
+	group1.UserSettings.FTPPassiveIP = "172.16.1.1"
+	group1.UserSettings.FTPPassivePortRange = dataprovider.FTPPortRange{Start: 50000, End: 50100}
+	group1.UserSettings.FTPAllowedSiteCommands = []string{"HELP", "MD5"}
+	group1.UserSettings.FTPDeniedSiteCommands = []string{"CHMOD"}
+	group1.UserSettings.RequireUserVerification = true
 	group1.UserSettings.Permissions = map[string][]string{
 		"/":               {dataprovider.PermListItems, dataprovider.PermUpload},
 		"/sub/%username%": {dataprovider.PermRename},
@@ -1237,6 +1275,15 @@ func TestGroupSettingsOverride(t *testing.T) {
 	assert.Equal(t, group1.UserSettings.TotalDataTransfer, user.TotalDataTransfer)
 	assert.Equal(t, group1.UserSettings.Filters.MaxUploadFileSize, user.Filters.MaxUploadFileSize)
 	assert.Equal(t, "/startdir/"+defaultUsername, user.Filters.StartDirectory)
+	assert.Equal(t, group1.UserSettings.FTPPassiveIP, user.Filters.FTPPassiveIP)
+	assert.Equal(t, group1.UserSettings.FTPPassivePortRange, user.Filters.FTPPassivePortRange)
+	assert.Equal(t, group1.UserSettings.FTPAllowedSiteCommands, user.Filters.FTPAllowedSiteCommands)
+	assert.Equal(t, group1.UserSettings.FTPDeniedSiteCommands, user.Filters.FTPDeniedSiteCommands)
+	assert.True(t, user.Filters.RequireUserVerification)
+	assert.False(t, user.Filters.RequireUserPresence)
+	assert.True(t, user.IsFTPSiteCommandAllowed("md5"))
+	assert.False(t, user.IsFTPSiteCommandAllowed("CHMOD"))
+	assert.False(t, user.IsFTPSiteCommandAllowed("QUOTA"))
 	if assert.Len(t, user.Filters.FilePatterns, 1) {
 		assert.Equal(t, "/sub2/"+defaultUsername+"test", user.Filters.FilePatterns[0].Path)
 	}
@@ -1681,6 +1728,25 @@ func TestEventActionValidation(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp), "cannot save HTTP configuration with a redacted secret")
 	action.Options.HTTPConfig.Password = nil
+	action.Options.HTTPConfig.SigningSecret = kms.NewSecret(sdkkms.SecretStatusRedacted, "payload", "", "")
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot save HTTP configuration with a redacted secret")
+	action.Options.HTTPConfig.SigningSecret = nil
+	action.Options.HTTPConfig.ClientCert = httpsCert
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "both client certificate and key are required")
+	action.Options.HTTPConfig.ClientKey = kms.NewPlainSecret("invalid key")
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid client certificate/key pair")
+	action.Options.HTTPConfig.ClientKey = kms.NewSecret(sdkkms.SecretStatusRedacted, "payload", "", "")
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot save HTTP configuration with a redacted secret")
+	action.Options.HTTPConfig.ClientCert = ""
+	action.Options.HTTPConfig.ClientKey = nil
 	action.Options.HTTPConfig.Method = http.MethodDelete
 	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
 	assert.NoError(t, err)
@@ -1732,6 +1798,12 @@ func TestEventActionValidation(t *testing.T) {
 	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp), "content type is automatically set for multipart requests")
+	action.Options.HTTPConfig.Headers = nil
+	action.Options.HTTPConfig.SigningSecret = kms.NewPlainSecret("secret")
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "request signing is not supported for multipart requests with files")
+	action.Options.HTTPConfig.SigningSecret = nil
 
 	action.Type = dataprovider.ActionTypeCommand
 	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
@@ -1891,6 +1963,204 @@ func TestEventActionValidation(t *testing.T) {
 	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
 	assert.NoError(t, err)
 	assert.Contains(t, string(resp), "invalid path to compress")
+	action.Options.FsConfig.Type = dataprovider.FilesystemActionExtract
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "archive name is mandatory")
+	action.Options.FsConfig.Extract.Name = "/"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid archive name")
+	action.Options.FsConfig.Extract.Name = "archive.zip"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "extraction target directory is mandatory")
+	action.Options.FsConfig.Extract.Target = "/target"
+	action.Options.FsConfig.Extract.MaxSize = -1
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid max uncompressed size")
+	action.Options.FsConfig.Extract.MaxSize = 0
+	action.Options.FsConfig.Extract.MaxEntries = -1
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid max archive entries")
+	action.Options.FsConfig.Type = dataprovider.FilesystemActionTransfer
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "no path to transfer specified")
+	action.Options.FsConfig.Transfer.Paths = []dataprovider.KeyValue{
+		{
+			Key:   "/file.txt",
+			Value: "",
+		},
+	}
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid paths to transfer")
+	action.Options.FsConfig.Transfer.Paths[0].Value = "/"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "transferring the root directory is not allowed")
+	action.Options.FsConfig.Transfer.Paths[0].Value = "/target.txt"
+	action.Options.FsConfig.Transfer.Retries = 11
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid transfer retries")
+	action.Options.FsConfig.Transfer.Retries = 1
+	action.Options.FsConfig.Transfer.DeleteSource = true
+	action.Options.FsConfig.Transfer.MoveSourceTo = "/sent"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "the source file cannot be both deleted and moved")
+	action.Options.FsConfig.Transfer.DeleteSource = false
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "unsupported transfer target filesystem")
+	action.Options.FsConfig.Transfer.TargetFs.Provider = sdk.HTTPFilesystemProvider
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "unsupported transfer target filesystem")
+	action.Options.FsConfig.Transfer.TargetFs.Provider = sdk.SFTPFilesystemProvider
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "could not validate SFTP fs config")
+	action.Options.FsConfig.Transfer.TargetFs.SFTPConfig = vfs.SFTPFsConfig{
+		BaseSFTPFsConfig: sdk.BaseSFTPFsConfig{
+			Endpoint: "127.0.0.1:2022",
+			Username: "user",
+		},
+		Password: kms.NewSecret(sdkkms.SecretStatusRedacted, "", "", ""),
+	}
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot save a transfer target filesystem with a redacted secret")
+
+	action.Type = dataprovider.ActionTypeMessageBroker
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid message broker protocol")
+	action.Options.BrokerConfig.Protocol = dataprovider.BrokerProtocolAMQP
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "message broker endpoint is required")
+	action.Options.BrokerConfig.Endpoint = "nats://127.0.0.1:4222"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid AMQP endpoint schema")
+	action.Options.BrokerConfig.Protocol = dataprovider.BrokerProtocolNATS
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "message broker topic is required")
+	action.Options.BrokerConfig.Topic = "sftpgo.{{Event}}"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid message broker timeout")
+	action.Options.BrokerConfig.Protocol = dataprovider.BrokerProtocolKafka
+	action.Options.BrokerConfig.Endpoint = "kafka://127.0.0.1:9092, ,127.0.0.1"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid Kafka broker")
+	action.Options.BrokerConfig.Endpoint = "kafka://,"
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "no Kafka broker defined")
+	action.Options.BrokerConfig.Protocol = dataprovider.BrokerProtocolNATS
+	action.Options.BrokerConfig.Endpoint = "nats://127.0.0.1:4222"
+	action.Options.BrokerConfig.Timeout = 10
+	action.Options.BrokerConfig.Password = kms.NewSecret(sdkkms.SecretStatusRedacted, "payload", "", "")
+	_, resp, err = httpdtest.AddEventAction(action, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot save message broker configuration with a redacted secret")
+}
+
+func TestEventActionMessageBroker(t *testing.T) {
+	a := dataprovider.BaseEventAction{
+		Name: "broker_action",
+		Type: dataprovider.ActionTypeMessageBroker,
+		Options: dataprovider.BaseEventActionOptions{
+			BrokerConfig: dataprovider.EventActionBrokerConfig{
+				Protocol: dataprovider.BrokerProtocolAMQP,
+				Endpoint: "amqps://127.0.0.1:5671/vhost",
+				Username: "guest",
+				Password: kms.NewPlainSecret("guest_pwd"),
+				Exchange: "events",
+				Topic:    "sftpgo.{{Event}}",
+				Timeout:  15,
+			},
+		},
+	}
+	action, _, err := httpdtest.AddEventAction(a, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, action.Options.BrokerConfig.Password.GetStatus())
+	assert.NotEmpty(t, action.Options.BrokerConfig.Password.GetPayload())
+	assert.Empty(t, action.Options.BrokerConfig.Password.GetKey())
+	assert.Empty(t, action.Options.BrokerConfig.Password.GetAdditionalData())
+	dbAction, err := dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, action.Name, dbAction.Options.BrokerConfig.Password.GetAdditionalData())
+	payload := dbAction.Options.BrokerConfig.Password.GetPayload()
+	// update the action, the password must be preserved
+	action.Options.BrokerConfig.Body = "{{Name}}"
+	action.Options.BrokerConfig.Protocol = dataprovider.BrokerProtocolKafka
+	action.Options.BrokerConfig.Endpoint = "kafka://127.0.0.1:9092, 127.0.0.1:9093"
+	action.Options.BrokerConfig.Exchange = ""
+	action, _, err = httpdtest.UpdateEventAction(action, http.StatusOK)
+	assert.NoError(t, err)
+	dbAction, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, dbAction.Options.BrokerConfig.Password.GetPayload())
+	assert.Equal(t, "{{Name}}", dbAction.Options.BrokerConfig.Body)
+
+	_, err = httpdtest.RemoveEventAction(action, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestEventActionHTTPSigningAndClientCert(t *testing.T) {
+	a := dataprovider.BaseEventAction{
+		Name: "http_signed_action",
+		Type: dataprovider.ActionTypeHTTP,
+		Options: dataprovider.BaseEventActionOptions{
+			HTTPConfig: dataprovider.EventActionHTTPConfig{
+				Endpoint:      "https://localhost:4443/hook",
+				Timeout:       20,
+				Method:        http.MethodPost,
+				Body:          "{{Name}}",
+				SigningSecret: kms.NewPlainSecret("signing secret"),
+				ClientCert:    httpsCert,
+				ClientKey:     kms.NewPlainSecret(httpsKey),
+			},
+		},
+	}
+	action, _, err := httpdtest.AddEventAction(a, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, action.Options.HTTPConfig.SigningSecret.GetStatus())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, action.Options.HTTPConfig.ClientKey.GetStatus())
+	assert.Empty(t, action.Options.HTTPConfig.ClientKey.GetKey())
+	assert.Empty(t, action.Options.HTTPConfig.ClientKey.GetAdditionalData())
+	dbAction, err := dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, action.Name, dbAction.Options.HTTPConfig.SigningSecret.GetAdditionalData())
+	assert.Equal(t, action.Name, dbAction.Options.HTTPConfig.ClientKey.GetAdditionalData())
+	signingSecretPayload := dbAction.Options.HTTPConfig.SigningSecret.GetPayload()
+	clientKeyPayload := dbAction.Options.HTTPConfig.ClientKey.GetPayload()
+	// update the action, the secrets must be preserved
+	action.Options.HTTPConfig.Body = "{{Event}}"
+	action, _, err = httpdtest.UpdateEventAction(action, http.StatusOK)
+	assert.NoError(t, err)
+	dbAction, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, signingSecretPayload, dbAction.Options.HTTPConfig.SigningSecret.GetPayload())
+	assert.Equal(t, clientKeyPayload, dbAction.Options.HTTPConfig.ClientKey.GetPayload())
+	assert.Equal(t, "{{Event}}", dbAction.Options.HTTPConfig.Body)
+	err = dbAction.Options.HTTPConfig.TryDecryptSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, "signing secret", dbAction.Options.HTTPConfig.SigningSecret.GetPayload())
+	_, err = dbAction.Options.HTTPConfig.GetHTTPClient()
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveEventAction(action, http.StatusOK)
+	assert.NoError(t, err)
 }
 
 func TestEventRuleValidation(t *testing.T) {
@@ -3416,6 +3686,49 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
 	u.Filters.DeniedIP = []string{}
+	for _, dest := range []string{"127.0.0.1", ":22", "host:0", "host:a", "10.0.0.0/33:22", "[a-:22"} {
+		u.Filters.PermittedOpens = []string{dest}
+		_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+		assert.NoError(t, err)
+		assert.Contains(t, string(resp), "permitted open", dest)
+	}
+	u.Filters.PermittedOpens = nil
+	u.Filters.FTPDeniedSiteCommands = []string{"CHMOD", "RMDIR"}
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid FTP SITE command")
+	u.Filters.FTPDeniedSiteCommands = nil
+	u.Filters.FTPAllowedSiteCommands = []string{"RMDIR"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid FTP SITE command")
+	u.Filters.FTPAllowedSiteCommands = []string{"CHMOD", "mkdir"}
+	u.Filters.FTPDeniedSiteCommands = []string{"MKDIR"}
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "cannot be both allowed and denied")
+	u.Filters.FTPAllowedSiteCommands = nil
+	u.Filters.FTPDeniedSiteCommands = nil
+	for _, ip := range []string{"invalid", "::1", "192.168.1"} {
+		u.Filters.FTPPassiveIP = ip
+		_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+		assert.NoError(t, err)
+		assert.Contains(t, string(resp), "invalid FTP passive IP", ip)
+	}
+	u.Filters.FTPPassiveIP = ""
+	for _, portRange := range []dataprovider.FTPPortRange{{Start: 50000}, {End: 50000}, {Start: 50100, End: 50000},
+		{Start: -1, End: 50000}, {Start: 50000, End: 70000}} {
+		u.Filters.FTPPassivePortRange = portRange
+		_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+		assert.NoError(t, err)
+		assert.Contains(t, string(resp), "invalid FTP passive port range", portRange)
+	}
+	u.Filters.FTPPassivePortRange = dataprovider.FTPPortRange{}
+	u.Filters.WebDAVDeadPropsMaxSize = -2
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid WebDAV dead properties max size")
+	u.Filters.WebDAVDeadPropsMaxSize = 0
 	u.Filters.DeniedLoginMethods = []string{"invalid"}
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
@@ -5653,6 +5966,90 @@ func TestCloseActiveConnection(t *testing.T) {
 	assert.Len(t, common.Connections.GetStats(""), 0)
 }
 
+func TestWebDAVLocks(t *testing.T) {
+	_, err := httpdtest.BreakWebDAVLock("opaquelocktoken:missing", http.StatusNotFound)
+	assert.NoError(t, err)
+	r := getTestRole()
+	role, _, err := httpdtest.AddRole(r, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Role = role.Name
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	lock1 := common.NewWebDAVLock(user.Username, "/dir", false, "", time.Minute)
+	err = common.AddWebDAVLock(lock1)
+	assert.NoError(t, err)
+	lock2 := common.NewWebDAVLock("other_user", "/dir", true, "", time.Minute)
+	err = common.AddWebDAVLock(lock2)
+	assert.NoError(t, err)
+
+	locks, _, err := httpdtest.GetWebDAVLocks("", http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 2)
+	locks, _, err = httpdtest.GetWebDAVLocks(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, lock1.Token, locks[0].Token)
+		assert.Equal(t, lock1.Root, locks[0].Root)
+		assert.False(t, locks[0].ZeroDepth)
+	}
+	// an admin with a role can only see and break the locks of the users with the same role
+	a := getTestAdmin()
+	a.Username = altAdminUsername
+	a.Password = altAdminPassword
+	a.Role = role.Name
+	a.Permissions = []string{dataprovider.PermAdminViewConnections, dataprovider.PermAdminCloseConnections}
+	admin, _, err := httpdtest.AddAdmin(a, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, webDAVLocksPath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &locks)
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, lock1.Token, locks[0].Token)
+	}
+	req, err = http.NewRequest(http.MethodDelete, path.Join(webDAVLocksPath, url.PathEscape(lock2.Token)), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(webDAVLocksPath, url.PathEscape(lock1.Token)), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	_, err = common.GetWebDAVLock(lock1.Token)
+	assert.Error(t, err)
+
+	_, err = httpdtest.BreakWebDAVLock(lock2.Token, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.BreakWebDAVLock(lock2.Token, http.StatusNotFound)
+	assert.NoError(t, err)
+	locks, _, err = httpdtest.GetWebDAVLocks("", http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+	// the locks are removed when the user is deleted
+	err = common.AddWebDAVLock(lock1)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	locks, _, err = httpdtest.GetWebDAVLocks("", http.StatusOK)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveRole(role, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestCloseConnectionAfterUserUpdateDelete(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -9372,94 +9769,297 @@ func TestWebUserTOTP(t *testing.T) {
 	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
-func TestWebAPIChangeUserProfileMock(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
-	assert.False(t, user.Filters.AllowAPIKeyAuth)
-	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+func TestHostKeysRotationMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	// invalid json
-	req, err := http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer([]byte("{")))
+
+	req, err := http.NewRequest(http.MethodGet, hostKeysPath, nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-
-	email := "userapi@example.com"
-	description := "user API description"
-	profileReq := make(map[string]any)
-	profileReq["allow_api_key_auth"] = true
-	profileReq["email"] = email
-	profileReq["description"] = description
-	profileReq["public_keys"] = []string{testPubKey, testPubKey1}
-	asJSON, err := json.Marshal(profileReq)
+	checkResponseCode(t, http.StatusOK, rr)
+	var hostKeys []sftpd.HostKeyInfo
+	err = json.Unmarshal(rr.Body.Bytes(), &hostKeys)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	require.Len(t, hostKeys, 1)
+	activeKey := hostKeys[0]
+	assert.Equal(t, sftpd.HostKeyStatusActive, activeKey.Status)
+
+	req, err = http.NewRequest(http.MethodPost, hostKeysPath, bytes.NewBuffer([]byte("{")))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-
-	profileReq = make(map[string]any)
-	req, err = http.NewRequest(http.MethodGet, userProfilePath, nil)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, hostKeysPath, bytes.NewBuffer([]byte(`{"type":"dsa"}`)))
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	err = json.Unmarshal(rr.Body.Bytes(), &profileReq)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodPost, hostKeysPath, bytes.NewBuffer([]byte(`{"type":"ed25519"}`)))
 	assert.NoError(t, err)
-	assert.Equal(t, email, profileReq["email"].(string))
-	assert.Equal(t, description, profileReq["description"].(string))
-	assert.True(t, profileReq["allow_api_key_auth"].(bool))
-	assert.Len(t, profileReq["public_keys"].([]any), 2)
-	// set an invalid email
-	profileReq = make(map[string]any)
-	profileReq["email"] = "notavalidemail"
-	asJSON, err = json.Marshal(profileReq)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	var newKey sftpd.HostKeyInfo
+	err = json.Unmarshal(rr.Body.Bytes(), &newKey)
 	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	assert.Equal(t, sftpd.HostKeyStatusAdvertised, newKey.Status)
+	assert.Equal(t, ssh.KeyAlgoED25519, newKey.Type)
+	assert.FileExists(t, newKey.Path)
+	defer os.Remove(newKey.Path)
+	assert.Len(t, sftpd.GetStatus().HostKeys, 1)
+
+	req, err = http.NewRequest(http.MethodPost, path.Join(hostKeysPath, "missing", "promote"), nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Validation error: email")
-	// set an invalid public key
-	profileReq = make(map[string]any)
-	profileReq["public_keys"] = []string{"not a public key"}
-	asJSON, err = json.Marshal(profileReq)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodPost, path.Join(hostKeysPath, newKey.ID, "promote"), nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "Validation error: could not parse key")
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Len(t, sftpd.GetStatus().HostKeys, 2)
 
-	user.Filters.WebClient = []string{sdk.WebClientAPIKeyAuthChangeDisabled, sdk.WebClientPubKeyChangeDisabled}
-	user.Email = email
-	user.Description = description
-	user.Filters.AllowAPIKeyAuth = true
-	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	req, err = http.NewRequest(http.MethodGet, hostKeysPath, nil)
 	assert.NoError(t, err)
-	token, err = getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	hostKeys = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &hostKeys)
 	assert.NoError(t, err)
+	if assert.Len(t, hostKeys, 2) {
+		assert.Equal(t, newKey.ID, hostKeys[1].ID)
+		assert.Equal(t, sftpd.HostKeyStatusActive, hostKeys[1].Status)
+	}
 
-	profileReq = make(map[string]any)
-	profileReq["allow_api_key_auth"] = false
-	profileReq["email"] = email
-	profileReq["description"] = description + "_mod"
-	profileReq["public_keys"] = []string{testPubKey}
-	asJSON, err = json.Marshal(profileReq)
-	assert.NoError(t, err)
-	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	req, err = http.NewRequest(http.MethodDelete, path.Join(hostKeysPath, newKey.ID), nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), "Profile updated")
-	// check that api key auth and public keys were not changed
-	profileReq = make(map[string]any)
-	req, err = http.NewRequest(http.MethodGet, userProfilePath, nil)
+	assert.Len(t, sftpd.GetStatus().HostKeys, 1)
+	// the last active key cannot be retired
+	req, err = http.NewRequest(http.MethodDelete, path.Join(hostKeysPath, activeKey.ID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, err = http.NewRequest(http.MethodDelete, path.Join(hostKeysPath, newKey.ID), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	assert.Equal(t, []sftpd.HostKeyInfo{activeKey}, sftpd.GetHostKeys())
+}
+
+func TestSSHCertificateMock(t *testing.T) {
+	u := getTestUser()
+	u.Filters.AllowedIP = []string{"192.168.1.0/24"}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	userToken, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, path.Join(userPath, user.Username, "ssh-certificate"),
+		bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	certReq := make(map[string]any)
+	certReq["public_key"] = testPubKey
+	certReq["validity"] = 30
+	asJSON, err := json.Marshal(certReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, user.Username, "ssh-certificate"),
+		bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	certResp := make(map[string]any)
+	err = json.Unmarshal(rr.Body.Bytes(), &certResp)
+	assert.NoError(t, err)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certResp["certificate"].(string))) //nolint:dogsled
+	assert.NoError(t, err)
+	cert, ok := pubKey.(*ssh.Certificate)
+	if assert.True(t, ok) {
+		assert.Equal(t, []string{user.Username}, cert.ValidPrincipals)
+		assert.Equal(t, "192.168.1.0/24", cert.CriticalOptions["source-address"])
+		assert.Equal(t, int64(cert.ValidBefore)*1000, int64(certResp["valid_before"].(float64)))
+		assert.InDelta(t, time.Now().Add(30*time.Minute).Unix(), int64(cert.ValidBefore), 5)
+		assert.Equal(t, sftpd.GetUserCAPublicKey(),
+			strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.SignatureKey))))
+	}
+	// validity out of range
+	certReq["validity"] = 100000
+	asJSON, err = json.Marshal(certReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, user.Username, "ssh-certificate"),
+		bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// missing user
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, "missing_user", "ssh-certificate"),
+		bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	// user API
+	req, err = http.NewRequest(http.MethodPost, userSSHCertificatePath, bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	certReq = make(map[string]any)
+	certReq["public_key"] = testPubKey
+	asJSON, err = json.Marshal(certReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSSHCertificatePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "ssh-rsa-cert-v01@openssh.com")
+	// a certificate cannot be signed
+	certReq["public_key"] = certResp["certificate"]
+	asJSON, err = json.Marshal(certReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSSHCertificatePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	// disabled users cannot get certificates
+	user.Status = 0
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	certReq["public_key"] = testPubKey
+	asJSON, err = json.Marshal(certReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, path.Join(userPath, user.Username, "ssh-certificate"),
+		bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "is disabled")
+	// the public key permission is required for the user API
+	user.Status = 1
+	user.Filters.WebClient = []string{sdk.WebClientPubKeyChangeDisabled}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	userToken, err = getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, userSSHCertificatePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, userToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestWebAPIChangeUserProfileMock(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	assert.False(t, user.Filters.AllowAPIKeyAuth)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	// invalid json
+	req, err := http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer([]byte("{")))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	email := "userapi@example.com"
+	description := "user API description"
+	profileReq := make(map[string]any)
+	profileReq["allow_api_key_auth"] = true
+	profileReq["email"] = email
+	profileReq["description"] = description
+	profileReq["public_keys"] = []string{testPubKey, testPubKey1}
+	asJSON, err := json.Marshal(profileReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	profileReq = make(map[string]any)
+	req, err = http.NewRequest(http.MethodGet, userProfilePath, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &profileReq)
+	assert.NoError(t, err)
+	assert.Equal(t, email, profileReq["email"].(string))
+	assert.Equal(t, description, profileReq["description"].(string))
+	assert.True(t, profileReq["allow_api_key_auth"].(bool))
+	assert.Len(t, profileReq["public_keys"].([]any), 2)
+	// set an invalid email
+	profileReq = make(map[string]any)
+	profileReq["email"] = "notavalidemail"
+	asJSON, err = json.Marshal(profileReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Validation error: email")
+	// set an invalid public key
+	profileReq = make(map[string]any)
+	profileReq["public_keys"] = []string{"not a public key"}
+	asJSON, err = json.Marshal(profileReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Validation error: could not parse key")
+
+	user.Filters.WebClient = []string{sdk.WebClientAPIKeyAuthChangeDisabled, sdk.WebClientPubKeyChangeDisabled}
+	user.Email = email
+	user.Description = description
+	user.Filters.AllowAPIKeyAuth = true
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	token, err = getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	profileReq = make(map[string]any)
+	profileReq["allow_api_key_auth"] = false
+	profileReq["email"] = email
+	profileReq["description"] = description + "_mod"
+	profileReq["public_keys"] = []string{testPubKey}
+	asJSON, err = json.Marshal(profileReq)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, userProfilePath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "Profile updated")
+	// check that api key auth and public keys were not changed
+	profileReq = make(map[string]any)
+	req, err = http.NewRequest(http.MethodGet, userProfilePath, nil)
 	assert.NoError(t, err)
 	setBearerForReq(req, token)
 	rr = executeRequest(req)
@@ -13214,6 +13814,181 @@ func TestUserAPIShares(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestInternalShares(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 1024 * 1024
+	owner, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	group, _, err := httpdtest.AddGroup(getTestGroup(), http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser()
+	u.Username = altAdminUsername
+	recipient, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	recipientToken, err := getJWTAPIUserTokenFromTestServer(recipient.Username, defaultPassword)
+	assert.NoError(t, err)
+	u = getTestUser()
+	u.Username = defaultUsername + "_member"
+	u.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypeMembership,
+		},
+	}
+	member, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	memberToken, err := getJWTAPIUserTokenFromTestServer(member.Username, defaultPassword)
+	assert.NoError(t, err)
+
+	sharedDir := filepath.Join(owner.GetHomeDir(), "shared")
+	err = os.MkdirAll(sharedDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(sharedDir, "file.txt"), []byte("shared content"), os.ModePerm)
+	assert.NoError(t, err)
+
+	share := dataprovider.Share{
+		Name:     "share1",
+		Scope:    dataprovider.ShareScopeRead,
+		Paths:    []string{"/shared"},
+		Users:    []string{recipient.Username},
+		Password: defaultPassword,
+	}
+	for _, s := range []dataprovider.Share{
+		share,
+		{Name: "s", Scope: dataprovider.ShareScopeRead, Paths: []string{"/"}, Users: []string{recipient.Username}},
+		{Name: "s", Scope: dataprovider.ShareScopeRead, Paths: []string{"/shared"}, Users: []string{owner.Username}},
+		{Name: "s", Scope: dataprovider.ShareScopeRead, Paths: []string{"/shared", "/dir"}, Groups: []string{group.Name}},
+	} {
+		asJSON, err := json.Marshal(s)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+		assert.NoError(t, err)
+		setBearerForReq(req, token)
+		rr := executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, rr)
+	}
+	share.Password = ""
+	asJSON, err := json.Marshal(share)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, userSharesPath, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	location := rr.Header().Get("Location")
+	objectID := rr.Header().Get("X-Object-ID")
+	assert.NotEmpty(t, objectID)
+	// internal shares are not available as public links
+	req, err = http.NewRequest(http.MethodGet, path.Join(sharesPath, objectID), nil)
+	assert.NoError(t, err)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	sharedWithMe := path.Join(dataprovider.SharedWithMeDir, owner.Username)
+	mountPath := path.Join(sharedWithMe, share.Name)
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(sharedWithMe), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, recipientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var contents []map[string]any
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	if assert.Len(t, contents, 1) {
+		assert.Equal(t, share.Name, contents[0]["name"])
+	}
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(mountPath), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, recipientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	contents = nil
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	if assert.Len(t, contents, 1) {
+		assert.Equal(t, "file.txt", contents[0]["name"])
+	}
+	req, err = http.NewRequest(http.MethodGet, userFilesPath+"?path="+url.QueryEscape(path.Join(mountPath, "file.txt")), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, recipientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "shared content", rr.Body.String())
+	// the read scope does not allow uploads
+	uploadPath := userUploadFilePath + "?path=" + url.QueryEscape(path.Join(mountPath, "upload.txt"))
+	req, err = http.NewRequest(http.MethodPost, uploadPath, bytes.NewBuffer([]byte("uploaded content")))
+	assert.NoError(t, err)
+	setBearerForReq(req, recipientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	// the share is not available for the other users
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(mountPath), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, memberToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	share.Scope = dataprovider.ShareScopeReadWrite
+	share.Users = []string{"missing_user"}
+	share.Groups = []string{group.Name}
+	asJSON, err = json.Marshal(share)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPut, location, bytes.NewBuffer(asJSON))
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(mountPath), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, recipientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, err = http.NewRequest(http.MethodPost, uploadPath, bytes.NewBuffer([]byte("uploaded content")))
+	assert.NoError(t, err)
+	setBearerForReq(req, memberToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	assert.FileExists(t, filepath.Join(sharedDir, "upload.txt"))
+	// the quota is charged to the owner
+	owner, _, err = httpdtest.GetUserByUsername(owner.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, owner.UsedQuotaFiles)
+	assert.Equal(t, int64(len("uploaded content")), owner.UsedQuotaSize)
+	member, _, err = httpdtest.GetUserByUsername(member.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, member.UsedQuotaFiles)
+	assert.Equal(t, int64(0), member.UsedQuotaSize)
+
+	req, err = http.NewRequest(http.MethodDelete, location, nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, err = http.NewRequest(http.MethodGet, userDirsPath+"?path="+url.QueryEscape(mountPath), nil)
+	assert.NoError(t, err)
+	setBearerForReq(req, memberToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveUser(owner, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(owner.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(recipient, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(recipient.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(member, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(member.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestUsersAPISharesNoPasswordDisabled(t *testing.T) {
 	u := getTestUser()
 	u.Filters.WebClient = []string{sdk.WebClientShareNoPasswordDisabled}
@@ -16032,6 +16807,133 @@ func TestWebUserShareNoPasswordDisabled(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebClientSSHCertificate(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+
+	csrfToken, err := getCSRFToken(httpBaseURL + webClientLoginPath)
+	assert.NoError(t, err)
+	token, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, webClientSSHCertPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+
+	form := make(url.Values)
+	form.Set("public_key", testPubKey)
+	form.Set("validity", "15")
+	// no csrf token
+	req, err = http.NewRequest(http.MethodPost, webClientSSHCertPath, bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "unable to verify form token")
+
+	form.Set(csrfFormToken, csrfToken)
+	req, _ = http.NewRequest(http.MethodPost, webClientSSHCertPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "Certificate issued")
+	assert.Contains(t, rr.Body.String(), "ssh-rsa-cert-v01@openssh.com")
+	// invalid validity
+	form.Set("validity", "a")
+	req, _ = http.NewRequest(http.MethodPost, webClientSSHCertPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid validity")
+	// invalid public key
+	form.Set("validity", "")
+	form.Set("public_key", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webClientSSHCertPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.RemoteAddr = defaultRemoteAddr
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "could not parse public key")
+	// now remove permissions
+	user.Filters.WebClient = []string{sdk.WebClientPubKeyChangeDisabled}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	token, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientSSHCertPath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPublicKeysInfo(t *testing.T) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPubKey)) //nolint:dogsled
+	require.NoError(t, err)
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	lastUse := time.Date(2022, 10, 1, 10, 15, 0, 0, time.UTC)
+	u := getTestUser()
+	u.PublicKeys = []string{`from="127.0.0.1",no-pty ` + testPubKey, testPubKey1}
+	u.Filters.PublicKeysLastUse = map[string]int64{
+		fingerprint:   util.GetTimeAsMsSinceEpoch(lastUse),
+		"SHA256:test": util.GetTimeAsMsSinceEpoch(lastUse),
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// the last use for missing keys is removed
+	assert.Len(t, user.Filters.PublicKeysLastUse, 1)
+	// the last use is preserved on updates
+	user.Filters.PublicKeysLastUse = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, util.GetTimeAsMsSinceEpoch(lastUse), user.Filters.PublicKeysLastUse[fingerprint])
+
+	webToken, err := getJWTWebTokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, path.Join(webUserPath, user.Username), nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), strings.ReplaceAll(fingerprint, "+", "&#43;"))
+	assert.Contains(t, rr.Body.String(), "last use: 2022-10-01T10:15:00Z")
+	assert.Contains(t, rr.Body.String(), "last use: never")
+
+	webClientToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, webClientProfilePath, nil)
+	assert.NoError(t, err)
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), strings.ReplaceAll(fingerprint, "+", "&#43;"))
+	assert.Contains(t, rr.Body.String(), "options: from=&#34;127.0.0.1&#34;,no-pty")
+	// invalid options
+	user.PublicKeys = []string{`expiry-time="invalid" ` + testPubKey}
+	_, resp, err := httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid options for key nr. 1")
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestWebUserProfile(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -17703,6 +18605,51 @@ func TestWebUserAddMock(t *testing.T) {
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
 	form.Set("external_auth_cache_time", "0")
+	// test invalid permitted opens
+	form.Set("permitted_opens", "host:0")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid port for permitted open")
+	form.Set("permitted_opens", "*.example.com:443, 10.0.0.0/8:*")
+	form.Set("webdav_dead_props_max_size", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid WebDAV dead properties max size")
+	form.Set("webdav_dead_props_max_size", "32768")
+	form.Set("security_keys_only", "1")
+	form.Set("require_user_presence", "1")
+	form.Set("require_user_verification", "1")
+	form.Add("ftp_denied_site_commands", "SYMLINK")
+	form.Add("ftp_denied_site_commands", "mkdir")
+	form.Add("ftp_allowed_site_commands", "HELP")
+	form.Add("ftp_allowed_site_commands", "md5")
+	form.Set("ftp_passive_ip", "::ffff:172.16.1.1")
+	form.Set("ftp_passive_port_start", "a")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid FTP passive port range start")
+	form.Set("ftp_passive_port_start", "50000")
+	form.Set("ftp_passive_port_end", "b")
+	b, contentType, _ = getMultipartFormData(form, "", "")
+	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
+	setJWTCookieForReq(req, webToken)
+	req.Header.Set("Content-Type", contentType)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid FTP passive port range end")
+	form.Set("ftp_passive_port_end", "50100")
 	form.Set(csrfFormToken, "invalid form token")
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, webUserPath, &b)
@@ -17756,6 +18703,15 @@ func TestWebUserAddMock(t *testing.T) {
 	assert.Equal(t, "/start/dir", newUser.Filters.StartDirectory)
 	assert.Equal(t, 0, newUser.Filters.FTPSecurity)
 	assert.Equal(t, 10, newUser.Filters.DefaultSharesExpiration)
+	assert.Equal(t, []string{"*.example.com:443", "10.0.0.0/8:*"}, newUser.Filters.PermittedOpens)
+	assert.True(t, newUser.Filters.SecurityKeysOnly)
+	assert.True(t, newUser.Filters.RequireUserPresence)
+	assert.True(t, newUser.Filters.RequireUserVerification)
+	assert.Equal(t, []string{"SYMLINK", "MKDIR"}, newUser.Filters.FTPDeniedSiteCommands)
+	assert.Equal(t, []string{"HELP", "MD5"}, newUser.Filters.FTPAllowedSiteCommands)
+	assert.Equal(t, "172.16.1.1", newUser.Filters.FTPPassiveIP)
+	assert.Equal(t, dataprovider.FTPPortRange{Start: 50000, End: 50100}, newUser.Filters.FTPPassivePortRange)
+	assert.Equal(t, int64(32768), newUser.Filters.WebDAVDeadPropsMaxSize)
 	assert.True(t, util.Contains(newUser.PublicKeys, testPubKey))
 	if val, ok := newUser.Permissions["/subdir"]; ok {
 		assert.True(t, util.Contains(val, dataprovider.PermListItems))
@@ -19782,6 +20738,9 @@ func TestWebEventAction(t *testing.T) {
 	form.Set("http_username", action.Options.HTTPConfig.Username)
 	form.Set("http_password", action.Options.HTTPConfig.Password.GetPayload())
 	form.Set("http_method", action.Options.HTTPConfig.Method)
+	form.Set("http_signing_secret", "signing secret")
+	form.Set("http_client_cert", httpsCert)
+	form.Set("http_client_key", httpsKey)
 	req, err = http.NewRequest(http.MethodPost, webAdminEventActionPath, bytes.NewBuffer([]byte(form.Encode())))
 	assert.NoError(t, err)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -19851,8 +20810,13 @@ func TestWebEventAction(t *testing.T) {
 	assert.NotEmpty(t, actionGet.Options.HTTPConfig.Password.GetPayload())
 	assert.Empty(t, actionGet.Options.HTTPConfig.Password.GetKey())
 	assert.Empty(t, actionGet.Options.HTTPConfig.Password.GetAdditionalData())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, actionGet.Options.HTTPConfig.SigningSecret.GetStatus())
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, actionGet.Options.HTTPConfig.ClientKey.GetStatus())
+	assert.Equal(t, strings.TrimSpace(httpsCert), actionGet.Options.HTTPConfig.ClientCert)
 	// update and check that the password is preserved and the multipart fields
 	form.Set("http_password", redactedSecret)
+	form.Set("http_client_key", redactedSecret)
+	form.Set("http_signing_secret", "")
 	form.Set("http_body", "")
 	form.Set("http_timeout", "0")
 	form.Del("http_header_key0")
@@ -19880,6 +20844,10 @@ func TestWebEventAction(t *testing.T) {
 	err = dbAction.Options.HTTPConfig.Password.Decrypt()
 	assert.NoError(t, err)
 	assert.Equal(t, defaultPassword, dbAction.Options.HTTPConfig.Password.GetPayload())
+	assert.True(t, dbAction.Options.HTTPConfig.SigningSecret.IsEmpty())
+	err = dbAction.Options.HTTPConfig.ClientKey.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, httpsKey, dbAction.Options.HTTPConfig.ClientKey.GetPayload())
 	assert.Empty(t, dbAction.Options.HTTPConfig.Body)
 	assert.Equal(t, 0, dbAction.Options.HTTPConfig.Timeout)
 	if assert.Len(t, dbAction.Options.HTTPConfig.Parts, 2) {
@@ -20111,6 +21079,127 @@ func TestWebEventAction(t *testing.T) {
 			}
 		}
 	}
+	// transfer to a remote SFTP server
+	form.Set("fs_action_type", fmt.Sprintf("%d", dataprovider.FilesystemActionTransfer))
+	form.Set("fs_transfer_source0", "/a.txt")
+	form.Set("fs_transfer_target0", "/b/a.txt")
+	form.Set("fs_transfer_retries", "a")
+	form.Set("fs_transfer_verify_checksum", "checked")
+	form.Set("fs_transfer_move_source_to", "/sent")
+	form.Set("fs_provider", strconv.Itoa(int(sdk.SFTPFilesystemProvider)))
+	form.Set("sftp_endpoint", "127.0.0.1:2022")
+	form.Set("sftp_username", "remote_user")
+	form.Set("sftp_password", "remote_pwd")
+	form.Set("sftp_buffer_size", "0")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid transfer retries")
+	form.Set("fs_transfer_retries", "2")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr)
+	actionGet, _, err = httpdtest.GetEventActionByName(action.Name, http.StatusOK)
+	assert.NoError(t, err)
+	transfer := actionGet.Options.FsConfig.Transfer
+	assert.Equal(t, dataprovider.FilesystemActionTransfer, actionGet.Options.FsConfig.Type)
+	if assert.Len(t, transfer.Paths, 1) {
+		assert.Equal(t, "/a.txt", transfer.Paths[0].Key)
+		assert.Equal(t, "/b/a.txt", transfer.Paths[0].Value)
+	}
+	assert.Equal(t, 2, transfer.Retries)
+	assert.True(t, transfer.VerifyChecksum)
+	assert.False(t, transfer.DeleteSource)
+	assert.Equal(t, "/sent", transfer.MoveSourceTo)
+	assert.Equal(t, sdk.SFTPFilesystemProvider, transfer.TargetFs.Provider)
+	assert.Equal(t, "127.0.0.1:2022", transfer.TargetFs.SFTPConfig.Endpoint)
+	assert.Equal(t, "remote_user", transfer.TargetFs.SFTPConfig.Username)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, transfer.TargetFs.SFTPConfig.Password.GetStatus())
+	assert.NotEmpty(t, transfer.TargetFs.SFTPConfig.Password.GetPayload())
+	assert.Empty(t, transfer.TargetFs.SFTPConfig.Password.GetKey())
+	// the redacted password must be preserved on update
+	action, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	payload := action.Options.FsConfig.Transfer.TargetFs.SFTPConfig.Password.GetPayload()
+	assert.NotEmpty(t, payload)
+	form.Set("sftp_password", redactedSecret)
+	form.Set("fs_transfer_retries", "3")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr)
+	action, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, action.Options.FsConfig.Transfer.Retries)
+	assert.Equal(t, payload, action.Options.FsConfig.Transfer.TargetFs.SFTPConfig.Password.GetPayload())
+	// message broker action
+	form.Set("type", fmt.Sprintf("%d", dataprovider.ActionTypeMessageBroker))
+	form.Set("broker_protocol", fmt.Sprintf("%d", dataprovider.BrokerProtocolMQTT))
+	form.Set("broker_endpoint", "ssl://127.0.0.1:8883")
+	form.Set("broker_username", "mqtt_user")
+	form.Set("broker_password", "mqtt_pwd")
+	form.Set("broker_topic", "sftpgo/{{Name}}")
+	form.Set("broker_exchange", "ignored")
+	form.Set("broker_body", "{{ObjectData}}")
+	form.Set("broker_skip_tls_verify", "checked")
+	form.Set("broker_timeout", "a")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "invalid message broker timeout")
+	form.Set("broker_timeout", "30")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr)
+	action, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ActionTypeMessageBroker, action.Type)
+	assert.Empty(t, action.Options.FsConfig.Transfer.Paths)
+	brokerConfig := action.Options.BrokerConfig
+	assert.Equal(t, dataprovider.BrokerProtocolMQTT, brokerConfig.Protocol)
+	assert.Equal(t, "ssl://127.0.0.1:8883", brokerConfig.Endpoint)
+	assert.Equal(t, "mqtt_user", brokerConfig.Username)
+	assert.Equal(t, "sftpgo/{{Name}}", brokerConfig.Topic)
+	assert.Empty(t, brokerConfig.Exchange)
+	assert.Equal(t, "{{ObjectData}}", brokerConfig.Body)
+	assert.Equal(t, 30, brokerConfig.Timeout)
+	assert.True(t, brokerConfig.SkipTLSVerify)
+	assert.Equal(t, sdkkms.SecretStatusSecretBox, brokerConfig.Password.GetStatus())
+	payload = brokerConfig.Password.GetPayload()
+	assert.NotEmpty(t, payload)
+	// the redacted password must be preserved on update
+	form.Set("broker_password", redactedSecret)
+	form.Set("broker_timeout", "40")
+	req, err = http.NewRequest(http.MethodPost, path.Join(webAdminEventActionPath, action.Name),
+		bytes.NewBuffer([]byte(form.Encode())))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusSeeOther, rr)
+	action, err = dataprovider.EventActionExists(action.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, 40, action.Options.BrokerConfig.Timeout)
+	assert.Equal(t, payload, action.Options.BrokerConfig.Password.GetPayload())
 
 	req, err = http.NewRequest(http.MethodDelete, path.Join(webAdminEventActionPath, action.Name), nil)
 	assert.NoError(t, err)
@@ -20515,11 +21604,25 @@ func TestAddWebGroup(t *testing.T) {
 			UploadBandwidth:   128,
 			DownloadBandwidth: 256,
 		},
+		SecurityKeysOnly:       true,
+		RequireUserPresence:    true,
+		FTPPassiveIP:           "192.168.1.10",
+		FTPPassivePortRange:    dataprovider.FTPPortRange{Start: 50000, End: 50100},
+		FTPAllowedSiteCommands: []string{"HELP", "MD5"},
+		FTPDeniedSiteCommands:  []string{"CHMOD"},
 	}
 	form := make(url.Values)
 	form.Set("name", group.Name)
 	form.Set("description", group.Description)
 	form.Set("home_dir", group.UserSettings.HomeDir)
+	form.Set("security_keys_only", "1")
+	form.Set("require_user_presence", "1")
+	form.Set("ftp_passive_ip", group.UserSettings.FTPPassiveIP)
+	form.Set("ftp_passive_port_start", strconv.Itoa(group.UserSettings.FTPPassivePortRange.Start))
+	form.Set("ftp_passive_port_end", strconv.Itoa(group.UserSettings.FTPPassivePortRange.End))
+	form.Add("ftp_allowed_site_commands", "HELP")
+	form.Add("ftp_allowed_site_commands", "md5")
+	form.Add("ftp_denied_site_commands", "chmod")
 	b, contentType, err := getMultipartFormData(form, "", "")
 	assert.NoError(t, err)
 	req, err := http.NewRequest(http.MethodPost, webGroupPath, &b)
@@ -220,6 +220,7 @@ type fsWrapper struct {
 	IsGroupPage     bool
 	IsHidden        bool
 	HasUsersBaseDir bool
+	HideDirPath     bool
 	DirPath         string
 }
 
@@ -231,6 +232,7 @@ type userPage struct {
 	ValidPerms         []string
 	ValidLoginMethods  []string
 	ValidProtocols     []string
+	ValidSiteCommands  []string
 	TwoFactorProtocols []string
 	WebClientOptions   []string
 	RootDirPerms       []string
@@ -313,6 +315,7 @@ type groupPage struct {
 	ValidProtocols     []string
 	TwoFactorProtocols []string
 	WebClientOptions   []string
+	ValidSiteCommands  []string
 	VirtualFolders     []vfs.BaseVirtualFolder
 	FsWrapper          fsWrapper
 }
@@ -326,13 +329,15 @@ type rolePage struct {
 
 type eventActionPage struct {
 	basePage
-	Action         dataprovider.BaseEventAction
-	ActionTypes    []dataprovider.EnumMapping
-	FsActions      []dataprovider.EnumMapping
-	HTTPMethods    []string
-	RedactedSecret string
-	Error          string
-	Mode           genericPageMode
+	Action          dataprovider.BaseEventAction
+	ActionTypes     []dataprovider.EnumMapping
+	FsActions       []dataprovider.EnumMapping
+	BrokerProtocols []dataprovider.EnumMapping
+	HTTPMethods     []string
+	RedactedSecret  string
+	Error           string
+	Mode            genericPageMode
+	FsWrapper       fsWrapper
 }
 
 type eventRulePage struct {
@@ -445,6 +450,7 @@ func loadAdminTemplates(templatesPath string) {
 	eventActionPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateFsConfig),
 		filepath.Join(templatesPath, templateAdminDir, templateEventAction),
 	}
 	statusPaths := []string{
@@ -528,7 +534,7 @@ func loadAdminTemplates(templatesPath string) {
 	eventRulesTmpl := util.LoadTemplate(nil, eventRulesPaths...)
 	eventRuleTmpl := util.LoadTemplate(fsBaseTpl, eventRulePaths...)
 	eventActionsTmpl := util.LoadTemplate(nil, eventActionsPaths...)
-	eventActionTmpl := util.LoadTemplate(nil, eventActionPaths...)
+	eventActionTmpl := util.LoadTemplate(fsBaseTpl, eventActionPaths...)
 	statusTmpl := util.LoadTemplate(nil, statusPaths...)
 	loginTmpl := util.LoadTemplate(nil, loginPaths...)
 	profileTmpl := util.LoadTemplate(nil, profilePaths...)
@@ -896,6 +902,7 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 		ValidPerms:         dataprovider.ValidPerms,
 		ValidLoginMethods:  dataprovider.ValidLoginMethods,
 		ValidProtocols:     dataprovider.ValidProtocols,
+		ValidSiteCommands:  dataprovider.ValidFTPSiteCommands,
 		TwoFactorProtocols: dataprovider.MFAProtocols,
 		WebClientOptions:   sdk.WebClientOptions,
 		RootDirPerms:       user.GetPermissionsForPath("/"),
@@ -967,6 +974,7 @@ func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, gr
 		ValidProtocols:     dataprovider.ValidProtocols,
 		TwoFactorProtocols: dataprovider.MFAProtocols,
 		WebClientOptions:   sdk.WebClientOptions,
+		ValidSiteCommands:  dataprovider.ValidFTPSiteCommands,
 		VirtualFolders:     folders,
 		FsWrapper: fsWrapper{
 			Filesystem:      group.UserSettings.FsConfig,
@@ -998,16 +1006,25 @@ func (s *httpdServer) renderEventActionPage(w http.ResponseWriter, r *http.Reque
 	if action.Options.CmdConfig.Timeout == 0 {
 		action.Options.CmdConfig.Timeout = 20
 	}
+	if action.Options.BrokerConfig.Timeout == 0 {
+		action.Options.BrokerConfig.Timeout = 20
+	}
+	action.Options.FsConfig.Transfer.TargetFs.RedactedSecret = redactedSecret
 
 	data := eventActionPage{
-		basePage:       s.getBasePageData(title, currentURL, r),
-		Action:         action,
-		ActionTypes:    dataprovider.EventActionTypes,
-		FsActions:      dataprovider.FsActionTypes,
-		HTTPMethods:    dataprovider.SupportedHTTPActionMethods,
-		RedactedSecret: redactedSecret,
-		Error:          error,
-		Mode:           mode,
+		basePage:        s.getBasePageData(title, currentURL, r),
+		Action:          action,
+		ActionTypes:     dataprovider.EventActionTypes,
+		FsActions:       dataprovider.FsActionTypes,
+		BrokerProtocols: dataprovider.BrokerProtocols,
+		HTTPMethods:     dataprovider.SupportedHTTPActionMethods,
+		RedactedSecret:  redactedSecret,
+		Error:           error,
+		Mode:            mode,
+		FsWrapper: fsWrapper{
+			Filesystem:  action.Options.FsConfig.Transfer.TargetFs,
+			HideDirPath: true,
+		},
 	}
 	renderAdminTemplate(w, templateEventAction, data)
 }
@@ -1899,6 +1916,17 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 	if err != nil {
 		return user, err
 	}
+	passivePortRange, err := getFTPPassivePortRangeFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	webDAVDeadPropsMaxSize := int64(0)
+	if val := strings.TrimSpace(r.Form.Get("webdav_dead_props_max_size")); val != "" {
+		webDAVDeadPropsMaxSize, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return user, fmt.Errorf("invalid WebDAV dead properties max size: %w", err)
+		}
+	}
 	user = dataprovider.User{
 		BaseUser: sdk.BaseUser{
 			Username:             r.Form.Get("username"),
@@ -1924,7 +1952,16 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 			Role:                 r.Form.Get("role"),
 		},
 		Filters: dataprovider.UserFilters{
-			BaseUserFilters: filters,
+			BaseUserFilters:         filters,
+			PermittedOpens:          getSliceFromDelimitedValues(r.Form.Get("permitted_opens"), ","),
+			SecurityKeysOnly:        r.Form.Get("security_keys_only") != "",
+			RequireUserPresence:     r.Form.Get("require_user_presence") != "",
+			RequireUserVerification: r.Form.Get("require_user_verification") != "",
+			FTPAllowedSiteCommands:  r.Form["ftp_allowed_site_commands"],
+			FTPDeniedSiteCommands:   r.Form["ftp_denied_site_commands"],
+			FTPPassiveIP:            strings.TrimSpace(r.Form.Get("ftp_passive_ip")),
+			FTPPassivePortRange:     passivePortRange,
+			WebDAVDeadPropsMaxSize:  webDAVDeadPropsMaxSize,
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 		FsConfig:       fsConfig,
@@ -1961,6 +1998,10 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 	if err != nil {
 		return group, err
 	}
+	passivePortRange, err := getFTPPassivePortRangeFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		return group, err
@@ -1988,13 +2029,39 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 				TotalDataTransfer:    dataTransferTotal,
 				Filters:              filters,
 			},
-			FsConfig: fsConfig,
+			FsConfig:                fsConfig,
+			SecurityKeysOnly:        r.Form.Get("security_keys_only") != "",
+			RequireUserPresence:     r.Form.Get("require_user_presence") != "",
+			RequireUserVerification: r.Form.Get("require_user_verification") != "",
+			FTPPassiveIP:            strings.TrimSpace(r.Form.Get("ftp_passive_ip")),
+			FTPPassivePortRange:     passivePortRange,
+			FTPAllowedSiteCommands:  r.Form["ftp_allowed_site_commands"],
+			FTPDeniedSiteCommands:   r.Form["ftp_denied_site_commands"],
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 	}
 	return group, nil
 }
 
+func getFTPPassivePortRangeFromPostFields(r *http.Request) (dataprovider.FTPPortRange, error) {
+	var portRange dataprovider.FTPPortRange
+	var err error
+
+	if val := strings.TrimSpace(r.Form.Get("ftp_passive_port_start")); val != "" {
+		portRange.Start, err = strconv.Atoi(val)
+		if err != nil {
+			return portRange, fmt.Errorf("invalid FTP passive port range start: %w", err)
+		}
+	}
+	if val := strings.TrimSpace(r.Form.Get("ftp_passive_port_end")); val != "" {
+		portRange.End, err = strconv.Atoi(val)
+		if err != nil {
+			return portRange, fmt.Errorf("invalid FTP passive port range end: %w", err)
+		}
+	}
+	return portRange, nil
+}
+
 func getKeyValsFromPostFields(r *http.Request, key, val string) []dataprovider.KeyValue {
 	var res []dataprovider.KeyValue
 	for k := range r.Form {
@@ -2094,6 +2161,28 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 	if err != nil {
 		return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid fs action type: %w", err)
 	}
+	var extractMaxSize int64
+	if r.Form.Get("fs_extract_max_size") != "" {
+		extractMaxSize, err = util.ParseBytes(r.Form.Get("fs_extract_max_size"))
+		if err != nil {
+			return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid extract max size: %w", err)
+		}
+	}
+	var extractMaxEntries int
+	if r.Form.Get("fs_extract_max_entries") != "" {
+		extractMaxEntries, err = strconv.Atoi(r.Form.Get("fs_extract_max_entries"))
+		if err != nil {
+			return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid extract max entries: %w", err)
+		}
+	}
+	transfer, err := getFsTransferFromPostFields(r, fsActionType)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, err
+	}
+	brokerConfig, err := getBrokerConfigFromPostFields(r)
+	if err != nil {
+		return dataprovider.BaseEventActionOptions{}, err
+	}
 	var emailAttachments []string
 	if r.Form.Get("email_attachments") != "" {
 		emailAttachments = strings.Split(strings.ReplaceAll(r.Form.Get("email_attachments"), " ", ""), ",")
@@ -2114,6 +2203,9 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 			QueryParameters: getKeyValsFromPostFields(r, "http_query_key", "http_query_val"),
 			Body:            r.Form.Get("http_body"),
 			Parts:           getHTTPPartsFromPostFields(r),
+			SigningSecret:   getSecretFromFormField(r, "http_signing_secret"),
+			ClientCert:      r.Form.Get("http_client_cert"),
+			ClientKey:       getSecretFromFormField(r, "http_client_key"),
 		},
 		CmdConfig: dataprovider.EventActionCommandConfig{
 			Cmd:     r.Form.Get("cmd_path"),
@@ -2140,11 +2232,75 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 				Name:  r.Form.Get("fs_compress_name"),
 				Paths: strings.Split(strings.ReplaceAll(r.Form.Get("fs_compress_paths"), " ", ""), ","),
 			},
+			Extract: dataprovider.EventActionFsExtract{
+				Name:         r.Form.Get("fs_extract_name"),
+				Target:       r.Form.Get("fs_extract_target"),
+				MaxSize:      extractMaxSize,
+				MaxEntries:   extractMaxEntries,
+				DeleteSource: r.Form.Get("fs_extract_delete_source") != "",
+			},
+			Transfer: transfer,
 		},
+		BrokerConfig: brokerConfig,
 	}
 	return options, nil
 }
 
+func getBrokerConfigFromPostFields(r *http.Request) (dataprovider.EventActionBrokerConfig, error) {
+	var protocol, timeout int
+	var err error
+	if r.Form.Get("broker_protocol") != "" {
+		protocol, err = strconv.Atoi(r.Form.Get("broker_protocol"))
+		if err != nil {
+			return dataprovider.EventActionBrokerConfig{}, fmt.Errorf("invalid message broker protocol: %w", err)
+		}
+	}
+	if r.Form.Get("broker_timeout") != "" {
+		timeout, err = strconv.Atoi(r.Form.Get("broker_timeout"))
+		if err != nil {
+			return dataprovider.EventActionBrokerConfig{}, fmt.Errorf("invalid message broker timeout: %w", err)
+		}
+	}
+	return dataprovider.EventActionBrokerConfig{
+		Protocol:      protocol,
+		Endpoint:      r.Form.Get("broker_endpoint"),
+		Username:      r.Form.Get("broker_username"),
+		Password:      getSecretFromFormField(r, "broker_password"),
+		Exchange:      r.Form.Get("broker_exchange"),
+		Topic:         r.Form.Get("broker_topic"),
+		Body:          r.Form.Get("broker_body"),
+		Timeout:       timeout,
+		SkipTLSVerify: r.Form.Get("broker_skip_tls_verify") != "",
+	}, nil
+}
+
+func getFsTransferFromPostFields(r *http.Request, fsActionType int) (dataprovider.EventActionFsTransfer, error) {
+	if fsActionType != dataprovider.FilesystemActionTransfer {
+		return dataprovider.EventActionFsTransfer{}, nil
+	}
+	var retries int
+	var err error
+	if r.Form.Get("fs_transfer_retries") != "" {
+		retries, err = strconv.Atoi(r.Form.Get("fs_transfer_retries"))
+		if err != nil {
+			return dataprovider.EventActionFsTransfer{}, fmt.Errorf("invalid transfer retries: %w", err)
+		}
+	}
+	targetFs, err := getFsConfigFromPostFields(r)
+	if err != nil {
+		return dataprovider.EventActionFsTransfer{}, err
+	}
+	return dataprovider.EventActionFsTransfer{
+		Paths:          getKeyValsFromPostFields(r, "fs_transfer_source", "fs_transfer_target"),
+		TargetUser:     r.Form.Get("fs_transfer_target_user"),
+		TargetFs:       targetFs,
+		Retries:        retries,
+		VerifyChecksum: r.Form.Get("fs_transfer_verify_checksum") != "",
+		DeleteSource:   r.Form.Get("fs_transfer_delete_source") != "",
+		MoveSourceTo:   r.Form.Get("fs_transfer_move_source_to"),
+	}, nil
+}
+
 func getEventActionFromPostFields(r *http.Request) (dataprovider.BaseEventAction, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -2949,6 +3105,7 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.PublicKeysLastUse = user.Filters.PublicKeysLastUse
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
 		updatedUser.Password = user.Password
@@ -3399,6 +3556,18 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+		if updatedAction.Options.HTTPConfig.SigningSecret.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.HTTPConfig.SigningSecret = action.Options.HTTPConfig.SigningSecret
+		}
+		if updatedAction.Options.HTTPConfig.ClientKey.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.HTTPConfig.ClientKey = action.Options.HTTPConfig.ClientKey
+		}
+	case dataprovider.ActionTypeFilesystem:
+		updateFsTransferSecrets(&updatedAction.Options.FsConfig.Transfer.TargetFs, action.Options.FsConfig.Transfer.TargetFs)
+	case dataprovider.ActionTypeMessageBroker:
+		if updatedAction.Options.BrokerConfig.Password.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.BrokerConfig.Password = action.Options.BrokerConfig.Password
+		}
 	}
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, ipAddr)
 	if err != nil {
@@ -38,6 +38,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
+	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 	"github.com/drakkan/sftpgo/v2/internal/version"
@@ -53,6 +54,7 @@ const (
 	templateClientMessage           = "message.html"
 	templateClientProfile           = "profile.html"
 	templateClientChangePwd         = "changepassword.html"
+	templateClientSSHCert           = "sshcert.html"
 	templateClientTwoFactor         = "twofactor.html"
 	templateClientTwoFactorRecovery = "twofactor-recovery.html"
 	templateClientMFA               = "mfa.html"
@@ -66,6 +68,7 @@ const (
 	pageClientSharesTitle           = "Shares"
 	pageClientProfileTitle          = "My Profile"
 	pageClientChangePwdTitle        = "Change password"
+	pageClientSSHCertTitle          = "SSH certificate"
 	pageClient2FATitle              = "Two-factor auth"
 	pageClientEditFileTitle         = "Edit file"
 	pageClientForgotPwdTitle        = "SFTPGo WebClient - Forgot password"
@@ -102,6 +105,7 @@ type baseClientPage struct {
 	ShareURL     string
 	ProfileURL   string
 	ChangePwdURL string
+	SSHCertURL   string
 	StaticURL    string
 	LogoutURL    string
 	MFAURL       string
@@ -109,6 +113,8 @@ type baseClientPage struct {
 	FilesTitle   string
 	SharesTitle  string
 	ProfileTitle string
+	SSHCertTitle string
+	HasSSHCert   bool
 	Version      string
 	CSRFToken    string
 	LoggedUser   *dataprovider.User
@@ -181,7 +187,7 @@ type clientMessagePage struct {
 
 type clientProfilePage struct {
 	baseClientPage
-	PublicKeys      []string
+	PublicKeys      []dataprovider.PublicKeyInfo
 	CanSubmit       bool
 	AllowAPIKeyAuth bool
 	Email           string
@@ -189,6 +195,15 @@ type clientProfilePage struct {
 	Error           string
 }
 
+type clientSSHCertPage struct {
+	baseClientPage
+	PublicKey   string
+	Validity    int
+	Certificate string
+	ValidBefore string
+	Error       string
+}
+
 type changeClientPasswordPage struct {
 	baseClientPage
 	Error string
@@ -260,6 +275,11 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientChangePwd),
 	}
+	sshCertPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientSSHCert),
+	}
 	loginPath := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateClientDir, templateClientBaseLogin),
@@ -311,6 +331,7 @@ func loadClientTemplates(templatesPath string) {
 	filesTmpl := util.LoadTemplate(nil, filesPaths...)
 	profileTmpl := util.LoadTemplate(nil, profilePaths...)
 	changePwdTmpl := util.LoadTemplate(nil, changePwdPaths...)
+	sshCertTmpl := util.LoadTemplate(nil, sshCertPaths...)
 	loginTmpl := util.LoadTemplate(nil, loginPath...)
 	messageTmpl := util.LoadTemplate(nil, messagePath...)
 	mfaTmpl := util.LoadTemplate(nil, mfaPath...)
@@ -328,6 +349,7 @@ func loadClientTemplates(templatesPath string) {
 	clientTemplates[templateClientFiles] = filesTmpl
 	clientTemplates[templateClientProfile] = profileTmpl
 	clientTemplates[templateClientChangePwd] = changePwdTmpl
+	clientTemplates[templateClientSSHCert] = sshCertTmpl
 	clientTemplates[templateClientLogin] = loginTmpl
 	clientTemplates[templateClientMessage] = messageTmpl
 	clientTemplates[templateClientMFA] = mfaTmpl
@@ -358,6 +380,7 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		ShareURL:     webClientSharePath,
 		ProfileURL:   webClientProfilePath,
 		ChangePwdURL: webChangeClientPwdPath,
+		SSHCertURL:   webClientSSHCertPath,
 		StaticURL:    webStaticFilesPath,
 		LogoutURL:    webClientLogoutPath,
 		MFAURL:       webClientMFAPath,
@@ -365,6 +388,8 @@ func (s *httpdServer) getBaseClientPageData(title, currentURL string, r *http.Re
 		FilesTitle:   pageClientFilesTitle,
 		SharesTitle:  pageClientSharesTitle,
 		ProfileTitle: pageClientProfileTitle,
+		SSHCertTitle: pageClientSSHCertTitle,
+		HasSSHCert:   sftpd.IsUserCAEnabled(),
 		Version:      fmt.Sprintf("%v-%v", v.Version, v.CommitHash),
 		CSRFToken:    csrfToken,
 		LoggedUser:   getUserFromToken(r),
@@ -595,7 +620,7 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 		s.renderClientInternalServerErrorPage(w, r, err)
 		return
 	}
-	data.PublicKeys = user.PublicKeys
+	data.PublicKeys = user.GetPublicKeysInfo()
 	data.AllowAPIKeyAuth = user.Filters.AllowAPIKeyAuth
 	data.Email = user.Email
 	data.Description = user.Description
@@ -603,6 +628,11 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 	renderClientTemplate(w, templateClientProfile, data)
 }
 
+func (s *httpdServer) renderClientSSHCertPage(w http.ResponseWriter, r *http.Request, data clientSSHCertPage) {
+	data.baseClientPage = s.getBaseClientPageData(pageClientSSHCertTitle, webClientSSHCertPath, r)
+	renderClientTemplate(w, templateClientSSHCert, data)
+}
+
 func (s *httpdServer) renderClientChangePasswordPage(w http.ResponseWriter, r *http.Request, error string) {
 	data := changeClientPasswordPage{
 		baseClientPage: s.getBaseClientPageData(pageClientChangePwdTitle, webChangeClientPwdPath, r),
@@ -1097,7 +1127,7 @@ func (s *httpdServer) handleClientAddSharePost(w http.ResponseWriter, r *http.Re
 	share.ShareID = util.GenerateUniqueID()
 	share.LastUseAt = 0
 	share.Username = claims.Username
-	if share.Password == "" {
+	if share.Password == "" && !share.IsInternal() {
 		if util.Contains(claims.Permissions, sdk.WebClientShareNoPasswordDisabled) {
 			s.renderClientForbiddenPage(w, r, "You are not authorized to share files/folders without a password")
 			return
@@ -1142,7 +1172,7 @@ func (s *httpdServer) handleClientUpdateSharePost(w http.ResponseWriter, r *http
 	if updatedShare.Password == redactedSecret {
 		updatedShare.Password = share.Password
 	}
-	if updatedShare.Password == "" {
+	if updatedShare.Password == "" && !updatedShare.IsInternal() {
 		if util.Contains(claims.Permissions, sdk.WebClientShareNoPasswordDisabled) {
 			s.renderClientForbiddenPage(w, r, "You are not authorized to share files/folders without a password")
 			return
@@ -1150,6 +1180,7 @@ func (s *httpdServer) handleClientUpdateSharePost(w http.ResponseWriter, r *http
 	}
 	err = dataprovider.UpdateShare(updatedShare, claims.Username, ipAddr)
 	if err == nil {
+		disconnectShareRecipients(&share, updatedShare)
 		http.Redirect(w, r, webClientSharesPath, http.StatusSeeOther)
 	} else {
 		s.renderAddUpdateSharePage(w, r, updatedShare, err.Error(), false)
@@ -1246,6 +1277,59 @@ func (s *httpdServer) handleWebClientProfilePost(w http.ResponseWriter, r *http.
 		"Your profile has been successfully updated")
 }
 
+func (s *httpdServer) handleWebClientSSHCert(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	s.renderClientSSHCertPage(w, r, clientSSHCertPage{})
+}
+
+func (s *httpdServer) handleWebClientSSHCertPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseForm()
+	if err != nil {
+		s.renderClientSSHCertPage(w, r, clientSSHCertPage{Error: err.Error()})
+		return
+	}
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken), ipAddr); err != nil {
+		s.renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderClientForbiddenPage(w, r, "Invalid token claims")
+		return
+	}
+	data := clientSSHCertPage{
+		PublicKey: strings.TrimSpace(r.Form.Get("public_key")),
+	}
+	if val := strings.TrimSpace(r.Form.Get("validity")); val != "" {
+		data.Validity, err = strconv.Atoi(val)
+		if err != nil {
+			data.Error = fmt.Sprintf("invalid validity: %v", err)
+			s.renderClientSSHCertPage(w, r, data)
+			return
+		}
+	}
+	user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+	if err != nil {
+		data.Error = err.Error()
+		s.renderClientSSHCertPage(w, r, data)
+		return
+	}
+	cert, err := signUserSSHKey(&user, sshCertificateRequest{
+		PublicKey: data.PublicKey,
+		Validity:  data.Validity,
+	}, dataprovider.ActionExecutorSelf, ipAddr)
+	if err != nil {
+		data.Error = err.Error()
+		s.renderClientSSHCertPage(w, r, data)
+		return
+	}
+	data.Certificate = cert.Certificate
+	data.ValidBefore = util.GetTimeFromMsecSinceEpoch(cert.ValidBefore).UTC().Format(time.RFC3339)
+	s.renderClientSSHCertPage(w, r, data)
+}
+
 func (s *httpdServer) handleWebClientMFA(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	s.renderClientMFAPage(w, r)
@@ -1271,6 +1355,8 @@ func getShareFromPostFields(r *http.Request) (*dataprovider.Share, error) {
 	share.Paths = r.Form["paths"]
 	share.Password = r.Form.Get("password")
 	share.AllowFrom = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
+	share.Users = getSliceFromDelimitedValues(r.Form.Get("users"), ",")
+	share.Groups = getSliceFromDelimitedValues(r.Form.Get("groups"), ",")
 	scope, err := strconv.Atoi(r.Form.Get("scope"))
 	if err != nil {
 		return share, err
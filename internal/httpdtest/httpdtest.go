@@ -44,6 +44,7 @@ import (
 const (
 	tokenPath             = "/api/v2/token"
 	activeConnectionsPath = "/api/v2/connections"
+	webDAVLocksPath       = "/api/v2/webdavlocks"
 	quotasBasePath        = "/api/v2/quotas"
 	quotaScanPath         = "/api/v2/quotas/users/scans"
 	quotaScanVFolderPath  = "/api/v2/quotas/folders/scans"
@@ -1075,6 +1076,42 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// GetWebDAVLocks returns the active WebDAV locks, optionally filtered by username
+func GetWebDAVLocks(username string, expectedStatusCode int) ([]common.WebDAVLock, []byte, error) {
+	var locks []common.WebDAVLock
+	var body []byte
+	url, err := addUsernameQueryParam(buildURLRelativeToBase(webDAVLocksPath), username)
+	if err != nil {
+		return locks, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return locks, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &locks)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return locks, body, err
+}
+
+// BreakWebDAVLock removes the WebDAV lock identified by token
+func BreakWebDAVLock(token string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(webDAVLocksPath, url.PathEscape(token)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	body, _ = getResponseBody(resp)
+	return body, err
+}
+
 // AddFolder adds a new folder and checks the received HTTP Status code against expectedStatusCode
 func AddFolder(folder vfs.BaseVirtualFolder, expectedStatusCode int) (vfs.BaseVirtualFolder, []byte, error) {
 	var newFolder vfs.BaseVirtualFolder
@@ -1462,6 +1499,9 @@ func checkEventAction(expected, actual dataprovider.BaseEventAction) error {
 	if err := compareEventActionFsConfigFields(expected.Options.FsConfig, actual.Options.FsConfig); err != nil {
 		return err
 	}
+	if err := compareEventActionBrokerConfigFields(expected.Options.BrokerConfig, actual.Options.BrokerConfig); err != nil {
+		return err
+	}
 	return compareEventActionHTTPConfigFields(expected.Options.HTTPConfig, actual.Options.HTTPConfig)
 }
 
@@ -1670,6 +1710,27 @@ func checkGroup(expected, actual dataprovider.Group) error {
 	if err := compareUserFilters(expected.UserSettings.Filters, actual.UserSettings.Filters); err != nil {
 		return err
 	}
+	if expected.UserSettings.SecurityKeysOnly != actual.UserSettings.SecurityKeysOnly {
+		return errors.New("security keys only mismatch")
+	}
+	if expected.UserSettings.RequireUserPresence != actual.UserSettings.RequireUserPresence {
+		return errors.New("require user presence mismatch")
+	}
+	if expected.UserSettings.RequireUserVerification != actual.UserSettings.RequireUserVerification {
+		return errors.New("require user verification mismatch")
+	}
+	if expected.UserSettings.FTPPassiveIP != actual.UserSettings.FTPPassiveIP {
+		return errors.New("FTP passive IP mismatch")
+	}
+	if expected.UserSettings.FTPPassivePortRange != actual.UserSettings.FTPPassivePortRange {
+		return errors.New("FTP passive port range mismatch")
+	}
+	if err := checkFTPSiteCommands(expected.UserSettings.FTPAllowedSiteCommands, actual.UserSettings.FTPAllowedSiteCommands); err != nil {
+		return fmt.Errorf("FTP allowed SITE commands mismatch: %w", err)
+	}
+	if err := checkFTPSiteCommands(expected.UserSettings.FTPDeniedSiteCommands, actual.UserSettings.FTPDeniedSiteCommands); err != nil {
+		return fmt.Errorf("FTP denied SITE commands mismatch: %w", err)
+	}
 	return compareFsConfig(&expected.UserSettings.FsConfig, &actual.UserSettings.FsConfig)
 }
 
@@ -1836,6 +1897,33 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if err := compareUserFilters(expected.Filters.BaseUserFilters, actual.Filters.BaseUserFilters); err != nil {
 		return err
 	}
+	if !checkFilterMatch(expected.Filters.PermittedOpens, actual.Filters.PermittedOpens) {
+		return errors.New("permitted opens mismatch")
+	}
+	if expected.Filters.SecurityKeysOnly != actual.Filters.SecurityKeysOnly {
+		return errors.New("security keys only mismatch")
+	}
+	if expected.Filters.RequireUserPresence != actual.Filters.RequireUserPresence {
+		return errors.New("require user presence mismatch")
+	}
+	if expected.Filters.RequireUserVerification != actual.Filters.RequireUserVerification {
+		return errors.New("require user verification mismatch")
+	}
+	if err := checkFTPSiteCommands(expected.Filters.FTPAllowedSiteCommands, actual.Filters.FTPAllowedSiteCommands); err != nil {
+		return fmt.Errorf("FTP allowed SITE commands mismatch: %w", err)
+	}
+	if err := checkFTPSiteCommands(expected.Filters.FTPDeniedSiteCommands, actual.Filters.FTPDeniedSiteCommands); err != nil {
+		return fmt.Errorf("FTP denied SITE commands mismatch: %w", err)
+	}
+	if expected.Filters.FTPPassiveIP != actual.Filters.FTPPassiveIP {
+		return errors.New("FTP passive IP mismatch")
+	}
+	if expected.Filters.FTPPassivePortRange != actual.Filters.FTPPassivePortRange {
+		return errors.New("FTP passive port range mismatch")
+	}
+	if expected.Filters.WebDAVDeadPropsMaxSize != actual.Filters.WebDAVDeadPropsMaxSize {
+		return errors.New("WebDAV dead properties max size mismatch")
+	}
 	if err := compareFsConfig(&expected.FsConfig, &actual.FsConfig); err != nil {
 		return err
 	}
@@ -2431,12 +2519,52 @@ func compareEventActionHTTPConfigFields(expected, actual dataprovider.EventActio
 	if expected.Body != actual.Body {
 		return errors.New("http body mismatch")
 	}
+	if err := checkEncryptedSecret(expected.SigningSecret, actual.SigningSecret); err != nil {
+		return err
+	}
+	if strings.TrimSpace(expected.ClientCert) != actual.ClientCert {
+		return errors.New("http client certificate mismatch")
+	}
+	if err := checkEncryptedSecret(expected.ClientKey, actual.ClientKey); err != nil {
+		return err
+	}
 	if len(expected.Parts) != len(actual.Parts) {
 		return errors.New("http parts mismatch")
 	}
 	return compareHTTPparts(expected.Parts, actual.Parts)
 }
 
+func compareEventActionBrokerConfigFields(expected, actual dataprovider.EventActionBrokerConfig) error {
+	if expected.Protocol != actual.Protocol {
+		return errors.New("broker protocol mismatch")
+	}
+	if expected.Endpoint != actual.Endpoint {
+		return errors.New("broker endpoint mismatch")
+	}
+	if expected.Username != actual.Username {
+		return errors.New("broker username mismatch")
+	}
+	if err := checkEncryptedSecret(expected.Password, actual.Password); err != nil {
+		return err
+	}
+	if expected.Exchange != actual.Exchange {
+		return errors.New("broker exchange mismatch")
+	}
+	if expected.Topic != actual.Topic {
+		return errors.New("broker topic mismatch")
+	}
+	if expected.Body != actual.Body {
+		return errors.New("broker body mismatch")
+	}
+	if expected.Timeout != actual.Timeout {
+		return errors.New("broker timeout mismatch")
+	}
+	if expected.SkipTLSVerify != actual.SkipTLSVerify {
+		return errors.New("broker skip TLS verify mismatch")
+	}
+	return nil
+}
+
 func compareEventActionEmailConfigFields(expected, actual dataprovider.EventActionEmailConfig) error {
 	if len(expected.Recipients) != len(actual.Recipients) {
 		return errors.New("email recipients mismatch")
@@ -2478,6 +2606,49 @@ func compareEventActionFsCompressFields(expected, actual dataprovider.EventActio
 	return nil
 }
 
+func compareEventActionFsExtractFields(expected, actual dataprovider.EventActionFsExtract) error {
+	if expected.Name != actual.Name {
+		return errors.New("fs extract name mismatch")
+	}
+	if expected.Target != actual.Target {
+		return errors.New("fs extract target mismatch")
+	}
+	if expected.MaxSize != actual.MaxSize {
+		return errors.New("fs extract max size mismatch")
+	}
+	if expected.MaxEntries != actual.MaxEntries {
+		return errors.New("fs extract max entries mismatch")
+	}
+	if expected.DeleteSource != actual.DeleteSource {
+		return errors.New("fs extract delete source mismatch")
+	}
+	return nil
+}
+
+func compareEventActionFsTransferFields(expected, actual dataprovider.EventActionFsTransfer) error {
+	if err := compareKeyValues(expected.Paths, actual.Paths); err != nil {
+		return errors.New("fs transfer paths mismatch")
+	}
+	if expected.TargetUser != actual.TargetUser {
+		return errors.New("fs transfer target user mismatch")
+	}
+	if expected.Retries != actual.Retries {
+		return errors.New("fs transfer retries mismatch")
+	}
+	if expected.VerifyChecksum != actual.VerifyChecksum {
+		return errors.New("fs transfer verify checksum mismatch")
+	}
+	if expected.DeleteSource != actual.DeleteSource {
+		return errors.New("fs transfer delete source mismatch")
+	}
+	if expected.MoveSourceTo != actual.MoveSourceTo {
+		return errors.New("fs transfer move source to mismatch")
+	}
+	expected.TargetFs.SetEmptySecretsIfNil()
+	actual.TargetFs.SetEmptySecretsIfNil()
+	return compareFsConfig(&expected.TargetFs, &actual.TargetFs)
+}
+
 func compareEventActionFsConfigFields(expected, actual dataprovider.EventActionFilesystemConfig) error {
 	if expected.Type != actual.Type {
 		return errors.New("fs type mismatch")
@@ -2509,7 +2680,13 @@ func compareEventActionFsConfigFields(expected, actual dataprovider.EventActionF
 			return errors.New("fs exist content mismatch")
 		}
 	}
-	return compareEventActionFsCompressFields(expected.Compress, actual.Compress)
+	if err := compareEventActionFsCompressFields(expected.Compress, actual.Compress); err != nil {
+		return err
+	}
+	if err := compareEventActionFsExtractFields(expected.Extract, actual.Extract); err != nil {
+		return err
+	}
+	return compareEventActionFsTransferFields(expected.Transfer, actual.Transfer)
 }
 
 func compareEventActionCmdConfigFields(expected, actual dataprovider.EventActionCommandConfig) error {
@@ -2695,3 +2872,28 @@ func addDisconnectQueryParam(rawurl, disconnect string) (*url.URL, error) {
 	url.RawQuery = q.Encode()
 	return url, err
 }
+
+func addUsernameQueryParam(rawurl, username string) (*url.URL, error) {
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Query()
+	if len(username) > 0 {
+		q.Add("username", username)
+	}
+	url.RawQuery = q.Encode()
+	return url, err
+}
+
+func checkFTPSiteCommands(expected, actual []string) error {
+	if len(expected) != len(actual) {
+		return errors.New("length mismatch")
+	}
+	for _, command := range expected {
+		if !util.Contains(actual, strings.ToUpper(command)) {
+			return fmt.Errorf("command %q not found", command)
+		}
+	}
+	return nil
+}
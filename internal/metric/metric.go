@@ -102,6 +102,13 @@ var (
 		Help: "The total number of SSH command errors",
 	})
 
+	// totalFTPPassivePortExhausted is the metric that reports the total number of FTP passive
+	// connections refused because no port was available within the configured range
+	totalFTPPassivePortExhausted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftp_passive_port_exhausted_total",
+		Help: "The total number of FTP passive connections refused because no port was available",
+	})
+
 	// totalLoginAttempts is the metric that reports the total number of login attempts
 	totalLoginAttempts = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_login_attempts_total",
@@ -899,6 +906,12 @@ func SSHCommandCompleted(err error) {
 	}
 }
 
+// FTPPassivePortExhausted increments the metric for FTP passive connections refused
+// because no port was available within the configured range
+func FTPPassivePortExhausted() {
+	totalFTPPassivePortExhausted.Inc()
+}
+
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(err error) {
 	if err == nil {
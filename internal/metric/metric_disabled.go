@@ -55,6 +55,10 @@ func HTTPFsTransferCompleted(_ int64, _ int, _ error) {}
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(_ error) {}
 
+// FTPPassivePortExhausted increments the metric for FTP passive connections refused
+// because no port was available within the configured range
+func FTPPassivePortExhausted() {}
+
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(_ error) {}
 
@@ -120,20 +120,21 @@ func (s *Service) Start(disableAWSInstallationCode bool) error {
 }
 
 func (s *Service) initializeServices(disableAWSInstallationCode bool) error {
-	providerConf := config.GetProviderConf()
-	err := common.Initialize(config.GetCommonConfig(), providerConf.GetShared())
-	if err != nil {
-		logger.Error(logSender, "", "%v", err)
-		logger.ErrorToConsole("%v", err)
-		return err
-	}
+	// the KMS must be initialized before loading the common configuration, it includes secrets
 	kmsConfig := config.GetKMSConfig()
-	err = kmsConfig.Initialize()
+	err := kmsConfig.Initialize()
 	if err != nil {
 		logger.Error(logSender, "", "unable to initialize KMS: %v", err)
 		logger.ErrorToConsole("unable to initialize KMS: %v", err)
 		return err
 	}
+	providerConf := config.GetProviderConf()
+	err = common.Initialize(config.GetCommonConfig(), providerConf.GetShared())
+	if err != nil {
+		logger.Error(logSender, "", "%v", err)
+		logger.ErrorToConsole("%v", err)
+		return err
+	}
 	mfaConfig := config.GetMFAConfig()
 	err = mfaConfig.Initialize()
 	if err != nil {
@@ -0,0 +1,244 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	userCAKeyAdditionalData = "sftpgo_user_ca"
+	// certificates are valid starting some time in the past to allow for clock skew
+	userCertClockSkew = 5 * time.Minute
+)
+
+var (
+	userCAManager = userCertificateAuthority{}
+	// extensions added to the issued certificates, they are the same as the ssh-keygen defaults
+	userCertExtensions = []string{"permit-X11-forwarding", "permit-agent-forwarding", "permit-port-forwarding",
+		"permit-pty", "permit-user-rc"}
+)
+
+// UserCA defines the configuration for the built-in user certificate authority.
+// If enabled, SFTPGo can sign the users' public keys issuing short-lived OpenSSH
+// certificates and the CA public key is automatically trusted
+type UserCA struct {
+	// Set to true to enable the built-in certificate authority
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Path to the CA private key. The path can be absolute or relative to the configuration directory.
+	// The key is stored encrypted using the configured KMS, if the file does not exist a new Ed25519
+	// key is generated
+	KeyPath string `json:"key_path" mapstructure:"key_path"`
+	// Validity, in minutes, for the issued certificates if the client does not request a specific one
+	DefaultValidity int `json:"default_validity" mapstructure:"default_validity"`
+	// Maximum validity, in minutes, that clients can request
+	MaxValidity int `json:"max_validity" mapstructure:"max_validity"`
+}
+
+func (c *UserCA) validate() error {
+	if c.DefaultValidity <= 0 {
+		return fmt.Errorf("invalid default validity for the user CA: %d", c.DefaultValidity)
+	}
+	if c.MaxValidity < c.DefaultValidity {
+		return fmt.Errorf("invalid max validity for the user CA: %d, it must be greater than the default one: %d",
+			c.MaxValidity, c.DefaultValidity)
+	}
+	if !util.IsFileInputValid(c.KeyPath) {
+		return fmt.Errorf("invalid key path for the user CA: %q", c.KeyPath)
+	}
+	return nil
+}
+
+type userCertificateAuthority struct {
+	mu              sync.RWMutex
+	signer          ssh.Signer
+	defaultValidity int
+	maxValidity     int
+}
+
+func (a *userCertificateAuthority) load(c UserCA, configDir string) error {
+	if !c.Enabled {
+		a.set(nil, 0, 0)
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	keyPath := c.KeyPath
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	signer, err := a.loadSigner(keyPath)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to load user CA key %q: %v", keyPath, err)
+		return fmt.Errorf("unable to load user CA key %q: %w", keyPath, err)
+	}
+	logger.Info(logSender, "", "user CA loaded, key %q, fingerprint: %s, default validity: %d minutes, "+
+		"max validity: %d minutes", keyPath, ssh.FingerprintSHA256(signer.PublicKey()), c.DefaultValidity, c.MaxValidity)
+	a.set(signer, c.DefaultValidity, c.MaxValidity)
+	return nil
+}
+
+func (a *userCertificateAuthority) set(signer ssh.Signer, defaultValidity, maxValidity int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.signer = signer
+	a.defaultValidity = defaultValidity
+	a.maxValidity = maxValidity
+}
+
+func (a *userCertificateAuthority) loadSigner(keyPath string) (ssh.Signer, error) {
+	if _, err := os.Stat(keyPath); errors.Is(err, os.ErrNotExist) {
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info(logSender, "", "generating new user CA key %q", keyPath)
+		if err := writeEncryptedPrivateKey(keyPath, privKey, userCAKeyAdditionalData); err != nil {
+			return nil, err
+		}
+	}
+	return loadPrivateKey(keyPath)
+}
+
+func (a *userCertificateAuthority) getPublicKey() ssh.PublicKey {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.signer == nil {
+		return nil
+	}
+	return a.signer.PublicKey()
+}
+
+func (a *userCertificateAuthority) signUserKey(user *dataprovider.User, publicKey string, validity int) (*ssh.Certificate, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.signer == nil {
+		return nil, util.NewMethodDisabledError("the user certificate authority is disabled")
+	}
+	if validity == 0 {
+		validity = a.defaultValidity
+	}
+	if validity < 0 || validity > a.maxValidity {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid validity %d, allowed range: 1-%d minutes",
+			validity, a.maxValidity))
+	}
+	if !user.IsLoginMethodAllowed(dataprovider.SSHLoginMethodPublicKey, common.ProtocolSSH, nil) {
+		return nil, util.NewValidationError(fmt.Sprintf("public key authentication is not allowed for user %q",
+			user.Username))
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("could not parse public key: %v", err))
+	}
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		return nil, util.NewValidationError("certificates cannot be signed, please provide a public key")
+	}
+	if user.Filters.SecurityKeysOnly && !dataprovider.IsSecurityKey(pubKey) {
+		return nil, util.NewValidationError(fmt.Sprintf("user %q can only login using security keys, key type %q "+
+			"not allowed", user.Username, pubKey.Type()))
+	}
+	now := time.Now()
+	validBefore := now.Add(time.Duration(validity) * time.Minute)
+	if user.ExpirationDate > 0 {
+		expiration := util.GetTimeFromMsecSinceEpoch(user.ExpirationDate)
+		if !expiration.After(now) {
+			return nil, util.NewValidationError(fmt.Sprintf("user %q is expired", user.Username))
+		}
+		if expiration.Before(validBefore) {
+			validBefore = expiration
+		}
+	}
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("sftpgo:%s", user.Username),
+		ValidPrincipals: []string{user.Username},
+		ValidAfter:      uint64(now.Add(-userCertClockSkew).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: map[string]string{},
+			Extensions:      map[string]string{},
+		},
+	}
+	if len(user.Filters.AllowedIP) > 0 {
+		cert.Permissions.CriticalOptions[sourceAddressCriticalOption] = strings.Join(user.Filters.AllowedIP, ",")
+	}
+	for _, ext := range userCertExtensions {
+		cert.Permissions.Extensions[ext] = ""
+	}
+	if err := cert.SignCert(rand.Reader, a.signer); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// IsUserCAEnabled returns true if the built-in user certificate authority is enabled
+func IsUserCAEnabled() bool {
+	return userCAManager.getPublicKey() != nil
+}
+
+// GetUserCAPublicKey returns the public key of the built-in user certificate authority
+// in authorized keys format or an empty string if the CA is disabled
+func GetUserCAPublicKey() string {
+	pubKey := userCAManager.getPublicKey()
+	if pubKey == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+}
+
+// SignUserPublicKey signs the given public key, in authorized keys format, using the built-in
+// user certificate authority. The validity is expressed in minutes, 0 means the default one.
+// It returns the issued certificate in authorized keys format and its expiration
+func SignUserPublicKey(user *dataprovider.User, publicKey string, validity int, executor, ipAddress string,
+) (string, time.Time, error) {
+	cert, err := userCAManager.signUserKey(user, publicKey, validity)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to issue a certificate for user %q, executor %q, ip %q: %v",
+			user.Username, executor, ipAddress, err)
+		return "", time.Time{}, err
+	}
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	logger.Info(logSender, "", "certificate issued for user %q, executor %q, ip %q, serial: %d, key: %s %s, "+
+		"principals: %v, critical options: %v, valid until: %s", user.Username, executor, ipAddress, cert.Serial,
+		cert.Key.Type(), ssh.FingerprintSHA256(cert.Key), cert.ValidPrincipals, cert.CriticalOptions,
+		validBefore.UTC().Format(time.RFC3339))
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))), validBefore, nil
+}
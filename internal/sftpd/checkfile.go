@@ -0,0 +1,247 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	checkFileExtension       = "check-file"
+	checkFileHandleExtension = "check-file-handle"
+	checkFileNameExtension   = "check-file-name"
+	// the hashes are returned in a single packet, we must fit inside the
+	// maximum packet length accepted by the clients
+	maxCheckFileReplyLength = 200 * 1024
+	minCheckFileBlockSize   = 256
+)
+
+var (
+	checkFileAlgorithms = []string{"md5", "sha1", "sha224", "sha256", "sha384", "sha512", "crc32"}
+)
+
+func newCheckFileHasher(algo string) hash.Hash {
+	switch algo {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha224":
+		return sha256.New224()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	case "crc32":
+		return crc32.NewIEEE()
+	default:
+		return nil
+	}
+}
+
+func (c *extensionsChannel) handleCheckFile(id uint32, extension string, data []byte) {
+	req, err := parseCheckFileRequest(data)
+	if err != nil {
+		c.writeStatus(id, extension, err)
+		return
+	}
+	ctx := c.ctx
+	if extension == checkFileHandleExtension {
+		c.mu.Lock()
+		h, ok := c.handles[req.target]
+		c.mu.Unlock()
+		if !ok {
+			c.writeStatus(id, extension, sftp.ErrSSHFxNoSuchFile)
+			return
+		}
+		req.target = h.virtualPath
+		// hashing stops if the handle is closed
+		ctx = h.ctx
+	}
+	// hashing can read the whole file, it must not block the other requests
+	c.runAsync(func() {
+		algo, hashes, err := c.connection.checkFile(ctx, c.connection.cleanRequestPath(req.target), req)
+		if err != nil {
+			c.writeStatus(id, extension, err)
+			return
+		}
+		payload := appendSFTPString(nil, checkFileExtension)
+		payload = appendSFTPString(payload, algo)
+		payload = append(payload, hashes...)
+		c.writeExtendedReply(id, extension, payload)
+	})
+}
+
+type checkFileRequest struct {
+	target     string
+	algorithms []string
+	offset     int64
+	length     int64
+	blockSize  int64
+}
+
+func parseCheckFileRequest(data []byte) (checkFileRequest, error) {
+	var req checkFileRequest
+
+	target, data, ok := unmarshalSFTPString(data)
+	if !ok {
+		return req, errExtendedBadRequest
+	}
+	algorithms, data, ok := unmarshalSFTPString(data)
+	if !ok || len(data) < 20 {
+		return req, errExtendedBadRequest
+	}
+	offset := binary.BigEndian.Uint64(data)
+	length := binary.BigEndian.Uint64(data[8:])
+	blockSize := binary.BigEndian.Uint32(data[16:])
+	if offset > uint64(math.MaxInt64) || length > uint64(math.MaxInt64) {
+		return req, errExtendedBadRequest
+	}
+	if blockSize != 0 && blockSize < minCheckFileBlockSize {
+		return req, errExtendedBadRequest
+	}
+	req.target = string(target)
+	req.algorithms = strings.Split(string(algorithms), ",")
+	req.offset = int64(offset)
+	req.length = int64(length)
+	req.blockSize = int64(blockSize)
+	return req, nil
+}
+
+func (c *Connection) cleanRequestPath(p string) string {
+	if c.User.Filters.StartDirectory == "" {
+		return util.CleanPath(p)
+	}
+	return util.CleanPathWithBase(c.User.Filters.StartDirectory, p)
+}
+
+// checkFile computes the hashes requested using the check-file extension.
+// It returns the algorithm used and the hashes concatenated, hashing is
+// aborted if the specified context is canceled
+func (c *Connection) checkFile(ctx context.Context, virtualPath string, req checkFileRequest) (string, []byte, error) {
+	c.UpdateLastActivity()
+
+	if c.folderPrefix != "" {
+		if getPrefixHierarchy(c.folderPrefix, virtualPath) != pathContainsPrefix {
+			return "", nil, sftp.ErrSSHFxPermissionDenied
+		}
+		virtualPath, _ = (&prefixMiddleware{prefix: c.folderPrefix}).removeFolderPrefix(virtualPath)
+	}
+	var algo string
+	for _, a := range req.algorithms {
+		if util.Contains(checkFileAlgorithms, a) {
+			algo = a
+			break
+		}
+	}
+	if algo == "" {
+		return "", nil, sftp.ErrSSHFxOpUnsupported
+	}
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return "", nil, sftp.ErrSSHFxPermissionDenied
+	}
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "check-file for %q is not allowed", virtualPath)
+		return "", nil, c.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return "", nil, c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, sftp.ErrSSHFxFailure
+	}
+	if req.offset > info.Size() {
+		return "", nil, sftp.ErrSSHFxFailure
+	}
+	length := info.Size() - req.offset
+	if req.length > 0 && req.length < length {
+		length = req.length
+	}
+	blockSize := req.blockSize
+	if blockSize == 0 || blockSize > length {
+		blockSize = length
+	}
+	numBlocks := int64(1)
+	if blockSize > 0 {
+		numBlocks = (length + blockSize - 1) / blockSize
+	}
+	hasher := newCheckFileHasher(algo)
+	if numBlocks*int64(hasher.Size()) > maxCheckFileReplyLength {
+		return "", nil, sftp.ErrSSHFxFailure
+	}
+	// read using a download transfer, this way the permissions, the transfer
+	// quota and the bandwidth limits are applied as for any other download
+	t, err := c.getDownloadTransfer(virtualPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer t.Close() //nolint:errcheck
+
+	reader := &contextReader{ctx: ctx, r: io.NewSectionReader(t, req.offset, length)}
+	hashes := make([]byte, 0, numBlocks*int64(hasher.Size()))
+	for i := int64(0); i < numBlocks; i++ {
+		size := blockSize
+		if remaining := length - i*blockSize; remaining < size {
+			size = remaining
+		}
+		hasher.Reset()
+		if _, err := io.CopyN(hasher, reader, size); err != nil {
+			if ctx.Err() != nil {
+				c.Log(logger.LevelDebug, "check-file for %q canceled", virtualPath)
+				return "", nil, sftp.ErrSSHFxFailure
+			}
+			return "", nil, c.GetFsError(fs, err)
+		}
+		hashes = hasher.Sum(hashes)
+	}
+	return algo, hashes, nil
+}
+
+// contextReader returns the context error, if any, before each read
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,689 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// SFTP packet types we need to inspect to serve the extensions not supported
+// by pkg/sftp. The pkg/sftp request server rejects unknown extended requests,
+// so they are handled here before the packets reach it
+const (
+	sftpPacketVersion       = 2
+	sftpPacketOpen          = 3
+	sftpPacketClose         = 4
+	sftpPacketStatus        = 101
+	sftpPacketHandle        = 102
+	sftpPacketName          = 104
+	sftpPacketExtended      = 200
+	sftpPacketExtendedReply = 201
+	// read, write, append, create and truncate open flags, at least one of
+	// them is required to open a file
+	sftpOpenModeFlags = 0x1f
+
+	sftpStatusOK               = 0
+	sftpStatusEOF              = 1
+	sftpStatusNoSuchFile       = 2
+	sftpStatusPermissionDenied = 3
+	sftpStatusFailure          = 4
+	sftpStatusBadMessage       = 5
+	sftpStatusOpUnsupported    = 8
+
+	copyDataExtension        = "copy-data"
+	limitsExtension          = "limits@openssh.com"
+	expandPathExtension      = "expand-path@openssh.com"
+	usersGroupsByIDExtension = "users-groups-by-id@openssh.com"
+	fsyncExtension           = "fsync@openssh.com"
+	// same limit used in pkg/sftp
+	maxSFTPPacketLength = 256 * 1024
+	// pkg/sftp never returns more than 32KB for a read request
+	maxSFTPReadLength = 32 * 1024
+	// maximum number of extended requests, such as copy-data, served
+	// concurrently for each SFTP session
+	maxConcurrentExtendedRequests = 4
+)
+
+var (
+	channelExtensions = []string{copyDataExtension, limitsExtension, expandPathExtension, usersGroupsByIDExtension,
+		fsyncExtension}
+	errExtendedBadRequest = errors.New("malformed extended request")
+	// same error returned by pkg/sftp
+	errSFTPPacketTooLong = errors.New("packet too long")
+)
+
+// openHandle defines an handle returned by the SFTP server for an open request
+type openHandle struct {
+	virtualPath string
+	transfer    *transfer
+	// canceled when the handle is closed, it stops the asynchronous
+	// requests using the handle that don't need to complete, e.g. check-file
+	ctx    context.Context
+	cancel context.CancelFunc
+	// extended requests in progress using this handle, a close request
+	// waits for them before being forwarded to the SFTP server
+	refs sync.WaitGroup
+}
+
+// extensionsChannel wraps the SFTP channel, it serves the extended requests
+// not supported by pkg/sftp and forwards any other packet to the SFTP server
+type extensionsChannel struct {
+	io.ReadWriteCloser
+	connection *Connection
+	readBuf    []byte
+	writeMu    sync.Mutex
+	writeBuf   []byte
+	mu         sync.Mutex
+	// pending open requests, request id -> virtual path
+	pendingOpens map[uint32]string
+	// open file handles
+	handles map[string]*openHandle
+	// limits the concurrent extended requests
+	workers chan struct{}
+	wg      sync.WaitGroup
+	// canceled when the channel is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newExtensionsChannel(channel io.ReadWriteCloser, connection *Connection) *extensionsChannel {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &extensionsChannel{
+		ReadWriteCloser: channel,
+		connection:      connection,
+		pendingOpens:    make(map[uint32]string),
+		handles:         make(map[string]*openHandle),
+		workers:         make(chan struct{}, maxConcurrentExtendedRequests),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// Close implements io.Closer, the asynchronous requests in progress are canceled
+func (c *extensionsChannel) Close() error {
+	c.cancel()
+	return c.ReadWriteCloser.Close()
+}
+
+// Read implements io.Reader, the handled extended requests are served
+// directly and never returned to the SFTP server
+func (c *extensionsChannel) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		packet, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		if c.handleClientPacket(packet) {
+			continue
+		}
+		c.readBuf = packet
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write implements io.Writer. The SFTP server can write a packet using
+// multiple calls, we buffer them and we write complete packets, this way
+// the extended replies are never interleaved with other packets
+func (c *extensionsChannel) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeBuf = append(c.writeBuf, p...)
+	for len(c.writeBuf) >= 4 {
+		length := int(binary.BigEndian.Uint32(c.writeBuf))
+		if len(c.writeBuf) < 4+length {
+			break
+		}
+		packet := c.handleServerPacket(c.writeBuf[:4+length])
+		c.writeBuf = c.writeBuf[4+length:]
+		if _, err := c.ReadWriteCloser.Write(packet); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.writeBuf) == 0 {
+		c.writeBuf = nil
+	}
+	return len(p), nil
+}
+
+func (c *extensionsChannel) writePacket(packetType byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.ReadWriteCloser.Write(marshalSFTPPacket(packetType, payload))
+	return err
+}
+
+func (c *extensionsChannel) writeStatus(id uint32, extension string, err error) {
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to handle %q request: %v", extension, err)
+	}
+	if err := c.writePacket(sftpPacketStatus, marshalSFTPStatus(id, err)); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to send %q response: %v", extension, err)
+	}
+}
+
+func (c *extensionsChannel) writeExtendedReply(id uint32, extension string, payload []byte) {
+	if err := c.writePacket(sftpPacketExtendedReply, append(binary.BigEndian.AppendUint32(nil, id), payload...)); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to send %q response: %v", extension, err)
+	}
+}
+
+func (c *extensionsChannel) readPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.ReadWriteCloser, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > maxSFTPPacketLength {
+		return nil, errSFTPPacketTooLong
+	}
+	if length == 0 {
+		return nil, errors.New("invalid empty SFTP packet")
+	}
+	packet := make([]byte, 4+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(c.ReadWriteCloser, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// runAsync executes the specified function in a separate goroutine, so
+// the slow extended requests don't block the other SFTP requests
+func (c *extensionsChannel) runAsync(fn func()) {
+	c.workers <- struct{}{}
+	c.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-c.workers
+			c.wg.Done()
+		}()
+
+		fn()
+	}()
+}
+
+// handleClientPacket returns true if the packet was handled and must not be
+// forwarded to the SFTP server
+func (c *extensionsChannel) handleClientPacket(packet []byte) bool {
+	data := packet[5:]
+	switch packet[4] {
+	case sftpPacketOpen:
+		id, data, ok := unmarshalSFTPUint32(data)
+		if !ok {
+			return false
+		}
+		name, data, ok := unmarshalSFTPString(data)
+		if !ok {
+			return false
+		}
+		pflags, data, ok := unmarshalSFTPUint32(data)
+		if !ok {
+			return false
+		}
+		// the SFTP server rejects the packets without the attributes flags
+		// and the requests without a valid open mode, the open handlers
+		// are not called for them
+		if _, _, ok := unmarshalSFTPUint32(data); !ok || pflags&sftpOpenModeFlags == 0 {
+			return false
+		}
+		c.mu.Lock()
+		c.pendingOpens[id] = string(name)
+		c.mu.Unlock()
+		c.connection.addOpenRequestID(id)
+	case sftpPacketClose:
+		_, data, ok := unmarshalSFTPUint32(data)
+		if !ok {
+			return false
+		}
+		handle, _, ok := unmarshalSFTPString(data)
+		if !ok {
+			return false
+		}
+		c.mu.Lock()
+		h := c.handles[string(handle)]
+		delete(c.handles, string(handle))
+		c.mu.Unlock()
+		if h != nil {
+			// the transfer will be closed, wait for the extended requests using it
+			h.cancel()
+			h.refs.Wait()
+		}
+	case sftpPacketExtended:
+		id, data, ok := unmarshalSFTPUint32(data)
+		if !ok {
+			return false
+		}
+		extension, data, ok := unmarshalSFTPString(data)
+		if !ok {
+			return false
+		}
+		return c.handleExtendedRequest(id, string(extension), data)
+	}
+	return false
+}
+
+func (c *extensionsChannel) handleExtendedRequest(id uint32, extension string, data []byte) bool {
+	switch extension {
+	case checkFileNameExtension, checkFileHandleExtension:
+		c.handleCheckFile(id, extension, data)
+	case copyDataExtension:
+		c.handleCopyData(id, data)
+	case limitsExtension:
+		c.handleLimits(id)
+	case expandPathExtension:
+		c.handleExpandPath(id, data)
+	case usersGroupsByIDExtension:
+		c.handleUsersGroupsByID(id, data)
+	case fsyncExtension:
+		c.handleFsync(id, data)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleServerPacket returns the packet to send to the client, the version
+// packet is modified to advertise the extensions served here
+func (c *extensionsChannel) handleServerPacket(packet []byte) []byte {
+	if len(packet) < 5 {
+		return packet
+	}
+	data := packet[5:]
+	switch packet[4] {
+	case sftpPacketVersion:
+		payload := make([]byte, 0, len(data)+256)
+		payload = append(payload, data...)
+		payload = appendSFTPString(payload, checkFileExtension)
+		payload = appendSFTPString(payload, strings.Join(checkFileAlgorithms, ","))
+		for _, extension := range channelExtensions {
+			payload = appendSFTPString(payload, extension)
+			payload = appendSFTPString(payload, "1")
+		}
+		return marshalSFTPPacket(sftpPacketVersion, payload)
+	case sftpPacketHandle, sftpPacketStatus:
+		id, data, ok := unmarshalSFTPUint32(data)
+		if !ok {
+			return packet
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		name, ok := c.pendingOpens[id]
+		if !ok {
+			return packet
+		}
+		delete(c.pendingOpens, id)
+		t := c.connection.popOpenedTransfer(id)
+		if packet[4] == sftpPacketHandle {
+			if handle, _, ok := unmarshalSFTPString(data); ok {
+				ctx, cancel := context.WithCancel(c.ctx)
+				c.handles[string(handle)] = &openHandle{
+					virtualPath: name,
+					transfer:    t,
+					ctx:         ctx,
+					cancel:      cancel,
+				}
+			}
+		}
+	}
+	return packet
+}
+
+// acquireHandle returns the open handle with the specified name, the handle
+// cannot be closed until releaseHandle is called
+func (c *extensionsChannel) acquireHandle(handle string) (*openHandle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.handles[handle]
+	if !ok || h.transfer == nil {
+		return nil, sftp.ErrSSHFxNoSuchFile
+	}
+	h.refs.Add(1)
+	return h, nil
+}
+
+func (c *extensionsChannel) releaseHandle(h *openHandle) {
+	h.refs.Done()
+}
+
+// handleCopyData serves the copy-data extension, data is copied between two
+// open handles using the same transfers used for read and write requests,
+// so quota, bandwidth limits and transfer accounting are applied
+func (c *extensionsChannel) handleCopyData(id uint32, data []byte) {
+	readHandle, data, ok := unmarshalSFTPString(data)
+	if !ok || len(data) < 16 {
+		c.writeStatus(id, copyDataExtension, errExtendedBadRequest)
+		return
+	}
+	readOffset := int64(binary.BigEndian.Uint64(data))
+	readLength := int64(binary.BigEndian.Uint64(data[8:]))
+	writeHandle, data, ok := unmarshalSFTPString(data[16:])
+	if !ok || len(data) < 8 || readOffset < 0 || readLength < 0 {
+		c.writeStatus(id, copyDataExtension, errExtendedBadRequest)
+		return
+	}
+	writeOffset := int64(binary.BigEndian.Uint64(data))
+	if writeOffset < 0 {
+		c.writeStatus(id, copyDataExtension, errExtendedBadRequest)
+		return
+	}
+	src, err := c.acquireHandle(string(readHandle))
+	if err != nil {
+		c.writeStatus(id, copyDataExtension, err)
+		return
+	}
+	dst, err := c.acquireHandle(string(writeHandle))
+	if err != nil {
+		c.releaseHandle(src)
+		c.writeStatus(id, copyDataExtension, err)
+		return
+	}
+	if src.transfer == dst.transfer {
+		c.releaseHandle(src)
+		c.releaseHandle(dst)
+		// overlapping ranges are not allowed, we don't check the ranges and
+		// we simply refuse to copy data inside the same file
+		c.writeStatus(id, copyDataExtension, sftp.ErrSSHFxOpUnsupported)
+		return
+	}
+	c.runAsync(func() {
+		defer func() {
+			c.releaseHandle(src)
+			c.releaseHandle(dst)
+		}()
+
+		c.writeStatus(id, copyDataExtension, c.connection.copyData(src.transfer, readOffset, readLength,
+			dst.transfer, writeOffset))
+	})
+}
+
+// handleLimits serves the limits@openssh.com extension
+func (c *extensionsChannel) handleLimits(id uint32) {
+	payload := binary.BigEndian.AppendUint64(nil, maxSFTPPacketLength)
+	payload = binary.BigEndian.AppendUint64(payload, maxSFTPReadLength)
+	// the write packets must fit inside the maximum packet length
+	payload = binary.BigEndian.AppendUint64(payload, maxSFTPPacketLength-1024)
+	// no limit for the open handles
+	payload = binary.BigEndian.AppendUint64(payload, 0)
+	c.writeExtendedReply(id, limitsExtension, payload)
+}
+
+// handleExpandPath serves the expand-path@openssh.com extension, the home
+// directory is the virtual root directory or the start directory if set
+func (c *extensionsChannel) handleExpandPath(id uint32, data []byte) {
+	p, _, ok := unmarshalSFTPString(data)
+	if !ok {
+		c.writeStatus(id, expandPathExtension, errExtendedBadRequest)
+		return
+	}
+	expanded, err := c.connection.expandPath(string(p))
+	if err != nil {
+		c.writeStatus(id, expandPathExtension, err)
+		return
+	}
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	payload = binary.BigEndian.AppendUint32(payload, 1)
+	payload = appendSFTPString(payload, expanded)
+	payload = appendSFTPString(payload, expanded)
+	// no attributes
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	if err := c.writePacket(sftpPacketName, payload); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to send %q response: %v", expandPathExtension, err)
+	}
+}
+
+// handleUsersGroupsByID serves the users-groups-by-id@openssh.com extension.
+// Only the ids configured for the SFTPGo user are resolved, we never disclose
+// the system accounts, an empty name is returned for unknown ids
+func (c *extensionsChannel) handleUsersGroupsByID(id uint32, data []byte) {
+	uids, data, ok := unmarshalSFTPString(data)
+	if !ok || len(uids)%4 != 0 {
+		c.writeStatus(id, usersGroupsByIDExtension, errExtendedBadRequest)
+		return
+	}
+	gids, _, ok := unmarshalSFTPString(data)
+	if !ok || len(gids)%4 != 0 {
+		c.writeStatus(id, usersGroupsByIDExtension, errExtendedBadRequest)
+		return
+	}
+	var primaryGroup string
+	for _, g := range c.connection.User.Groups {
+		if g.Type == sdk.GroupTypePrimary {
+			primaryGroup = g.Name
+			break
+		}
+	}
+	var users, groups []byte
+	for i := 0; i < len(uids); i += 4 {
+		var name string
+		if uid := int(binary.BigEndian.Uint32(uids[i:])); uid == c.connection.User.GetUID() {
+			name = c.connection.User.Username
+		}
+		users = appendSFTPString(users, name)
+	}
+	for i := 0; i < len(gids); i += 4 {
+		var name string
+		if gid := int(binary.BigEndian.Uint32(gids[i:])); gid == c.connection.User.GetGID() {
+			name = primaryGroup
+		}
+		groups = appendSFTPString(groups, name)
+	}
+	payload := appendSFTPString(nil, string(users))
+	payload = appendSFTPString(payload, string(groups))
+	c.writeExtendedReply(id, usersGroupsByIDExtension, payload)
+}
+
+// handleFsync serves the fsync@openssh.com extension
+func (c *extensionsChannel) handleFsync(id uint32, data []byte) {
+	handle, _, ok := unmarshalSFTPString(data)
+	if !ok {
+		c.writeStatus(id, fsyncExtension, errExtendedBadRequest)
+		return
+	}
+	h, err := c.acquireHandle(string(handle))
+	if err != nil {
+		c.writeStatus(id, fsyncExtension, err)
+		return
+	}
+	c.runAsync(func() {
+		defer c.releaseHandle(h)
+
+		c.writeStatus(id, fsyncExtension, c.connection.syncTransfer(h.transfer))
+	})
+}
+
+// copyData copies length bytes, or until EOF if length is 0, from src to dst
+func (c *Connection) copyData(src *transfer, readOffset, length int64, dst *transfer, writeOffset int64) error {
+	c.UpdateLastActivity()
+
+	buf := make([]byte, maxSFTPReadLength)
+	var copied int64
+	for length == 0 || copied < length {
+		size := int64(len(buf))
+		if length > 0 && length-copied < size {
+			size = length - copied
+		}
+		n, err := src.ReadAt(buf[:size], readOffset+copied)
+		if n > 0 {
+			if _, errWrite := dst.WriteAt(buf[:n], writeOffset+copied); errWrite != nil {
+				return errWrite
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			if length > 0 && copied < length {
+				return io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.Log(logger.LevelDebug, "copy-data completed, bytes copied: %d, src: %q, dst: %q", copied,
+		src.GetVirtualPath(), dst.GetVirtualPath())
+	return nil
+}
+
+// expandPath returns the absolute virtual path for the specified path,
+// "~" and "~/" prefixes are relative to the start directory
+func (c *Connection) expandPath(p string) (string, error) {
+	if strings.HasPrefix(p, "~") {
+		if p != "~" && !strings.HasPrefix(p, "~/") {
+			// ~user is not supported
+			return "", sftp.ErrSSHFxNoSuchFile
+		}
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "~"), "/")
+	}
+	p = c.cleanRequestPath(p)
+	if c.folderPrefix != "" {
+		return p, nil
+	}
+	return c.RealPath(p)
+}
+
+// syncTransfer commits the data written to the file to stable storage,
+// only files opened on local filesystems are supported
+func (c *Connection) syncTransfer(t *transfer) error {
+	c.UpdateLastActivity()
+
+	syncer, ok := t.File.(interface{ Sync() error })
+	if t.File == nil || !ok {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if err := syncer.Sync(); err != nil {
+		c.Log(logger.LevelError, "unable to sync file %q: %v", t.GetVirtualPath(), err)
+		return c.GetFsError(t.Fs, err)
+	}
+	return nil
+}
+
+// addOpenRequestID records an SFTP open request that will be served by the open handlers.
+// The SFTP server handles the open requests sequentially, in the order they are received
+func (c *Connection) addOpenRequestID(id uint32) {
+	c.openedTransfersMu.Lock()
+	defer c.openedTransfersMu.Unlock()
+
+	c.openRequestIDs = append(c.openRequestIDs, id)
+}
+
+// nextOpenRequestID returns the id for the open request being handled, if any
+func (c *Connection) nextOpenRequestID() (uint32, bool) {
+	c.openedTransfersMu.Lock()
+	defer c.openedTransfersMu.Unlock()
+
+	if len(c.openRequestIDs) == 0 {
+		return 0, false
+	}
+	id := c.openRequestIDs[0]
+	c.openRequestIDs = c.openRequestIDs[1:]
+	return id, true
+}
+
+// addOpenedTransfer records the transfer opened for the SFTP open request with
+// the specified id, the extensions channel associates it to the returned handle
+func (c *Connection) addOpenedTransfer(id uint32, t *transfer) {
+	c.openedTransfersMu.Lock()
+	defer c.openedTransfersMu.Unlock()
+
+	if c.openedTransfers == nil {
+		c.openedTransfers = make(map[uint32]*transfer)
+	}
+	c.openedTransfers[id] = t
+}
+
+func (c *Connection) popOpenedTransfer(id uint32) *transfer {
+	c.openedTransfersMu.Lock()
+	defer c.openedTransfersMu.Unlock()
+
+	t := c.openedTransfers[id]
+	delete(c.openedTransfers, id)
+	return t
+}
+
+func getSFTPStatusCode(err error) uint32 {
+	switch {
+	case err == nil:
+		return sftpStatusOK
+	case errors.Is(err, io.EOF):
+		return sftpStatusEOF
+	case errors.Is(err, sftp.ErrSSHFxNoSuchFile), errors.Is(err, os.ErrNotExist):
+		return sftpStatusNoSuchFile
+	case errors.Is(err, sftp.ErrSSHFxPermissionDenied), errors.Is(err, os.ErrPermission):
+		return sftpStatusPermissionDenied
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		return sftpStatusOpUnsupported
+	case errors.Is(err, errExtendedBadRequest):
+		return sftpStatusBadMessage
+	default:
+		return sftpStatusFailure
+	}
+}
+
+func marshalSFTPStatus(id uint32, err error) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, id)
+	payload = binary.BigEndian.AppendUint32(payload, getSFTPStatusCode(err))
+	msg := "OK"
+	if err != nil {
+		msg = err.Error()
+	}
+	payload = appendSFTPString(payload, msg)
+	return appendSFTPString(payload, "")
+}
+
+func marshalSFTPPacket(packetType byte, payload []byte) []byte {
+	packet := make([]byte, 0, 5+len(payload))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(1+len(payload)))
+	packet = append(packet, packetType)
+	return append(packet, payload...)
+}
+
+func appendSFTPString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func unmarshalSFTPUint32(b []byte) (uint32, []byte, bool) {
+	if len(b) < 4 {
+		return 0, b, false
+	}
+	return binary.BigEndian.Uint32(b), b[4:], true
+}
+
+func unmarshalSFTPString(b []byte) ([]byte, []byte, bool) {
+	length, b, ok := unmarshalSFTPUint32(b)
+	if !ok || uint32(len(b)) < length {
+		return nil, b, false
+	}
+	return b[:length], b[length:], true
+}
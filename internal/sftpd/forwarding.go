@@ -0,0 +1,148 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	directTCPIPChannelType = "direct-tcpip"
+	forwardingDialTimeout  = 10 * time.Second
+)
+
+// directTCPIPData defines the payload for a direct-tcpip channel, RFC 4254 section 7.2
+type directTCPIPData struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// handleDirectTCPIP handles local port forwarding requests, for example "ssh -L" or "ssh -J".
+// The destination must be explicitly allowed in the user's permitted opens
+func handleDirectTCPIP(newChannel ssh.NewChannel, connection *Connection) {
+	var data directTCPIPData
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &data); err != nil {
+		connection.Log(logger.LevelDebug, "unable to parse direct-tcpip payload: %v", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload") //nolint:errcheck
+		return
+	}
+	destination := net.JoinHostPort(data.DestAddr, strconv.FormatUint(uint64(data.DestPort), 10))
+	connection.command = fmt.Sprintf("%s %s", directTCPIPChannelType, destination)
+	ipAddr := util.GetIPFromRemoteAddress(connection.GetRemoteAddress())
+
+	if !connection.User.IsForwardingAllowed(data.DestAddr, data.DestPort) {
+		connection.Log(logger.LevelInfo, "port forwarding to %q denied, origin %s:%d", destination,
+			data.OriginAddr, data.OriginPort)
+		common.AddDefenderEvent(ipAddr, common.HostEventLimitExceeded)
+		newChannel.Reject(ssh.Prohibited, "port forwarding to this destination is not allowed") //nolint:errcheck
+		return
+	}
+	if common.IsBanned(ipAddr) {
+		connection.Log(logger.LevelInfo, "port forwarding to %q denied, IP %q is banned", destination, ipAddr)
+		newChannel.Reject(ssh.Prohibited, "port forwarding is not allowed") //nolint:errcheck
+		return
+	}
+	target, err := net.DialTimeout("tcp", destination, forwardingDialTimeout)
+	if err != nil {
+		connection.Log(logger.LevelInfo, "unable to connect to forwarding destination %q: %v", destination, err)
+		newChannel.Reject(ssh.ConnectionFailed, "unable to connect to the destination") //nolint:errcheck
+		return
+	}
+	defer target.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		connection.Log(logger.LevelWarn, "could not accept a direct-tcpip channel: %v", err)
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	connection.channel = channel
+
+	if err := common.Connections.Add(connection); err != nil {
+		connection.Log(logger.LevelInfo, "unable to add port forwarding connection: %v", err)
+		channel.Close()
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	startTime := time.Now()
+	connection.Log(logger.LevelInfo, "port forwarding to %q started, origin %s:%d", destination,
+		data.OriginAddr, data.OriginPort)
+
+	var bytesSent, bytesReceived atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyForwardedData(target, channel, connection, connection.User.UploadBandwidth, &bytesReceived)
+		if tcpConn, ok := target.(*net.TCPConn); ok {
+			tcpConn.CloseWrite() //nolint:errcheck
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		copyForwardedData(channel, target, connection, connection.User.DownloadBandwidth, &bytesSent)
+		channel.CloseWrite() //nolint:errcheck
+	}()
+	wg.Wait()
+	channel.Close()
+
+	connection.Log(logger.LevelInfo, "port forwarding to %q ended, bytes received: %d, bytes sent: %d, elapsed: %s",
+		destination, bytesReceived.Load(), bytesSent.Load(), time.Since(startTime))
+}
+
+// copyForwardedData copies from src to dst respecting the specified bandwidth limit
+// as KB/s, 0 means unlimited
+func copyForwardedData(dst io.Writer, src io.Reader, connection *Connection, bandwidth int64,
+	transferred *atomic.Int64,
+) {
+	buf := make([]byte, 32768)
+	start := time.Now()
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, errWrite := dst.Write(buf[:n]); errWrite != nil {
+				return
+			}
+			connection.UpdateLastActivity()
+			total := transferred.Add(int64(n))
+			if bandwidth > 0 {
+				// real and wanted elapsed as milliseconds, bytes as kilobytes
+				realElapsed := time.Since(start).Milliseconds()
+				wantedElapsed := 1000 * (total / 1024) / bandwidth
+				if wantedElapsed > realElapsed {
+					time.Sleep(time.Duration(wantedElapsed-realElapsed) * time.Millisecond)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
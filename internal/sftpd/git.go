@@ -0,0 +1,334 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime/debug"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	gitUploadPackCmdName  = "git-upload-pack"
+	gitReceivePackCmdName = "git-receive-pack"
+)
+
+var (
+	// pushes to the same repository are serialized
+	gitRepoLocks sync.Map
+	errGitNoRepo = errors.New("repository not found")
+)
+
+// gitStorageLoader implements server.Loader for a single repository
+type gitStorageLoader struct {
+	storer storer.Storer
+}
+
+func (l *gitStorageLoader) Load(_ *transport.Endpoint) (storer.Storer, error) {
+	return l.storer, nil
+}
+
+// gitCommand implements the git smart protocol on top of the virtual filesystem,
+// the repository can be stored on any storage backend
+type gitCommand struct {
+	sshCommand
+}
+
+func (c *gitCommand) handle() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handle git command: %#v stack trace: %v", r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+	}()
+	if err := common.Connections.Add(c.connection); err != nil {
+		logger.Info(logSender, "", "unable to add git connection: %v", err)
+		return err
+	}
+	defer common.Connections.Remove(c.connection.GetID())
+
+	c.connection.Log(logger.LevelDebug, "handle git command %q, args: %v user: %v", c.command, c.args,
+		c.connection.User.Username)
+	err = c.serve()
+	if err != nil {
+		c.sendStderr(err)
+	}
+	c.sendExitStatus(err)
+	return err
+}
+
+func (c *gitCommand) sendStderr(err error) {
+	channel, ok := c.connection.channel.(interface{ Stderr() io.ReadWriter })
+	if !ok {
+		return
+	}
+	if errors.Is(err, errGitNoRepo) {
+		channel.Stderr().Write([]byte(fmt.Sprintf("fatal: '%s' does not appear to be a git repository\n", //nolint:errcheck
+			c.getDestPath())))
+		return
+	}
+	channel.Stderr().Write([]byte(fmt.Sprintf("fatal: %v\n", err))) //nolint:errcheck
+}
+
+func (c *gitCommand) isReceivePack() bool {
+	return c.command == gitReceivePackCmdName
+}
+
+func (c *gitCommand) getRequiredPermissions() []string {
+	if c.isReceivePack() {
+		return []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload,
+			dataprovider.PermCreateDirs, dataprovider.PermOverwrite, dataprovider.PermDelete}
+	}
+	return []string{dataprovider.PermListItems, dataprovider.PermDownload}
+}
+
+func (c *gitCommand) serve() error {
+	if err := common.CheckClosing(); err != nil {
+		return err
+	}
+	if len(c.args) != 1 {
+		return errors.New("a single repository path is required")
+	}
+	repoPath := path.Clean(c.getDestPath())
+	if !c.connection.User.HasPerms(c.getRequiredPermissions(), repoPath) {
+		return c.connection.GetPermissionDeniedError()
+	}
+	if err := c.isSystemCommandAllowed(); err != nil {
+		return err
+	}
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(repoPath)
+	if err != nil {
+		return err
+	}
+	diskQuota, transferQuota := c.connection.HasSpace(true, false, path.Join(repoPath, "fakecontent"))
+	if !transferQuota.HasDownloadSpace() {
+		return c.connection.GetReadQuotaExceededError()
+	}
+	if c.isReceivePack() && (!diskQuota.HasSpace || !transferQuota.HasUploadSpace()) {
+		return c.connection.GetQuotaExceededError()
+	}
+	if c.isReceivePack() {
+		lockKey := fmt.Sprintf("%s_%s", fs.Name(), fsPath)
+		mu, _ := gitRepoLocks.LoadOrStore(lockKey, &sync.Mutex{})
+		mu.(*sync.Mutex).Lock()
+		defer mu.(*sync.Mutex).Unlock()
+	}
+
+	gitFs := newGitFilesystem(fs, fsPath, c.connection.User.GetUID(), c.connection.User.GetGID())
+	defer gitFs.Close()
+
+	storage, err := c.getStorage(gitFs, repoPath)
+	if err != nil {
+		return err
+	}
+	if !c.isReceivePack() {
+		return c.serveUploadPack(storage, fs, fsPath, repoPath, transferQuota)
+	}
+	initialFiles, initialSize, err := c.getSizeForPath(fs, fsPath)
+	if err != nil {
+		return err
+	}
+	err = c.serveReceivePack(storage, fs, fsPath, repoPath, diskQuota.GetRemainingSize(), transferQuota)
+	numFiles, dirSize, errSize := c.getSizeForPath(fs, fsPath)
+	if errSize == nil {
+		c.updateQuota(repoPath, numFiles-initialFiles, dirSize-initialSize)
+	}
+	c.connection.Log(logger.LevelDebug, "git push to %q finished, initial files %d initial size %d "+
+		"current files %d current size %d size err: %v", repoPath, initialFiles, initialSize, numFiles, dirSize, errSize)
+	if err == nil {
+		for packPath, size := range gitFs.getNewPacks() {
+			common.ExecuteUploadNotification(c.connection.BaseConnection, packPath, //nolint:errcheck
+				fs.GetRelativePath(packPath), size, nil)
+		}
+	}
+	return err
+}
+
+// getStorage returns the storage for the repository. A bare repository is created
+// on push if the repository path does not exist and the user can create it
+func (c *gitCommand) getStorage(gitFs *gitFilesystem, repoPath string) (storer.Storer, error) {
+	storage := filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault())
+	if _, err := gitFs.Stat("config"); err == nil {
+		return storage, nil
+	}
+	if !c.isReceivePack() {
+		return nil, errGitNoRepo
+	}
+	if _, err := gitFs.Stat("/"); err == nil {
+		return nil, errGitNoRepo
+	}
+	if repoPath == "/" || !c.connection.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(repoPath)) {
+		return nil, errGitNoRepo
+	}
+	if _, err := git.Init(storage, nil); err != nil {
+		c.connection.Log(logger.LevelError, "unable to create git repository %q: %v", repoPath, err)
+		return nil, err
+	}
+	c.connection.Log(logger.LevelInfo, "git repository %q created", repoPath)
+	return storage, nil
+}
+
+// getStreams returns the reader and the writer to use to communicate with the client,
+// the transferred bytes are accounted for bandwidth and transfer quota limits
+func (c *gitCommand) getStreams(fs vfs.Fs, fsPath, repoPath string, maxWriteSize int64,
+	transferQuota dataprovider.TransferQuota,
+) (io.Reader, io.WriteCloser, <-chan struct{}) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	outDone := make(chan struct{})
+
+	go func() {
+		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, fsPath, fsPath, repoPath,
+			common.TransferUpload, 0, 0, maxWriteSize, 0, false, fs, transferQuota)
+		transfer := newTransfer(baseTransfer, nil, nil, nil)
+
+		w, err := transfer.copyFromReaderToWriter(inWriter, c.connection.channel)
+		c.connection.Log(logger.LevelDebug, "command: %q, copy from remote command to git server ended, written: %d, "+
+			"max write size: %d, err: %v", c.connection.command, w, maxWriteSize, err)
+		if err != nil {
+			inWriter.CloseWithError(err)
+			return
+		}
+		inWriter.Close()
+	}()
+
+	go func() {
+		defer close(outDone)
+
+		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, fsPath, fsPath, repoPath,
+			common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+		transfer := newTransfer(baseTransfer, nil, nil, nil)
+
+		w, err := transfer.copyFromReaderToWriter(c.connection.channel, outReader)
+		c.connection.Log(logger.LevelDebug, "command: %q, copy from git server to remote command ended, written: %d err: %v",
+			c.connection.command, w, err)
+		if err != nil {
+			outReader.CloseWithError(err)
+		}
+	}()
+
+	return inReader, outWriter, outDone
+}
+
+// hasRequest returns false if the client closed the connection or sent a flush packet
+// after the advertised references, this happens if there is nothing to fetch or push
+func (c *gitCommand) hasRequest(r *bufio.Reader) (bool, error) {
+	data, err := r.Peek(4)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(data) != "0000", nil
+}
+
+func (c *gitCommand) serveUploadPack(storage storer.Storer, fs vfs.Fs, fsPath, repoPath string,
+	transferQuota dataprovider.TransferQuota,
+) error {
+	session, err := server.NewServer(&gitStorageLoader{storer: storage}).NewUploadPackSession(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	in, out, outDone := c.getStreams(fs, fsPath, repoPath, 0, transferQuota)
+	err = c.uploadPack(session, bufio.NewReader(in), out)
+	out.Close()
+	<-outDone
+	return err
+}
+
+func (c *gitCommand) uploadPack(session transport.UploadPackSession, in *bufio.Reader, out io.Writer) error {
+	advRefs, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	if err := advRefs.Encode(out); err != nil {
+		return err
+	}
+	if ok, err := c.hasRequest(in); !ok {
+		return err
+	}
+	req := packp.NewUploadPackRequest()
+	if err := req.Decode(in); err != nil {
+		return err
+	}
+	resp, err := session.UploadPack(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	return resp.Encode(out)
+}
+
+func (c *gitCommand) serveReceivePack(storage storer.Storer, fs vfs.Fs, fsPath, repoPath string, maxWriteSize int64,
+	transferQuota dataprovider.TransferQuota,
+) error {
+	session, err := server.NewServer(&gitStorageLoader{storer: storage}).NewReceivePackSession(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	in, out, outDone := c.getStreams(fs, fsPath, repoPath, maxWriteSize, transferQuota)
+	err = c.receivePack(session, bufio.NewReader(in), out)
+	out.Close()
+	<-outDone
+	return err
+}
+
+func (c *gitCommand) receivePack(session transport.ReceivePackSession, in *bufio.Reader, out io.Writer) error {
+	advRefs, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+	if err := advRefs.Encode(out); err != nil {
+		return err
+	}
+	if ok, err := c.hasRequest(in); !ok {
+		return err
+	}
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(in); err != nil {
+		return err
+	}
+	status, err := session.ReceivePack(context.Background(), req)
+	if status != nil {
+		if errEncode := status.Encode(out); errEncode != nil && err == nil {
+			err = errEncode
+		}
+	}
+	return err
+}
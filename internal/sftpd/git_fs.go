@@ -0,0 +1,566 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// gitFsState is shared between a git filesystem and its chroots
+type gitFsState struct {
+	mu sync.Mutex
+	// local copies for files stored on remote backends, keyed by fs path.
+	// Git objects and packs are immutable so they are downloaded only once
+	cache map[string]string
+	// temporary files, keyed by fs path, they are kept locally until renamed
+	tempFiles map[string]string
+	// pack files added to the repository, keyed by fs path
+	newPacks map[string]int64
+}
+
+// gitFilesystem implements billy.Filesystem on top of vfs.Fs so git repositories can be stored
+// on any storage backend. Files on remote or encrypted backends are read and written using
+// local copies, the local filesystem is used directly
+type gitFilesystem struct {
+	fs      vfs.Fs
+	root    string
+	uid     int
+	gid     int
+	isLocal bool
+	state   *gitFsState
+}
+
+func newGitFilesystem(fs vfs.Fs, root string, uid, gid int) *gitFilesystem {
+	return &gitFilesystem{
+		fs:      fs,
+		root:    root,
+		uid:     uid,
+		gid:     gid,
+		isLocal: vfs.IsLocalOsFs(fs),
+		state: &gitFsState{
+			cache:     make(map[string]string),
+			tempFiles: make(map[string]string),
+			newPacks:  make(map[string]int64),
+		},
+	}
+}
+
+func (g *gitFilesystem) getFsPath(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if name == "/" {
+		return g.root
+	}
+	return g.fs.Join(g.root, name[1:])
+}
+
+func (g *gitFilesystem) convertError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if g.fs.IsNotExist(err) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+func (g *gitFilesystem) getLocalPath(fsPath string) (string, bool) {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+
+	if p, ok := g.state.tempFiles[fsPath]; ok {
+		return p, true
+	}
+	p, ok := g.state.cache[fsPath]
+	return p, ok
+}
+
+func (g *gitFilesystem) setCachedPath(fsPath, localPath string) {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+
+	if p, ok := g.state.cache[fsPath]; ok && p != localPath {
+		os.Remove(p)
+	}
+	if localPath == "" {
+		delete(g.state.cache, fsPath)
+		return
+	}
+	g.state.cache[fsPath] = localPath
+}
+
+// Close removes the local copies and the temporary files not renamed
+func (g *gitFilesystem) Close() {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+
+	for _, p := range g.state.cache {
+		os.Remove(p)
+	}
+	for _, p := range g.state.tempFiles {
+		os.Remove(p)
+	}
+	g.state.cache = make(map[string]string)
+	g.state.tempFiles = make(map[string]string)
+}
+
+func (g *gitFilesystem) getNewPacks() map[string]int64 {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+
+	result := make(map[string]int64)
+	for k, v := range g.state.newPacks {
+		result[k] = v
+	}
+	return result
+}
+
+// Create implements billy.Basic
+func (g *gitFilesystem) Create(filename string) (billy.File, error) {
+	return g.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Open implements billy.Basic
+func (g *gitFilesystem) Open(filename string) (billy.File, error) {
+	return g.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+// OpenFile implements billy.Basic
+func (g *gitFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fsPath := g.getFsPath(filename)
+	isWrite := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if localPath, ok := g.getLocalPath(fsPath); ok && (!isWrite || g.isTempFile(fsPath)) {
+		f, err := os.OpenFile(localPath, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return &gitFile{name: filename, file: f}, nil
+	}
+	if flag&os.O_CREATE != 0 {
+		if err := g.MkdirAll(path.Dir(filepath.ToSlash(filename)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if g.isLocal {
+		var file vfs.File
+		var err error
+		if isWrite {
+			file, _, _, err = g.fs.Create(fsPath, flag)
+			if err == nil && flag&os.O_CREATE != 0 {
+				vfs.SetPathPermissions(g.fs, fsPath, g.uid, g.gid)
+			}
+		} else {
+			file, _, _, err = g.fs.Open(fsPath, 0)
+		}
+		if err != nil {
+			return nil, g.convertError("open", filename, err)
+		}
+		return &gitFile{name: filename, file: file}, nil
+	}
+	if !isWrite {
+		localPath, err := g.download(filename, fsPath)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		return &gitFile{name: filename, file: f}, nil
+	}
+	return g.openRemoteForWrite(filename, fsPath, flag)
+}
+
+func (g *gitFilesystem) isTempFile(fsPath string) bool {
+	g.state.mu.Lock()
+	defer g.state.mu.Unlock()
+
+	_, ok := g.state.tempFiles[fsPath]
+	return ok
+}
+
+// download creates a local copy for a file stored on a remote backend
+func (g *gitFilesystem) download(filename, fsPath string) (string, error) {
+	if localPath, ok := g.getLocalPath(fsPath); ok {
+		return localPath, nil
+	}
+	info, err := g.fs.Stat(fsPath)
+	if err != nil {
+		return "", g.convertError("open", filename, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%q is a directory", filename)
+	}
+	tmp, err := os.CreateTemp("", "sftpgo-git-")
+	if err != nil {
+		return "", err
+	}
+	file, r, cancelFn, err := g.fs.Open(fsPath, 0)
+	if err == nil {
+		if file != nil {
+			_, err = io.Copy(tmp, file)
+			file.Close()
+		} else {
+			_, err = io.Copy(tmp, r)
+			r.Close()
+		}
+		if cancelFn != nil {
+			cancelFn()
+		}
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", g.convertError("open", filename, err)
+	}
+	g.setCachedPath(fsPath, tmp.Name())
+	return tmp.Name(), nil
+}
+
+func (g *gitFilesystem) openRemoteForWrite(filename, fsPath string, flag int) (billy.File, error) {
+	tmp, err := os.CreateTemp("", "sftpgo-git-")
+	if err != nil {
+		return nil, err
+	}
+	var existing string
+	if flag&os.O_TRUNC == 0 {
+		existing, err = g.download(filename, fsPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+		if err != nil && flag&os.O_CREATE == 0 {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+	if existing != "" {
+		src, err := os.Open(existing)
+		if err == nil {
+			_, err = io.Copy(tmp, src)
+			src.Close()
+		}
+		if err == nil && flag&os.O_APPEND == 0 {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, err
+		}
+	}
+	return &gitFile{
+		name: filename,
+		file: tmp,
+		onClose: func(f *gitFile) error {
+			if !f.isModified {
+				os.Remove(tmp.Name())
+				return nil
+			}
+			if err := g.upload(tmp.Name(), fsPath); err != nil {
+				os.Remove(tmp.Name())
+				return err
+			}
+			g.setCachedPath(fsPath, tmp.Name())
+			return nil
+		},
+	}, nil
+}
+
+// upload copies the specified local file to the storage backend
+func (g *gitFilesystem) upload(localPath, fsPath string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, w, cancelFn, err := g.fs.Create(fsPath, 0)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		_, err = io.Copy(file, src)
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	} else {
+		_, err = io.Copy(w, src)
+		if errClose := w.Close(); err == nil {
+			err = errClose
+		}
+	}
+	if cancelFn != nil {
+		cancelFn()
+	}
+	if err != nil {
+		logger.Warn(logSender, g.fs.ConnectionID(), "unable to upload git file %q: %v", fsPath, err)
+	}
+	return err
+}
+
+// Stat implements billy.Basic
+func (g *gitFilesystem) Stat(filename string) (os.FileInfo, error) {
+	fsPath := g.getFsPath(filename)
+	if localPath, ok := g.getLocalPath(fsPath); ok && g.isTempFile(fsPath) {
+		return os.Stat(localPath)
+	}
+	info, err := g.fs.Stat(fsPath)
+	return info, g.convertError("stat", filename, err)
+}
+
+// Lstat implements billy.Symlink
+func (g *gitFilesystem) Lstat(filename string) (os.FileInfo, error) {
+	fsPath := g.getFsPath(filename)
+	if localPath, ok := g.getLocalPath(fsPath); ok && g.isTempFile(fsPath) {
+		return os.Lstat(localPath)
+	}
+	info, err := g.fs.Lstat(fsPath)
+	return info, g.convertError("lstat", filename, err)
+}
+
+// Rename implements billy.Basic
+func (g *gitFilesystem) Rename(oldpath, newpath string) error {
+	source := g.getFsPath(oldpath)
+	target := g.getFsPath(newpath)
+	if err := g.MkdirAll(path.Dir(filepath.ToSlash(newpath)), 0755); err != nil {
+		return err
+	}
+	g.state.mu.Lock()
+	localPath, isTemp := g.state.tempFiles[source]
+	g.state.mu.Unlock()
+
+	if isTemp {
+		if err := g.upload(localPath, target); err != nil {
+			return err
+		}
+		g.state.mu.Lock()
+		delete(g.state.tempFiles, source)
+		g.state.mu.Unlock()
+		g.setCachedPath(target, localPath)
+	} else {
+		if err := g.fs.Rename(source, target); err != nil {
+			return g.convertError("rename", oldpath, err)
+		}
+		g.setCachedPath(source, "")
+		g.setCachedPath(target, "")
+	}
+	if strings.HasSuffix(target, ".pack") {
+		if info, err := g.fs.Stat(target); err == nil {
+			g.state.mu.Lock()
+			g.state.newPacks[target] = info.Size()
+			g.state.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Remove implements billy.Basic
+func (g *gitFilesystem) Remove(filename string) error {
+	fsPath := g.getFsPath(filename)
+	g.state.mu.Lock()
+	localPath, isTemp := g.state.tempFiles[fsPath]
+	if isTemp {
+		delete(g.state.tempFiles, fsPath)
+	}
+	g.state.mu.Unlock()
+
+	if isTemp {
+		return os.Remove(localPath)
+	}
+	info, err := g.fs.Lstat(fsPath)
+	if err != nil {
+		return g.convertError("remove", filename, err)
+	}
+	g.setCachedPath(fsPath, "")
+	return g.convertError("remove", filename, g.fs.Remove(fsPath, info.IsDir()))
+}
+
+// Join implements billy.Basic
+func (g *gitFilesystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// TempFile implements billy.TempFile
+func (g *gitFilesystem) TempFile(dir, prefix string) (billy.File, error) {
+	if err := g.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	localDir := ""
+	if g.isLocal {
+		localDir = g.getFsPath(dir)
+	}
+	f, err := os.CreateTemp(localDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Join(filepath.ToSlash(dir), filepath.Base(f.Name()))
+	if g.isLocal {
+		vfs.SetPathPermissions(g.fs, f.Name(), g.uid, g.gid)
+	} else {
+		g.state.mu.Lock()
+		g.state.tempFiles[g.getFsPath(name)] = f.Name()
+		g.state.mu.Unlock()
+	}
+	return &gitFile{name: name, file: f}, nil
+}
+
+// ReadDir implements billy.Dir
+func (g *gitFilesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	files, err := g.fs.ReadDir(g.getFsPath(dirname))
+	return files, g.convertError("readdir", dirname, err)
+}
+
+// MkdirAll implements billy.Dir
+func (g *gitFilesystem) MkdirAll(filename string, perm os.FileMode) error {
+	filename = path.Clean("/" + filepath.ToSlash(filename))
+	// the repository root is created too, if missing, this happens on the first push
+	if err := g.mkdir("/"); err != nil {
+		return err
+	}
+	if filename == "/" {
+		return nil
+	}
+	current := "/"
+	for _, part := range strings.Split(filename[1:], "/") {
+		current = path.Join(current, part)
+		if err := g.mkdir(current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gitFilesystem) mkdir(name string) error {
+	fsPath := g.getFsPath(name)
+	info, err := g.fs.Stat(fsPath)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", name)
+		}
+		return nil
+	}
+	if !g.fs.IsNotExist(err) {
+		return err
+	}
+	if err := g.fs.Mkdir(fsPath); err != nil {
+		return err
+	}
+	vfs.SetPathPermissions(g.fs, fsPath, g.uid, g.gid)
+	return nil
+}
+
+// Symlink implements billy.Symlink
+func (g *gitFilesystem) Symlink(target, link string) error {
+	return billy.ErrNotSupported
+}
+
+// Readlink implements billy.Symlink
+func (g *gitFilesystem) Readlink(link string) (string, error) {
+	return "", billy.ErrNotSupported
+}
+
+// Chroot implements billy.Chroot
+func (g *gitFilesystem) Chroot(p string) (billy.Filesystem, error) {
+	return &gitFilesystem{
+		fs:      g.fs,
+		root:    g.getFsPath(p),
+		uid:     g.uid,
+		gid:     g.gid,
+		isLocal: g.isLocal,
+		state:   g.state,
+	}, nil
+}
+
+// Root implements billy.Chroot
+func (g *gitFilesystem) Root() string {
+	return g.root
+}
+
+type gitFileHandle interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Truncate(size int64) error
+}
+
+// gitFile implements billy.File, locking is handled at the repository level
+type gitFile struct {
+	name       string
+	file       gitFileHandle
+	isModified bool
+	onClose    func(f *gitFile) error
+}
+
+func (f *gitFile) Name() string {
+	return f.name
+}
+
+func (f *gitFile) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+func (f *gitFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.file.ReadAt(p, off)
+}
+
+func (f *gitFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *gitFile) Write(p []byte) (int, error) {
+	f.isModified = true
+	return f.file.Write(p)
+}
+
+func (f *gitFile) Truncate(size int64) error {
+	f.isModified = true
+	return f.file.Truncate(size)
+}
+
+func (f *gitFile) Close() error {
+	err := f.file.Close()
+	if f.onClose != nil {
+		if errClose := f.onClose(f); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (*gitFile) Lock() error {
+	return nil
+}
+
+func (*gitFile) Unlock() error {
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -42,6 +43,11 @@ type Connection struct {
 	channel      io.ReadWriteCloser
 	command      string
 	folderPrefix string
+	// SFTP open requests, served by the extensions channel, in the order they
+	// will be handled and the transfers opened for them, keyed by request id
+	openedTransfersMu sync.Mutex
+	openRequestIDs    []uint32
+	openedTransfers   map[uint32]*transfer
 }
 
 // GetClientVersion returns the connected client's version
@@ -74,7 +80,22 @@ func (c *Connection) GetCommand() string {
 func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	c.UpdateLastActivity()
 
-	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
+	id, hasID := c.nextOpenRequestID()
+	t, err := c.getDownloadTransfer(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if hasID {
+		c.addOpenedTransfer(id, t)
+	}
+
+	return t, nil
+}
+
+// getDownloadTransfer checks the download permissions and limits for the
+// specified virtual path and returns a transfer to read it
+func (c *Connection) getDownloadTransfer(virtualPath string) (*transfer, error) {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 	transferQuota := c.GetTransferQuota()
@@ -83,18 +104,18 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, c.GetReadQuotaExceededError()
 	}
 
-	if ok, policy := c.User.IsFileAllowed(request.Filepath); !ok {
-		c.Log(logger.LevelWarn, "reading file %#v is not allowed", request.Filepath)
+	if ok, policy := c.User.IsFileAllowed(virtualPath); !ok {
+		c.Log(logger.LevelWarn, "reading file %#v is not allowed", virtualPath)
 		return nil, c.GetErrorForDeniedFile(policy)
 	}
 
-	fs, p, err := c.GetFsAndResolvedPath(request.Filepath)
+	fs, p, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, request.Filepath, 0, 0); err != nil {
-		c.Log(logger.LevelDebug, "download for file %#v denied by pre action: %v", request.Filepath, err)
+	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreDownload, p, virtualPath, 0, 0); err != nil {
+		c.Log(logger.LevelDebug, "download for file %#v denied by pre action: %v", virtualPath, err)
 		return nil, c.GetPermissionDeniedError()
 	}
 
@@ -104,11 +125,9 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, c.GetFsError(fs, err)
 	}
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, request.Filepath, common.TransferDownload,
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, p, virtualPath, common.TransferDownload,
 		0, 0, 0, 0, false, fs, transferQuota)
-	t := newTransfer(baseTransfer, nil, r, nil)
-
-	return t, nil
+	return newTransfer(baseTransfer, nil, r, nil), nil
 }
 
 // OpenFile implements OpenFileWriter interface
@@ -122,6 +141,18 @@ func (c *Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 }
 
 func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	id, hasID := c.nextOpenRequestID()
+	w, err := c.openFileForWrite(request)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := w.(*transfer); ok && hasID {
+		c.addOpenedTransfer(id, t)
+	}
+	return w, nil
+}
+
+func (c *Connection) openFileForWrite(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
 	c.UpdateLastActivity()
 
 	if ok, _ := c.User.IsFileAllowed(request.Filepath); !ok {
@@ -200,6 +231,10 @@ func (c *Connection) Filecmd(request *sftp.Request) error {
 		if err := c.CreateSymlink(request.Filepath, request.Target); err != nil {
 			return err
 		}
+	case "Link":
+		if err := c.CreateHardlink(request.Filepath, request.Target); err != nil {
+			return err
+		}
 	case "Remove":
 		return c.handleSFTPRemove(request)
 	default:
@@ -401,6 +436,9 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, pflags sftp.FileOpenFl
 		return nil, c.GetQuotaExceededError()
 	}
 
+	if err := c.CheckWebDAVLocks(requestPath, false); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, 0, 0); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
@@ -449,6 +487,9 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		return nil, err
 	}
 
+	if err := c.CheckWebDAVLocks(requestPath, false); err != nil {
+		return nil, err
+	}
 	if err := common.ExecutePreAction(c.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath, fileSize, osFlags); err != nil {
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
@@ -0,0 +1,608 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported host key status
+const (
+	// HostKeyStatusActive defines a key used for the key exchange and advertised to the clients
+	HostKeyStatusActive = "active"
+	// HostKeyStatusAdvertised defines a key advertised to the clients but not used for the key exchange
+	HostKeyStatusAdvertised = "advertised"
+	// HostKeyStatusRetired defines a key no longer used nor advertised
+	HostKeyStatusRetired = "retired"
+)
+
+// Supported types for the generated host keys
+const (
+	HostKeyTypeRSA     = "rsa"
+	HostKeyTypeECDSA   = "ecdsa"
+	HostKeyTypeEd25519 = "ed25519"
+)
+
+const (
+	hostKeysAdvertisementRequest = "hostkeys-00@openssh.com"
+	hostKeysProveRequest         = "hostkeys-prove-00@openssh.com"
+	hostKeyAdditionalData        = "sftpgo_host_key"
+)
+
+var (
+	hostKeysManager     = hostKeysStore{}
+	supportedHostKeyGen = []string{HostKeyTypeEd25519, HostKeyTypeECDSA, HostKeyTypeRSA}
+)
+
+// HostKeyInfo defines the details for a loaded host key
+type HostKeyInfo struct {
+	// Unique identifier, it is the hex encoded SHA256 hash of the public key
+	ID          string `json:"id"`
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Status      string `json:"status"`
+}
+
+type hostKeyRotationEntry struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// hostKeyRotationState defines the changes, made using the REST API, to the configured host keys
+type hostKeyRotationState struct {
+	Keys []hostKeyRotationEntry `json:"keys"`
+}
+
+type hostKey struct {
+	path        string
+	status      string
+	signer      ssh.Signer
+	certSigners []ssh.Signer
+}
+
+func (k *hostKey) getID() string {
+	return getHostKeyID(k.signer.PublicKey())
+}
+
+func (k *hostKey) getInfo() HostKeyInfo {
+	return HostKeyInfo{
+		ID:          k.getID(),
+		Path:        k.path,
+		Type:        k.signer.PublicKey().Type(),
+		Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+		Status:      k.status,
+	}
+}
+
+type hostKeysStore struct {
+	mu sync.RWMutex
+	// active and advertised keys. If more active keys have the same type,
+	// the last one is used for the key exchange
+	keys []*hostKey
+	// paths for the retired keys, they are persisted in the rotation file
+	retired      []string
+	certificates []*ssh.Certificate
+	rotationFile string
+}
+
+func (s *hostKeysStore) load(activeKeys, advertisedKeys []string, certificates []*ssh.Certificate, rotationFile string) error {
+	var keys []*hostKey
+	var retired []string
+
+	addKey := func(keyPath, status string) error {
+		for _, k := range keys {
+			if k.path == keyPath {
+				return nil
+			}
+		}
+		logger.Info(logSender, "", "Loading private host key %q, status %q", keyPath, status)
+		signer, err := loadPrivateKey(keyPath)
+		if err != nil {
+			return err
+		}
+		k := &hostKey{
+			path:   keyPath,
+			status: status,
+			signer: signer,
+		}
+		for _, cert := range certificates {
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err == nil {
+				k.certSigners = append(k.certSigners, certSigner)
+				logger.Info(logSender, "", "Host certificate loaded for host key %q, fingerprint %q",
+					keyPath, ssh.FingerprintSHA256(certSigner.PublicKey()))
+			}
+		}
+		keys = append(keys, k)
+		logger.Info(logSender, "", "Host key %q loaded, type %q, fingerprint %q", keyPath,
+			signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()))
+		return nil
+	}
+
+	for _, keyPath := range activeKeys {
+		if err := addKey(keyPath, HostKeyStatusActive); err != nil {
+			return err
+		}
+	}
+	for _, keyPath := range advertisedKeys {
+		if err := addKey(keyPath, HostKeyStatusAdvertised); err != nil {
+			return err
+		}
+	}
+	if rotationFile != "" {
+		state, err := readHostKeyRotationState(rotationFile)
+		if err != nil {
+			return err
+		}
+		// the rotation state overrides the status for the configured keys
+		for _, entry := range state.Keys {
+			keys = removeHostKeyWithPath(keys, entry.Path)
+			switch entry.Status {
+			case HostKeyStatusActive, HostKeyStatusAdvertised:
+				if err := addKey(entry.Path, entry.Status); err != nil {
+					return err
+				}
+			case HostKeyStatusRetired:
+				retired = append(retired, entry.Path)
+			default:
+				return fmt.Errorf("invalid status %q for host key %q in rotation file %q", entry.Status,
+					entry.Path, rotationFile)
+			}
+		}
+	}
+	if !hasActiveHostKey(keys) {
+		return errors.New("no active host key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = keys
+	s.retired = retired
+	s.certificates = certificates
+	s.rotationFile = rotationFile
+	return nil
+}
+
+func (s *hostKeysStore) updateServiceStatus() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hostKeys []HostKey
+	var fp []string
+	for _, k := range s.keys {
+		if k.status != HostKeyStatusActive {
+			continue
+		}
+		hk := HostKey{
+			Path:        k.path,
+			Fingerprint: ssh.FingerprintSHA256(k.signer.PublicKey()),
+		}
+		hostKeys = append(hostKeys, hk)
+		fp = append(fp, hk.Fingerprint)
+	}
+	serviceStatus.HostKeys = hostKeys
+	vfs.SetSFTPFingerprints(fp)
+}
+
+// getServerConfig returns a copy of the provided configuration with the active host keys added
+func (s *hostKeysStore) getServerConfig(config *ssh.ServerConfig) *ssh.ServerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return config
+	}
+	serverConfig := *config
+	for _, k := range s.keys {
+		if k.status != HostKeyStatusActive {
+			continue
+		}
+		serverConfig.AddHostKey(k.signer)
+		for _, certSigner := range k.certSigners {
+			serverConfig.AddHostKey(certSigner)
+		}
+	}
+	return &serverConfig
+}
+
+// getAdvertisementPayload returns the payload for the hostkeys-00@openssh.com request.
+// All the active and advertised keys are included so the clients can learn the upcoming keys
+func (s *hostKeysStore) getAdvertisementPayload() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var payload []byte
+	for _, k := range s.keys {
+		payload = append(payload, ssh.Marshal(struct {
+			Key []byte
+		}{k.signer.PublicKey().Marshal()})...)
+	}
+	return payload
+}
+
+// prove handles the hostkeys-prove-00@openssh.com request, the client asks to prove the ownership
+// of the private keys for the requested public keys
+func (s *hostKeysStore) prove(sessionID, payload []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var response []byte
+	for len(payload) > 0 {
+		var req struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("unable to parse host keys prove request: %w", err)
+		}
+		payload = req.Rest
+		k := s.getKeyFromPublicKey(req.Key)
+		if k == nil {
+			return nil, errors.New("unable to prove an unknown host key")
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, req.Key})
+		sig, err := signHostKeyProof(k.signer, data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign host key proof: %w", err)
+		}
+		response = append(response, ssh.Marshal(struct {
+			Signature []byte
+		}{ssh.Marshal(sig)})...)
+	}
+	return response, nil
+}
+
+// getKeyFromPublicKey must be called while holding the lock
+func (s *hostKeysStore) getKeyFromPublicKey(pubKey []byte) *hostKey {
+	for _, k := range s.keys {
+		if bytes.Equal(k.signer.PublicKey().Marshal(), pubKey) {
+			return k
+		}
+	}
+	return nil
+}
+
+// getKeyFromID must be called while holding the lock
+func (s *hostKeysStore) getKeyFromID(id string) *hostKey {
+	for _, k := range s.keys {
+		if k.getID() == id {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *hostKeysStore) getKeys() []HostKeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]HostKeyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		result = append(result, k.getInfo())
+	}
+	return result
+}
+
+func (s *hostKeysStore) generateKey(keyType string) (HostKeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rotationFile == "" {
+		return HostKeyInfo{}, util.NewMethodDisabledError("host keys rotation is disabled")
+	}
+	privKey, err := generateHostKey(keyType)
+	if err != nil {
+		return HostKeyInfo{}, err
+	}
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		return HostKeyInfo{}, err
+	}
+	keyPath := filepath.Join(filepath.Dir(s.rotationFile),
+		fmt.Sprintf("host_key_%s_%s", keyType, getHostKeyID(signer.PublicKey())[:16]))
+	if err := writeEncryptedPrivateKey(keyPath, privKey, hostKeyAdditionalData); err != nil {
+		return HostKeyInfo{}, err
+	}
+	k := &hostKey{
+		path:   keyPath,
+		status: HostKeyStatusAdvertised,
+		signer: signer,
+	}
+	for _, cert := range s.certificates {
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err == nil {
+			k.certSigners = append(k.certSigners, certSigner)
+		}
+	}
+	s.keys = append(s.keys, k)
+	if err := s.saveRotationState(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return HostKeyInfo{}, err
+	}
+	info := k.getInfo()
+	logger.Info(logSender, "", "new host key %q generated, type %q, fingerprint %q", info.Path, info.Type,
+		info.Fingerprint)
+	return info, nil
+}
+
+func (s *hostKeysStore) promoteKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rotationFile == "" {
+		return util.NewMethodDisabledError("host keys rotation is disabled")
+	}
+	k := s.getKeyFromID(id)
+	if k == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("host key %q not found", id))
+	}
+	if k.status == HostKeyStatusActive {
+		return util.NewValidationError(fmt.Sprintf("host key %q is already active", id))
+	}
+	keys := s.keys
+	// the promoted key is moved to the end so it takes precedence over any active key of the same type
+	s.keys = append(removeHostKeyWithPath(keys, k.path), k)
+	k.status = HostKeyStatusActive
+	if err := s.saveRotationState(); err != nil {
+		k.status = HostKeyStatusAdvertised
+		s.keys = keys
+		return err
+	}
+	logger.Info(logSender, "", "host key %q promoted, fingerprint %q", k.path, ssh.FingerprintSHA256(k.signer.PublicKey()))
+	return nil
+}
+
+func (s *hostKeysStore) retireKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rotationFile == "" {
+		return util.NewMethodDisabledError("host keys rotation is disabled")
+	}
+	k := s.getKeyFromID(id)
+	if k == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("host key %q not found", id))
+	}
+	keys := s.keys
+	retired := s.retired
+	s.keys = removeHostKeyWithPath(keys, k.path)
+	if !hasActiveHostKey(s.keys) {
+		s.keys = keys
+		return util.NewValidationError("unable to retire the last active host key")
+	}
+	s.retired = append(util.Remove(retired, k.path), k.path)
+	if err := s.saveRotationState(); err != nil {
+		s.keys = keys
+		s.retired = retired
+		return err
+	}
+	logger.Info(logSender, "", "host key %q retired, fingerprint %q", k.path, ssh.FingerprintSHA256(k.signer.PublicKey()))
+	return nil
+}
+
+// saveRotationState must be called while holding the lock
+func (s *hostKeysStore) saveRotationState() error {
+	var state hostKeyRotationState
+	for _, p := range s.retired {
+		state.Keys = append(state.Keys, hostKeyRotationEntry{
+			Path:   p,
+			Status: HostKeyStatusRetired,
+		})
+	}
+	for _, k := range s.keys {
+		state.Keys = append(state.Keys, hostKeyRotationEntry{
+			Path:   k.path,
+			Status: k.status,
+		})
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.rotationFile, data, 0600)
+}
+
+func readHostKeyRotationState(rotationFile string) (hostKeyRotationState, error) {
+	var state hostKeyRotationState
+
+	data, err := os.ReadFile(rotationFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("unable to parse host keys rotation file %q: %w", rotationFile, err)
+	}
+	return state, nil
+}
+
+func removeHostKeyWithPath(keys []*hostKey, keyPath string) []*hostKey {
+	result := make([]*hostKey, 0, len(keys))
+	for _, k := range keys {
+		if k.path != keyPath {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+func hasActiveHostKey(keys []*hostKey) bool {
+	for _, k := range keys {
+		if k.status == HostKeyStatusActive {
+			return true
+		}
+	}
+	return false
+}
+
+func getHostKeyID(pubKey ssh.PublicKey) string {
+	h := sha256.Sum256(pubKey.Marshal())
+	return hex.EncodeToString(h[:])
+}
+
+func signHostKeyProof(signer ssh.Signer, data []byte) (*ssh.Signature, error) {
+	// OpenSSH clients expect RSA proofs signed using a SHA-2 algorithm
+	if algoSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		return algoSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	}
+	return signer.Sign(rand.Reader, data)
+}
+
+func generateHostKey(keyType string) (crypto.PrivateKey, error) {
+	switch keyType {
+	case HostKeyTypeEd25519:
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, err
+	case HostKeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case HostKeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, util.NewValidationError(fmt.Sprintf("unsupported host key type %q, supported types: %v",
+			keyType, supportedHostKeyGen))
+	}
+}
+
+// loadPrivateKey loads a private key stored as plain PEM or encrypted using the configured KMS
+func loadPrivateKey(keyPath string) (ssh.Signer, error) {
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return ssh.ParsePrivateKey(content)
+	}
+	secret := kms.NewEmptySecret()
+	if err := json.Unmarshal(content, secret); err != nil {
+		return nil, err
+	}
+	if secret.IsEncrypted() {
+		if err := secret.Decrypt(); err != nil {
+			return nil, err
+		}
+	}
+	return ssh.ParsePrivateKey([]byte(secret.GetPayload()))
+}
+
+// writeEncryptedPrivateKey stores the provided private key encrypted using the configured KMS
+func writeEncryptedPrivateKey(keyPath string, privKey crypto.PrivateKey, additionalData string) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+	secret := kms.NewPlainSecret(string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyBytes,
+	})))
+	secret.SetAdditionalData(additionalData)
+	if err := secret.Encrypt(); err != nil {
+		return err
+	}
+	content, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath, content, 0600)
+}
+
+func advertiseHostKeys(sconn *ssh.ServerConn, connectionID string) {
+	payload := hostKeysManager.getAdvertisementPayload()
+	if len(payload) == 0 {
+		return
+	}
+	if _, _, err := sconn.SendRequest(hostKeysAdvertisementRequest, false, payload); err != nil {
+		logger.Debug(logSender, connectionID, "unable to advertise host keys: %v", err)
+	}
+}
+
+func handleGlobalRequests(reqs <-chan *ssh.Request, sessionID []byte, connectionID string) {
+	for req := range reqs {
+		if req.Type == hostKeysProveRequest {
+			response, err := hostKeysManager.prove(sessionID, req.Payload)
+			if err != nil {
+				logger.Debug(logSender, connectionID, "host keys prove request failed: %v", err)
+			}
+			if req.WantReply {
+				req.Reply(err == nil, response) //nolint:errcheck
+			}
+			continue
+		}
+		if req.WantReply {
+			req.Reply(false, nil) //nolint:errcheck
+		}
+	}
+}
+
+// GetHostKeys returns the active and advertised host keys
+func GetHostKeys() []HostKeyInfo {
+	return hostKeysManager.getKeys()
+}
+
+// GenerateHostKey generates a new host key of the specified type, the key is stored encrypted
+// using the configured KMS and it is advertised to the clients but not used for the key exchange
+// until it is promoted
+func GenerateHostKey(keyType string) (HostKeyInfo, error) {
+	return hostKeysManager.generateKey(keyType)
+}
+
+// PromoteHostKey marks the advertised host key with the specified ID as active
+func PromoteHostKey(id string) error {
+	if err := hostKeysManager.promoteKey(id); err != nil {
+		return err
+	}
+	hostKeysManager.updateServiceStatus()
+	return nil
+}
+
+// RetireHostKey retires the host key with the specified ID, it will no longer be used nor advertised
+func RetireHostKey(id string) error {
+	if err := hostKeysManager.retireKey(id); err != nil {
+		return err
+	}
+	hostKeysManager.updateServiceStatus()
+	return nil
+}
@@ -16,6 +16,14 @@ package sftpd
 
 import (
 	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +31,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -518,7 +529,7 @@ func TestSSHCommandErrors(t *testing.T) {
 	assert.Error(t, err, "ssh command must fail, we are requesting an invalid path")
 
 	cmd = sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: &connection,
 		args:       []string{"/../../testrepo"},
 	}
@@ -664,9 +675,9 @@ func TestCommandsWithExtensionsFilter(t *testing.T) {
 	assert.EqualError(t, err, common.ErrPermissionDenied.Error())
 
 	cmd = sshCommand{
-		command:    "rsync",
+		command:    "git-upload-pack",
 		connection: connection,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+		args:       []string{"/"},
 	}
 	_, err = cmd.getSystemCommand()
 	assert.EqualError(t, err, errUnsupportedConfig.Error())
@@ -853,55 +864,6 @@ func TestGitVirtualFolders(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestRsyncOptions(t *testing.T) {
-	permissions := make(map[string][]string)
-	permissions["/"] = []string{dataprovider.PermAny}
-	user := dataprovider.User{
-		BaseUser: sdk.BaseUser{
-			Permissions: permissions,
-			HomeDir:     os.TempDir(),
-		},
-	}
-	conn := &Connection{
-		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
-	}
-	sshCmd := sshCommand{
-		command:    "rsync",
-		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
-	}
-	cmd, err := sshCmd.getSystemCommand()
-	assert.NoError(t, err)
-	assert.True(t, util.Contains(cmd.cmd.Args, "--safe-links"),
-		"--safe-links must be added if the user has the create symlinks permission")
-
-	permissions["/"] = []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
-		dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete, dataprovider.PermRename}
-	user.Permissions = permissions
-
-	conn = &Connection{
-		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
-	}
-	sshCmd = sshCommand{
-		command:    "rsync",
-		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
-	}
-	cmd, err = sshCmd.getSystemCommand()
-	assert.NoError(t, err)
-	assert.True(t, util.Contains(cmd.cmd.Args, "--munge-links"),
-		"--munge-links must be added if the user has the create symlinks permission")
-
-	sshCmd.connection.User.VirtualFolders = append(sshCmd.connection.User.VirtualFolders, vfs.VirtualFolder{
-		BaseVirtualFolder: vfs.BaseVirtualFolder{
-			MappedPath: os.TempDir(),
-		},
-		VirtualPath: "/vdir",
-	})
-	_, err = sshCmd.getSystemCommand()
-	assert.EqualError(t, err, errUnsupportedConfig.Error())
-}
-
 func TestSystemCommandSizeForPath(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
@@ -1065,6 +1027,67 @@ func TestSystemCommandErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGitCommandErrors(t *testing.T) {
+	buf := make([]byte, 65535)
+	stdErrBuf := make([]byte, 65535)
+	mockSSHChannel := MockChannel{
+		Buffer:       bytes.NewBuffer(buf),
+		StdErrBuffer: bytes.NewBuffer(stdErrBuf),
+	}
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Permissions: permissions,
+			HomeDir:     filepath.Clean(os.TempDir()),
+		},
+	}
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, "", "", user),
+		channel:        &mockSSHChannel,
+	}
+	cmd := gitCommand{
+		sshCommand: sshCommand{
+			command:    gitUploadPackCmdName,
+			connection: conn,
+			args:       []string{},
+		},
+	}
+	err := cmd.handle()
+	assert.Error(t, err)
+	cmd.args = []string{"/missing_repo.git"}
+	err = cmd.handle()
+	assert.ErrorIs(t, err, errGitNoRepo)
+	assert.Contains(t, mockSSHChannel.StdErrBuffer.String(), "does not appear to be a git repository")
+
+	cmd.command = gitReceivePackCmdName
+	err = cmd.handle()
+	assert.EqualError(t, err, common.ErrPermissionDenied.Error())
+
+	cmd.connection.User.Permissions["/"] = []string{dataprovider.PermAny}
+	cmd.connection.User.QuotaFiles = 1
+	cmd.connection.User.UsedQuotaFiles = 2
+	err = cmd.handle()
+	assert.EqualError(t, err, common.ErrQuotaExceeded.Error())
+	cmd.connection.User.QuotaFiles = 0
+	cmd.connection.User.UsedQuotaFiles = 0
+	// the parent directory cannot be created
+	cmd.connection.User.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload,
+		dataprovider.PermUpload, dataprovider.PermOverwrite, dataprovider.PermDelete}
+	cmd.connection.User.Permissions["/missing_repo.git"] = []string{dataprovider.PermAny}
+	err = cmd.handle()
+	assert.ErrorIs(t, err, errGitNoRepo)
+
+	cmd.connection.User.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/missing_repo.git",
+			AllowedPatterns: []string{"*.jpg"},
+		},
+	}
+	err = cmd.handle()
+	assert.EqualError(t, err, errUnsupportedConfig.Error())
+}
+
 func TestCommandGetFsError(t *testing.T) {
 	user := dataprovider.User{
 		FsConfig: vfs.Filesystem{
@@ -1075,9 +1098,9 @@ func TestCommandGetFsError(t *testing.T) {
 		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
 	}
 	sshCmd := sshCommand{
-		command:    "rsync",
+		command:    "git-upload-pack",
 		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+		args:       []string{"/"},
 	}
 	_, err := sshCmd.getSystemCommand()
 	assert.Error(t, err)
@@ -1951,16 +1974,15 @@ func TestLoadModuli(t *testing.T) {
 }
 
 func TestLoadHostKeys(t *testing.T) {
-	serverConfig := &ssh.ServerConfig{}
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	err := c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = os.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1972,7 +1994,7 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
@@ -1988,16 +2010,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		err = c.checkAndLoadHostKeys(keysDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -2226,6 +2248,298 @@ func TestLoadRevokedUserCertsFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserCertificateAuthority(t *testing.T) {
+	ca := userCertificateAuthority{}
+	c := UserCA{
+		Enabled:         true,
+		KeyPath:         "",
+		DefaultValidity: 0,
+		MaxValidity:     10,
+	}
+	err := ca.load(c, configDir)
+	assert.Error(t, err)
+	c.DefaultValidity = 20
+	err = ca.load(c, configDir)
+	assert.Error(t, err)
+	c.DefaultValidity = 5
+	err = ca.load(c, configDir)
+	assert.Error(t, err)
+	c.KeyPath = filepath.Join(os.TempDir(), "test_user_ca_key")
+	err = ca.load(c, configDir)
+	assert.NoError(t, err)
+	caKey := ca.getPublicKey()
+	require.NotNil(t, caKey)
+	// the key is now loaded from the existing file
+	err = ca.load(c, configDir)
+	assert.NoError(t, err)
+	assert.Equal(t, caKey.Marshal(), ca.getPublicKey().Marshal())
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPubKey, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	pubKey := string(ssh.MarshalAuthorizedKey(sshPubKey))
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user_ca",
+		},
+	}
+	user.Filters.AllowedIP = []string{"192.168.1.0/24", "10.8.0.1/32"}
+	_, err = ca.signUserKey(&user, pubKey, 11)
+	assert.Error(t, err)
+	_, err = ca.signUserKey(&user, pubKey, -1)
+	assert.Error(t, err)
+	_, err = ca.signUserKey(&user, "invalid key", 0)
+	assert.Error(t, err)
+	cert, err := ca.signUserKey(&user, pubKey, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{user.Username}, cert.ValidPrincipals)
+	assert.Equal(t, uint32(ssh.UserCert), cert.CertType)
+	assert.Equal(t, "192.168.1.0/24,10.8.0.1/32", cert.CriticalOptions[sourceAddressCriticalOption])
+	assert.Equal(t, caKey.Marshal(), cert.SignatureKey.Marshal())
+	assert.InDelta(t, time.Now().Add(5*time.Minute).Unix(), int64(cert.ValidBefore), 5)
+	_, err = ca.signUserKey(&user, string(ssh.MarshalAuthorizedKey(cert)), 0)
+	assert.Error(t, err)
+	// the certificate validity cannot exceed the user expiration
+	user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Minute))
+	cert, err = ca.signUserKey(&user, pubKey, 10)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(2*time.Minute).Unix(), int64(cert.ValidBefore), 5)
+	user.ExpirationDate = util.GetTimeAsMsSinceEpoch(time.Now().Add(-2 * time.Minute))
+	_, err = ca.signUserKey(&user, pubKey, 0)
+	assert.Error(t, err)
+	user.ExpirationDate = 0
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodPublicKey}
+	_, err = ca.signUserKey(&user, pubKey, 0)
+	assert.Error(t, err)
+
+	err = os.WriteFile(c.KeyPath, []byte("not a json"), 0600)
+	assert.NoError(t, err)
+	err = ca.load(c, configDir)
+	assert.Error(t, err)
+	err = os.Remove(c.KeyPath)
+	assert.NoError(t, err)
+
+	c.Enabled = false
+	err = ca.load(c, configDir)
+	assert.NoError(t, err)
+	assert.Nil(t, ca.getPublicKey())
+	_, err = ca.signUserKey(&user, pubKey, 0)
+	assert.Error(t, err)
+}
+
+func TestHostKeysRotation(t *testing.T) {
+	keysDir := filepath.Join(os.TempDir(), "hostkeys_rotation")
+	err := os.MkdirAll(keysDir, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(keysDir)
+
+	activeKey := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
+	err = util.GenerateEd25519Keys(activeKey)
+	require.NoError(t, err)
+	privKey, err := generateHostKey(HostKeyTypeEd25519)
+	require.NoError(t, err)
+	encryptedKey := filepath.Join(keysDir, "encrypted_key")
+	err = writeEncryptedPrivateKey(encryptedKey, privKey, hostKeyAdditionalData)
+	require.NoError(t, err)
+	content, err := os.ReadFile(encryptedKey)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "PRIVATE KEY")
+
+	store := hostKeysStore{}
+	err = store.load(nil, []string{encryptedKey}, nil, "")
+	assert.Error(t, err)
+	err = store.load([]string{activeKey}, []string{encryptedKey}, nil, "")
+	require.NoError(t, err)
+	keys := store.getKeys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, HostKeyStatusActive, keys[0].Status)
+	assert.Equal(t, HostKeyStatusAdvertised, keys[1].Status)
+	assert.Equal(t, ssh.KeyAlgoED25519, keys[1].Type)
+	_, err = store.generateKey(HostKeyTypeEd25519)
+	assert.Error(t, err)
+	err = store.promoteKey(keys[1].ID)
+	assert.Error(t, err)
+	err = store.retireKey(keys[1].ID)
+	assert.Error(t, err)
+	// only the active keys are used for the key exchange
+	serverConfig := store.getServerConfig(&ssh.ServerConfig{})
+	assert.NotNil(t, serverConfig)
+	emptyStore := hostKeysStore{}
+	baseConfig := &ssh.ServerConfig{}
+	assert.Equal(t, baseConfig, emptyStore.getServerConfig(baseConfig))
+	// the ownership can be proved for all the advertised keys
+	sessionID := []byte("session id")
+	var payload []byte
+	for _, k := range store.keys {
+		payload = append(payload, ssh.Marshal(struct {
+			Key []byte
+		}{k.signer.PublicKey().Marshal()})...)
+	}
+	assert.Equal(t, payload, store.getAdvertisementPayload())
+	response, err := store.prove(sessionID, payload)
+	require.NoError(t, err)
+	for _, k := range store.keys {
+		var resp struct {
+			Signature []byte
+			Rest      []byte `ssh:"rest"`
+		}
+		err = ssh.Unmarshal(response, &resp)
+		require.NoError(t, err)
+		response = resp.Rest
+		sig := new(ssh.Signature)
+		err = ssh.Unmarshal(resp.Signature, sig)
+		require.NoError(t, err)
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, k.signer.PublicKey().Marshal()})
+		assert.NoError(t, k.signer.PublicKey().Verify(data, sig))
+	}
+	assert.Len(t, response, 0)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	unknownKey, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	_, err = store.prove(sessionID, ssh.Marshal(struct {
+		Key []byte
+	}{unknownKey.Marshal()}))
+	assert.Error(t, err)
+	_, err = store.prove(sessionID, []byte("invalid"))
+	assert.Error(t, err)
+	// rotation
+	rotationFile := filepath.Join(keysDir, "rotation.json")
+	err = store.load([]string{activeKey}, nil, nil, rotationFile)
+	require.NoError(t, err)
+	_, err = store.generateKey("unsupported")
+	assert.Error(t, err)
+	newKey, err := store.generateKey(HostKeyTypeEd25519)
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyStatusAdvertised, newKey.Status)
+	assert.Equal(t, keysDir, filepath.Dir(newKey.Path))
+	assert.FileExists(t, newKey.Path)
+	err = store.promoteKey("missing")
+	assert.Error(t, err)
+	err = store.retireKey("missing")
+	assert.Error(t, err)
+	err = store.promoteKey(newKey.ID)
+	require.NoError(t, err)
+	err = store.promoteKey(newKey.ID)
+	assert.Error(t, err)
+	keys = store.getKeys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, newKey.ID, keys[1].ID)
+	assert.Equal(t, HostKeyStatusActive, keys[1].Status)
+	err = store.retireKey(keys[0].ID)
+	require.NoError(t, err)
+	err = store.retireKey(newKey.ID)
+	assert.Error(t, err)
+	// the rotation state is restored on reload
+	err = store.load([]string{activeKey}, nil, nil, rotationFile)
+	require.NoError(t, err)
+	keys = store.getKeys()
+	require.Len(t, keys, 1)
+	assert.Equal(t, newKey.ID, keys[0].ID)
+	assert.Equal(t, HostKeyStatusActive, keys[0].Status)
+	assert.Equal(t, []string{activeKey}, store.retired)
+
+	err = os.WriteFile(rotationFile, []byte(`{"keys":[{"path":"`+activeKey+`","status":"unknown"}]}`), 0600)
+	require.NoError(t, err)
+	err = store.load([]string{activeKey}, nil, nil, rotationFile)
+	assert.Error(t, err)
+	err = os.WriteFile(rotationFile, []byte("not json"), 0600)
+	require.NoError(t, err)
+	err = store.load([]string{activeKey}, nil, nil, rotationFile)
+	assert.Error(t, err)
+}
+
+func TestSecurityKeyRequiredFlags(t *testing.T) {
+	certPerms := &ssh.Permissions{
+		CriticalOptions: map[string]string{"source-address": "127.0.0.1/8"},
+		Extensions:      map[string]string{"permit-pty": ""},
+	}
+	assert.Equal(t, certPerms, setSecurityKeyRequiredFlags(certPerms, 0))
+	perms := setSecurityKeyRequiredFlags(certPerms, ssh.SKFlagUserPresent|ssh.SKFlagUserVerified)
+	assert.Len(t, certPerms.Extensions, 1)
+	assert.Equal(t, certPerms.CriticalOptions, perms.CriticalOptions)
+	assert.Len(t, perms.Extensions, 2)
+	assert.Equal(t, "5", perms.Extensions[extSKRequiredFlags])
+	perms = setSecurityKeyRequiredFlags(nil, ssh.SKFlagUserPresent)
+	assert.Equal(t, "1", perms.Extensions[extSKRequiredFlags])
+
+	sig := &ssh.Signature{
+		Format: ssh.KeyAlgoSKED25519,
+		Rest: ssh.Marshal(struct {
+			Flags   byte
+			Counter uint32
+		}{ssh.SKFlagUserPresent, 1}),
+	}
+	assert.NoError(t, checkSecurityKeySignature(nil, sig))
+	assert.NoError(t, checkSecurityKeySignature(certPerms, sig))
+	assert.NoError(t, checkSecurityKeySignature(perms, sig))
+	perms.Extensions[extSKRequiredFlags] = "4"
+	assert.ErrorContains(t, checkSecurityKeySignature(perms, sig), "user verification")
+	perms.Extensions[extSKRequiredFlags] = "invalid"
+	assert.Error(t, checkSecurityKeySignature(perms, sig))
+	perms.Extensions[extSKRequiredFlags] = "1"
+	sig.Rest = nil
+	assert.Error(t, checkSecurityKeySignature(perms, sig))
+	sig.Format = ssh.KeyAlgoED25519
+	assert.Error(t, checkSecurityKeySignature(perms, sig))
+}
+
+func TestPublicKeyRestrictions(t *testing.T) {
+	assert.True(t, isSFTPForcedCommand("internal-sftp"))
+	assert.True(t, isSFTPForcedCommand("/usr/lib/openssh/sftp-server -l INFO"))
+	assert.False(t, isSFTPForcedCommand("scp -t /"))
+	assert.False(t, isSFTPForcedCommand(`"`))
+	assert.NoError(t, checkForcedCommand(""))
+	assert.NoError(t, checkForcedCommand("git-upload-pack '/repo.git'"))
+	assert.Error(t, checkForcedCommand(`"`))
+	assert.Error(t, checkForcedCommand("ls"))
+
+	r := getPublicKeyRestrictions(nil)
+	assert.False(t, r.isSFTPOnly())
+	assert.True(t, r.canUseSFTP())
+
+	options, err := dataprovider.ParsePublicKeyOptions([]string{`restrict`, `pty`, `COMMAND="sha1sum \"file name\""`,
+		`from="192.168.1.*,!192.168.1.2"`, `no-agent-forwarding`})
+	require.NoError(t, err)
+	assert.True(t, options.NoPortForwarding)
+	assert.False(t, options.NoPty)
+	assert.Equal(t, `sha1sum "file name"`, options.Command)
+	assert.True(t, options.IsSourceAllowed("192.168.1.1"))
+	assert.False(t, options.IsSourceAllowed("192.168.1.2"))
+	assert.False(t, options.IsSourceAllowed("10.8.0.1"))
+	assert.False(t, options.IsExpired())
+	perms := &ssh.Permissions{
+		Extensions: make(map[string]string),
+	}
+	setPublicKeyRestrictions(perms, &options)
+	r = getPublicKeyRestrictions(perms)
+	assert.Equal(t, options.Command, r.forcedCommand)
+	assert.True(t, r.noPortForwarding)
+	assert.False(t, r.noPty)
+	assert.False(t, r.isSFTPOnly())
+	assert.False(t, r.canUseSFTP())
+	var msg sshSubsystemExecMsg
+	err = ssh.Unmarshal(r.getForcedCommandPayload(), &msg)
+	assert.NoError(t, err)
+	assert.Equal(t, options.Command, msg.Command)
+
+	options, err = dataprovider.ParsePublicKeyOptions([]string{`expiry-time="202001021530Z"`})
+	require.NoError(t, err)
+	assert.True(t, options.IsExpired())
+	assert.Equal(t, time.Date(2020, 1, 2, 15, 30, 0, 0, time.UTC), options.ExpiryTime)
+	_, err = dataprovider.ParsePublicKeyOptions([]string{`from=""`})
+	assert.Error(t, err)
+	_, err = dataprovider.ParsePublicKeyOptions([]string{`from="[a"`})
+	assert.Error(t, err)
+	_, err = dataprovider.ParsePublicKeyOptions([]string{`command=""`})
+	assert.Error(t, err)
+}
+
 func TestMaxUserSessions(t *testing.T) {
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", dataprovider.User{
@@ -2298,3 +2612,1270 @@ func TestCanReadSymlink(t *testing.T) {
 	err = connection.canReadLink("/denied/file.txt")
 	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
 }
+
+func TestRestrictedShellHelpers(t *testing.T) {
+	flags, args, err := parseShellFlags([]string{"-rf", "dir", "--", "-file"}, "rRf")
+	assert.NoError(t, err)
+	assert.True(t, flags['r'])
+	assert.True(t, flags['f'])
+	assert.Equal(t, []string{"dir", "-file"}, args)
+	_, _, err = parseShellFlags([]string{"-x", "dir"}, "r")
+	assert.Error(t, err)
+
+	width, height, ok := parsePtyRequest(ssh.Marshal(&ptyRequestMsg{Term: "xterm", Columns: 100, Rows: 30}))
+	assert.True(t, ok)
+	assert.Equal(t, 100, width)
+	assert.Equal(t, 30, height)
+	_, _, ok = parsePtyRequest([]byte("invalid"))
+	assert.False(t, ok)
+	width, height, ok = parseWindowChange(ssh.Marshal(&windowChangeMsg{Columns: 120, Rows: 40}))
+	assert.True(t, ok)
+	assert.Equal(t, 120, width)
+	assert.Equal(t, 40, height)
+	_, _, ok = parseWindowChange(nil)
+	assert.False(t, ok)
+
+	assert.Equal(t, "ab", commonPrefix("abc", "abd"))
+	assert.Equal(t, "", commonPrefix("abc", "def"))
+
+	homeDir := filepath.Join(os.TempDir(), "shell_home")
+	err = os.MkdirAll(filepath.Join(homeDir, "subdir"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "subfile"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "shell_user",
+			HomeDir:  homeDir,
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	mockSSHChannel := MockChannel{
+		Buffer:       bytes.NewBuffer(nil),
+		StdErrBuffer: bytes.NewBuffer(nil),
+	}
+	connection := &Connection{
+		channel:        &mockSSHChannel,
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, "", "", user),
+	}
+	shell := newRestrictedShell(connection, 0, 0)
+	assert.Equal(t, int32(shellDefaultWidth), shell.width.Load())
+	assert.Equal(t, "/", shell.cwd)
+	assert.Equal(t, "/a/b", shell.getVirtualPath("a/b"))
+	assert.Equal(t, "/b", shell.getVirtualPath("../b"))
+	assert.Equal(t, "/c", shell.getVirtualPath("~/c"))
+
+	line, pos, ok := shell.autoComplete("mkd", 3, '\t')
+	assert.True(t, ok)
+	assert.Equal(t, "mkdir ", line)
+	assert.Equal(t, 6, pos)
+	_, _, ok = shell.autoComplete("mkd", 3, 'a')
+	assert.False(t, ok)
+	// ambiguous completion, nothing to add
+	_, _, ok = shell.autoComplete("ls sub", 6, '\t')
+	assert.False(t, ok)
+	line, _, ok = shell.autoComplete("ls subd", 7, '\t')
+	assert.True(t, ok)
+	assert.Equal(t, "ls subdir/", line)
+	line, _, ok = shell.autoComplete("cat subf", 8, '\t')
+	assert.True(t, ok)
+	assert.Equal(t, "cat subfile ", line)
+	_, _, ok = shell.autoComplete("cat missing/a", 13, '\t')
+	assert.False(t, ok)
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+// rsyncTestPipe is an unbounded in memory pipe, writes never block
+type rsyncTestPipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newRsyncTestPipe() *rsyncTestPipe {
+	p := &rsyncTestPipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *rsyncTestPipe) Read(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.buf.Len() == 0 {
+		if p.closed {
+			return 0, io.EOF
+		}
+		p.cond.Wait()
+	}
+	return p.buf.Read(data)
+}
+
+func (p *rsyncTestPipe) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := p.buf.Write(data)
+	p.cond.Broadcast()
+	return n, err
+}
+
+func (p *rsyncTestPipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+	return nil
+}
+
+type rsyncTestChannel struct {
+	in         *rsyncTestPipe
+	out        *rsyncTestPipe
+	stderr     bytes.Buffer
+	exitStatus atomic.Int32
+}
+
+func newRsyncTestChannel() *rsyncTestChannel {
+	c := &rsyncTestChannel{
+		in:  newRsyncTestPipe(),
+		out: newRsyncTestPipe(),
+	}
+	c.exitStatus.Store(-1)
+	return c
+}
+
+func (c *rsyncTestChannel) Read(data []byte) (int, error) {
+	return c.in.Read(data)
+}
+
+func (c *rsyncTestChannel) Write(data []byte) (int, error) {
+	return c.out.Write(data)
+}
+
+func (c *rsyncTestChannel) Close() error {
+	return c.out.Close()
+}
+
+func (c *rsyncTestChannel) CloseWrite() error {
+	return c.out.Close()
+}
+
+func (c *rsyncTestChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if name == "exit-status" {
+		var msg sshSubsystemExitStatus
+		if err := ssh.Unmarshal(payload, &msg); err == nil {
+			c.exitStatus.Store(int32(msg.Status))
+		}
+	}
+	return true, nil
+}
+
+func (c *rsyncTestChannel) Stderr() io.ReadWriter {
+	return &c.stderr
+}
+
+// rsyncTestDemux extracts the data from the multiplexed stream sent by the server
+type rsyncTestDemux struct {
+	r        io.Reader
+	pending  []byte
+	messages []string
+}
+
+func (d *rsyncTestDemux) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(d.r, header[:]); err != nil {
+			return 0, err
+		}
+		h := binary.LittleEndian.Uint32(header[:])
+		data := make([]byte, h&0xffffff)
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return 0, err
+		}
+		if h>>24 == rsyncMplexBase+rsyncMsgData {
+			d.pending = data
+		} else {
+			d.messages = append(d.messages, string(data))
+		}
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func startRsyncTestCommand(user dataprovider.User, args []string) (*rsyncTestChannel, chan error) {
+	channel := newRsyncTestChannel()
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSSH, "", "", user),
+		channel:        channel,
+	}
+	cmd := rsyncCommand{
+		sshCommand: sshCommand{
+			command:    rsyncCmdName,
+			connection: conn,
+			args:       args,
+		},
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cmd.handle()
+	}()
+	return channel, errCh
+}
+
+func rsyncTestHandshake(t *testing.T, channel *rsyncTestChannel) (*rsyncConn, *rsyncTestDemux, int32) {
+	return rsyncTestHandshakeVersion(t, channel, 30)
+}
+
+// rsyncTestHandshakeVersion acts as an rsync client announcing the given protocol version
+func rsyncTestHandshakeVersion(t *testing.T, channel *rsyncTestChannel, version uint32) (*rsyncConn, *rsyncTestDemux, int32) {
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[:4], version)
+	_, err := channel.in.Write(b[:4])
+	require.NoError(t, err)
+	_, err = io.ReadFull(channel.out, b[:])
+	require.NoError(t, err)
+	require.Equal(t, uint32(rsyncMaxProtocolVersion), binary.LittleEndian.Uint32(b[:4]))
+	demux := &rsyncTestDemux{r: channel.out}
+	conn := newRsyncConn(struct {
+		io.Reader
+		io.Writer
+	}{demux, channel.in})
+	return conn, demux, int32(binary.LittleEndian.Uint32(b[4:]))
+}
+
+// rsyncTestSend acts as an rsync client sending the given files
+func rsyncTestSend(t *testing.T, conn *rsyncConn, seed int32, entries []*rsyncFileEntry, contents map[string][]byte) {
+	codec := &rsyncFileListCodec{}
+	for _, e := range entries {
+		require.NoError(t, codec.encode(conn, e))
+	}
+	require.NoError(t, conn.writeByte(0))
+	require.NoError(t, conn.writeInt32(0))
+	require.NoError(t, conn.flush())
+	phase := 0
+	for phase < 2 {
+		idx, err := conn.readInt32()
+		require.NoError(t, err)
+		if idx == rsyncIndexDone {
+			phase++
+			require.NoError(t, conn.writeInt32(rsyncIndexDone))
+			require.NoError(t, conn.flush())
+			continue
+		}
+		require.True(t, idx >= 0 && int(idx) < len(entries))
+		var head rsyncSumHead
+		require.NoError(t, head.read(conn, true))
+		require.NoError(t, conn.writeInt32(idx))
+		require.NoError(t, head.write(conn, false))
+		require.NoError(t, rsyncSendDelta(conn, bytes.NewReader(contents[entries[idx].name]), &head, seed))
+		require.NoError(t, conn.flush())
+	}
+	goodbye, err := conn.readInt32()
+	require.NoError(t, err)
+	assert.Equal(t, int32(rsyncIndexDone), goodbye)
+}
+
+// rsyncTestReceive acts as an rsync client receiving files, basis contents are used for delta transfers
+func rsyncTestReceive(t *testing.T, conn *rsyncConn, seed int32, basis map[string][]byte) map[string][]byte {
+	require.NoError(t, conn.writeInt32(0))
+	require.NoError(t, conn.flush())
+	codec := &rsyncFileListCodec{}
+	var entries []*rsyncFileEntry
+	for {
+		e, err := codec.decode(conn)
+		require.NoError(t, err)
+		if e == nil {
+			break
+		}
+		entries = append(entries, e)
+	}
+	ioError, err := conn.readInt32()
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), ioError)
+	for idx, e := range entries {
+		if !e.isRegular() {
+			continue
+		}
+		head := &rsyncSumHead{}
+		if data, ok := basis[e.name]; ok {
+			head, err = rsyncComputeSums(bytes.NewReader(data), int64(len(data)), seed)
+			require.NoError(t, err)
+		}
+		require.NoError(t, conn.writeInt32(int32(idx)))
+		require.NoError(t, head.write(conn, true))
+	}
+	require.NoError(t, conn.writeInt32(rsyncIndexDone))
+	require.NoError(t, conn.flush())
+	result := make(map[string][]byte)
+	for {
+		idx, err := conn.readInt32()
+		require.NoError(t, err)
+		if idx == rsyncIndexDone {
+			break
+		}
+		var head rsyncSumHead
+		require.NoError(t, head.read(conn, false))
+		var buf bytes.Buffer
+		isValid, err := rsyncReceiveDelta(conn, &head, bytes.NewReader(basis[entries[idx].name]), &buf, seed)
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		result[entries[idx].name] = buf.Bytes()
+	}
+	require.NoError(t, conn.writeInt32(rsyncIndexDone))
+	require.NoError(t, conn.flush())
+	idx, err := conn.readInt32()
+	require.NoError(t, err)
+	assert.Equal(t, int32(rsyncIndexDone), idx)
+	for i := 0; i < 3; i++ {
+		_, err = conn.readLongint()
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.writeInt32(rsyncIndexDone))
+	require.NoError(t, conn.flush())
+	return result
+}
+
+func getRsyncTestUser(homeDir string) dataprovider.User {
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	return dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    "rsync_test_user",
+			Permissions: permissions,
+			HomeDir:     homeDir,
+		},
+	}
+}
+
+func TestRsyncParseArgs(t *testing.T) {
+	opts, err := parseRsyncArgs([]string{"--server", "-vlogDtpre.iLsfxC", "--delete", "--checksum-seed=12",
+		"--modify-window=-2", ".", "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, opts.verbose)
+	assert.True(t, opts.preserveLinks)
+	assert.True(t, opts.recursive)
+	assert.True(t, opts.preserveTimes)
+	assert.True(t, opts.deleteMode)
+	assert.False(t, opts.sender)
+	assert.Equal(t, int32(12), opts.checksumSeed)
+	assert.Equal(t, int64(2), opts.modifyWindow)
+	assert.Equal(t, []string{"dir"}, opts.paths)
+
+	opts, err = parseRsyncArgs([]string{"--server", "--sender", "-r", ".", "a", "b"})
+	require.NoError(t, err)
+	assert.True(t, opts.sender)
+	assert.Equal(t, []string{"a", "b"}, opts.paths)
+	assert.Equal(t, rsyncCompressNone, opts.compression)
+
+	opts, err = parseRsyncArgs([]string{"--server", "-rze.iLsfxC", "--compress-level=9", "--skip-compress=gz/zip",
+		".", "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, rsyncCompressZlib, opts.compression)
+	assert.Equal(t, 9, opts.compressLevel)
+	opts, err = parseRsyncArgs([]string{"--server", "-rz", "--new-compress", ".", "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, rsyncCompressZlibX, opts.compression)
+	assert.Equal(t, -1, opts.compressLevel)
+	opts, err = parseRsyncArgs([]string{"--server", "-r", "--compress-choice=zlib", ".", "dir"})
+	require.NoError(t, err)
+	assert.Equal(t, rsyncCompressZlib, opts.compression)
+
+	for _, args := range [][]string{
+		{"-r", ".", "dir"},
+		{"--server", "-rn", ".", "dir"},
+		{"--server", "--compress-choice=zstd", ".", "dir"},
+		{"--server", "--compress-level=10", ".", "dir"},
+		{"--server", "--checksum-seed=a", ".", "dir"},
+		{"--server", "--modify-window=a", ".", "dir"},
+		{"--server", "-r", "."},
+		{"--server", "-r", "dir"},
+		{"--server", "-r"},
+	} {
+		_, err = parseRsyncArgs(args)
+		assert.Error(t, err, "args %v should not be accepted", args)
+	}
+}
+
+func TestRsyncFilterRules(t *testing.T) {
+	c := rsyncCommand{}
+	for _, rule := range []string{"+ *.keep", "- *.tmp", "- /top", "- cache/", "- a/*/c", "- **/deep/*.log", "logs[0-9]"} {
+		filter, err := newRsyncFilterRule(rule)
+		require.NoError(t, err)
+		c.filters = append(c.filters, filter)
+	}
+	assert.True(t, c.isExcluded("file.tmp", false))
+	assert.True(t, c.isExcluded("dir/file.tmp", false))
+	assert.False(t, c.isExcluded("dir/file.keep", false))
+	assert.False(t, c.isExcluded("file.txt", false))
+	assert.True(t, c.isExcluded("top", true))
+	assert.False(t, c.isExcluded("dir/top", true))
+	assert.True(t, c.isExcluded("dir/cache", true))
+	assert.False(t, c.isExcluded("dir/cache", false))
+	assert.True(t, c.isExcluded("a/b/c", false))
+	assert.True(t, c.isExcluded("x/a/b/c", false))
+	assert.False(t, c.isExcluded("a/b/d/c", false))
+	assert.True(t, c.isExcluded("x/y/deep/f.log", false))
+	assert.True(t, c.isExcluded("logs1", false))
+	assert.False(t, c.isExcluded("logsa", false))
+
+	assert.Equal(t, `[^/]*\.txt`, rsyncPatternToRegexp("*.txt"))
+	assert.Equal(t, `[^a]`, rsyncPatternToRegexp("[!a]"))
+	assert.Equal(t, `\[a`, rsyncPatternToRegexp("[a"))
+	assert.Equal(t, `\*`, rsyncPatternToRegexp(`\*`))
+}
+
+func TestRsyncLinks(t *testing.T) {
+	assert.True(t, rsyncIsSafeLink("a/b/link", "../file"))
+	assert.True(t, rsyncIsSafeLink("link", "dir/file"))
+	assert.False(t, rsyncIsSafeLink("link", "../file"))
+	assert.False(t, rsyncIsSafeLink("a/link", "../../file"))
+	assert.False(t, rsyncIsSafeLink("a/link", "/etc/passwd"))
+	assert.False(t, rsyncIsSafeLink("a/link", ""))
+
+	assert.Equal(t, "file", rsyncRelativePath("/", "/file"))
+	assert.Equal(t, "../b/file", rsyncRelativePath("/a/c", "/a/b/file"))
+	assert.Equal(t, "..", rsyncRelativePath("/a/b", "/a"))
+	assert.Equal(t, ".", rsyncRelativePath("/a", "/a"))
+
+	_, err := rsyncCleanName("../file")
+	assert.Error(t, err)
+	_, err = rsyncCleanName("/file")
+	assert.Error(t, err)
+	name, err := rsyncCleanName("a//b/")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b", name)
+}
+
+func TestRsyncFileListCodec(t *testing.T) {
+	var buf bytes.Buffer
+	conn := newRsyncConn(&buf)
+	opts := rsyncFileListOptions{
+		preserveLinks:  true,
+		preserveUID:    true,
+		preserveGID:    true,
+		alwaysChecksum: true,
+	}
+	longName := strings.Repeat("a", 300)
+	entries := []*rsyncFileEntry{
+		{name: ".", mode: rsyncModeDir | 0755, mtime: 100, topDir: true},
+		{name: "dir", mode: rsyncModeDir | 0700, mtime: 100, uid: 1000, gid: 1000},
+		{name: "dir/file1", mode: rsyncModeRegular | 0644, mtime: 200, size: 1 << 33, uid: 1000, gid: 1000,
+			sum: bytes.Repeat([]byte{1}, rsyncSumLength)},
+		{name: "dir/file2", mode: rsyncModeRegular | 0644, mtime: 200, size: 10, uid: 1000, gid: 1000,
+			sum: bytes.Repeat([]byte{2}, rsyncSumLength)},
+		{name: "dir/" + longName, mode: rsyncModeRegular | 0600, mtime: 300, uid: 1001, gid: 1000,
+			sum: bytes.Repeat([]byte{3}, rsyncSumLength)},
+		{name: "link", mode: rsyncModeSymlink | 0777, mtime: 300, link: "dir/file1", uid: 1001, gid: 1000},
+	}
+	encoder := &rsyncFileListCodec{opts: opts}
+	for _, e := range entries {
+		require.NoError(t, encoder.encode(conn, e))
+	}
+	require.NoError(t, conn.writeByte(0))
+	require.NoError(t, conn.flush())
+
+	decoder := &rsyncFileListCodec{opts: opts}
+	for _, e := range entries {
+		decoded, err := decoder.decode(conn)
+		require.NoError(t, err)
+		require.NotNil(t, decoded)
+		assert.Equal(t, e.name, decoded.name)
+		assert.Equal(t, e.mode, decoded.mode)
+		assert.Equal(t, e.mtime, decoded.mtime)
+		assert.Equal(t, e.size, decoded.size)
+		assert.Equal(t, e.uid, decoded.uid)
+		assert.Equal(t, e.gid, decoded.gid)
+		assert.Equal(t, e.link, decoded.link)
+		if e.isRegular() {
+			assert.Equal(t, e.sum, decoded.sum)
+		}
+	}
+	decoded, err := decoder.decode(conn)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded)
+}
+
+func TestRsyncDelta(t *testing.T) {
+	seed := int32(1234)
+	basis := make([]byte, 100000)
+	for i := range basis {
+		basis[i] = byte(i * 7 % 251)
+	}
+	// insert and remove some data, most blocks should match
+	data := append([]byte("prefix"), basis[:30000]...)
+	data = append(data, basis[31000:]...)
+	data = append(data, []byte("suffix")...)
+
+	head, err := rsyncComputeSums(bytes.NewReader(basis), int64(len(basis)), seed)
+	require.NoError(t, err)
+	assert.Equal(t, int32(rsyncBlockSize), head.blength)
+
+	var buf bytes.Buffer
+	conn := newRsyncConn(&buf)
+	require.NoError(t, rsyncSendDelta(conn, bytes.NewReader(data), head, seed))
+	require.NoError(t, conn.flush())
+	assert.Less(t, buf.Len(), len(data)/2)
+
+	var result bytes.Buffer
+	isValid, err := rsyncReceiveDelta(conn, head, bytes.NewReader(basis), &result, seed)
+	require.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Equal(t, data, result.Bytes())
+	// a different basis must be detected
+	buf.Reset()
+	require.NoError(t, rsyncSendDelta(conn, bytes.NewReader(data), head, seed))
+	require.NoError(t, conn.flush())
+	result.Reset()
+	isValid, err = rsyncReceiveDelta(conn, head, bytes.NewReader(data), &result, seed)
+	require.NoError(t, err)
+	assert.False(t, isValid)
+
+	assert.Equal(t, int32(rsyncBlockSize), rsyncGetBlockLength(1000))
+	assert.Equal(t, int32(rsyncMaxBlockSize), rsyncGetBlockLength(1<<40))
+}
+
+func TestRsyncCompressedDelta(t *testing.T) {
+	seed := int32(4321)
+	data := make([]byte, 200000)
+	_, err := rand.Read(data[:100000])
+	require.NoError(t, err)
+	copy(data[100000:], bytes.Repeat([]byte("compressible data "), 6000))
+
+	var buf bytes.Buffer
+	conn := newRsyncConn(&buf)
+	require.NoError(t, conn.enableCompression(rsyncCompressZlib, 6))
+	head, err := rsyncComputeSums(bytes.NewReader(data), int64(len(data)), seed)
+	require.NoError(t, err)
+	require.NoError(t, rsyncSendDelta(conn, bytes.NewReader(data), head, seed))
+	require.NoError(t, conn.flush())
+	assert.Less(t, buf.Len(), 150000)
+	var result bytes.Buffer
+	isValid, err := rsyncReceiveDelta(conn, head, nil, &result, seed)
+	require.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Equal(t, data, result.Bytes())
+	// empty files are sent without deflated data
+	require.NoError(t, rsyncSendDelta(conn, bytes.NewReader(nil), &rsyncSumHead{}, seed))
+	require.NoError(t, conn.flush())
+	assert.Equal(t, byte(rsyncTokenEnd), buf.Bytes()[0])
+	result.Reset()
+	isValid, err = rsyncReceiveDelta(conn, &rsyncSumHead{}, nil, &result, seed)
+	require.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Equal(t, 0, result.Len())
+
+	// compressed stream with matched blocks, as sent by an rsync client
+	basis := make([]byte, 3*rsyncBlockSize)
+	_, err = rand.Read(basis)
+	require.NoError(t, err)
+	head, err = rsyncComputeSums(bytes.NewReader(basis), int64(len(basis)), seed)
+	require.NoError(t, err)
+	block := func(idx int) []byte {
+		return basis[idx*rsyncBlockSize : (idx+1)*rsyncBlockSize : (idx+1)*rsyncBlockSize]
+	}
+	for _, compression := range []int{rsyncCompressZlib, rsyncCompressZlibX} {
+		var expected, history []byte
+		var stream bytes.Buffer
+		writeDeflated := func(literal []byte) {
+			var compressed bytes.Buffer
+			w, err := flate.NewWriterDict(&compressed, flate.BestCompression, history)
+			require.NoError(t, err)
+			_, err = w.Write(literal)
+			require.NoError(t, err)
+			require.NoError(t, w.Flush())
+			out := bytes.TrimSuffix(compressed.Bytes(), rsyncSyncMarker)
+			stream.Write([]byte{rsyncTokenDeflated | byte(len(out)>>8), byte(len(out))})
+			stream.Write(out)
+			expected = append(expected, literal...)
+			history = append(history, literal...)
+		}
+		matchBlocks := func(indexes ...int) {
+			for _, idx := range indexes {
+				expected = append(expected, block(idx)...)
+				if compression == rsyncCompressZlib {
+					history = append(history, block(idx)...)
+				}
+			}
+		}
+		writeDeflated(bytes.Repeat([]byte("prefix data "), 10))
+		stream.WriteByte(rsyncTokenRel)
+		matchBlocks(0)
+		stream.Write([]byte{rsyncTokenRunRel | 1, 1, 0})
+		matchBlocks(1, 2)
+		stream.WriteByte(rsyncTokenLong)
+		stream.Write([]byte{0, 0, 0, 0})
+		matchBlocks(0)
+		// the literal data refer to the matched blocks if they are in the history
+		writeDeflated(append(block(1), block(0)...))
+		stream.Write([]byte{rsyncTokenRunLong, 1, 0, 0, 0, 1, 0})
+		matchBlocks(1, 2)
+		stream.WriteByte(rsyncTokenEnd)
+		stream.Write(newRsyncFileHasher(seed).Sum(nil))
+
+		buf.Reset()
+		conn := newRsyncConn(&buf)
+		require.NoError(t, conn.enableCompression(compression, flate.DefaultCompression))
+		streamData := stream.Bytes()
+		_, err = buf.Write(streamData)
+		require.NoError(t, err)
+		result.Reset()
+		isValid, err := rsyncReceiveDelta(conn, head, bytes.NewReader(basis), &result, seed)
+		require.NoError(t, err)
+		// the file checksum in the stream does not match
+		assert.False(t, isValid)
+		assert.Equal(t, expected, result.Bytes())
+		// now send the right file checksum
+		buf.Reset()
+		h := newRsyncFileHasher(seed)
+		h.Write(expected) //nolint:errcheck
+		_, err = buf.Write(append(streamData[:len(streamData)-rsyncSumLength], h.Sum(nil)...))
+		require.NoError(t, err)
+		result.Reset()
+		isValid, err = rsyncReceiveDelta(conn, head, bytes.NewReader(basis), &result, seed)
+		require.NoError(t, err)
+		assert.True(t, isValid)
+		assert.Equal(t, expected, result.Bytes())
+		// invalid deflated data
+		buf.Reset()
+		_, err = buf.Write([]byte{rsyncTokenDeflated, 4, 0xff, 0xff, 0xff, 0xff, rsyncTokenEnd})
+		require.NoError(t, err)
+		_, err = rsyncReceiveDelta(conn, head, nil, io.Discard, seed)
+		assert.ErrorIs(t, err, errRsyncProtocol)
+		// invalid block index
+		buf.Reset()
+		_, err = buf.Write([]byte{rsyncTokenRel | 10, rsyncTokenEnd})
+		require.NoError(t, err)
+		_, err = rsyncReceiveDelta(conn, head, nil, io.Discard, seed)
+		assert.ErrorIs(t, err, errRsyncProtocol)
+	}
+	assert.Error(t, newRsyncConn(&buf).enableCompression(rsyncCompressZlib, 20))
+}
+
+func TestRsyncUpload(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "rsync_upload")
+	require.NoError(t, os.MkdirAll(filepath.Join(homeDir, "dest"), os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	extraFile := filepath.Join(homeDir, "dest", "extra.txt")
+	require.NoError(t, os.WriteFile(extraFile, []byte("extra"), 0644))
+	user := getRsyncTestUser(homeDir)
+	mtime := int32(1600000000)
+	entries := []*rsyncFileEntry{
+		{name: ".", mode: rsyncModeDir | 0755, mtime: mtime, topDir: true},
+		{name: "a.txt", mode: rsyncModeRegular | 0644, mtime: mtime},
+		{name: "sub", mode: rsyncModeDir | 0755, mtime: mtime},
+		{name: "sub/b.txt", mode: rsyncModeRegular | 0644, mtime: mtime},
+	}
+	contents := map[string][]byte{
+		"a.txt":     bytes.Repeat([]byte("rsync test content "), 1000),
+		"sub/b.txt": []byte("small file"),
+	}
+	for _, e := range entries {
+		e.size = int64(len(contents[e.name]))
+	}
+	args := []string{"--server", "-vlrte.iLsfxC", "--delete", ".", "dest/"}
+	channel, errCh := startRsyncTestCommand(user, args)
+	conn, demux, seed := rsyncTestHandshake(t, channel)
+	// filter list
+	require.NoError(t, conn.writeInt32(0))
+	rsyncTestSend(t, conn, seed, entries, contents)
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, int32(0), channel.exitStatus.Load())
+	assert.Contains(t, demux.messages, "deleting extra.txt\n")
+	assert.NoFileExists(t, extraFile)
+	for name, data := range contents {
+		p := filepath.Join(homeDir, "dest", filepath.FromSlash(name))
+		content, err := os.ReadFile(p)
+		assert.NoError(t, err)
+		assert.Equal(t, data, content)
+		info, err := os.Stat(p)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(mtime), info.ModTime().Unix())
+		}
+	}
+	// update a file using the existing one as basis
+	contents["a.txt"] = append([]byte("new "), contents["a.txt"][100:]...)
+	entries[1].size = int64(len(contents["a.txt"]))
+	entries[1].mtime = mtime + 10
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "-rt", ".", "dest"})
+	conn, _, seed = rsyncTestHandshake(t, channel)
+	rsyncTestSend(t, conn, seed, entries, contents)
+	assert.NoError(t, <-errCh)
+	content, err := os.ReadFile(filepath.Join(homeDir, "dest", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, contents["a.txt"], content)
+	// single file upload to a missing destination
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "-t", ".", "single.txt"})
+	conn, _, seed = rsyncTestHandshake(t, channel)
+	rsyncTestSend(t, conn, seed, []*rsyncFileEntry{entries[3]}, map[string][]byte{"sub/b.txt": contents["sub/b.txt"]})
+	assert.NoError(t, <-errCh)
+	content, err = os.ReadFile(filepath.Join(homeDir, "single.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, contents["sub/b.txt"], content)
+	// compressed upload
+	contents["a.txt"] = append([]byte("compressed "), contents["a.txt"]...)
+	entries[1].size = int64(len(contents["a.txt"]))
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "-rtz", ".", "dest"})
+	conn, _, seed = rsyncTestHandshake(t, channel)
+	require.NoError(t, conn.enableCompression(rsyncCompressZlib, flate.DefaultCompression))
+	rsyncTestSend(t, conn, seed, entries, contents)
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, int32(0), channel.exitStatus.Load())
+	content, err = os.ReadFile(filepath.Join(homeDir, "dest", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, contents["a.txt"], content)
+}
+
+func TestRsyncUploadPermissions(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "rsync_upload_perms")
+	require.NoError(t, os.MkdirAll(homeDir, os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	user := getRsyncTestUser(homeDir)
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermCreateDirs}
+	entries := []*rsyncFileEntry{
+		{name: ".", mode: rsyncModeDir | 0755, topDir: true},
+		{name: "file.txt", mode: rsyncModeRegular | 0644, size: 4},
+	}
+	channel, errCh := startRsyncTestCommand(user, []string{"--server", "-r", ".", "dest"})
+	conn, demux, seed := rsyncTestHandshake(t, channel)
+	rsyncTestSend(t, conn, seed, entries, map[string][]byte{"file.txt": []byte("test")})
+	assert.ErrorIs(t, <-errCh, errRsyncPartialTransfer)
+	assert.Equal(t, int32(1), channel.exitStatus.Load())
+	assert.DirExists(t, filepath.Join(homeDir, "dest"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "dest", "file.txt"))
+	require.NotEmpty(t, demux.messages)
+	assert.Contains(t, demux.messages[0], common.ErrPermissionDenied.Error())
+}
+
+func TestRsyncDownload(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "rsync_download")
+	require.NoError(t, os.MkdirAll(filepath.Join(homeDir, "src", "sub"), os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	contents := map[string][]byte{
+		"a.txt":     bytes.Repeat([]byte("download content "), 2000),
+		"sub/b.txt": []byte("small file"),
+		"skip.tmp":  []byte("excluded"),
+	}
+	for name, data := range contents {
+		require.NoError(t, os.WriteFile(filepath.Join(homeDir, "src", filepath.FromSlash(name)), data, 0644))
+	}
+	user := getRsyncTestUser(homeDir)
+
+	channel, errCh := startRsyncTestCommand(user, []string{"--server", "--sender", "-rte.iLsfxC", ".", "src/"})
+	conn, _, seed := rsyncTestHandshake(t, channel)
+	// exclude the *.tmp files
+	rule := "- *.tmp"
+	require.NoError(t, conn.writeInt32(int32(len(rule))))
+	require.NoError(t, conn.write([]byte(rule)))
+	received := rsyncTestReceive(t, conn, seed, nil)
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, int32(0), channel.exitStatus.Load())
+	assert.Len(t, received, 2)
+	assert.Equal(t, contents["a.txt"], received["a.txt"])
+	assert.Equal(t, contents["sub/b.txt"], received["sub/b.txt"])
+	// delta download without trailing slash
+	basis := map[string][]byte{
+		"src/a.txt": append([]byte("old content"), contents["a.txt"][500:]...),
+	}
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "--sender", "-r", ".", "src"})
+	conn, _, seed = rsyncTestHandshake(t, channel)
+	received = rsyncTestReceive(t, conn, seed, basis)
+	assert.NoError(t, <-errCh)
+	assert.Len(t, received, 3)
+	assert.Equal(t, contents["a.txt"], received["src/a.txt"])
+	// compressed delta download, the files are sent as literal data
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "--sender", "-rz", "--new-compress", ".", "src"})
+	conn, _, seed = rsyncTestHandshake(t, channel)
+	require.NoError(t, conn.enableCompression(rsyncCompressZlibX, flate.DefaultCompression))
+	received = rsyncTestReceive(t, conn, seed, basis)
+	assert.NoError(t, <-errCh)
+	assert.Len(t, received, 3)
+	assert.Equal(t, contents["a.txt"], received["src/a.txt"])
+	assert.Equal(t, contents["sub/b.txt"], received["src/sub/b.txt"])
+	// download denied
+	user.Permissions["/src/sub"] = []string{dataprovider.PermListItems}
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "--sender", "-r", ".", "src/"})
+	conn, demux, seed := rsyncTestHandshake(t, channel)
+	received = rsyncTestReceive(t, conn, seed, nil)
+	assert.ErrorIs(t, <-errCh, errRsyncPartialTransfer)
+	assert.Equal(t, int32(1), channel.exitStatus.Load())
+	assert.Len(t, received, 2)
+	assert.NotContains(t, received, "sub/b.txt")
+	assert.NotEmpty(t, demux.messages)
+}
+
+func TestRsyncProtocolNegotiation(t *testing.T) {
+	for _, version := range []int32{27, 28, 29, 30, 31, 40} {
+		protocol, err := negotiateRsyncProtocol(version)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(27), protocol)
+	}
+	for _, version := range []int32{-1, 0, 20, 26} {
+		_, err := negotiateRsyncProtocol(version)
+		assert.Error(t, err)
+	}
+
+	homeDir := filepath.Join(os.TempDir(), "rsync_protocol")
+	require.NoError(t, os.MkdirAll(homeDir, os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	user := getRsyncTestUser(homeDir)
+	entries := []*rsyncFileEntry{
+		{name: "file.txt", mode: rsyncModeRegular | 0644, mtime: 1600000000},
+	}
+	// rsync 2.6.x announces 28 or 29, 3.0.x 30 and 3.1.x or later 31, all of them
+	// must be downgraded to the implemented protocol
+	for _, version := range []uint32{27, 28, 29, 30, 31} {
+		content := []byte(fmt.Sprintf("content for protocol %d", version))
+		entries[0].size = int64(len(content))
+		fileName := fmt.Sprintf("file%d.txt", version)
+		channel, errCh := startRsyncTestCommand(user, []string{"--server", "-t", ".", fileName})
+		conn, _, seed := rsyncTestHandshakeVersion(t, channel, version)
+		rsyncTestSend(t, conn, seed, entries, map[string][]byte{"file.txt": content})
+		assert.NoError(t, <-errCh)
+		assert.Equal(t, int32(0), channel.exitStatus.Load())
+		data, err := os.ReadFile(filepath.Join(homeDir, fileName))
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+
+		channel, errCh = startRsyncTestCommand(user, []string{"--server", "--sender", "-t", ".", fileName})
+		conn, _, seed = rsyncTestHandshakeVersion(t, channel, version)
+		received := rsyncTestReceive(t, conn, seed, nil)
+		assert.NoError(t, <-errCh)
+		assert.Equal(t, content, received[fileName])
+	}
+}
+
+func TestRsyncUnsupportedOptions(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "rsync_unsupported")
+	user := getRsyncTestUser(homeDir)
+	channel, errCh := startRsyncTestCommand(user, []string{"--server", "-rn", ".", "dest"})
+	assert.Error(t, <-errCh)
+	assert.Equal(t, int32(1), channel.exitStatus.Load())
+	assert.Contains(t, channel.stderr.String(), "unsupported option -n")
+	// old protocol version
+	channel, errCh = startRsyncTestCommand(user, []string{"--server", "-r", ".", "dest"})
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], 26)
+	_, err := channel.in.Write(b[:])
+	assert.NoError(t, err)
+	assert.Error(t, <-errCh)
+	assert.Equal(t, int32(1), channel.exitStatus.Load())
+	assert.Contains(t, channel.stderr.String(), "protocol version 26 is not supported")
+}
+
+type checkFileTestChannel struct {
+	in  *bytes.Buffer
+	out *bytes.Buffer
+}
+
+func (c *checkFileTestChannel) Read(data []byte) (int, error) {
+	return c.in.Read(data)
+}
+
+func (c *checkFileTestChannel) Write(data []byte) (int, error) {
+	return c.out.Write(data)
+}
+
+func (c *checkFileTestChannel) Close() error {
+	return nil
+}
+
+func TestCheckFileExtension(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "checkfile")
+	require.NoError(t, os.MkdirAll(homeDir, os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	content := bytes.Repeat([]byte("check-file"), 100)
+	err := os.WriteFile(filepath.Join(homeDir, "file"), content, os.ModePerm)
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "checkfile_user",
+			HomeDir:  homeDir,
+			Permissions: map[string][]string{
+				"/":    {dataprovider.PermAny},
+				"/sub": {dataprovider.PermListItems},
+			},
+		},
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+	}
+	ch := &checkFileTestChannel{
+		in:  new(bytes.Buffer),
+		out: new(bytes.Buffer),
+	}
+	c := newExtensionsChannel(ch, connection)
+	// the version packet must advertise the check-file extension, the server can write it in chunks
+	version := marshalSFTPPacket(sftpPacketVersion, binary.BigEndian.AppendUint32(nil, 3))
+	n, err := c.Write(version[:5])
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 0, ch.out.Len())
+	_, err = c.Write(version[5:])
+	assert.NoError(t, err)
+	packet := ch.out.Bytes()
+	assert.Equal(t, byte(sftpPacketVersion), packet[4])
+	assert.Contains(t, string(packet), checkFileExtension)
+	assert.Contains(t, string(packet), "sha256")
+	ch.out.Reset()
+
+	checkFileRequest := func(id uint32, extension, target, algorithms string, offset, length uint64, blockSize uint32) []byte {
+		payload := binary.BigEndian.AppendUint32(nil, id)
+		payload = appendSFTPString(payload, extension)
+		payload = appendSFTPString(payload, target)
+		payload = appendSFTPString(payload, algorithms)
+		payload = binary.BigEndian.AppendUint64(payload, offset)
+		payload = binary.BigEndian.AppendUint64(payload, length)
+		payload = binary.BigEndian.AppendUint32(payload, blockSize)
+		return marshalSFTPPacket(sftpPacketExtended, payload)
+	}
+	readReply := func() (byte, uint32, []byte) {
+		// the hashes are computed asynchronously
+		c.wg.Wait()
+		packet := ch.out.Bytes()
+		ch.out.Reset()
+		require.GreaterOrEqual(t, len(packet), 9)
+		return packet[4], binary.BigEndian.Uint32(packet[5:]), packet[9:]
+	}
+
+	ch.in.Write(checkFileRequest(1, checkFileNameExtension, "file", "unknown,sha256,md5", 0, 0, 0)) //nolint:errcheck
+	// any other packet must be forwarded
+	stat := marshalSFTPPacket(17, appendSFTPString(binary.BigEndian.AppendUint32(nil, 2), "/file"))
+	ch.in.Write(stat) //nolint:errcheck
+	buf := make([]byte, 1024)
+	n, err = c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, stat, buf[:n])
+	packetType, id, data := readReply()
+	assert.Equal(t, byte(sftpPacketExtendedReply), packetType)
+	assert.Equal(t, uint32(1), id)
+	name, data, ok := unmarshalSFTPString(data)
+	assert.True(t, ok)
+	assert.Equal(t, checkFileExtension, string(name))
+	algo, data, ok := unmarshalSFTPString(data)
+	assert.True(t, ok)
+	assert.Equal(t, "sha256", string(algo))
+	sum := sha256.Sum256(content)
+	assert.Equal(t, sum[:], data)
+	// hash by blocks
+	ch.in.Write(checkFileRequest(3, checkFileNameExtension, "/file", "md5", 100, 600, 256)) //nolint:errcheck
+	ch.in.Write(stat)                                                                       //nolint:errcheck
+	_, err = c.Read(buf)
+	assert.NoError(t, err)
+	packetType, id, data = readReply()
+	assert.Equal(t, byte(sftpPacketExtendedReply), packetType)
+	assert.Equal(t, uint32(3), id)
+	_, data, _ = unmarshalSFTPString(data)
+	_, data, _ = unmarshalSFTPString(data)
+	var expected []byte
+	for _, block := range [][]byte{content[100:356], content[356:612], content[612:700]} {
+		h := md5.Sum(block)
+		expected = append(expected, h[:]...)
+	}
+	assert.Equal(t, expected, data)
+	// check-file-handle requires a handle returned for an open request
+	openPayload := appendSFTPString(binary.BigEndian.AppendUint32(nil, 4), "file")
+	openPayload = binary.BigEndian.AppendUint32(openPayload, 1)
+	open := marshalSFTPPacket(sftpPacketOpen, binary.BigEndian.AppendUint32(openPayload, 0))
+	ch.in.Write(open) //nolint:errcheck
+	n, err = c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, open, buf[:n])
+	_, err = c.Write(marshalSFTPPacket(sftpPacketHandle, appendSFTPString(binary.BigEndian.AppendUint32(nil, 4), "1")))
+	assert.NoError(t, err)
+	ch.out.Reset()
+	ch.in.Write(checkFileRequest(5, checkFileHandleExtension, "1", "sha1", 0, 0, 0)) //nolint:errcheck
+	ch.in.Write(stat)                                                                //nolint:errcheck
+	_, err = c.Read(buf)
+	assert.NoError(t, err)
+	packetType, id, data = readReply()
+	assert.Equal(t, byte(sftpPacketExtendedReply), packetType)
+	assert.Equal(t, uint32(5), id)
+	_, data, _ = unmarshalSFTPString(data)
+	_, data, _ = unmarshalSFTPString(data)
+	sha1Sum := sha1.Sum(content)
+	assert.Equal(t, sha1Sum[:], data)
+	// after close the handle is no longer valid and the hashing using it is canceled
+	c.mu.Lock()
+	h := c.handles["1"]
+	c.mu.Unlock()
+	require.NotNil(t, h)
+	ch.in.Write(marshalSFTPPacket(sftpPacketClose, appendSFTPString(binary.BigEndian.AppendUint32(nil, 6), "1"))) //nolint:errcheck
+	_, err = c.Read(buf)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, h.ctx.Err(), context.Canceled)
+	req, err := parseCheckFileRequest(append(appendSFTPString(appendSFTPString(nil, "/file"), "md5"), make([]byte, 20)...))
+	require.NoError(t, err)
+	_, _, err = connection.checkFile(h.ctx, req.target, req)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	assert.Len(t, connection.GetTransfers(), 0)
+
+	errorRequests := []struct {
+		request []byte
+		code    uint32
+	}{
+		{checkFileRequest(7, checkFileHandleExtension, "1", "sha1", 0, 0, 0), sftpStatusNoSuchFile},
+		{checkFileRequest(8, checkFileNameExtension, "missing", "sha1", 0, 0, 0), sftpStatusNoSuchFile},
+		{checkFileRequest(9, checkFileNameExtension, "file", "sha3", 0, 0, 0), sftpStatusOpUnsupported},
+		{checkFileRequest(10, checkFileNameExtension, "file", "md5", 0, 0, 10), sftpStatusBadMessage},
+		{checkFileRequest(11, checkFileNameExtension, "file", "md5", 2000, 0, 0), sftpStatusFailure},
+		{checkFileRequest(12, checkFileNameExtension, "/sub/file", "md5", 0, 0, 0), sftpStatusPermissionDenied},
+		{checkFileRequest(13, checkFileNameExtension, "/", "md5", 0, 0, 0), sftpStatusFailure},
+	}
+	for _, r := range errorRequests {
+		ch.in.Write(r.request) //nolint:errcheck
+		ch.in.Write(stat)      //nolint:errcheck
+		_, err = c.Read(buf)
+		assert.NoError(t, err)
+		packetType, _, data = readReply()
+		assert.Equal(t, byte(sftpPacketStatus), packetType)
+		if assert.GreaterOrEqual(t, len(data), 4) {
+			assert.Equal(t, r.code, binary.BigEndian.Uint32(data))
+		}
+	}
+	// the file is read as a download, so the transfer quota is checked.
+	// The user does not exist and so the used quota cannot be checked
+	connection.User.TotalDataTransfer = 1
+	ch.in.Write(checkFileRequest(14, checkFileNameExtension, "file", "md5", 0, 0, 0)) //nolint:errcheck
+	ch.in.Write(stat)                                                                 //nolint:errcheck
+	_, err = c.Read(buf)
+	assert.NoError(t, err)
+	packetType, id, data = readReply()
+	assert.Equal(t, byte(sftpPacketStatus), packetType)
+	assert.Equal(t, uint32(14), id)
+	if assert.GreaterOrEqual(t, len(data), 4) {
+		assert.Equal(t, uint32(sftpStatusFailure), binary.BigEndian.Uint32(data))
+	}
+	connection.User.TotalDataTransfer = 0
+	assert.Len(t, connection.GetTransfers(), 0)
+	// invalid packet length
+	ch.in.Write(binary.BigEndian.AppendUint32(nil, maxSFTPPacketLength+1)) //nolint:errcheck
+	_, err = c.Read(buf)
+	assert.Error(t, err)
+	// closing the channel cancels the requests in progress
+	assert.NoError(t, c.Close())
+	assert.ErrorIs(t, c.ctx.Err(), context.Canceled)
+}
+
+func TestSFTPChannelExtensions(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "extensions")
+	require.NoError(t, os.MkdirAll(homeDir, os.ModePerm))
+	defer os.RemoveAll(homeDir)
+
+	content := bytes.Repeat([]byte("copy-data"), 10000)
+	err := os.WriteFile(filepath.Join(homeDir, "file"), content, os.ModePerm)
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "extensions_user",
+			HomeDir:  homeDir,
+			UID:      1000,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: "primary",
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", user),
+	}
+	ch := &checkFileTestChannel{
+		in:  new(bytes.Buffer),
+		out: new(bytes.Buffer),
+	}
+	c := newExtensionsChannel(ch, connection)
+	stat := marshalSFTPPacket(17, appendSFTPString(binary.BigEndian.AppendUint32(nil, 1), "/file"))
+	buf := make([]byte, 1024)
+	sendRequest := func(packet []byte) (byte, uint32, []byte) {
+		ch.in.Write(packet) //nolint:errcheck
+		ch.in.Write(stat)   //nolint:errcheck
+		n, err := c.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, stat, buf[:n])
+		c.wg.Wait()
+		reply := ch.out.Bytes()
+		ch.out.Reset()
+		require.GreaterOrEqual(t, len(reply), 9)
+		return reply[4], binary.BigEndian.Uint32(reply[5:]), reply[9:]
+	}
+	extendedRequest := func(id uint32, extension string, payload []byte) []byte {
+		data := binary.BigEndian.AppendUint32(nil, id)
+		data = appendSFTPString(data, extension)
+		return marshalSFTPPacket(sftpPacketExtended, append(data, payload...))
+	}
+	openRequest := func(id uint32, name string, pflags uint32) []byte {
+		data := appendSFTPString(binary.BigEndian.AppendUint32(nil, id), name)
+		data = binary.BigEndian.AppendUint32(data, pflags)
+		// no attributes
+		data = binary.BigEndian.AppendUint32(data, 0)
+		return marshalSFTPPacket(sftpPacketOpen, data)
+	}
+	handleReply := func(id uint32, handle string) {
+		_, err := c.Write(marshalSFTPPacket(sftpPacketHandle, appendSFTPString(binary.BigEndian.AppendUint32(nil, id), handle)))
+		require.NoError(t, err)
+		ch.out.Reset()
+	}
+	openHandle := func(id uint32, name, handle string, openFn func(*sftp.Request) error) {
+		ch.in.Write(openRequest(id, name, 1)) //nolint:errcheck
+		_, err := c.Read(buf)
+		require.NoError(t, err)
+		require.NoError(t, openFn(sftp.NewRequest("Open", name)))
+		handleReply(id, handle)
+	}
+	checkStatus := func(packetType byte, data []byte, code uint32) {
+		assert.Equal(t, byte(sftpPacketStatus), packetType)
+		if assert.GreaterOrEqual(t, len(data), 4) {
+			assert.Equal(t, code, binary.BigEndian.Uint32(data))
+		}
+	}
+
+	packetType, id, data := sendRequest(extendedRequest(2, limitsExtension, nil))
+	assert.Equal(t, byte(sftpPacketExtendedReply), packetType)
+	assert.Equal(t, uint32(2), id)
+	if assert.Len(t, data, 32) {
+		assert.Equal(t, uint64(maxSFTPPacketLength), binary.BigEndian.Uint64(data))
+		assert.Equal(t, uint64(maxSFTPReadLength), binary.BigEndian.Uint64(data[8:]))
+	}
+
+	packetType, id, data = sendRequest(extendedRequest(3, expandPathExtension, appendSFTPString(nil, "~/dir/../file")))
+	assert.Equal(t, byte(sftpPacketName), packetType)
+	assert.Equal(t, uint32(3), id)
+	count, data, _ := unmarshalSFTPUint32(data)
+	assert.Equal(t, uint32(1), count)
+	name, _, _ := unmarshalSFTPString(data)
+	assert.Equal(t, "/file", string(name))
+	packetType, _, data = sendRequest(extendedRequest(4, expandPathExtension, appendSFTPString(nil, "~root/file")))
+	checkStatus(packetType, data, sftpStatusNoSuchFile)
+
+	ids := binary.BigEndian.AppendUint32(nil, 1000)
+	ids = binary.BigEndian.AppendUint32(ids, 0)
+	payload := appendSFTPString(nil, string(ids))
+	payload = appendSFTPString(payload, string(binary.BigEndian.AppendUint32(nil, 0)))
+	packetType, id, data = sendRequest(extendedRequest(5, usersGroupsByIDExtension, payload))
+	assert.Equal(t, byte(sftpPacketExtendedReply), packetType)
+	assert.Equal(t, uint32(5), id)
+	users, data, ok := unmarshalSFTPString(data)
+	assert.True(t, ok)
+	groups, _, ok := unmarshalSFTPString(data)
+	assert.True(t, ok)
+	username, users, _ := unmarshalSFTPString(users)
+	assert.Equal(t, user.Username, string(username))
+	username, _, _ = unmarshalSFTPString(users)
+	assert.Empty(t, string(username))
+	groupname, _, _ := unmarshalSFTPString(groups)
+	assert.Empty(t, string(groupname))
+	packetType, _, data = sendRequest(extendedRequest(6, usersGroupsByIDExtension, appendSFTPString(nil, "abc")))
+	checkStatus(packetType, data, sftpStatusBadMessage)
+
+	openHandle(7, "file", "1", func(r *sftp.Request) error {
+		_, err := connection.Fileread(r)
+		return err
+	})
+	openHandle(8, "copy", "2", func(r *sftp.Request) error {
+		r.Flags = 26 // write,create,truncate
+		_, err := connection.Filewrite(r)
+		return err
+	})
+	copyDataRequest := func(id uint32, readHandle string, readOffset, readLength uint64, writeHandle string,
+		writeOffset uint64,
+	) []byte {
+		payload := appendSFTPString(nil, readHandle)
+		payload = binary.BigEndian.AppendUint64(payload, readOffset)
+		payload = binary.BigEndian.AppendUint64(payload, readLength)
+		payload = appendSFTPString(payload, writeHandle)
+		payload = binary.BigEndian.AppendUint64(payload, writeOffset)
+		return extendedRequest(id, copyDataExtension, payload)
+	}
+	packetType, id, data = sendRequest(copyDataRequest(9, "1", 0, 0, "2", 0))
+	checkStatus(packetType, data, sftpStatusOK)
+	assert.Equal(t, uint32(9), id)
+	packetType, _, data = sendRequest(extendedRequest(10, fsyncExtension, appendSFTPString(nil, "2")))
+	checkStatus(packetType, data, sftpStatusOK)
+	// copying past the end of the source file
+	packetType, _, data = sendRequest(copyDataRequest(11, "1", uint64(len(content)-10), 20, "2", uint64(len(content))))
+	checkStatus(packetType, data, sftpStatusEOF)
+	packetType, _, data = sendRequest(copyDataRequest(12, "1", 0, 0, "1", 0))
+	checkStatus(packetType, data, sftpStatusOpUnsupported)
+	packetType, _, data = sendRequest(copyDataRequest(13, "1", 0, 0, "3", 0))
+	checkStatus(packetType, data, sftpStatusNoSuchFile)
+	packetType, _, data = sendRequest(extendedRequest(14, copyDataExtension, appendSFTPString(nil, "1")))
+	checkStatus(packetType, data, sftpStatusBadMessage)
+	packetType, _, data = sendRequest(extendedRequest(15, fsyncExtension, appendSFTPString(nil, "3")))
+	checkStatus(packetType, data, sftpStatusNoSuchFile)
+
+	// the transfers are associated to the handles using the open request ids,
+	// even if the replies are not sent in the same order of the requests
+	ch.in.Write(openRequest(20, "file", 1))   //nolint:errcheck
+	ch.in.Write(openRequest(21, "copy2", 26)) //nolint:errcheck
+	// open requests without a valid mode never reach the open handlers
+	ch.in.Write(openRequest(22, "file", 0)) //nolint:errcheck
+	for i := 0; i < 3; i++ {
+		_, err = c.Read(buf)
+		require.NoError(t, err)
+	}
+	_, err = connection.Fileread(sftp.NewRequest("Open", "/file"))
+	require.NoError(t, err)
+	r := sftp.NewRequest("Open", "/copy2")
+	r.Flags = 26
+	_, err = connection.Filewrite(r)
+	require.NoError(t, err)
+	handleReply(21, "4")
+	handleReply(20, "3")
+	_, err = c.Write(marshalSFTPPacket(sftpPacketStatus, marshalSFTPStatus(22, sftp.ErrSSHFxFailure)))
+	require.NoError(t, err)
+	ch.out.Reset()
+	for handle, virtualPath := range map[string]string{"3": "/file", "4": "/copy2"} {
+		h, err := c.acquireHandle(handle)
+		if assert.NoError(t, err) {
+			assert.Equal(t, virtualPath, h.transfer.GetVirtualPath())
+			c.releaseHandle(h)
+		}
+	}
+	assert.Len(t, connection.openRequestIDs, 0)
+	assert.Len(t, connection.openedTransfers, 0)
+
+	for _, handle := range []string{"1", "2", "3", "4"} {
+		h, err := c.acquireHandle(handle)
+		require.NoError(t, err)
+		// a close request waits for the extended requests using the handle
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+
+			ch.in.Write(marshalSFTPPacket(sftpPacketClose, appendSFTPString(binary.BigEndian.AppendUint32(nil, 16), handle))) //nolint:errcheck
+			_, err := c.Read(buf)
+			assert.NoError(t, err)
+		}()
+		select {
+		case <-closed:
+			assert.Fail(t, "close request forwarded with an extended request in progress")
+		case <-time.After(100 * time.Millisecond):
+		}
+		c.releaseHandle(h)
+		<-closed
+		assert.NoError(t, h.transfer.Close())
+		_, err = c.acquireHandle(handle)
+		assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+	}
+	copied, err := os.ReadFile(filepath.Join(homeDir, "copy"))
+	assert.NoError(t, err)
+	assert.Equal(t, append(content, content[len(content)-10:]...), copied)
+}
@@ -0,0 +1,244 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	extForcedCommand    = "sftpgo_forced_command"
+	extNoPortForwarding = "sftpgo_no_port_forwarding"
+	extNoPty            = "sftpgo_no_pty"
+	extSKRequiredFlags  = "sftpgo_sk_required_flags"
+	// forced command restricting a public key to the SFTP subsystem
+	sftpForcedCommand = "internal-sftp"
+)
+
+// partialAuthKeyTimeout defines how long the options for a public key accepted
+// as first authentication step are kept waiting for the next step
+const partialAuthKeyTimeout = 5 * time.Minute
+
+var partialAuthKeys = partialAuthKeysStore{
+	keys: make(map[string]partialAuthKey),
+}
+
+func init() {
+	dataprovider.SetForcedCommandValidator(checkForcedCommand)
+}
+
+// partialAuthKey stores the options for a public key accepted with partial
+// success, they will be applied once the next authentication step succeeds
+type partialAuthKey struct {
+	username    string
+	fingerprint string
+	options     dataprovider.PublicKeyOptions
+	addedAt     time.Time
+}
+
+// partialAuthKeysStore keeps the partial authenticated public keys, keyed by SSH session ID
+type partialAuthKeysStore struct {
+	sync.Mutex
+	keys map[string]partialAuthKey
+}
+
+func (s *partialAuthKeysStore) add(sessionID string, key partialAuthKey) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, k := range s.keys {
+		if time.Since(k.addedAt) > partialAuthKeyTimeout {
+			delete(s.keys, id)
+		}
+	}
+	key.addedAt = time.Now()
+	s.keys[sessionID] = key
+}
+
+// get returns and removes the key for the specified session and username
+func (s *partialAuthKeysStore) get(sessionID, username string) (partialAuthKey, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := s.keys[sessionID]
+	if !ok {
+		return key, false
+	}
+	delete(s.keys, sessionID)
+	if key.username != username || time.Since(key.addedAt) > partialAuthKeyTimeout {
+		return key, false
+	}
+	return key, true
+}
+
+// applyPartialAuthKey sets the restrictions for the public key accepted as first
+// authentication step, if any, and updates its last use
+func applyPartialAuthKey(conn ssh.ConnMetadata, user *dataprovider.User, perms *ssh.Permissions) {
+	key, ok := partialAuthKeys.get(hex.EncodeToString(conn.SessionID()), conn.User())
+	if !ok {
+		return
+	}
+	setPublicKeyRestrictions(perms, &key.options)
+	dataprovider.UpdatePublicKeyLastUse(user, key.fingerprint)
+}
+
+// publicKeyRestrictions defines the restrictions, set using authorized_keys options,
+// for a connection authenticated using a public key
+type publicKeyRestrictions struct {
+	forcedCommand    string
+	noPortForwarding bool
+	noPty            bool
+}
+
+// isSFTPOnly returns true if the forced command restricts the connection to the SFTP subsystem.
+// "internal-sftp" and the OpenSSH "sftp-server" binary are both accepted
+func (r *publicKeyRestrictions) isSFTPOnly() bool {
+	if r.forcedCommand == "" {
+		return false
+	}
+	return isSFTPForcedCommand(r.forcedCommand)
+}
+
+func (r *publicKeyRestrictions) canUseSFTP() bool {
+	return r.forcedCommand == "" || r.isSFTPOnly()
+}
+
+func (r *publicKeyRestrictions) getForcedCommandPayload() []byte {
+	return ssh.Marshal(sshSubsystemExecMsg{Command: r.forcedCommand})
+}
+
+func isSFTPForcedCommand(command string) bool {
+	name, _, err := parseCommandPayload(command)
+	if err != nil {
+		return false
+	}
+	return name == sftpForcedCommand || path.Base(name) == "sftp-server"
+}
+
+func checkForcedCommand(command string) error {
+	if command == "" || isSFTPForcedCommand(command) {
+		return nil
+	}
+	name, _, err := parseCommandPayload(command)
+	if err != nil {
+		return fmt.Errorf("invalid forced command %q: %w", command, err)
+	}
+	if !util.Contains(supportedSSHCommands, name) {
+		return fmt.Errorf("unsupported forced command %q", command)
+	}
+	return nil
+}
+
+func checkPublicKeyOptions(user *dataprovider.User, pubKey ssh.PublicKey, ipAddr string) (dataprovider.PublicKeyOptions, error) {
+	options, err := user.CheckPublicKeyOptions(pubKey.Marshal(), ipAddr)
+	if err != nil {
+		return options, err
+	}
+	return options, checkForcedCommand(options.Command)
+}
+
+func setPublicKeyRestrictions(perms *ssh.Permissions, options *dataprovider.PublicKeyOptions) {
+	if options.Command != "" {
+		perms.Extensions[extForcedCommand] = options.Command
+	}
+	if options.NoPortForwarding {
+		perms.Extensions[extNoPortForwarding] = "1"
+	}
+	if options.NoPty {
+		perms.Extensions[extNoPty] = "1"
+	}
+}
+
+// getSecurityKeyRequiredFlags returns the flags that the signatures made using
+// the given public key must assert, 0 means no requirements
+func getSecurityKeyRequiredFlags(user *dataprovider.User, pubKey ssh.PublicKey) byte {
+	if !dataprovider.IsSecurityKey(pubKey) {
+		return 0
+	}
+	var flags byte
+	if user.Filters.RequireUserPresence {
+		flags |= ssh.SKFlagUserPresent
+	}
+	if user.Filters.RequireUserVerification {
+		flags |= ssh.SKFlagUserVerified
+	}
+	return flags
+}
+
+// setSecurityKeyRequiredFlags returns a copy of the given permissions with the
+// required security key flags, they are checked once the signature is verified.
+// The given permissions could be the ones embedded in a certificate, so they are
+// never modified
+func setSecurityKeyRequiredFlags(perms *ssh.Permissions, flags byte) *ssh.Permissions {
+	if flags == 0 {
+		return perms
+	}
+	result := &ssh.Permissions{
+		Extensions: make(map[string]string),
+	}
+	if perms != nil {
+		result.CriticalOptions = perms.CriticalOptions
+		for k, v := range perms.Extensions {
+			result.Extensions[k] = v
+		}
+	}
+	result.Extensions[extSKRequiredFlags] = strconv.Itoa(int(flags))
+	return result
+}
+
+// checkSecurityKeySignature verifies that the signature asserts the security
+// key flags required for the user
+func checkSecurityKeySignature(perms *ssh.Permissions, sig *ssh.Signature) error {
+	if perms == nil || perms.Extensions[extSKRequiredFlags] == "" {
+		return nil
+	}
+	required, err := strconv.ParseUint(perms.Extensions[extSKRequiredFlags], 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid required security key flags %q: %w", perms.Extensions[extSKRequiredFlags], err)
+	}
+	flags, err := ssh.ParseSKSignatureFlags(sig)
+	if err != nil {
+		return err
+	}
+	if flags&ssh.SKFlagUserPresent == 0 && byte(required)&ssh.SKFlagUserPresent != 0 {
+		return errors.New("the security key signature does not assert the user presence")
+	}
+	if flags&ssh.SKFlagUserVerified == 0 && byte(required)&ssh.SKFlagUserVerified != 0 {
+		return errors.New("the security key signature does not assert the user verification")
+	}
+	return nil
+}
+
+func getPublicKeyRestrictions(perms *ssh.Permissions) publicKeyRestrictions {
+	if perms == nil {
+		return publicKeyRestrictions{}
+	}
+	return publicKeyRestrictions{
+		forcedCommand:    perms.Extensions[extForcedCommand],
+		noPortForwarding: perms.Extensions[extNoPortForwarding] != "",
+		noPty:            perms.Extensions[extNoPty] != "",
+	}
+}
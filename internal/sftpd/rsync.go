@@ -0,0 +1,1477 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const rsyncCmdName = "rsync"
+
+var (
+	errRsyncPartialTransfer = errors.New("some files could not be transferred")
+	errRsyncChecksum        = errors.New("checksum mismatch")
+)
+
+// rsyncOptions defines the supported options received from the rsync client
+type rsyncOptions struct {
+	isServer        bool
+	sender          bool
+	verbose         int
+	recursive       bool
+	dirs            bool
+	preserveLinks   bool
+	copyLinks       bool
+	preserveUID     bool
+	preserveGID     bool
+	preserveDevices bool
+	preserveTimes   bool
+	omitDirTimes    bool
+	preservePerms   bool
+	alwaysChecksum  bool
+	ignoreTimes     bool
+	sizeOnly        bool
+	update          bool
+	wholeFile       bool
+	ignoreExisting  bool
+	existingOnly    bool
+	numericIDs      bool
+	deleteMode      bool
+	deleteExcluded  bool
+	pruneEmptyDirs  bool
+	modifyWindow    int64
+	checksumSeed    int32
+	compression     int
+	compressLevel   int
+	paths           []string
+}
+
+func (o *rsyncOptions) parseShortOptions(options string) error {
+	for idx := 0; idx < len(options); idx++ {
+		switch options[idx] {
+		case 'v':
+			o.verbose++
+		case 'r':
+			o.recursive = true
+		case 'd':
+			o.dirs = true
+		case 'l':
+			o.preserveLinks = true
+		case 'L':
+			o.copyLinks = true
+		case 'o':
+			o.preserveUID = true
+		case 'g':
+			o.preserveGID = true
+		case 'D':
+			o.preserveDevices = true
+		case 't':
+			o.preserveTimes = true
+		case 'O':
+			o.omitDirTimes = true
+		case 'p':
+			o.preservePerms = true
+		case 'c':
+			o.alwaysChecksum = true
+		case 'I':
+			o.ignoreTimes = true
+		case 'u':
+			o.update = true
+		case 'W':
+			o.wholeFile = true
+		case 'm':
+			o.pruneEmptyDirs = true
+		case 'z':
+			if o.compression == rsyncCompressNone {
+				o.compression = rsyncCompressZlib
+			}
+		case 'q', 'x', 'S', 'k', 'K', 'E', 'J', 'h', 'i':
+			// accepted and ignored
+		case 'e':
+			// the remaining characters are the client capabilities, they are
+			// ignored for the supported protocol version
+			return nil
+		default:
+			return fmt.Errorf("unsupported option -%c", options[idx])
+		}
+	}
+	return nil
+}
+
+func (o *rsyncOptions) parseLongOption(option string) error {
+	name, value, _ := strings.Cut(option, "=")
+	switch name {
+	case "server":
+		o.isServer = true
+	case "sender":
+		o.sender = true
+	case "delete", "delete-before", "delete-during", "delete-after", "delete-delay":
+		o.deleteMode = true
+	case "delete-excluded":
+		o.deleteMode = true
+		o.deleteExcluded = true
+	case "ignore-existing":
+		o.ignoreExisting = true
+	case "existing", "ignore-non-existing":
+		o.existingOnly = true
+	case "size-only":
+		o.sizeOnly = true
+	case "ignore-times":
+		o.ignoreTimes = true
+	case "numeric-ids":
+		o.numericIDs = true
+	case "omit-dir-times":
+		o.omitDirTimes = true
+	case "checksum-seed":
+		seed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid checksum seed %q", value)
+		}
+		o.checksumSeed = int32(seed)
+	case "modify-window":
+		window, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid modify window %q", value)
+		}
+		if window < 0 {
+			window = -window
+		}
+		o.modifyWindow = window
+	case "compress", "old-compress":
+		o.compression = rsyncCompressZlib
+	case "new-compress":
+		o.compression = rsyncCompressZlibX
+	case "compress-choice", "zc":
+		switch value {
+		case "zlib":
+			o.compression = rsyncCompressZlib
+		case "zlibx":
+			o.compression = rsyncCompressZlibX
+		case "none":
+			o.compression = rsyncCompressNone
+		default:
+			return fmt.Errorf("unsupported compression %q", value)
+		}
+	case "compress-level", "zl":
+		level, err := strconv.Atoi(value)
+		if err != nil || level < flate.NoCompression || level > flate.BestCompression {
+			return fmt.Errorf("invalid compression level %q", value)
+		}
+		o.compressLevel = level
+	case "partial", "inplace", "timeout", "contimeout", "bwlimit", "safe-links", "munge-links", "log-format",
+		"out-format", "no-implied-dirs", "ignore-errors", "force", "fake-super", "omit-link-times", "skip-compress":
+		// accepted and ignored
+	default:
+		return fmt.Errorf("unsupported option --%s", name)
+	}
+	return nil
+}
+
+// parseRsyncArgs parses the arguments sent by an rsync client started in server mode,
+// the options are followed by a "." argument and then by the paths
+func parseRsyncArgs(args []string) (rsyncOptions, error) {
+	opts := rsyncOptions{
+		compressLevel: flate.DefaultCompression,
+	}
+	for idx, arg := range args {
+		switch {
+		case arg == ".":
+			if !opts.isServer {
+				return opts, errors.New("only the server mode is supported")
+			}
+			opts.paths = args[idx+1:]
+			if len(opts.paths) == 0 {
+				return opts, errors.New("no path specified")
+			}
+			return opts, nil
+		case strings.HasPrefix(arg, "--"):
+			if err := opts.parseLongOption(arg[2:]); err != nil {
+				return opts, err
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			if err := opts.parseShortOptions(arg[1:]); err != nil {
+				return opts, err
+			}
+		default:
+			return opts, fmt.Errorf("unexpected argument %q", arg)
+		}
+	}
+	return opts, errors.New("no path specified")
+}
+
+// rsyncFilterRule is an include/exclude rule received from the client
+type rsyncFilterRule struct {
+	include      bool
+	dirOnly      bool
+	basenameOnly bool
+	re           *regexp.Regexp
+}
+
+func newRsyncFilterRule(rule string) (rsyncFilterRule, error) {
+	var result rsyncFilterRule
+	pattern := rule
+	if strings.HasPrefix(rule, "+ ") {
+		result.include = true
+		pattern = rule[2:]
+	} else if strings.HasPrefix(rule, "- ") {
+		pattern = rule[2:]
+	}
+	if len(pattern) > 1 && strings.HasSuffix(pattern, "/") {
+		result.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	var expr string
+	switch {
+	case strings.HasPrefix(pattern, "/"):
+		expr = "^" + rsyncPatternToRegexp(pattern[1:]) + "$"
+	case strings.Contains(pattern, "/") || strings.Contains(pattern, "**"):
+		expr = "(^|/)" + rsyncPatternToRegexp(pattern) + "$"
+	default:
+		result.basenameOnly = true
+		expr = "^" + rsyncPatternToRegexp(pattern) + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return result, fmt.Errorf("invalid filter rule %q: %w", rule, err)
+	}
+	result.re = re
+	return result, nil
+}
+
+func (r *rsyncFilterRule) matches(name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.basenameOnly {
+		return r.re.MatchString(path.Base(name))
+	}
+	return r.re.MatchString(name)
+}
+
+// rsyncPatternToRegexp converts an rsync wildcard pattern to a regular expression
+func rsyncPatternToRegexp(pattern string) string {
+	var sb strings.Builder
+	for idx := 0; idx < len(pattern); idx++ {
+		ch := pattern[idx]
+		switch ch {
+		case '*':
+			if idx+1 < len(pattern) && pattern[idx+1] == '*' {
+				sb.WriteString(".*")
+				idx++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[idx+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(string(ch)))
+				continue
+			}
+			class := pattern[idx+1 : idx+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			idx += end + 1
+		case '\\':
+			if idx+1 < len(pattern) {
+				idx++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[idx])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return sb.String()
+}
+
+// rsyncIsSafeLink returns true if the symlink target is relative and
+// does not point outside the transfer root
+func rsyncIsSafeLink(name, target string) bool {
+	if target == "" || path.IsAbs(target) {
+		return false
+	}
+	resolved := path.Join(path.Dir(name), target)
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
+// rsyncRelativePath returns target relative to the base directory, both must be absolute paths
+func rsyncRelativePath(base, target string) string {
+	var baseParts, targetParts []string
+	if trimmed := strings.Trim(base, "/"); trimmed != "" {
+		baseParts = strings.Split(trimmed, "/")
+	}
+	if trimmed := strings.Trim(target, "/"); trimmed != "" {
+		targetParts = strings.Split(trimmed, "/")
+	}
+	common := 0
+	for common < len(baseParts) && common < len(targetParts) && baseParts[common] == targetParts[common] {
+		common++
+	}
+	parts := make([]string, 0, len(baseParts)-common+len(targetParts)-common)
+	for idx := common; idx < len(baseParts); idx++ {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, targetParts[common:]...)
+	if len(parts) == 0 {
+		return "."
+	}
+	return strings.Join(parts, "/")
+}
+
+// rsyncFile allows to read a file using the io.ReaderAt interface for any storage backend
+type rsyncFile struct {
+	file     vfs.File
+	reader   *pipeat.PipeReaderAt
+	cancelFn func()
+}
+
+func (f *rsyncFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.file != nil {
+		return f.file.ReadAt(p, off)
+	}
+	return f.reader.ReadAt(p, off)
+}
+
+func (f *rsyncFile) Close() error {
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+	} else if f.reader != nil {
+		err = f.reader.Close()
+	}
+	if f.cancelFn != nil {
+		f.cancelFn()
+	}
+	return err
+}
+
+// rsyncTransferWriter writes sequentially to an upload transfer.
+// Write errors are tracked within the transfer and so they are
+// returned when the transfer is closed
+type rsyncTransferWriter struct {
+	t      *transfer
+	offset int64
+	err    error
+}
+
+func (w *rsyncTransferWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+	n, err := w.t.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return len(p), nil
+}
+
+// rsyncCommand implements the rsync protocol on top of the virtual filesystem.
+// If the client requested the --sender option the server sends the files,
+// otherwise it receives them. The receiver runs the generator, that requests
+// the files to transfer, in a separate goroutine
+type rsyncCommand struct {
+	sshCommand
+	opts       rsyncOptions
+	conn       *rsyncConn
+	protocol   int32
+	seed       int32
+	filters    []rsyncFilterRule
+	files      []*rsyncFileEntry
+	ioError    bool
+	hasErrors  atomic.Bool
+	destPath   string
+	singleFile bool
+	children   map[string]map[string]bool
+}
+
+func (c *rsyncCommand) handle() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handle rsync command: %#v stack trace: %v", r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+	}()
+	if err := common.Connections.Add(c.connection); err != nil {
+		logger.Info(logSender, "", "unable to add rsync connection: %v", err)
+		return err
+	}
+	defer common.Connections.Remove(c.connection.GetID())
+
+	c.connection.Log(logger.LevelDebug, "handle rsync command, args: %v user: %v", c.args, c.connection.User.Username)
+	opts, err := parseRsyncArgs(c.args)
+	if err != nil {
+		c.connection.Log(logger.LevelInfo, "unsupported rsync command, args: %v, err: %v", c.args, err)
+		c.sendStderr(err)
+		c.sendExitStatus(err)
+		return err
+	}
+	c.opts = opts
+	c.conn = newRsyncConn(c.connection.channel)
+	if err = c.conn.enableCompression(c.opts.compression, c.opts.compressLevel); err != nil {
+		c.sendStderr(err)
+		c.sendExitStatus(err)
+		return err
+	}
+	if err = c.handshake(); err == nil {
+		if c.opts.sender {
+			err = c.handleSender()
+		} else {
+			err = c.handleReceiver()
+		}
+	}
+	if err != nil {
+		c.conn.writeMessage(rsyncMsgErrorXfer, fmt.Sprintf("rsync error: %v\n", err)) //nolint:errcheck
+	}
+	c.sendExitStatus(err)
+	return err
+}
+
+func (c *rsyncCommand) handshake() error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], rsyncMaxProtocolVersion)
+	if err := c.conn.writeRaw(b[:]); err != nil {
+		return err
+	}
+	version, err := c.conn.readInt32()
+	if err != nil {
+		return err
+	}
+	protocol, err := negotiateRsyncProtocol(version)
+	if err != nil {
+		c.sendStderr(err)
+		return err
+	}
+	c.protocol = protocol
+	c.seed = c.opts.checksumSeed
+	if c.seed == 0 {
+		c.seed = int32(time.Now().Unix())
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(c.seed))
+	if err := c.conn.writeRaw(b[:]); err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "rsync handshake completed, client protocol version: %d, negotiated version: %d",
+		version, c.protocol)
+	return c.conn.startMultiplex()
+}
+
+// negotiateRsyncProtocol returns the protocol version to use with a client
+// announcing the given version. As in rsync, the lower version is used
+func negotiateRsyncProtocol(clientVersion int32) (int32, error) {
+	protocol := clientVersion
+	if protocol > rsyncMaxProtocolVersion {
+		protocol = rsyncMaxProtocolVersion
+	}
+	if protocol < rsyncMinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is not supported, at least version %d is required",
+			clientVersion, rsyncMinProtocolVersion)
+	}
+	return protocol, nil
+}
+
+func (c *rsyncCommand) sendStderr(err error) {
+	if channel, ok := c.connection.channel.(ssh.Channel); ok {
+		channel.Stderr().Write([]byte(fmt.Sprintf("rsync: %v\n", err))) //nolint:errcheck
+	}
+}
+
+func (c *rsyncCommand) sendInfo(format string, v ...any) {
+	c.conn.writeMessage(rsyncMsgInfo, fmt.Sprintf(format, v...)) //nolint:errcheck
+}
+
+// sendError notifies the client about an error for a single file,
+// the transfer continues for the other files
+func (c *rsyncCommand) sendError(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	c.connection.Log(logger.LevelWarn, "rsync error: %s", msg)
+	c.hasErrors.Store(true)
+	c.conn.writeMessage(rsyncMsgErrorXfer, fmt.Sprintf("rsync: %s\n", msg)) //nolint:errcheck
+}
+
+func (c *rsyncCommand) getTransferError() error {
+	if c.ioError || c.hasErrors.Load() {
+		return errRsyncPartialTransfer
+	}
+	return nil
+}
+
+func (c *rsyncCommand) getFileListOptions() rsyncFileListOptions {
+	return rsyncFileListOptions{
+		preserveLinks:   c.opts.preserveLinks,
+		preserveUID:     c.opts.preserveUID,
+		preserveGID:     c.opts.preserveGID,
+		preserveDevices: c.opts.preserveDevices,
+		alwaysChecksum:  c.opts.alwaysChecksum,
+	}
+}
+
+func (c *rsyncCommand) recvFilterList() error {
+	for {
+		length, err := c.conn.readInt32()
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
+		rule, err := c.conn.readString(length)
+		if err != nil {
+			return err
+		}
+		if rule == "!" {
+			c.filters = nil
+			continue
+		}
+		filter, err := newRsyncFilterRule(rule)
+		if err != nil {
+			return err
+		}
+		c.filters = append(c.filters, filter)
+	}
+}
+
+func (c *rsyncCommand) isExcluded(name string, isDir bool) bool {
+	for idx := range c.filters {
+		if c.filters[idx].matches(name, isDir) {
+			return !c.filters[idx].include
+		}
+	}
+	return false
+}
+
+// sortFileList sorts the file list as the client does, the file indexes sent
+// on the wire refer to the sorted list
+func (c *rsyncCommand) sortFileList() {
+	sort.SliceStable(c.files, func(i, j int) bool {
+		return c.files[i].name < c.files[j].name
+	})
+}
+
+func (c *rsyncCommand) openFileForRead(virtualPath string) (*rsyncFile, error) {
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	file, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, c.connection.GetFsError(fs, err)
+	}
+	return &rsyncFile{
+		file:     file,
+		reader:   r,
+		cancelFn: cancelFn,
+	}, nil
+}
+
+func (c *rsyncCommand) getFileChecksum(virtualPath string) ([]byte, error) {
+	f, err := c.openFileForRead(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return rsyncFileChecksum(io.NewSectionReader(f, 0, math.MaxInt64))
+}
+
+func (c *rsyncCommand) getBasisSums(virtualPath string, size int64) (*rsyncSumHead, error) {
+	f, err := c.openFileForRead(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return rsyncComputeSums(io.NewSectionReader(f, 0, math.MaxInt64), size, c.seed)
+}
+
+func (c *rsyncCommand) isSameTime(modTime time.Time, mtime int32) bool {
+	diff := modTime.Unix() - int64(mtime)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= c.opts.modifyWindow
+}
+
+func (c *rsyncCommand) setTimes(virtualPath string, mtime int32) {
+	modTime := time.Unix(int64(mtime), 0)
+	err := c.connection.SetStat(virtualPath, &common.StatAttributes{
+		Flags: common.StatAttrTimes,
+		Atime: time.Now(),
+		Mtime: modTime,
+	})
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to set times for path %q: %v", virtualPath, err)
+	}
+}
+
+func (c *rsyncCommand) setPermissions(virtualPath string, mode os.FileMode) {
+	err := c.connection.SetStat(virtualPath, &common.StatAttributes{
+		Flags: common.StatAttrPerms,
+		Mode:  mode,
+	})
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to set permissions for path %q: %v", virtualPath, err)
+	}
+}
+
+// sender
+
+func (c *rsyncCommand) handleSender() error {
+	if err := c.recvFilterList(); err != nil {
+		return err
+	}
+	for _, p := range c.opts.paths {
+		c.addSourcePath(p)
+	}
+	c.sortFileList()
+	if err := c.sendFileList(); err != nil {
+		return err
+	}
+	if len(c.files) == 0 {
+		return c.getTransferError()
+	}
+	if err := c.sendFiles(); err != nil {
+		return err
+	}
+	var totalSize int64
+	for _, e := range c.files {
+		totalSize += e.size
+	}
+	c.conn.writeLongint(c.conn.bytesRead)         //nolint:errcheck
+	c.conn.writeLongint(c.conn.getBytesWritten()) //nolint:errcheck
+	c.conn.writeLongint(totalSize)                //nolint:errcheck
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	// final goodbye
+	if _, err := c.conn.readInt32(); err != nil {
+		return err
+	}
+	return c.getTransferError()
+}
+
+func (c *rsyncCommand) addSourcePath(sourcePath string) {
+	virtualPath := c.cleanCommandPath(sourcePath)
+	contentsOnly := strings.HasSuffix(virtualPath, "/") || path.Base(sourcePath) == "."
+	virtualPath = path.Clean(virtualPath)
+	info, err := c.connection.DoStat(virtualPath, 1, true)
+	if err != nil {
+		c.ioError = true
+		c.sendError("link_stat %q failed: %v", sourcePath, err)
+		return
+	}
+	if info.IsDir() && contentsOnly {
+		if !c.opts.recursive && !c.opts.dirs {
+			c.sendInfo("skipping directory %s\n", sourcePath)
+			return
+		}
+		c.files = append(c.files, c.newFileEntry(virtualPath, ".", info, true))
+		c.walkDir(virtualPath, ".")
+		return
+	}
+	name := path.Base(virtualPath)
+	if name == "/" {
+		name = "."
+	}
+	c.addFileEntry(virtualPath, name, info, true)
+}
+
+func (c *rsyncCommand) walkDir(virtualPath, name string) {
+	files, err := c.connection.ListDir(virtualPath)
+	if err != nil {
+		c.ioError = true
+		c.sendError("opendir %q failed: %v", name, err)
+		return
+	}
+	for _, fi := range files {
+		childName := fi.Name()
+		if name != "." {
+			childName = path.Join(name, fi.Name())
+		}
+		c.addFileEntry(path.Join(virtualPath, fi.Name()), childName, fi, false)
+	}
+}
+
+func (c *rsyncCommand) addFileEntry(virtualPath, name string, info os.FileInfo, isTopDir bool) {
+	if !isTopDir && c.isExcluded(name, info.IsDir()) {
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch {
+		case c.opts.copyLinks:
+			var err error
+			info, err = c.connection.DoStat(virtualPath, 0, true)
+			if err != nil {
+				c.ioError = true
+				c.sendError("link_stat %q failed: %v", name, err)
+				return
+			}
+		case c.opts.preserveLinks:
+			target, err := c.readLink(virtualPath)
+			if err != nil {
+				c.ioError = true
+				c.sendError("readlink %q failed: %v", name, err)
+				return
+			}
+			entry := c.newFileEntry(virtualPath, name, info, false)
+			entry.link = target
+			c.files = append(c.files, entry)
+			return
+		default:
+			c.sendInfo("skipping non-regular file %q\n", name)
+			return
+		}
+	}
+	switch {
+	case info.IsDir():
+		if !c.opts.recursive && !c.opts.dirs {
+			c.sendInfo("skipping directory %s\n", name)
+			return
+		}
+		c.files = append(c.files, c.newFileEntry(virtualPath, name, info, isTopDir))
+		if c.opts.recursive {
+			c.walkDir(virtualPath, name)
+		}
+	case info.Mode().IsRegular():
+		entry := c.newFileEntry(virtualPath, name, info, false)
+		if c.opts.alwaysChecksum && c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+			if ok, _ := c.connection.User.IsFileAllowed(virtualPath); ok {
+				entry.sum, _ = c.getFileChecksum(virtualPath)
+			}
+		}
+		c.files = append(c.files, entry)
+	default:
+		c.sendInfo("skipping non-regular file %q\n", name)
+	}
+}
+
+func (c *rsyncCommand) newFileEntry(virtualPath, name string, info os.FileInfo, isTopDir bool) *rsyncFileEntry {
+	entry := &rsyncFileEntry{
+		name:        name,
+		mtime:       int32(info.ModTime().Unix()),
+		mode:        rsyncModeFromFileMode(info.Mode()),
+		topDir:      isTopDir,
+		virtualPath: virtualPath,
+	}
+	if info.Mode().IsRegular() {
+		entry.size = info.Size()
+	}
+	if uid := c.connection.User.GetUID(); uid > 0 {
+		entry.uid = int32(uid)
+	}
+	if gid := c.connection.User.GetGID(); gid > 0 {
+		entry.gid = int32(gid)
+	}
+	return entry
+}
+
+func (c *rsyncCommand) readLink(virtualPath string) (string, error) {
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return "", err
+	}
+	target, err := fs.Readlink(fsPath)
+	if err != nil {
+		return "", c.connection.GetFsError(fs, err)
+	}
+	return rsyncRelativePath(path.Dir(virtualPath), target), nil
+}
+
+func (c *rsyncCommand) sendFileList() error {
+	codec := &rsyncFileListCodec{opts: c.getFileListOptions()}
+	for _, entry := range c.files {
+		if err := codec.encode(c.conn, entry); err != nil {
+			return err
+		}
+	}
+	c.conn.writeByte(0) //nolint:errcheck
+	// we don't send user and group names, the ids lists are always empty
+	if !c.opts.numericIDs {
+		if c.opts.preserveUID {
+			c.conn.writeInt32(0) //nolint:errcheck
+		}
+		if c.opts.preserveGID {
+			c.conn.writeInt32(0) //nolint:errcheck
+		}
+	}
+	var ioError int32
+	if c.ioError {
+		ioError = 1
+	}
+	c.conn.writeInt32(ioError) //nolint:errcheck
+	return c.conn.flush()
+}
+
+func (c *rsyncCommand) sendFiles() error {
+	phase := 0
+	for {
+		if err := c.conn.flush(); err != nil {
+			return err
+		}
+		idx, err := c.conn.readInt32()
+		if err != nil {
+			return err
+		}
+		if idx == rsyncIndexDone {
+			phase++
+			if phase > 1 {
+				break
+			}
+			c.conn.writeInt32(rsyncIndexDone) //nolint:errcheck
+			continue
+		}
+		if idx < 0 || int(idx) >= len(c.files) || !c.files[idx].isRegular() {
+			return fmt.Errorf("%w: invalid file index %d", errRsyncProtocol, idx)
+		}
+		var head rsyncSumHead
+		if err := head.read(c.conn, true); err != nil {
+			return err
+		}
+		c.sendFile(idx, &head)
+	}
+	c.conn.writeInt32(rsyncIndexDone) //nolint:errcheck
+	return c.conn.flush()
+}
+
+func (c *rsyncCommand) sendFile(idx int32, head *rsyncSumHead) {
+	entry := c.files[idx]
+	t, err := c.getDownloadTransfer(entry.virtualPath)
+	if err != nil {
+		c.sendError("send_files failed to open %q: %v", entry.name, err)
+		return
+	}
+	c.conn.writeInt32(idx)    //nolint:errcheck
+	head.write(c.conn, false) //nolint:errcheck
+	err = rsyncSendDelta(c.conn, io.NewSectionReader(t, 0, math.MaxInt64), head, c.seed)
+	if err != nil {
+		c.connection.Log(logger.LevelWarn, "unable to read file %q: %v", entry.virtualPath, err)
+	}
+	if err := t.Close(); err != nil {
+		c.connection.Log(logger.LevelWarn, "download for file %q completed with error: %v", entry.virtualPath, err)
+	}
+}
+
+func (c *rsyncCommand) getDownloadTransfer(virtualPath string) (*transfer, error) {
+	c.connection.UpdateLastActivity()
+	transferQuota := c.connection.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		c.connection.Log(logger.LevelInfo, "denying file read due to quota limits")
+		return nil, c.connection.GetReadQuotaExceededError()
+	}
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	if ok, policy := c.connection.User.IsFileAllowed(virtualPath); !ok {
+		c.connection.Log(logger.LevelWarn, "reading file %q is not allowed", virtualPath)
+		return nil, c.connection.GetErrorForDeniedFile(policy)
+	}
+	if err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreDownload, fsPath, virtualPath,
+		0, 0); err != nil {
+		c.connection.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", virtualPath, err)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	file, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		c.connection.Log(logger.LevelError, "could not open file %q for reading: %v", fsPath, err)
+		return nil, c.connection.GetFsError(fs, err)
+	}
+	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, fsPath, fsPath, virtualPath,
+		common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+	return newTransfer(baseTransfer, nil, r, nil), nil
+}
+
+// receiver
+
+func (c *rsyncCommand) handleReceiver() error {
+	if len(c.opts.paths) != 1 {
+		return errors.New("a single destination path is required")
+	}
+	if c.opts.pruneEmptyDirs || (c.opts.deleteMode && !c.opts.deleteExcluded) {
+		if err := c.recvFilterList(); err != nil {
+			return err
+		}
+	}
+	if err := c.recvFileList(); err != nil {
+		return err
+	}
+	if len(c.files) == 0 {
+		return nil
+	}
+	if err := c.prepareDestination(); err != nil {
+		return err
+	}
+	redoCh := make(chan []int32, 1)
+	recvDone := make(chan struct{})
+	genErrCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error(logSender, "", "panic in rsync generator: %#v stack trace: %v", r, string(debug.Stack()))
+				genErrCh <- common.ErrGenericFailure
+			}
+		}()
+
+		genErrCh <- c.generateFiles(redoCh, recvDone)
+	}()
+	err := c.receiveFiles(redoCh)
+	close(recvDone)
+	if err != nil {
+		return err
+	}
+	if err := <-genErrCh; err != nil {
+		return err
+	}
+	return c.getTransferError()
+}
+
+func (c *rsyncCommand) recvFileList() error {
+	codec := &rsyncFileListCodec{opts: c.getFileListOptions()}
+	for {
+		entry, err := codec.decode(c.conn)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.name, err = rsyncCleanName(entry.name); err != nil {
+			return err
+		}
+		c.files = append(c.files, entry)
+	}
+	if !c.opts.numericIDs {
+		if c.opts.preserveUID {
+			if err := c.recvIDList(); err != nil {
+				return err
+			}
+		}
+		if c.opts.preserveGID {
+			if err := c.recvIDList(); err != nil {
+				return err
+			}
+		}
+	}
+	ioError, err := c.conn.readInt32()
+	if err != nil {
+		return err
+	}
+	if ioError != 0 && c.opts.deleteMode {
+		c.opts.deleteMode = false
+		c.sendInfo("IO error encountered on the sending side -- skipping file deletion\n")
+	}
+	c.sortFileList()
+	c.connection.Log(logger.LevelDebug, "rsync file list received, number of files: %d", len(c.files))
+	return nil
+}
+
+// recvIDList reads and discards the user or group names sent by the client,
+// we don't change the owner of the received files
+func (c *rsyncCommand) recvIDList() error {
+	for {
+		id, err := c.conn.readInt32()
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return nil
+		}
+		length, err := c.conn.readByte()
+		if err != nil {
+			return err
+		}
+		if _, err := c.conn.readString(int32(length)); err != nil {
+			return err
+		}
+	}
+}
+
+// prepareDestination creates the destination directory, if required,
+// and sets the target virtual path for each received file
+func (c *rsyncCommand) prepareDestination() error {
+	destPath := c.cleanCommandPath(c.opts.paths[0])
+	hasTrailingSlash := strings.HasSuffix(destPath, "/")
+	c.destPath = path.Clean(destPath)
+	isSingleRegularFile := len(c.files) == 1 && c.files[0].isRegular()
+
+	info, err := c.connection.DoStat(c.destPath, 0, false)
+	if err == nil {
+		if !info.IsDir() {
+			if !isSingleRegularFile {
+				return fmt.Errorf("destination %q must be a directory when copying more than 1 file", c.opts.paths[0])
+			}
+			c.singleFile = true
+		}
+	} else {
+		if !c.connection.IsNotExistError(err) {
+			return err
+		}
+		if isSingleRegularFile && !hasTrailingSlash {
+			c.singleFile = true
+		} else {
+			if err := c.connection.CreateDir(c.destPath, true); err != nil {
+				return fmt.Errorf("mkdir %q failed: %w", c.opts.paths[0], err)
+			}
+			if c.opts.verbose > 0 {
+				c.sendInfo("created directory %s\n", c.opts.paths[0])
+			}
+		}
+	}
+	c.children = make(map[string]map[string]bool)
+	for _, entry := range c.files {
+		if c.singleFile || entry.name == "." {
+			entry.virtualPath = c.destPath
+			continue
+		}
+		entry.virtualPath = path.Join(c.destPath, entry.name)
+		parent := path.Dir(entry.name)
+		if _, ok := c.children[parent]; !ok {
+			c.children[parent] = make(map[string]bool)
+		}
+		c.children[parent][path.Base(entry.name)] = true
+	}
+	return nil
+}
+
+func (c *rsyncCommand) generateFiles(redoCh <-chan []int32, recvDone <-chan struct{}) error {
+	for idx, entry := range c.files {
+		if idx > 0 && entry.name == c.files[idx-1].name {
+			// duplicate entry
+			continue
+		}
+		if err := c.generateFile(int32(idx), entry, false); err != nil {
+			return err
+		}
+	}
+	c.conn.writeInt32(rsyncIndexDone) //nolint:errcheck
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	var redo []int32
+	select {
+	case redo = <-redoCh:
+	case <-recvDone:
+		return nil
+	}
+	for _, idx := range redo {
+		c.connection.Log(logger.LevelDebug, "checksum mismatch for file %q, send it again", c.files[idx].virtualPath)
+		if err := c.generateRegularFile(idx, c.files[idx], true); err != nil {
+			return err
+		}
+	}
+	c.conn.writeInt32(rsyncIndexDone) //nolint:errcheck
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	<-recvDone
+	c.setDirsAttributes()
+	// final goodbye
+	c.conn.writeInt32(rsyncIndexDone) //nolint:errcheck
+	return c.conn.flush()
+}
+
+func (c *rsyncCommand) generateFile(idx int32, entry *rsyncFileEntry, isRedo bool) error {
+	switch {
+	case entry.isDir():
+		c.generateDir(entry)
+	case entry.isSymlink():
+		c.generateSymlink(entry)
+	case entry.isRegular():
+		return c.generateRegularFile(idx, entry, isRedo)
+	default:
+		c.sendInfo("skipping non-regular file %q\n", entry.name)
+	}
+	return nil
+}
+
+func (c *rsyncCommand) generateDir(entry *rsyncFileEntry) {
+	info, err := c.connection.DoStat(entry.virtualPath, 1, false)
+	if err == nil {
+		if !info.IsDir() {
+			c.sendError("cannot create directory %q: a file with the same name already exists", entry.name)
+			return
+		}
+	} else {
+		if !c.connection.IsNotExistError(err) {
+			c.sendError("stat %q failed: %v", entry.name, err)
+			return
+		}
+		if err := c.connection.CreateDir(entry.virtualPath, true); err != nil {
+			c.sendError("mkdir %q failed: %v", entry.name, err)
+			return
+		}
+	}
+	if c.opts.deleteMode && c.opts.recursive {
+		c.deleteExtraneous(entry)
+	}
+}
+
+// deleteExtraneous removes the files inside the directory for the given entry
+// that are not included in the received file list
+func (c *rsyncCommand) deleteExtraneous(entry *rsyncFileEntry) {
+	files, err := c.connection.ListDir(entry.virtualPath)
+	if err != nil {
+		c.sendError("unable to list %q for deletion: %v", entry.name, err)
+		return
+	}
+	children := c.children[entry.name]
+	for _, fi := range files {
+		if children[fi.Name()] {
+			continue
+		}
+		name := fi.Name()
+		if entry.name != "." {
+			name = path.Join(entry.name, fi.Name())
+		}
+		if !c.opts.deleteExcluded && c.isExcluded(name, fi.IsDir()) {
+			continue
+		}
+		virtualPath := path.Join(entry.virtualPath, fi.Name())
+		if c.connection.User.IsVirtualFolder(virtualPath) {
+			continue
+		}
+		if err := c.connection.RemoveAll(virtualPath); err != nil {
+			c.sendError("delete %q failed: %v", name, err)
+			continue
+		}
+		if c.opts.verbose > 0 {
+			c.sendInfo("deleting %s\n", name)
+		}
+	}
+}
+
+func (c *rsyncCommand) generateSymlink(entry *rsyncFileEntry) {
+	if !c.opts.preserveLinks {
+		return
+	}
+	if !rsyncIsSafeLink(entry.name, entry.link) {
+		c.sendInfo("ignoring unsafe symlink %q -> %q\n", entry.name, entry.link)
+		return
+	}
+	info, err := c.connection.DoStat(entry.virtualPath, 1, false)
+	if err == nil {
+		fs, fsPath, err := c.connection.GetFsAndResolvedPath(entry.virtualPath)
+		if err != nil {
+			c.sendError("symlink %q failed: %v", entry.name, err)
+			return
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := fs.Readlink(fsPath)
+			if err == nil && target == path.Join(path.Dir(entry.virtualPath), entry.link) {
+				return
+			}
+		}
+		if info.IsDir() {
+			c.sendError("cannot replace directory %q with a symlink", entry.name)
+			return
+		}
+		if err := c.connection.RemoveFile(fs, fsPath, entry.virtualPath, info); err != nil {
+			c.sendError("unable to replace %q with a symlink: %v", entry.name, err)
+			return
+		}
+	} else if !c.connection.IsNotExistError(err) {
+		c.sendError("stat %q failed: %v", entry.name, err)
+		return
+	}
+	if err := c.connection.CreateSymlink(entry.link, entry.virtualPath); err != nil {
+		c.sendError("symlink %q -> %q failed: %v", entry.name, entry.link, err)
+	}
+}
+
+func (c *rsyncCommand) isUpToDate(entry *rsyncFileEntry, info os.FileInfo) bool {
+	if c.opts.ignoreTimes || info.Size() != entry.size {
+		return false
+	}
+	if c.opts.sizeOnly {
+		return true
+	}
+	if c.opts.alwaysChecksum {
+		sum, err := c.getFileChecksum(entry.virtualPath)
+		return err == nil && bytes.Equal(sum, entry.sum)
+	}
+	return c.isSameTime(info.ModTime(), entry.mtime)
+}
+
+func (c *rsyncCommand) checkUploadPermissions(virtualPath string, isNewFile bool) error {
+	if ok, _ := c.connection.User.IsFileAllowed(virtualPath); !ok {
+		return c.connection.GetPermissionDeniedError()
+	}
+	if isNewFile {
+		if !c.connection.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+			return c.connection.GetPermissionDeniedError()
+		}
+		return nil
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermOverwrite, virtualPath) {
+		return c.connection.GetPermissionDeniedError()
+	}
+	return nil
+}
+
+func (c *rsyncCommand) generateRegularFile(idx int32, entry *rsyncFileEntry, isRedo bool) error {
+	info, err := c.connection.DoStat(entry.virtualPath, 1, false)
+	if err != nil && !c.connection.IsNotExistError(err) {
+		c.sendError("stat %q failed: %v", entry.name, err)
+		return nil
+	}
+	exists := err == nil
+	if exists && !info.Mode().IsRegular() {
+		if info.Mode()&os.ModeSymlink == 0 {
+			c.sendError("cannot overwrite non-regular file %q", entry.name)
+			return nil
+		}
+		fs, fsPath, err := c.connection.GetFsAndResolvedPath(entry.virtualPath)
+		if err == nil {
+			err = c.connection.RemoveFile(fs, fsPath, entry.virtualPath, info)
+		}
+		if err != nil {
+			c.sendError("unable to replace symlink %q: %v", entry.name, err)
+			return nil
+		}
+		exists = false
+	}
+	if !isRedo {
+		if (exists && c.opts.ignoreExisting) || (!exists && c.opts.existingOnly) {
+			return nil
+		}
+		if exists && c.isUpToDate(entry, info) {
+			if c.opts.preserveTimes && !c.isSameTime(info.ModTime(), entry.mtime) {
+				c.setTimes(entry.virtualPath, entry.mtime)
+			}
+			return nil
+		}
+		if exists && c.opts.update && info.ModTime().Unix() > int64(entry.mtime) {
+			return nil
+		}
+	}
+	if err := c.checkUploadPermissions(entry.virtualPath, !exists); err != nil {
+		c.sendError("unable to write %q: %v", entry.name, err)
+		return nil
+	}
+	head := &rsyncSumHead{}
+	if exists && !isRedo && !c.opts.wholeFile && info.Size() > 0 {
+		sums, err := c.getBasisSums(entry.virtualPath, info.Size())
+		if err == nil {
+			head = sums
+		} else {
+			c.connection.Log(logger.LevelWarn, "unable to compute block checksums for %q, the whole file will be transferred: %v",
+				entry.virtualPath, err)
+		}
+	}
+	entry.requested.Store(true)
+	c.conn.writeInt32(idx) //nolint:errcheck
+	if err := head.write(c.conn, true); err != nil {
+		return err
+	}
+	return c.conn.flush()
+}
+
+func (c *rsyncCommand) setDirsAttributes() {
+	setTimes := c.opts.preserveTimes && !c.opts.omitDirTimes
+	if !setTimes && !c.opts.preservePerms {
+		return
+	}
+	for idx := len(c.files) - 1; idx >= 0; idx-- {
+		entry := c.files[idx]
+		if !entry.isDir() || entry.virtualPath == "" {
+			continue
+		}
+		if c.opts.preservePerms {
+			c.setPermissions(entry.virtualPath, entry.fileMode())
+		}
+		if setTimes {
+			c.setTimes(entry.virtualPath, entry.mtime)
+		}
+	}
+}
+
+func (c *rsyncCommand) receiveFiles(redoCh chan<- []int32) error {
+	phase := 0
+	var redo []int32
+	for {
+		idx, err := c.conn.readInt32()
+		if err != nil {
+			return err
+		}
+		if idx == rsyncIndexDone {
+			phase++
+			if phase > 1 {
+				return nil
+			}
+			redoCh <- redo
+			redo = nil
+			continue
+		}
+		if idx < 0 || int(idx) >= len(c.files) || !c.files[idx].requested.Load() {
+			return fmt.Errorf("%w: unexpected file index %d", errRsyncProtocol, idx)
+		}
+		var head rsyncSumHead
+		if err := head.read(c.conn, false); err != nil {
+			return err
+		}
+		if head.count > 0 && head.blength > rsyncMaxBlockSize {
+			return fmt.Errorf("%w: invalid block length %d", errRsyncProtocol, head.blength)
+		}
+		entry := c.files[idx]
+		isValid, err := c.receiveFile(entry, &head)
+		if err != nil {
+			return err
+		}
+		if !isValid {
+			if phase == 0 {
+				redo = append(redo, idx)
+				continue
+			}
+			c.sendError("%q failed verification -- update discarded", entry.name)
+		}
+	}
+}
+
+// receiveFile receives a single file, it returns false if the checksum does not match
+func (c *rsyncCommand) receiveFile(entry *rsyncFileEntry, head *rsyncSumHead) (bool, error) {
+	var basis io.ReaderAt
+	if head.count > 0 {
+		f, err := c.openFileForRead(entry.virtualPath)
+		if err == nil {
+			defer f.Close()
+			basis = f
+		} else {
+			c.connection.Log(logger.LevelWarn, "unable to open basis file %q: %v", entry.virtualPath, err)
+		}
+	}
+	t, err := c.getUploadTransfer(entry.virtualPath)
+	if err != nil {
+		c.sendError("unable to write %q: %v", entry.name, err)
+		_, err = rsyncReceiveDelta(c.conn, head, nil, io.Discard, c.seed)
+		return true, err
+	}
+	isValid, err := rsyncReceiveDelta(c.conn, head, basis, &rsyncTransferWriter{t: t}, c.seed)
+	if err != nil {
+		t.TransferError(err)
+		t.Close() //nolint:errcheck
+		return false, err
+	}
+	if !isValid {
+		t.TransferError(errRsyncChecksum)
+		t.Close() //nolint:errcheck
+		return false, nil
+	}
+	if c.opts.preserveTimes {
+		// the times are stored within the transfer and applied after closing it
+		c.setTimes(entry.virtualPath, entry.mtime)
+	}
+	if err := t.Close(); err != nil {
+		c.sendError("unable to write %q: %v", entry.name, err)
+		return true, nil
+	}
+	if c.opts.preservePerms {
+		c.setPermissions(entry.virtualPath, entry.fileMode())
+	}
+	return true, nil
+}
+
+// getUploadTransfer returns a transfer to write the specified file. Existing files are
+// replaced atomically, if supported, so they can be used as basis for the delta transfer
+func (c *rsyncCommand) getUploadTransfer(virtualPath string) (*transfer, error) {
+	c.connection.UpdateLastActivity()
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	isNewFile := false
+	var fileSize int64
+	info, err := fs.Lstat(fsPath)
+	if err == nil {
+		if info.IsDir() {
+			return nil, fmt.Errorf("%q is a directory", virtualPath)
+		}
+		fileSize = info.Size()
+	} else {
+		if !fs.IsNotExist(err) {
+			return nil, c.connection.GetFsError(fs, err)
+		}
+		isNewFile = true
+	}
+	if err := c.checkUploadPermissions(virtualPath, isNewFile); err != nil {
+		return nil, err
+	}
+	diskQuota, transferQuota := c.connection.HasSpace(isNewFile, false, virtualPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		c.connection.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, c.connection.GetQuotaExceededError()
+	}
+	if err := c.connection.CheckWebDAVLocks(virtualPath, false); err != nil {
+		return nil, err
+	}
+	if err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreUpload, fsPath, virtualPath,
+		fileSize, os.O_TRUNC); err != nil {
+		c.connection.Log(logger.LevelDebug, "upload for file %q denied by pre action: %v", virtualPath, err)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	maxWriteSize, _ := c.connection.GetMaxWriteSize(diskQuota, false, fileSize, fs.IsUploadResumeSupported())
+
+	filePath := fsPath
+	if fs.IsAtomicUploadSupported() && (!isNewFile || common.Config.IsAtomicUploadEnabled()) {
+		filePath = fs.GetAtomicUploadPath(fsPath)
+	}
+	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		c.connection.Log(logger.LevelError, "error creating file %q: %v", filePath, err)
+		return nil, c.connection.GetFsError(fs, err)
+	}
+	var initialSize, truncatedSize int64
+	if !isNewFile {
+		// the existing file is replaced when the transfer completes
+		initialSize = fileSize
+		truncatedSize = fileSize
+		if maxWriteSize > 0 {
+			maxWriteSize += fileSize
+		}
+	}
+	vfs.SetPathPermissions(fs, filePath, c.connection.User.GetUID(), c.connection.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, fsPath, filePath, virtualPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, truncatedSize, isNewFile, fs, transferQuota)
+	return newTransfer(baseTransfer, w, nil, nil), nil
+}
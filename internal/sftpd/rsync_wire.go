@@ -0,0 +1,1271 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck
+)
+
+const (
+	// protocol 27 is the most recent version that does not require varint encoding,
+	// incremental recursion and negotiated checksums, it is the only implemented
+	// version. The client and the server use the lower of the announced versions,
+	// so any rsync client supporting a more recent protocol downgrades to this one.
+	// Clients announcing an older version are refused
+	rsyncMinProtocolVersion = 27
+	rsyncMaxProtocolVersion = 27
+	rsyncMplexBase          = 7
+	rsyncMsgData            = 0
+	rsyncMsgErrorXfer       = 1
+	rsyncMsgInfo            = 2
+	rsyncChunkSize          = 32 * 1024
+	rsyncMaxFrameSize       = 0xFFFFFF
+	rsyncSumLength          = 16
+	rsyncBlockSize          = 700
+	rsyncMaxBlockSize       = 1 << 17
+	rsyncMaxSumCount        = 1 << 22
+	// block checksums sent by the client for bigger blocks are ignored and
+	// the whole file is sent
+	rsyncMaxMatchBlockSize = 1 << 24
+	rsyncMaxPathLength     = 4096
+	rsyncIndexDone         = -1
+)
+
+// file list transmission flags
+const (
+	rsyncXmitTopDir   = 1 << 0
+	rsyncXmitSameMode = 1 << 1
+	rsyncXmitSameRdev = 1 << 2
+	rsyncXmitSameUID  = 1 << 3
+	rsyncXmitSameGID  = 1 << 4
+	rsyncXmitSameName = 1 << 5
+	rsyncXmitLongName = 1 << 6
+	rsyncXmitSameTime = 1 << 7
+)
+
+// file types as sent on the wire, they match the Unix ones
+const (
+	rsyncModeTypeMask = 0170000
+	rsyncModeSocket   = 0140000
+	rsyncModeSymlink  = 0120000
+	rsyncModeRegular  = 0100000
+	rsyncModeBlock    = 0060000
+	rsyncModeDir      = 0040000
+	rsyncModeChar     = 0020000
+	rsyncModeFifo     = 0010000
+)
+
+// compression methods for the file data
+const (
+	rsyncCompressNone = iota
+	// zlib adds the matched blocks to the compression history
+	rsyncCompressZlib
+	// zlibx does not add the matched blocks to the compression history
+	rsyncCompressZlibX
+)
+
+// compressed token stream flags
+const (
+	rsyncTokenEnd        = 0x00
+	rsyncTokenLong       = 0x20
+	rsyncTokenRunLong    = 0x21
+	rsyncTokenDeflated   = 0x40
+	rsyncTokenRel        = 0x80
+	rsyncTokenRunRel     = 0xc0
+	rsyncMaxDeflatedSize = 16383
+	rsyncMaxSeeSize      = 0xffff
+	rsyncDeflateWindow   = 1 << 15
+)
+
+var (
+	errRsyncProtocol = errors.New("rsync protocol error")
+	// rsyncSyncMarker ends the data flushed by the compressor, it is not sent on the wire
+	rsyncSyncMarker = []byte{0x00, 0x00, 0xff, 0xff}
+)
+
+// rsyncConn handles the rsync wire encoding. Data sent to the client is buffered
+// and multiplexed with informational and error messages once the protocol
+// handshake is completed. Data received from the client is never multiplexed
+// for the supported protocol version
+type rsyncConn struct {
+	r            *bufio.Reader
+	w            io.Writer
+	mu           sync.Mutex
+	buf          []byte
+	multiplex    bool
+	err          error
+	bytesRead    int64
+	bytesWritten int64
+	// deflater and inflater are not nil if the file data are compressed
+	deflater *rsyncDeflater
+	inflater *rsyncInflater
+}
+
+func newRsyncConn(rw io.ReadWriter) *rsyncConn {
+	return &rsyncConn{
+		r: bufio.NewReaderSize(rw, rsyncChunkSize),
+		w: rw,
+	}
+}
+
+// enableCompression enables the compressed token stream for the file data
+func (c *rsyncConn) enableCompression(compression, level int) error {
+	if compression == rsyncCompressNone {
+		return nil
+	}
+	deflater := &rsyncDeflater{}
+	w, err := flate.NewWriter(deflater, level)
+	if err != nil {
+		return err
+	}
+	deflater.w = w
+	c.deflater = deflater
+	c.inflater = &rsyncInflater{
+		conn:       c,
+		seeBlocks:  compression == rsyncCompressZlib,
+		markerSent: len(rsyncSyncMarker),
+	}
+	return nil
+}
+
+func (c *rsyncConn) startMultiplex() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.multiplex = true
+	return nil
+}
+
+func (c *rsyncConn) readFull(p []byte) error {
+	n, err := io.ReadFull(c.r, p)
+	c.bytesRead += int64(n)
+	return err
+}
+
+func (c *rsyncConn) readByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.bytesRead++
+	}
+	return b, err
+}
+
+func (c *rsyncConn) readInt32() (int32, error) {
+	var b [4]byte
+	if err := c.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b[:])), nil
+}
+
+func (c *rsyncConn) readLongint() (int64, error) {
+	v, err := c.readInt32()
+	if err != nil || v != -1 {
+		return int64(v), err
+	}
+	var b [8]byte
+	if err := c.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+func (c *rsyncConn) readString(length int32) (string, error) {
+	if length < 0 || length > rsyncMaxPathLength {
+		return "", fmt.Errorf("%w: invalid string length %d", errRsyncProtocol, length)
+	}
+	b := make([]byte, length)
+	if err := c.readFull(b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (c *rsyncConn) write(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= 2*rsyncChunkSize {
+		return c.flushLocked()
+	}
+	return nil
+}
+
+func (c *rsyncConn) writeByte(b byte) error {
+	return c.write([]byte{b})
+}
+
+func (c *rsyncConn) writeInt32(v int32) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	return c.write(b[:])
+}
+
+func (c *rsyncConn) writeLongint(v int64) error {
+	if v >= 0 && v <= math.MaxInt32 {
+		return c.writeInt32(int32(v))
+	}
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[:4], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(b[4:], uint64(v))
+	return c.write(b[:])
+}
+
+// writeRaw writes the given bytes bypassing the multiplexing, it must be used
+// before the multiplexing starts
+func (c *rsyncConn) writeRaw(p []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	_, err := c.w.Write(p)
+	if err != nil {
+		c.err = err
+	}
+	return err
+}
+
+func (c *rsyncConn) writeMessage(code byte, msg string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.multiplex {
+		return nil
+	}
+	if err := c.flushLocked(); err != nil {
+		return err
+	}
+	if len(msg) > rsyncMaxFrameSize {
+		msg = msg[:rsyncMaxFrameSize]
+	}
+	frame := make([]byte, 4, 4+len(msg))
+	binary.LittleEndian.PutUint32(frame, uint32(rsyncMplexBase+code)<<24|uint32(len(msg)))
+	frame = append(frame, msg...)
+	if _, err := c.w.Write(frame); err != nil {
+		c.err = err
+		return err
+	}
+	return nil
+}
+
+func (c *rsyncConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flushLocked()
+}
+
+func (c *rsyncConn) flushLocked() error {
+	if c.err != nil {
+		return c.err
+	}
+	data := c.buf
+	for len(data) > 0 {
+		n := len(data)
+		if n > rsyncMaxFrameSize {
+			n = rsyncMaxFrameSize
+		}
+		var err error
+		if c.multiplex {
+			frame := make([]byte, 4, 4+n)
+			binary.LittleEndian.PutUint32(frame, uint32(rsyncMplexBase+rsyncMsgData)<<24|uint32(n))
+			frame = append(frame, data[:n]...)
+			_, err = c.w.Write(frame)
+		} else {
+			_, err = c.w.Write(data[:n])
+		}
+		if err != nil {
+			c.err = err
+			return err
+		}
+		c.bytesWritten += int64(n)
+		data = data[n:]
+	}
+	c.buf = c.buf[:0]
+	return nil
+}
+
+func (c *rsyncConn) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err != nil
+}
+
+func (c *rsyncConn) getBytesWritten() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytesWritten
+}
+
+// rsyncFileEntry is an rsync file list entry
+type rsyncFileEntry struct {
+	name        string
+	size        int64
+	mtime       int32
+	mode        uint32
+	uid         int32
+	gid         int32
+	rdev        int32
+	link        string
+	sum         []byte
+	topDir      bool
+	virtualPath string
+	requested   atomic.Bool
+}
+
+func (e *rsyncFileEntry) isDir() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeDir
+}
+
+func (e *rsyncFileEntry) isRegular() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeRegular
+}
+
+func (e *rsyncFileEntry) isSymlink() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeSymlink
+}
+
+func (e *rsyncFileEntry) isDevice() bool {
+	switch e.mode & rsyncModeTypeMask {
+	case rsyncModeChar, rsyncModeBlock, rsyncModeFifo, rsyncModeSocket:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *rsyncFileEntry) fileMode() os.FileMode {
+	return os.FileMode(e.mode & 0777)
+}
+
+func rsyncModeFromFileMode(mode os.FileMode) uint32 {
+	wireMode := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		wireMode |= rsyncModeDir
+	case mode&os.ModeSymlink != 0:
+		wireMode |= rsyncModeSymlink
+	case mode.IsRegular():
+		wireMode |= rsyncModeRegular
+	case mode&os.ModeNamedPipe != 0:
+		wireMode |= rsyncModeFifo
+	case mode&os.ModeSocket != 0:
+		wireMode |= rsyncModeSocket
+	case mode&os.ModeCharDevice != 0:
+		wireMode |= rsyncModeChar
+	case mode&os.ModeDevice != 0:
+		wireMode |= rsyncModeBlock
+	}
+	return wireMode
+}
+
+// rsyncCleanName validates a file name received from the client, it must be
+// a relative path that does not escape the transfer root
+func rsyncCleanName(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: invalid file name %q", errRsyncProtocol, name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: invalid file name %q", errRsyncProtocol, name)
+	}
+	return cleaned, nil
+}
+
+// rsyncFileListOptions defines the options that affect the file list encoding
+type rsyncFileListOptions struct {
+	preserveLinks   bool
+	preserveUID     bool
+	preserveGID     bool
+	preserveDevices bool
+	alwaysChecksum  bool
+}
+
+// rsyncFileListCodec encodes and decodes file list entries, each entry is
+// compressed against the previous one
+type rsyncFileListCodec struct {
+	opts      rsyncFileListOptions
+	lastName  string
+	lastMode  uint32
+	lastUID   int32
+	lastGID   int32
+	lastMtime int32
+	lastRdev  int32
+}
+
+func (l *rsyncFileListCodec) encode(conn *rsyncConn, e *rsyncFileEntry) error {
+	var flags byte
+	if e.topDir && e.isDir() {
+		flags |= rsyncXmitTopDir
+	}
+	if e.mode == l.lastMode {
+		flags |= rsyncXmitSameMode
+	}
+	if l.opts.preserveUID && e.uid == l.lastUID {
+		flags |= rsyncXmitSameUID
+	}
+	if l.opts.preserveGID && e.gid == l.lastGID {
+		flags |= rsyncXmitSameGID
+	}
+	if e.mtime == l.lastMtime {
+		flags |= rsyncXmitSameTime
+	}
+	prefixLen := 0
+	for prefixLen < len(e.name) && prefixLen < len(l.lastName) && prefixLen < 255 &&
+		e.name[prefixLen] == l.lastName[prefixLen] {
+		prefixLen++
+	}
+	suffix := e.name[prefixLen:]
+	if prefixLen > 0 {
+		flags |= rsyncXmitSameName
+	}
+	if len(suffix) > 255 {
+		flags |= rsyncXmitLongName
+	}
+	if flags == 0 {
+		// a zero flags byte marks the end of the list
+		if e.isDir() {
+			flags |= rsyncXmitLongName
+		} else {
+			flags |= rsyncXmitTopDir
+		}
+	}
+	conn.writeByte(flags) //nolint:errcheck
+	if flags&rsyncXmitSameName != 0 {
+		conn.writeByte(byte(prefixLen)) //nolint:errcheck
+	}
+	if flags&rsyncXmitLongName != 0 {
+		conn.writeInt32(int32(len(suffix))) //nolint:errcheck
+	} else {
+		conn.writeByte(byte(len(suffix))) //nolint:errcheck
+	}
+	conn.write([]byte(suffix)) //nolint:errcheck
+	conn.writeLongint(e.size)  //nolint:errcheck
+	if flags&rsyncXmitSameTime == 0 {
+		conn.writeInt32(e.mtime) //nolint:errcheck
+	}
+	if flags&rsyncXmitSameMode == 0 {
+		conn.writeInt32(int32(e.mode)) //nolint:errcheck
+	}
+	if l.opts.preserveUID && flags&rsyncXmitSameUID == 0 {
+		conn.writeInt32(e.uid) //nolint:errcheck
+	}
+	if l.opts.preserveGID && flags&rsyncXmitSameGID == 0 {
+		conn.writeInt32(e.gid) //nolint:errcheck
+	}
+	if l.opts.preserveLinks && e.isSymlink() {
+		conn.writeInt32(int32(len(e.link))) //nolint:errcheck
+		conn.write([]byte(e.link))          //nolint:errcheck
+	}
+	if l.opts.alwaysChecksum {
+		sum := e.sum
+		if len(sum) != rsyncSumLength {
+			sum = make([]byte, rsyncSumLength)
+		}
+		conn.write(sum) //nolint:errcheck
+	}
+	l.lastName = e.name
+	l.lastMode = e.mode
+	l.lastUID = e.uid
+	l.lastGID = e.gid
+	l.lastMtime = e.mtime
+	return conn.write(nil)
+}
+
+// decode reads the next file list entry, a nil entry means the end of the list
+func (l *rsyncFileListCodec) decode(conn *rsyncConn) (*rsyncFileEntry, error) {
+	flags, err := conn.readByte()
+	if err != nil || flags == 0 {
+		return nil, err
+	}
+	prefixLen := 0
+	if flags&rsyncXmitSameName != 0 {
+		b, err := conn.readByte()
+		if err != nil {
+			return nil, err
+		}
+		prefixLen = int(b)
+	}
+	var suffixLen int32
+	if flags&rsyncXmitLongName != 0 {
+		suffixLen, err = conn.readInt32()
+	} else {
+		var b byte
+		b, err = conn.readByte()
+		suffixLen = int32(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if prefixLen > len(l.lastName) {
+		return nil, fmt.Errorf("%w: invalid file name prefix length %d", errRsyncProtocol, prefixLen)
+	}
+	suffix, err := conn.readString(suffixLen)
+	if err != nil {
+		return nil, err
+	}
+	e := &rsyncFileEntry{
+		name:  l.lastName[:prefixLen] + suffix,
+		mode:  l.lastMode,
+		uid:   l.lastUID,
+		gid:   l.lastGID,
+		mtime: l.lastMtime,
+	}
+	l.lastName = e.name
+	if e.size, err = conn.readLongint(); err != nil {
+		return nil, err
+	}
+	if e.size < 0 {
+		return nil, fmt.Errorf("%w: invalid size %d for file %q", errRsyncProtocol, e.size, e.name)
+	}
+	if flags&rsyncXmitSameTime == 0 {
+		if e.mtime, err = conn.readInt32(); err != nil {
+			return nil, err
+		}
+	}
+	if flags&rsyncXmitSameMode == 0 {
+		mode, err := conn.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		e.mode = uint32(mode)
+	}
+	if l.opts.preserveUID && flags&rsyncXmitSameUID == 0 {
+		if e.uid, err = conn.readInt32(); err != nil {
+			return nil, err
+		}
+	}
+	if l.opts.preserveGID && flags&rsyncXmitSameGID == 0 {
+		if e.gid, err = conn.readInt32(); err != nil {
+			return nil, err
+		}
+	}
+	if l.opts.preserveDevices {
+		if e.isDevice() {
+			if flags&rsyncXmitSameRdev == 0 {
+				if l.lastRdev, err = conn.readInt32(); err != nil {
+					return nil, err
+				}
+			}
+			e.rdev = l.lastRdev
+		} else {
+			l.lastRdev = 0
+		}
+	}
+	if l.opts.preserveLinks && e.isSymlink() {
+		linkLen, err := conn.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		if e.link, err = conn.readString(linkLen); err != nil {
+			return nil, err
+		}
+	}
+	if l.opts.alwaysChecksum {
+		e.sum = make([]byte, rsyncSumLength)
+		if err := conn.readFull(e.sum); err != nil {
+			return nil, err
+		}
+	}
+	l.lastMode = e.mode
+	l.lastUID = e.uid
+	l.lastGID = e.gid
+	l.lastMtime = e.mtime
+	e.topDir = flags&rsyncXmitTopDir != 0 && e.isDir()
+	return e, nil
+}
+
+// rsyncBlockSum defines the checksums for a single block
+type rsyncBlockSum struct {
+	sum1 uint32
+	sum2 []byte
+}
+
+// rsyncSumHead defines the block checksums for a basis file.
+// A zero count means that the whole file must be sent
+type rsyncSumHead struct {
+	count     int32
+	blength   int32
+	s2length  int32
+	remainder int32
+	sums      []rsyncBlockSum
+}
+
+func (h *rsyncSumHead) getBlockLength(idx int32) int32 {
+	if idx == h.count-1 && h.remainder != 0 {
+		return h.remainder
+	}
+	return h.blength
+}
+
+func (h *rsyncSumHead) write(conn *rsyncConn, withSums bool) error {
+	conn.writeInt32(h.count)     //nolint:errcheck
+	conn.writeInt32(h.blength)   //nolint:errcheck
+	conn.writeInt32(h.s2length)  //nolint:errcheck
+	conn.writeInt32(h.remainder) //nolint:errcheck
+	if withSums {
+		for _, s := range h.sums {
+			conn.writeInt32(int32(s.sum1))  //nolint:errcheck
+			conn.write(s.sum2[:h.s2length]) //nolint:errcheck
+		}
+	}
+	return conn.write(nil)
+}
+
+func (h *rsyncSumHead) read(conn *rsyncConn, withSums bool) error {
+	var err error
+	if h.count, err = conn.readInt32(); err != nil {
+		return err
+	}
+	if h.blength, err = conn.readInt32(); err != nil {
+		return err
+	}
+	if h.s2length, err = conn.readInt32(); err != nil {
+		return err
+	}
+	if h.remainder, err = conn.readInt32(); err != nil {
+		return err
+	}
+	if h.count < 0 || h.count > rsyncMaxSumCount || h.blength < 0 || h.blength > 1<<29 ||
+		h.s2length < 0 || h.s2length > rsyncSumLength || h.remainder < 0 || h.remainder > h.blength ||
+		(h.count > 0 && h.blength == 0) {
+		return fmt.Errorf("%w: invalid checksum header, count %d block length %d checksum length %d remainder %d",
+			errRsyncProtocol, h.count, h.blength, h.s2length, h.remainder)
+	}
+	if !withSums {
+		return nil
+	}
+	h.sums = make([]rsyncBlockSum, 0, h.count)
+	for i := int32(0); i < h.count; i++ {
+		sum1, err := conn.readInt32()
+		if err != nil {
+			return err
+		}
+		sum2 := make([]byte, h.s2length)
+		if err := conn.readFull(sum2); err != nil {
+			return err
+		}
+		h.sums = append(h.sums, rsyncBlockSum{sum1: uint32(sum1), sum2: sum2})
+	}
+	return nil
+}
+
+// rsyncChecksum1 returns the rolling checksum for the given block.
+// Bytes are treated as signed chars as the reference implementation does
+func rsyncChecksum1(buf []byte) uint32 {
+	var s1, s2 uint32
+	l := uint32(len(buf))
+	for i, b := range buf {
+		v := uint32(int8(b))
+		s1 += v
+		s2 += (l - uint32(i)) * v
+	}
+	return (s1 & 0xffff) | (s2 << 16)
+}
+
+// rsyncChecksum2 returns the strong checksum for the given block, the checksum
+// seed is appended to the block data
+func rsyncChecksum2(buf []byte, seed int32) []byte {
+	h := md4.New()
+	h.Write(buf) //nolint:errcheck
+	if seed != 0 {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(seed))
+		h.Write(b[:]) //nolint:errcheck
+	}
+	return h.Sum(nil)
+}
+
+// newRsyncFileHasher returns the hash used to verify the whole transferred file,
+// the checksum seed is prepended to the file data
+func newRsyncFileHasher(seed int32) hash.Hash {
+	h := md4.New()
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(seed))
+	h.Write(b[:]) //nolint:errcheck
+	return h
+}
+
+// rsyncFileChecksum returns the checksum used for the --checksum option
+func rsyncFileChecksum(r io.Reader) ([]byte, error) {
+	h := md4.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func rsyncGetBlockLength(size int64) int32 {
+	if size <= rsyncBlockSize*rsyncBlockSize {
+		return rsyncBlockSize
+	}
+	blength := int64(math.Sqrt(float64(size))) &^ 7
+	if blength > rsyncMaxBlockSize {
+		blength = rsyncMaxBlockSize
+	}
+	return int32(blength)
+}
+
+// rsyncComputeSums computes the block checksums for the basis file read from r
+func rsyncComputeSums(r io.Reader, size int64, seed int32) (*rsyncSumHead, error) {
+	blength := rsyncGetBlockLength(size)
+	count := (size + int64(blength) - 1) / int64(blength)
+	if count > rsyncMaxSumCount {
+		return nil, fmt.Errorf("file too large to compute block checksums: %d", size)
+	}
+	head := &rsyncSumHead{
+		count:     int32(count),
+		blength:   blength,
+		s2length:  rsyncSumLength,
+		remainder: int32(size % int64(blength)),
+		sums:      make([]rsyncBlockSum, 0, count),
+	}
+	buf := make([]byte, blength)
+	for i := int32(0); i < head.count; i++ {
+		block := buf[:head.getBlockLength(i)]
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		head.sums = append(head.sums, rsyncBlockSum{
+			sum1: rsyncChecksum1(block),
+			sum2: rsyncChecksum2(block, seed),
+		})
+	}
+	return head, nil
+}
+
+// rsyncSendDelta reads the file to send from r and writes the literal data and the
+// matched block tokens for the basis file described by head. The whole file checksum
+// is written at the end. Errors reading the source file are returned after the
+// file checksum is sent: we send an empty checksum in this case so the client will
+// discard the received data.
+func rsyncSendDelta(conn *rsyncConn, r io.Reader, head *rsyncSumHead, seed int32) error {
+	fileHash := newRsyncFileHasher(seed)
+	var readErr error
+	switch {
+	case conn.deflater != nil:
+		// matched blocks must be added to the compressor history without producing
+		// any output and the standard library does not allow this, compressed files
+		// are always sent as literal data
+		readErr = conn.deflater.send(conn, r, fileHash)
+	case head.count == 0 || head.blength > rsyncMaxMatchBlockSize:
+		readErr = rsyncSendLiteral(conn, r, fileHash)
+		conn.writeInt32(0) //nolint:errcheck
+	default:
+		readErr = rsyncSendMatches(conn, r, head, seed, fileHash)
+		conn.writeInt32(0) //nolint:errcheck
+	}
+	if readErr != nil {
+		conn.write(make([]byte, rsyncSumLength)) //nolint:errcheck
+		return readErr
+	}
+	return conn.write(fileHash.Sum(nil))
+}
+
+func rsyncSendLiteral(conn *rsyncConn, r io.Reader, fileHash hash.Hash) error {
+	buf := make([]byte, rsyncChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			conn.writeInt32(int32(n)) //nolint:errcheck
+			conn.write(buf[:n])       //nolint:errcheck
+			fileHash.Write(buf[:n])   //nolint:errcheck
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if conn.failed() {
+			return nil
+		}
+	}
+}
+
+func rsyncWriteLiteral(conn *rsyncConn, data []byte, fileHash hash.Hash) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > rsyncChunkSize {
+			n = rsyncChunkSize
+		}
+		conn.writeInt32(int32(n)) //nolint:errcheck
+		conn.write(data[:n])      //nolint:errcheck
+		fileHash.Write(data[:n])  //nolint:errcheck
+		data = data[n:]
+	}
+}
+
+func rsyncSendMatches(conn *rsyncConn, r io.Reader, head *rsyncSumHead, seed int32, fileHash hash.Hash) error {
+	table := make(map[uint32][]int32, len(head.sums))
+	for idx, s := range head.sums {
+		table[s.sum1] = append(table[s.sum1], int32(idx))
+	}
+	blength := int(head.blength)
+	// buf contains the pending literal data, from its start to k, and the current
+	// window, from k to k+windowLen
+	// the pending literal data is flushed when it reaches the chunk size so the buffer
+	// capacity is always enough
+	buf := make([]byte, 0, rsyncChunkSize+2*blength)
+	eof := false
+	fill := func(size int) error {
+		for len(buf) < size && !eof {
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := fill(blength); err != nil {
+		return err
+	}
+	k := 0
+	windowLen := blength
+	if windowLen > len(buf) {
+		windowLen = len(buf)
+	}
+	sum1 := rsyncChecksum1(buf[:windowLen])
+	s1 := sum1 & 0xffff
+	s2 := sum1 >> 16
+	lastMatch := int32(-1)
+
+	for windowLen > 0 {
+		if conn.failed() {
+			return nil
+		}
+		matched := int32(-1)
+		if candidates, ok := table[(s1&0xffff)|(s2<<16)]; ok {
+			var strongSum []byte
+			for _, idx := range candidates {
+				if int(head.getBlockLength(idx)) != windowLen {
+					continue
+				}
+				if strongSum == nil {
+					strongSum = rsyncChecksum2(buf[k:k+windowLen], seed)
+				}
+				if bytes.Equal(strongSum[:head.s2length], head.sums[idx].sum2) {
+					matched = idx
+					if idx == lastMatch+1 {
+						break
+					}
+				}
+			}
+		}
+		if matched >= 0 {
+			rsyncWriteLiteral(conn, buf[:k], fileHash)
+			conn.writeInt32(-(matched + 1))      //nolint:errcheck
+			fileHash.Write(buf[k : k+windowLen]) //nolint:errcheck
+			lastMatch = matched
+			buf = buf[:copy(buf, buf[k+windowLen:])]
+			k = 0
+			if err := fill(blength); err != nil {
+				return err
+			}
+			windowLen = blength
+			if windowLen > len(buf) {
+				windowLen = len(buf)
+			}
+			sum1 = rsyncChecksum1(buf[:windowLen])
+			s1 = sum1 & 0xffff
+			s2 = sum1 >> 16
+			continue
+		}
+		// no match, move the window forward by one byte
+		out := uint32(int8(buf[k]))
+		k++
+		if k >= rsyncChunkSize {
+			rsyncWriteLiteral(conn, buf[:k], fileHash)
+			buf = buf[:copy(buf, buf[k:])]
+			k = 0
+		}
+		if err := fill(k + blength); err != nil {
+			return err
+		}
+		if k+windowLen <= len(buf) {
+			in := uint32(int8(buf[k+windowLen-1]))
+			s1 = s1 - out + in
+			s2 = s2 - uint32(windowLen)*out + s1
+		} else {
+			// we are at the end of the file, the window shrinks
+			s1 -= out
+			s2 -= uint32(windowLen) * out
+			windowLen--
+		}
+	}
+	rsyncWriteLiteral(conn, buf[:k], fileHash)
+	return nil
+}
+
+// rsyncDeltaWriter writes the literal data and the matched blocks received from
+// the client to the destination file
+type rsyncDeltaWriter struct {
+	head     *rsyncSumHead
+	basis    io.ReaderAt
+	w        io.Writer
+	fileHash hash.Hash
+	buf      []byte
+	isValid  bool
+}
+
+func (d *rsyncDeltaWriter) writeLiteral(p []byte) {
+	d.fileHash.Write(p) //nolint:errcheck
+	d.w.Write(p)        //nolint:errcheck
+}
+
+// writeBlock writes the block with the given index read from the basis file.
+// It returns the block data or nil if the basis file cannot be read
+func (d *rsyncDeltaWriter) writeBlock(idx int32) ([]byte, error) {
+	if idx < 0 || idx >= d.head.count {
+		return nil, fmt.Errorf("%w: invalid block index %d, number of blocks %d", errRsyncProtocol, idx, d.head.count)
+	}
+	if d.basis == nil {
+		d.isValid = false
+		return nil, nil
+	}
+	blockLen := int(d.head.getBlockLength(idx))
+	if blockLen > len(d.buf) {
+		d.buf = make([]byte, blockLen)
+	}
+	n, _ := d.basis.ReadAt(d.buf[:blockLen], int64(idx)*int64(d.head.blength))
+	if n != blockLen {
+		d.basis = nil
+		d.isValid = false
+		return nil, nil
+	}
+	d.writeLiteral(d.buf[:blockLen])
+	return d.buf[:blockLen], nil
+}
+
+// rsyncReceiveDelta reads the literal data and the matched block tokens from the
+// client and writes the resulting file to w. Matched blocks are read from basis.
+// It returns false if the whole file checksum does not match
+func rsyncReceiveDelta(conn *rsyncConn, head *rsyncSumHead, basis io.ReaderAt, w io.Writer, seed int32) (bool, error) {
+	d := &rsyncDeltaWriter{
+		head:     head,
+		basis:    basis,
+		w:        w,
+		fileHash: newRsyncFileHasher(seed),
+		isValid:  true,
+	}
+	var err error
+	if conn.inflater != nil {
+		err = conn.inflater.receive(d)
+	} else {
+		err = rsyncReceiveTokens(conn, d)
+	}
+	if err != nil {
+		return false, err
+	}
+	sum := make([]byte, rsyncSumLength)
+	if err := conn.readFull(sum); err != nil {
+		return false, err
+	}
+	return d.isValid && bytes.Equal(sum, d.fileHash.Sum(nil)), nil
+}
+
+func rsyncReceiveTokens(conn *rsyncConn, d *rsyncDeltaWriter) error {
+	buf := make([]byte, rsyncChunkSize)
+	for {
+		token, err := conn.readInt32()
+		if err != nil {
+			return err
+		}
+		if token == 0 {
+			return nil
+		}
+		if token > 0 {
+			for remaining := int(token); remaining > 0; {
+				n := remaining
+				if n > len(buf) {
+					n = len(buf)
+				}
+				if err := conn.readFull(buf[:n]); err != nil {
+					return err
+				}
+				d.writeLiteral(buf[:n])
+				remaining -= n
+			}
+			continue
+		}
+		if _, err := d.writeBlock(-(token + 1)); err != nil {
+			return err
+		}
+	}
+}
+
+// rsyncDeflater writes the file data as compressed tokens. The data is compressed
+// using raw deflate and a sync flush is done at the end of the file. The flushed
+// data ends with a fixed marker that is not sent on the wire
+type rsyncDeflater struct {
+	w   *flate.Writer
+	out []byte
+}
+
+// Write receives the compressed data
+func (d *rsyncDeflater) Write(p []byte) (int, error) {
+	d.out = append(d.out, p...)
+	return len(p), nil
+}
+
+func (d *rsyncDeflater) send(conn *rsyncConn, r io.Reader, fileHash hash.Hash) error {
+	d.w.Reset(d)
+	d.out = d.out[:0]
+	buf := make([]byte, rsyncChunkSize)
+	hasData := false
+	var readErr error
+	for !conn.failed() {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			hasData = true
+			fileHash.Write(buf[:n]) //nolint:errcheck
+			d.w.Write(buf[:n])      //nolint:errcheck
+			d.writeFrames(conn, false)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	if hasData {
+		d.w.Flush() //nolint:errcheck
+		d.out = bytes.TrimSuffix(d.out, rsyncSyncMarker)
+		d.writeFrames(conn, true)
+	}
+	conn.writeByte(rsyncTokenEnd) //nolint:errcheck
+	return readErr
+}
+
+// writeFrames sends the compressed data. The last bytes are kept until the
+// data is flushed, they could be the sync marker
+func (d *rsyncDeflater) writeFrames(conn *rsyncConn, flushed bool) {
+	for len(d.out) > rsyncMaxDeflatedSize+len(rsyncSyncMarker) || (flushed && len(d.out) > 0) {
+		n := len(d.out)
+		if n > rsyncMaxDeflatedSize {
+			n = rsyncMaxDeflatedSize
+		}
+		conn.write([]byte{rsyncTokenDeflated | byte(n>>8), byte(n)}) //nolint:errcheck
+		conn.write(d.out[:n])                                        //nolint:errcheck
+		d.out = d.out[:copy(d.out, d.out[n:])]
+	}
+}
+
+// rsyncInflater reads the compressed tokens sent by the client. Deflated data
+// are decompressed until the next token, the sync marker that ends them is
+// added back before the token. Matched blocks are added to the decompressor
+// history, if required, so the history is tracked here and used as dictionary
+// each time the decompression restarts
+type rsyncInflater struct {
+	conn       *rsyncConn
+	seeBlocks  bool
+	r          io.ReadCloser
+	history    []byte
+	buf        []byte
+	remaining  int
+	flag       byte
+	hasFlag    bool
+	markerSent int
+}
+
+// Read implements io.Reader for the decompressor
+func (i *rsyncInflater) Read(p []byte) (int, error) {
+	for i.remaining == 0 {
+		if i.hasFlag {
+			if i.markerSent == len(rsyncSyncMarker) {
+				return 0, io.EOF
+			}
+			n := copy(p, rsyncSyncMarker[i.markerSent:])
+			i.markerSent += n
+			return n, nil
+		}
+		flag, err := i.conn.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if flag&rsyncTokenRunRel != rsyncTokenDeflated {
+			i.flag = flag
+			i.hasFlag = true
+			i.markerSent = 0
+			continue
+		}
+		if err := i.readDeflatedSize(flag); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > i.remaining {
+		p = p[:i.remaining]
+	}
+	if err := i.conn.readFull(p); err != nil {
+		return 0, err
+	}
+	i.remaining -= len(p)
+	return len(p), nil
+}
+
+// ReadByte implements io.ByteReader, so the decompressor does not read ahead
+func (i *rsyncInflater) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := i.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (i *rsyncInflater) readDeflatedSize(flag byte) error {
+	b, err := i.conn.readByte()
+	if err != nil {
+		return err
+	}
+	i.remaining = int(flag&^rsyncTokenRunRel)<<8 | int(b)
+	return nil
+}
+
+func (i *rsyncInflater) readFlag() (byte, error) {
+	if i.hasFlag {
+		i.hasFlag = false
+		return i.flag, nil
+	}
+	return i.conn.readByte()
+}
+
+func (i *rsyncInflater) addHistory(p []byte) {
+	if len(p) >= rsyncDeflateWindow {
+		i.history = append(i.history[:0], p[len(p)-rsyncDeflateWindow:]...)
+		return
+	}
+	if drop := len(i.history) + len(p) - rsyncDeflateWindow; drop > 0 {
+		i.history = i.history[:copy(i.history, i.history[drop:])]
+	}
+	i.history = append(i.history, p...)
+}
+
+// seeBlock adds a matched block to the decompressor history as the client does.
+// Before protocol version 31 the data is added in chunks all starting from the
+// beginning of the block
+func (i *rsyncInflater) seeBlock(block []byte) {
+	for remaining := len(block); remaining > 0; {
+		n := remaining
+		if n > rsyncMaxSeeSize {
+			n = rsyncMaxSeeSize
+		}
+		i.addHistory(block[:n])
+		remaining -= n
+	}
+}
+
+func (i *rsyncInflater) receive(d *rsyncDeltaWriter) error {
+	// the decompressor state is reset for each file
+	i.history = i.history[:0]
+	var token int32
+	for {
+		flag, err := i.readFlag()
+		if err != nil {
+			return err
+		}
+		if flag&rsyncTokenRunRel == rsyncTokenDeflated {
+			if err := i.inflate(flag, d); err != nil {
+				return err
+			}
+			continue
+		}
+		if flag == rsyncTokenEnd {
+			return nil
+		}
+		if flag&rsyncTokenRel != 0 {
+			token += int32(flag &^ rsyncTokenRunRel)
+			flag >>= 6
+		} else if token, err = i.conn.readInt32(); err != nil {
+			return err
+		}
+		run := 0
+		if flag&1 != 0 {
+			var b [2]byte
+			if err := i.conn.readFull(b[:]); err != nil {
+				return err
+			}
+			run = int(binary.LittleEndian.Uint16(b[:]))
+		}
+		for n := 0; n <= run; n++ {
+			if n > 0 {
+				token++
+			}
+			block, err := d.writeBlock(token)
+			if err != nil {
+				return err
+			}
+			if block != nil && i.seeBlocks {
+				i.seeBlock(block)
+			}
+		}
+	}
+}
+
+func (i *rsyncInflater) inflate(flag byte, d *rsyncDeltaWriter) error {
+	if err := i.readDeflatedSize(flag); err != nil {
+		return err
+	}
+	if i.r == nil {
+		i.r = flate.NewReaderDict(i, i.history)
+		i.buf = make([]byte, rsyncChunkSize)
+	} else if err := i.r.(flate.Resetter).Reset(i, i.history); err != nil {
+		return err
+	}
+	for {
+		n, err := i.r.Read(i.buf)
+		if n > 0 {
+			d.writeLiteral(i.buf[:n])
+			i.addHistory(i.buf[:n])
+		}
+		if err != nil {
+			// after the sync marker the decompressor tries to read the next block
+			if err == io.ErrUnexpectedEOF && i.hasFlag && i.markerSent == len(rsyncSyncMarker) {
+				return nil
+			}
+			return fmt.Errorf("%w: unable to decompress data: %v", errRsyncProtocol, err)
+		}
+	}
+}
@@ -153,7 +153,7 @@ func (c *scpCommand) handleRecursiveUpload() error {
 func (c *scpCommand) handleCreateDir(fs vfs.Fs, dirPath string) error {
 	c.connection.UpdateLastActivity()
 
-	p, err := fs.ResolvePath(dirPath)
+	_, p, err := c.connection.GetFsAndResolvedPath(dirPath)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "error creating dir: %#v, invalid file path, err: %v", dirPath, err)
 		c.sendErrorMessage(fs, err)
@@ -236,6 +236,10 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		c.sendErrorMessage(nil, err)
 		return err
 	}
+	if err := c.connection.CheckWebDAVLocks(requestPath, false); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
 	err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath,
 		fileSize, os.O_TRUNC)
 	if err != nil {
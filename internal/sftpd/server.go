@@ -39,7 +39,6 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
-	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 const (
@@ -50,7 +49,7 @@ const (
 )
 
 var (
-	sftpExtensions        = []string{"statvfs@openssh.com"}
+	sftpExtensions        = []string{"statvfs@openssh.com", "posix-rename@openssh.com", "hardlink@openssh.com"}
 	supportedHostKeyAlgos = []string{
 		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
 		ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
@@ -134,6 +133,15 @@ type Configuration struct {
 	// If empty or missing, the daemon will search or try to generate "id_rsa" and "id_ecdsa" host keys
 	// inside the configuration directory.
 	HostKeys []string `json:"host_keys" mapstructure:"host_keys"`
+	// AdvertisedHostKeys define private host keys advertised to the clients, using the
+	// hostkeys-00@openssh.com extension, but not used for the key exchange.
+	// This allows clients to learn new host keys before they are used.
+	// Each host key can be defined as a path relative to the configuration directory or an absolute one.
+	AdvertisedHostKeys []string `json:"advertised_host_keys" mapstructure:"advertised_host_keys"`
+	// Path to the file where the host keys rotation state is stored. Host keys can be generated,
+	// promoted and retired using the REST API only if this file is set.
+	// The path can be absolute or relative to the configuration directory.
+	HostKeysRotationFile string `json:"host_keys_rotation_file" mapstructure:"host_keys_rotation_file"`
 	// HostCertificates defines public host certificates.
 	// Each certificate can be defined as a path relative to the configuration directory or an absolute one.
 	// Certificate's public key must match a private host key otherwise it will be silently ignored.
@@ -164,6 +172,8 @@ type Configuration struct {
 	// Example content:
 	// ["SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es","SHA256:119+8cL/HH+NLMawRsJx6CzPF1I3xC+jpM60bQHXGE8"]
 	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
+	// UserCA configures the built-in certificate authority that issues short-lived user certificates
+	UserCA UserCA `json:"user_ca" mapstructure:"user_ca"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -197,6 +207,11 @@ type Configuration struct {
 	KeyboardInteractiveHook string `json:"keyboard_interactive_auth_hook" mapstructure:"keyboard_interactive_auth_hook"`
 	// PasswordAuthentication specifies whether password authentication is allowed.
 	PasswordAuthentication bool `json:"password_authentication" mapstructure:"password_authentication"`
+	// RestrictedShell enables an interactive shell for SSH sessions. The shell only allows
+	// built-in file management commands, for example ls, cp, mv, rm, implemented inside SFTPGo
+	// using the user's permissions, so no system command is ever executed and any storage
+	// backend is supported. The shell is disabled if a folder prefix is configured.
+	RestrictedShell bool `json:"restricted_shell" mapstructure:"restricted_shell"`
 	// Virtual root folder prefix to include in all file operations (ex: /files).
 	// The virtual paths used for per-directory permissions, file patterns etc. must not include the folder prefix.
 	// The prefix is only applied to SFTP requests, SCP and other SSH commands will be automatically disabled if
@@ -241,6 +256,19 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 
 			return sp, nil
 		},
+		PublicKeySignatureCallback: func(conn ssh.ConnMetadata, pubKey ssh.PublicKey, perms *ssh.Permissions, sig *ssh.Signature) error {
+			if err := checkSecurityKeySignature(perms, sig); err != nil {
+				connectionID := hex.EncodeToString(conn.SessionID())
+				logger.Info(logSender, connectionID, "public key %s not accepted for user %q: %v",
+					ssh.FingerprintSHA256(pubKey), conn.User(), err)
+				var user dataprovider.User
+				user.Username = conn.User()
+				ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+				updateLoginMetrics(&user, ipAddr, dataprovider.SSHLoginMethodPublicKey, err)
+				return &authenticationError{err: fmt.Sprintf("could not validate public key signature: %v", err)}
+			}
+			return nil
+		},
 		NextAuthMethodsCallback: func(conn ssh.ConnMetadata) []string {
 			var nextMethods []string
 			user, err := dataprovider.GetUserWithGroupSettings(conn.User(), "")
@@ -291,11 +319,15 @@ func (c *Configuration) Initialize(configDir string) error {
 		return common.ErrNoBinding
 	}
 
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
+	if err := c.checkAndLoadHostKeys(configDir); err != nil {
 		serviceStatus.HostKeys = nil
 		return err
 	}
 
+	if err := userCAManager.load(c.UserCA, configDir); err != nil {
+		return err
+	}
+
 	if err := c.initializeCertChecker(configDir); err != nil {
 		return err
 	}
@@ -518,7 +550,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, hostKeysManager.getServerConfig(config))
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection: %v", err)
 		checkAuthError(ipAddr, err)
@@ -535,6 +567,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	json.Unmarshal([]byte(sconn.Permissions.Extensions["sftpgo_user"]), &user) //nolint:errcheck
 
 	loginType := sconn.Permissions.Extensions["sftpgo_login_method"]
+	restrictions := getPublicKeyRestrictions(sconn.Permissions)
 	connectionID := hex.EncodeToString(sconn.SessionID())
 
 	defer user.CloseFs() //nolint:errcheck
@@ -553,10 +586,30 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	defer common.Connections.RemoveSSHConnection(connectionID)
 
-	go ssh.DiscardRequests(reqs)
+	go handleGlobalRequests(reqs, sconn.SessionID(), connectionID)
+	advertiseHostKeys(sconn, connectionID)
 
 	channelCounter := int64(0)
 	for newChannel := range chans {
+		if newChannel.ChannelType() == directTCPIPChannelType {
+			if restrictions.noPortForwarding {
+				logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
+					"port forwarding denied, it is disabled for the used public key")
+				newChannel.Reject(ssh.Prohibited, "port forwarding is not allowed") //nolint:errcheck
+				continue
+			}
+			channelCounter++
+			sshConnection.UpdateLastActivity()
+			connection := &Connection{
+				BaseConnection: common.NewBaseConnection(fmt.Sprintf("%s_%d", connectionID, channelCounter),
+					common.ProtocolSSH, conn.LocalAddr().String(), conn.RemoteAddr().String(), user),
+				ClientVersion: string(sconn.ClientVersion()),
+				RemoteAddr:    conn.RemoteAddr(),
+				LocalAddr:     conn.LocalAddr(),
+			}
+			go handleDirectTCPIP(newChannel, connection)
+			continue
+		}
 		// If its not a session channel we just move on because its not something we
 		// know how to handle at this point.
 		if newChannel.ChannelType() != "session" {
@@ -575,15 +628,49 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		channelCounter++
 		sshConnection.UpdateLastActivity()
 		// Channels have a type that is dependent on the protocol. For SFTP this is "subsystem"
-		// with a payload that (should) be "sftp". Discard anything else we receive ("pty", "shell", etc)
+		// with a payload that (should) be "sftp". "pty-req" and "shell" are only accepted if the
+		// restricted shell is enabled, anything else is discarded
 		go func(in <-chan *ssh.Request, counter int64) {
+			var shell *restrictedShell
+			var ptyWidth, ptyHeight int
 			for req := range in {
 				ok := false
 				connID := fmt.Sprintf("%s_%d", connectionID, counter)
 
 				switch req.Type {
+				case "pty-req":
+					if c.RestrictedShell && shell == nil && !restrictions.noPty {
+						ptyWidth, ptyHeight, ok = parsePtyRequest(req.Payload)
+					}
+				case "window-change":
+					if shell != nil {
+						if width, height, parsed := parseWindowChange(req.Payload); parsed {
+							shell.setSize(width, height)
+						}
+					}
+				case "shell":
+					if restrictions.forcedCommand != "" {
+						// like OpenSSH the forced command is executed if the client requests a shell
+						if !restrictions.isSFTPOnly() {
+							connection := c.newExecConnection(connID, conn, sconn, channel, user)
+							ok = processSSHCommand(restrictions.getForcedCommandPayload(), connection,
+								c.EnabledSSHCommands)
+						}
+					} else if c.RestrictedShell && shell == nil {
+						ok = true
+						connection := &Connection{
+							BaseConnection: common.NewBaseConnection(connID, common.ProtocolSSH, conn.LocalAddr().String(),
+								conn.RemoteAddr().String(), user),
+							ClientVersion: string(sconn.ClientVersion()),
+							RemoteAddr:    conn.RemoteAddr(),
+							LocalAddr:     conn.LocalAddr(),
+							channel:       channel,
+						}
+						shell = newRestrictedShell(connection, ptyWidth, ptyHeight)
+						go shell.handle() //nolint:errcheck
+					}
 				case "subsystem":
-					if string(req.Payload[4:]) == "sftp" {
+					if string(req.Payload[4:]) == "sftp" && restrictions.canUseSFTP() {
 						ok = true
 						connection := &Connection{
 							BaseConnection: common.NewBaseConnection(connID, common.ProtocolSFTP, conn.LocalAddr().String(),
@@ -597,17 +684,15 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						go c.handleSftpConnection(channel, connection)
 					}
 				case "exec":
-					// protocol will be set later inside processSSHCommand it could be SSH or SCP
-					connection := Connection{
-						BaseConnection: common.NewBaseConnection(connID, "sshd_exec", conn.LocalAddr().String(),
-							conn.RemoteAddr().String(), user),
-						ClientVersion: string(sconn.ClientVersion()),
-						RemoteAddr:    conn.RemoteAddr(),
-						LocalAddr:     conn.LocalAddr(),
-						channel:       channel,
-						folderPrefix:  c.FolderPrefix,
+					if restrictions.isSFTPOnly() {
+						break
+					}
+					payload := req.Payload
+					if restrictions.forcedCommand != "" {
+						payload = restrictions.getForcedCommandPayload()
 					}
-					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+					connection := c.newExecConnection(connID, conn, sconn, channel, user)
+					ok = processSSHCommand(payload, connection, c.EnabledSSHCommands)
 				}
 				if req.WantReply {
 					req.Reply(ok, nil) //nolint:errcheck
@@ -617,6 +702,21 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	}
 }
 
+func (c *Configuration) newExecConnection(connID string, conn net.Conn, sconn *ssh.ServerConn, channel ssh.Channel,
+	user dataprovider.User,
+) *Connection {
+	// protocol will be set later inside processSSHCommand it could be SSH or SCP
+	return &Connection{
+		BaseConnection: common.NewBaseConnection(connID, "sshd_exec", conn.LocalAddr().String(),
+			conn.RemoteAddr().String(), user),
+		ClientVersion: string(sconn.ClientVersion()),
+		RemoteAddr:    conn.RemoteAddr(),
+		LocalAddr:     conn.LocalAddr(),
+		channel:       channel,
+		folderPrefix:  c.FolderPrefix,
+	}
+}
+
 func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Connection) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -631,7 +731,7 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	defer common.Connections.Remove(connection.GetID())
 
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, c.createHandlers(connection), sftp.WithRSAllocator(),
+	server := sftp.NewRequestServer(newExtensionsChannel(channel, connection), c.createHandlers(connection), sftp.WithRSAllocator(),
 		sftp.WithStartDirectory(connection.User.Filters.StartDirectory))
 
 	defer server.Close()
@@ -770,7 +870,9 @@ func (c *Configuration) checkFolderPrefix() {
 	}
 	if c.FolderPrefix != "" {
 		c.EnabledSSHCommands = nil
-		logger.Debug(logSender, "", "folder prefix %#v configured, SSH commands are disabled", c.FolderPrefix)
+		c.RestrictedShell = false
+		logger.Debug(logSender, "", "folder prefix %#v configured, SSH commands and restricted shell are disabled",
+			c.FolderPrefix)
 	}
 }
 
@@ -874,7 +976,7 @@ func (c *Configuration) loadModuli(configDir string) error {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) checkAndLoadHostKeys(configDir string) error {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
 		return err
 	}
@@ -882,8 +984,27 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 	if err != nil {
 		return err
 	}
-	serviceStatus.HostKeys = nil
-	for _, hostKey := range c.HostKeys {
+	rotationFile := strings.TrimSpace(c.HostKeysRotationFile)
+	if rotationFile != "" {
+		if !util.IsFileInputValid(rotationFile) {
+			return fmt.Errorf("invalid host keys rotation file %q", rotationFile)
+		}
+		if !filepath.IsAbs(rotationFile) {
+			rotationFile = filepath.Join(configDir, rotationFile)
+		}
+	}
+	err = hostKeysManager.load(c.getHostKeyPaths(configDir, c.HostKeys), c.getHostKeyPaths(configDir, c.AdvertisedHostKeys),
+		hostCertificates, rotationFile)
+	if err != nil {
+		return err
+	}
+	hostKeysManager.updateServiceStatus()
+	return nil
+}
+
+func (c *Configuration) getHostKeyPaths(configDir string, hostKeys []string) []string {
+	var result []string
+	for _, hostKey := range hostKeys {
 		hostKey = strings.TrimSpace(hostKey)
 		if !util.IsFileInputValid(hostKey) {
 			logger.Warn(logSender, "", "unable to load invalid host key %q", hostKey)
@@ -893,43 +1014,9 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		if !filepath.IsAbs(hostKey) {
 			hostKey = filepath.Join(configDir, hostKey)
 		}
-		logger.Info(logSender, "", "Loading private host key %q", hostKey)
-
-		privateBytes, err := os.ReadFile(hostKey)
-		if err != nil {
-			return err
-		}
-
-		private, err := ssh.ParsePrivateKey(privateBytes)
-		if err != nil {
-			return err
-		}
-		k := HostKey{
-			Path:        hostKey,
-			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
-		}
-		serviceStatus.HostKeys = append(serviceStatus.HostKeys, k)
-		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
-			private.PublicKey().Type(), k.Fingerprint)
-
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(private)
-		for _, cert := range hostCertificates {
-			signer, err := ssh.NewCertSigner(cert, private)
-			if err == nil {
-				serverConfig.AddHostKey(signer)
-				logger.Info(logSender, "", "Host certificate loaded for host key %#v, fingerprint %#v",
-					hostKey, ssh.FingerprintSHA256(signer.PublicKey()))
-			}
-		}
+		result = append(result, hostKey)
 	}
-	var fp []string
-	for idx := range serviceStatus.HostKeys {
-		h := &serviceStatus.HostKeys[idx]
-		fp = append(fp, h.Fingerprint)
-	}
-	vfs.SetSFTPFingerprints(fp)
-	return nil
+	return result
 }
 
 func (c *Configuration) loadHostCertificates(configDir string) ([]*ssh.Certificate, error) {
@@ -999,6 +1086,10 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 					return true
 				}
 			}
+			// the built-in CA is always trusted, if enabled
+			if caKey := userCAManager.getPublicKey(); caKey != nil {
+				return bytes.Equal(k.Marshal(), caKey.Marshal())
+			}
 			return false
 		},
 	}
@@ -1060,13 +1151,39 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		certPerm = &cert.Permissions
 	}
 	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH, ok); err == nil {
+		var keyOptions dataprovider.PublicKeyOptions
+		if user.Filters.SecurityKeysOnly && !dataprovider.IsSecurityKey(pubKey) {
+			err = fmt.Errorf("key type %q not allowed, user %q can only login using security keys", pubKey.Type(),
+				conn.User())
+			logger.Info(logSender, connectionID, "public key %s not accepted: %v", ssh.FingerprintSHA256(pubKey), err)
+			user.Username = conn.User()
+			updateLoginMetrics(&user, ipAddr, method, err)
+			return nil, err
+		}
 		if ok {
 			keyID = fmt.Sprintf("%s: ID: %s, serial: %v, CA %s %s", certFingerprint,
 				cert.KeyId, cert.Serial, cert.Type(), ssh.FingerprintSHA256(cert.SignatureKey))
+		} else {
+			keyOptions, err = checkPublicKeyOptions(&user, pubKey, ipAddr)
+			if err != nil {
+				logger.Info(logSender, connectionID, "public key %s not accepted for user %q: %v",
+					ssh.FingerprintSHA256(pubKey), conn.User(), err)
+				user.Username = conn.User()
+				updateLoginMetrics(&user, ipAddr, method, err)
+				return nil, err
+			}
 		}
 		if user.IsPartialAuth(method) {
 			logger.Debug(logSender, connectionID, "user %#v authenticated with partial success", conn.User())
-			return certPerm, ssh.ErrPartialSuccess
+			if !ok {
+				// the permissions returned here are not used, the restrictions are applied after the next step
+				partialAuthKeys.add(connectionID, partialAuthKey{
+					username:    conn.User(),
+					fingerprint: ssh.FingerprintSHA256(pubKey),
+					options:     keyOptions,
+				})
+			}
+			return setSecurityKeyRequiredFlags(certPerm, getSecurityKeyRequiredFlags(&user, pubKey)), ssh.ErrPartialSuccess
 		}
 		sshPerm, err = loginUser(&user, method, keyID, conn)
 		if err == nil && certPerm != nil {
@@ -1079,6 +1196,13 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 				}
 			}
 		}
+		if err == nil {
+			sshPerm = setSecurityKeyRequiredFlags(sshPerm, getSecurityKeyRequiredFlags(&user, pubKey))
+		}
+		if err == nil && !ok {
+			setPublicKeyRestrictions(sshPerm, &keyOptions)
+			dataprovider.UpdatePublicKeyLastUse(&user, ssh.FingerprintSHA256(pubKey))
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
@@ -1097,6 +1221,9 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
+		if err == nil && method == dataprovider.SSHLoginMethodKeyAndPassword {
+			applyPartialAuthKey(conn, &user, sshPerm)
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
@@ -1116,6 +1243,9 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
 		ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
+		if err == nil && method == dataprovider.SSHLoginMethodKeyAndKeyboardInt {
+			applyPartialAuthKey(conn, &user, sshPerm)
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
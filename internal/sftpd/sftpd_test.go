@@ -18,11 +18,13 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -55,6 +57,7 @@ import (
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -161,11 +164,13 @@ var (
 	scpForce         bool
 	gitPath          string
 	sshPath          string
+	rsyncPath        string
 	hookCmdPath      string
 	pubKeyPath       string
 	privateKeyPath   string
 	trustedCAUserKey string
 	revokeUserCerts  string
+	userCAKeyPath    string
 	gitWrapPath      string
 	extAuthPath      string
 	keyIntAuthPath   string
@@ -256,6 +261,7 @@ func TestMain(m *testing.M) {
 	sftpdConf.LoginBannerFile = loginBannerFileName
 	// we need to test all supported ssh commands
 	sftpdConf.EnabledSSHCommands = []string{"*"}
+	sftpdConf.RestrictedShell = true
 
 	keyIntAuthPath = filepath.Join(homeBasePath, "keyintauth.sh")
 	err = os.WriteFile(keyIntAuthPath, getKeyboardInteractiveScriptContent([]string{"1", "2"}, 0, false, 1), os.ModePerm)
@@ -269,6 +275,8 @@ func TestMain(m *testing.M) {
 	createInitialFiles(scriptArgs)
 	sftpdConf.TrustedUserCAKeys = append(sftpdConf.TrustedUserCAKeys, trustedCAUserKey)
 	sftpdConf.RevokedUserCertsFile = revokeUserCerts
+	sftpdConf.UserCA.Enabled = true
+	sftpdConf.UserCA.KeyPath = userCAKeyPath
 
 	go func() {
 		logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
@@ -355,6 +363,7 @@ func TestMain(m *testing.M) {
 	os.Remove(privateKeyPath)
 	os.Remove(trustedCAUserKey)
 	os.Remove(revokeUserCerts)
+	os.Remove(userCAKeyPath)
 	os.Remove(gitWrapPath)
 	os.Remove(extAuthPath)
 	os.Remove(preLoginPath)
@@ -381,6 +390,14 @@ func TestInitialization(t *testing.T) {
 	}
 	sftpdConf.LoginBannerFile = "invalid_file"
 	sftpdConf.EnabledSSHCommands = append(sftpdConf.EnabledSSHCommands, "ls")
+	sftpdConf.UserCA.Enabled = true
+	sftpdConf.UserCA.KeyPath = userCAKeyPath
+	sftpdConf.UserCA.DefaultValidity = 0
+	err = sftpdConf.Initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid default validity")
+	}
+	sftpdConf.UserCA.DefaultValidity = 60
 	err = sftpdConf.Initialize(configDir)
 	assert.Error(t, err)
 	sftpdConf.KeyboardInteractiveAuthentication = true
@@ -418,6 +435,8 @@ func TestInitialization(t *testing.T) {
 	err = sftpdConf.Initialize(configDir)
 	assert.EqualError(t, err, common.ErrNoBinding.Error())
 	sftpdConf = config.GetSFTPDConfig()
+	sftpdConf.UserCA.Enabled = true
+	sftpdConf.UserCA.KeyPath = userCAKeyPath
 	sftpdConf.Ciphers = []string{"not a cipher"}
 	err = sftpdConf.Initialize(configDir)
 	if assert.Error(t, err) {
@@ -1053,6 +1072,235 @@ func TestOpenReadWrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestInternalShare(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Permissions["/shared/sub"] = []string{dataprovider.PermListItems}
+	u.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/shared",
+			DeniedPatterns:  []string{"*.zip"},
+			DenyPolicy:      sdk.DenyPolicyDefault,
+			AllowedPatterns: []string{},
+		},
+	}
+	owner, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser(usePubKey)
+	u.Username += "_recipient"
+	u.HomeDir += "_recipient"
+	recipient, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(owner.GetHomeDir(), "shared", "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	for _, name := range []string{testFileName, "file.zip", filepath.Join("sub", testFileName)} {
+		err = os.WriteFile(filepath.Join(owner.GetHomeDir(), "shared", name), []byte("test data"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	share := dataprovider.Share{
+		ShareID:  "internal_share",
+		Name:     "share/1",
+		Scope:    dataprovider.ShareScopeRead,
+		Paths:    []string{"/shared"},
+		Username: owner.Username,
+		Users:    []string{recipient.Username},
+	}
+	err = dataprovider.AddShare(&share, owner.Username, "")
+	assert.NoError(t, err)
+
+	mountPath := path.Join(dataprovider.SharedWithMeDir, owner.Username, "share_1")
+	conn, client, err := getSftpClient(recipient, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		entries, err := client.ReadDir(dataprovider.SharedWithMeDir)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, owner.Username, entries[0].Name())
+		}
+		entries, err = client.ReadDir(mountPath)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+		f, err := client.Open(path.Join(mountPath, testFileName))
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("test data"), data)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		_, err = client.Create(path.Join(mountPath, testFileName+"_1"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Remove(path.Join(mountPath, testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Mkdir(path.Join(dataprovider.SharedWithMeDir, "dir"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		// the owner's permissions and file patterns apply to the sub paths
+		_, err = client.Open(path.Join(mountPath, "file.zip"))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		entries, err = client.ReadDir(path.Join(mountPath, "sub"))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		_, err = client.Open(path.Join(mountPath, "sub", testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+	}
+	// the owner cannot see shares of its own
+	conn, client, err = getSftpClient(owner, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		_, err = client.Stat(mountPath)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	// share names cannot escape the owner's directory
+	expiringShare := dataprovider.Share{
+		ShareID:   "expiring_share",
+		Name:      "..",
+		Scope:     dataprovider.ShareScopeRead,
+		Paths:     []string{"/shared"},
+		Username:  owner.Username,
+		Users:     []string{recipient.Username},
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(time.Now().Add(2 * time.Second)),
+	}
+	err = dataprovider.AddShare(&expiringShare, owner.Username, "")
+	assert.NoError(t, err)
+	expiringMountPath := path.Join(dataprovider.SharedWithMeDir, owner.Username, expiringShare.ShareID)
+	conn, client, err = getSftpClient(recipient, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		entries, err := client.ReadDir(path.Join(dataprovider.SharedWithMeDir, owner.Username))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		_, err = client.Stat(path.Join(expiringMountPath, testFileName))
+		assert.NoError(t, err)
+		// the expiration is checked for the active connections too
+		time.Sleep(2100 * time.Millisecond)
+		_, err = client.Open(path.Join(expiringMountPath, testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.ReadDir(expiringMountPath)
+		assert.ErrorIs(t, err, os.ErrPermission)
+		_, err = client.Stat(path.Join(mountPath, testFileName))
+		assert.NoError(t, err)
+	}
+
+	err = dataprovider.DeleteShare(share.ShareID, owner.Username, "")
+	assert.NoError(t, err)
+	err = dataprovider.DeleteShare(expiringShare.ShareID, owner.Username, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(recipient, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		_, err = client.Stat(mountPath)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	_, err = httpdtest.RemoveUser(owner, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(owner.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(recipient, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(recipient.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestInternalShareSymlink(t *testing.T) {
+	usePubKey := false
+	owner, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(usePubKey)
+	u.Username += "_recipient"
+	u.HomeDir += "_recipient"
+	recipient, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	outsideDir := filepath.Join(os.TempDir(), "outside_share")
+	err = os.MkdirAll(outsideDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(outsideDir, testFileName), []byte("secret"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(owner.GetHomeDir(), "shared"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(owner.GetHomeDir(), "shared", testFileName), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	// a symlink to a directory outside the home dir and one to a directory inside it
+	err = os.Symlink(outsideDir, filepath.Join(owner.GetHomeDir(), "outside"))
+	assert.NoError(t, err)
+	err = os.Symlink(filepath.Join(owner.GetHomeDir(), "shared"), filepath.Join(owner.GetHomeDir(), "inside"))
+	assert.NoError(t, err)
+
+	shares := []dataprovider.Share{
+		{
+			ShareID:  "outside_share",
+			Name:     "outside",
+			Scope:    dataprovider.ShareScopeRead,
+			Paths:    []string{"/outside"},
+			Username: owner.Username,
+			Users:    []string{recipient.Username},
+		},
+		{
+			ShareID:  "outside_sub_share",
+			Name:     "outside_sub",
+			Scope:    dataprovider.ShareScopeRead,
+			Paths:    []string{"/outside/sub"},
+			Username: owner.Username,
+			Users:    []string{recipient.Username},
+		},
+		{
+			ShareID:  "inside_share",
+			Name:     "inside",
+			Scope:    dataprovider.ShareScopeRead,
+			Paths:    []string{"/inside"},
+			Username: owner.Username,
+			Users:    []string{recipient.Username},
+		},
+	}
+	err = os.MkdirAll(filepath.Join(outsideDir, "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	for idx := range shares {
+		err = dataprovider.AddShare(&shares[idx], owner.Username, "")
+		assert.NoError(t, err)
+	}
+	conn, client, err := getSftpClient(recipient, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		entries, err := client.ReadDir(path.Join(dataprovider.SharedWithMeDir, owner.Username))
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "inside", entries[0].Name())
+		}
+		_, err = client.Stat(path.Join(dataprovider.SharedWithMeDir, owner.Username, "outside", testFileName))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat(path.Join(dataprovider.SharedWithMeDir, owner.Username, "outside_sub"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat(path.Join(dataprovider.SharedWithMeDir, owner.Username, "inside", testFileName))
+		assert.NoError(t, err)
+	}
+
+	for _, share := range shares {
+		err = dataprovider.DeleteShare(share.ShareID, owner.Username, "")
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(owner, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(owner.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(recipient, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(recipient.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(outsideDir)
+	assert.NoError(t, err)
+}
+
 func TestOpenReadWritePerm(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -2076,133 +2324,647 @@ func TestLogin(t *testing.T) {
 	assert.NoError(t, err)
 	conn, client, err = getSftpClient(user, true)
 	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestLoginUserCert(t *testing.T) {
+	u := getTestUser(true)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// try login using a cert signed from a trusted CA
+	signer, err := getSignerForUserCert([]byte(testCertValid))
+	assert.NoError(t, err)
+	conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// revoke the certificate
+	certs := []string{"SHA256:OkxVB1ImSJ2XeI8nA2Wg+6zJVlxdevD1FYBSEJjFEN4"}
+	data, err := json.Marshal(certs)
+	assert.NoError(t, err)
+	err = os.WriteFile(revokeUserCerts, data, 0644)
+	assert.NoError(t, err)
+	err = sftpd.Reload()
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// if we remove the revoked certificate login should work again
+	certs = []string{"SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es, SHA256:1kxVB1ImSJ2XeI8nA2Wg+6zJVlxdevD1FYBSEJjFEN4"}
+	data, err = json.Marshal(certs)
+	assert.NoError(t, err)
+	err = os.WriteFile(revokeUserCerts, data, 0644)
+	assert.NoError(t, err)
+	err = sftpd.Reload()
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+
+	// try login using a cert signed from an untrusted CA
+	signer, err = getSignerForUserCert([]byte(testCertUntrustedCA))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// try login using an host certificate instead of an user certificate
+	signer, err = getSignerForUserCert([]byte(testHostCert))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// try login using a user certificate with an authorized source address different from localhost
+	signer, err = getSignerForUserCert([]byte(testCertOtherSourceAddress))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// try login using an expired certificate
+	signer, err = getSignerForUserCert([]byte(testCertExpired))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// try login using a certificate with no principals
+	signer, err = getSignerForUserCert([]byte(testCertNoPrincipals))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	// the user does not exist
+	signer, err = getSignerForUserCert([]byte(testCertValid))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+
+	// now login with a username not in the set of valid principals for the given certificate
+	u.Username += "1"
+	user, _, err = httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	signer, err = getSignerForUserCert([]byte(testCertValid))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+
+	err = os.WriteFile(revokeUserCerts, []byte(`[]`), 0644)
+	assert.NoError(t, err)
+	err = sftpd.Reload()
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestLoginUserCertFromBuiltinCA(t *testing.T) {
+	u := getTestUser(true)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.True(t, sftpd.IsUserCAEnabled())
+	assert.True(t, strings.HasPrefix(sftpd.GetUserCAPublicKey(), ssh.KeyAlgoED25519))
+
+	certificate, validBefore, err := sftpd.SignUserPublicKey(&user, testPubKey, 0, "admin", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, validBefore.After(time.Now()))
+	signer, err := getSignerForUserCert([]byte(certificate))
+	assert.NoError(t, err)
+	conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// the certificate is valid only for the user it was issued for
+	u = getTestUser(true)
+	u.Username += "_1"
+	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user1, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// the allowed IP addresses are added as source-address critical option
+	user.Filters.AllowedIP = []string{"172.16.0.0/16"}
+	certificate, _, err = sftpd.SignUserPublicKey(&user, testPubKey, 5, "admin", "127.0.0.1")
+	assert.NoError(t, err)
+	signer, err = getSignerForUserCert([]byte(certificate))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPublicKeyOptions(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.PublicKeys = []string{`from="10.8.0.0/16" ` + testPubKey}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{`from="!10.*,127.0.0.1" ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPubKey)) //nolint:dogsled
+	require.NoError(t, err)
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Greater(t, user.Filters.PublicKeysLastUse[ssh.FingerprintSHA256(pubKey)], int64(0))
+	// expired key
+	user.PublicKeys = []string{`expiry-time="20200101" ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{fmt.Sprintf(`expiry-time="%sZ" `, time.Now().Add(24*time.Hour).UTC().Format("20060102")) +
+		testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	// the key can only be used for SFTP
+	user.PublicKeys = []string{`command="internal-sftp" ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	_, err = runSSHCommand("pwd", user, usePubKey)
+	assert.Error(t, err)
+	// forced SSH command
+	user.PublicKeys = []string{`command="pwd" ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	out, err := runSSHCommand("md5sum", user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/\n", string(out))
+	}
+	// unsupported forced commands are rejected
+	user.PublicKeys = []string{`command="ls -la" ` + testPubKey}
+	_, resp, err := httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "unsupported forced command")
+	user.PublicKeys = []string{`command="scp 'unterminated" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	// port forwarding
+	user.Filters.PermittedOpens = []string{"127.0.0.1:*"}
+	user.PublicKeys = []string{`restrict,command="internal-sftp" ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		_, err = conn.Dial("tcp", sftpServerAddr)
+		assert.Error(t, err)
+		client.Close()
+		conn.Close()
+	}
+	user.PublicKeys = []string{`restrict,port-forwarding ` + testPubKey}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		c, err := conn.Dial("tcp", sftpServerAddr)
+		if assert.NoError(t, err) {
+			c.Close()
+		}
+		client.Close()
+		conn.Close()
+	}
+	// invalid options
+	user.PublicKeys = []string{`from="10.8.0.0/33" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.PublicKeys = []string{`expiry-time="2020" ` + testPubKey}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestPublicKeyOptionsMultiStepAuth(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Password = defaultPassword
+	u.PublicKeys = []string{`command="pwd",no-port-forwarding ` + testPubKey}
+	u.Filters.PermittedOpens = []string{"127.0.0.1:*"}
+	u.Filters.DeniedLoginMethods = []string{
+		dataprovider.SSHLoginMethodPublicKey,
+		dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive,
+		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	assert.NoError(t, err)
+	authMethods := []ssh.AuthMethod{
+		ssh.PublicKeys(signer),
+		ssh.Password(defaultPassword),
+	}
+	// the forced command must apply after the second authentication step too
+	conn, client, err := getCustomAuthSftpClient(user, authMethods, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth:    authMethods,
+		Timeout: 5 * time.Second,
+	}
+	conn, err = ssh.Dial("tcp", sftpServerAddr, config)
+	if assert.NoError(t, err) {
+		session, err := conn.NewSession()
+		if assert.NoError(t, err) {
+			out, err := session.Output("md5sum")
+			assert.NoError(t, err)
+			assert.Equal(t, "/\n", string(out))
+			session.Close()
+		}
+		_, err = conn.Dial("tcp", sftpServerAddr)
+		assert.Error(t, err)
+		conn.Close()
+	}
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Greater(t, user.Filters.PublicKeysLastUse[ssh.FingerprintSHA256(signer.PublicKey())], int64(0))
+	// updating the last use must not change the other filters
+	assert.Len(t, user.Filters.DeniedLoginMethods, 4)
+	assert.Equal(t, []string{"127.0.0.1:*"}, user.Filters.PermittedOpens)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestHostKeysAdvertisement(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	require.NoError(t, err)
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		Timeout: 5 * time.Second,
+	}
+	netConn, err := net.DialTimeout("tcp", sftpServerAddr, 5*time.Second)
+	require.NoError(t, err)
+	conn, _, reqs, err := ssh.NewClientConn(netConn, sftpServerAddr, config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var advertisedKeys [][]byte
+	select {
+	case req := <-reqs:
+		require.NotNil(t, req)
+		assert.Equal(t, "hostkeys-00@openssh.com", req.Type)
+		assert.False(t, req.WantReply)
+		payload := req.Payload
+		for len(payload) > 0 {
+			var key struct {
+				Key  []byte
+				Rest []byte `ssh:"rest"`
+			}
+			err = ssh.Unmarshal(payload, &key)
+			require.NoError(t, err)
+			advertisedKeys = append(advertisedKeys, key.Key)
+			payload = key.Rest
+		}
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "host keys not advertised")
+	}
+	require.NotEmpty(t, advertisedKeys)
+	found := false
+	var proveReq []byte
+	for _, k := range advertisedKeys {
+		if bytes.Equal(k, hostKey.Marshal()) {
+			found = true
+		}
+		proveReq = append(proveReq, ssh.Marshal(struct {
+			Key []byte
+		}{k})...)
+	}
+	assert.True(t, found)
+	ok, response, err := conn.SendRequest("hostkeys-prove-00@openssh.com", true, proveReq)
+	require.NoError(t, err)
+	require.True(t, ok)
+	for _, k := range advertisedKeys {
+		var resp struct {
+			Signature []byte
+			Rest      []byte `ssh:"rest"`
+		}
+		err = ssh.Unmarshal(response, &resp)
+		require.NoError(t, err)
+		response = resp.Rest
+		sig := new(ssh.Signature)
+		err = ssh.Unmarshal(resp.Signature, sig)
+		require.NoError(t, err)
+		pubKey, err := ssh.ParsePublicKey(k)
+		require.NoError(t, err)
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{"hostkeys-prove-00@openssh.com", conn.SessionID(), k})
+		assert.NoError(t, pubKey.Verify(data, sig))
+	}
+	ok, _, err = conn.SendRequest("hostkeys-prove-00@openssh.com", true, []byte("invalid"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, _, err = conn.SendRequest("unknown-request", true, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSecurityKeysOnly(t *testing.T) {
+	skSigner, err := newTestSKEd25519Signer()
+	require.NoError(t, err)
+	skPubKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(skSigner.PublicKey())))
+	assert.True(t, strings.HasPrefix(skPubKey, ssh.KeyAlgoSKED25519))
+
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.PublicKeys = append(u.PublicKeys, skPubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	user.Filters.SecurityKeysOnly = true
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.True(t, user.Filters.SecurityKeysOnly)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	// the built-in CA cannot sign keys that are not security keys
+	_, _, err = sftpd.SignUserPublicKey(&user, testPubKey, 0, "", "")
+	assert.Error(t, err)
+	certificate, _, err := sftpd.SignUserPublicKey(&user, skPubKey, 0, "", "")
+	assert.NoError(t, err)
+	cert, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate)) //nolint:dogsled
+	require.NoError(t, err)
+	certSigner, err := ssh.NewCertSigner(cert.(*ssh.Certificate), skSigner)
+	require.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(certSigner)}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	// the restriction can also be inherited from a secondary group
+	g := getTestGroup()
+	g.UserSettings.SecurityKeysOnly = true
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.True(t, group.UserSettings.SecurityKeysOnly)
+	user.Filters.SecurityKeysOnly = false
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.False(t, user.Filters.SecurityKeysOnly)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if assert.NoError(t, err) {
 		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
 	}
+
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
 }
 
-func TestLoginUserCert(t *testing.T) {
-	u := getTestUser(true)
+func TestSecurityKeysRequiredFlags(t *testing.T) {
+	skSigner, err := newTestSKEd25519Signer()
+	require.NoError(t, err)
+	skPubKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(skSigner.PublicKey())))
+
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Password = defaultPassword
+	u.PublicKeys = append(u.PublicKeys, skPubKey)
+	u.Filters.RequireUserVerification = true
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	// try login using a cert signed from a trusted CA
-	signer, err := getSignerForUserCert([]byte(testCertValid))
-	assert.NoError(t, err)
-	conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	assert.True(t, user.Filters.RequireUserVerification)
+	// the requirements only apply to security keys
+	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
 		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
 	}
-	// revoke the certificate
-	certs := []string{"SHA256:OkxVB1ImSJ2XeI8nA2Wg+6zJVlxdevD1FYBSEJjFEN4"}
-	data, err := json.Marshal(certs)
-	assert.NoError(t, err)
-	err = os.WriteFile(revokeUserCerts, data, 0644)
-	assert.NoError(t, err)
-	err = sftpd.Reload()
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if !assert.Error(t, err, "a signature without user verification must be refused") {
 		client.Close()
 		conn.Close()
 	}
-	// if we remove the revoked certificate login should work again
-	certs = []string{"SHA256:bsBRHC/xgiqBJdSuvSTNpJNLTISP/G356jNMCRYC5Es, SHA256:1kxVB1ImSJ2XeI8nA2Wg+6zJVlxdevD1FYBSEJjFEN4"}
-	data, err = json.Marshal(certs)
-	assert.NoError(t, err)
-	err = os.WriteFile(revokeUserCerts, data, 0644)
-	assert.NoError(t, err)
-	err = sftpd.Reload()
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	skSigner.flags = ssh.SKFlagUserPresent | ssh.SKFlagUserVerified
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
 	if assert.NoError(t, err) {
-		defer conn.Close()
-		defer client.Close()
 		assert.NoError(t, checkBasicSFTP(client))
-	}
-
-	// try login using a cert signed from an untrusted CA
-	signer, err = getSignerForUserCert([]byte(testCertUntrustedCA))
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
 		client.Close()
 		conn.Close()
 	}
-	// try login using an host certificate instead of an user certificate
-	signer, err = getSignerForUserCert([]byte(testHostCert))
+	// the flags are checked for certificates too
+	certificate, _, err := sftpd.SignUserPublicKey(&user, skPubKey, 0, "", "")
 	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	cert, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate)) //nolint:dogsled
+	require.NoError(t, err)
+	certSigner, err := ssh.NewCertSigner(cert.(*ssh.Certificate), skSigner)
+	require.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(certSigner)}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
 		client.Close()
 		conn.Close()
 	}
-	// try login using a user certificate with an authorized source address different from localhost
-	signer, err = getSignerForUserCert([]byte(testCertOtherSourceAddress))
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	skSigner.flags = ssh.SKFlagUserPresent
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(certSigner)}, "")
+	if !assert.Error(t, err, "a certificate signature without user verification must be refused") {
 		client.Close()
 		conn.Close()
 	}
-	// try login using an expired certificate
-	signer, err = getSignerForUserCert([]byte(testCertExpired))
+	// and when the public key is the first authentication step
+	user.Filters.DeniedLoginMethods = []string{
+		dataprovider.SSHLoginMethodPublicKey,
+		dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive,
+		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	authMethods := []ssh.AuthMethod{
+		ssh.PublicKeys(skSigner),
+		ssh.Password(defaultPassword),
+	}
+	conn, client, err = getCustomAuthSftpClient(user, authMethods, "")
+	if !assert.Error(t, err, "a signature without user verification must be refused in multi-step login") {
 		client.Close()
 		conn.Close()
 	}
-	// try login using a certificate with no principals
-	signer, err = getSignerForUserCert([]byte(testCertNoPrincipals))
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	skSigner.flags = ssh.SKFlagUserPresent | ssh.SKFlagUserVerified
+	conn, client, err = getCustomAuthSftpClient(user, authMethods, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
 		client.Close()
 		conn.Close()
 	}
-	_, err = httpdtest.RemoveUser(user, http.StatusOK)
-	assert.NoError(t, err)
-	err = os.RemoveAll(user.GetHomeDir())
+	// the requirements can be inherited from a group
+	g := getTestGroup()
+	g.UserSettings.RequireUserPresence = true
+	group, _, err := httpdtest.AddGroup(g, http.StatusCreated)
 	assert.NoError(t, err)
-
-	// the user does not exist
-	signer, err = getSignerForUserCert([]byte(testCertValid))
+	assert.True(t, group.UserSettings.RequireUserPresence)
+	user.Filters.DeniedLoginMethods = []string{dataprovider.SSHLoginMethodKeyboardInteractive}
+	user.Filters.RequireUserVerification = false
+	user.Groups = []sdk.GroupMapping{
+		{
+			Name: group.Name,
+			Type: sdk.GroupTypeSecondary,
+		},
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	assert.False(t, user.Filters.RequireUserPresence)
+	skSigner.flags = ssh.SKFlagUserVerified
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if !assert.Error(t, err, "a signature without user presence must be refused") {
 		client.Close()
 		conn.Close()
 	}
-
-	// now login with a username not in the set of valid principals for the given certificate
-	u.Username += "1"
-	user, _, err = httpdtest.AddUser(u, http.StatusCreated)
-	assert.NoError(t, err)
-
-	signer, err = getSignerForUserCert([]byte(testCertValid))
-	assert.NoError(t, err)
-	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
-	if !assert.Error(t, err) {
+	skSigner.flags = ssh.SKFlagUserPresent
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(skSigner)}, "")
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
 		client.Close()
 		conn.Close()
 	}
 
-	err = os.WriteFile(revokeUserCerts, []byte(`[]`), 0644)
-	assert.NoError(t, err)
-	err = sftpd.Reload()
-	assert.NoError(t, err)
-
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
+	_, err = httpdtest.RemoveGroup(group, http.StatusOK)
+	assert.NoError(t, err)
 }
 
 func TestMultiStepLoginKeyAndPwd(t *testing.T) {
@@ -4253,103 +5015,366 @@ func TestQuotaDisabledError(t *testing.T) {
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	err = dataprovider.Close()
-	assert.NoError(t, err)
-	err = config.LoadConfig(configDir, "")
-	assert.NoError(t, err)
-	providerConf = config.GetProviderConf()
-	err = dataprovider.Initialize(providerConf, configDir, true)
-	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+}
+
+//nolint:dupl
+func TestMaxConnections(t *testing.T) {
+	oldValue := common.Config.MaxTotalConnections
+	common.Config.MaxTotalConnections = 1
+
+	assert.Eventually(t, func() bool {
+		return common.Connections.GetClientConnections() == 0
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+
+	usePubKey := true
+	user := getTestUser(usePubKey)
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	user.Password = ""
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		s, c, err := getSftpClient(user, usePubKey)
+		if !assert.Error(t, err, "max total connections exceeded, new login should not succeed") {
+			c.Close()
+			s.Close()
+		}
+		err = client.Close()
+		assert.NoError(t, err)
+		err = conn.Close()
+		assert.NoError(t, err)
+	}
+	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	common.Config.MaxTotalConnections = oldValue
+}
+
+//nolint:dupl
+func TestMaxPerHostConnections(t *testing.T) {
+	oldValue := common.Config.MaxPerHostConnections
+	common.Config.MaxPerHostConnections = 1
+
+	assert.Eventually(t, func() bool {
+		return common.Connections.GetClientConnections() == 0
+	}, 1000*time.Millisecond, 50*time.Millisecond)
+
+	usePubKey := true
+	user := getTestUser(usePubKey)
+	err := dataprovider.AddUser(&user, "", "")
+	assert.NoError(t, err)
+	user.Password = ""
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		s, c, err := getSftpClient(user, usePubKey)
+		if !assert.Error(t, err, "max per host connections exceeded, new login should not succeed") {
+			c.Close()
+			s.Close()
+		}
+		err = client.Close()
+		assert.NoError(t, err)
+		err = conn.Close()
+		assert.NoError(t, err)
+	}
+	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	common.Config.MaxPerHostConnections = oldValue
+}
+
+func TestMaxSessions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Username += "1"
+	u.MaxSessions = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+		s, c, err := getSftpClient(user, usePubKey)
+		if !assert.Error(t, err, "max sessions exceeded, new login should not succeed") {
+			c.Close()
+			s.Close()
+		}
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSupportedExtensions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+		v, ok := client.HasExtension("statvfs@openssh.com")
+		assert.Equal(t, "2", v)
+		assert.True(t, ok)
+		v, ok = client.HasExtension("hardlink@openssh.com")
+		assert.Equal(t, "1", v)
+		assert.True(t, ok)
+		v, ok = client.HasExtension("posix-rename@openssh.com")
+		assert.Equal(t, "1", v)
+		assert.True(t, ok)
+		v, ok = client.HasExtension("check-file")
+		assert.Equal(t, "md5,sha1,sha224,sha256,sha384,sha512,crc32", v)
+		assert.True(t, ok)
+		for _, extension := range []string{"copy-data", "limits@openssh.com", "expand-path@openssh.com",
+			"users-groups-by-id@openssh.com", "fsync@openssh.com"} {
+			v, ok = client.HasExtension(extension)
+			assert.Equal(t, "1", v, extension)
+			assert.True(t, ok, extension)
+		}
+		// fsync is supported for local filesystems
+		f, err := client.Create(testFileName)
+		if assert.NoError(t, err) {
+			_, err = f.Write([]byte("test data"))
+			assert.NoError(t, err)
+			err = f.Sync()
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		content, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, "test data", string(content))
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
 }
 
-//nolint:dupl
-func TestMaxConnections(t *testing.T) {
-	oldValue := common.Config.MaxTotalConnections
-	common.Config.MaxTotalConnections = 1
-
-	assert.Eventually(t, func() bool {
-		return common.Connections.GetClientConnections() == 0
-	}, 1000*time.Millisecond, 50*time.Millisecond)
+func TestPortForwarding(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c) //nolint:errcheck
+			}(c)
+		}
+	}()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
 
 	usePubKey := true
-	user := getTestUser(usePubKey)
-	err := dataprovider.AddUser(&user, "", "")
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
 	assert.NoError(t, err)
-	user.Password = ""
 	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
-		assert.NoError(t, checkBasicSFTP(client))
-		s, c, err := getSftpClient(user, usePubKey)
-		if !assert.Error(t, err, "max total connections exceeded, new login should not succeed") {
-			c.Close()
-			s.Close()
+		defer conn.Close()
+		defer client.Close()
+		// port forwarding is not allowed by default
+		_, err = conn.Dial("tcp", listener.Addr().String())
+		assert.Error(t, err)
+	}
+	user.Filters.PermittedOpens = []string{"localhost:22", "127.0.0.0/8:" + port}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		c, err := conn.Dial("tcp", listener.Addr().String())
+		if assert.NoError(t, err) {
+			_, err = c.Write([]byte("hello"))
+			assert.NoError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(c, buf)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(buf))
+			assert.Eventually(t, func() bool {
+				for _, stat := range common.Connections.GetStats("") {
+					if strings.HasPrefix(stat.Command, "direct-tcpip") {
+						return true
+					}
+				}
+				return false
+			}, 1*time.Second, 50*time.Millisecond)
+			err = c.Close()
+			assert.NoError(t, err)
 		}
-		err = client.Close()
-		assert.NoError(t, err)
-		err = conn.Close()
-		assert.NoError(t, err)
+		_, err = conn.Dial("tcp", "127.0.0.1:22")
+		assert.Error(t, err)
 	}
-	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-
-	common.Config.MaxTotalConnections = oldValue
 }
 
-//nolint:dupl
-func TestMaxPerHostConnections(t *testing.T) {
-	oldValue := common.Config.MaxPerHostConnections
-	common.Config.MaxPerHostConnections = 1
-
-	assert.Eventually(t, func() bool {
-		return common.Connections.GetClientConnections() == 0
-	}, 1000*time.Millisecond, 50*time.Millisecond)
-
+func TestRestrictedShell(t *testing.T) {
 	usePubKey := true
-	user := getTestUser(usePubKey)
-	err := dataprovider.AddUser(&user, "", "")
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-	user.Password = ""
 	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
-		assert.NoError(t, checkBasicSFTP(client))
-		s, c, err := getSftpClient(user, usePubKey)
-		if !assert.Error(t, err, "max per host connections exceeded, new login should not succeed") {
-			c.Close()
-			s.Close()
+		defer conn.Close()
+		defer client.Close()
+
+		content := []byte("line1\nline2\nline3\n")
+		f, err := client.Create("file.txt")
+		assert.NoError(t, err)
+		_, err = f.Write(content)
+		assert.NoError(t, err)
+		err = f.Close()
+		assert.NoError(t, err)
+
+		session, err := conn.NewSession()
+		require.NoError(t, err)
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		stdin, err := session.StdinPipe()
+		require.NoError(t, err)
+		err = session.RequestPty("xterm", 40, 120, ssh.TerminalModes{})
+		require.NoError(t, err)
+		err = session.Shell()
+		require.NoError(t, err)
+		commands := []string{
+			"pwd",
+			"mkdir -p dir1/sub",
+			"cd dir1",
+			"pwd",
+			"cp ../file.txt sub/",
+			"mv sub/file.txt moved.txt",
+			"head -n 1 moved.txt",
+			"cat moved.txt",
+			"sha256sum moved.txt",
+			"find / -name *.txt -type f",
+			"du /",
+			"rm sub",
+			"rm -r sub",
+			"ls -l",
+			"quota",
+			"unknowncmd",
+			"exit",
 		}
-		err = client.Close()
+		_, err = stdin.Write([]byte(strings.Join(commands, "\n") + "\n"))
 		assert.NoError(t, err)
-		err = conn.Close()
+		err = session.Wait()
+		assert.NoError(t, err)
+		output := stdout.String()
+		hash := sha256.Sum256(content)
+		assert.Contains(t, output, "Welcome "+user.Username)
+		assert.Contains(t, output, user.Username+":/dir1$ ")
+		assert.Contains(t, output, "line1\r\n")
+		assert.Contains(t, output, "line3\r\n")
+		assert.Contains(t, output, hex.EncodeToString(hash[:])+"  moved.txt")
+		assert.Contains(t, output, "/dir1/moved.txt\r\n")
+		assert.Contains(t, output, "/file.txt\r\n")
+		assert.Contains(t, output, "2 files\t/")
+		assert.Contains(t, output, "rm: sub: is a directory")
+		assert.Contains(t, output, "Files: 2/100")
+		assert.Contains(t, output, "unknowncmd: command not found")
+		assert.NotContains(t, output, "cp: ")
+		assert.NotContains(t, output, "mv: ")
+
+		_, err = client.Stat("/dir1/moved.txt")
+		assert.NoError(t, err)
+		_, err = client.Stat("/dir1/sub")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = client.Stat("/file.txt")
 		assert.NoError(t, err)
 	}
-	err = dataprovider.DeleteUser(user.Username, "", "", "")
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 	assert.NoError(t, err)
-	err = os.RemoveAll(user.GetHomeDir())
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(36), user.UsedQuotaSize)
+	// without the delete permission the shell cannot remove files
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
+	conn, client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
 
-	common.Config.MaxPerHostConnections = oldValue
+		session, err := conn.NewSession()
+		require.NoError(t, err)
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		session.Stdin = bytes.NewBufferString("rm file.txt\nmkdir newdir\nexit\n")
+		err = session.Shell()
+		require.NoError(t, err)
+		err = session.Wait()
+		assert.NoError(t, err)
+		assert.Contains(t, stdout.String(), "rm: file.txt: permission denied")
+		assert.Contains(t, stdout.String(), "mkdir: newdir: permission denied")
+		_, err = client.Stat("/file.txt")
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
 }
 
-func TestMaxSessions(t *testing.T) {
+func TestPosixRename(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
-	u.Username += "1"
-	u.MaxSessions = 1
+	u.QuotaFiles = 100
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
 	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
-		assert.NoError(t, checkBasicSFTP(client))
-		s, c, err := getSftpClient(user, usePubKey)
-		if !assert.Error(t, err, "max sessions exceeded, new login should not succeed") {
-			c.Close()
-			s.Close()
+
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName+"_1", testFileSize, client)
+		assert.NoError(t, err)
+		// the target file is atomically replaced
+		err = client.PosixRename(testFileName, testFileName+"_1")
+		assert.NoError(t, err)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		info, err := client.Stat(testFileName + "_1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
 		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, testFileSize, user.UsedQuotaSize)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
 	}
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -4357,23 +5382,58 @@ func TestMaxSessions(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestSupportedExtensions(t *testing.T) {
+func TestHardlink(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	u.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	u.Permissions["/nodownload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
 	conn, client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
 		defer conn.Close()
 		defer client.Close()
-		assert.NoError(t, checkBasicSFTP(client))
-		v, ok := client.HasExtension("statvfs@openssh.com")
-		assert.Equal(t, "2", v)
-		assert.True(t, ok)
-		_, ok = client.HasExtension("hardlink@openssh.com")
-		assert.False(t, ok)
-		_, ok = client.HasExtension("posix-rename@openssh.com")
-		assert.False(t, ok)
+
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Link(testFileName, testFileName+".link")
+		assert.NoError(t, err)
+		fi1, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		fi2, err := os.Stat(filepath.Join(user.GetHomeDir(), testFileName+".link"))
+		assert.NoError(t, err)
+		assert.True(t, os.SameFile(fi1, fi2))
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+		// the target already exists
+		err = client.Link(testFileName, testFileName+".link")
+		assert.Error(t, err)
+		// directories are not supported
+		err = client.Mkdir("sub")
+		assert.NoError(t, err)
+		err = client.Link("sub", "sub.link")
+		assert.Error(t, err)
+		// no upload permission in the target dir
+		err = client.Link(testFileName, path.Join("sub", testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Link("missing", testFileName+".link1")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		// no download permission in the source dir
+		err = client.Mkdir("nodownload")
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join("nodownload", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Link(path.Join("nodownload", testFileName), testFileName+".link2")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
 	}
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -9566,6 +10626,165 @@ func TestGitErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGitRemoteBackends(t *testing.T) {
+	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
+		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	usePubKey := true
+	localUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestSFTPUser(usePubKey)
+	u.QuotaFiles = 1000
+	sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestUser(usePubKey)
+	u.Username += "_crypt"
+	u.HomeDir += "_crypt"
+	u.QuotaFiles = 1000
+	u.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	u.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret(defaultPassword)
+	cryptUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	repoName := "remoterepo.git"
+	srcPath := filepath.Join(homeBasePath, "gitsrc")
+	clonePath := filepath.Join(homeBasePath, strings.TrimSuffix(repoName, ".git"))
+	for _, user := range []dataprovider.User{sftpUser, cryptUser} {
+		err = os.RemoveAll(srcPath)
+		assert.NoError(t, err)
+		err = os.RemoveAll(clonePath)
+		assert.NoError(t, err)
+		err = os.MkdirAll(srcPath, os.ModePerm)
+		assert.NoError(t, err)
+		cmd := exec.Command(gitPath, "init")
+		cmd.Dir = srcPath
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		out, err = addFileToGitRepo(srcPath, 65535)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		// the repository does not exist, it is created on the first push
+		remoteURL := fmt.Sprintf("ssh://%v@127.0.0.1:2022/%v", user.Username, repoName)
+		out, err = pushToGitRemote(srcPath, remoteURL)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+
+		out, err = cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		srcContent, err := os.ReadFile(filepath.Join(srcPath, "test"))
+		assert.NoError(t, err)
+		cloneContent, err := os.ReadFile(filepath.Join(clonePath, "test"))
+		assert.NoError(t, err)
+		assert.Equal(t, srcContent, cloneContent)
+
+		out, err = addFileToGitRepo(clonePath, 131072)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		out, err = pushToGitRepo(clonePath)
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		cmd = exec.Command(gitPath, "pull", remoteURL, "HEAD")
+		cmd.Dir = srcPath
+		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH=%v", gitWrapPath))
+		out, err = cmd.CombinedOutput()
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+		srcContent, err = os.ReadFile(filepath.Join(srcPath, "test"))
+		assert.NoError(t, err)
+		assert.Len(t, srcContent, 131072)
+
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Greater(t, user.UsedQuotaFiles, 0)
+		assert.Greater(t, user.UsedQuotaSize, int64(0))
+	}
+	// read only users cannot push
+	u = getTestUser(usePubKey)
+	u.Username += "_ro"
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	roUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	out, err := pushToGitRemote(clonePath, fmt.Sprintf("ssh://%v@127.0.0.1:2022/%v", roUser.Username, repoName))
+	assert.Error(t, err, "push must fail without upload permissions, out: %v", string(out))
+
+	for _, user := range []dataprovider.User{roUser, cryptUser, sftpUser, localUser} {
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+	err = os.RemoveAll(srcPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+}
+
+func TestRsyncInterop(t *testing.T) {
+	if rsyncPath == "" || sshPath == "" || runtime.GOOS == osWindows {
+		t.Skip("rsync and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	maxProtocol := getRsyncClientProtocolVersion()
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	srcDir := filepath.Join(homeBasePath, "rsync_src")
+	downloadDir := filepath.Join(homeBasePath, "rsync_download")
+	err = os.MkdirAll(filepath.Join(srcDir, "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	files := map[string]int64{
+		"file.dat":     131072,
+		"sub/file.dat": 65535,
+		"empty.dat":    0,
+	}
+	for name, size := range files {
+		err = createTestFile(filepath.Join(srcDir, filepath.FromSlash(name)), size)
+		assert.NoError(t, err)
+	}
+	checkFiles := func(protocol int, dir1, dir2 string) {
+		for name := range files {
+			hash1, err := computeHashForFile(sha256.New(), filepath.Join(dir1, filepath.FromSlash(name)))
+			assert.NoError(t, err, "protocol %d", protocol)
+			hash2, err := computeHashForFile(sha256.New(), filepath.Join(dir2, filepath.FromSlash(name)))
+			assert.NoError(t, err, "protocol %d", protocol)
+			assert.Equal(t, hash1, hash2, "protocol %d, file %q", protocol, name)
+		}
+	}
+	// rsync 2.6.x uses the protocol version 28 or 29, 3.0.x the version 30 and 3.1.x or
+	// later the version 31, the server downgrades all of them to the implemented version.
+	// 0 means the default protocol version for the installed client
+	for _, protocol := range []int{0, 27, 28, 29, 30, 31} {
+		if protocol > maxProtocol {
+			continue
+		}
+		remoteDir := fmt.Sprintf("dest%d", protocol)
+		remoteURL := fmt.Sprintf("%v@127.0.0.1:/%v/", user.Username, remoteDir)
+		out, err := runRsync(protocol, "-rtz", "--delete", srcDir+"/", remoteURL)
+		assert.NoError(t, err, "protocol %d, out: %s", protocol, string(out))
+		checkFiles(protocol, srcDir, filepath.Join(user.GetHomeDir(), remoteDir))
+		// update a file, the existing one is used as basis for the delta transfer
+		f, err := os.OpenFile(filepath.Join(srcDir, "file.dat"), os.O_WRONLY, 0)
+		if assert.NoError(t, err) {
+			_, err = f.WriteAt([]byte(fmt.Sprintf("updated using protocol %d", protocol)), 1024)
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+		}
+		mtime := time.Now().Add(time.Duration(protocol+1) * time.Minute)
+		err = os.Chtimes(filepath.Join(srcDir, "file.dat"), mtime, mtime)
+		assert.NoError(t, err)
+		out, err = runRsync(protocol, "-rt", srcDir+"/", remoteURL)
+		assert.NoError(t, err, "protocol %d, out: %s", protocol, string(out))
+		checkFiles(protocol, srcDir, filepath.Join(user.GetHomeDir(), remoteDir))
+
+		out, err = runRsync(protocol, "-rt", remoteURL, downloadDir+"/")
+		assert.NoError(t, err, "protocol %d, out: %s", protocol, string(out))
+		checkFiles(protocol, srcDir, downloadDir)
+		err = os.RemoveAll(downloadDir)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(srcDir)
+	assert.NoError(t, err)
+}
+
 // Start SCP tests
 func TestSCPBasicHandling(t *testing.T) {
 	if scpPath == "" {
@@ -10726,6 +11945,63 @@ func runSSHCommand(command string, user dataprovider.User, usePubKey bool) ([]by
 	return stdout.Bytes(), err
 }
 
+// testSKEd25519Signer emulates a FIDO/U2F authenticator for sk-ssh-ed25519@openssh.com keys,
+// signatures have the configured flags, by default only the user presence flag is set
+type testSKEd25519Signer struct {
+	privKey     ed25519.PrivateKey
+	pubKey      ssh.PublicKey
+	application string
+	flags       byte
+	counter     uint32
+}
+
+func newTestSKEd25519Signer() (*testSKEd25519Signer, error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	application := "ssh:"
+	sshPubKey, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pubKey, application}))
+	if err != nil {
+		return nil, err
+	}
+	return &testSKEd25519Signer{
+		privKey:     privKey,
+		pubKey:      sshPubKey,
+		application: application,
+		flags:       ssh.SKFlagUserPresent,
+	}, nil
+}
+
+func (s *testSKEd25519Signer) PublicKey() ssh.PublicKey {
+	return s.pubKey
+}
+
+func (s *testSKEd25519Signer) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	appDigest := sha256.Sum256([]byte(s.application))
+	dataDigest := sha256.Sum256(data)
+	flags := s.flags
+	s.counter++
+	blob := ssh.Marshal(struct {
+		ApplicationDigest []byte `ssh:"rest"`
+		Flags             byte
+		Counter           uint32
+		MessageDigest     []byte `ssh:"rest"`
+	}{appDigest[:], flags, s.counter, dataDigest[:]})
+	return &ssh.Signature{
+		Format: ssh.KeyAlgoSKED25519,
+		Blob:   ed25519.Sign(s.privKey, blob),
+		Rest: ssh.Marshal(struct {
+			Flags   byte
+			Counter uint32
+		}{flags, s.counter}),
+	}, nil
+}
+
 func getSignerForUserCert(certBytes []byte) (ssh.Signer, error) {
 	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
 	if err != nil {
@@ -11132,6 +12408,12 @@ func checkSystemCommands() {
 		logger.WarnToConsole("unable to get ssh command. GIT tests will be skipped, err: %v", err)
 		gitPath = ""
 	}
+	rsyncPath, err = exec.LookPath("rsync")
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get rsync command. rsync interoperability tests will be skipped, err: %v", err)
+		logger.WarnToConsole("unable to get rsync command. rsync interoperability tests will be skipped, err: %v", err)
+		rsyncPath = ""
+	}
 	hookCmdPath, err = exec.LookPath("true")
 	if err != nil {
 		logger.Warn(logSender, "", "unable to get hook command: %v", err)
@@ -11151,6 +12433,34 @@ func checkSystemCommands() {
 	}
 }
 
+// getRsyncClientProtocolVersion returns the most recent protocol version supported
+// by the installed rsync client
+func getRsyncClientProtocolVersion() int {
+	out, err := exec.Command(rsyncPath, "--version").Output()
+	if err != nil {
+		return 0
+	}
+	_, after, ok := strings.Cut(string(out), "protocol version ")
+	if !ok {
+		return 0
+	}
+	version, _, _ := strings.Cut(after, "\n")
+	protocol, err := strconv.Atoi(strings.TrimSpace(version))
+	if err != nil {
+		return 0
+	}
+	return protocol
+}
+
+func runRsync(protocol int, args ...string) ([]byte, error) {
+	cmdArgs := []string{"-e", fmt.Sprintf("%v -p 2022", gitWrapPath)}
+	if protocol > 0 {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("--protocol=%d", protocol))
+	}
+	cmd := exec.Command(rsyncPath, append(cmdArgs, args...)...)
+	return cmd.CombinedOutput()
+}
+
 func initGitRepo(path string) ([]byte, error) {
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
@@ -11170,6 +12480,14 @@ func pushToGitRepo(repoPath string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+func pushToGitRemote(repoPath, remoteURL string) ([]byte, error) {
+	cmd := exec.Command(gitPath, "push", remoteURL, "HEAD:refs/heads/master")
+	cmd.Dir = repoPath
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GIT_SSH=%v", gitWrapPath))
+	return cmd.CombinedOutput()
+}
+
 func cloneGitRepo(basePath, remotePath, username string) ([]byte, error) {
 	remoteURL := fmt.Sprintf("ssh://%v@127.0.0.1:2022%v", username, remotePath)
 	args := []string{"clone", remoteURL}
@@ -11368,6 +12686,7 @@ func createInitialFiles(scriptArgs string) {
 	preDownloadPath = filepath.Join(homeBasePath, "predownload.sh")
 	preUploadPath = filepath.Join(homeBasePath, "preupload.sh")
 	revokeUserCerts = filepath.Join(homeBasePath, "revoked_certs.json")
+	userCAKeyPath = filepath.Join(homeBasePath, "user_ca_key")
 	err := os.WriteFile(pubKeyPath, []byte(testPubKey+"\n"), 0600)
 	if err != nil {
 		logger.WarnToConsole("unable to save public key to file: %v", err)
@@ -0,0 +1,838 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/shlex"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	shellDefaultWidth  = 80
+	shellDefaultHeight = 24
+)
+
+var (
+	errShellExit          = errors.New("exit")
+	errShellCommandFailed = errors.New("one or more paths failed")
+)
+
+// ptyRequestMsg defines the payload for a "pty-req" request, RFC 4254 section 6.2
+type ptyRequestMsg struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+// windowChangeMsg defines the payload for a "window-change" request, RFC 4254 section 6.7
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type shellCommand struct {
+	usage       string
+	description string
+	handler     func(s *restrictedShell, args []string) error
+}
+
+// shellCommands defines the commands available inside the restricted shell.
+// They are implemented using the user's filesystems and permissions and
+// never execute system binaries, so they work for any storage backend
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"cat":       {"cat <file>...", "print files content", (*restrictedShell).handleCat},
+		"cd":        {"cd [dir]", "change the working directory", (*restrictedShell).handleCd},
+		"cp":        {"cp [-r] <source> <target>", "copy files and directories", (*restrictedShell).handleCp},
+		"df":        {"df [path]", "show available space", (*restrictedShell).handleDf},
+		"du":        {"du [path]...", "show files number and disk usage", (*restrictedShell).handleDu},
+		"exit":      {"exit", "close the shell", (*restrictedShell).handleExit},
+		"find":      {"find [path] [-name <pattern>] [-type f|d]", "search for files", (*restrictedShell).handleFind},
+		"head":      {"head [-n <lines>] <file>", "print the first lines of a file", (*restrictedShell).handleHead},
+		"help":      {"help", "show this help", (*restrictedShell).handleHelp},
+		"ls":        {"ls [-l] [-a] [path]...", "list directory contents", (*restrictedShell).handleLs},
+		"mkdir":     {"mkdir [-p] <dir>...", "create directories", (*restrictedShell).handleMkdir},
+		"mv":        {"mv <source> <target>", "move or rename files and directories", (*restrictedShell).handleMv},
+		"pwd":       {"pwd", "print the working directory", (*restrictedShell).handlePwd},
+		"quota":     {"quota", "show quota usage and limits", (*restrictedShell).handleQuota},
+		"rm":        {"rm [-r] [-f] <path>...", "remove files and directories", (*restrictedShell).handleRm},
+		"rmdir":     {"rmdir <dir>...", "remove empty directories", (*restrictedShell).handleRmdir},
+		"sha256sum": {"sha256sum <file>...", "print SHA256 checksums", (*restrictedShell).handleSHA256Sum},
+	}
+}
+
+func parsePtyRequest(payload []byte) (int, int, bool) {
+	var msg ptyRequestMsg
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return 0, 0, false
+	}
+	return int(msg.Columns), int(msg.Rows), true
+}
+
+func parseWindowChange(payload []byte) (int, int, bool) {
+	var msg windowChangeMsg
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return 0, 0, false
+	}
+	return int(msg.Columns), int(msg.Rows), true
+}
+
+// shellReadWriter translates line feeds to carriage returns, the terminal only
+// handles the latter as enter key but clients without a pty send line feeds
+type shellReadWriter struct {
+	io.ReadWriter
+}
+
+func (rw *shellReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	for idx := 0; idx < n; idx++ {
+		if p[idx] == '\n' {
+			p[idx] = '\r'
+		}
+	}
+	return n, err
+}
+
+// restrictedShell is an interactive shell that allows to manage files using
+// the built-in commands defined in shellCommands
+type restrictedShell struct {
+	connection *Connection
+	terminal   *term.Terminal
+	cwd        string
+	width      atomic.Int32
+}
+
+func newRestrictedShell(connection *Connection, width, height int) *restrictedShell {
+	s := &restrictedShell{
+		connection: connection,
+		cwd:        util.CleanPath(connection.User.Filters.StartDirectory),
+	}
+	s.terminal = term.NewTerminal(&shellReadWriter{ReadWriter: connection.channel}, "")
+	s.terminal.AutoCompleteCallback = s.autoComplete
+	s.setSize(width, height)
+	s.updatePrompt()
+	return s
+}
+
+func (s *restrictedShell) setSize(width, height int) {
+	if width <= 0 || height <= 0 {
+		width = shellDefaultWidth
+		height = shellDefaultHeight
+	}
+	s.width.Store(int32(width))
+	s.terminal.SetSize(width, height) //nolint:errcheck
+}
+
+func (s *restrictedShell) updatePrompt() {
+	s.terminal.SetPrompt(fmt.Sprintf("%s:%s$ ", s.connection.User.Username, s.cwd))
+}
+
+func (s *restrictedShell) handle() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handle restricted shell: %#v stack trace: %v", r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+	}()
+	if err := common.Connections.Add(s.connection); err != nil {
+		logger.Info(logSender, "", "unable to add restricted shell connection: %v", err)
+		s.sendExitStatus(1)
+		return err
+	}
+	defer common.Connections.Remove(s.connection.GetID())
+
+	s.connection.Log(logger.LevelInfo, "restricted shell started")
+	s.printf("Welcome %s, type \"help\" to list the available commands\n", s.connection.User.Username)
+	for {
+		line, err := s.terminal.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				s.connection.Log(logger.LevelDebug, "restricted shell read error: %v", err)
+			}
+			break
+		}
+		s.connection.UpdateLastActivity()
+		if err := s.execute(line); err != nil {
+			if errors.Is(err, errShellExit) {
+				break
+			}
+		}
+	}
+	s.connection.Log(logger.LevelInfo, "restricted shell closed")
+	s.sendExitStatus(0)
+	return nil
+}
+
+func (s *restrictedShell) execute(line string) error {
+	args, err := shlex.Split(line)
+	if err != nil {
+		s.printf("%v\n", err)
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	name := args[0]
+	cmd, ok := shellCommands[name]
+	if !ok {
+		s.printf("%s: command not found\n", name)
+		return fmt.Errorf("command %q not found", name)
+	}
+	s.connection.command = line
+	s.connection.Log(logger.LevelDebug, "restricted shell command: %q args: %v", name, args[1:])
+	err = cmd.handler(s, args[1:])
+	if err != nil && !errors.Is(err, errShellExit) {
+		if !errors.Is(err, errShellCommandFailed) {
+			s.printf("%s: %v\n", name, err)
+		}
+		s.connection.Log(logger.LevelDebug, "restricted shell command %q failed: %v", line, err)
+	}
+	s.connection.command = ""
+	return err
+}
+
+func (s *restrictedShell) sendExitStatus(status uint32) {
+	exitStatus := sshSubsystemExitStatus{
+		Status: status,
+	}
+	if channel, ok := s.connection.channel.(ssh.Channel); ok {
+		_, err := channel.SendRequest("exit-status", false, ssh.Marshal(&exitStatus))
+		s.connection.Log(logger.LevelDebug, "exit status sent, error: %v", err)
+	}
+	s.connection.channel.Close()
+}
+
+func (s *restrictedShell) printf(format string, v ...any) {
+	fmt.Fprintf(s.terminal, format, v...)
+}
+
+// getVirtualPath returns the virtual path for the given shell argument,
+// relative paths are resolved against the working directory
+func (s *restrictedShell) getVirtualPath(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		name = path.Join(util.CleanPath(s.connection.User.Filters.StartDirectory), strings.TrimPrefix(name, "~"))
+	}
+	if !path.IsAbs(name) {
+		name = path.Join(s.cwd, name)
+	}
+	return util.CleanPath(name)
+}
+
+// getTargetPath returns the target path for copy and move commands,
+// if the target is an existing directory the source is placed inside it
+func (s *restrictedShell) getTargetPath(source, target string) string {
+	if info, err := s.connection.DoStat(target, 0, false); err == nil && info.IsDir() {
+		return path.Join(target, path.Base(source))
+	}
+	return target
+}
+
+// parseShellFlags splits the given args in flags and positional arguments,
+// flags are returned without the leading dash
+func parseShellFlags(args []string, allowed string) (map[rune]bool, []string, error) {
+	flags := make(map[rune]bool)
+	var positional []string
+	for idx, arg := range args {
+		if arg == "--" {
+			positional = append(positional, args[idx+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+		for _, r := range arg[1:] {
+			if !strings.ContainsRune(allowed, r) {
+				return nil, nil, fmt.Errorf("invalid option -- %q", r)
+			}
+			flags[r] = true
+		}
+	}
+	return flags, positional, nil
+}
+
+func (s *restrictedShell) handleHelp(_ []string) error {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := shellCommands[name]
+		s.printf("%-44s %s\n", cmd.usage, cmd.description)
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleExit(_ []string) error {
+	return errShellExit
+}
+
+func (s *restrictedShell) handlePwd(_ []string) error {
+	s.printf("%s\n", s.cwd)
+	return nil
+}
+
+func (s *restrictedShell) handleCd(args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	target := util.CleanPath(s.connection.User.Filters.StartDirectory)
+	if len(args) == 1 {
+		target = s.getVirtualPath(args[0])
+	}
+	info, err := s.connection.DoStat(target, 0, true)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+	if !s.connection.User.HasPerm(dataprovider.PermListItems, target) {
+		return s.connection.GetPermissionDeniedError()
+	}
+	s.cwd = target
+	s.updatePrompt()
+	return nil
+}
+
+func (s *restrictedShell) handleLs(args []string) error {
+	flags, paths, err := parseShellFlags(args, "la")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var lsErr error
+	for idx, p := range paths {
+		virtualPath := s.getVirtualPath(p)
+		info, err := s.connection.DoStat(virtualPath, 1, true)
+		if err != nil {
+			s.printf("ls: %s: %v\n", p, err)
+			lsErr = err
+			continue
+		}
+		if len(paths) > 1 && info.IsDir() {
+			if idx > 0 {
+				s.printf("\n")
+			}
+			s.printf("%s:\n", p)
+		}
+		var contents []os.FileInfo
+		if info.IsDir() {
+			contents, err = s.connection.ListDir(virtualPath)
+			if err != nil {
+				s.printf("ls: %s: %v\n", p, err)
+				lsErr = err
+				continue
+			}
+		} else {
+			contents = []os.FileInfo{info}
+		}
+		s.printListing(contents, flags['l'], flags['a'])
+	}
+	if lsErr != nil {
+		return errShellCommandFailed
+	}
+	return nil
+}
+
+func (s *restrictedShell) printListing(contents []os.FileInfo, long, all bool) {
+	sort.Slice(contents, func(i, j int) bool {
+		return contents[i].Name() < contents[j].Name()
+	})
+	names := make([]string, 0, len(contents))
+	maxLen := 0
+	for _, info := range contents {
+		if !all && strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if long {
+			s.printf("%s %12d %s %s\n", info.Mode().String(), info.Size(),
+				info.ModTime().Format("Jan 02 15:04 2006"), info.Name())
+			continue
+		}
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		if len(name) > maxLen {
+			maxLen = len(name)
+		}
+		names = append(names, name)
+	}
+	if long || len(names) == 0 {
+		return
+	}
+	columns := int(s.width.Load()) / (maxLen + 2)
+	if columns < 1 {
+		columns = 1
+	}
+	var sb strings.Builder
+	for idx, name := range names {
+		sb.WriteString(name)
+		if (idx+1)%columns == 0 || idx == len(names)-1 {
+			sb.WriteString("\n")
+		} else {
+			sb.WriteString(strings.Repeat(" ", maxLen+2-len(name)))
+		}
+	}
+	s.printf("%s", sb.String())
+}
+
+func (s *restrictedShell) handleMkdir(args []string) error {
+	flags, paths, err := parseShellFlags(args, "p")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.New("missing operand")
+	}
+	for _, p := range paths {
+		virtualPath := s.getVirtualPath(p)
+		if flags['p'] {
+			err = s.connection.CheckParentDirs(virtualPath)
+		} else {
+			err = s.connection.CreateDir(virtualPath, true)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleRmdir(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing operand")
+	}
+	for _, p := range args {
+		if err := s.connection.RemoveDir(s.getVirtualPath(p)); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleRm(args []string) error {
+	flags, paths, err := parseShellFlags(args, "rRf")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		if flags['f'] {
+			return nil
+		}
+		return errors.New("missing operand")
+	}
+	recursive := flags['r'] || flags['R']
+	for _, p := range paths {
+		virtualPath := s.getVirtualPath(p)
+		info, err := s.connection.DoStat(virtualPath, 1, true)
+		if err != nil {
+			if flags['f'] && s.connection.IsNotExistError(err) {
+				continue
+			}
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if info.IsDir() {
+			if !recursive {
+				return fmt.Errorf("%s: is a directory", p)
+			}
+			err = s.connection.RemoveAll(virtualPath)
+		} else {
+			fs, fsPath, errFs := s.connection.GetFsAndResolvedPath(virtualPath)
+			if errFs != nil {
+				return fmt.Errorf("%s: %w", p, errFs)
+			}
+			err = s.connection.RemoveFile(fs, fsPath, virtualPath, info)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleMv(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: " + shellCommands["mv"].usage)
+	}
+	source := s.getVirtualPath(args[0])
+	target := s.getTargetPath(source, s.getVirtualPath(args[1]))
+	return s.connection.Rename(source, target)
+}
+
+func (s *restrictedShell) handleCp(args []string) error {
+	flags, paths, err := parseShellFlags(args, "rR")
+	if err != nil {
+		return err
+	}
+	if len(paths) != 2 {
+		return errors.New("usage: " + shellCommands["cp"].usage)
+	}
+	source := s.getVirtualPath(paths[0])
+	info, err := s.connection.DoStat(source, 1, true)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !flags['r'] && !flags['R'] {
+		return fmt.Errorf("-r not specified, omitting directory %q", paths[0])
+	}
+	target := s.getTargetPath(source, s.getVirtualPath(paths[1]))
+	return s.connection.Copy(source, target)
+}
+
+// getFileTransfer opens the specified file for reading, the returned transfer
+// is accounted as a download and so data transfer limits and bandwidth limits apply
+func (s *restrictedShell) getFileTransfer(virtualPath string) (*transfer, int64, error) {
+	transferQuota := s.connection.GetTransferQuota()
+	if !transferQuota.HasDownloadSpace() {
+		return nil, 0, s.connection.GetReadQuotaExceededError()
+	}
+	if !s.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil, 0, s.connection.GetPermissionDeniedError()
+	}
+	if ok, policy := s.connection.User.IsFileAllowed(virtualPath); !ok {
+		return nil, 0, s.connection.GetErrorForDeniedFile(policy)
+	}
+	fs, fsPath, err := s.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return nil, 0, s.connection.GetFsError(fs, err)
+	}
+	if info.IsDir() {
+		return nil, 0, errors.New("is a directory")
+	}
+	if err := common.ExecutePreAction(s.connection.BaseConnection, common.OperationPreDownload, fsPath, virtualPath,
+		0, 0); err != nil {
+		s.connection.Log(logger.LevelDebug, "download for file %q denied by pre action: %v", virtualPath, err)
+		return nil, 0, s.connection.GetPermissionDeniedError()
+	}
+	file, r, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, 0, s.connection.GetFsError(fs, err)
+	}
+	baseTransfer := common.NewBaseTransfer(file, s.connection.BaseConnection, cancelFn, fsPath, fsPath, virtualPath,
+		common.TransferDownload, 0, 0, 0, 0, false, fs, transferQuota)
+	return newTransfer(baseTransfer, nil, r, nil), info.Size(), nil
+}
+
+func (s *restrictedShell) readFile(virtualPath string, fn func(reader io.Reader) error) error {
+	t, size, err := s.getFileTransfer(virtualPath)
+	if err != nil {
+		return err
+	}
+	err = fn(io.NewSectionReader(t, 0, size))
+	if err != nil {
+		t.TransferError(err)
+		t.Close()
+		return err
+	}
+	return t.Close()
+}
+
+func (s *restrictedShell) handleCat(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing operand")
+	}
+	for _, p := range args {
+		err := s.readFile(s.getVirtualPath(p), func(reader io.Reader) error {
+			_, err := io.Copy(s.terminal, reader)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleHead(args []string) error {
+	lines := 10
+	var paths []string
+	for idx := 0; idx < len(args); idx++ {
+		if args[idx] == "-n" && idx+1 < len(args) {
+			n, err := strconv.Atoi(args[idx+1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid number of lines: %q", args[idx+1])
+			}
+			lines = n
+			idx++
+			continue
+		}
+		paths = append(paths, args[idx])
+	}
+	if len(paths) != 1 {
+		return errors.New("usage: " + shellCommands["head"].usage)
+	}
+	return s.readFile(s.getVirtualPath(paths[0]), func(reader io.Reader) error {
+		scanner := bufio.NewScanner(reader)
+		for count := 0; count < lines && scanner.Scan(); count++ {
+			s.printf("%s\n", scanner.Text())
+		}
+		return scanner.Err()
+	})
+}
+
+func (s *restrictedShell) handleSHA256Sum(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing operand")
+	}
+	for _, p := range args {
+		virtualPath := s.getVirtualPath(p)
+		if ok, policy := s.connection.User.IsFileAllowed(virtualPath); !ok {
+			return fmt.Errorf("%s: %w", p, s.connection.GetErrorForDeniedFile(policy))
+		}
+		if !s.connection.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+			return fmt.Errorf("%s: %w", p, s.connection.GetPermissionDeniedError())
+		}
+		fs, fsPath, err := s.connection.GetFsAndResolvedPath(virtualPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		cmd := sshCommand{command: "sha256sum", connection: s.connection}
+		hash, err := cmd.computeHashForFile(fs, sha256.New(), fsPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, s.connection.GetFsError(fs, err))
+		}
+		s.printf("%s  %s\n", hash, p)
+	}
+	return nil
+}
+
+// walk calls fn for virtualPath and, if it is a directory, for any items inside it.
+// Contents are listed using the connection so permissions and virtual folders are respected
+func (s *restrictedShell) walk(virtualPath string, info os.FileInfo, fn func(string, os.FileInfo) error) error {
+	if err := fn(virtualPath, info); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	contents, err := s.connection.ListDir(virtualPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", virtualPath, err)
+	}
+	for _, fi := range contents {
+		if err := common.CheckClosing(); err != nil {
+			return err
+		}
+		if err := s.walk(path.Join(virtualPath, fi.Name()), fi, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleDu(args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, p := range args {
+		virtualPath := s.getVirtualPath(p)
+		info, err := s.connection.DoStat(virtualPath, 1, true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		var numFiles int
+		var size int64
+		err = s.walk(virtualPath, info, func(_ string, fi os.FileInfo) error {
+			if fi.Mode().IsRegular() {
+				numFiles++
+				size += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.printf("%s\t%d files\t%s\n", util.ByteCountIEC(size), numFiles, p)
+	}
+	return nil
+}
+
+func (s *restrictedShell) handleFind(args []string) error {
+	root := "."
+	var namePattern, fileType string
+	for idx := 0; idx < len(args); idx++ {
+		switch args[idx] {
+		case "-name", "-type":
+			if idx+1 >= len(args) {
+				return fmt.Errorf("missing argument to %q", args[idx])
+			}
+			if args[idx] == "-name" {
+				namePattern = args[idx+1]
+				if _, err := path.Match(namePattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q: %w", namePattern, err)
+				}
+			} else {
+				fileType = args[idx+1]
+				if fileType != "f" && fileType != "d" {
+					return fmt.Errorf("unknown argument to -type: %q", fileType)
+				}
+			}
+			idx++
+		default:
+			if idx > 0 {
+				return errors.New("usage: " + shellCommands["find"].usage)
+			}
+			root = args[idx]
+		}
+	}
+	virtualPath := s.getVirtualPath(root)
+	info, err := s.connection.DoStat(virtualPath, 1, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", root, err)
+	}
+	return s.walk(virtualPath, info, func(walkedPath string, fi os.FileInfo) error {
+		if (fileType == "f" && fi.IsDir()) || (fileType == "d" && !fi.IsDir()) {
+			return nil
+		}
+		if namePattern != "" {
+			if matched, _ := path.Match(namePattern, path.Base(walkedPath)); !matched {
+				return nil
+			}
+		}
+		s.printf("%s\n", walkedPath)
+		return nil
+	})
+}
+
+func (s *restrictedShell) handleDf(args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	virtualPath := s.cwd
+	if len(args) == 1 {
+		virtualPath = s.getVirtualPath(args[0])
+	}
+	stat, err := s.connection.StatVFS(&sftp.Request{Filepath: virtualPath})
+	if err != nil {
+		return err
+	}
+	total := int64(stat.TotalSpace())
+	free := int64(stat.FreeSpace())
+	s.printf("%-10s %-10s %-10s %s\n", "Size", "Used", "Avail", "Path")
+	s.printf("%-10s %-10s %-10s %s\n", util.ByteCountIEC(total), util.ByteCountIEC(total-free),
+		util.ByteCountIEC(free), virtualPath)
+	return nil
+}
+
+func (s *restrictedShell) handleQuota(_ []string) error {
+	user, err := dataprovider.UserExists(s.connection.User.Username, "")
+	if err != nil {
+		return err
+	}
+	summary := user.GetQuotaSummary()
+	if summary == "" {
+		summary = "no quota restrictions"
+	}
+	s.printf("%s\n", summary)
+	return nil
+}
+
+// autoComplete completes command names and paths when the tab key is pressed
+func (s *restrictedShell) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndex(line[:pos], " ") + 1
+	word := line[start:pos]
+	var candidates []string
+	if start == 0 {
+		for name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name+" ")
+			}
+		}
+	} else {
+		candidates = s.getPathCandidates(word)
+	}
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	completion := candidates[0]
+	for _, candidate := range candidates[1:] {
+		completion = commonPrefix(completion, candidate)
+	}
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+	newLine := line[:start] + completion + line[pos:]
+	return newLine, start + len(completion), true
+}
+
+func (s *restrictedShell) getPathCandidates(word string) []string {
+	dir, prefix := path.Split(word)
+	contents, err := s.connection.ListDir(s.getVirtualPath(dir))
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, info := range contents {
+		if !strings.HasPrefix(info.Name(), prefix) {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if info.IsDir() {
+			candidates = append(candidates, dir+info.Name()+"/")
+		} else {
+			candidates = append(candidates, dir+info.Name()+" ")
+		}
+	}
+	return candidates
+}
+
+func commonPrefix(a, b string) string {
+	idx := 0
+	for idx < len(a) && idx < len(b) && a[idx] == b[idx] {
+		idx++
+	}
+	return a[:idx]
+}
@@ -103,6 +103,28 @@ func processSSHCommand(payload []byte, connection *Connection, enabledSSHCommand
 				go scpCommand.handle() //nolint:errcheck
 				return true
 			}
+			if name == gitUploadPackCmdName || name == gitReceivePackCmdName {
+				connection.SetProtocol(common.ProtocolSSH)
+				gitCommand := gitCommand{
+					sshCommand: sshCommand{
+						command:    name,
+						connection: connection,
+						args:       args},
+				}
+				go gitCommand.handle() //nolint:errcheck
+				return true
+			}
+			if name == rsyncCmdName {
+				connection.SetProtocol(common.ProtocolSSH)
+				rsyncCommand := rsyncCommand{
+					sshCommand: sshCommand{
+						command:    name,
+						connection: connection,
+						args:       args},
+				}
+				go rsyncCommand.handle() //nolint:errcheck
+				return true
+			}
 			if name != scpCmdName {
 				connection.SetProtocol(common.ProtocolSSH)
 				sshCommand := sshCommand{
@@ -495,9 +517,9 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	}
 	if len(c.args) > 0 {
 		var err error
-		fsPath, err = fs.ResolvePath(sshPath)
+		_, fsPath, err = c.connection.GetFsAndResolvedPath(sshPath)
 		if err != nil {
-			return command, c.connection.GetFsError(fs, err)
+			return command, err
 		}
 		quotaPath = sshPath
 		fi, err := fs.Stat(fsPath)
@@ -516,23 +538,6 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	if err := c.isSystemCommandAllowed(); err != nil {
 		return command, errUnsupportedConfig
 	}
-	if c.command == "rsync" {
-		// we cannot avoid that rsync creates symlinks so if the user has the permission
-		// to create symlinks we add the option --safe-links to the received rsync command if
-		// it is not already set. This should prevent to create symlinks that point outside
-		// the home dir.
-		// If the user cannot create symlinks we add the option --munge-links, if it is not
-		// already set. This should make symlinks unusable (but manually recoverable)
-		if c.connection.User.HasPerm(dataprovider.PermCreateSymlinks, c.getDestPath()) {
-			if !util.Contains(args, "--safe-links") {
-				args = append([]string{"--safe-links"}, args...)
-			}
-		} else {
-			if !util.Contains(args, "--munge-links") {
-				args = append([]string{"--munge-links"}, args...)
-			}
-		}
-	}
 	c.connection.Log(logger.LevelDebug, "new system command %#v, with args: %+v fs path %#v quota check path %#v",
 		c.command, args, fsPath, quotaPath)
 	cmd := exec.Command(c.command, args...)
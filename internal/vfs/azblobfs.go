@@ -41,6 +41,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/eikenb/pipeat"
 	"github.com/google/uuid"
 	"github.com/pkg/sftp"
@@ -56,6 +57,10 @@ const (
 	azureDefaultEndpoint = "blob.core.windows.net"
 	azBlobFsName         = "AzureBlobFs"
 	azFolderKey          = "hdi_isfolder"
+	// the size of the blocks staged from the source blobs when composing a blob
+	azureComposeBlockSize = 100 * 1024 * 1024
+	azureMaxBlocks        = 50000
+	azureMaxMetadataSize  = 8192
 )
 
 // AzureBlobFs is a Fs implementation for Azure Blob storage.
@@ -181,20 +186,12 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 
 	attrs, err := fs.headObject(name)
 	if err == nil {
-		contentType := util.GetStringFromPointer(attrs.ContentType)
-		isDir := contentType == dirMimeType
-		if !isDir {
-			for k, v := range attrs.Metadata {
-				if strings.ToLower(k) == azFolderKey {
-					isDir = (v == "true")
-					break
-				}
-			}
-		}
+		isDir := isAzDirectory(attrs)
 		metric.AZListObjectsCompleted(nil)
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir,
-			util.GetIntFromPointer(attrs.ContentLength),
-			util.GetTimeFromPointer(attrs.LastModified), false))
+		info := NewFileInfo(name, isDir, util.GetIntFromPointer(attrs.ContentLength),
+			util.GetTimeFromPointer(attrs.LastModified), false)
+		info.SetETag(getAzETag(attrs.ETag))
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return nil, err
@@ -295,9 +292,18 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 		if hasContents {
 			return fmt.Errorf("cannot rename non empty directory: %#v", source)
 		}
+		props, err := fs.GetProperties(source)
+		if err != nil {
+			return err
+		}
 		if err := fs.mkdirInternal(target); err != nil {
 			return err
 		}
+		if len(props) > 0 {
+			if err := fs.SetProperties(target, props); err != nil {
+				return err
+			}
+		}
 	} else {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 		defer cancelFn()
@@ -339,6 +345,115 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 	return fs.Remove(source, fi.IsDir())
 }
 
+// Compose creates target by concatenating the specified sources. The target
+// blocks are staged copying byte ranges from the source blobs
+func (fs *AzureBlobFs) Compose(sources []string, target string) error {
+	type blockRange struct {
+		id     string
+		source string
+		offset int64
+		count  int64
+	}
+
+	var blocks []blockRange
+	for _, source := range sources {
+		attrs, err := fs.headObject(source)
+		if err != nil {
+			return err
+		}
+		size := util.GetIntFromPointer(attrs.ContentLength)
+		srcBlob := fs.containerClient.NewBlockBlobClient(url.PathEscape(source))
+		sourceURL := srcBlob.URL()
+		// the source must be authorized, the URL already contains the SAS token
+		// if the filesystem is configured using a SAS URL
+		if sasURL, err := srcBlob.BlobClient().GetSASURL(sas.BlobPermissions{Read: true},
+			time.Now().Add(-10*time.Minute), time.Now().Add(fs.ctxLongTimeout)); err == nil {
+			sourceURL = sasURL
+		}
+		for offset := int64(0); offset < size; offset += azureComposeBlockSize {
+			count := int64(azureComposeBlockSize)
+			if offset+count > size {
+				count = size - offset
+			}
+			generatedUUID, err := uuid.NewRandom()
+			if err != nil {
+				return fmt.Errorf("unable to generate block ID: %w", err)
+			}
+			blocks = append(blocks, blockRange{
+				id:     base64.StdEncoding.EncodeToString([]byte(generatedUUID.String())),
+				source: sourceURL,
+				offset: offset,
+				count:  count,
+			})
+		}
+	}
+	if len(blocks) == 0 || len(blocks) > azureMaxBlocks {
+		return ErrVfsUnsupported
+	}
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	dstBlob := fs.containerClient.NewBlockBlobClient(url.PathEscape(target))
+	guard := make(chan struct{}, fs.config.UploadConcurrency)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var composeError error
+
+	opCtx, opCancel := context.WithCancel(ctx)
+	defer opCancel()
+
+	for _, block := range blocks {
+		guard <- struct{}{}
+		if opCtx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(block blockRange) {
+			defer func() {
+				<-guard
+				wg.Done()
+			}()
+
+			_, err := dstBlob.StageBlockFromURL(opCtx, block.id, block.source, 0, &blockblob.StageBlockFromURLOptions{
+				Range: blob.HTTPRange{Offset: block.offset, Count: block.count},
+			})
+			if err != nil {
+				errOnce.Do(func() {
+					fsLog(fs, logger.LevelDebug, "unable to stage block for %q: %+v", target, err)
+					composeError = fmt.Errorf("unable to stage block: %w", err)
+					opCancel()
+				})
+			}
+		}(block)
+	}
+
+	wg.Wait()
+	close(guard)
+
+	if composeError != nil {
+		metric.AZCopyObjectCompleted(composeError)
+		return composeError
+	}
+	blockIDs := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		blockIDs = append(blockIDs, block.id)
+	}
+	commitOptions := blockblob.CommitBlockListOptions{}
+	if contentType := mime.TypeByExtension(path.Ext(target)); contentType != "" {
+		commitOptions.HTTPHeaders = &blob.HTTPHeaders{
+			BlobContentType: &contentType,
+		}
+	}
+	if fs.config.AccessTier != "" {
+		commitOptions.Tier = (*blob.AccessTier)(&fs.config.AccessTier)
+	}
+	_, err := dstBlob.CommitBlockList(ctx, blockIDs, &commitOptions)
+	metric.AZCopyObjectCompleted(err)
+	return err
+}
+
 // Remove removes the named file or (empty) directory.
 func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 	if isDir {
@@ -484,9 +599,11 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			size := int64(0)
 			isDir := false
 			modTime := time.Unix(0, 0)
+			etag := ""
 			if blobItem.Properties != nil {
 				size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
 				modTime = util.GetTimeFromPointer(blobItem.Properties.LastModified)
+				etag = getAzETag(blobItem.Properties.ETag)
 				contentType := util.GetStringFromPointer(blobItem.Properties.ContentType)
 				isDir = checkDirectoryMarkers(contentType, blobItem.Metadata)
 				if isDir {
@@ -500,7 +617,9 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				modTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
-			result = append(result, NewFileInfo(name, isDir, size, modTime, false))
+			info := NewFileInfo(name, isDir, size, modTime, false)
+			info.SetETag(etag)
+			result = append(result, info)
 		}
 	}
 	metric.AZListObjectsCompleted(nil)
@@ -773,6 +892,71 @@ func (fs *AzureBlobFs) headObject(name string) (blob.GetPropertiesResponse, erro
 	return resp, err
 }
 
+// GetProperties returns the custom properties stored within the metadata of the
+// blob for the named file or directory. Nil is returned if there are none
+func (fs *AzureBlobFs) GetProperties(name string) ([]byte, error) {
+	if fs.isRootDir(name) {
+		return nil, ErrVfsUnsupported
+	}
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			// virtual directory without a directory blob
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getPropertiesFromMetadata(attrs.Metadata)
+}
+
+// SetProperties stores the custom properties for the named file or directory
+// within the blob metadata, a directory blob is created for virtual directories.
+// Empty data removes the stored properties
+func (fs *AzureBlobFs) SetProperties(name string, data []byte) error {
+	if fs.isRootDir(name) {
+		return ErrVfsUnsupported
+	}
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		if !fs.IsNotExist(err) || len(data) == 0 {
+			return err
+		}
+		hasContents, errContents := fs.hasContents(name)
+		if errContents != nil {
+			return errContents
+		}
+		if !hasContents {
+			return err
+		}
+		if err := fs.mkdirInternal(name); err != nil {
+			return err
+		}
+		attrs, err = fs.headObject(name)
+		if err != nil {
+			return err
+		}
+	}
+	objMetadata, err := setPropertiesInMetadata(attrs.Metadata, data, azureMaxMetadataSize)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	blockBlob := fs.containerClient.NewBlockBlobClient(url.PathEscape(name))
+	if _, err := blockBlob.SetMetadata(ctx, objMetadata, &blob.SetMetadataOptions{}); err != nil {
+		return err
+	}
+	if !isAzDirectory(attrs) {
+		preserveModTimeAfterMetadataUpdate(fs, fs.getStorageID(), name, util.GetTimeFromPointer(attrs.LastModified))
+	}
+	return nil
+}
+
+func (fs *AzureBlobFs) isRootDir(name string) bool {
+	return name == "" || name == "/" || name == "." || fs.config.KeyPrefix == name+"/"
+}
+
 // GetMimeType returns the content type
 func (fs *AzureBlobFs) GetMimeType(name string) (string, error) {
 	response, err := fs.headObject(name)
@@ -1137,6 +1321,13 @@ func (fs *AzureBlobFs) getStorageID() string {
 	return fmt.Sprintf("azblob://%v", fs.config.Container)
 }
 
+func getAzETag(etag *azcore.ETag) string {
+	if etag == nil {
+		return ""
+	}
+	return string(*etag)
+}
+
 func checkDirectoryMarkers(contentType string, metadata map[string]*string) bool {
 	if contentType == dirMimeType {
 		return true
@@ -1149,6 +1340,18 @@ func checkDirectoryMarkers(contentType string, metadata map[string]*string) bool
 	return false
 }
 
+func isAzDirectory(attrs blob.GetPropertiesResponse) bool {
+	if util.GetStringFromPointer(attrs.ContentType) == dirMimeType {
+		return true
+	}
+	for k, v := range attrs.Metadata {
+		if strings.ToLower(k) == azFolderKey {
+			return v == "true"
+		}
+	}
+	return false
+}
+
 func getAzContainerClientOptions() *container.ClientOptions {
 	version := version.Get()
 	return &container.ClientOptions{
@@ -26,6 +26,7 @@ type FileInfo struct {
 	sizeInBytes int64
 	modTime     time.Time
 	mode        os.FileMode
+	etag        string
 }
 
 // NewFileInfo creates file info.
@@ -77,6 +78,18 @@ func (fi *FileInfo) SetMode(mode os.FileMode) {
 	fi.mode = mode
 }
 
+// SetETag sets the entity tag reported by the storage backend, if any
+func (fi *FileInfo) SetETag(etag string) {
+	fi.etag = etag
+}
+
+// ETag returns the entity tag reported by the storage backend, for example
+// the S3 ETag or the GCS generation. An empty string is returned if the
+// backend does not provide it
+func (fi *FileInfo) ETag() string {
+	return fi.etag
+}
+
 // Sys provides the underlying data source (can return nil)
 func (fi *FileInfo) Sys() any {
 	return nil
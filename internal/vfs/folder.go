@@ -45,6 +45,10 @@ type BaseVirtualFolder struct {
 	Groups []string `json:"groups,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// SharedBy is the user sharing this folder, it is set for the folders added
+	// at login time for internal shares and it is never stored. The used quota
+	// is charged to this user
+	SharedBy string `json:"-"`
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -69,6 +73,7 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		Users:           users,
 		Groups:          v.Groups,
 		FsConfig:        v.FsConfig.GetACopy(),
+		SharedBy:        v.SharedBy,
 	}
 }
 
@@ -26,12 +26,14 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/rs/xid"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
@@ -46,6 +48,9 @@ import (
 const (
 	defaultGCSPageSize = 5000
 	gcsfsName          = "GCSFs"
+	// the maximum number of source objects for a single compose request
+	gcsComposeMaxSources = 32
+	gcsMaxMetadataSize   = 8192
 )
 
 var (
@@ -121,7 +126,7 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
-	_, info, err := fs.getObjectStat(name)
+	_, _, info, err := fs.getObjectStat(name)
 	return info, err
 }
 
@@ -214,7 +219,7 @@ func (fs *GCSFs) Rename(source, target string) error {
 	if source == target {
 		return nil
 	}
-	realSourceName, fi, err := fs.getObjectStat(source)
+	realSourceName, attrs, fi, err := fs.getObjectStat(source)
 	if err != nil {
 		return err
 	}
@@ -226,9 +231,18 @@ func (fs *GCSFs) Rename(source, target string) error {
 		if hasContents {
 			return fmt.Errorf("cannot rename non empty directory: %#v", source)
 		}
+		props, err := fs.GetProperties(source)
+		if err != nil {
+			return err
+		}
 		if err := fs.mkdirInternal(target); err != nil {
 			return err
 		}
+		if len(props) > 0 {
+			if err := fs.SetProperties(target, props); err != nil {
+				return err
+			}
+		}
 	} else {
 		src := fs.svc.Bucket(fs.config.Bucket).Object(realSourceName)
 		dst := fs.svc.Bucket(fs.config.Bucket).Object(target)
@@ -236,6 +250,7 @@ func (fs *GCSFs) Rename(source, target string) error {
 		defer cancelFn()
 
 		copier := dst.CopierFrom(src)
+		copier.Metadata = attrs.Metadata
 		if fs.config.StorageClass != "" {
 			copier.StorageClass = fs.config.StorageClass
 		}
@@ -263,6 +278,74 @@ func (fs *GCSFs) Rename(source, target string) error {
 	return fs.Remove(source, fi.IsDir())
 }
 
+// Compose creates target by concatenating the specified sources. A compose
+// request accepts up to 32 sources, if there are more sources they are
+// composed in intermediate objects removed after composing the target
+func (fs *GCSFs) Compose(sources []string, target string) error {
+	if len(sources) == 0 {
+		return ErrVfsUnsupported
+	}
+	var tempObjects []string
+	defer func() {
+		for _, name := range tempObjects {
+			ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+			err := fs.svc.Bucket(fs.config.Bucket).Object(name).Delete(ctx)
+			cancelFn()
+			metric.GCSDeleteObjectCompleted(err)
+			if err != nil {
+				fsLog(fs, logger.LevelWarn, "unable to remove intermediate compose object %q: %v", name, err)
+			}
+		}
+	}()
+
+	prefix := path.Join(path.Dir(target), ".sftpgo-compose."+xid.New().String())
+	for len(sources) > gcsComposeMaxSources {
+		var composed []string
+		for start := 0; start < len(sources); start += gcsComposeMaxSources {
+			end := start + gcsComposeMaxSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			if end-start == 1 {
+				composed = append(composed, sources[start])
+				continue
+			}
+			name := fmt.Sprintf("%s.%d", prefix, len(tempObjects))
+			tempObjects = append(tempObjects, name)
+			if err := fs.composeObjects(sources[start:end], name, ""); err != nil {
+				return err
+			}
+			composed = append(composed, name)
+		}
+		sources = composed
+	}
+	return fs.composeObjects(sources, target, mime.TypeByExtension(path.Ext(target)))
+}
+
+func (fs *GCSFs) composeObjects(sources []string, target, contentType string) error {
+	bkt := fs.svc.Bucket(fs.config.Bucket)
+	srcs := make([]*storage.ObjectHandle, 0, len(sources))
+	for _, source := range sources {
+		srcs = append(srcs, bkt.Object(source))
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	composer := bkt.Object(target).ComposerFrom(srcs...)
+	if fs.config.StorageClass != "" {
+		composer.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		composer.PredefinedACL = fs.config.ACL
+	}
+	if contentType != "" {
+		composer.ContentType = contentType
+	}
+	_, err := composer.Run(ctx)
+	metric.GCSCopyObjectCompleted(err)
+	return err
+}
+
 // Remove removes the named file or (empty) directory.
 func (fs *GCSFs) Remove(name string, isDir bool) error {
 	if isDir {
@@ -413,7 +496,9 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 				if t, ok := modTimes[name]; ok {
 					modTime = util.GetTimeFromMsecSinceEpoch(t)
 				}
-				result = append(result, NewFileInfo(name, isDir, attrs.Size, modTime, false))
+				info := NewFileInfo(name, isDir, attrs.Size, modTime, false)
+				info.SetETag(getGCSETag(attrs))
+				result = append(result, info)
 			}
 		}
 
@@ -717,36 +802,48 @@ func (fs *GCSFs) resolve(name, prefix, contentType string) (string, bool) {
 	return result, isDir
 }
 
-// getObjectStat returns the stat result and the real object name as first value
-func (fs *GCSFs) getObjectStat(name string) (string, os.FileInfo, error) {
+// getGCSETag returns the object generation, it changes each time the object
+// data is overwritten
+func getGCSETag(attrs *storage.ObjectAttrs) string {
+	if attrs.Generation == 0 {
+		return ""
+	}
+	return strconv.FormatInt(attrs.Generation, 10)
+}
+
+// getObjectStat returns the stat result, the real object name as first value
+// and the object attributes, they are nil for virtual directories
+func (fs *GCSFs) getObjectStat(name string) (string, *storage.ObjectAttrs, os.FileInfo, error) {
 	attrs, err := fs.headObject(name)
 	var info os.FileInfo
 	if err == nil {
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
-		info, err = updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, objSize, objectModTime, false))
-		return name, info, err
+		fi := NewFileInfo(name, isDir, objSize, objectModTime, false)
+		fi.SetETag(getGCSETag(attrs))
+		info, err = updateFileInfoModTime(fs.getStorageID(), name, fi)
+		return name, attrs, info, err
 	}
 	if !fs.IsNotExist(err) {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 	// now check if this is a prefix (virtual directory)
 	hasContents, err := fs.hasContents(name)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 	if hasContents {
 		info, err = updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
-		return name, info, err
+		return name, nil, info, err
 	}
 	// finally check if this is an object with a trailing /
 	attrs, err = fs.headObject(name + "/")
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 	info, err = updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, attrs.Size, attrs.Updated, false))
-	return name + "/", info, err
+	return name + "/", attrs, info, err
 }
 
 func (fs *GCSFs) mkdirInternal(name string) error {
@@ -819,6 +916,108 @@ func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
 	return attrs, err
 }
 
+// GetProperties returns the custom properties stored within the metadata of the
+// object for the named file or directory. Nil is returned if there are none
+func (fs *GCSFs) GetProperties(name string) ([]byte, error) {
+	if fs.isRootDir(name) {
+		return nil, ErrVfsUnsupported
+	}
+	_, attrs, err := fs.getPropertiesObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			// virtual directory without a directory object
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getPropertiesFromMetadata(attrs.Metadata)
+}
+
+// SetProperties stores the custom properties for the named file or directory
+// within the object metadata, a directory object is created for virtual
+// directories. Empty data removes the stored properties
+func (fs *GCSFs) SetProperties(name string, data []byte) error {
+	if fs.isRootDir(name) {
+		return ErrVfsUnsupported
+	}
+	key, attrs, err := fs.getPropertiesObject(name)
+	if err != nil {
+		if !fs.IsNotExist(err) || len(data) == 0 {
+			return err
+		}
+		hasContents, errContents := fs.hasContents(name)
+		if errContents != nil {
+			return errContents
+		}
+		if !hasContents {
+			return err
+		}
+		return fs.putDirWithProperties(key, data)
+	}
+	objMetadata, err := setPropertiesInMetadata(attrs.Metadata, data, gcsMaxMetadataSize)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 && len(objMetadata) > 0 {
+		// metadata are merged on update, an empty map removes all of them
+		objMetadata[propertiesMetadataKey] = ""
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.svc.Bucket(fs.config.Bucket).Object(key).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: objMetadata,
+	})
+	if err != nil {
+		return err
+	}
+	if key == name && attrs.ContentType != dirMimeType {
+		preserveModTimeAfterMetadataUpdate(fs, fs.getStorageID(), name, attrs.Updated)
+	}
+	return nil
+}
+
+// getPropertiesObject returns the key and the attributes of the object storing
+// the properties for the named file or directory
+func (fs *GCSFs) getPropertiesObject(name string) (string, *storage.ObjectAttrs, error) {
+	attrs, err := fs.headObject(name)
+	if err == nil {
+		return name, attrs, nil
+	}
+	if !fs.IsNotExist(err) {
+		return "", nil, err
+	}
+	key := name + "/"
+	attrs, err = fs.headObject(key)
+	return key, attrs, err
+}
+
+func (fs *GCSFs) putDirWithProperties(key string, data []byte) error {
+	objMetadata, err := setPropertiesInMetadata(nil, data, gcsMaxMetadataSize)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objectWriter := fs.svc.Bucket(fs.config.Bucket).Object(key).NewWriter(ctx)
+	objectWriter.ObjectAttrs.ContentType = dirMimeType
+	objectWriter.ObjectAttrs.Metadata = objMetadata
+	if fs.config.StorageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		objectWriter.PredefinedACL = fs.config.ACL
+	}
+	err = objectWriter.Close()
+	metric.GCSTransferCompleted(0, 0, err)
+	return err
+}
+
+func (fs *GCSFs) isRootDir(name string) bool {
+	return name == "" || name == "/" || name == "." || fs.config.KeyPrefix == name+"/"
+}
+
 // GetMimeType returns the content type
 func (fs *GCSFs) GetMimeType(name string) (string, error) {
 	attrs, err := fs.headObject(name)
@@ -37,6 +37,8 @@ import (
 const (
 	// osFsName is the name for the local Fs implementation
 	osFsName = "osfs"
+	// extended attribute used to store custom properties
+	propertiesXattrName = "user.sftpgo.properties"
 )
 
 type pathResolutionError struct {
@@ -142,6 +144,21 @@ func (*OsFs) Remove(name string, isDir bool) error {
 	return os.Remove(name)
 }
 
+// GetProperties returns the custom properties stored for the named file or
+// directory inside an extended attribute. Nil is returned if there are none
+func (*OsFs) GetProperties(name string) ([]byte, error) {
+	return getXattr(name, propertiesXattrName)
+}
+
+// SetProperties stores the custom properties for the named file or directory
+// inside an extended attribute. Empty data removes the stored properties
+func (*OsFs) SetProperties(name string, data []byte) error {
+	if len(data) == 0 {
+		return removeXattr(name, propertiesXattrName)
+	}
+	return setXattr(name, propertiesXattrName, data)
+}
+
 // Mkdir creates a new directory with the specified name and default permissions
 func (*OsFs) Mkdir(name string) error {
 	return os.Mkdir(name, os.ModePerm)
@@ -152,6 +169,11 @@ func (*OsFs) Symlink(source, target string) error {
 	return os.Symlink(source, target)
 }
 
+// Link creates target as a hard link to source.
+func (*OsFs) Link(source, target string) error {
+	return os.Link(source, target)
+}
+
 // Readlink returns the destination of the named symbolic link
 // as absolute virtual path
 func (fs *OsFs) Readlink(name string) (string, error) {
@@ -18,6 +18,7 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -57,6 +58,10 @@ const (
 	// using this mime type for directories improves compatibility with s3fs-fuse
 	s3DirMimeType        = "application/x-directory"
 	s3TransferBufferSize = 256 * 1024
+	s3MinPartSize        = 5 * 1024 * 1024
+	s3MaxCopyPartSize    = 5 * 1024 * 1024 * 1024
+	s3MaxParts           = 10000
+	s3MaxMetadataSize    = 2048
 	s3fsName             = "S3Fs"
 )
 
@@ -166,8 +171,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 		// Some S3 providers (like SeaweedFS) remove the trailing '/' from object keys.
 		// So we check some common content types to detect if this is a "directory".
 		isDir := util.Contains(s3DirMimeTypes, util.GetStringFromPointer(obj.ContentType))
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, obj.ContentLength,
-			util.GetTimeFromPointer(obj.LastModified), false))
+		info := NewFileInfo(name, isDir, obj.ContentLength, util.GetTimeFromPointer(obj.LastModified), false)
+		info.SetETag(util.GetStringFromPointer(obj.ETag))
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return result, err
@@ -303,9 +309,18 @@ func (fs *S3Fs) Rename(source, target string) error {
 		if hasContents {
 			return fmt.Errorf("cannot rename non empty directory: %q", source)
 		}
+		props, err := fs.GetProperties(source)
+		if err != nil {
+			return err
+		}
 		if err := fs.mkdirInternal(target); err != nil {
 			return err
 		}
+		if len(props) > 0 {
+			if err := fs.SetProperties(target, props); err != nil {
+				return err
+			}
+		}
 	} else {
 		contentType := mime.TypeByExtension(path.Ext(source))
 		copySource := pathEscape(fs.Join(fs.config.Bucket, source))
@@ -313,7 +328,12 @@ func (fs *S3Fs) Rename(source, target string) error {
 		if fi.Size() > 500*1024*1024 {
 			fsLog(fs, logger.LevelDebug, "renaming file %q with size %d using multipart copy",
 				source, fi.Size())
-			err = fs.doMultipartCopy(copySource, target, contentType, fi.Size())
+			// the metadata are not copied using a multipart copy
+			obj, err := fs.headObject(source)
+			if err != nil {
+				return err
+			}
+			err = fs.doMultipartCopy(copySource, target, contentType, obj.Metadata, fi.Size())
 		} else {
 			ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 			defer cancelFn()
@@ -492,8 +512,9 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				objectModTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
-			result = append(result, NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size,
-				objectModTime, false))
+			info := NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size, objectModTime, false)
+			info.SetETag(util.GetStringFromPointer(fileObject.ETag))
+			result = append(result, info)
 		}
 	}
 
@@ -810,7 +831,72 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	return false, nil
 }
 
-func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int64) error {
+// s3CopyPart defines a byte range, end excluded, to copy from the specified
+// escaped source using a multipart copy
+type s3CopyPart struct {
+	source string
+	start  int64
+	end    int64
+}
+
+func (fs *S3Fs) doMultipartCopy(source, target, contentType string, objMetadata map[string]string,
+	fileSize int64,
+) error {
+	// We use 32 MB part size and copy 10 parts in parallel.
+	// These values are arbitrary. We don't want to start too many goroutines
+	maxPartSize := int64(32 * 1024 * 1024)
+	if fileSize > int64(100*1024*1024*1024) {
+		maxPartSize = int64(500 * 1024 * 1024)
+	}
+	var parts []s3CopyPart
+	for offset := int64(0); offset < fileSize; offset += maxPartSize {
+		end := offset + maxPartSize
+		if end > fileSize {
+			end = fileSize
+		}
+		parts = append(parts, s3CopyPart{source: source, start: offset, end: end})
+	}
+	return fs.copyParts(parts, target, contentType, objMetadata)
+}
+
+// Compose creates target by concatenating the specified sources using a
+// multipart copy. Each source, except the last one, must be at least 5 MB
+func (fs *S3Fs) Compose(sources []string, target string) error {
+	var parts []s3CopyPart
+	for idx, source := range sources {
+		obj, err := fs.headObject(source)
+		if err != nil {
+			return err
+		}
+		size := obj.ContentLength
+		if size < s3MinPartSize && idx < len(sources)-1 {
+			fsLog(fs, logger.LevelDebug, "unable to compose %q, the source %q is too small: %d", target, source, size)
+			return ErrVfsUnsupported
+		}
+		if size == 0 {
+			continue
+		}
+		numParts := (size + s3MaxCopyPartSize - 1) / s3MaxCopyPartSize
+		partSize := (size + numParts - 1) / numParts
+		copySource := pathEscape(fs.Join(fs.config.Bucket, source))
+		for offset := int64(0); offset < size; offset += partSize {
+			end := offset + partSize
+			if end > size {
+				end = size
+			}
+			parts = append(parts, s3CopyPart{source: copySource, start: offset, end: end})
+		}
+	}
+	if len(parts) == 0 || len(parts) > s3MaxParts {
+		return ErrVfsUnsupported
+	}
+	err := fs.copyParts(parts, target, mime.TypeByExtension(path.Ext(target)), nil)
+	metric.S3CopyObjectCompleted(err)
+	return err
+}
+
+// copyParts creates target using a multipart copy for the specified parts
+func (fs *S3Fs) copyParts(parts []s3CopyPart, target, contentType string, objMetadata map[string]string) error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
@@ -820,6 +906,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 		StorageClass: types.StorageClass(fs.config.StorageClass),
 		ACL:          types.ObjectCannedACL(fs.config.ACL),
 		ContentType:  util.NilIfEmpty(contentType),
+		Metadata:     objMetadata,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to create multipart copy request: %w", err)
@@ -828,34 +915,19 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	if uploadID == "" {
 		return errors.New("unable to get multipart copy upload ID")
 	}
-	// We use 32 MB part size and copy 10 parts in parallel.
-	// These values are arbitrary. We don't want to start too many goroutines
-	maxPartSize := int64(32 * 1024 * 1024)
-	if fileSize > int64(100*1024*1024*1024) {
-		maxPartSize = int64(500 * 1024 * 1024)
-	}
 	guard := make(chan struct{}, 10)
-	finished := false
 	var completedParts []types.CompletedPart
 	var partMutex sync.Mutex
 	var wg sync.WaitGroup
 	var hasError atomic.Bool
 	var errOnce sync.Once
 	var copyError error
-	var partNumber int32
-	var offset int64
 
 	opCtx, opCancel := context.WithCancel(context.Background())
 	defer opCancel()
 
-	for partNumber = 1; !finished; partNumber++ {
-		start := offset
-		end := offset + maxPartSize
-		if end >= fileSize {
-			end = fileSize
-			finished = true
-		}
-		offset = end
+	for idx, part := range parts {
+		partNumber := int32(idx + 1)
 
 		guard <- struct{}{}
 		if hasError.Load() {
@@ -864,7 +936,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 		}
 
 		wg.Add(1)
-		go func(partNum int32, partStart, partEnd int64) {
+		go func(partNum int32, part s3CopyPart) {
 			defer func() {
 				<-guard
 				wg.Done()
@@ -875,11 +947,11 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 
 			partResp, err := fs.svc.UploadPartCopy(innerCtx, &s3.UploadPartCopyInput{
 				Bucket:          aws.String(fs.config.Bucket),
-				CopySource:      aws.String(source),
+				CopySource:      aws.String(part.source),
 				Key:             aws.String(target),
 				PartNumber:      partNum,
 				UploadId:        aws.String(uploadID),
-				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", partStart, partEnd-1)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", part.start, part.end-1)),
 			})
 			if err != nil {
 				errOnce.Do(func() {
@@ -909,7 +981,7 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 				PartNumber: partNum,
 			})
 			partMutex.Unlock()
-		}(partNumber, start, end)
+		}(partNumber, part)
 	}
 
 	wg.Wait()
@@ -962,6 +1034,118 @@ func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	return obj, err
 }
 
+// GetProperties returns the custom properties stored within the metadata of the
+// object for the named file or directory. Nil is returned if there are none
+func (fs *S3Fs) GetProperties(name string) ([]byte, error) {
+	if fs.isRootDir(name) {
+		return nil, ErrVfsUnsupported
+	}
+	_, obj, err := fs.getPropertiesObject(name)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			// virtual directory without a directory object
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getPropertiesFromMetadata(obj.Metadata)
+}
+
+// SetProperties stores the custom properties for the named file or directory
+// within the object metadata. The object is copied over itself to replace its
+// metadata, a directory object is created for virtual directories.
+// Empty data removes the stored properties
+func (fs *S3Fs) SetProperties(name string, data []byte) error {
+	if fs.isRootDir(name) {
+		return ErrVfsUnsupported
+	}
+	key, obj, err := fs.getPropertiesObject(name)
+	if err != nil {
+		if !fs.IsNotExist(err) || len(data) == 0 {
+			return err
+		}
+		hasContents, errContents := fs.hasContents(name)
+		if errContents != nil {
+			return errContents
+		}
+		if !hasContents {
+			return err
+		}
+		return fs.putDirWithProperties(key, data)
+	}
+	objMetadata, err := setPropertiesInMetadata(obj.Metadata, data, s3MaxMetadataSize)
+	if err != nil {
+		return err
+	}
+	contentType := util.GetStringFromPointer(obj.ContentType)
+	copySource := pathEscape(fs.Join(fs.config.Bucket, key))
+	if obj.ContentLength > 500*1024*1024 {
+		err = fs.doMultipartCopy(copySource, key, contentType, objMetadata, obj.ContentLength)
+	} else {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		_, err = fs.svc.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(fs.config.Bucket),
+			CopySource:        aws.String(copySource),
+			Key:               aws.String(key),
+			StorageClass:      types.StorageClass(fs.config.StorageClass),
+			ACL:               types.ObjectCannedACL(fs.config.ACL),
+			ContentType:       util.NilIfEmpty(contentType),
+			Metadata:          objMetadata,
+			MetadataDirective: types.MetadataDirectiveReplace,
+		})
+	}
+	metric.S3CopyObjectCompleted(err)
+	if err != nil {
+		return err
+	}
+	if key == name && !util.Contains(s3DirMimeTypes, contentType) {
+		preserveModTimeAfterMetadataUpdate(fs, fs.getStorageID(), name, util.GetTimeFromPointer(obj.LastModified))
+	}
+	return nil
+}
+
+// getPropertiesObject returns the key and the attributes of the object storing
+// the properties for the named file or directory
+func (fs *S3Fs) getPropertiesObject(name string) (string, *s3.HeadObjectOutput, error) {
+	obj, err := fs.headObject(name)
+	if err == nil {
+		return name, obj, nil
+	}
+	if !fs.IsNotExist(err) {
+		return "", nil, err
+	}
+	key := name + "/"
+	obj, err = fs.headObject(key)
+	return key, obj, err
+}
+
+func (fs *S3Fs) putDirWithProperties(key string, data []byte) error {
+	objMetadata, err := setPropertiesInMetadata(nil, data, s3MaxMetadataSize)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err = fs.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(nil),
+		ACL:          types.ObjectCannedACL(fs.config.ACL),
+		StorageClass: types.StorageClass(fs.config.StorageClass),
+		ContentType:  aws.String(s3DirMimeType),
+		Metadata:     objMetadata,
+	})
+	metric.S3TransferCompleted(0, 0, err)
+	return err
+}
+
+func (fs *S3Fs) isRootDir(name string) bool {
+	return name == "" || name == "/" || name == "." || fs.config.KeyPrefix == name+"/"
+}
+
 // GetMimeType returns the content type
 func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	obj, err := fs.headObject(name)
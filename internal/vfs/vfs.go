@@ -16,6 +16,7 @@
 package vfs
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -38,12 +39,21 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
-const dirMimeType = "inode/directory"
+const (
+	dirMimeType = "inode/directory"
+	// ChunkedUploadsDirName is the directory, inside the user root filesystem,
+	// where the WebDAV chunked uploads are stored until they are assembled
+	ChunkedUploadsDirName = ".sftpgo-uploads"
+	// object metadata key used to store custom properties on cloud storage backends
+	propertiesMetadataKey = "sftpgoproperties"
+)
 
 var (
 	validAzAccessTier = []string{"", "Archive", "Hot", "Cool"}
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
+	// ErrPropertiesTooLarge is returned if the custom properties to store exceed the allowed size
+	ErrPropertiesTooLarge = errors.New("the properties exceed the allowed size")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
 	ErrVfsUnsupported    = errors.New("not supported")
 	tempPath             string
@@ -115,6 +125,32 @@ type FsRealPather interface {
 	RealPath(p string) (string, error)
 }
 
+// FsHardlinker is a Fs that implements the Link method.
+// Hard links are only supported for filesystems implementing this interface
+type FsHardlinker interface {
+	Fs
+	Link(source, target string) error
+}
+
+// FsPropertiesHolder is a Fs able to store custom properties, for example the
+// WebDAV dead properties, inside the backend metadata. The stored properties
+// follow the path when it is renamed and they are removed with it.
+// ErrVfsUnsupported is returned if the backend cannot store them
+type FsPropertiesHolder interface {
+	Fs
+	GetProperties(name string) ([]byte, error)
+	SetProperties(name string, data []byte) error
+}
+
+// FsFileComposer is a Fs able to create a file by concatenating existing files
+// server side, without downloading and uploading them again.
+// ErrVfsUnsupported is returned if the specified sources cannot be composed,
+// in this case the caller should copy the data itself
+type FsFileComposer interface {
+	Fs
+	Compose(sources []string, target string) error
+}
+
 // fsMetadataChecker is a Fs that implements the getFileNamesInPrefix method.
 // This interface is used to abstract metadata consistency checks
 type fsMetadataChecker interface {
@@ -703,6 +739,12 @@ func isDirectory(fs Fs, path string) (bool, error) {
 	return fileInfo.IsDir(), err
 }
 
+// IsChunkedUploadsPath returns true if the specified virtual path is the
+// chunked uploads directory or is inside it
+func IsChunkedUploadsPath(virtualPath string) bool {
+	return virtualPath == "/"+ChunkedUploadsDirName || strings.HasPrefix(virtualPath, "/"+ChunkedUploadsDirName+"/")
+}
+
 // IsLocalOsFs returns true if fs is a local filesystem implementation
 func IsLocalOsFs(fs Fs) bool {
 	return fs.Name() == osFsName
@@ -902,6 +944,67 @@ func getMountPath(mountPath string) string {
 	return mountPath
 }
 
+// getPropertiesFromMetadata returns the custom properties stored inside the
+// specified object metadata. Nil is returned if there are none
+func getPropertiesFromMetadata(objMetadata map[string]string) ([]byte, error) {
+	for k, v := range objMetadata {
+		if strings.ToLower(k) == propertiesMetadataKey {
+			if v == "" {
+				return nil, nil
+			}
+			return base64.StdEncoding.DecodeString(v)
+		}
+	}
+	return nil, nil
+}
+
+// setPropertiesInMetadata returns a copy of the specified object metadata with
+// the custom properties replaced. The properties are base64 encoded since
+// metadata values are restricted to ASCII characters and they must fit, with the
+// other metadata, within the specified limit. Empty data removes the properties
+func setPropertiesInMetadata(objMetadata map[string]string, data []byte, maxSize int) (map[string]string, error) {
+	result := make(map[string]string)
+	size := 0
+	for k, v := range objMetadata {
+		if strings.ToLower(k) == propertiesMetadataKey {
+			continue
+		}
+		result[k] = v
+		size += len(k) + len(v)
+	}
+	if len(data) == 0 {
+		return result, nil
+	}
+	value := base64.StdEncoding.EncodeToString(data)
+	size += len(propertiesMetadataKey) + len(value)
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: the object metadata size %d exceeds the limit %d", ErrPropertiesTooLarge,
+			size, maxSize)
+	}
+	result[propertiesMetadataKey] = value
+	return result, nil
+}
+
+// preserveModTimeAfterMetadataUpdate stores the specified modification time,
+// using the metadata plugin, if there is no modification time for the named
+// file. Updating the object metadata changes the object modification time
+func preserveModTimeAfterMetadataUpdate(fs Fs, storageID, name string, modTime time.Time) {
+	if !plugin.Handler.HasMetadater() {
+		return
+	}
+	_, err := plugin.Handler.GetModificationTime(storageID, ensureAbsPath(name), false)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, metadata.ErrNoSuchObject) {
+		err = plugin.Handler.SetModificationTime(storageID, ensureAbsPath(name), util.GetTimeAsMsSinceEpoch(modTime))
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to preserve modification time after updating the metadata for %q: %+v",
+			name, err)
+	}
+}
+
 func fsLog(fs Fs, level logger.LogLevel, format string, v ...any) {
 	logger.Log(level, fs.Name(), fs.ConnectionID(), format, v...)
 }
@@ -0,0 +1,68 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func getXattr(name, attr string) ([]byte, error) {
+	for {
+		size, err := unix.Getxattr(name, attr, nil)
+		if err != nil {
+			return nil, convertXattrError(err)
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		data := make([]byte, size)
+		size, err = unix.Getxattr(name, attr, data)
+		if err != nil {
+			// the attribute could be modified between the two calls
+			if errors.Is(err, unix.ERANGE) {
+				continue
+			}
+			return nil, convertXattrError(err)
+		}
+		return data[:size], nil
+	}
+}
+
+func setXattr(name, attr string, data []byte) error {
+	return convertXattrError(unix.Setxattr(name, attr, data, 0))
+}
+
+func removeXattr(name, attr string) error {
+	return convertXattrError(unix.Removexattr(name, attr))
+}
+
+func convertXattrError(err error) error {
+	switch {
+	case err == nil, errors.Is(err, unix.ENODATA):
+		return nil
+	case errors.Is(err, unix.ENOTSUP), errors.Is(err, unix.EPERM):
+		return ErrVfsUnsupported
+	case errors.Is(err, unix.E2BIG), errors.Is(err, unix.ENOSPC):
+		return fmt.Errorf("%w: %v", ErrPropertiesTooLarge, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,589 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	// chunkedUploadsPath is the URL path, relative to the binding prefix, for
+	// the Nextcloud chunking v2 uploads
+	chunkedUploadsPath      = "/remote.php/dav/uploads"
+	chunkedUploadTargetName = ".file"
+	chunkedUploadMaxChunks  = 10000
+)
+
+var (
+	chunkedUploadIDRegex  = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,255}$`)
+	errInvalidDestination = errors.New("invalid destination")
+)
+
+// chunkedUpload defines a request inside the chunked uploads namespace
+type chunkedUpload struct {
+	fs vfs.Fs
+	// rootDir is the filesystem path for the directory containing all the uploads
+	rootDir string
+	// transferID identifies the upload, it is empty for requests to the user
+	// uploads collection
+	transferID string
+	// name is the chunk name or ".file", it is empty for requests to the upload collection
+	name string
+}
+
+func (u *chunkedUpload) getDir() string {
+	return u.fs.Join(u.rootDir, u.transferID)
+}
+
+// getVirtualPath returns the virtual path for the upload, the chunks are
+// stored inside the chunked uploads directory in the user root filesystem
+func (u *chunkedUpload) getVirtualPath() string {
+	return path.Join("/", vfs.ChunkedUploadsDirName, u.transferID, u.name)
+}
+
+func (u *chunkedUpload) getFsPath() string {
+	if u.transferID == "" {
+		return u.rootDir
+	}
+	if u.name == "" {
+		return u.getDir()
+	}
+	return u.fs.Join(u.getDir(), u.name)
+}
+
+// getChunks returns the filesystem paths for the uploaded chunks sorted
+// by chunk number and their total size
+func (u *chunkedUpload) getChunks() ([]string, int64, error) {
+	entries, err := u.fs.ReadDir(u.getDir())
+	if err != nil {
+		return nil, 0, err
+	}
+	type chunk struct {
+		number int
+		name   string
+	}
+	var chunks []chunk
+	var size int64
+	for _, entry := range entries {
+		number, ok := getChunkNumber(entry.Name())
+		if !ok || !entry.Mode().IsRegular() {
+			continue
+		}
+		chunks = append(chunks, chunk{number: number, name: entry.Name()})
+		size += entry.Size()
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].number < chunks[j].number
+	})
+	result := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		result = append(result, u.fs.Join(u.getDir(), c.name))
+	}
+	return result, size, nil
+}
+
+func isChunkedUploadRequest(reqPath string) bool {
+	return reqPath == chunkedUploadsPath || strings.HasPrefix(reqPath, chunkedUploadsPath+"/")
+}
+
+// getChunkNumber returns the chunk number, Nextcloud chunking v2 requires
+// chunk numbers between 1 and 10000
+func getChunkNumber(name string) (int, bool) {
+	if name == "" || strings.TrimLeft(name, "0123456789") != "" {
+		return 0, false
+	}
+	number, err := strconv.Atoi(name)
+	if err != nil || number < 1 || number > chunkedUploadMaxChunks {
+		return 0, false
+	}
+	return number, true
+}
+
+// getChunkedUpload parses a request path inside the chunked uploads namespace,
+// the expected format is /remote.php/dav/uploads/<username>/<transfer id>/<chunk>
+func (c *Connection) getChunkedUpload(reqPath string) (*chunkedUpload, int, error) {
+	elems := strings.Split(strings.TrimPrefix(reqPath, chunkedUploadsPath), "/")[1:]
+	if len(elems) == 0 || len(elems) > 3 {
+		return nil, http.StatusNotFound, fmt.Errorf("invalid chunked upload path %q", reqPath)
+	}
+	if elems[0] != c.User.Username {
+		return nil, http.StatusForbidden, fmt.Errorf("chunked upload path %q does not match the user", reqPath)
+	}
+	fs, rootPath, err := c.GetFsAndResolvedPath("/")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	upload := &chunkedUpload{
+		fs:      fs,
+		rootDir: fs.Join(rootPath, vfs.ChunkedUploadsDirName),
+	}
+	if len(elems) > 1 {
+		upload.transferID = elems[1]
+		if upload.transferID == "." || upload.transferID == ".." || !chunkedUploadIDRegex.MatchString(upload.transferID) {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid transfer ID %q", upload.transferID)
+		}
+	}
+	if len(elems) > 2 {
+		upload.name = elems[2]
+		if _, ok := getChunkNumber(upload.name); !ok && upload.name != chunkedUploadTargetName {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid chunk name %q", upload.name)
+		}
+	}
+	return upload, http.StatusOK, nil
+}
+
+// handleChunkedUpload handles the requests for the Nextcloud chunking v2 protocol
+func handleChunkedUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, c *Connection, prefix string,
+) (int, error) {
+	reqPath, ok := getRequestPath(r.URL.Path, prefix)
+	if !ok {
+		return writeStatus(w, http.StatusNotFound), errors.New("prefix mismatch")
+	}
+	upload, status, err := c.getChunkedUpload(reqPath)
+	if err != nil {
+		return writeStatus(w, status), err
+	}
+	switch r.Method {
+	case "MKCOL":
+		if upload.transferID == "" || upload.name != "" {
+			return writeStatus(w, http.StatusMethodNotAllowed), errors.New("chunked uploads can only be created inside the user collection")
+		}
+		return c.createChunkedUpload(w, r, upload, prefix)
+	case http.MethodPut:
+		if _, ok := getChunkNumber(upload.name); !ok {
+			return writeStatus(w, http.StatusMethodNotAllowed), errors.New("chunks can only be uploaded inside an upload collection")
+		}
+		return c.uploadChunk(w, r, upload, prefix)
+	case "MOVE":
+		if upload.name != chunkedUploadTargetName {
+			return writeStatus(w, http.StatusMethodNotAllowed), errors.New("only the upload target can be moved")
+		}
+		return c.assembleChunkedUpload(w, r, upload, prefix)
+	case http.MethodDelete:
+		if upload.transferID == "" {
+			return writeStatus(w, http.StatusMethodNotAllowed), errors.New("the user upload collection cannot be removed")
+		}
+		return c.removeChunkedUpload(w, upload)
+	case methodPropfind:
+		return c.propfindChunkedUpload(ctx, w, r, upload, reqPath, prefix)
+	default:
+		return writeStatus(w, http.StatusMethodNotAllowed), fmt.Errorf("method %q is not allowed for chunked uploads", r.Method)
+	}
+}
+
+func (c *Connection) createChunkedUpload(w http.ResponseWriter, r *http.Request, upload *chunkedUpload,
+	prefix string,
+) (int, error) {
+	if _, err := c.checkChunkedUploadDestination(r, prefix); err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	if _, err := upload.fs.Stat(upload.getDir()); err == nil {
+		return writeStatus(w, http.StatusMethodNotAllowed), fmt.Errorf("upload %q already exists", upload.transferID)
+	}
+	if _, err := upload.fs.Stat(upload.rootDir); err != nil {
+		if !upload.fs.IsNotExist(err) {
+			return writeStatus(w, http.StatusInternalServerError), err
+		}
+		if err := upload.fs.Mkdir(upload.rootDir); err != nil {
+			return writeStatus(w, http.StatusInternalServerError), err
+		}
+		vfs.SetPathPermissions(upload.fs, upload.rootDir, c.User.GetUID(), c.User.GetGID())
+	}
+	if err := upload.fs.Mkdir(upload.getDir()); err != nil {
+		return writeStatus(w, http.StatusInternalServerError), err
+	}
+	vfs.SetPathPermissions(upload.fs, upload.getDir(), c.User.GetUID(), c.User.GetGID())
+	common.AddChunkedUploadsUser(c.User.Username)
+	c.Log(logger.LevelDebug, "chunked upload %q created", upload.transferID)
+	return writeStatus(w, http.StatusCreated), nil
+}
+
+// uploadChunk stores a chunk as a transfer inside the chunked uploads directory.
+// The chunks are not included in the disk and transfer quota, the assembled file
+// is, so the size allowed for a chunk is reduced by the size already uploaded
+func (c *Connection) uploadChunk(w http.ResponseWriter, r *http.Request, upload *chunkedUpload,
+	prefix string,
+) (int, error) {
+	if _, err := c.checkChunkedUploadDestination(r, prefix); err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	_, uploadedSize, err := upload.getChunks()
+	if err != nil {
+		if upload.fs.IsNotExist(err) {
+			return writeStatus(w, http.StatusConflict), fmt.Errorf("upload %q does not exist", upload.transferID)
+		}
+		return writeStatus(w, http.StatusInternalServerError), err
+	}
+	chunkPath := upload.getFsPath()
+	if info, err := upload.fs.Stat(chunkPath); err == nil {
+		uploadedSize -= info.Size()
+	}
+	maxSize, err := c.getChunkedUploadMaxSize("/", 0)
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	if maxSize > 0 {
+		maxSize -= uploadedSize
+		if maxSize <= 0 {
+			c.Log(logger.LevelInfo, "denying chunk upload, the uploaded size %d exceeds the allowed size", uploadedSize)
+			return writeStatus(w, http.StatusInsufficientStorage), common.ErrQuotaExceeded
+		}
+	}
+	file, pipeWriter, cancelFn, err := upload.fs.Create(chunkPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		c.Log(logger.LevelError, "error creating chunk %q: %+v", chunkPath, err)
+		return writeStatus(w, http.StatusInternalServerError), c.GetFsError(upload.fs, err)
+	}
+	vfs.SetPathPermissions(upload.fs, chunkPath, c.User.GetUID(), c.User.GetGID())
+	common.AddChunkedUploadsUser(c.User.Username)
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, chunkPath, chunkPath,
+		upload.getVirtualPath(), common.TransferUpload, 0, 0, maxSize, 0, true, upload.fs, c.GetTransferQuota())
+	transfer := newWebDavFile(baseTransfer, pipeWriter, nil)
+	n, err := io.Copy(transfer, r.Body)
+	if err != nil {
+		transfer.TransferError(err)
+	}
+	if errClose := transfer.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to write chunk %q for upload %q: %v", upload.name, upload.transferID, err)
+		upload.fs.Remove(chunkPath, false) //nolint:errcheck
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	c.Log(logger.LevelDebug, "chunk %q for upload %q written, size: %d", upload.name, upload.transferID, n)
+	return writeStatus(w, http.StatusCreated), nil
+}
+
+// assembleChunkedUpload creates the destination file from the uploaded chunks.
+// The chunks are composed server side if the destination is on the same
+// storage backend as the chunks and the backend supports it, otherwise they
+// are copied to the destination as for a normal upload
+func (c *Connection) assembleChunkedUpload(w http.ResponseWriter, r *http.Request, upload *chunkedUpload,
+	prefix string,
+) (int, error) {
+	target, err := c.checkChunkedUploadDestination(r, prefix)
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	chunks, size, err := upload.getChunks()
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(c.GetFsError(upload.fs, err))), err
+	}
+	if len(chunks) == 0 {
+		return writeStatus(w, http.StatusBadRequest), fmt.Errorf("no chunks uploaded for %q", upload.transferID)
+	}
+	if val := r.Header.Get("OC-Total-Length"); val != "" {
+		if totalLength, err := strconv.ParseInt(val, 10, 64); err != nil || totalLength != size {
+			return writeStatus(w, http.StatusBadRequest),
+				fmt.Errorf("the uploaded size %d does not match the total length %q", size, val)
+		}
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(target)
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	status := http.StatusCreated
+	var existingSize int64
+	if info, err := fs.Stat(fsPath); err == nil {
+		if info.IsDir() {
+			return writeStatus(w, http.StatusConflict), fmt.Errorf("the destination %q is a directory", target)
+		}
+		if r.Header.Get("Overwrite") == "F" {
+			return writeStatus(w, http.StatusPreconditionFailed), fmt.Errorf("the destination %q already exists", target)
+		}
+		existingSize = info.Size()
+		status = http.StatusNoContent
+	}
+	maxSize, err := c.getChunkedUploadMaxSize(target, existingSize)
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	if maxSize > 0 && size > maxSize {
+		c.Log(logger.LevelInfo, "denying chunked upload to %q, size %d exceeds the allowed size %d", target, size, maxSize)
+		return writeStatus(w, http.StatusInsufficientStorage), common.ErrQuotaExceeded
+	}
+
+	composed, err := c.writeChunkedUpload(fs, fsPath, target, upload.fs, chunks, size, c.isChunkedUploadFs(target))
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(err)), err
+	}
+	c.Log(logger.LevelDebug, "chunked upload %q assembled to %q, size: %d, composed: %t", upload.transferID,
+		target, size, composed)
+	common.RemoveChunkedUploadDir(upload.fs, upload.getDir(), c.GetID())
+
+	if info, err := fs.Stat(fsPath); err == nil {
+		etag := getETag(info)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("OC-ETag", etag)
+	}
+	if !c.getModificationTime().IsZero() {
+		w.Header().Set("X-OC-MTime", "accepted")
+	}
+	return writeStatus(w, status), nil
+}
+
+// writeChunkedUpload writes the chunks to the specified destination as a normal upload.
+// If canCompose is true and the destination Fs supports it, the chunks are composed
+// server side. It returns true if the chunks were composed
+func (c *Connection) writeChunkedUpload(fs vfs.Fs, fsPath, target string, chunksFs vfs.Fs, chunks []string,
+	size int64, canCompose bool,
+) (bool, error) {
+	composed := false
+	createFn := newFileCreator(fs)
+	if composer, ok := fs.(vfs.FsFileComposer); ok && canCompose {
+		createFn = func(filePath string) (vfs.File, *vfs.PipeWriter, func(), error) {
+			err := composer.Compose(chunks, filePath)
+			if errors.Is(err, vfs.ErrVfsUnsupported) {
+				c.Log(logger.LevelDebug, "unable to compose %q, the chunks will be copied", target)
+				return fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+			}
+			composed = err == nil
+			return nil, nil, nil, err
+		}
+	}
+	davFile, err := c.openUploadFile(fs, fsPath, target, createFn)
+	if err != nil {
+		return false, err
+	}
+	transfer := davFile.(*webDavFile)
+	if composed {
+		transfer.BytesReceived.Store(size)
+	} else if err := c.copyChunks(transfer, chunksFs, chunks); err != nil {
+		transfer.TransferError(err)
+	}
+	return composed, transfer.Close()
+}
+
+// copyChunks writes the specified chunks to the given upload
+func (c *Connection) copyChunks(transfer *webDavFile, fs vfs.Fs, chunks []string) error {
+	for _, chunk := range chunks {
+		file, pipeReader, cancelFn, err := fs.Open(chunk, 0)
+		if err != nil {
+			return c.GetFsError(fs, err)
+		}
+		var reader io.ReadCloser = pipeReader
+		if file != nil {
+			reader = file
+		}
+		_, err = io.Copy(transfer, reader)
+		reader.Close()
+		if cancelFn != nil {
+			cancelFn()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Connection) removeChunkedUpload(w http.ResponseWriter, upload *chunkedUpload) (int, error) {
+	fsPath := upload.getFsPath()
+	info, err := upload.fs.Stat(fsPath)
+	if err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(c.GetFsError(upload.fs, err))), err
+	}
+	if info.IsDir() {
+		common.RemoveChunkedUploadDir(upload.fs, fsPath, c.GetID())
+	} else if err := upload.fs.Remove(fsPath, false); err != nil {
+		return writeStatus(w, c.getChunkedUploadStatus(c.GetFsError(upload.fs, err))), err
+	}
+	return writeStatus(w, http.StatusNoContent), nil
+}
+
+// propfindChunkedUpload returns the properties for the uploads, for the chunks
+// inside an upload or for a single chunk
+func (c *Connection) propfindChunkedUpload(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	upload *chunkedUpload, reqPath, prefix string,
+) (int, error) {
+	depth := r.Header.Get("Depth")
+	if depth == "" {
+		depth = "1"
+	}
+	if depth != "0" && depth != "1" {
+		return writeStatus(w, http.StatusBadRequest), errInvalidDepth
+	}
+	req, err := readPropfindRequest(r)
+	if err != nil {
+		return writeStatus(w, http.StatusBadRequest), err
+	}
+	var names []xml.Name
+	if req.Prop != nil {
+		names = req.Prop
+	}
+	fsPath := upload.getFsPath()
+	info, err := upload.fs.Stat(fsPath)
+	if err != nil {
+		if upload.transferID != "" || !upload.fs.IsNotExist(err) {
+			return writeStatus(w, c.getChunkedUploadStatus(c.GetFsError(upload.fs, err))), err
+		}
+		// the uploads directory is created on first use
+		info = vfs.NewFileInfo(vfs.ChunkedUploadsDirName, true, 0, time.Now(), false)
+	}
+	ms := &multistatus{}
+	addResponse := func(virtualPath, fsPath string, info os.FileInfo) {
+		fi := &webDavFileInfo{
+			FileInfo:    info,
+			Fs:          upload.fs,
+			virtualPath: virtualPath,
+			fsPath:      fsPath,
+		}
+		ms.Responses = append(ms.Responses, msResponse{
+			Href:      getHref(prefix, virtualPath, info.IsDir()),
+			Propstats: getPropstats(ctx, fi, names),
+		})
+	}
+	addResponse(reqPath, fsPath, info)
+	if info.IsDir() && depth == "1" {
+		entries, err := upload.fs.ReadDir(fsPath)
+		if err != nil && !upload.fs.IsNotExist(err) {
+			return writeStatus(w, c.getChunkedUploadStatus(c.GetFsError(upload.fs, err))), err
+		}
+		for _, entry := range entries {
+			addResponse(path.Join(reqPath, entry.Name()), upload.fs.Join(fsPath, entry.Name()), entry)
+		}
+	}
+	return webdav.StatusMulti, writeMultistatus(w, ms)
+}
+
+// checkChunkedUploadDestination checks that the destination is set, that the
+// user can upload to it and that there is enough space for the total length.
+// It returns the destination virtual path
+func (c *Connection) checkChunkedUploadDestination(r *http.Request, prefix string) (string, error) {
+	target, err := getChunkedUploadDestination(r, prefix)
+	if err != nil {
+		return "", err
+	}
+	if target == "" {
+		return "", fmt.Errorf("%w: the destination header is required", errInvalidDestination)
+	}
+	if ok, _ := c.User.IsFileAllowed(target); !ok {
+		c.Log(logger.LevelWarn, "writing file %q is not allowed", target)
+		return "", c.GetPermissionDeniedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(target)
+	if err != nil {
+		return "", err
+	}
+	perm := dataprovider.PermUpload
+	if _, err := fs.Stat(fsPath); err == nil {
+		perm = dataprovider.PermOverwrite
+	}
+	if !c.User.HasPerm(perm, path.Dir(target)) {
+		return "", c.GetPermissionDeniedError()
+	}
+	val := r.Header.Get("OC-Total-Length")
+	if val == "" {
+		return target, nil
+	}
+	totalLength, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid total length %q", errInvalidXMLBody, val)
+	}
+	maxSize, err := c.getChunkedUploadMaxSize(target, 0)
+	if err != nil {
+		return "", err
+	}
+	if maxSize > 0 && totalLength > maxSize {
+		c.Log(logger.LevelInfo, "denying chunked upload to %q, total length %d exceeds the allowed size %d",
+			target, totalLength, maxSize)
+		return "", common.ErrQuotaExceeded
+	}
+	return target, nil
+}
+
+// getChunkedUploadMaxSize returns the maximum allowed size for an upload to the
+// specified path, 0 means no limit. existingSize is the size of the file to overwrite
+func (c *Connection) getChunkedUploadMaxSize(virtualPath string, existingSize int64) (int64, error) {
+	diskQuota, transferQuota := c.HasSpace(existingSize == 0, false, virtualPath)
+	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
+		return 0, common.ErrQuotaExceeded
+	}
+	maxSize, err := c.GetMaxWriteSize(diskQuota, false, existingSize, false)
+	if err != nil {
+		return 0, err
+	}
+	if transferQuota.AllowedULSize > 0 && (maxSize == 0 || transferQuota.AllowedULSize < maxSize) {
+		maxSize = transferQuota.AllowedULSize
+	}
+	if transferQuota.AllowedTotalSize > 0 && (maxSize == 0 || transferQuota.AllowedTotalSize < maxSize) {
+		maxSize = transferQuota.AllowedTotalSize
+	}
+	return maxSize, nil
+}
+
+// isChunkedUploadFs returns true if the specified virtual path is stored on
+// the same storage backend as the uploaded chunks
+func (c *Connection) isChunkedUploadFs(virtualPath string) bool {
+	folder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err != nil {
+		return true
+	}
+	return folder.FsConfig.IsSameResource(c.User.FsConfig)
+}
+
+func (c *Connection) getChunkedUploadStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidXMLBody), errors.Is(err, errInvalidDestination):
+		return http.StatusBadRequest
+	case c.IsQuotaExceededError(err):
+		return http.StatusInsufficientStorage
+	default:
+		return getStatusForError(c, err)
+	}
+}
+
+// getChunkedUploadDestination returns the virtual path for the Destination
+// header, an empty string is returned if the header is not set
+func getChunkedUploadDestination(r *http.Request, prefix string) (string, error) {
+	hdr := r.Header.Get("Destination")
+	if hdr == "" {
+		return "", nil
+	}
+	u, err := url.Parse(hdr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidDestination, err)
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", fmt.Errorf("%w: host mismatch", errInvalidDestination)
+	}
+	target, ok := getRequestPath(u.Path, prefix)
+	if !ok || target == "/" || isChunkedUploadRequest(target) {
+		return "", fmt.Errorf("%w: %q", errInvalidDestination, u.Path)
+	}
+	return target, nil
+}
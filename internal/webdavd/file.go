@@ -16,13 +16,17 @@ package webdavd
 
 import (
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -49,6 +53,8 @@ type webDavFile struct {
 	startOffset int64
 	isFinished  bool
 	readTryed   atomic.Bool
+	// dead properties to store after the upload
+	deadProps []byte
 }
 
 func newWebDavFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter, pipeReader *pipeat.PipeReaderAt) *webDavFile {
@@ -74,6 +80,22 @@ func newWebDavFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter
 	return f
 }
 
+// backendETager is implemented by the os.FileInfo returned by storage backends
+// providing an entity tag, for example S3 and Azure Blob ETags or GCS generations
+type backendETager interface {
+	ETag() string
+}
+
+// eTagFileInfo adds the backend entity tag, if any, to an os.FileInfo
+type eTagFileInfo struct {
+	os.FileInfo
+}
+
+// ETag implements webdav.ETager interface
+func (fi *eTagFileInfo) ETag(ctx context.Context) (string, error) {
+	return getETag(fi.FileInfo), nil
+}
+
 type webDavFileInfo struct {
 	os.FileInfo
 	Fs          vfs.Fs
@@ -103,6 +125,11 @@ func (fi *webDavFileInfo) ContentType(ctx context.Context) (string, error) {
 	return "", webdav.ErrNotImplemented
 }
 
+// ETag implements webdav.ETager interface
+func (fi *webDavFileInfo) ETag(ctx context.Context) (string, error) {
+	return getETag(fi.FileInfo), nil
+}
+
 // Readdir reads directory entries from the handle
 func (f *webDavFile) Readdir(count int) ([]os.FileInfo, error) {
 	if !f.Connection.User.HasPerm(dataprovider.PermListItems, f.GetVirtualPath()) {
@@ -375,6 +402,9 @@ func (f *webDavFile) Close() error {
 		if errBaseClose != nil {
 			err = errBaseClose
 		}
+		if err == nil && f.GetType() == common.TransferUpload {
+			f.storeDeadProps()
+		}
 	} else {
 		f.Connection.RemoveTransfer(f.BaseTransfer)
 	}
@@ -418,51 +448,201 @@ func (f *webDavFile) isTransfer() bool {
 }
 
 // DeadProps returns a copy of the dead properties held.
-// We always return nil for now, we only support the last modification time
-// and it is already included in "live" properties
+// The last modification time is not stored, it is included in "live" properties
 func (f *webDavFile) DeadProps() (map[xml.Name]webdav.Property, error) {
-	return nil, nil
+	data, err := f.Connection.GetWebDAVDeadProps(f.GetVirtualPath())
+	if err != nil {
+		f.Connection.Log(logger.LevelWarn, "unable to get dead properties for %q: %v", f.GetVirtualPath(), err)
+		return nil, err
+	}
+	return decodeDeadProps(data)
 }
 
 // Patch patches the dead properties held.
-// In our minimal implementation we just support Win32LastModifiedTime and
-// getlastmodified to set the the modification time.
-// We ignore any other property and just return an OK response if the patch sets
-// the modification time, otherwise a Forbidden response
+// Win32LastModifiedTime and getlastmodified are used to set the modification
+// time and they are not stored. Removing them is not allowed.
+// The other properties are stored, if dead properties are enabled for the user,
+// replacing the existing ones with the same name
 func (f *webDavFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
 	resp := make([]webdav.Propstat, 0, len(patches))
 	hasError := false
+	var deadProps map[xml.Name]webdav.Property
+	var patchedDeadProps []webdav.Property
+	deadPropsStatus := http.StatusOK
 	for _, patch := range patches {
 		status := http.StatusForbidden
 		pstat := webdav.Propstat{}
 		for _, p := range patch.Props {
-			if status == http.StatusForbidden && !hasError {
-				if !patch.Remove && util.Contains(lastModifiedProps, p.XMLName.Local) {
-					parsed, err := http.ParseTime(string(p.InnerXML))
-					if err != nil {
-						f.Connection.Log(logger.LevelWarn, "unsupported last modification time: %q, err: %v",
-							string(p.InnerXML), err)
-						hasError = true
-						continue
-					}
-					attrs := &common.StatAttributes{
-						Flags: common.StatAttrTimes,
-						Atime: parsed,
-						Mtime: parsed,
-					}
-					if err := f.Connection.SetStat(f.GetVirtualPath(), attrs); err != nil {
-						f.Connection.Log(logger.LevelWarn, "unable to set modification time for %q, err :%v",
-							f.GetVirtualPath(), err)
-						hasError = true
-						continue
-					}
-					status = http.StatusOK
+			if !util.Contains(lastModifiedProps, p.XMLName.Local) {
+				if patchedDeadProps == nil {
+					deadProps, deadPropsStatus = f.getDeadPropsForPatch()
+				}
+				if patch.Remove {
+					delete(deadProps, p.XMLName)
+				} else {
+					deadProps[p.XMLName] = p
+				}
+				patchedDeadProps = append(patchedDeadProps, webdav.Property{XMLName: p.XMLName})
+				continue
+			}
+			if status == http.StatusForbidden && !hasError && !patch.Remove {
+				parsed, err := http.ParseTime(string(p.InnerXML))
+				if err != nil {
+					f.Connection.Log(logger.LevelWarn, "unsupported last modification time: %q, err: %v",
+						string(p.InnerXML), err)
+					hasError = true
+					continue
+				}
+				attrs := &common.StatAttributes{
+					Flags: common.StatAttrTimes,
+					Atime: parsed,
+					Mtime: parsed,
 				}
+				if err := f.Connection.SetStat(f.GetVirtualPath(), attrs); err != nil {
+					f.Connection.Log(logger.LevelWarn, "unable to set modification time for %q, err :%v",
+						f.GetVirtualPath(), err)
+					hasError = true
+					continue
+				}
+				status = http.StatusOK
 			}
 			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
 		}
-		pstat.Status = status
-		resp = append(resp, pstat)
+		if len(pstat.Props) > 0 {
+			pstat.Status = status
+			resp = append(resp, pstat)
+		}
+	}
+	if len(patchedDeadProps) > 0 {
+		if deadPropsStatus == http.StatusOK {
+			deadPropsStatus = f.setDeadProps(deadProps)
+		}
+		resp = append(resp, webdav.Propstat{
+			Props:  patchedDeadProps,
+			Status: deadPropsStatus,
+		})
 	}
 	return resp, nil
 }
+
+func (f *webDavFile) getDeadPropsForPatch() (map[xml.Name]webdav.Property, int) {
+	props := make(map[xml.Name]webdav.Property)
+	if f.Connection.User.GetWebDAVDeadPropsMaxSize() == 0 {
+		return props, http.StatusForbidden
+	}
+	if f.GetType() == common.TransferUpload {
+		// the properties are stored after the upload, see Close
+		return props, http.StatusOK
+	}
+	existing, err := f.DeadProps()
+	if err != nil {
+		return props, http.StatusInternalServerError
+	}
+	for k, v := range existing {
+		props[k] = v
+	}
+	return props, http.StatusOK
+}
+
+func (f *webDavFile) setDeadProps(props map[xml.Name]webdav.Property) int {
+	data, err := encodeDeadProps(props)
+	if err != nil {
+		f.Connection.Log(logger.LevelError, "unable to encode dead properties for %q: %v", f.GetVirtualPath(), err)
+		return http.StatusInternalServerError
+	}
+	if f.GetType() == common.TransferUpload {
+		// the file could be uploaded to a temporary path, we store the properties on close
+		f.Lock()
+		f.deadProps = data
+		f.Unlock()
+		return http.StatusOK
+	}
+	err = f.Connection.SetWebDAVDeadProps(f.GetVirtualPath(), data)
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, vfs.ErrPropertiesTooLarge):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, os.ErrPermission), errors.Is(err, common.ErrOpUnsupported):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// storeDeadProps stores the dead properties patched for an upload
+func (f *webDavFile) storeDeadProps() {
+	f.Lock()
+	data := f.deadProps
+	f.Unlock()
+
+	if len(data) == 0 {
+		return
+	}
+	if err := f.Connection.SetWebDAVDeadProps(f.GetVirtualPath(), data); err != nil {
+		f.Connection.Log(logger.LevelWarn, "unable to store dead properties for %q after upload: %v",
+			f.GetVirtualPath(), err)
+	}
+}
+
+// deadProperty defines a dead property as stored
+type deadProperty struct {
+	Space    string `json:"space,omitempty"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"inner_xml,omitempty"`
+}
+
+func encodeDeadProps(props map[xml.Name]webdav.Property) ([]byte, error) {
+	if len(props) == 0 {
+		return nil, nil
+	}
+	result := make([]deadProperty, 0, len(props))
+	for _, p := range props {
+		result = append(result, deadProperty{
+			Space:    p.XMLName.Space,
+			Local:    p.XMLName.Local,
+			Lang:     p.Lang,
+			InnerXML: string(p.InnerXML),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Space == result[j].Space {
+			return result[i].Local < result[j].Local
+		}
+		return result[i].Space < result[j].Space
+	})
+	return json.Marshal(result)
+}
+
+func decodeDeadProps(data []byte) (map[xml.Name]webdav.Property, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var props []deadProperty
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, err
+	}
+	result := make(map[xml.Name]webdav.Property, len(props))
+	for _, p := range props {
+		name := xml.Name{Space: p.Space, Local: p.Local}
+		result[name] = webdav.Property{
+			XMLName:  name,
+			Lang:     p.Lang,
+			InnerXML: []byte(p.InnerXML),
+		}
+	}
+	return result, nil
+}
+
+// getETag returns a strong entity tag for the specified file. The entity tag
+// reported by the storage backend is used if available, otherwise it is derived
+// from the modification time and the size, like the Apache web server does
+func getETag(info os.FileInfo) string {
+	if e, ok := info.(backendETager); ok {
+		if etag := strings.Trim(e.ETag(), `"`); etag != "" {
+			return fmt.Sprintf(`"%s"`, etag)
+		}
+	}
+	return fmt.Sprintf(`"%x%x"`, info.ModTime().UnixNano(), info.Size())
+}
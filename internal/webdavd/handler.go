@@ -128,7 +128,7 @@ func (c *Connection) Stat(ctx context.Context, name string) (os.FileInfo, error)
 	if err != nil {
 		return nil, err
 	}
-	return fi, err
+	return &eTagFileInfo{FileInfo: fi}, err
 }
 
 // RemoveAll removes path and any children it contains.
@@ -168,7 +168,21 @@ func (c *Connection) getFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 	return newWebDavFile(baseTransfer, nil, nil), nil
 }
 
+// fileCreator creates the file to upload to, it returns the same values as vfs.Fs.Create
+type fileCreator func(filePath string) (vfs.File, *vfs.PipeWriter, func(), error)
+
+// newFileCreator returns a fileCreator that creates or truncates the file using the specified Fs
+func newFileCreator(fs vfs.Fs) fileCreator {
+	return func(filePath string) (vfs.File, *vfs.PipeWriter, func(), error) {
+		return fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	}
+}
+
 func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File, error) {
+	return c.openUploadFile(fs, fsPath, virtualPath, newFileCreator(fs))
+}
+
+func (c *Connection) openUploadFile(fs vfs.Fs, fsPath, virtualPath string, createFn fileCreator) (webdav.File, error) {
 	if ok, _ := c.User.IsFileAllowed(virtualPath); !ok {
 		c.Log(logger.LevelWarn, "writing file %#v is not allowed", virtualPath)
 		return nil, c.GetPermissionDeniedError()
@@ -184,7 +198,7 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
 			return nil, c.GetPermissionDeniedError()
 		}
-		return c.handleUploadToNewFile(fs, fsPath, filePath, virtualPath)
+		return c.handleUploadToNewFile(fs, fsPath, filePath, virtualPath, createFn)
 	}
 
 	if statErr != nil {
@@ -202,10 +216,12 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	return c.handleUploadToExistingFile(fs, fsPath, filePath, stat.Size(), virtualPath)
+	return c.handleUploadToExistingFile(fs, fsPath, filePath, stat.Size(), virtualPath, createFn)
 }
 
-func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, requestPath string) (webdav.File, error) {
+func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, requestPath string,
+	createFn fileCreator,
+) (webdav.File, error) {
 	diskQuota, transferQuota := c.HasSpace(true, false, requestPath)
 	if !diskQuota.HasSpace || !transferQuota.HasUploadSpace() {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
@@ -215,7 +231,7 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 		c.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
 		return nil, c.GetPermissionDeniedError()
 	}
-	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	file, w, cancelFn, err := createFn(filePath)
 	if err != nil {
 		c.Log(logger.LevelError, "error creating file %#v: %+v", resolvedPath, err)
 		return nil, c.GetFsError(fs, err)
@@ -235,7 +251,7 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 }
 
 func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePath string, fileSize int64,
-	requestPath string,
+	requestPath string, createFn fileCreator,
 ) (webdav.File, error) {
 	var err error
 	diskQuota, transferQuota := c.HasSpace(false, false, requestPath)
@@ -262,7 +278,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 		}
 	}
 
-	file, w, cancelFn, err := fs.Create(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	file, w, cancelFn, err := createFn(filePath)
 	if err != nil {
 		c.Log(logger.LevelError, "error creating file %#v: %+v", resolvedPath, err)
 		return nil, c.GetFsError(fs, err)
@@ -27,6 +27,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -323,6 +324,28 @@ func (fs *MockOsFs) GetMimeType(name string) (string, error) {
 	return "application/custom-mime", nil
 }
 
+// MockComposerFs is an OsFs supporting files composition
+type MockComposerFs struct {
+	vfs.Fs
+	err error
+}
+
+// Compose concatenates the sources into target or returns the configured error
+func (fs *MockComposerFs) Compose(sources []string, target string) error {
+	if fs.err != nil {
+		return fs.err
+	}
+	var data []byte
+	for _, source := range sources {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		data = append(data, content...)
+	}
+	return os.WriteFile(target, data, os.ModePerm)
+}
+
 func newMockOsFs(atomicUpload bool, connectionID, rootDir string, reader *pipeat.PipeReaderAt, err error) vfs.Fs {
 	return &MockOsFs{
 		Fs:                      vfs.NewOsFs(connectionID, rootDir, ""),
@@ -569,22 +592,23 @@ func TestFileAccessErrors(t *testing.T) {
 	err = davFile.Close()
 	assert.ErrorIs(t, err, os.ErrNotExist)
 	p := filepath.Join(user.HomeDir, "adir", missingPath)
-	_, err = connection.handleUploadToNewFile(fs, p, p, path.Join("adir", missingPath))
+	_, err = connection.handleUploadToNewFile(fs, p, p, path.Join("adir", missingPath), newFileCreator(fs))
 	assert.ErrorIs(t, err, os.ErrNotExist)
-	_, err = connection.handleUploadToExistingFile(fs, p, "_"+p, 0, path.Join("adir", missingPath))
+	_, err = connection.handleUploadToExistingFile(fs, p, "_"+p, 0, path.Join("adir", missingPath),
+		newFileCreator(fs))
 	if assert.Error(t, err) {
 		assert.ErrorIs(t, err, os.ErrNotExist)
 	}
 
 	fs = newMockOsFs(false, fs.ConnectionID(), user.HomeDir, nil, nil)
-	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, path.Join("adir", missingPath))
+	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, path.Join("adir", missingPath), newFileCreator(fs))
 	assert.ErrorIs(t, err, os.ErrNotExist)
 
 	f, err := os.CreateTemp("", "temp")
 	assert.NoError(t, err)
 	err = f.Close()
 	assert.NoError(t, err)
-	davFile, err = connection.handleUploadToExistingFile(fs, f.Name(), f.Name(), 123, f.Name())
+	davFile, err = connection.handleUploadToExistingFile(fs, f.Name(), f.Name(), 123, f.Name(), newFileCreator(fs))
 	if assert.NoError(t, err) {
 		transfer := davFile.(*webDavFile)
 		transfers := connection.GetTransfers()
@@ -1571,3 +1595,398 @@ func TestMisc(t *testing.T) {
 
 	certMgr = oldCertMgr
 }
+
+func TestLockSystem(t *testing.T) {
+	ls := newLockSystem("user")
+	otherLS := newLockSystem("user1")
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{
+		Root:     "dir",
+		Duration: time.Minute,
+		OwnerXML: "<owner/>",
+	})
+	assert.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{
+		Root:      "/dir/file",
+		Duration:  time.Minute,
+		ZeroDepth: true,
+	})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	// locks are per user
+	otherToken, err := otherLS.Create(now, webdav.LockDetails{
+		Root:     "/dir",
+		Duration: -1,
+	})
+	assert.NoError(t, err)
+
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: otherToken})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls.Confirm(now, "/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	release, err := ls.Confirm(now, "/dir/file", "/dir/sub", webdav.Condition{Token: token})
+	assert.NoError(t, err)
+	// a held lock cannot be confirmed, refreshed or unlocked
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: token})
+	assert.ErrorIs(t, err, webdav.ErrConfirmationFailed)
+	_, err = ls.Refresh(now, token, time.Hour)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	err = ls.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	release()
+
+	details, err := ls.Refresh(now, token, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir", details.Root)
+	assert.Equal(t, "<owner/>", details.OwnerXML)
+	assert.Equal(t, time.Hour, details.Duration)
+	_, err = otherLS.Refresh(now, token, time.Hour)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+
+	lockToken, _, details, err := ls.GetByName("/dir/sub/file")
+	assert.NoError(t, err)
+	assert.Equal(t, token, lockToken)
+	assert.Equal(t, "/dir", details.Root)
+	_, _, _, err = ls.GetByName("/file")
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+
+	deleter, ok := ls.(webdav.LockDeleter)
+	if assert.True(t, ok) {
+		err = deleter.Delete(now, "/dir/sub")
+		assert.NoError(t, err)
+		_, err = common.GetWebDAVLock(token)
+		assert.NoError(t, err)
+		err = deleter.Delete(now, "/")
+		assert.NoError(t, err)
+		_, err = common.GetWebDAVLock(token)
+		assert.Error(t, err)
+		_, err = common.GetWebDAVLock(otherToken)
+		assert.NoError(t, err)
+	}
+	err = ls.Unlock(now, token)
+	assert.ErrorIs(t, err, webdav.ErrNoSuchLock)
+	err = otherLS.Unlock(now, otherToken)
+	assert.NoError(t, err)
+	locks, err := common.GetWebDAVLocks("")
+	assert.NoError(t, err)
+	assert.Len(t, locks, 0)
+	assert.Equal(t, "/", slashClean(""))
+	assert.Equal(t, "/a/b", slashClean("a/b/"))
+}
+
+func TestLockSystemSharedResources(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "lshome")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	assert.NoError(t, err)
+	getConnection := func(username string) *Connection {
+		user := dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: username,
+				HomeDir:  homeDir,
+			},
+		}
+		user.Permissions = make(map[string][]string)
+		user.Permissions["/"] = []string{dataprovider.PermAny}
+		return &Connection{
+			BaseConnection: common.NewBaseConnection("", common.ProtocolWebDAV, "", "", user),
+		}
+	}
+	ls := getConnectionLockSystem(newLockSystem("user"), getConnection("user"))
+	otherLS := getConnectionLockSystem(newLockSystem("user1"), getConnection("user1"))
+	unboundLS := newLockSystem("user1")
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{
+		Root:     "/dir",
+		Duration: time.Minute,
+	})
+	assert.NoError(t, err)
+	lock, err := common.GetWebDAVLock(token)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.ToSlash(filepath.Join(homeDir, "dir")), lock.FsPath)
+		assert.NotEmpty(t, lock.FsName)
+	}
+	_, err = otherLS.Create(now, webdav.LockDetails{
+		Root:      "/dir/file",
+		Duration:  time.Minute,
+		ZeroDepth: true,
+	})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	// without a connection the lock roots are not resolved
+	token1, err := unboundLS.Create(now, webdav.LockDetails{
+		Root:      "/dir/file",
+		Duration:  time.Minute,
+		ZeroDepth: true,
+	})
+	assert.NoError(t, err)
+	err = unboundLS.Unlock(now, token1)
+	assert.NoError(t, err)
+	_, err = otherLS.Create(now, webdav.LockDetails{
+		Root:     "/dir1",
+		Duration: time.Minute,
+	})
+	assert.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{
+		Root:     "/../..",
+		Duration: time.Minute,
+	})
+	assert.ErrorIs(t, err, webdav.ErrLocked)
+	assert.Equal(t, webdav.NewMemLS(), getConnectionLockSystem(webdav.NewMemLS(), nil))
+
+	common.RemoveWebDAVLocks("user")
+	common.RemoveWebDAVLocks("user1")
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+func TestSyncCollectionHelpers(t *testing.T) {
+	changes := []common.FsChange{
+		{VirtualPath: "/dir/file"},
+		{VirtualPath: "/dir/sub/file", Deleted: true},
+		{VirtualPath: "/dir/sub1", Recursive: true},
+		{VirtualPath: "/dir1/file"},
+		{VirtualPath: "/dir"},
+		{VirtualPath: "/dir/sub1/file"},
+	}
+	members := getChangedMembers(changes, "/dir", false)
+	assert.Equal(t, map[string]bool{"/dir/file": false, "/dir/sub": false, "/dir/sub1": false}, members)
+	members = getChangedMembers(changes, "/dir", true)
+	assert.Equal(t, map[string]bool{"/dir/file": false, "/dir/sub/file": false, "/dir/sub1": true,
+		"/dir/sub1/file": false}, members)
+	members = getChangedMembers(changes, "/", false)
+	assert.Equal(t, map[string]bool{"/dir": false, "/dir1": false}, members)
+	assert.True(t, isCollectionMember("/a", "/"))
+	assert.False(t, isCollectionMember("/", "/"))
+	assert.False(t, isCollectionMember("/ab", "/a"))
+	assert.True(t, isCollectionMember("/a/b", "/a"))
+
+	modTime := time.Unix(1668000000, 0)
+	info := vfs.NewFileInfo("file", false, 100, modTime, false)
+	assert.Equal(t, fmt.Sprintf(`"%x%x"`, modTime.UnixNano(), int64(100)), getETag(info))
+	info.SetETag(`"abc"`)
+	assert.Equal(t, `"abc"`, getETag(info))
+	info.SetETag("123")
+	assert.Equal(t, `"123"`, getETag(info))
+	fi := &webDavFileInfo{FileInfo: info}
+	etag, err := fi.ETag(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, `"123"`, etag)
+	etag, err = (&eTagFileInfo{FileInfo: info}).ETag(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, `"123"`, etag)
+}
+
+func TestReadPropfindRequest(t *testing.T) {
+	newRequest := func(body string) *http.Request {
+		req, err := http.NewRequest("PROPFIND", "/", strings.NewReader(body))
+		assert.NoError(t, err)
+		return req
+	}
+	pf, err := readPropfindRequest(newRequest(""))
+	assert.NoError(t, err)
+	assert.NotNil(t, pf.Allprop)
+	pf, err = readPropfindRequest(newRequest(`<D:propfind xmlns:D="DAV:"><D:prop><D:getetag/><Z:tag xmlns:Z="urn:z"/></D:prop></D:propfind>`))
+	assert.NoError(t, err)
+	assert.Nil(t, pf.Allprop)
+	assert.Equal(t, propNames{{Space: "DAV:", Local: "getetag"}, {Space: "urn:z", Local: "tag"}}, pf.Prop)
+	pf, err = readPropfindRequest(newRequest(`<D:propfind xmlns:D="DAV:"><D:allprop/><D:include><D:getetag/></D:include></D:propfind>`))
+	assert.NoError(t, err)
+	assert.NotNil(t, pf.Allprop)
+	assert.Len(t, pf.Include, 1)
+
+	for _, body := range []string{
+		"invalid",
+		`<D:propfind xmlns:D="DAV:"></D:propfind>`,
+		`<D:propfind xmlns:D="DAV:"><D:include><D:getetag/></D:include></D:propfind>`,
+		`<D:propfind xmlns:D="DAV:"><D:allprop/><D:propname/></D:propfind>`,
+		`<D:propfind xmlns:D="DAV:"><D:propname/><D:prop><D:getetag/></D:prop></D:propfind>`,
+	} {
+		_, err = readPropfindRequest(newRequest(body))
+		assert.Error(t, err, body)
+	}
+
+	assert.Empty(t, getLockDiscovery(nil, "/missing"))
+	locks := []common.WebDAVLock{*common.NewWebDAVLock("user", "/dir", true, "<D:href>owner</D:href>", time.Minute)}
+	assert.Empty(t, getLockDiscovery(locks, "/missing"))
+	val := getLockDiscovery(locks, "/dir/file")
+	assert.Contains(t, val, "<D:depth>0</D:depth>")
+	assert.Contains(t, val, "<D:owner><D:href>owner</D:href></D:owner>")
+	assert.Contains(t, val, locks[0].Token)
+
+	pf, err = readPropfindRequest(newRequest(""))
+	assert.NoError(t, err)
+	assert.True(t, pf.needsDeadProps())
+	assert.True(t, pf.needsLockDiscovery())
+	pf, err = readPropfindRequest(newRequest(`<D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`))
+	assert.NoError(t, err)
+	assert.True(t, pf.needsDeadProps())
+	assert.False(t, pf.needsLockDiscovery())
+	pf, err = readPropfindRequest(newRequest(`<D:propfind xmlns:D="DAV:"><D:prop><D:getetag/><D:lockdiscovery/></D:prop></D:propfind>`))
+	assert.NoError(t, err)
+	assert.False(t, pf.needsDeadProps())
+	assert.True(t, pf.needsLockDiscovery())
+	pf, err = readPropfindRequest(newRequest(`<D:propfind xmlns:D="DAV:"><D:prop><D:getetag/><Z:tag xmlns:Z="urn:z"/></D:prop></D:propfind>`))
+	assert.NoError(t, err)
+	assert.True(t, pf.needsDeadProps())
+	assert.False(t, pf.needsLockDiscovery())
+}
+
+func TestSearchHelpers(t *testing.T) {
+	re, err := likeToRegexp(`%.t_t`, true)
+	assert.NoError(t, err)
+	assert.True(t, re.MatchString("file.txt"))
+	assert.True(t, re.MatchString("FILE.TXT"))
+	assert.False(t, re.MatchString("file.tx"))
+	re, err = likeToRegexp(`100\%_a\_`, false)
+	assert.NoError(t, err)
+	assert.True(t, re.MatchString("100%ba_"))
+	assert.False(t, re.MatchString("100%bA_"))
+	assert.False(t, re.MatchString("100abab"))
+
+	_, err = parseSearchTime("2022-11-09T10:00:00Z")
+	assert.NoError(t, err)
+	_, err = parseSearchTime("Wed, 09 Nov 2022 10:00:00 GMT")
+	assert.NoError(t, err)
+	_, err = parseSearchTime("invalid")
+	assert.Error(t, err)
+
+	now := time.Now()
+	assert.Equal(t, -1, compareSearchValues(int64(1), int64(2)))
+	assert.Equal(t, 1, compareSearchValues(int64(2), int64(1)))
+	assert.Equal(t, 0, compareSearchValues(int64(2), int64(2)))
+	assert.Equal(t, -1, compareSearchValues(now, now.Add(time.Second)))
+	assert.Equal(t, 1, compareSearchValues(now, now.Add(-time.Second)))
+	assert.Equal(t, 0, compareSearchValues(now, now))
+	assert.Equal(t, -1, compareSearchValues(int64(1), "a"))
+}
+
+func TestChunkedUploadHelpers(t *testing.T) {
+	for _, name := range []string{"1", "00001", "10000"} {
+		_, ok := getChunkNumber(name)
+		assert.True(t, ok, name)
+	}
+	for _, name := range []string{"", "0", "10001", "-1", "+1", "1a", ".file"} {
+		_, ok := getChunkNumber(name)
+		assert.False(t, ok, name)
+	}
+	assert.True(t, isChunkedUploadRequest("/remote.php/dav/uploads"))
+	assert.True(t, isChunkedUploadRequest("/remote.php/dav/uploads/user/id"))
+	assert.False(t, isChunkedUploadRequest("/remote.php/dav/uploads1"))
+	assert.True(t, vfs.IsChunkedUploadsPath("/.sftpgo-uploads/id"))
+	assert.False(t, vfs.IsChunkedUploadsPath("/dir/.sftpgo-uploads"))
+	assert.False(t, vfs.IsChunkedUploadsPath("/.sftpgo-uploads1"))
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolWebDAV, "", "", dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: "user",
+				HomeDir:  filepath.Clean(os.TempDir()),
+			},
+		}),
+	}
+	for p, status := range map[string]int{
+		"/remote.php/dav/uploads/user/id/1/2": http.StatusNotFound,
+		"/remote.php/dav/uploads/other/id":    http.StatusForbidden,
+		"/remote.php/dav/uploads/user/..":     http.StatusBadRequest,
+		"/remote.php/dav/uploads/user/a%20b":  http.StatusBadRequest,
+		"/remote.php/dav/uploads/user/id/a":   http.StatusBadRequest,
+	} {
+		_, code, err := connection.getChunkedUpload(p)
+		assert.Error(t, err, p)
+		assert.Equal(t, status, code, p)
+	}
+	upload, _, err := connection.getChunkedUpload("/remote.php/dav/uploads/user/id/.file")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "id", upload.transferID)
+		assert.Equal(t, filepath.Join(os.TempDir(), vfs.ChunkedUploadsDirName, "id", ".file"), upload.getFsPath())
+		assert.Equal(t, "/.sftpgo-uploads/id/.file", upload.getVirtualPath())
+	}
+	entries := []os.FileInfo{
+		vfs.NewFileInfo(vfs.ChunkedUploadsDirName, true, 0, time.Now(), false),
+		vfs.NewFileInfo("file", false, 0, time.Now(), false),
+	}
+	assert.Len(t, connection.User.FilterListDir(entries, "/dir"), 2)
+	assert.Len(t, connection.User.FilterListDir(entries, "/"), 1)
+	_, _, err = connection.GetFsAndResolvedPath("/.sftpgo-uploads/id")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	req, err := http.NewRequest("MKCOL", "http://localhost/remote.php/dav/uploads/user/id", nil)
+	assert.NoError(t, err)
+	_, err = connection.checkChunkedUploadDestination(req, "")
+	assert.ErrorIs(t, err, errInvalidDestination)
+	assert.Equal(t, http.StatusBadRequest, connection.getChunkedUploadStatus(err))
+
+	for hdr, expected := range map[string]string{
+		"":                                "",
+		"/dav/file":                       "/file",
+		"http://localhost/dav/dir/file":   "/dir/file",
+		"http://otherhost/dav/file":       "error",
+		"/file":                           "error",
+		"/dav/":                           "error",
+		"/dav/remote.php/dav/uploads/a/b": "error",
+	} {
+		req, err := http.NewRequest("MOVE", "http://localhost/dav/remote.php/dav/uploads/user/id/.file", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Destination", hdr)
+		target, err := getChunkedUploadDestination(req, "/dav")
+		if expected == "error" {
+			assert.ErrorIs(t, err, errInvalidDestination, hdr)
+		} else {
+			assert.NoError(t, err, hdr)
+			assert.Equal(t, expected, target, hdr)
+		}
+	}
+}
+
+func TestWriteChunkedUpload(t *testing.T) {
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			HomeDir: filepath.Join(os.TempDir(), "chunkedupload"),
+		},
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	err := os.MkdirAll(user.HomeDir, os.ModePerm)
+	assert.NoError(t, err)
+	defer os.RemoveAll(user.HomeDir)
+
+	osFs := vfs.NewOsFs("connID", user.HomeDir, "")
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(osFs.ConnectionID(), common.ProtocolWebDAV, "", "", user),
+	}
+	var chunks []string
+	for idx, data := range []string{"chunk1", "chunk2"} {
+		p := filepath.Join(user.HomeDir, fmt.Sprintf("%d", idx+1))
+		err = os.WriteFile(p, []byte(data), os.ModePerm)
+		assert.NoError(t, err)
+		chunks = append(chunks, p)
+	}
+	target := filepath.Join(user.HomeDir, "target")
+	testCases := []struct {
+		composeErr error
+		canCompose bool
+		composed   bool
+	}{
+		{composeErr: nil, canCompose: true, composed: true},
+		{composeErr: vfs.ErrVfsUnsupported, canCompose: true, composed: false},
+		{composeErr: nil, canCompose: false, composed: false},
+	}
+	for _, tc := range testCases {
+		fs := &MockComposerFs{Fs: osFs, err: tc.composeErr}
+		composed, err := connection.writeChunkedUpload(fs, target, "/target", osFs, chunks, 12, tc.canCompose)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.composed, composed)
+		data, err := os.ReadFile(target)
+		assert.NoError(t, err)
+		assert.Equal(t, "chunk1chunk2", string(data))
+		err = os.Remove(target)
+		assert.NoError(t, err)
+	}
+	fs := &MockComposerFs{Fs: osFs, err: os.ErrPermission}
+	_, err = connection.writeChunkedUpload(fs, target, "/target", osFs, chunks, 12, true)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	// missing chunk
+	_, err = connection.writeChunkedUpload(osFs, target, "/target", osFs, append(chunks, filepath.Join(user.HomeDir, "3")),
+		12, true)
+	assert.Error(t, err)
+	assert.Len(t, connection.GetTransfers(), 0)
+}
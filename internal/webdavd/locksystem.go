@@ -0,0 +1,247 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// locks confirmed for an in progress request cannot be confirmed again, refreshed
+// or unlocked until they are released. Held locks are tracked per instance
+var heldLocks = struct {
+	sync.Mutex
+	tokens map[string]bool
+}{
+	tokens: make(map[string]bool),
+}
+
+// lockSystem implements webdav.LockSystem for a user, the locks are stored
+// using the common WebDAV locks store, so they are shared between all the
+// connections of the same user and, if the data provider is shared, between
+// multiple SFTPGo instances.
+// If a connection is set, the lock roots are resolved to filesystem paths, so
+// the locks also apply to the other users accessing the same resources
+type lockSystem struct {
+	username string
+	conn     *Connection
+}
+
+func newLockSystem(username string) webdav.LockSystem {
+	return &lockSystem{
+		username: username,
+	}
+}
+
+// getConnectionLockSystem returns a copy of the specified lock system bound
+// to the given connection
+func getConnectionLockSystem(ls webdav.LockSystem, conn *Connection) webdav.LockSystem {
+	if l, ok := ls.(*lockSystem); ok {
+		return &lockSystem{
+			username: l.username,
+			conn:     conn,
+		}
+	}
+	return ls
+}
+
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+
+	var l0, l1 *common.WebDAVLock
+	if name0 != "" {
+		if l0 = ls.lookup(slashClean(name0), conditions...); l0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if l1 = ls.lookup(slashClean(name1), conditions...); l1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	// don't hold the same lock twice
+	if l0 != nil && l1 != nil && l0.Token == l1.Token {
+		l1 = nil
+	}
+	var tokens []string
+	for _, l := range []*common.WebDAVLock{l0, l1} {
+		if l != nil {
+			heldLocks.tokens[l.Token] = true
+			tokens = append(tokens, l.Token)
+		}
+	}
+
+	return func() {
+		heldLocks.Lock()
+		defer heldLocks.Unlock()
+
+		for _, token := range tokens {
+			delete(heldLocks.tokens, token)
+		}
+	}, nil
+}
+
+// lookup returns the lock for the named resource, provided that it matches at
+// least one of the given conditions and that it isn't held by another request.
+// The lock may be a parent of the named resource, if it is an infinite depth lock.
+// It must be called while holding the heldLocks mutex
+func (ls *lockSystem) lookup(name string, conditions ...webdav.Condition) *common.WebDAVLock {
+	for _, c := range conditions {
+		if c.Token == "" || heldLocks.tokens[c.Token] {
+			continue
+		}
+		lock, err := ls.getLock(c.Token)
+		if err != nil {
+			continue
+		}
+		if lock.Covers(name) {
+			return lock
+		}
+	}
+	return nil
+}
+
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	lock := common.NewWebDAVLock(ls.username, slashClean(details.Root), details.ZeroDepth, details.OwnerXML,
+		details.Duration)
+	if ls.conn != nil {
+		if fs, fsPath, err := ls.conn.GetFsAndResolvedPath(lock.Root); err == nil {
+			lock.SetFsPath(fs.Name(), fsPath)
+		}
+	}
+	if err := common.AddWebDAVLock(lock); err != nil {
+		if errors.Is(err, common.ErrWebDAVLocked) {
+			return "", webdav.ErrLocked
+		}
+		return "", err
+	}
+	return lock.Token, nil
+}
+
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	if err := ls.checkUnheldLock(token); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	lock, err := common.RefreshWebDAVLock(token, duration)
+	if err != nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	return webdav.LockDetails{
+		Root:      lock.Root,
+		Duration:  lock.GetDuration().Round(time.Second),
+		OwnerXML:  lock.OwnerXML,
+		ZeroDepth: lock.ZeroDepth,
+	}, nil
+}
+
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	if err := ls.checkUnheldLock(token); err != nil {
+		return err
+	}
+	if err := common.RemoveWebDAVLock(token); err != nil {
+		return webdav.ErrNoSuchLock
+	}
+	return nil
+}
+
+// GetByName returns the lock for the named resource or for its nearest locked parent
+func (ls *lockSystem) GetByName(name string) (string, time.Time, webdav.LockDetails, error) {
+	locks, err := common.GetWebDAVLocks(ls.username)
+	if err != nil {
+		return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	lock := findLockByName(locks, name)
+	if lock == nil {
+		return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	return lock.Token, util.GetTimeFromMsecSinceEpoch(lock.ExpiresAt), webdav.LockDetails{
+		Root:      lock.Root,
+		Duration:  lock.GetDuration(),
+		OwnerXML:  lock.OwnerXML,
+		ZeroDepth: lock.ZeroDepth,
+	}, nil
+}
+
+// Delete implements webdav.LockDeleter, it removes all the locks rooted at
+// the named resource or at its contents
+func (ls *lockSystem) Delete(now time.Time, name string) error {
+	locks, err := common.GetWebDAVLocks(ls.username)
+	if err != nil {
+		return err
+	}
+	name = slashClean(name)
+	for _, lock := range locks {
+		if lock.Root == name || name == "/" || strings.HasPrefix(lock.Root, name+"/") {
+			if err := common.RemoveWebDAVLock(lock.Token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ls *lockSystem) checkUnheldLock(token string) error {
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+
+	if _, err := ls.getLock(token); err != nil {
+		return err
+	}
+	if heldLocks.tokens[token] {
+		return webdav.ErrLocked
+	}
+	return nil
+}
+
+func (ls *lockSystem) getLock(token string) (*common.WebDAVLock, error) {
+	lock, err := common.GetWebDAVLock(token)
+	if err != nil || lock.Username != ls.username {
+		return nil, webdav.ErrNoSuchLock
+	}
+	return lock, nil
+}
+
+// findLockByName returns the lock rooted at the named resource or at its
+// nearest locked parent, nil is returned if there is no such lock
+func findLockByName(locks []common.WebDAVLock, name string) *common.WebDAVLock {
+	name = slashClean(name)
+	for {
+		for idx := range locks {
+			if locks[idx].Root == name {
+				return &locks[idx]
+			}
+		}
+		if name == "/" {
+			return nil
+		}
+		name = path.Dir(name)
+	}
+}
+
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
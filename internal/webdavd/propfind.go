@@ -0,0 +1,362 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+const (
+	methodPropfind = "PROPFIND"
+	supportedLock  = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype></D:lockentry>`
+)
+
+var (
+	errInvalidPropfind = errors.New("invalid propfind")
+	errInvalidDepth    = errors.New("invalid depth")
+	// the live properties returned for allprop and propname requests, in order
+	propfindLiveProps = []string{"resourcetype", "displayname", "getcontentlength", "getlastmodified",
+		"getcontenttype", "getetag", "lockdiscovery", "supportedlock"}
+)
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	Allprop  *struct{} `xml:"DAV: allprop"`
+	Propname *struct{} `xml:"DAV: propname"`
+	Prop     propNames `xml:"DAV: prop"`
+	Include  propNames `xml:"DAV: include"`
+}
+
+// readPropfindRequest parses a PROPFIND body, an empty body means allprop
+func readPropfindRequest(r *http.Request) (propfindRequest, error) {
+	var req propfindRequest
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxXMLBodySize))
+	if err != nil {
+		return req, err
+	}
+	if len(body) == 0 {
+		req.Allprop = new(struct{})
+		return req, nil
+	}
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil {
+		return req, fmt.Errorf("%w: %v", errInvalidXMLBody, err)
+	}
+	switch {
+	case req.Allprop == nil && req.Include != nil:
+		return req, errInvalidPropfind
+	case req.Allprop != nil && (req.Prop != nil || req.Propname != nil):
+		return req, errInvalidPropfind
+	case req.Prop != nil && req.Propname != nil:
+		return req, errInvalidPropfind
+	case req.Propname == nil && req.Allprop == nil && req.Prop == nil:
+		return req, errInvalidPropfind
+	}
+	return req, nil
+}
+
+// needsDeadProps returns true if the dead properties are required to reply to
+// the request. Dead properties cannot be in the DAV: namespace
+func (req *propfindRequest) needsDeadProps() bool {
+	if req.Prop == nil {
+		return true
+	}
+	for _, name := range req.Prop {
+		if name.Space != "DAV:" {
+			return true
+		}
+	}
+	return false
+}
+
+// needsLockDiscovery returns true if the lockdiscovery property value is
+// required to reply to the request
+func (req *propfindRequest) needsLockDiscovery() bool {
+	if req.Allprop != nil {
+		return true
+	}
+	for _, name := range req.Prop {
+		if name.Space == "DAV:" && name.Local == "lockdiscovery" {
+			return true
+		}
+	}
+	return false
+}
+
+// propfindResource defines a resource to report in a PROPFIND response.
+// info is the os.FileInfo as returned by Stat or Readdir, it is used to
+// get the content type the same way as the webdav handler does
+type propfindResource struct {
+	info     os.FileInfo
+	fileInfo *webDavFileInfo
+}
+
+// handlePropfind handles PROPFIND requests. It behaves like the webdav
+// handler but the stored dead properties are returned too
+func handlePropfind(ctx context.Context, w http.ResponseWriter, r *http.Request, c *Connection, prefix string) (int, error) {
+	reqPath, ok := getRequestPath(r.URL.Path, prefix)
+	if !ok {
+		return writeStatus(w, http.StatusNotFound), errors.New("prefix mismatch")
+	}
+	info, err := c.Stat(ctx, reqPath)
+	if err != nil {
+		switch {
+		case c.IsNotExistError(err):
+			return writeStatus(w, http.StatusNotFound), err
+		case errors.Is(err, os.ErrPermission):
+			return writeStatus(w, http.StatusForbidden), err
+		default:
+			return writeStatus(w, http.StatusMethodNotAllowed), err
+		}
+	}
+	depth := "1"
+	if hdr := r.Header.Get("Depth"); hdr != "" {
+		depth = hdr
+	}
+	switch depth {
+	case "0", "1":
+	case "infinity":
+		return writeStatus(w, http.StatusForbidden), errors.New(`PROPFIND requests with a Depth of "infinity" are not allowed`)
+	default:
+		return writeStatus(w, http.StatusBadRequest), errInvalidDepth
+	}
+	req, err := readPropfindRequest(r)
+	if err != nil {
+		return writeStatus(w, http.StatusBadRequest), err
+	}
+	fi, err := c.getFileInfo(reqPath, info)
+	if err != nil {
+		return writeStatus(w, getStatusForError(c, err)), err
+	}
+	resources := []propfindResource{{info: info, fileInfo: fi}}
+	if info.IsDir() && depth == "1" {
+		members, err := c.getPropfindMembers(ctx, reqPath)
+		if err != nil {
+			return writeStatus(w, http.StatusInternalServerError), err
+		}
+		resources = append(resources, members...)
+	}
+	// the locks are read once and not for each reported resource
+	var locks []common.WebDAVLock
+	if req.needsLockDiscovery() {
+		locks, err = common.GetWebDAVLocks(c.User.Username)
+		if err != nil {
+			c.Log(logger.LevelWarn, "unable to get WebDAV locks: %v", err)
+		}
+	}
+	ms := &multistatus{}
+	for _, res := range resources {
+		ms.Responses = append(ms.Responses, msResponse{
+			Href:      getHref(prefix, res.fileInfo.virtualPath, res.fileInfo.IsDir()),
+			Propstats: c.getPropfindPropstats(ctx, &req, res, locks),
+		})
+	}
+	return webdav.StatusMulti, writeMultistatus(w, ms)
+}
+
+// getPropfindMembers returns the members of the specified collection. Permission
+// and not found errors are ignored, the collection contents are not reported
+func (c *Connection) getPropfindMembers(ctx context.Context, virtualPath string) ([]propfindResource, error) {
+	f, err := c.OpenFile(ctx, virtualPath, os.O_RDONLY, 0)
+	if err == nil {
+		var entries []os.FileInfo
+		entries, err = f.Readdir(0)
+		f.Close()
+		if err == nil {
+			resources := make([]propfindResource, 0, len(entries))
+			for _, entry := range entries {
+				fi, err := c.getFileInfo(path.Join(virtualPath, entry.Name()), entry)
+				if err != nil {
+					c.Log(logger.LevelDebug, "unable to get info for %q, skipping: %v", entry.Name(), err)
+					continue
+				}
+				resources = append(resources, propfindResource{info: entry, fileInfo: fi})
+			}
+			return resources, nil
+		}
+	}
+	var pathErr *os.PathError
+	if errors.Is(err, os.ErrPermission) || c.IsNotExistError(err) || errors.As(err, &pathErr) {
+		c.Log(logger.LevelDebug, "unable to list %q, skipping contents: %v", virtualPath, err)
+		return nil, nil
+	}
+	return nil, err
+}
+
+func (c *Connection) getPropfindPropstats(ctx context.Context, req *propfindRequest, res propfindResource,
+	locks []common.WebDAVLock,
+) []msPropstat {
+	var deadProps []webdav.Property
+	if req.needsDeadProps() {
+		deadProps = c.getPropfindDeadProps(res.fileInfo.virtualPath)
+	}
+	var names []xml.Name
+	if req.Prop != nil {
+		names = req.Prop
+	} else {
+		for _, name := range propfindLiveProps {
+			names = append(names, xml.Name{Space: "DAV:", Local: name})
+		}
+		for _, p := range deadProps {
+			names = append(names, p.XMLName)
+		}
+	}
+	var found, notFound []msProperty
+	for _, name := range names {
+		if req.Propname != nil {
+			if _, ok := c.getPropfindPropValue(ctx, name, res, deadProps, locks, true); ok {
+				found = append(found, msProperty{XMLName: name})
+			}
+			continue
+		}
+		if val, ok := c.getPropfindPropValue(ctx, name, res, deadProps, locks, false); ok {
+			found = append(found, msProperty{XMLName: name, InnerXML: val})
+		} else if req.Prop != nil {
+			notFound = append(notFound, msProperty{XMLName: name})
+		}
+	}
+	var result []msPropstat
+	if len(found) > 0 || len(notFound) == 0 {
+		result = append(result, msPropstat{Props: found, Status: getStatusLine(http.StatusOK)})
+	}
+	if len(notFound) > 0 {
+		result = append(result, msPropstat{Props: notFound, Status: getStatusLine(http.StatusNotFound)})
+	}
+	return result
+}
+
+// getPropfindPropValue returns the value for the specified property, if nameOnly
+// is true the value is not computed and only its availability is reported
+func (c *Connection) getPropfindPropValue(ctx context.Context, name xml.Name, res propfindResource,
+	deadProps []webdav.Property, locks []common.WebDAVLock, nameOnly bool,
+) (string, bool) {
+	if name.Space == "DAV:" {
+		switch name.Local {
+		case "supportedlock":
+			return supportedLock, true
+		case "lockdiscovery":
+			if nameOnly {
+				return "", true
+			}
+			return getLockDiscovery(locks, res.fileInfo.virtualPath), true
+		case "getcontenttype":
+			if res.fileInfo.IsDir() {
+				return "", false
+			}
+			if nameOnly {
+				return "", true
+			}
+			return c.getPropfindContentType(ctx, res)
+		}
+		if val, ok := getLivePropValue(ctx, res.fileInfo, name.Local); ok {
+			return val, true
+		}
+	}
+	for _, p := range deadProps {
+		if p.XMLName == name {
+			return string(p.InnerXML), true
+		}
+	}
+	return "", false
+}
+
+// getPropfindContentType returns the content type the same way as the webdav
+// handler: if the content type cannot be detected from the file info, the file
+// is opened and the content type is guessed from its initial contents
+func (c *Connection) getPropfindContentType(ctx context.Context, res propfindResource) (string, bool) {
+	if ct, ok := res.info.(webdav.ContentTyper); ok {
+		ctype, err := ct.ContentType(ctx)
+		if err != webdav.ErrNotImplemented {
+			return escapeXMLText(ctype), err == nil
+		}
+	}
+	ctype := mime.TypeByExtension(path.Ext(res.fileInfo.virtualPath))
+	if ctype != "" {
+		return escapeXMLText(ctype), true
+	}
+	f, err := c.OpenFile(ctx, res.fileInfo.virtualPath, os.O_RDONLY, 0)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to open %q to detect the content type: %v", res.fileInfo.virtualPath, err)
+		return "", false
+	}
+	defer f.Close()
+
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		c.Log(logger.LevelDebug, "unable to read %q to detect the content type: %v", res.fileInfo.virtualPath, err)
+		return "", false
+	}
+	return escapeXMLText(http.DetectContentType(buf[:n])), true
+}
+
+// getPropfindDeadProps returns the dead properties for the specified path sorted by name
+func (c *Connection) getPropfindDeadProps(virtualPath string) []webdav.Property {
+	data, err := c.GetWebDAVDeadProps(virtualPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to get dead properties for %q: %v", virtualPath, err)
+		return nil
+	}
+	props, err := decodeDeadProps(data)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to decode dead properties for %q: %v", virtualPath, err)
+		return nil
+	}
+	result := make([]webdav.Property, 0, len(props))
+	for _, p := range props {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].XMLName.Space == result[j].XMLName.Space {
+			return result[i].XMLName.Local < result[j].XMLName.Local
+		}
+		return result[i].XMLName.Space < result[j].XMLName.Space
+	})
+	return result
+}
+
+// getLockDiscovery returns the lockdiscovery value for the named resource
+// using the specified locks
+func getLockDiscovery(locks []common.WebDAVLock, name string) string {
+	lock := findLockByName(locks, name)
+	if lock == nil {
+		return ""
+	}
+	depth := "infinity"
+	if lock.ZeroDepth {
+		depth = "0"
+	}
+	timeout := fmt.Sprintf("Second-%d", lock.GetDuration()/time.Second)
+	return fmt.Sprintf("<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>"+
+		"<D:depth>%s</D:depth><D:owner>%s</D:owner><D:timeout>%s</D:timeout>"+
+		"<D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock>",
+		depth, lock.OwnerXML, timeout, escapeXMLText(lock.Token), escapeXMLText(lock.Root))
+}
@@ -0,0 +1,462 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	methodReport   = "REPORT"
+	methodSearch   = "SEARCH"
+	syncLevelOne   = "1"
+	syncLevelInf   = "infinite"
+	maxXMLBodySize = 1048576
+)
+
+var (
+	errUnsupportedReport = errors.New("unsupported report")
+	errInvalidXMLBody    = errors.New("invalid XML body")
+	davLiveProps         = []string{"resourcetype", "displayname", "getcontentlength", "getlastmodified",
+		"getcontenttype", "getetag"}
+)
+
+// propNames is the list of the requested properties, it is used to parse
+// the DAV:prop elements
+type propNames []xml.Name
+
+func (pn *propNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch elem := t.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			*pn = append(*pn, elem.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type syncCollectionRequest struct {
+	XMLName   xml.Name  `xml:"DAV: sync-collection"`
+	SyncToken string    `xml:"DAV: sync-token"`
+	SyncLevel string    `xml:"DAV: sync-level"`
+	Prop      propNames `xml:"DAV: prop"`
+}
+
+type msProperty struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
+}
+
+type msPropstat struct {
+	Props  []msProperty
+	Status string
+}
+
+// MarshalXML writes the properties inside a DAV:prop element. Properties
+// in the DAV: namespace use the D prefix
+func (ps msPropstat) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	prop := xml.StartElement{Name: xml.Name{Local: "D:prop"}}
+	if err := e.EncodeToken(prop); err != nil {
+		return err
+	}
+	for _, p := range ps.Props {
+		if p.XMLName.Space == "DAV:" {
+			p.XMLName = xml.Name{Local: "D:" + p.XMLName.Local}
+		}
+		if err := e.Encode(p); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeToken(prop.End()); err != nil {
+		return err
+	}
+	if err := e.EncodeElement(ps.Status, xml.StartElement{Name: xml.Name{Local: "D:status"}}); err != nil {
+		return err
+	}
+	return e.EncodeToken(start.End())
+}
+
+type msResponse struct {
+	Href      string       `xml:"D:href"`
+	Propstats []msPropstat `xml:"D:propstat,omitempty"`
+	Status    string       `xml:"D:status,omitempty"`
+}
+
+type multistatus struct {
+	XMLName   xml.Name     `xml:"D:multistatus"`
+	XMLNS     string       `xml:"xmlns:D,attr"`
+	Responses []msResponse `xml:"D:response"`
+	SyncToken string       `xml:"D:sync-token,omitempty"`
+}
+
+func writeMultistatus(w http.ResponseWriter, ms *multistatus) error {
+	ms.XMLNS = "DAV:"
+	if ms.Responses == nil {
+		ms.Responses = []msResponse{}
+	}
+	data, err := xml.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(webdav.StatusMulti)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeDAVError writes an error response with the specified precondition
+func writeDAVError(w http.ResponseWriter, status int, condition string) int {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<D:error xmlns:D="DAV:"><D:%s/></D:error>`, xml.Header, condition) //nolint:errcheck
+	return status
+}
+
+func writeStatus(w http.ResponseWriter, status int) int {
+	w.WriteHeader(status)
+	w.Write([]byte(webdav.StatusText(status))) //nolint:errcheck
+	return status
+}
+
+func getStatusLine(status int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", status, webdav.StatusText(status))
+}
+
+func decodeXMLBody(r *http.Request, v any) error {
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidXMLBody, err)
+	}
+	return nil
+}
+
+// getRequestPath strips the binding prefix from the specified URL path
+func getRequestPath(urlPath, prefix string) (string, bool) {
+	if prefix == "" {
+		return util.CleanPath(urlPath), true
+	}
+	if p := strings.TrimPrefix(urlPath, prefix); len(p) < len(urlPath) {
+		return util.CleanPath(p), true
+	}
+	return "", false
+}
+
+func getHref(prefix, virtualPath string, isDir bool) string {
+	href := path.Join("/", prefix, virtualPath)
+	if href != "/" && isDir {
+		href += "/"
+	}
+	return (&url.URL{Path: href}).EscapedPath()
+}
+
+func escapeXMLText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s)) //nolint:errcheck
+	return b.String()
+}
+
+// getLivePropValue returns the value for the specified DAV: property, the
+// second returned value is false if the property is not available
+func getLivePropValue(ctx context.Context, info *webDavFileInfo, name string) (string, bool) {
+	switch name {
+	case "resourcetype":
+		if info.IsDir() {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "displayname":
+		if info.virtualPath == "/" {
+			return "", true
+		}
+		return escapeXMLText(path.Base(info.virtualPath)), true
+	case "getlastmodified":
+		return escapeXMLText(info.ModTime().UTC().Format(http.TimeFormat)), true
+	case "getcontentlength":
+		if info.IsDir() {
+			return "", false
+		}
+		return fmt.Sprintf("%d", info.Size()), true
+	case "getetag":
+		if info.IsDir() {
+			return "", false
+		}
+		return escapeXMLText(getETag(info.FileInfo)), true
+	case "getcontenttype":
+		if info.IsDir() {
+			return "", false
+		}
+		ctype, err := info.ContentType(ctx)
+		if err != nil || ctype == "" {
+			return "", false
+		}
+		return escapeXMLText(ctype), true
+	}
+	return "", false
+}
+
+// getPropstats returns the requested properties, if names is empty all the
+// supported live properties are returned
+func getPropstats(ctx context.Context, info *webDavFileInfo, names []xml.Name) []msPropstat {
+	var found, notFound []msProperty
+	allProps := len(names) == 0
+	if allProps {
+		for _, name := range davLiveProps {
+			names = append(names, xml.Name{Space: "DAV:", Local: name})
+		}
+	}
+	for _, name := range names {
+		if name.Space == "DAV:" {
+			if val, ok := getLivePropValue(ctx, info, name.Local); ok {
+				found = append(found, msProperty{XMLName: name, InnerXML: val})
+				continue
+			}
+		}
+		if !allProps {
+			notFound = append(notFound, msProperty{XMLName: name})
+		}
+	}
+	var result []msPropstat
+	if len(found) > 0 || len(notFound) == 0 {
+		result = append(result, msPropstat{Props: found, Status: getStatusLine(http.StatusOK)})
+	}
+	if len(notFound) > 0 {
+		result = append(result, msPropstat{Props: notFound, Status: getStatusLine(http.StatusNotFound)})
+	}
+	return result
+}
+
+// getFileInfo wraps the specified os.FileInfo, virtualPath is the virtual path
+// for the file
+func (c *Connection) getFileInfo(virtualPath string, info os.FileInfo) (*webDavFileInfo, error) {
+	if fi, ok := info.(*webDavFileInfo); ok {
+		return fi, nil
+	}
+	if fi, ok := info.(*eTagFileInfo); ok {
+		info = fi.FileInfo
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	return &webDavFileInfo{
+		FileInfo:    info,
+		Fs:          fs,
+		virtualPath: virtualPath,
+		fsPath:      fsPath,
+	}, nil
+}
+
+// walkCollection calls walkFn for each member of the specified collection, if
+// recursive is true the sub collections are visited too. Paths that cannot be
+// listed are skipped
+func (c *Connection) walkCollection(virtualPath string, recursive bool, walkFn func(*webDavFileInfo) error) error {
+	dirs := []string{virtualPath}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		entries, err := c.ListDir(dir)
+		if err != nil {
+			if dir == virtualPath {
+				return err
+			}
+			c.Log(logger.LevelDebug, "unable to list %q, skipping: %v", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			p := path.Join(dir, entry.Name())
+			info, err := c.getFileInfo(p, entry)
+			if err != nil {
+				c.Log(logger.LevelDebug, "unable to get info for %q, skipping: %v", p, err)
+				continue
+			}
+			if err := walkFn(info); err != nil {
+				return err
+			}
+			if recursive && entry.IsDir() {
+				dirs = append(dirs, p)
+			}
+		}
+	}
+	return nil
+}
+
+// handleReport handles the RFC 6578 sync-collection report
+func handleReport(ctx context.Context, w http.ResponseWriter, r *http.Request, c *Connection, prefix string) (int, error) {
+	reqPath, ok := getRequestPath(r.URL.Path, prefix)
+	if !ok {
+		return writeStatus(w, http.StatusNotFound), errors.New("prefix mismatch")
+	}
+	var req syncCollectionRequest
+	if err := decodeXMLBody(r, &req); err != nil {
+		if strings.Contains(err.Error(), "expected element type <sync-collection>") {
+			return writeDAVError(w, http.StatusForbidden, "supported-report"), errUnsupportedReport
+		}
+		return writeStatus(w, http.StatusBadRequest), err
+	}
+	req.SyncLevel = strings.TrimSpace(req.SyncLevel)
+	if req.SyncLevel != syncLevelOne && req.SyncLevel != syncLevelInf {
+		return writeStatus(w, http.StatusBadRequest), fmt.Errorf("invalid sync level %q", req.SyncLevel)
+	}
+	info, err := c.Stat(ctx, reqPath)
+	if err != nil {
+		return writeStatus(w, getStatusForError(c, err)), err
+	}
+	if !info.IsDir() {
+		return writeDAVError(w, http.StatusForbidden, "supported-report"), errUnsupportedReport
+	}
+	isRecursive := req.SyncLevel == syncLevelInf
+	ms := &multistatus{}
+	syncToken := strings.TrimSpace(req.SyncToken)
+	if syncToken == "" {
+		// get the token before listing the contents, any change done while
+		// listing will be reported in the next request
+		ms.SyncToken = common.GetSyncToken(c.User.Username)
+		err = c.walkCollection(reqPath, isRecursive, func(fi *webDavFileInfo) error {
+			ms.Responses = append(ms.Responses, msResponse{
+				Href:      getHref(prefix, fi.virtualPath, fi.IsDir()),
+				Propstats: getPropstats(ctx, fi, req.Prop),
+			})
+			return nil
+		})
+		if err != nil {
+			return writeStatus(w, getStatusForError(c, err)), err
+		}
+		return webdav.StatusMulti, writeMultistatus(w, ms)
+	}
+
+	changes, newToken, err := common.GetChangesSince(c.User.Username, syncToken)
+	if err != nil {
+		return writeDAVError(w, http.StatusForbidden, "valid-sync-token"), err
+	}
+	ms.SyncToken = newToken
+	changed := getChangedMembers(changes, reqPath, isRecursive)
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	reported := make(map[string]bool)
+	addResponse := func(fi *webDavFileInfo) {
+		if reported[fi.virtualPath] {
+			return
+		}
+		reported[fi.virtualPath] = true
+		ms.Responses = append(ms.Responses, msResponse{
+			Href:      getHref(prefix, fi.virtualPath, fi.IsDir()),
+			Propstats: getPropstats(ctx, fi, req.Prop),
+		})
+	}
+	for _, p := range paths {
+		stat, err := c.Stat(ctx, p)
+		if err != nil {
+			if c.IsNotExistError(err) {
+				ms.Responses = append(ms.Responses, msResponse{
+					Href:   getHref(prefix, p, false),
+					Status: getStatusLine(http.StatusNotFound),
+				})
+			} else {
+				c.Log(logger.LevelDebug, "unable to stat changed path %q, skipping: %v", p, err)
+			}
+			continue
+		}
+		fi, err := c.getFileInfo(p, stat)
+		if err != nil {
+			continue
+		}
+		addResponse(fi)
+		if changed[p] && fi.IsDir() {
+			c.walkCollection(p, true, func(fi *webDavFileInfo) error { //nolint:errcheck
+				addResponse(fi)
+				return nil
+			})
+		}
+	}
+	return webdav.StatusMulti, writeMultistatus(w, ms)
+}
+
+// getChangedMembers returns the members of the specified collection affected
+// by the given changes. The returned map values are true if the member contents
+// must be reported too. If isRecursive is false, changes to nested paths are
+// reported as changes of the collection member containing them
+func getChangedMembers(changes []common.FsChange, collection string, isRecursive bool) map[string]bool {
+	result := make(map[string]bool)
+	for _, change := range changes {
+		p := change.VirtualPath
+		if !isCollectionMember(p, collection) {
+			continue
+		}
+		recursive := change.Recursive && !change.Deleted && isRecursive
+		if !isRecursive {
+			rel := strings.TrimPrefix(strings.TrimPrefix(p, collection), "/")
+			if first, _, found := strings.Cut(rel, "/"); found {
+				p = path.Join(collection, first)
+			}
+		}
+		if recursive {
+			result[p] = true
+		} else if _, ok := result[p]; !ok {
+			result[p] = false
+		}
+	}
+	return result
+}
+
+// isCollectionMember returns true if p is inside the specified collection
+func isCollectionMember(p, collection string) bool {
+	if p == collection {
+		return false
+	}
+	return collection == "/" || strings.HasPrefix(p, collection+"/")
+}
+
+func getStatusForError(c *Connection, err error) int {
+	switch {
+	case c.IsNotExistError(err):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,583 @@
+// Copyright (C) 2019-2022  Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdavd
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/webdav"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	daslBasicSearch = "<DAV:basicsearch>"
+)
+
+var (
+	errUnsupportedSearch = errors.New("unsupported search")
+)
+
+// xmlNode is a generic XML element, it is used to parse the search requests
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+func (n *xmlNode) isDAV(local string) bool {
+	return n.XMLName.Space == "DAV:" && n.XMLName.Local == local
+}
+
+func (n *xmlNode) child(local string) *xmlNode {
+	for idx := range n.Children {
+		if n.Children[idx].isDAV(local) {
+			return &n.Children[idx]
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) childrenNamed(local string) []*xmlNode {
+	var result []*xmlNode
+	for idx := range n.Children {
+		if n.Children[idx].isDAV(local) {
+			result = append(result, &n.Children[idx])
+		}
+	}
+	return result
+}
+
+func (n *xmlNode) attr(local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// propNames returns the names of the properties inside the DAV:prop child
+func (n *xmlNode) propNames() []xml.Name {
+	prop := n.child("prop")
+	if prop == nil {
+		return nil
+	}
+	names := make([]xml.Name, 0, len(prop.Children))
+	for _, c := range prop.Children {
+		names = append(names, c.XMLName)
+	}
+	return names
+}
+
+type searchScope struct {
+	virtualPath string
+	depth       string
+}
+
+type searchOrder struct {
+	prop       xml.Name
+	descending bool
+}
+
+// searchExpr evaluates a DAV:where condition for the specified file
+type searchExpr func(ctx context.Context, fi *webDavFileInfo) bool
+
+type basicSearch struct {
+	props   []xml.Name
+	scopes  []searchScope
+	where   searchExpr
+	orderBy []searchOrder
+	limit   int
+}
+
+// parseBasicSearch parses an RFC 5323 DAV:basicsearch query. Only the
+// searchable live properties are supported in the conditions
+func parseBasicSearch(root *xmlNode, reqPath, prefix string) (*basicSearch, error) {
+	if !root.isDAV("searchrequest") {
+		return nil, fmt.Errorf("%w: unexpected root element %q", errUnsupportedSearch, root.XMLName.Local)
+	}
+	query := root.child("basicsearch")
+	if query == nil {
+		return nil, fmt.Errorf("%w: only basicsearch is supported", errUnsupportedSearch)
+	}
+	search := &basicSearch{}
+	if sel := query.child("select"); sel != nil {
+		search.props = sel.propNames()
+	} else {
+		return nil, fmt.Errorf("%w: select is required", errInvalidXMLBody)
+	}
+	from := query.child("from")
+	if from == nil {
+		return nil, fmt.Errorf("%w: from is required", errInvalidXMLBody)
+	}
+	for _, scope := range from.childrenNamed("scope") {
+		s, err := parseSearchScope(scope, reqPath, prefix)
+		if err != nil {
+			return nil, err
+		}
+		search.scopes = append(search.scopes, s)
+	}
+	if len(search.scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least a scope is required", errInvalidXMLBody)
+	}
+	search.where = func(_ context.Context, _ *webDavFileInfo) bool {
+		return true
+	}
+	if where := query.child("where"); where != nil {
+		if len(where.Children) != 1 {
+			return nil, fmt.Errorf("%w: where must contain a single condition", errInvalidXMLBody)
+		}
+		expr, err := parseSearchExpr(&where.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		search.where = expr
+	}
+	if orderBy := query.child("orderby"); orderBy != nil {
+		for _, order := range orderBy.childrenNamed("order") {
+			names := order.propNames()
+			if len(names) != 1 {
+				return nil, fmt.Errorf("%w: order must reference a single property", errInvalidXMLBody)
+			}
+			search.orderBy = append(search.orderBy, searchOrder{
+				prop:       names[0],
+				descending: order.child("descending") != nil,
+			})
+		}
+	}
+	if limit := query.child("limit"); limit != nil {
+		if nResults := limit.child("nresults"); nResults != nil {
+			val, err := strconv.Atoi(strings.TrimSpace(nResults.Text))
+			if err != nil || val <= 0 {
+				return nil, fmt.Errorf("%w: invalid nresults %q", errInvalidXMLBody, nResults.Text)
+			}
+			search.limit = val
+		}
+	}
+	return search, nil
+}
+
+func parseSearchScope(scope *xmlNode, reqPath, prefix string) (searchScope, error) {
+	result := searchScope{
+		depth: "infinity",
+	}
+	href := scope.child("href")
+	if href == nil {
+		return result, fmt.Errorf("%w: scope href is required", errInvalidXMLBody)
+	}
+	u, err := url.Parse(strings.TrimSpace(href.Text))
+	if err != nil {
+		return result, fmt.Errorf("%w: invalid scope href %q", errInvalidXMLBody, href.Text)
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		p, ok := getRequestPath(u.Path, prefix)
+		if !ok {
+			return result, fmt.Errorf("%w: scope href %q outside prefix", errInvalidXMLBody, u.Path)
+		}
+		result.virtualPath = p
+	} else {
+		result.virtualPath = util.CleanPath(path.Join(reqPath, u.Path))
+	}
+	if depth := scope.child("depth"); depth != nil {
+		result.depth = strings.TrimSpace(depth.Text)
+	}
+	switch result.depth {
+	case "0", "1", "infinity":
+	default:
+		return result, fmt.Errorf("%w: invalid scope depth %q", errInvalidXMLBody, result.depth)
+	}
+	return result, nil
+}
+
+func parseSearchExpr(n *xmlNode) (searchExpr, error) {
+	if n.XMLName.Space != "DAV:" {
+		return nil, fmt.Errorf("%w: unsupported operator %q", errUnsupportedSearch, n.XMLName.Local)
+	}
+	switch n.XMLName.Local {
+	case "and", "or":
+		var exprs []searchExpr
+		for idx := range n.Children {
+			expr, err := parseSearchExpr(&n.Children[idx])
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+		}
+		isAnd := n.XMLName.Local == "and"
+		return func(ctx context.Context, fi *webDavFileInfo) bool {
+			for _, expr := range exprs {
+				if expr(ctx, fi) != isAnd {
+					return !isAnd
+				}
+			}
+			return isAnd
+		}, nil
+	case "not":
+		if len(n.Children) != 1 {
+			return nil, fmt.Errorf("%w: not must contain a single condition", errInvalidXMLBody)
+		}
+		expr, err := parseSearchExpr(&n.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, fi *webDavFileInfo) bool {
+			return !expr(ctx, fi)
+		}, nil
+	case "is-collection":
+		return func(_ context.Context, fi *webDavFileInfo) bool {
+			return fi.IsDir()
+		}, nil
+	case "is-defined":
+		name, err := getSearchExprProp(n)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, fi *webDavFileInfo) bool {
+			_, ok := getSearchPropValue(ctx, fi, name)
+			return ok
+		}, nil
+	case "eq", "lt", "lte", "gt", "gte":
+		return parseCompareExpr(n)
+	case "like":
+		return parseLikeExpr(n)
+	}
+	return nil, fmt.Errorf("%w: unsupported operator %q", errUnsupportedSearch, n.XMLName.Local)
+}
+
+func getSearchExprProp(n *xmlNode) (xml.Name, error) {
+	names := n.propNames()
+	if len(names) != 1 {
+		return xml.Name{}, fmt.Errorf("%w: %q must reference a single property", errInvalidXMLBody, n.XMLName.Local)
+	}
+	if names[0].Space != "DAV:" || !util.Contains(davLiveProps, names[0].Local) {
+		return xml.Name{}, fmt.Errorf("%w: property %q is not searchable", errUnsupportedSearch, names[0].Local)
+	}
+	return names[0], nil
+}
+
+func getSearchLiteral(n *xmlNode) (string, error) {
+	literal := n.child("literal")
+	if literal == nil {
+		literal = n.child("typed-literal")
+	}
+	if literal == nil {
+		return "", fmt.Errorf("%w: %q requires a literal", errInvalidXMLBody, n.XMLName.Local)
+	}
+	return literal.Text, nil
+}
+
+func isCaseless(n *xmlNode) bool {
+	return n.attr("caseless") != "no"
+}
+
+func parseCompareExpr(n *xmlNode) (searchExpr, error) {
+	name, err := getSearchExprProp(n)
+	if err != nil {
+		return nil, err
+	}
+	literal, err := getSearchLiteral(n)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	switch name.Local {
+	case "getcontentlength":
+		size, err := strconv.ParseInt(strings.TrimSpace(literal), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid size %q", errInvalidXMLBody, literal)
+		}
+		value = size
+	case "getlastmodified":
+		t, err := parseSearchTime(literal)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid date %q", errInvalidXMLBody, literal)
+		}
+		value = t
+	case "resourcetype":
+		return nil, fmt.Errorf("%w: use is-collection to search by resource type", errUnsupportedSearch)
+	default:
+		if isCaseless(n) {
+			literal = strings.ToLower(literal)
+		}
+		value = literal
+	}
+	caseless := isCaseless(n)
+	op := n.XMLName.Local
+	return func(ctx context.Context, fi *webDavFileInfo) bool {
+		val, ok := getSearchPropValue(ctx, fi, name)
+		if !ok {
+			return false
+		}
+		if s, ok := val.(string); ok && caseless {
+			val = strings.ToLower(s)
+		}
+		res := compareSearchValues(val, value)
+		switch op {
+		case "eq":
+			return res == 0
+		case "lt":
+			return res < 0
+		case "lte":
+			return res <= 0
+		case "gt":
+			return res > 0
+		default:
+			return res >= 0
+		}
+	}, nil
+}
+
+func parseLikeExpr(n *xmlNode) (searchExpr, error) {
+	name, err := getSearchExprProp(n)
+	if err != nil {
+		return nil, err
+	}
+	literal, err := getSearchLiteral(n)
+	if err != nil {
+		return nil, err
+	}
+	re, err := likeToRegexp(literal, isCaseless(n))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid like pattern %q", errInvalidXMLBody, literal)
+	}
+	return func(ctx context.Context, fi *webDavFileInfo) bool {
+		val, ok := getSearchPropValue(ctx, fi, name)
+		if !ok {
+			return false
+		}
+		return re.MatchString(fmt.Sprintf("%v", val))
+	}, nil
+}
+
+// likeToRegexp converts a DAV:like pattern, "%" matches any sequence of
+// characters, "_" a single character and "\" escapes the next one
+func likeToRegexp(pattern string, caseless bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if caseless {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		if escaped {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+			continue
+		}
+		switch r {
+		case '\\':
+			escaped = true
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func parseSearchTime(val string) (time.Time, error) {
+	val = strings.TrimSpace(val)
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	return http.ParseTime(val)
+}
+
+// getSearchPropValue returns the value of a searchable property as int64,
+// time.Time or string
+func getSearchPropValue(ctx context.Context, fi *webDavFileInfo, name xml.Name) (any, bool) {
+	switch name.Local {
+	case "getcontentlength":
+		if fi.IsDir() {
+			return nil, false
+		}
+		return fi.Size(), true
+	case "getlastmodified":
+		return fi.ModTime(), true
+	case "displayname":
+		return path.Base(fi.virtualPath), true
+	case "getetag":
+		if fi.IsDir() {
+			return nil, false
+		}
+		return getETag(fi.FileInfo), true
+	case "getcontenttype":
+		if fi.IsDir() {
+			return nil, false
+		}
+		ctype, err := fi.ContentType(ctx)
+		if err != nil || ctype == "" {
+			return nil, false
+		}
+		return ctype, true
+	case "resourcetype":
+		if fi.IsDir() {
+			return "collection", true
+		}
+		return "", true
+	}
+	return nil, false
+}
+
+func compareSearchValues(a, b any) int {
+	switch v := a.(type) {
+	case int64:
+		other, ok := b.(int64)
+		if !ok {
+			return -1
+		}
+		switch {
+		case v < other:
+			return -1
+		case v > other:
+			return 1
+		}
+		return 0
+	case time.Time:
+		other, ok := b.(time.Time)
+		if !ok {
+			return -1
+		}
+		switch {
+		case v.Before(other):
+			return -1
+		case v.After(other):
+			return 1
+		}
+		return 0
+	case string:
+		other, ok := b.(string)
+		if !ok {
+			return -1
+		}
+		return strings.Compare(v, other)
+	}
+	return -1
+}
+
+func (s *basicSearch) sortResults(ctx context.Context, results []*webDavFileInfo) {
+	if len(s.orderBy) == 0 {
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].virtualPath < results[j].virtualPath
+		})
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		for _, order := range s.orderBy {
+			a, okA := getSearchPropValue(ctx, results[i], order.prop)
+			b, okB := getSearchPropValue(ctx, results[j], order.prop)
+			if okA != okB {
+				// undefined values are sorted last
+				return okA
+			}
+			if !okA {
+				continue
+			}
+			res := compareSearchValues(a, b)
+			if res == 0 {
+				continue
+			}
+			if order.descending {
+				return res > 0
+			}
+			return res < 0
+		}
+		return false
+	})
+}
+
+// handleSearch handles RFC 5323 SEARCH requests using the DAV:basicsearch grammar
+func handleSearch(ctx context.Context, w http.ResponseWriter, r *http.Request, c *Connection, prefix string) (int, error) {
+	reqPath, ok := getRequestPath(r.URL.Path, prefix)
+	if !ok {
+		return writeStatus(w, http.StatusNotFound), errors.New("prefix mismatch")
+	}
+	var root xmlNode
+	if err := decodeXMLBody(r, &root); err != nil {
+		return writeStatus(w, http.StatusBadRequest), err
+	}
+	search, err := parseBasicSearch(&root, reqPath, prefix)
+	if err != nil {
+		if errors.Is(err, errUnsupportedSearch) {
+			return writeDAVError(w, http.StatusUnprocessableEntity, "search-grammar-supported"), err
+		}
+		return writeStatus(w, http.StatusBadRequest), err
+	}
+	var results []*webDavFileInfo
+	found := make(map[string]bool)
+	addResult := func(fi *webDavFileInfo) error {
+		if !found[fi.virtualPath] && search.where(ctx, fi) {
+			found[fi.virtualPath] = true
+			results = append(results, fi)
+		}
+		return nil
+	}
+	for _, scope := range search.scopes {
+		info, err := c.Stat(ctx, scope.virtualPath)
+		if err != nil {
+			return writeStatus(w, getStatusForError(c, err)), err
+		}
+		fi, err := c.getFileInfo(scope.virtualPath, info)
+		if err != nil {
+			return writeStatus(w, getStatusForError(c, err)), err
+		}
+		addResult(fi) //nolint:errcheck
+		if scope.depth != "0" && fi.IsDir() {
+			if err := c.walkCollection(scope.virtualPath, scope.depth == "infinity", addResult); err != nil {
+				return writeStatus(w, getStatusForError(c, err)), err
+			}
+		}
+	}
+	search.sortResults(ctx, results)
+	ms := &multistatus{}
+	truncated := search.limit > 0 && len(results) > search.limit
+	if truncated {
+		results = results[:search.limit]
+	}
+	for _, fi := range results {
+		ms.Responses = append(ms.Responses, msResponse{
+			Href:      getHref(prefix, fi.virtualPath, fi.IsDir()),
+			Propstats: getPropstats(ctx, fi, search.props),
+		})
+	}
+	if truncated {
+		// RFC 5323, section 5.17: the result set was truncated
+		ms.Responses = append(ms.Responses, msResponse{
+			Href:   getHref(prefix, reqPath, true),
+			Status: getStatusLine(http.StatusInsufficientStorage),
+		})
+	}
+	c.Log(logger.LevelDebug, "search completed, scopes: %d, results: %d, truncated: %t", len(search.scopes),
+		len(results), truncated)
+	return webdav.StatusMulti, writeMultistatus(w, ms)
+}
@@ -38,6 +38,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/metric"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 type webDavServer struct {
@@ -142,7 +143,7 @@ func (s *webDavServer) checkRequestMethod(ctx context.Context, r *http.Request,
 			if r.Method == http.MethodHead {
 				return true
 			}
-			r.Method = "PROPFIND"
+			r.Method = methodPropfind
 			if r.Header.Get("Depth") == "" {
 				r.Header.Add("Depth", "1")
 			}
@@ -151,6 +152,35 @@ func (s *webDavServer) checkRequestMethod(ctx context.Context, r *http.Request,
 	return false
 }
 
+// isChunkedUploadRequest returns true if the request targets the Nextcloud
+// chunking v2 uploads namespace. OPTIONS requests are handled as usual
+func (s *webDavServer) isChunkedUploadRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return false
+	}
+	reqPath, ok := getRequestPath(r.URL.Path, s.binding.Prefix)
+	return ok && isChunkedUploadRequest(reqPath)
+}
+
+// handleExtendedMethod handles the WebDAV methods not supported by the webdav handler:
+// the RFC 6578 sync-collection REPORT and the RFC 5323 SEARCH. PROPFIND is handled
+// here too, the webdav handler does not return dead properties
+func (s *webDavServer) handleExtendedMethod(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	connection *Connection,
+) {
+	var status int
+	var err error
+	switch r.Method {
+	case methodReport:
+		status, err = handleReport(ctx, w, r, connection, s.binding.Prefix)
+	case methodPropfind:
+		status, err = handlePropfind(ctx, w, r, connection, s.binding.Prefix)
+	default:
+		status, err = handleSearch(ctx, w, r, connection, s.binding.Prefix)
+	}
+	writeLog(r, status, err)
+}
+
 // ServeHTTP implements the http.Handler interface
 func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
@@ -231,6 +261,7 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer common.Connections.Remove(connection.GetID())
+	lockSystem = getConnectionLockSystem(lockSystem, connection)
 
 	updateLoginMetrics(&user, ipAddr, loginMethod, err)
 
@@ -239,6 +270,17 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	dataprovider.UpdateLastLogin(&user)
 
+	if s.isChunkedUploadRequest(r) {
+		status, err := handleChunkedUpload(ctx, w, r.WithContext(ctx), connection, s.binding.Prefix)
+		writeLog(r, status, err)
+		return
+	}
+	if reqPath, ok := getRequestPath(r.URL.Path, s.binding.Prefix); ok && vfs.IsChunkedUploadsPath(reqPath) {
+		status := writeStatus(w, http.StatusNotFound)
+		writeLog(r, status, errors.New("direct access to the chunked uploads directory is not allowed"))
+		return
+	}
+
 	if s.checkRequestMethod(ctx, r, connection) {
 		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 		w.WriteHeader(http.StatusMultiStatus)
@@ -247,6 +289,14 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch r.Method {
+	case methodReport, methodSearch, methodPropfind:
+		s.handleExtendedMethod(ctx, w, r.WithContext(ctx), connection)
+		return
+	case http.MethodOptions:
+		w.Header().Set("DASL", daslBasicSearch)
+	}
+
 	handler := webdav.Handler{
 		Prefix:     s.binding.Prefix,
 		FileSystem: connection,
@@ -308,7 +358,7 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 		updateLoginMetrics(&user, ip, loginMethod, err)
 		return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
 	}
-	lockSystem := webdav.NewMemLS()
+	lockSystem := newLockSystem(user.Username)
 	cachedUser = &dataprovider.CachedUser{
 		User:       user,
 		Password:   password,
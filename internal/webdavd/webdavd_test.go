@@ -994,6 +994,466 @@ func TestPropPatch(t *testing.T) {
 		1*time.Second, 100*time.Millisecond)
 }
 
+func TestDeadProperties(t *testing.T) {
+	u := getTestUser()
+	u.Username = u.Username + "1"
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	sftpUser := getTestSFTPUser()
+	sftpUser.FsConfig.SFTPConfig.Username = localUser.Username
+	users := []dataprovider.User{getTestUser(), getTestUserWithCryptFs()}
+	switch dataprovider.GetProviderStatus().Driver {
+	case dataprovider.BoltDataProviderName, dataprovider.MemoryDataProviderName:
+	default:
+		// the dead properties are stored within the data provider
+		users = append(users, sftpUser)
+	}
+	setBody := `<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:sftpgo:test"><D:set><D:prop><Z:tag xml:lang="en">%s</Z:tag></D:prop></D:set></D:propertyupdate>`
+	removeBody := `<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:sftpgo:test"><D:remove><D:prop><Z:tag/></D:prop></D:remove></D:propertyupdate>`
+	propfindBody := `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+	depth0 := dataprovider.KeyValue{Key: "Depth", Value: "0"}
+
+	for _, u := range users {
+		user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+		assert.NoError(t, err)
+		client := getWebDavClient(user, false, nil)
+		assert.NoError(t, checkBasicFunc(client))
+		err = client.Write("file", []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+		err = client.Mkdir("dir", os.ModePerm)
+		assert.NoError(t, err)
+		// dead properties are disabled by default
+		status, body, err := sendDAVRequest(user, "PROPPATCH", "/file", fmt.Sprintf(setBody, "value1"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+		user.Filters.WebDAVDeadPropsMaxSize = 65536
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+
+		status, body, err = sendDAVRequest(user, "PROPPATCH", "/file", fmt.Sprintf(setBody, "value1"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "HTTP/1.1 200 OK")
+		status, body, err = sendDAVRequest(user, "PROPFIND", "/file", propfindBody, depth0)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "value1</tag>", user.Username)
+		assert.Contains(t, body, "urn:sftpgo:test")
+		// remove the property
+		status, body, err = sendDAVRequest(user, "PROPPATCH", "/file", removeBody)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "HTTP/1.1 200 OK")
+		_, body, err = sendDAVRequest(user, "PROPFIND", "/file", propfindBody, depth0)
+		assert.NoError(t, err)
+		assert.NotContains(t, body, "value1")
+		// the properties follow renames and copies
+		status, _, err = sendDAVRequest(user, "PROPPATCH", "/file", fmt.Sprintf(setBody, "value2"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		status, _, err = sendDAVRequest(user, "PROPPATCH", "/dir", fmt.Sprintf(setBody, "dirvalue"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		err = client.Rename("file", "dir/file1", false)
+		assert.NoError(t, err)
+		err = client.Rename("dir", "dir1", false)
+		assert.NoError(t, err)
+		err = client.Copy("dir1/file1", "file2", false)
+		assert.NoError(t, err)
+		for urlPath, value := range map[string]string{"/dir1/file1": "value2", "/file2": "value2", "/dir1": "dirvalue"} {
+			_, body, err = sendDAVRequest(user, "PROPFIND", urlPath, propfindBody, depth0)
+			assert.NoError(t, err)
+			assert.Contains(t, body, value+"</tag>", urlPath)
+		}
+		propBody := `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:sftpgo:test"><D:prop><Z:tag/><Z:missing/><D:getetag/></D:prop></D:propfind>`
+		status, body, err = sendDAVRequest(user, "PROPFIND", "/dir1", propBody)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "dirvalue</tag>")
+		assert.Contains(t, body, "value2</tag>")
+		assert.Contains(t, body, "HTTP/1.1 404 Not Found")
+		assert.Contains(t, body, "getetag>")
+		propnameBody := `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`
+		status, body, err = sendDAVRequest(user, "PROPFIND", "/dir1/file1", propnameBody)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, `<tag xmlns="urn:sftpgo:test"></tag>`)
+		assert.NotContains(t, body, "value2")
+		// the properties are removed with the file
+		err = client.Remove("file2")
+		assert.NoError(t, err)
+		err = client.Write("file2", []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+		_, body, err = sendDAVRequest(user, "PROPFIND", "/file2", propfindBody, depth0)
+		assert.NoError(t, err)
+		assert.NotContains(t, body, "value2")
+		// size limit
+		user.Filters.WebDAVDeadPropsMaxSize = 100
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+		status, body, err = sendDAVRequest(user, "PROPPATCH", "/file2", fmt.Sprintf(setBody, strings.Repeat("a", 100)))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "HTTP/1.1 507 Insufficient Storage")
+		// dead properties disabled
+		user.Filters.WebDAVDeadPropsMaxSize = -1
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+		status, body, err = sendDAVRequest(user, "PROPPATCH", "/file2", fmt.Sprintf(setBody, "value3"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+		_, body, err = sendDAVRequest(user, "PROPFIND", "/dir1", propfindBody, depth0)
+		assert.NoError(t, err)
+		assert.NotContains(t, body, "dirvalue")
+
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestChunkedUpload(t *testing.T) {
+	for _, u := range []dataprovider.User{getTestUser(), getTestUserWithCryptFs()} {
+		u.QuotaFiles = 100
+		user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+		assert.NoError(t, err)
+		client := getWebDavClient(user, false, nil)
+		assert.NoError(t, checkBasicFunc(client))
+		uploadPath := path.Join("/remote.php/dav/uploads", user.Username, "transfer-1")
+		destination := dataprovider.KeyValue{Key: "Destination", Value: fmt.Sprintf("http://%v/dir/file.dat", webDavServerAddr)}
+		err = client.Mkdir("dir", os.ModePerm)
+		assert.NoError(t, err)
+
+		status, _, err := sendDAVRequest(user, "MKCOL", uploadPath, "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, "MKCOL", uploadPath, "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		// the destination is required
+		status, _, err = sendDAVRequest(user, "MKCOL", path.Join("/remote.php/dav/uploads", user.Username, "transfer-2"), "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "00003"), "data")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		// the chunks are assembled in numeric order
+		chunks := map[string]string{"00002": "second", "00010": "third", "00001": "first"}
+		for name, data := range chunks {
+			status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, name), data, destination)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusCreated, status)
+		}
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "chunk"), "data", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, body, err := sendDAVRequest(user, "PROPFIND", uploadPath, "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMultiStatus, status)
+		for name := range chunks {
+			assert.Contains(t, body, path.Join(uploadPath, name))
+		}
+		// the staging directory is not visible
+		entries, err := client.ReadDir("/")
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, ".sftpgo-uploads", entry.Name())
+		}
+		_, err = client.Stat("/.sftpgo-uploads")
+		assert.Error(t, err)
+		// the total length must match
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "", destination,
+			dataprovider.KeyValue{Key: "OC-Total-Length", Value: "100"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "", destination,
+			dataprovider.KeyValue{Key: "OC-Total-Length", Value: "16"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		content, err := client.Read("/dir/file.dat")
+		assert.NoError(t, err)
+		assert.Equal(t, "firstsecondthird", string(content))
+		status, _, err = sendDAVRequest(user, "PROPFIND", uploadPath, "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		// overwrite an existing file
+		status, _, err = sendDAVRequest(user, "MKCOL", uploadPath, "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "1"), "new content", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "", destination,
+			dataprovider.KeyValue{Key: "Overwrite", Value: "F"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, status)
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status)
+		content, err = client.Read("/dir/file.dat")
+		assert.NoError(t, err)
+		assert.Equal(t, "new content", string(content))
+		// abort an upload
+		status, _, err = sendDAVRequest(user, "MKCOL", uploadPath, "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "1"), "data", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, http.MethodDelete, uploadPath, "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, status)
+		status, _, err = sendDAVRequest(user, http.MethodDelete, uploadPath, "")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "1"), "data", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, status)
+		// uploads for other users are not allowed
+		status, _, err = sendDAVRequest(user, "MKCOL", path.Join("/remote.php/dav/uploads", "other", "transfer"), "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, status)
+		// quota limits are checked before assembling the chunks
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		user.QuotaSize = user.UsedQuotaSize + 10
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+		status, _, err = sendDAVRequest(user, "MKCOL", uploadPath, "", destination,
+			dataprovider.KeyValue{Key: "OC-Total-Length", Value: "100"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInsufficientStorage, status)
+		status, _, err = sendDAVRequest(user, "MKCOL", uploadPath, "", destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "1"), strings.Repeat("a", 20), destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInsufficientStorage, status)
+		// the chunks already uploaded reduce the allowed size for the next ones
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "1"), strings.Repeat("a", 6), destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, status)
+		status, _, err = sendDAVRequest(user, http.MethodPut, path.Join(uploadPath, "2"), strings.Repeat("a", 6), destination)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInsufficientStorage, status)
+		// the chunks are not included in the quota
+		usedQuotaSize := user.UsedQuotaSize
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, usedQuotaSize, user.UsedQuotaSize)
+		user.QuotaSize = user.UsedQuotaSize + 4
+		user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+		assert.NoError(t, err)
+		status, _, err = sendDAVRequest(user, "MOVE", path.Join(uploadPath, ".file"), "",
+			dataprovider.KeyValue{Key: "Destination", Value: "/file.dat"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInsufficientStorage, status)
+		_, err = client.Stat("/file.dat")
+		assert.Error(t, err)
+
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+}
+
+func TestSyncCollectionReport(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client := getWebDavClient(user, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	err = client.Mkdir("dir", os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Write("dir/file1", []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Write("file2", []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+
+	reportBody := `<?xml version="1.0" encoding="utf-8" ?><D:sync-collection xmlns:D="DAV:"><D:sync-token>%s</D:sync-token><D:sync-level>%s</D:sync-level><D:prop><D:getetag/><D:resourcetype/></D:prop></D:sync-collection>`
+	status, body, err := sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, "", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/</D:href>")
+	assert.Contains(t, body, "<D:href>/file2</D:href>")
+	assert.NotContains(t, body, "/dir/file1")
+	assert.Contains(t, body, "<D:collection/>")
+	info, err := os.Stat(filepath.Join(user.GetHomeDir(), "file2"))
+	if assert.NoError(t, err) {
+		etag := fmt.Sprintf(`&#34;%x%x&#34;`, info.ModTime().UnixNano(), info.Size())
+		assert.Contains(t, body, fmt.Sprintf("<D:getetag>%s</D:getetag>", etag))
+	}
+	token1 := getSyncToken(body)
+	assert.NotEmpty(t, token1)
+	status, body, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, "", "infinite"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/file1</D:href>")
+	token2 := getSyncToken(body)
+	assert.Equal(t, token1, token2)
+	// no changes
+	status, body, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, token1, "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.NotContains(t, body, "<D:response>")
+	assert.Equal(t, token1, getSyncToken(body))
+
+	err = client.Write("dir/file3", []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Remove("file2")
+	assert.NoError(t, err)
+	err = client.Rename("dir", "dir1", false)
+	assert.NoError(t, err)
+	status, body, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, token1, "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir</D:href><D:status>HTTP/1.1 404 Not Found</D:status>")
+	assert.Contains(t, body, "<D:href>/file2</D:href><D:status>HTTP/1.1 404 Not Found</D:status>")
+	assert.Contains(t, body, "<D:href>/dir1/</D:href>")
+	assert.NotContains(t, body, "/dir1/file")
+	token3 := getSyncToken(body)
+	assert.NotEqual(t, token1, token3)
+	status, body, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, token1, "infinite"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/file3</D:href><D:status>HTTP/1.1 404 Not Found</D:status>")
+	assert.Contains(t, body, "<D:href>/dir1/file1</D:href>")
+	assert.Contains(t, body, "<D:href>/dir1/file3</D:href>")
+	// report on a sub collection
+	err = client.Write("dir1/file4", []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	status, body, err = sendDAVRequest(user, "REPORT", "/dir1", fmt.Sprintf(reportBody, token3, "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir1/file4</D:href>")
+	assert.NotContains(t, body, "<D:href>/dir1/</D:href>")
+	// invalid requests
+	status, body, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, "urn:sftpgo:sync:invalid:1", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Contains(t, body, "<D:valid-sync-token/>")
+	status, _, err = sendDAVRequest(user, "REPORT", "/", fmt.Sprintf(reportBody, "", "2"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _, err = sendDAVRequest(user, "REPORT", "/", "invalid body")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, body, err = sendDAVRequest(user, "REPORT", "/",
+		`<?xml version="1.0" encoding="utf-8" ?><D:expand-property xmlns:D="DAV:"/>`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Contains(t, body, "<D:supported-report/>")
+	status, body, err = sendDAVRequest(user, "REPORT", "/dir1/file1", fmt.Sprintf(reportBody, "", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Contains(t, body, "<D:supported-report/>")
+	status, _, err = sendDAVRequest(user, "REPORT", "/missing", fmt.Sprintf(reportBody, "", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestSearch(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client := getWebDavClient(user, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	err = client.Mkdir("dir", os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Write("dir/file1.txt", make([]byte, 100), os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Write("dir/file2.dat", make([]byte, 200), os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Write("file3.TXT", make([]byte, 300), os.ModePerm)
+	assert.NoError(t, err)
+
+	searchBody := `<?xml version="1.0" encoding="utf-8" ?><D:searchrequest xmlns:D="DAV:"><D:basicsearch><D:select><D:prop><D:displayname/><D:getcontentlength/></D:prop></D:select><D:from><D:scope><D:href>%s</D:href><D:depth>%s</D:depth></D:scope></D:from>%s</D:basicsearch></D:searchrequest>`
+	status, body, err := sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/", "infinity",
+		`<D:where><D:like><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like></D:where>`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/file1.txt</D:href>")
+	assert.Contains(t, body, "<D:href>/file3.TXT</D:href>")
+	assert.NotContains(t, body, "file2.dat")
+	assert.Contains(t, body, "<D:getcontentlength>100</D:getcontentlength>")
+	// case sensitive search
+	status, body, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/", "infinity",
+		`<D:where><D:like caseless="no"><D:prop><D:displayname/></D:prop><D:literal>%.txt</D:literal></D:like></D:where>`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/file1.txt</D:href>")
+	assert.NotContains(t, body, "file3.TXT")
+	// depth 1 and relative scope
+	status, body, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "dir", "1",
+		`<D:where><D:and><D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>150</D:literal></D:gt><D:not><D:is-collection/></D:not></D:and></D:where>`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/file2.dat</D:href>")
+	assert.NotContains(t, body, "file1.txt")
+	assert.NotContains(t, body, "file3.TXT")
+	// order and limit
+	status, body, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/", "infinity",
+		`<D:where><D:not><D:is-collection/></D:not></D:where><D:orderby><D:order><D:prop><D:getcontentlength/></D:prop><D:descending/></D:order></D:orderby><D:limit><D:nresults>2</D:nresults></D:limit>`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	idx3 := strings.Index(body, "<D:href>/file3.TXT</D:href>")
+	idx2 := strings.Index(body, "<D:href>/dir/file2.dat</D:href>")
+	assert.Greater(t, idx3, 0)
+	assert.Greater(t, idx2, idx3)
+	assert.NotContains(t, body, "file1.txt")
+	assert.Contains(t, body, "HTTP/1.1 507 Insufficient Storage")
+	// depth 0
+	status, body, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/dir", "0", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, body, "<D:href>/dir/</D:href>")
+	assert.NotContains(t, body, "file")
+	// unsupported grammar
+	status, body, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/", "infinity",
+		`<D:where><D:contains>data</D:contains></D:where>`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, body, "<D:search-grammar-supported/>")
+	status, _, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/", "2", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _, err = sendDAVRequest(user, "SEARCH", "/", fmt.Sprintf(searchBody, "/missing", "1", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+	// the search grammar is advertised
+	req, err := http.NewRequest(http.MethodOptions, fmt.Sprintf("http://%v/", webDavServerAddr), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(user.Username, defaultPassword)
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "<DAV:basicsearch>", resp.Header.Get("DASL"))
+		err = resp.Body.Close()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestLoginInvalidPwd(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -3038,6 +3498,36 @@ func checkBasicFunc(client *gowebdav.Client) error {
 	return err
 }
 
+func sendDAVRequest(user dataprovider.User, method, urlPath, body string, headers ...dataprovider.KeyValue,
+) (int, string, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%v%v", webDavServerAddr, urlPath),
+		bytes.NewReader([]byte(body)))
+	if err != nil {
+		return 0, "", err
+	}
+	req.SetBasicAuth(user.Username, defaultPassword)
+	for _, kv := range headers {
+		req.Header.Set(kv.Key, kv.Value)
+	}
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data), err
+}
+
+func getSyncToken(body string) string {
+	_, after, ok := strings.Cut(body, "<D:sync-token>")
+	if !ok {
+		return ""
+	}
+	token, _, _ := strings.Cut(after, "</D:sync-token>")
+	return token
+}
+
 func checkFileSize(remoteDestPath string, expectedSize int64, client *gowebdav.Client) error {
 	info, err := client.Stat(remoteDestPath)
 	if err != nil {
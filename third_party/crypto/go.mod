@@ -0,0 +1,15 @@
+module golang.org/x/crypto
+
+go 1.17
+
+require (
+	github.com/minio/sha256-simd v1.0.0
+	golang.org/x/net v0.2.0
+	golang.org/x/sys v0.2.0
+	golang.org/x/term v0.2.0
+)
+
+require (
+	github.com/klauspost/cpuid/v2 v2.2.1 // indirect
+	golang.org/x/text v0.4.0 // indirect
+)
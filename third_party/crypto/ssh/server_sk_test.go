@@ -0,0 +1,184 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testSKSigner emulates a security key, the signatures have the configured flags
+type testSKSigner struct {
+	key   ed25519.PrivateKey
+	pub   *skEd25519PublicKey
+	flags byte
+}
+
+func newTestSKSigner(t *testing.T, flags byte) *testSKSigner {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate ed25519 key: %v", err)
+	}
+	return &testSKSigner{
+		key: priv,
+		pub: &skEd25519PublicKey{
+			application: "ssh:",
+			PublicKey:   pub,
+		},
+		flags: flags,
+	}
+}
+
+func (s *testSKSigner) PublicKey() PublicKey {
+	return s.pub
+}
+
+func (s *testSKSigner) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	appDigest := sha256.Sum256([]byte(s.pub.application))
+	dataDigest := sha256.Sum256(data)
+	blob := struct {
+		ApplicationDigest []byte `ssh:"rest"`
+		Flags             byte
+		Counter           uint32
+		MessageDigest     []byte `ssh:"rest"`
+	}{
+		appDigest[:],
+		s.flags,
+		1,
+		dataDigest[:],
+	}
+	edSig := struct {
+		Signature []byte `ssh:"rest"`
+	}{
+		ed25519.Sign(s.key, Marshal(blob)),
+	}
+	return &Signature{
+		Format: KeyAlgoSKED25519,
+		Blob:   Marshal(edSig),
+		Rest:   Marshal(skFields{Flags: s.flags, Counter: 1}),
+	}, nil
+}
+
+func TestParseSKSignatureFlags(t *testing.T) {
+	signer := newTestSKSigner(t, SKFlagUserPresent|SKFlagUserVerified)
+	sig, err := signer.Sign(rand.Reader, []byte("data"))
+	if err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+	if err := signer.PublicKey().Verify([]byte("data"), sig); err != nil {
+		t.Fatalf("unable to verify the signature: %v", err)
+	}
+	flags, err := ParseSKSignatureFlags(sig)
+	if err != nil {
+		t.Fatalf("unable to parse the signature flags: %v", err)
+	}
+	if flags != SKFlagUserPresent|SKFlagUserVerified {
+		t.Fatalf("unexpected flags %#x", flags)
+	}
+	if _, err := ParseSKSignatureFlags(&Signature{Format: KeyAlgoSKED25519}); err == nil {
+		t.Fatal("parsing a signature without the security key fields must fail")
+	}
+	sig, err = testSigners["ed25519"].Sign(rand.Reader, []byte("data"))
+	if err != nil {
+		t.Fatalf("unable to sign: %v", err)
+	}
+	if _, err := ParseSKSignatureFlags(sig); err == nil {
+		t.Fatal("parsing the flags for a non security key signature must fail")
+	}
+}
+
+func TestPublicKeySignatureCallback(t *testing.T) {
+	errNoUserVerification := errors.New("user verification required")
+
+	for _, tc := range []struct {
+		flags     byte
+		partial   bool
+		expectErr bool
+	}{
+		{flags: SKFlagUserPresent | SKFlagUserVerified},
+		{flags: SKFlagUserPresent, expectErr: true},
+		{flags: SKFlagUserPresent | SKFlagUserVerified, partial: true},
+		{flags: SKFlagUserPresent, partial: true, expectErr: true},
+	} {
+		signer := newTestSKSigner(t, tc.flags)
+		var serverAuthErrors []error
+		var callbacks int
+
+		serverConfig := &ServerConfig{
+			PublicKeyCallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
+				if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+					return nil, errors.New("unexpected key")
+				}
+				perms := &Permissions{
+					Extensions: map[string]string{"verify-required": ""},
+				}
+				if tc.partial && len(conn.PartialSuccessMethods()) == 0 {
+					return perms, ErrPartialSuccess
+				}
+				return perms, nil
+			},
+			PasswordCallback: func(conn ConnMetadata, pass []byte) (*Permissions, error) {
+				if len(conn.PartialSuccessMethods()) == 1 && string(pass) == clientPassword {
+					return nil, nil
+				}
+				return nil, errors.New("password auth failed")
+			},
+			NextAuthMethodsCallback: func(conn ConnMetadata) []string {
+				if len(conn.PartialSuccessMethods()) == 1 {
+					return []string{"password"}
+				}
+				return []string{"publickey", "password"}
+			},
+			PublicKeySignatureCallback: func(conn ConnMetadata, key PublicKey, perms *Permissions, sig *Signature) error {
+				callbacks++
+				if _, ok := perms.Extensions["verify-required"]; !ok {
+					return errors.New("unexpected permissions")
+				}
+				flags, err := ParseSKSignatureFlags(sig)
+				if err != nil {
+					return err
+				}
+				if flags&SKFlagUserVerified == 0 {
+					return errNoUserVerification
+				}
+				return nil
+			},
+		}
+		clientConfig := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				PublicKeys(signer),
+				Password(clientPassword),
+			},
+			HostKeyCallback: InsecureIgnoreHostKey(),
+		}
+
+		err := doClientServerAuth(t, serverConfig, clientConfig, &serverAuthErrors)
+		if tc.expectErr {
+			if err == nil {
+				t.Fatalf("flags %#x, partial %v: client login must fail", tc.flags, tc.partial)
+			}
+			found := false
+			for _, authErr := range serverAuthErrors {
+				if errors.Is(authErr, errNoUserVerification) {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("flags %#x, partial %v: unexpected server errors: %v", tc.flags, tc.partial, serverAuthErrors)
+			}
+		} else if err != nil {
+			t.Fatalf("flags %#x, partial %v: client login error: %v", tc.flags, tc.partial, err)
+		}
+		if callbacks != 1 {
+			t.Fatalf("flags %#x, partial %v: unexpected signature callbacks %d", tc.flags, tc.partial, callbacks)
+		}
+	}
+}
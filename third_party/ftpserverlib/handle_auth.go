@@ -0,0 +1,74 @@
+package ftpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// Handle the "USER" command
+func (c *clientHandler) handleUSER(param string) error {
+	if verifier, ok := c.server.driver.(MainDriverExtensionUserVerifier); ok {
+		err := verifier.PreAuthUser(c, param)
+
+		if err != nil {
+			c.writeMessage(StatusNotLoggedIn, fmt.Sprintf("User rejected: %v", err))
+			c.disconnect()
+
+			return nil
+		}
+	}
+
+	if c.isTLSRequired() && !c.HasTLSForControl() {
+		c.writeMessage(StatusServiceNotAvailable, "TLS is required")
+		c.disconnect()
+
+		return nil
+	}
+
+	if c.HasTLSForControl() {
+		if verifier, ok := c.server.driver.(MainDriverExtensionTLSVerifier); ok {
+			if tlsConn, ok := c.conn.(*tls.Conn); ok {
+				driver, err := verifier.VerifyConnection(c, param, tlsConn)
+
+				if err != nil {
+					c.writeMessage(StatusNotLoggedIn, fmt.Sprintf("TLS verification failed: %v", err))
+					c.disconnect()
+
+					return nil
+				}
+
+				if driver != nil {
+					c.user = param
+					c.driver = driver
+					c.writeMessage(StatusUserLoggedIn, "TLS certificate ok, continue")
+
+					return nil
+				}
+			}
+		}
+	}
+
+	c.user = param
+	c.writeMessage(StatusUserOK, "OK")
+
+	return nil
+}
+
+// Handle the "PASS" command
+func (c *clientHandler) handlePASS(param string) error {
+	var err error
+	c.driver, err = c.server.driver.AuthUser(c, c.user, param)
+
+	switch {
+	case err == nil:
+		c.writeMessage(StatusUserLoggedIn, "Password ok, continue")
+	case err != nil:
+		c.writeMessage(StatusNotLoggedIn, fmt.Sprintf("Authentication problem: %v", err))
+		c.disconnect()
+	default:
+		c.writeMessage(StatusNotLoggedIn, "I can't deal with you (nil driver)")
+		c.disconnect()
+	}
+
+	return nil
+}